@@ -52,6 +52,14 @@ func (connType ConnType) String() string {
 
 type NewConnFunc func() (mcc.ClientIface, error)
 
+// credentials used to authenticate the connections to a target
+type ConnCredentials struct {
+	UserName          string
+	Password          string
+	ClientCertificate []byte
+	ClientKey         []byte
+}
+
 type ConnPool interface {
 	Get() (mcc.ClientIface, error)
 	GetNew(sanInCertificate bool) (mcc.ClientIface, error)
@@ -65,6 +73,11 @@ type ConnPool interface {
 	ConnType() ConnType
 	Hostname() string
 	Password() string
+	// returns true if the password is the one of either the primary or the secondary credentials
+	HasPassword(password string) bool
+	// new connections are authenticated with the primary credentials, and with the secondary credentials
+	// when the primary ones fail, e.g. while the credentials on the target are being rotated
+	SetCredentials(primary, secondary *ConnCredentials)
 	Close()
 	Stale() bool
 	SetStale(stale bool)
@@ -91,6 +104,8 @@ type connPool struct {
 	plainAuth   bool
 	stale       bool
 	state_lock  *sync.RWMutex
+	// credentials to fall back on when the primary ones are rejected by target
+	secondary *ConnCredentials
 }
 
 type sslOverMemConnPool struct {
@@ -163,9 +178,31 @@ func (p *connPool) Hostname() string {
 }
 
 func (p *connPool) Password() string {
+	p.state_lock.RLock()
+	defer p.state_lock.RUnlock()
 	return p.password
 }
 
+func (p *connPool) HasPassword(password string) bool {
+	p.state_lock.RLock()
+	defer p.state_lock.RUnlock()
+	return p.password == password || (p.secondary != nil && p.secondary.Password == password)
+}
+
+func (p *connPool) SetCredentials(primary, secondary *ConnCredentials) {
+	p.state_lock.Lock()
+	defer p.state_lock.Unlock()
+	p.userName = primary.UserName
+	p.password = primary.Password
+	p.secondary = secondary
+}
+
+func (p *connPool) credentials() (primary, secondary *ConnCredentials) {
+	p.state_lock.RLock()
+	defer p.state_lock.RUnlock()
+	return &ConnCredentials{UserName: p.userName, Password: p.password}, p.secondary
+}
+
 func (p *connPool) Size() int {
 	p.lock.RLock()
 	defer p.lock.RUnlock()
@@ -205,7 +242,13 @@ func (p *connPool) GetNew(sanInCertificate bool) (mcc.ClientIface, error) {
 }
 
 func (p *connPool) newConn() (mcc.ClientIface, error) {
-	return NewConn(p.hostName, p.userName, p.password, p.bucketName, p.plainAuth, KeepAlivePeriod, p.logger)
+	primary, secondary := p.credentials()
+	client, err := NewConn(p.hostName, primary.UserName, primary.Password, p.bucketName, p.plainAuth, KeepAlivePeriod, p.logger)
+	if err != nil && secondary != nil {
+		p.logger.Warnf("Failed to set up connection for pool %v with the primary credentials, retrying with the secondary credentials. err=%v", p.name, err)
+		client, err = NewConn(p.hostName, secondary.UserName, secondary.Password, p.bucketName, p.plainAuth, KeepAlivePeriod, p.logger)
+	}
+	return client, err
 }
 func (p *connPool) NewConnFunc() NewConnFunc {
 	return p.newConnFunc
@@ -267,9 +310,31 @@ func (p *sslOverMemConnPool) Certificate() []byte {
 	return p.certificate
 }
 
+func (p *sslOverMemConnPool) SetCredentials(primary, secondary *ConnCredentials) {
+	p.state_lock.Lock()
+	defer p.state_lock.Unlock()
+	p.userName = primary.UserName
+	p.password = primary.Password
+	p.clientCertificate = primary.ClientCertificate
+	p.clientKey = primary.ClientKey
+	p.secondary = secondary
+}
+
+func (p *sslOverMemConnPool) credentials() (primary, secondary *ConnCredentials) {
+	p.state_lock.RLock()
+	defer p.state_lock.RUnlock()
+	return &ConnCredentials{UserName: p.userName, Password: p.password, ClientCertificate: p.clientCertificate, ClientKey: p.clientKey}, p.secondary
+}
+
 func (p *sslOverMemConnPool) GetNew(sanInCertificate bool) (mcc.ClientIface, error) {
 	ssl_con_str := GetHostAddr(p.hostName, uint16(p.remote_memcached_port))
-	return NewTLSConn(ssl_con_str, p.userName, p.password, p.certificate, sanInCertificate, p.clientCertificate, p.clientKey, p.bucketName, p.logger)
+	primary, secondary := p.credentials()
+	client, err := NewTLSConn(ssl_con_str, primary.UserName, primary.Password, p.certificate, sanInCertificate, primary.ClientCertificate, primary.ClientKey, p.bucketName, p.logger)
+	if err != nil && secondary != nil {
+		p.logger.Warnf("Failed to set up connection for pool %v with the primary credentials, retrying with the secondary credentials. err=%v", p.name, err)
+		client, err = NewTLSConn(ssl_con_str, secondary.UserName, secondary.Password, p.certificate, sanInCertificate, secondary.ClientCertificate, secondary.ClientKey, p.bucketName, p.logger)
+	}
+	return client, err
 }
 
 func (p *sslOverMemConnPool) ConnType() ConnType {
@@ -378,7 +443,7 @@ func (connPoolMgr *connPoolMgr) GetOrCreatePool(poolNameToCreate string, hostnam
 	if ok {
 		_, ok = pool.(*connPool)
 		if ok {
-			if !pool.Stale() && pool.Password() == password {
+			if !pool.Stale() && pool.HasPassword(password) {
 				return pool, nil
 			} else {
 				ConnPoolMgr().logger.Infof("Removing pool %v. stale=%v, new size=%v, old size=%v", poolNameToCreate, pool.Stale(), connsize, pool.MaxConn())
@@ -421,7 +486,7 @@ func (connPoolMgr *connPoolMgr) GetOrCreateSSLOverMemPool(poolNameToCreate strin
 	if ok {
 		_, ok = pool.(*sslOverMemConnPool)
 		if ok {
-			if !pool.Stale() && pool.Password() == password {
+			if !pool.Stale() && pool.HasPassword(password) {
 				return pool, nil
 			} else {
 				ConnPoolMgr().logger.Infof("Removing pool %v. stale=%v, new size=%v, old size=%v", poolNameToCreate, pool.Stale(), connsize, pool.MaxConn())
@@ -500,6 +565,19 @@ func (connPoolMgr *connPoolMgr) SetStaleForPoolsWithNamePrefix(poolNamePrefix st
 	}
 }
 
+// Unlike SetStaleForPoolsWithNamePrefix, the pools and the connections in them are kept. Only the connections
+// set up from now on use the new credentials
+func (connPoolMgr *connPoolMgr) SetCredentialsForPoolsWithNamePrefix(poolNamePrefix string, primary, secondary *ConnCredentials) {
+	connPoolMgr.map_lock.RLock()
+	defer connPoolMgr.map_lock.RUnlock()
+	for poolName, pool := range connPoolMgr.conn_pools_map {
+		if strings.HasPrefix(poolName, poolNamePrefix) {
+			pool.SetCredentials(primary, secondary)
+			connPoolMgr.logger.Infof("Updated credentials of pool %v. hasSecondary=%v", pool.Name(), secondary != nil)
+		}
+	}
+}
+
 func (connPoolMgr *connPoolMgr) RemovePool(poolName string) {
 	connPoolMgr.map_lock.Lock()
 	defer connPoolMgr.map_lock.Unlock()
@@ -0,0 +1,55 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software
+will be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package base
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnPoolCredentialRotation(t *testing.T) {
+	fmt.Println("============== Test case start: TestConnPoolCredentialRotation =================")
+	defer fmt.Println("============== Test case end: TestConnPoolCredentialRotation =================")
+	assert := assert.New(t)
+
+	poolName := "credRotationSpec/src/tgt_xmem"
+	defer ConnPoolMgr().RemovePool(poolName)
+	pool, err := ConnPoolMgr().GetOrCreatePool(poolName, "127.0.0.1:11210", "tgt", "oldUser", "oldPassword", 1, true /*plainAuth*/)
+	assert.Nil(err)
+	assert.True(pool.HasPassword("oldPassword"))
+	assert.False(pool.HasPassword("newPassword"))
+
+	// staging the new credentials keeps the pool, which accepts both passwords from then on
+	secondary := &ConnCredentials{UserName: "newUser", Password: "newPassword"}
+	ConnPoolMgr().SetCredentialsForPoolsWithNamePrefix("credRotationSpec/", &ConnCredentials{UserName: "oldUser", Password: "oldPassword"}, secondary)
+	assert.True(pool.HasPassword("newPassword"))
+	samePool, err := ConnPoolMgr().GetOrCreatePool(poolName, "127.0.0.1:11210", "tgt", "newUser", "newPassword", 1, true /*plainAuth*/)
+	assert.Nil(err)
+	assert.True(pool == samePool)
+
+	// once the old credentials are retired, the pool no longer accepts the old password
+	ConnPoolMgr().SetCredentialsForPoolsWithNamePrefix("credRotationSpec/", secondary, nil)
+	assert.Equal("newPassword", pool.Password())
+	assert.False(pool.HasPassword("oldPassword"))
+	primary, fallback := pool.(*connPool).credentials()
+	assert.Equal("newUser", primary.UserName)
+	assert.Nil(fallback)
+
+	// a pool of another replication is left alone
+	otherPoolName := "otherSpec/src/tgt_xmem"
+	defer ConnPoolMgr().RemovePool(otherPoolName)
+	otherPool, err := ConnPoolMgr().GetOrCreatePool(otherPoolName, "127.0.0.1:11210", "tgt", "oldUser", "oldPassword", 1, true /*plainAuth*/)
+	assert.Nil(err)
+	ConnPoolMgr().SetCredentialsForPoolsWithNamePrefix("credRotationSpec/", secondary, nil)
+	assert.Equal("oldPassword", otherPool.Password())
+}
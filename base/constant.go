@@ -179,8 +179,9 @@ var AdminportWriteTimeout = 180 * time.Second
 type XDCROutgoingNozzleType int
 
 const (
-	Xmem XDCROutgoingNozzleType = iota
-	Capi XDCROutgoingNozzleType = iota
+	Xmem       XDCROutgoingNozzleType = iota
+	Capi       XDCROutgoingNozzleType = iota
+	FileExport XDCROutgoingNozzleType = iota
 )
 
 // Last element is invalid and is there to keep consistency with the EndMarker
@@ -226,6 +227,10 @@ const (
 	BANDWIDTH_THROTTLER_SVC    string = "BandwidthThrottlerSvc"
 	BACKFILL_MGR_SVC           string = "BackfillMgrSvc"
 	CONFLICT_MANAGER_SVC       string = "ConflictManager"
+	CONFLICT_LOGGER_SVC        string = "ConflictLogger"
+	CAUGHT_UP_MONITOR_SVC      string = "CaughtUpMonitor"
+	DEAD_LETTER_QUEUE_SVC      string = "DeadLetterQueue"
+	BULK_DELETE_BREAKER_SVC    string = "BulkDeleteBreaker"
 )
 
 // supervisor related constants
@@ -243,6 +248,7 @@ const (
 	GlobalSettingChangeListener    = "GlobalSettingChangeListener"
 	BucketSettingsChangeListener   = "BucketSettingsChangeListener"
 	InternalSettingsChangeListener = "InternalSettingsChangeListener"
+	MergeFunctionsChangeListener   = "MergeFunctionsChangeListener"
 )
 
 // constants for integer parsing
@@ -329,6 +335,20 @@ var ErrorNilCertificateStrictMode = errors.New("cluster encryption is set to str
 var ErrorOpInterrupted = errors.New("Operation interrupted")
 var ErrorNoVbSpecified = errors.New("No vb being specified")
 
+// Reported by a stop when caught up replication once all of its VBs have replicated up to their end seqnos
+var ErrorReplicationCaughtUp = errors.New("Replication has caught up with the source high seqnos")
+
+// Raised by a pipeline when it is detached from the shared source stream it reads from
+var ErrorSharedDcpFeedDetached = errors.New("Detached from shared source stream")
+
+// Documents that cannot be transformed by the transformation rules of a replication are not replicated
+var ErrorTransformationFailed = errors.New("Unable to apply transformation rules to document")
+var ErrorKeyRewriteFailed = errors.New("Unable to apply target key rewriting rules to document key")
+var ErrorDeadLetterQueueFull = errors.New("Dead letter queue is full")
+
+// Reported by the bulk delete breaker so that the replication is paused instead of being repaired
+var ErrorBulkDeletesDetected = errors.New("Bulk deletion detected on the source")
+
 func GetBackfillFatalDataLossError(specId string) error {
 	return fmt.Errorf("%v experienced fatal error when trying to create backfill request. To prevent data loss, the pipeline must restream from the beginning", specId)
 }
@@ -372,6 +392,10 @@ const (
 	ConnectivityStatus             = "connectivityStatus"
 	RemoteBucketManifest           = "remoteBucketManifest"
 	RedactRequested                = "redactRequested"
+
+	// staged while the credentials of a remote cluster reference are being rotated
+	RemoteClusterSecondaryUserName          = "secondaryUsername"
+	RemoteClusterSecondaryClientCertificate = "secondaryClientCertificate"
 )
 
 // secure type for remote cluster reference
@@ -383,12 +407,22 @@ const (
 
 // constants used for create replication request
 const (
-	Type       = "type"
-	FromBucket = "fromBucket"
-	ToCluster  = "toCluster"
-	ToBucket   = "toBucket"
+	Type            = "type"
+	FromBucket      = "fromBucket"
+	ToCluster       = "toCluster"
+	ToBucket        = "toBucket"
+	ReplicationName = "replicationName"
 )
 
+// A named replication has its name appended to the target bucket part of its replication ID, i.e.
+// <targetClusterUUID>/<sourceBucket>/<targetBucket>:<replicationName>
+// The name is not a separate path part so that the metakv catalogs keyed by the replication ID of a
+// named replication are never nested under the ones of the unnamed replication between the same buckets
+const ReplicationNameDelimiter = ":"
+
+// Replication names may only contain letters, digits, '.', '_' and '-'
+const ReplicationNameMaxLength = 100
+
 const RemoteClusterAuthErrString = "Authentication failed"
 
 // constant used by more than one rest apis
@@ -470,6 +504,7 @@ const (
 
 	ReplicationDocTypeXmem = "xdc-xmem"
 	ReplicationDocTypeCapi = "xdc"
+	ReplicationDocTypeFile = "xdc-file"
 )
 
 // constant used in replication info to ensure compatibility with erlang xdcr
@@ -519,12 +554,15 @@ const (
 )
 
 const (
-	OutNozzleStatsCollector  = "OutNozzleStatsCollector"
-	DcpStatsCollector        = "DcpStatsCollector"
-	RouterStatsCollector     = "RouterStatsCollector"
-	CheckpointStatsCollector = "CheckpointStatsCollector"
-	ThroughSeqnoTracker      = "ThroughSeqnoTracker"
-	ConflictMgrCollector     = "ConflictManagerCollector"
+	OutNozzleStatsCollector   = "OutNozzleStatsCollector"
+	DcpStatsCollector         = "DcpStatsCollector"
+	RouterStatsCollector      = "RouterStatsCollector"
+	CheckpointStatsCollector  = "CheckpointStatsCollector"
+	ThroughSeqnoTracker       = "ThroughSeqnoTracker"
+	ConflictMgrCollector      = "ConflictManagerCollector"
+	ConflictLoggerCollector   = "ConflictLoggerCollector"
+	DeadLetterQueueCollector  = "DeadLetterQueueCollector"
+	CollectionsStatsCollector = "CollectionsStatsCollector"
 )
 
 var CouchbaseBucketType = "membase"
@@ -666,6 +704,15 @@ var TimeoutDcpCloseUprStreams = 3 * time.Second
 var TimeoutDcpCloseUprFeed = 3 * time.Second
 var TimeoutP2PProtocol = 60 * time.Second // Default if not specified
 
+// Max number of source/target collection pairs that a replication keeps per collection stats for
+// Collections beyond it are accounted for under CollectionStatsOverflowKey. 0 disables per collection stats
+var CollectionStatsMaxEntries = 1000
+
+const CollectionStatsOverflowKey = "_overflow"
+
+// Delimits the source and target namespace in the key of per collection stats
+const CollectionStatsKeyDelimiter = ":"
+
 // This is for enforcing remote connection network type.
 const TCP = "tcp"   // ipv4/ipv6 are both supported
 const TCP4 = "tcp4" // ipv4 only
@@ -1137,7 +1184,9 @@ func InitConstants(topologyChangeCheckInterval time.Duration, maxTopologyChangeC
 	maxP2PReceiveChLen int,
 	p2pOpaqueCleanupInterval, p2pVBRelatedGCInterval,
 	throughSeqnoBgScannerFreq, throughSeqnoBgScannerLogFreq,
-	timeoutP2PProtocol time.Duration) {
+	timeoutP2PProtocol time.Duration,
+	collectionStatsMaxEntries int,
+	fileExportRootDir string) {
 	TopologyChangeCheckInterval = topologyChangeCheckInterval
 	MaxTopologyChangeCountBeforeRestart = maxTopologyChangeCountBeforeRestart
 	MaxTopologyStableCountBeforeRestart = maxTopologyStableCountBeforeRestart
@@ -1280,6 +1329,8 @@ func InitConstants(topologyChangeCheckInterval time.Duration, maxTopologyChangeC
 	TimeoutPartsStart = timeoutPartsStart
 	TimeoutPartsStop = timeoutPartsStop
 	TimeoutP2PProtocol = timeoutP2PProtocol
+	CollectionStatsMaxEntries = collectionStatsMaxEntries
+	FileExportRootDir = fileExportRootDir
 }
 
 // XDCR Dev hidden replication settings
@@ -1318,6 +1369,21 @@ const (
 	BucketDocXattrKey = "xattrs"
 )
 
+// UI+ns_server document listing, used when sampling documents for filter testing
+const (
+	BucketDocListRowsKey  = "rows"
+	BucketDocListIdKey    = "id"
+	BucketDocListDocKey   = "doc"
+	BucketDocListLimitKey = "limit"
+)
+
+// Number of source documents to run through a filter expression when sampling, and the max allowed
+const FilterSampleSizeDefault = 100
+const FilterSampleSizeMax = 1000
+
+// Max number of example keys returned per category when sampling a filter expression
+var FilterSampleMaxExampleKeys = 20
+
 const TransactionClientRecordKey = "_txn:client-record"
 const ActiveTransactionRecordPrefix = "^_txn:atr-"
 const ValidVbucketRangeRegexpGroup = "([0-9]|[1-9][0-9]|[1-9][0-9][0-9]|[1][0][0-2][0-3])"
@@ -1352,6 +1418,8 @@ const (
 	DefaultMergeFuncBodyCC = "function " + DefaultMergeFunc + "(key, sourceDoc, sourceCas, sourceId, targetDoc, targetCas, targetId) {" +
 		"if (sourceCas >= targetCas) {return sourceDoc; } else {return targetDoc; } } "
 	BucketMergeFunctionKey = "default"
+	// metakv catalog of the merge functions of the in-process merge engine, one entry per function
+	MergeFunctionsCatalogKey = "mergeFunctions"
 
 	CCRKVRestCallRetryInterval = 2 * time.Second
 )
@@ -1412,3 +1480,209 @@ const (
 	SourceNozzlePerNode = "sourceNozzlePerNode"
 	TargetNozzlePerNode = "targetNozzlePerNode"
 )
+
+// Conflict logging records source mutations that lost source side conflict resolution
+const (
+	ConflictLoggingKey     = "conflictLogging"
+	ConflictLoggingDestKey = "conflictLoggingDest"
+)
+
+// An empty conflict logging destination means a rotating file under the XDCR log directory.
+// Otherwise, the destination is a "scope.collection" in the source bucket
+const ConflictLoggingDestFile = ""
+
+// Per-pipeline conflict log files are named using the replication ID, which is sanitized first
+const ConflictLogFilePrefix = "xdcr_conflicts_"
+const ConflictLogFileSuffix = ".log"
+
+// Number of conflict records that can be queued per pipeline before new ones are dropped
+var ConflictLoggerQueueSize = 10000
+
+// File export replications write the routed mutations to local files instead of a target bucket
+const FileExportDirKey = "fileExportDir"
+
+// The export directories of file export replications must be under this directory. It is an internal setting, so that
+// only an administrator decides where on the node XDCR may write. File export is unavailable while it is empty
+var FileExportRootDir = ""
+
+// File export replications have no target cluster. Their specs carry this in place of the target cluster UUID,
+// and their target bucket name only names the archive
+const FileExportTargetClusterUUID = "fileExport"
+
+// Each out nozzle writes to its own sequence of files under <fileExportDir>/<sanitized replication ID>
+// The files are named <sanitized nozzle ID>_<nozzle start time in unix nano>_<sequence>.jsonl
+// and each line is a JSON encoded FileExportRecord
+const FileExportFileSuffix = ".jsonl"
+
+// Values for FileExportRecord.Op
+const (
+	FileExportOpMutation   = "mutation"
+	FileExportOpDeletion   = "deletion"
+	FileExportOpExpiration = "expiration"
+)
+
+// A file export file is closed and a new one is started once it grows beyond this size
+var FileExportMaxFileSize int64 = 256 * 1024 * 1024
+
+// Written records are flushed and synced to disk at least this often, or once batch count records are pending
+var FileExportFlushInterval = 1 * time.Second
+
+// A position in a file export archive is printed and parsed as <file name>:<number of records of the file before it>
+const FileExportPositionDelimiter = ":"
+
+// The archive replay tool waits for the target to acknowledge this many records before it moves its resume position forward
+var FileExportReplayChunkSize = 10000
+
+// A conflict resolution strategy overrides how source side conflict resolution decides between a source mutation
+// and the target document. It is in the form of <strategy name> or <strategy name>:<argument>.
+// Empty means the conflict resolution mode of the buckets
+const ConflictResolutionStrategyKey = "conflictResolutionStrategy"
+
+const ConflictResolutionStrategyDefault = ""
+const ConflictResolutionStrategyArgDelimiter = ":"
+
+// Names of the built-in conflict resolution strategies
+const (
+	ConflictResolutionStrategySourceWins       = "sourceAlwaysWins"
+	ConflictResolutionStrategyTargetWins       = "targetAlwaysWins"
+	ConflictResolutionStrategyHighestJsonField = "highestJsonFieldWins"
+)
+
+// A stop when caught up replication streams each VB up to the source high seqno at the time the pipeline starts,
+// and the replication is paused once every VB has been replicated up to that seqno
+const StopWhenCaughtUpKey = "stopWhenCaughtUp"
+
+// How often the caught up monitor checks whether all the VBs have caught up
+var CaughtUpMonitorInterval = 5 * time.Second
+
+// Main pipelines of replications that opt into a shared source stream read from one set of DCP streams per source
+// bucket. The first DCP nozzle to start its streams owns them and fans the events out to the other pipelines
+const SharedSourceStreamKey = "sharedSourceStream"
+
+// Number of DCP events buffered for each pipeline reading from a shared source stream that it does not own.
+// A pipeline that falls further behind than this is detached so that it does not hold up the others
+var SharedDcpFeedBufferSize = 10000
+
+// The other pipelines are detached from a shared source stream when its owner is blocked forwarding a single
+// mutation for longer than this
+var SharedDcpFeedOwnerStallTimeout = 30 * time.Second
+
+// How long a pipeline waits for the owner of a shared source stream to have all of its VB streams open
+var SharedDcpFeedJoinTimeout = 30 * time.Second
+
+// How long a pipeline that has been detached from a shared source stream streams on its own before reading
+// from a shared source stream again
+var SharedDcpFeedRejoinCooldown = 5 * time.Minute
+
+// Fields of the documents of a replication can be removed, masked, hashed, renamed or added before the documents are
+// sent to the target. The rules are a JSON object, see base/transform
+const TransformationRulesKey = "transformationRules"
+
+// Document keys can be rewritten on the way to the target, i.e. to namespace the keys of several source buckets that
+// replicate to the same target bucket. The prefix is removed first, then the regex substitution is applied, and the
+// prefix is added last. The rewritten keys are hashed to their own target VBs, so the checkpoints of such replications
+// are validated against the vbuuids of all of the target VBs
+const (
+	TargetKeyRemovePrefixKey     = "targetKeyRemovePrefix"
+	TargetKeyRegexKey            = "targetKeyRegex"
+	TargetKeyRegexReplacementKey = "targetKeyRegexReplacement"
+	TargetKeyAddPrefixKey        = "targetKeyAddPrefix"
+)
+
+// Longest document key that KV accepts
+const MaxDocKeyLength = 250
+
+// Documents that the target rejects for good, i.e. because they are too big or have invalid xattrs, are written to a
+// dead letter destination so that their VBs can move on. Without a destination, XMEM keeps repairing the connection
+// and resending them
+const (
+	DeadLetterDestKey        = "deadLetterDest"
+	DeadLetterIncludeBodyKey = "deadLetterIncludeBody"
+)
+
+// The dead letter destination is either disabled, an append-only file under the XDCR log directory, or a collection
+// in the form of <DeadLetterDestSource|DeadLetterDestTarget><scope>.<collection>
+const (
+	DeadLetterDestDisabled = ""
+	DeadLetterDestFile     = "file"
+	DeadLetterDestSource   = "source:"
+	DeadLetterDestTarget   = "target:"
+)
+
+// Per-pipeline dead letter files are named using the sanitized pipeline topic. Each line is a JSON encoded entry
+const DeadLetterFilePrefix = "xdcr_dead_letters_"
+const DeadLetterFileSuffix = ".jsonl"
+
+// Number of dead letter records that can be queued per pipeline. Unlike conflict records, dead letter records are
+// never dropped. XMEM restarts the pipeline instead so that the documents are sent again
+var DeadLetterQueueSize = 10000
+
+// The growth of a dead letter destination is reported in the UI log on the first record and every so many records
+var DeadLetterUILogInterval uint64 = 1000
+
+// Longest dead letter file line that is read when the entries are re-driven. Entries with binary bodies are base64 encoded
+var DeadLetterEntryMaxSize = 32 * 1024 * 1024
+
+// With an apply delay, mutations are held by XMEM until their source CAS is at least the given number of minutes old,
+// which keeps the target a lagged standby that data can be recovered from after a bad bulk change on the source.
+// 0 disables the delay
+const ApplyDelayKey = "applyDelayMin"
+
+// Longest apply delay that can be set, in minutes
+const ApplyDelayMax = 7 * 24 * 60
+
+// Held mutations are kept in memory up to DelayBufferMemLimit bytes per XMEM. Beyond that they are spilled to
+// segment files under the XDCR log directory, and XMEM stops accepting new mutations once DelayBufferDiskLimit bytes
+// have been spilled
+var DelayBufferMemLimit int64 = 64 * 1024 * 1024
+var DelayBufferDiskLimit int64 = 4 * 1024 * 1024 * 1024
+var DelayBufferSegmentSize int64 = 64 * 1024 * 1024
+
+// Spill segments are named using the sanitized XMEM id and a segment counter
+const DelayBufferFilePrefix = "xdcr_delay_buffer_"
+const DelayBufferFileSuffix = ".jsonl"
+
+// How often XMEM checks the held mutations for ones that are old enough to be sent
+var DelayBufferReleaseInterval = 1 * time.Second
+
+// The bulk delete breaker pauses a replication when the deletions and expirations of recent source mutations that
+// the routers let through exceed a rate, or a percentage of the mutations let through, over a window.
+// 0 disables the respective limit
+const (
+	BulkDeleteMaxRateKey    = "bulkDeleteMaxRate"
+	BulkDeleteMaxPercentKey = "bulkDeleteMaxPercent"
+	BulkDeleteWindowKey     = "bulkDeleteWindowSec"
+	// Set when the breaker has paused the replication. The replication cannot be resumed until the operator
+	// acknowledges by clearing it
+	BulkDeleteTrippedKey = "bulkDeleteTripped"
+	// When the operator last acknowledged, in seconds since the epoch. Deletions from before then are not counted again
+	BulkDeleteAckTimeKey = "bulkDeleteAckTime"
+)
+
+var BulkDeleteWindowDefault = 60
+
+const BulkDeleteWindowMax = 3600
+
+// The percentage limit only applies once there have been this many deletions in the window, so that a handful of
+// deletions on a quiet bucket does not pause the replication
+var BulkDeleteMinDeletesForPercent = 1000
+
+// How often the bulk delete breaker checks the deletions in the window
+var BulkDeleteBreakerCheckInterval = 1 * time.Second
+
+// A new replication can start from a point in time instead of from seqno 0, e.g. when the target has been restored
+// from a backup taken at that time. The start point is either a time in seconds since the epoch, or a JSON object of
+// VB number to the seqno that the VB starts streaming after. Only VBs that have no checkpoints start from it.
+// The start time relies on the CAS of the documents, which is not monotonic on buckets that are themselves the target
+// of XDCR, so documents replicated into such buckets after the start time can be missed
+const (
+	StartFromTimeKey   = "startFromTime"
+	StartFromSeqnosKey = "startFromSeqnos"
+)
+
+// How long the checkpoint manager scans the source VBs for the first seqno at or after the start time
+var StartFromTimeScanTimeout = 1 * time.Hour
+
+// The maximum number of settings changes that the settings history keeps for each replication, the default
+// replication settings, the global settings and the internal settings. The oldest changes are dropped first
+var MaxSettingsHistoryEntries = 100
@@ -64,6 +64,9 @@ func (pool *MCRequestPool) cleanReq(req *WrappedMCRequest) *WrappedMCRequest {
 	req.SiblingReqs = req.SiblingReqs[:0]
 	req.SiblingReqsMtx.Unlock()
 	req.RetryCRCount = 0
+	req.KeyRewritten = false
+	req.SrcVBucket = 0
+	req.SrcKey = nil
 	return req
 }
 
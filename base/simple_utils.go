@@ -19,6 +19,7 @@ import (
 	"errors"
 	"expvar"
 	"fmt"
+	"hash/crc32"
 	"io/ioutil"
 	"math"
 	mrand "math/rand"
@@ -103,6 +104,11 @@ func GetVbListFromKvVbMap(kv_vb_map map[string][]uint16) []uint16 {
 	return vb_list
 }
 
+// The VB that KV clients hash a document key to. numOfVbs is a power of 2
+func GetVBucketForKey(key []byte, numOfVbs int) uint16 {
+	return uint16(((crc32.ChecksumIEEE(key) >> 16) & 0x7fff) & uint32(numOfVbs-1))
+}
+
 // type to facilitate the sorting of uint16 lists
 type Uint16List []uint16
 
@@ -1947,3 +1953,35 @@ func ReverseVBNodesMap(replicaMap VbHostsMapType, getMap func(keys []string) *Kv
 func GetIterationId(counter *uint32) string {
 	return fmt.Sprintf("%v", atomic.AddUint32(counter, 1))
 }
+
+// Returns whether a dead letter collection destination is in the target bucket, and the namespace of the collection
+func ParseDeadLetterCollectionDest(dest string) (isTarget bool, namespace CollectionNamespace, err error) {
+	switch {
+	case strings.HasPrefix(dest, DeadLetterDestSource):
+		namespace, err = NewCollectionNamespaceFromString(strings.TrimPrefix(dest, DeadLetterDestSource))
+	case strings.HasPrefix(dest, DeadLetterDestTarget):
+		isTarget = true
+		namespace, err = NewCollectionNamespaceFromString(strings.TrimPrefix(dest, DeadLetterDestTarget))
+	default:
+		err = fmt.Errorf("%v is not a dead letter collection destination", dest)
+	}
+	return
+}
+
+// Parses a JSON object of VB number to seqno, e.g. {"0":1200,"1":985}
+func ParseStartFromSeqnos(value string) (map[uint16]uint64, error) {
+	var seqnos map[string]uint64
+	err := json.Unmarshal([]byte(value), &seqnos)
+	if err != nil {
+		return nil, err
+	}
+	vbSeqnos := make(map[uint16]uint64, len(seqnos))
+	for vbStr, seqno := range seqnos {
+		vbno, err := strconv.ParseUint(vbStr, 10, 16)
+		if err != nil || vbno >= NumberOfVbs {
+			return nil, fmt.Errorf("%v is not a valid VB number", vbStr)
+		}
+		vbSeqnos[uint16(vbno)] = seqno
+	}
+	return vbSeqnos, nil
+}
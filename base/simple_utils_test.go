@@ -403,3 +403,20 @@ func TestReverseVBNodesMap(t *testing.T) {
 	assert.Len((*output)["node2"], 2)
 	assert.Len((*output)["node3"], 1)
 }
+
+func TestParseStartFromSeqnos(t *testing.T) {
+	fmt.Println("============== Test case start: TestParseStartFromSeqnos =================")
+	defer fmt.Println("============== Test case end: TestParseStartFromSeqnos =================")
+	assert := assert.New(t)
+
+	seqnos, err := ParseStartFromSeqnos(`{"0":1200,"1023":985}`)
+	assert.Nil(err)
+	assert.Equal(map[uint16]uint64{0: 1200, 1023: 985}, seqnos)
+
+	_, err = ParseStartFromSeqnos(`{"1024":1}`)
+	assert.NotNil(err)
+	_, err = ParseStartFromSeqnos(`{"a":1}`)
+	assert.NotNil(err)
+	_, err = ParseStartFromSeqnos(`{"1":-1}`)
+	assert.NotNil(err)
+}
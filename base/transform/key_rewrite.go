@@ -0,0 +1,97 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software
+will be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package transform
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/couchbase/goxdcr/base"
+)
+
+type KeyRewriteRules struct {
+	RemovePrefix string
+	// The replacement may refer to the groups of the regex, i.e. "tenant1::$1"
+	Regex            string
+	RegexReplacement string
+	AddPrefix        string
+}
+
+func (r KeyRewriteRules) IsEmpty() bool {
+	return len(r.RemovePrefix) == 0 && len(r.Regex) == 0 && len(r.AddPrefix) == 0
+}
+
+type KeyRewriter struct {
+	removePrefix []byte
+	regex        base.PcreWrapperInterface
+	replacement  []byte
+	addPrefix    []byte
+}
+
+// Returns a nil rewriter if there are no rules
+func NewKeyRewriter(rules KeyRewriteRules) (*KeyRewriter, error) {
+	if rules.IsEmpty() {
+		return nil, nil
+	}
+	rewriter := &KeyRewriter{
+		removePrefix: []byte(rules.RemovePrefix),
+		replacement:  []byte(rules.RegexReplacement),
+		addPrefix:    []byte(rules.AddPrefix),
+	}
+	if len(rules.Regex) > 0 {
+		regex, err := makeKeyRegex(rules.Regex)
+		if err != nil {
+			return nil, err
+		}
+		rewriter.regex = regex
+	}
+	return rewriter, nil
+}
+
+// pcre panics on expressions that do not compile
+func makeKeyRegex(expression string) (regex base.PcreWrapperInterface, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Invalid %v %v: %v", base.TargetKeyRegexKey, expression, r)
+		}
+	}()
+
+	regex, err = base.MakePcreRegex(expression)
+	if err == base.ErrorNotSupported {
+		err = fmt.Errorf("%v requires a build with PCRE support", base.TargetKeyRegexKey)
+	}
+	return
+}
+
+func ValidateKeyRegex(expression string) error {
+	_, err := makeKeyRegex(expression)
+	return err
+}
+
+// Rewrite returns the key that the document is written to on the target. The returned slice is never the
+// one passed in, so the caller may hold on to it after the source key is recycled
+func (r *KeyRewriter) Rewrite(key []byte) ([]byte, error) {
+	rewritten := key
+	if len(r.removePrefix) > 0 {
+		rewritten = bytes.TrimPrefix(rewritten, r.removePrefix)
+	}
+	if r.regex != nil {
+		rewritten = r.regex.ReplaceAll(rewritten, r.replacement, 0 /*flags*/)
+	}
+
+	newKey := make([]byte, 0, len(r.addPrefix)+len(rewritten))
+	newKey = append(newKey, r.addPrefix...)
+	newKey = append(newKey, rewritten...)
+	if len(newKey) == 0 || len(newKey) > base.MaxDocKeyLength {
+		return nil, base.ErrorKeyRewriteFailed
+	}
+	return newKey, nil
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software
+will be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package transform
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/couchbase/goxdcr/base"
+	"github.com/stretchr/testify/assert"
+)
+
+// stands in for pcre, which is not part of the default build
+type goRegex struct {
+	regex *regexp.Regexp
+}
+
+func (r *goRegex) ReplaceAll(orig, strToSub []byte, flags int) []byte {
+	return r.regex.ReplaceAll(orig, strToSub)
+}
+
+func TestKeyRewriter(t *testing.T) {
+	fmt.Println("============== Test case start: TestKeyRewriter =================")
+	defer fmt.Println("============== Test case end: TestKeyRewriter =================")
+	assert := assert.New(t)
+
+	rewriter, err := NewKeyRewriter(KeyRewriteRules{})
+	assert.Nil(err)
+	assert.Nil(rewriter)
+
+	rewriter, err = NewKeyRewriter(KeyRewriteRules{RemovePrefix: "legacy::", AddPrefix: "tenant1::"})
+	assert.Nil(err)
+	key := []byte("legacy::doc1")
+	rewritten, err := rewriter.Rewrite(key)
+	assert.Nil(err)
+	assert.Equal("tenant1::doc1", string(rewritten))
+	assert.Equal("legacy::doc1", string(key))
+	rewritten, err = rewriter.Rewrite([]byte("doc2"))
+	assert.Nil(err)
+	assert.Equal("tenant1::doc2", string(rewritten))
+
+	// the regex is applied after the prefix is removed and before the prefix is added
+	rewriter.regex = &goRegex{regexp.MustCompile(`^user_(\d+)$`)}
+	rewriter.replacement = []byte("u${1}")
+	rewritten, err = rewriter.Rewrite([]byte("legacy::user_42"))
+	assert.Nil(err)
+	assert.Equal("tenant1::u42", string(rewritten))
+
+	// keys that end up empty or too long are not replicated
+	rewriter, err = NewKeyRewriter(KeyRewriteRules{RemovePrefix: "doc"})
+	assert.Nil(err)
+	_, err = rewriter.Rewrite([]byte("doc"))
+	assert.Equal(base.ErrorKeyRewriteFailed, err)
+	rewriter, err = NewKeyRewriter(KeyRewriteRules{AddPrefix: "tenant1::"})
+	assert.Nil(err)
+	_, err = rewriter.Rewrite([]byte(strings.Repeat("k", base.MaxDocKeyLength)))
+	assert.Equal(base.ErrorKeyRewriteFailed, err)
+}
@@ -0,0 +1,214 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software
+will be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package transform
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/couchbase/goxdcr/base"
+)
+
+// Value that masked fields are replaced with
+const MaskedValue = "****"
+
+// Nested fields are referred to by joining the field names with this delimiter, i.e. "address.zip"
+const FieldPathDelimiter = "."
+
+// Rules are given as a JSON object in the transformation rules replication setting, i.e.
+// {"remove":["ssn"],"mask":["phone"],"hash":["email"],"hashSalt":"s","rename":{"fname":"firstName"},"add":{"region":"eu"}}
+// They are applied in the order of the fields below
+type Rules struct {
+	Remove []string `json:"remove,omitempty"`
+	Mask   []string `json:"mask,omitempty"`
+	// Values are replaced with the hex encoded SHA-256 of the salt followed by the value
+	Hash     []string          `json:"hash,omitempty"`
+	HashSalt string            `json:"hashSalt,omitempty"`
+	Rename   map[string]string `json:"rename,omitempty"`
+	// Static fields that are added, or that replace the value of existing fields
+	Add map[string]interface{} `json:"add,omitempty"`
+}
+
+type Transformer struct {
+	rules Rules
+	// rename rules sorted by their source field so that they are applied in the same order for every document
+	renameFrom []string
+	addPaths   []string
+}
+
+// Returns a nil transformer if there are no rules
+func NewTransformer(rulesStr string) (*Transformer, error) {
+	if len(rulesStr) == 0 {
+		return nil, nil
+	}
+
+	var rules Rules
+	decoder := json.NewDecoder(strings.NewReader(rulesStr))
+	decoder.UseNumber()
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&rules)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid transformation rules: %v", err)
+	}
+
+	transformer := &Transformer{rules: rules}
+	for _, paths := range [][]string{rules.Remove, rules.Mask, rules.Hash} {
+		for _, path := range paths {
+			if err = validatePath(path); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for from, to := range rules.Rename {
+		if err = validatePath(from); err != nil {
+			return nil, err
+		}
+		if err = validatePath(to); err != nil {
+			return nil, err
+		}
+		transformer.renameFrom = append(transformer.renameFrom, from)
+	}
+	for path := range rules.Add {
+		if err = validatePath(path); err != nil {
+			return nil, err
+		}
+		transformer.addPaths = append(transformer.addPaths, path)
+	}
+	sort.Strings(transformer.renameFrom)
+	sort.Strings(transformer.addPaths)
+
+	if len(rules.Remove) == 0 && len(rules.Mask) == 0 && len(rules.Hash) == 0 && len(rules.Rename) == 0 && len(rules.Add) == 0 {
+		return nil, nil
+	}
+	return transformer, nil
+}
+
+func ValidateRules(rulesStr string) error {
+	_, err := NewTransformer(rulesStr)
+	return err
+}
+
+func validatePath(path string) error {
+	for _, field := range strings.Split(path, FieldPathDelimiter) {
+		if len(field) == 0 {
+			return fmt.Errorf("Invalid field %v in transformation rules", path)
+		}
+	}
+	return nil
+}
+
+// Transform takes a JSON document body without xattrs and returns the transformed body.
+// The body must be a JSON object
+func (t *Transformer) Transform(body []byte) ([]byte, error) {
+	var doc map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	err := decoder.Decode(&doc)
+	if err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		return nil, base.ErrorInvalidInput
+	}
+
+	for _, path := range t.rules.Remove {
+		removeField(doc, path)
+	}
+	for _, path := range t.rules.Mask {
+		if _, exists := getField(doc, path); exists {
+			setField(doc, path, MaskedValue)
+		}
+	}
+	for _, path := range t.rules.Hash {
+		if value, exists := getField(doc, path); exists {
+			hashed, err := t.hash(value)
+			if err != nil {
+				return nil, err
+			}
+			setField(doc, path, hashed)
+		}
+	}
+	for _, from := range t.renameFrom {
+		if value, exists := removeField(doc, from); exists {
+			setField(doc, t.rules.Rename[from], value)
+		}
+	}
+	for _, path := range t.addPaths {
+		setField(doc, path, t.rules.Add[path])
+	}
+
+	return json.Marshal(doc)
+}
+
+func (t *Transformer) hash(value interface{}) (string, error) {
+	var valueBytes []byte
+	if valueStr, isString := value.(string); isString {
+		valueBytes = []byte(valueStr)
+	} else {
+		var err error
+		valueBytes, err = json.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+	}
+	hasher := sha256.New()
+	hasher.Write([]byte(t.rules.HashSalt))
+	hasher.Write(valueBytes)
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// Returns the object that holds the last field of the path. When create is set, missing objects along the path
+// are created, and fields along the path that are not objects are replaced
+func getParent(doc map[string]interface{}, path string, create bool) (map[string]interface{}, string) {
+	fields := strings.Split(path, FieldPathDelimiter)
+	parent := doc
+	for _, field := range fields[:len(fields)-1] {
+		child, isObject := parent[field].(map[string]interface{})
+		if !isObject {
+			if !create {
+				return nil, ""
+			}
+			child = make(map[string]interface{})
+			parent[field] = child
+		}
+		parent = child
+	}
+	return parent, fields[len(fields)-1]
+}
+
+func getField(doc map[string]interface{}, path string) (interface{}, bool) {
+	parent, field := getParent(doc, path, false)
+	if parent == nil {
+		return nil, false
+	}
+	value, exists := parent[field]
+	return value, exists
+}
+
+func setField(doc map[string]interface{}, path string, value interface{}) {
+	parent, field := getParent(doc, path, true)
+	parent[field] = value
+}
+
+func removeField(doc map[string]interface{}, path string) (interface{}, bool) {
+	parent, field := getParent(doc, path, false)
+	if parent == nil {
+		return nil, false
+	}
+	value, exists := parent[field]
+	delete(parent, field)
+	return value, exists
+}
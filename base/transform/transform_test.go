@@ -0,0 +1,78 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software
+will be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package transform
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewTransformer(t *testing.T) {
+	fmt.Println("============== Test case start: TestNewTransformer =================")
+	defer fmt.Println("============== Test case end: TestNewTransformer =================")
+	assert := assert.New(t)
+
+	transformer, err := NewTransformer("")
+	assert.Nil(err)
+	assert.Nil(transformer)
+
+	transformer, err = NewTransformer("{}")
+	assert.Nil(err)
+	assert.Nil(transformer)
+
+	_, err = NewTransformer(`{"drop":["ssn"]}`)
+	assert.NotNil(err)
+	_, err = NewTransformer(`{"remove":["address..zip"]}`)
+	assert.NotNil(err)
+	_, err = NewTransformer(`{"rename":{"name":""}}`)
+	assert.NotNil(err)
+	assert.NotNil(ValidateRules("not json"))
+}
+
+func TestTransform(t *testing.T) {
+	fmt.Println("============== Test case start: TestTransform =================")
+	defer fmt.Println("============== Test case end: TestTransform =================")
+	assert := assert.New(t)
+
+	rules := `{"remove":["ssn","address.zip","missing.field"],"mask":["phone"],"hash":["email"],"hashSalt":"salt",
+		"rename":{"fname":"name.first"},"add":{"region":"eu-west","version":2}}`
+	transformer, err := NewTransformer(rules)
+	assert.Nil(err)
+	assert.NotNil(transformer)
+
+	body := []byte(`{"ssn":"123-45-6789","address":{"city":"Paris","zip":"75001"},"phone":"555-0100","email":"a@b.com","fname":"Ann","balance":12345678901234567890}`)
+	transformed, err := transformer.Transform(body)
+	assert.Nil(err)
+
+	var doc map[string]interface{}
+	assert.Nil(json.Unmarshal(transformed, &doc))
+	assert.NotContains(doc, "ssn")
+	assert.Equal(map[string]interface{}{"city": "Paris"}, doc["address"])
+	assert.Equal(MaskedValue, doc["phone"])
+	expectedHash := sha256.Sum256([]byte("salta@b.com"))
+	assert.Equal(hex.EncodeToString(expectedHash[:]), doc["email"])
+	assert.NotContains(doc, "fname")
+	assert.Equal(map[string]interface{}{"first": "Ann"}, doc["name"])
+	assert.Equal("eu-west", doc["region"])
+	assert.Equal(float64(2), doc["version"])
+	// numbers that do not fit in a float are kept as they are
+	assert.Contains(string(transformed), "12345678901234567890")
+
+	_, err = transformer.Transform([]byte(`["not","an","object"]`))
+	assert.NotNil(err)
+	_, err = transformer.Transform([]byte(`null`))
+	assert.NotNil(err)
+}
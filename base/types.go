@@ -668,9 +668,10 @@ type TargetCollectionInfo struct {
 	// The len is needed because slice returned from datapool can have garbage
 	ColIDPrefixedKeyLen int
 
+	// The target namespace that the router has routed the request to. It is used for per collection stats
 	// For migration, since given WrappedMCRequest's SrcColNamespace, there is no way to look up the actual
-	// target namespace given migration rules, this is needed for error handling
-	// For non-migration use cases, this is not to be used
+	// target namespace given migration rules, this is also needed for error handling
+	// Nil if the target cluster does not support collections
 	TargetNamespace *CollectionNamespace
 }
 
@@ -691,6 +692,28 @@ type WrappedMCRequest struct {
 	SiblingReqs    []*WrappedMCRequest
 	SiblingReqsMtx sync.RWMutex
 	RetryCRCount   int
+
+	// Set when the target key has been rewritten, in which case the request may go to a different target VB than
+	// the VB of the source mutation
+	KeyRewritten bool
+	SrcVBucket   uint16
+	SrcKey       []byte
+}
+
+// Events raised for the request are tracked against the VB of the source mutation
+func (req *WrappedMCRequest) GetSourceVBucket() uint16 {
+	if req.KeyRewritten {
+		return req.SrcVBucket
+	}
+	return req.Req.VBucket
+}
+
+// Returns the key of the source document, which differs from the target key when the target key has been rewritten
+func (req *WrappedMCRequest) GetSourceKey() []byte {
+	if req.KeyRewritten {
+		return req.SrcKey
+	}
+	return req.GetPlainKey()
 }
 
 func (req *WrappedMCRequest) ConstructUniqueKey() {
@@ -727,6 +750,22 @@ func (req *WrappedMCRequest) GetSourceCollectionNamespace() *CollectionNamespace
 	return req.SrcColNamespace
 }
 
+// The router prefixes Req.Key with the leb128 encoded target collection ID
+// Returns the document key as it is known to the user
+func (req *WrappedMCRequest) GetPlainKey() []byte {
+	req.ColInfoMtx.RLock()
+	defer req.ColInfoMtx.RUnlock()
+	if req.ColInfo == nil || req.ColInfo.ColIDPrefixedKeyLen == 0 {
+		return req.Req.Key
+	}
+	for i := 0; i < len(req.Req.Key); i++ {
+		if req.Req.Key[i]&0x80 == 0 {
+			return req.Req.Key[i+1:]
+		}
+	}
+	return req.Req.Key
+}
+
 type McRequestMap map[string]*WrappedMCRequest
 
 type MCResponseMap map[string]*gomemcached.MCResponse
@@ -2406,6 +2445,118 @@ type ConflictParams struct {
 	ObjectRecycler func(request *WrappedMCRequest) // for source WrappedMCRequest cleanup
 }
 
+// ConflictDocMeta is the document metadata recorded on each side of a logged conflict
+type ConflictDocMeta struct {
+	Cas      uint64 `json:"cas"`
+	RevSeq   uint64 `json:"revSeq"`
+	Flags    uint32 `json:"flags"`
+	Expiry   uint32 `json:"expiry"`
+	Deletion bool   `json:"deleted"`
+	DataType uint8  `json:"datatype"`
+}
+
+// ConflictRecord describes a source mutation that lost source side conflict resolution to the target document.
+// XMEM populates it with copies of the data so that the source WrappedMCRequest can be recycled right away
+type ConflictRecord struct {
+	Key       []byte
+	Namespace CollectionNamespace
+	VBucket   uint16
+	Seqno     uint64
+	CRMode    ConflictResolutionMode
+	Timestamp time.Time
+	Source    ConflictDocMeta
+	Target    ConflictDocMeta
+	// Value of the source mutation as sent by DCP. It may be snappy compressed and may contain xattrs
+	Body []byte
+}
+
+// DeadLetterRecord describes a source mutation that the target rejected with a non-retryable status.
+// Like ConflictRecord, XMEM populates it with copies so that the WrappedMCRequest can be recycled right away
+type DeadLetterRecord struct {
+	// Key of the source document, before any target key rewriting
+	Key       []byte
+	TargetKey []byte
+	Namespace CollectionNamespace
+	// Source VB and seqno of the mutation, which the through seqno of the VB moves past once the record is written
+	VBucket    uint16
+	Seqno      uint64
+	ManifestId uint64
+	Opcode     gomemcached.CommandCode
+	Status     gomemcached.Status
+	Cas        uint64
+	DataType   uint8
+	Timestamp  time.Time
+	// Value as sent to the target. It may be snappy compressed and may contain xattrs.
+	// It is only written out when deadLetterIncludeBody is on
+	Body []byte
+}
+
+// FileExportRecord is a single line in a file written by the file export nozzle
+// The value is stored decompressed and split into its xattrs and its body so that the archive can be read
+// without any knowledge of the memcached protocol. DataType is the source datatype with the snappy bit removed
+type FileExportRecord struct {
+	Key     string `json:"key"`
+	VBucket uint16 `json:"vb"`
+	Seqno   uint64 `json:"seqno"`
+	// One of FileExportOpMutation, FileExportOpDeletion or FileExportOpExpiration
+	Op       string `json:"op"`
+	Cas      uint64 `json:"cas"`
+	RevSeq   uint64 `json:"revSeq"`
+	Flags    uint32 `json:"flags"`
+	Expiry   uint32 `json:"expiry"`
+	DataType uint8  `json:"datatype"`
+	// Namespaces are in the form of scope.collection
+	SourceNamespace  string `json:"sourceNamespace"`
+	TargetNamespace  string `json:"targetNamespace"`
+	TargetManifestId uint64 `json:"targetManifestId"`
+	// Raw xattr values keyed by xattr name
+	Xattrs map[string]string `json:"xattrs,omitempty"`
+	// A valid JSON body is stored as is. Anything else is stored in DocBinary
+	Doc       json.RawMessage `json:"doc,omitempty"`
+	DocBinary []byte          `json:"docBinary,omitempty"`
+}
+
+// Outcome of running a filter expression against a sample of source documents
+type FilterSampleResult struct {
+	SampledCnt        int `json:"sampled"`
+	MatchedCnt        int `json:"matched"`
+	NotMatchedCnt     int `json:"notMatched"`
+	UnableToFilterCnt int `json:"unableToFilter"`
+
+	// Example keys are capped at FilterSampleMaxExampleKeys
+	MatchedKeys []string `json:"matchedKeys"`
+	// Unable to filter is keyed by document key to the reason
+	UnableToFilterDocs map[string]string `json:"unableToFilterDocs"`
+}
+
+func NewFilterSampleResult() *FilterSampleResult {
+	return &FilterSampleResult{
+		MatchedKeys:        []string{},
+		UnableToFilterDocs: make(map[string]string),
+	}
+}
+
+func (f *FilterSampleResult) RecordMatched(docId string) {
+	f.SampledCnt++
+	f.MatchedCnt++
+	if len(f.MatchedKeys) < FilterSampleMaxExampleKeys {
+		f.MatchedKeys = append(f.MatchedKeys, docId)
+	}
+}
+
+func (f *FilterSampleResult) RecordNotMatched() {
+	f.SampledCnt++
+	f.NotMatchedCnt++
+}
+
+func (f *FilterSampleResult) RecordUnableToFilter(docId string, reason string) {
+	f.SampledCnt++
+	f.UnableToFilterCnt++
+	if len(f.UnableToFilterDocs) < FilterSampleMaxExampleKeys {
+		f.UnableToFilterDocs[docId] = reason
+	}
+}
+
 type ConflictManagerAction int
 
 const (
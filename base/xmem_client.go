@@ -385,3 +385,20 @@ func IsEAccessError(resp_status gomemcached.Status) bool {
 		return false
 	}
 }
+
+// check if memcached response status indicates that the target will not accept the document no matter how many times
+// it is resent, in which case the document can be written to the dead letter destination
+func IsDeadLetterMCError(resp_status gomemcached.Status) bool {
+	switch resp_status {
+	case gomemcached.E2BIG:
+		fallthrough
+	case gomemcached.EINVAL:
+		fallthrough
+	case gomemcached.XATTR_EINVAL:
+		fallthrough
+	case gomemcached.EACCESS:
+		return true
+	default:
+		return false
+	}
+}
@@ -84,6 +84,18 @@ const (
 	DataCloned ComponentEventType = iota
 	// DCP may send OSO snapshot marker if XDCR requested only one collection
 	OsoSnapshotReceived ComponentEventType = iota
+	// A source document that failed source side conflict resolution has been written to the conflict log
+	ConflictLogged ComponentEventType = iota
+	// A conflict record could not be queued or written to the conflict log
+	ConflictLogDropped ComponentEventType = iota
+	// A stop when caught up pipeline has replicated every VB up to the seqno its stream ended at
+	ReplicationCaughtUp ComponentEventType = iota
+	// A document that the target rejected for good has been written to the dead letter destination
+	DataDeadLettered ComponentEventType = iota
+	// A dead letter record could not be written to the dead letter destination
+	DeadLetterWriteFailed ComponentEventType = iota
+	// The deletions flowing through the routers have exceeded the bulk delete limits of the replication
+	BulkDeletesDetected ComponentEventType = iota
 )
 
 func (c ComponentEventType) IsOutNozzleThroughSeqnoRelated() bool {
@@ -96,6 +108,8 @@ func (c ComponentEventType) IsOutNozzleThroughSeqnoRelated() bool {
 		return true
 	case DataNotReplicated:
 		return true
+	case DataDeadLettered:
+		return true
 	default:
 		return false
 	}
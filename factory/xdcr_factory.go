@@ -36,10 +36,11 @@ import (
 )
 
 const (
-	PART_NAME_DELIMITER     = "_"
-	DCP_NOZZLE_NAME_PREFIX  = "dcp"
-	XMEM_NOZZLE_NAME_PREFIX = "xmem"
-	CAPI_NOZZLE_NAME_PREFIX = "capi"
+	PART_NAME_DELIMITER            = "_"
+	DCP_NOZZLE_NAME_PREFIX         = "dcp"
+	XMEM_NOZZLE_NAME_PREFIX        = "xmem"
+	CAPI_NOZZLE_NAME_PREFIX        = "capi"
+	FILE_EXPORT_NOZZLE_NAME_PREFIX = "file"
 )
 
 // interface so we can autogenerate mock and do unit test
@@ -196,10 +197,14 @@ func (xdcrf *XDCRFactory) newPipelineCommon(topic string, pipelineType common.Pi
 	}
 	defer latestSourceBucketTopology.Recycle()
 
-	targetClusterRef, err := xdcrf.remote_cluster_svc.RemoteClusterByUuid(spec.TargetClusterUUID, false)
-	if err != nil {
-		xdcrf.logger.Errorf("Error getting remote cluster with uuid=%v for pipeline %v, err=%v\n", spec.TargetClusterUUID, spec.Id, err)
-		return nil, nil, err
+	// file export replications have no target cluster, and hence no reference nor target bucket topology
+	var targetClusterRef *metadata.RemoteClusterReference
+	if !spec.IsFileExport() {
+		targetClusterRef, err = xdcrf.remote_cluster_svc.RemoteClusterByUuid(spec.TargetClusterUUID, false)
+		if err != nil {
+			xdcrf.logger.Errorf("Error getting remote cluster with uuid=%v for pipeline %v, err=%v\n", spec.TargetClusterUUID, spec.Id, err)
+			return nil, nil, err
+		}
 	}
 
 	nozzleType, err := xdcrf.getOutNozzleType(targetClusterRef, spec)
@@ -209,33 +214,38 @@ func (xdcrf *XDCRFactory) newPipelineCommon(topic string, pipelineType common.Pi
 	}
 	isCapiReplication := (nozzleType == base.Capi)
 
-	targetBucketFeed, err := xdcrf.bucketTopologySvc.SubscribeToRemoteBucketFeed(spec, xdcrf.bucketSvcId)
-	if err != nil {
-		xdcrf.logger.Errorf("Error subscribing to remote feed for spec %v", spec.Id)
-		return nil, nil, err
-	}
-	var latestTargetBucketTopology service_def.TargetNotification
-	defer xdcrf.bucketTopologySvc.UnSubscribeRemoteBucketFeed(spec, xdcrf.bucketSvcId)
-	select {
-	case latestTargetBucketTopology = <-targetBucketFeed:
-	default:
-		return nil, nil, base.ErrorSourceBucketTopologyNotReady
-	}
-	targetBucketInfo := latestTargetBucketTopology.GetTargetBucketInfo()
-	defer latestTargetBucketTopology.Recycle()
-
-	conflictResolutionType, err := xdcrf.utils.GetConflictResolutionTypeFromBucketInfo(spec.TargetBucketName, targetBucketInfo)
-	if err != nil {
-		return nil, nil, err
-	}
-
 	// sourceCRMode is the conflict resolution mode to use when resolving conflicts for big documents at source side
 	// capi replication always uses rev id based conflict resolution
+	// file export replication has no target documents to resolve conflicts against and uses rev id as well
 	sourceCRMode := base.CRMode_RevId
-	if !isCapiReplication {
-		// for xmem replication, sourceCRMode is LWW if and only if target bucket is LWW enabled, so as to ensure that source side conflict
-		// resolution and target side conflict resolution yield consistent results
-		sourceCRMode = base.GetCRModeFromConflictResolutionTypeSetting(conflictResolutionType)
+
+	var targetBucketInfo map[string]interface{}
+	if !spec.IsFileExport() {
+		targetBucketFeed, err := xdcrf.bucketTopologySvc.SubscribeToRemoteBucketFeed(spec, xdcrf.bucketSvcId)
+		if err != nil {
+			xdcrf.logger.Errorf("Error subscribing to remote feed for spec %v", spec.Id)
+			return nil, nil, err
+		}
+		var latestTargetBucketTopology service_def.TargetNotification
+		defer xdcrf.bucketTopologySvc.UnSubscribeRemoteBucketFeed(spec, xdcrf.bucketSvcId)
+		select {
+		case latestTargetBucketTopology = <-targetBucketFeed:
+		default:
+			return nil, nil, base.ErrorSourceBucketTopologyNotReady
+		}
+		targetBucketInfo = latestTargetBucketTopology.GetTargetBucketInfo()
+		defer latestTargetBucketTopology.Recycle()
+
+		conflictResolutionType, err := xdcrf.utils.GetConflictResolutionTypeFromBucketInfo(spec.TargetBucketName, targetBucketInfo)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if nozzleType == base.Xmem {
+			// for xmem replication, sourceCRMode is LWW if and only if target bucket is LWW enabled, so as to ensure that source side conflict
+			// resolution and target side conflict resolution yield consistent results
+			sourceCRMode = base.GetCRModeFromConflictResolutionTypeSetting(conflictResolutionType)
+		}
 	}
 
 	var specForConstruction metadata.GenericSpecification
@@ -279,8 +289,22 @@ func (xdcrf *XDCRFactory) newPipelineCommon(topic string, pipelineType common.Pi
 	 * 2. vbNozzleMap - map of VBucket# -> nozzle to be used (to be used by router)
 	 * 3. kvVBMap - map of remote KVNodes -> vbucket# responsible for per node
 	 */
-	outNozzles, vbNozzleMap, target_kv_vb_map, targetUserName, targetPassword, targetClusterVersion, err :=
-		xdcrf.constructOutgoingNozzles(partTopic, spec, kv_vb_map, sourceCRMode, targetBucketInfo, targetClusterRef, isCapiReplication, logger_ctx)
+	var outNozzles map[string]common.Nozzle
+	var vbNozzleMap map[uint16]string
+	var target_kv_vb_map map[string][]uint16
+	var targetUserName, targetPassword string
+	var targetClusterVersion int
+	if nozzleType == base.FileExport {
+		if !spec.Settings.GetTargetKeyRewriteRules().IsEmpty() {
+			return nil, nil, fmt.Errorf("Target key rewriting is not supported for file export replications")
+		}
+		// file export nozzles do not talk to the target cluster, so there is no target kv map nor credentials
+		target_kv_vb_map = make(map[string][]uint16)
+		outNozzles, vbNozzleMap, err = xdcrf.constructFileExportNozzles(partTopic, spec, kv_vb_map, logger_ctx)
+	} else {
+		outNozzles, vbNozzleMap, target_kv_vb_map, targetUserName, targetPassword, targetClusterVersion, err =
+			xdcrf.constructOutgoingNozzles(partTopic, spec, kv_vb_map, sourceCRMode, targetBucketInfo, targetClusterRef, isCapiReplication, logger_ctx)
+	}
 
 	if err != nil {
 		return nil, nil, err
@@ -294,6 +318,12 @@ func (xdcrf *XDCRFactory) newPipelineCommon(topic string, pipelineType common.Pi
 	for _, sourceNozzle := range sourceNozzles {
 		vblist := sourceNozzle.(*parts.DcpNozzle).ResponsibleVBs()
 		downStreamParts := make(map[string]common.Part)
+		if !spec.Settings.GetTargetKeyRewriteRules().IsEmpty() {
+			// rewritten keys can be routed to any of the target nozzles
+			for outNozzleId, outNozzle := range outNozzles {
+				downStreamParts[outNozzleId] = outNozzle
+			}
+		}
 		for _, vb := range vblist {
 			targetNozzleId, ok := vbNozzleMap[vb]
 			if !ok {
@@ -343,7 +373,7 @@ func (xdcrf *XDCRFactory) newPipelineCommon(topic string, pipelineType common.Pi
 	pipeline.SetRuntimeContext(pipelineContext)
 
 	registerCb := func(mainPipeline *common.Pipeline) error {
-		return xdcrf.registerServices(pipeline, logger_ctx, kv_vb_map, targetUserName, targetPassword, spec.TargetBucketName, target_kv_vb_map, targetClusterRef, targetClusterVersion, isCapiReplication, mainPipeline, sourceCRMode)
+		return xdcrf.registerServices(pipeline, logger_ctx, kv_vb_map, targetUserName, targetPassword, spec.TargetBucketName, target_kv_vb_map, targetClusterRef, targetClusterVersion, nozzleType, mainPipeline, sourceCRMode)
 	}
 	return pipeline, registerCb, nil
 }
@@ -588,6 +618,12 @@ func (xdcrf *XDCRFactory) constructOutgoingNozzles(topic string, spec *metadata.
 		}
 	}
 
+	keyRewriting := !spec.Settings.GetTargetKeyRewriteRules().IsEmpty()
+	if keyRewriting && isCapiReplication {
+		err = fmt.Errorf("Target key rewriting is not supported for CAPI replications")
+		return
+	}
+
 	var vbCouchApiBaseMap map[uint16]string
 
 	// For each destination host (kvaddr) and its vbucvket list that it has (kvVBList)
@@ -604,6 +640,10 @@ func (xdcrf *XDCRFactory) constructOutgoingNozzles(topic string, spec *metadata.
 		// Given current Destination node's list of VBucketList and the map of all source nodes -> vbLists
 		// Match the needed vbuckets
 		relevantVBs := xdcrf.filterVBList(kvVBList /* Dest */, kv_vb_map /* source */)
+		if keyRewriting {
+			// rewritten keys can hash to any target vb
+			relevantVBs = kvVBList
+		}
 
 		xdcrf.logger.Debugf("kvaddr = %v; kvVbList=%v, relevantVBs=-%v\n", kvaddr, kvVBList, relevantVBs)
 
@@ -631,7 +671,10 @@ func (xdcrf *XDCRFactory) constructOutgoingNozzles(topic string, spec *metadata.
 				}
 			} else {
 				connSize := numOfOutNozzles * 2
-				outNozzle = xdcrf.constructXMEMNozzle(topic, sourceClusterUuid, spec.TargetClusterUUID, kvaddr, spec.SourceBucketName, spec.TargetBucketName, spec.TargetBucketUUID, targetUserName, targetPassword, i, connSize, sourceCRMode, targetBucketInfo, logger_ctx, vbList)
+				outNozzle, err = xdcrf.constructXMEMNozzle(topic, sourceClusterUuid, spec.TargetClusterUUID, kvaddr, spec.SourceBucketName, spec.TargetBucketName, spec.TargetBucketUUID, targetUserName, targetPassword, i, connSize, sourceCRMode, spec.Settings.GetConflictResolutionStrategy(), targetBucketInfo, logger_ctx, vbList)
+				if err != nil {
+					return
+				}
 			}
 
 			// Add the created nozzle to the collective map of outNozzles to be returned
@@ -654,6 +697,56 @@ func (xdcrf *XDCRFactory) constructOutgoingNozzles(topic string, spec *metadata.
 	return
 }
 
+// File export nozzles are not tied to any target node. The vbuckets of each source node are
+// spread over up to TargetNozzlePerNode nozzles, each of which writes to its own files
+func (xdcrf *XDCRFactory) constructFileExportNozzles(topic string, spec *metadata.ReplicationSpecification, kv_vb_map map[string][]uint16,
+	logger_ctx *log.LoggerContext) (outNozzles map[string]common.Nozzle, vbNozzleMap map[uint16]string, err error) {
+	outNozzles = make(map[string]common.Nozzle)
+	vbNozzleMap = make(map[uint16]string)
+
+	exportDir := parts.FileExportDirForReplication(spec.Settings.GetFileExportDir(), spec.Id)
+	targetNamespaceGetter := func(manifestId uint64, colId uint32) (*base.CollectionNamespace, error) {
+		manifest, err := xdcrf.collectionsManifestSvc.GetSpecificTargetManifest(spec, manifestId)
+		if err != nil {
+			return nil, err
+		}
+		scopeName, collectionName, err := manifest.GetScopeAndCollectionName(colId)
+		if err != nil {
+			return nil, err
+		}
+		return &base.CollectionNamespace{ScopeName: scopeName, CollectionName: collectionName}, nil
+	}
+
+	for kvaddr, vbnos := range kv_vb_map {
+		numOfVbs := len(vbnos)
+		numOfOutNozzles := min(numOfVbs, spec.Settings.TargetNozzlePerNode)
+		load_distribution := base.BalanceLoad(numOfOutNozzles, numOfVbs)
+		xdcrf.logger.Infof("topic=%v, numOfFileExportNozzles=%v, numOfVbs=%v, load_distribution=%v, exportDir=%v\n", spec.Id, numOfOutNozzles, numOfVbs, load_distribution, exportDir)
+
+		for i := 0; i < numOfOutNozzles; i++ {
+			vbList := make([]uint16, 0)
+			for index := load_distribution[i][0]; index < load_distribution[i][1]; index++ {
+				vbList = append(vbList, vbnos[index])
+			}
+
+			// partIds of the file export nozzles look like "file_$topic_$kvaddr_1"
+			nozzleId := xdcrf.partId(FILE_EXPORT_NOZZLE_NAME_PREFIX, topic, kvaddr, i)
+			outNozzle := parts.NewFileExportNozzle(nozzleId, topic, exportDir, targetNamespaceGetter, logger_ctx, xdcrf.utils, vbList)
+			outNozzles[outNozzle.Id()] = outNozzle
+			for _, vbno := range vbList {
+				vbNozzleMap[vbno] = outNozzle.Id()
+			}
+		}
+	}
+
+	if len(outNozzles) == 0 {
+		err = base.ErrorNoTargetNozzle
+		return
+	}
+	xdcrf.logger.Infof("Constructed %v file export nozzles\n", len(outNozzles))
+	return
+}
+
 func (xdcrf *XDCRFactory) constructRouter(id string, spec *metadata.ReplicationSpecification, downStreamParts map[string]common.Part, vbNozzleMap map[uint16]string, sourceCRMode base.ConflictResolutionMode, logger_ctx *log.LoggerContext, srcNozzleObjRecycler utilities.RecycleObjFunc, migrationUIMsgRaiser func(string)) (*parts.Router, error) {
 	routerId := "Router" + PART_NAME_DELIMITER + id
 
@@ -666,19 +759,29 @@ func (xdcrf *XDCRFactory) constructRouter(id string, spec *metadata.ReplicationS
 		}
 	}
 
-	// Get the current remote cluster capability. Note - if remote cluster capability changes, pipelines
-	// based on the target reference will restart
-	ref, err := xdcrf.remote_cluster_svc.RemoteClusterByUuid(spec.TargetClusterUUID, false)
-	if err != nil {
-		return nil, err
-	}
-	remoteClusterCapability, err := xdcrf.remote_cluster_svc.GetCapability(ref)
-	if err != nil {
-		return nil, err
-	}
+	var remoteClusterCapability metadata.Capability
+	var connectivityStatusGetter func() (metadata.ConnectivityStatus, error)
+	if spec.IsFileExport() {
+		// the local files are always reachable
+		remoteClusterCapability = metadata.FileExportCapability()
+		connectivityStatusGetter = func() (metadata.ConnectivityStatus, error) {
+			return metadata.ConnValid, nil
+		}
+	} else {
+		// Get the current remote cluster capability. Note - if remote cluster capability changes, pipelines
+		// based on the target reference will restart
+		ref, err := xdcrf.remote_cluster_svc.RemoteClusterByUuid(spec.TargetClusterUUID, false)
+		if err != nil {
+			return nil, err
+		}
+		remoteClusterCapability, err = xdcrf.remote_cluster_svc.GetCapability(ref)
+		if err != nil {
+			return nil, err
+		}
 
-	connectivityStatusGetter := func() (metadata.ConnectivityStatus, error) {
-		return xdcrf.remote_cluster_svc.GetConnectivityStatus(ref)
+		connectivityStatusGetter = func() (metadata.ConnectivityStatus, error) {
+			return xdcrf.remote_cluster_svc.GetConnectivityStatus(ref)
+		}
 	}
 
 	// when initializing router, isHighReplication is set to true only if replication priority is High
@@ -700,6 +803,8 @@ func (xdcrf *XDCRFactory) getOutNozzleType(targetClusterRef *metadata.RemoteClus
 		return base.Xmem, nil
 	case metadata.ReplicationTypeCapi:
 		return base.Capi, nil
+	case metadata.ReplicationTypeFile:
+		return base.FileExport, nil
 	default:
 		// should never get here
 		return -1, errors.New(fmt.Sprintf("Invalid replication type %v", spec.Settings.RepType))
@@ -718,14 +823,31 @@ func (xdcrf *XDCRFactory) constructXMEMNozzle(topic string,
 	nozzle_index int,
 	connPoolSize int,
 	sourceCRMode base.ConflictResolutionMode,
+	crStrategy string,
 	targetBucketInfo map[string]interface{},
 	logger_ctx *log.LoggerContext,
-	vbList []uint16) common.Nozzle {
+	vbList []uint16) (common.Nozzle, error) {
 	// partIds of the xmem nozzles look like "xmem_$topic_$kvaddr_1"
 	xmemNozzle_Id := xdcrf.partId(XMEM_NOZZLE_NAME_PREFIX, topic, kvaddr, nozzle_index)
 	nozzle := parts.NewXmemNozzle(xmemNozzle_Id, xdcrf.remote_cluster_svc, sourceClusterUuid, targetClusterUuid, topic, topic, connPoolSize, kvaddr, sourceBucketName, targetBucketName,
 		targetBucketUuid, username, password, sourceCRMode, logger_ctx, xdcrf.utils, vbList)
-	return nozzle
+
+	if crStrategy != base.ConflictResolutionStrategyDefault {
+		if sourceCRMode == base.CRMode_Custom {
+			// the strategy can only be set on a custom CR replication after it has been created
+			xdcrf.logger.Warnf("%v ignores conflict resolution strategy %v since the buckets use custom conflict resolution", xmemNozzle_Id, crStrategy)
+		} else {
+			resolver, err := parts.NewConflictResolver(crStrategy, sourceCRMode)
+			if err != nil {
+				return nil, err
+			}
+			err = nozzle.SetConflictResolver(resolver)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return nozzle, nil
 }
 
 func (xdcrf *XDCRFactory) constructCAPINozzle(topic string,
@@ -771,6 +893,9 @@ func (xdcrf *XDCRFactory) ConstructSettingsForPart(pipeline common.Pipeline, par
 	} else if _, ok := part.(*parts.CapiNozzle); ok {
 		xdcrf.logger.Debugf("Construct settings for CapiNozzle %s", part.Id())
 		return xdcrf.constructSettingsForCapiNozzle(pipeline, settings)
+	} else if _, ok := part.(*parts.FileExportNozzle); ok {
+		xdcrf.logger.Debugf("Construct settings for FileExportNozzle %s", part.Id())
+		return xdcrf.constructSettingsForFileExportNozzle(pipeline, settings)
 	} else {
 		return settings, nil
 	}
@@ -796,6 +921,9 @@ func (xdcrf *XDCRFactory) ConstructUpdateSettingsForPart(pipeline common.Pipelin
 	} else if _, ok := part.(*parts.DcpNozzle); ok {
 		xdcrf.logger.Debugf("Construct update settings for DcpNozzle %s", part.Id())
 		return xdcrf.constructUpdateSettingsForDcpNozzle(pipeline, settings), nil
+	} else if _, ok := part.(*parts.FileExportNozzle); ok {
+		// none of the file export nozzle settings are changeable at runtime
+		return make(metadata.ReplicationSettingsMap), nil
 	} else {
 		return settings, nil
 	}
@@ -1005,6 +1133,7 @@ func (xdcrf *XDCRFactory) constructSettingsForXmemNozzle(pipeline common.Pipelin
 	xmemSettings[parts.XMEM_SETTING_CLIENT_KEY] = targetClusterRef.ClientKey()
 	xmemSettings[parts.XMEM_SETTING_ENCRYPTION_TYPE] = targetClusterRef.EncryptionType()
 	xmemSettings[parts.HLV_PRUNING_WINDOW] = metadata.GetSettingFromSettingsMap(settings, metadata.HlvPruningWindowKey, base.HlvPruningDefault)
+	xmemSettings[parts.XMEM_SETTING_APPLY_DELAY] = repSettings.GetApplyDelay()
 	if targetClusterRef.IsFullEncryption() {
 		mem_ssl_port, ok := ssl_port_map[xmemConnStr]
 		if !ok {
@@ -1036,6 +1165,16 @@ func (xdcrf *XDCRFactory) constructSettingsForCapiNozzle(pipeline common.Pipelin
 
 }
 
+func (xdcrf *XDCRFactory) constructSettingsForFileExportNozzle(pipeline common.Pipeline, settings metadata.ReplicationSettingsMap) (map[string]interface{}, error) {
+	fileExportSettings := make(metadata.ReplicationSettingsMap)
+	repSettings := pipeline.Specification().GetReplicationSpec().Settings
+
+	fileExportSettings[parts.SETTING_BATCHCOUNT] = metadata.GetSettingFromSettingsMap(settings, metadata.BatchCountKey, repSettings.BatchCount)
+	fileExportSettings[parts.SETTING_STATS_INTERVAL] = metadata.GetSettingFromSettingsMap(settings, metadata.PipelineStatsIntervalKey, repSettings.StatsInterval)
+
+	return fileExportSettings, nil
+}
+
 func (xdcrf *XDCRFactory) getTargetTimeoutEstimate(topic string) time.Duration {
 	//TODO: implement
 	//need to get the tcp ping time for the estimate
@@ -1053,6 +1192,11 @@ func (xdcrf *XDCRFactory) disableCollectionIfNeeded(incomingSettings, filteredSe
 		return nil
 	}
 
+	if spec.IsFileExport() {
+		// every source collection is exported
+		return nil
+	}
+
 	// Check to see if remote side supports collections
 	ref, err := xdcrf.remote_cluster_svc.RemoteClusterByUuid(spec.TargetClusterUUID, false /*refresh*/)
 	if err != nil {
@@ -1103,10 +1247,29 @@ func (xdcrf *XDCRFactory) constructSettingsForDcpNozzle(pipeline common.Pipeline
 		}
 	}
 
+	if pipeline.Type() == common.MainPipeline && stopWhenCaughtUp(repSettings) {
+		dcpNozzleSettings[parts.DCP_StopWhenCaughtUp] = true
+	}
+
+	if pipeline.Type() == common.MainPipeline && sharedSourceStream(repSettings) {
+		dcpNozzleSettings[parts.DCP_SharedSourceStream] = true
+	}
+
 	constructSharedSettingsForDcpNozzle(settings, dcpNozzleSettings, repSettings)
 	return dcpNozzleSettings, nil
 }
 
+// The main pipeline of a collections migration streams with a default collection filter that never ends,
+// so it cannot stop when caught up
+func stopWhenCaughtUp(repSettings *metadata.ReplicationSettings) bool {
+	return repSettings.GetStopWhenCaughtUp() && !repSettings.GetCollectionModes().IsMigrationOn()
+}
+
+// Migration and stop when caught up pipelines filter or end their streams on their own, so they cannot share them
+func sharedSourceStream(repSettings *metadata.ReplicationSettings) bool {
+	return repSettings.GetSharedSourceStream() && !repSettings.GetCollectionModes().IsMigrationOn() && !stopWhenCaughtUp(repSettings)
+}
+
 func constructSharedSettingsForDcpNozzle(settings metadata.ReplicationSettingsMap, dcpNozzleSettings metadata.ReplicationSettingsMap, repSettings *metadata.ReplicationSettings) {
 	// dcp priority settings could have been set through replStatus.customSettings.
 	dcpPriority, ok := settings[parts.DCP_Priority]
@@ -1235,7 +1398,7 @@ func (xdcrf *XDCRFactory) constructSettingsForRouter(pipeline common.Pipeline, s
 func (xdcrf *XDCRFactory) registerServices(pipeline common.Pipeline, logger_ctx *log.LoggerContext,
 	kv_vb_map map[string][]uint16, targetUserName, targetPassword string, targetBucketName string,
 	target_kv_vb_map map[string][]uint16, targetClusterRef *metadata.RemoteClusterReference,
-	targetClusterVersion int, isCapi bool, mainPipeline *common.Pipeline, crMode base.ConflictResolutionMode) error {
+	targetClusterVersion int, nozzleType base.XDCROutgoingNozzleType, mainPipeline *common.Pipeline, crMode base.ConflictResolutionMode) error {
 
 	ctx := pipeline.RuntimeContext()
 	var parentCtx common.PipelineRuntimeContext
@@ -1254,8 +1417,8 @@ func (xdcrf *XDCRFactory) registerServices(pipeline common.Pipeline, logger_ctx
 
 	// Register ConflictManager after pipeline supervisor
 	if crMode == base.CRMode_Custom {
-		if isCapi {
-			return errors.New("Custom conflict resolution cannot be used with Capi nozzle.")
+		if nozzleType != base.Xmem {
+			return errors.New("Custom conflict resolution can only be used with Xmem nozzle.")
 		}
 		conflictMgr := pipeline_svc.NewConflictManager(xdcrf.resolverSvc, pipeline.Specification().GetReplicationSpec().Id, xdcrf.xdcr_topology_svc, xdcrf.utils)
 		err := ctx.RegisterService(base.CONFLICT_MANAGER_SVC, conflictMgr)
@@ -1267,6 +1430,32 @@ func (xdcrf *XDCRFactory) registerServices(pipeline common.Pipeline, logger_ctx
 		}
 	}
 
+	// Register ConflictLogger after pipeline supervisor. Only source side CR done by XMEM is logged
+	if nozzleType == base.Xmem && crMode != base.CRMode_Custom && pipeline.Specification().GetReplicationSpec().Settings.GetConflictLoggingEnabled() {
+		conflictLogger := pipeline_svc.NewConflictLogger(pipeline.Specification().GetReplicationSpec().Id, xdcrf.xdcr_topology_svc,
+			xdcrf.collectionsManifestSvc, xdcrf.bucketTopologySvc, xdcrf.utils)
+		err := ctx.RegisterService(base.CONFLICT_LOGGER_SVC, conflictLogger)
+		if err != nil {
+			return err
+		}
+		for _, target := range pipeline.Targets() {
+			target.(*parts.XmemNozzle).SetConflictLogger(conflictLogger)
+		}
+	}
+
+	// Register DeadLetterQueue after pipeline supervisor
+	if nozzleType == base.Xmem && pipeline.Specification().GetReplicationSpec().Settings.GetDeadLetterDest() != base.DeadLetterDestDisabled {
+		deadLetterQueue := pipeline_svc.NewDeadLetterQueue(pipeline.Specification().GetReplicationSpec().Id, xdcrf.remote_cluster_svc,
+			xdcrf.collectionsManifestSvc, xdcrf.bucketTopologySvc, xdcrf.uilog_svc, xdcrf.utils)
+		err := ctx.RegisterService(base.DEAD_LETTER_QUEUE_SVC, deadLetterQueue)
+		if err != nil {
+			return err
+		}
+		for _, target := range pipeline.Targets() {
+			target.(*parts.XmemNozzle).SetDeadLetterQueue(deadLetterQueue)
+		}
+	}
+
 	// Register BackfillMgr as a pipeline service
 	backfillMgrPipelineSvc := xdcrf.getBackfillMgr().GetPipelineSvc()
 	err = ctx.RegisterService(base.BACKFILL_MGR_SVC, backfillMgrPipelineSvc)
@@ -1305,6 +1494,26 @@ func (xdcrf *XDCRFactory) registerServices(pipeline common.Pipeline, logger_ctx
 		return err
 	}
 
+	// Only the main pipeline stops when caught up. Backfill pipelines already end at their tasks' end seqnos
+	if mainPipeline == nil && stopWhenCaughtUp(pipeline.Specification().GetReplicationSpec().Settings) {
+		caughtUpMonitor := pipeline_svc.NewCaughtUpMonitor(pipeline.Specification().GetReplicationSpec().Id, through_seqno_tracker_svc,
+			xdcrf.checkpoint_svc, xdcrf.xdcr_topology_svc, xdcrf.bucketTopologySvc, logger_ctx)
+		err = ctx.RegisterService(base.CAUGHT_UP_MONITOR_SVC, caughtUpMonitor)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Bulk deletions are the user's doing and flow through the main pipeline. Backfill pipelines are paused along with it
+	if mainPipeline == nil && pipeline.Specification().GetReplicationSpec().Settings.IsBulkDeleteBreakerEnabled() {
+		bulkDeleteBreaker := pipeline_svc.NewBulkDeleteBreaker(pipeline.Specification().GetReplicationSpec().Id,
+			pipeline.Specification().GetReplicationSpec().Settings, logger_ctx)
+		err = ctx.RegisterService(base.BULK_DELETE_BREAKER_SVC, bulkDeleteBreaker)
+		if err != nil {
+			return err
+		}
+	}
+
 	// register sharable topology change detect service
 	var top_detect_svc *pipeline_svc.TopologyChangeDetectorSvc
 	if mainPipeline != nil {
@@ -1321,7 +1530,7 @@ func (xdcrf *XDCRFactory) registerServices(pipeline common.Pipeline, logger_ctx
 		return err
 	}
 
-	if !isCapi {
+	if nozzleType == base.Xmem {
 		var bw_throttler_svc *pipeline_svc.BandwidthThrottler
 		if mainPipeline != nil {
 			var ok bool
@@ -1474,7 +1683,7 @@ func (xdcrf *XDCRFactory) ConstructSSLPortMap(targetClusterRef *metadata.RemoteC
 	// if both xmem nozzles and ssl are involved, populate ssl_port_map
 	// if target cluster is post-3.0, the ssl ports in the map are memcached ssl ports
 	// otherwise, the ssl ports in the map are proxy ssl ports
-	if targetClusterRef.IsFullEncryption() && nozzleType == base.Xmem {
+	if nozzleType == base.Xmem && targetClusterRef.IsFullEncryption() {
 
 		username, password, httpAuthMech, certificate, sanInCertificate, clientCertificate, clientKey, err := targetClusterRef.MyCredentials()
 		if err != nil {
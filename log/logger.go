@@ -101,6 +101,11 @@ func CopyCtx(ctx_to_copy *LoggerContext) *LoggerContext {
 
 var DefaultLoggerContext *LoggerContext
 
+// runtime logging parameters, remembered so that other rotating log files can be created next to xdcr.log
+var logFileDir string
+var logFileMaxSize uint64
+var logFileMaxNumber uint64
+
 // before logging paramters become available, direct all logging to stdout
 func init() {
 	logWriters := make(map[LogLevel]*LogWriter)
@@ -120,7 +125,11 @@ func init() {
 
 // re-initializes default logger context with runtime logging parameters
 // log entries will be written to log files after this point
-func Init(logFileDir string, maxLogFileSize, maxNumberOfLogFiles uint64) error {
+func Init(logDir string, maxLogFileSize, maxNumberOfLogFiles uint64) error {
+	logFileDir = logDir
+	logFileMaxSize = maxLogFileSize
+	logFileMaxNumber = maxNumberOfLogFiles
+
 	// xdcr log file
 	xdcrLogFilePath := filepath.Join(logFileDir, XdcrLogFileName)
 	xdcrLogWriter, err := NewRotatingLogFileWriter(xdcrLogFilePath, maxLogFileSize, maxNumberOfLogFiles)
@@ -153,6 +162,22 @@ func Init(logFileDir string, maxLogFileSize, maxNumberOfLogFiles uint64) error {
 	return nil
 }
 
+// creates a rotating log file in the xdcr log directory, with the same size and rotation limits as xdcr.log
+func NewRotatingLogFileWriterInLogDir(fileName string) (*RotatingLogFileWriter, error) {
+	if logFileDir == "" {
+		return nil, errors.New("log file directory has not been initialized")
+	}
+	return NewRotatingLogFileWriter(filepath.Join(logFileDir, fileName), logFileMaxSize, logFileMaxNumber)
+}
+
+// returns the path of a file in the xdcr log directory, for files that are written without rotation
+func FilePathInLogDir(fileName string) (string, error) {
+	if logFileDir == "" {
+		return "", errors.New("log file directory has not been initialized")
+	}
+	return filepath.Join(logFileDir, fileName), nil
+}
+
 func NewLogger(module string, logger_context *LoggerContext) *CommonLogger {
 	context := DefaultLoggerContext
 	if logger_context != nil {
@@ -42,6 +42,17 @@ var options struct {
 	logFileDir          string
 	maxLogFileSize      uint64
 	maxNumberOfLogFiles uint64
+
+	// archive replay related parameters
+	replayArchiveDir    string // directory written by a file export replication. when set, xdcr replays it and exits
+	replayRemoteCluster string // name of the remote cluster reference to replay to
+	replayTargetBucket  string
+	replayResumeFrom    string // position printed by a previous replay
+	replayNamespaces    string // comma separated target scope.collection or scope names. empty means all
+
+	localMergeEngine bool // whether custom CR uses the in-process merge engine instead of the javascript evaluator
+
+	metadataDir string // directory of the local metadata store. when set, metadata is kept there instead of in metakv
 }
 
 var max_retry_wait_for_metadata_service = 30
@@ -73,6 +84,23 @@ func argParse() {
 
 	flag.StringVar(&options.caFileLocation, "caFile", "",
 		"location of the cluster CA file")
+
+	flag.StringVar(&options.replayArchiveDir, "replayArchiveDir", "",
+		"archive directory of a file export replication to replay into a target bucket")
+	flag.StringVar(&options.replayRemoteCluster, "replayRemoteCluster", "",
+		"name of the remote cluster reference to replay the archive to")
+	flag.StringVar(&options.replayTargetBucket, "replayTargetBucket", "",
+		"bucket to replay the archive to")
+	flag.StringVar(&options.replayResumeFrom, "replayResumeFrom", "",
+		"archive position to resume a replay from, as printed by a previous replay")
+	flag.StringVar(&options.replayNamespaces, "replayNamespaces", "",
+		"comma separated list of target scope.collection or scope names to replay. all are replayed when empty")
+
+	flag.BoolVar(&options.localMergeEngine, "localMergeEngine", false,
+		"whether custom conflict resolution uses the in-process merge engine instead of the javascript evaluator")
+
+	flag.StringVar(&options.metadataDir, "metadataDir", "",
+		"directory to keep metadata in instead of metakv, for running xdcr without the cluster manager")
 	flag.Parse()
 }
 
@@ -139,10 +167,21 @@ func main() {
 
 	host := top_svc.GetLocalHostName()
 
-	metakv_svc, err := metadata_svc.NewMetaKVMetadataSvc(nil, utils, false /*readOnly*/)
-	if err != nil {
-		fmt.Printf("Error starting metadata service. err=%v\n", err)
-		os.Exit(1)
+	var metakv_svc service_def.MetadataSvc
+	if options.metadataDir != "" {
+		file_metadata_svc, err := metadata_svc.NewFileMetadataSvc(options.metadataDir, nil)
+		if err != nil {
+			fmt.Printf("Error starting metadata service in %v. err=%v\n", options.metadataDir, err)
+			os.Exit(1)
+		}
+		rm.SetMetadataObserver(file_metadata_svc.RunObserveChildren)
+		metakv_svc = file_metadata_svc
+	} else {
+		metakv_svc, err = metadata_svc.NewMetaKVMetadataSvc(nil, utils, false /*readOnly*/)
+		if err != nil {
+			fmt.Printf("Error starting metadata service. err=%v\n", err)
+			os.Exit(1)
+		}
 	}
 
 	err = waitForMetadataService(metakv_svc)
@@ -164,6 +203,15 @@ func main() {
 	processSetting_svc := metadata_svc.NewGlobalSettingsSvc(metakv_svc, nil)
 	bucketSettings_svc := metadata_svc.NewBucketSettingsService(metakv_svc, top_svc, nil, utils)
 
+	if options.replayArchiveDir != "" {
+		err = replayArchive(metakv_svc, top_svc, utils)
+		if err != nil {
+			fmt.Printf("Error replaying archive %v. err=%v\n", options.replayArchiveDir, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	if options.isConvert {
 		// disable uilogging during upgrade by specifying a nil uilog service
 		remote_cluster_svc, err := metadata_svc.NewRemoteClusterService(nil, metakv_svc, top_svc, nil, utils)
@@ -201,7 +249,12 @@ func main() {
 			fmt.Printf("Error starting remote cluster service. err=%v\n", err)
 			os.Exit(1)
 		}
-		resolver_svc := service_impl.NewResolverSvc(top_svc)
+		var resolver_svc service_def.ResolverSvcIface
+		if options.localMergeEngine {
+			resolver_svc = service_impl.NewLocalResolverSvc(metakv_svc)
+		} else {
+			resolver_svc = service_impl.NewResolverSvc(top_svc)
+		}
 
 		replicationSettingSvc := metadata_svc.NewReplicationSettingsSvc(metakv_svc, nil, top_svc)
 
@@ -262,6 +315,7 @@ func main() {
 			processSetting_svc,
 			bucketSettings_svc,
 			internalSettings_svc,
+			metadata_svc.NewSettingsHistorySvc(metakv_svc, nil),
 			service_impl.NewThroughputThrottlerSvc(nil),
 			resolver_svc,
 			utils,
@@ -0,0 +1,213 @@
+// Copyright 2013-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included in
+// the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+// file, in accordance with the Business Source License, use of this software
+// will be governed by the Apache License, Version 2.0, included in the file
+// licenses/APL2.txt.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	base "github.com/couchbase/goxdcr/base"
+	common "github.com/couchbase/goxdcr/common"
+	log "github.com/couchbase/goxdcr/log"
+	"github.com/couchbase/goxdcr/metadata"
+	"github.com/couchbase/goxdcr/metadata_svc"
+	"github.com/couchbase/goxdcr/parts"
+	"github.com/couchbase/goxdcr/pipeline_svc"
+	"github.com/couchbase/goxdcr/service_def"
+	utilities "github.com/couchbase/goxdcr/utils"
+)
+
+const replayNozzleNamePrefix = "xmem_replay"
+
+// Replays an archive written by a file export replication into a bucket of a remote cluster.
+// The remote cluster needs to have been added to this cluster as a remote cluster reference
+func replayArchive(metakv_svc service_def.MetadataSvc, top_svc service_def.XDCRCompTopologySvc, utils utilities.UtilsIface) error {
+	logger := log.NewLogger("ArchiveReplay", log.DefaultLoggerContext)
+
+	if options.replayRemoteCluster == "" || options.replayTargetBucket == "" {
+		return errors.New("replayRemoteCluster and replayTargetBucket are required when replayArchiveDir is specified")
+	}
+	from, err := parts.ParseFileExportArchivePosition(options.replayResumeFrom)
+	if err != nil {
+		return err
+	}
+	var namespaceFilter []string
+	if options.replayNamespaces != "" {
+		namespaceFilter = strings.Split(options.replayNamespaces, ",")
+	}
+
+	// disable uilogging since this is not a long running xdcr process
+	remote_cluster_svc, err := metadata_svc.NewRemoteClusterService(nil, metakv_svc, top_svc, nil, utils)
+	if err != nil {
+		return err
+	}
+	ref, err := remote_cluster_svc.RemoteClusterByRefName(options.replayRemoteCluster, true /*refresh*/)
+	if err != nil {
+		return err
+	}
+
+	bucketName := options.replayTargetBucket
+	connStr, err := ref.MyConnectionStr()
+	if err != nil {
+		return err
+	}
+	username, password, httpAuthMech, certificate, sanInCertificate, clientCertificate, clientKey, err := ref.MyCredentials()
+	if err != nil {
+		return err
+	}
+	useExternal, err := remote_cluster_svc.ShouldUseAlternateAddress(ref)
+	if err != nil {
+		return err
+	}
+
+	targetBucketInfo, err := utils.GetBucketInfo(connStr, bucketName, username, password, httpAuthMech, certificate, sanInCertificate, clientCertificate, clientKey, logger)
+	if err != nil {
+		return err
+	}
+	targetBucketUuid, err := utils.GetBucketUuidFromBucketInfo(bucketName, targetBucketInfo, logger)
+	if err != nil {
+		return err
+	}
+	conflictResolutionType, err := utils.GetConflictResolutionTypeFromBucketInfo(bucketName, targetBucketInfo)
+	if err != nil {
+		return err
+	}
+	crMode := base.GetCRModeFromConflictResolutionTypeSetting(conflictResolutionType)
+	kvVBMap, err := utils.GetRemoteServerVBucketsMap(ref.HostName(), bucketName, targetBucketInfo, useExternal)
+	if err != nil {
+		return err
+	}
+	if len(kvVBMap) == 0 {
+		return base.ErrorNoTargetNozzle
+	}
+
+	var ssl_port_map base.SSLPortMap
+	if ref.IsFullEncryption() {
+		ssl_port_map, err = utils.GetMemcachedSSLPortMap(connStr, username, password, httpAuthMech, certificate, sanInCertificate,
+			clientCertificate, clientKey, bucketName, logger, useExternal)
+		if err != nil {
+			return err
+		}
+	}
+
+	capability, err := waitForRemoteClusterCapability(remote_cluster_svc, ref, utils)
+	if err != nil {
+		return err
+	}
+	var targetManifest *metadata.CollectionsManifest
+	if capability.HasCollectionSupport() {
+		targetManifest, err = remote_cluster_svc.GetManifestByUuid(ref.Uuid(), bucketName, true /*forceRefresh*/, true /*restAPIQuery*/)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the bandwidth throttler is never started, which means no bandwidth limit
+	bandwidthThrottler := pipeline_svc.NewBandwidthThrottlerSvc(top_svc, log.DefaultLoggerContext)
+	vbNozzleMap := make(map[uint16]common.Nozzle)
+	xmemNozzles := make([]*parts.XmemNozzle, 0, len(kvVBMap))
+	for kvaddr, vbList := range kvVBMap {
+		id := fmt.Sprintf("%v_%v", replayNozzleNamePrefix, kvaddr)
+		xmem := parts.NewXmemNozzle(id, remote_cluster_svc, "" /*sourceClusterUuid*/, ref.Uuid(), replayNozzleNamePrefix, replayNozzleNamePrefix,
+			2 /*connPoolSize*/, kvaddr, "" /*sourceBucketName*/, bucketName, targetBucketUuid, username, password, crMode,
+			log.DefaultLoggerContext, utils, vbList)
+		xmem.SetBandwidthThrottler(bandwidthThrottler)
+		xmemNozzles = append(xmemNozzles, xmem)
+		for _, vbno := range vbList {
+			vbNozzleMap[vbno] = xmem
+		}
+	}
+
+	reader, err := parts.NewFileExportArchiveReader(options.replayArchiveDir, from, logger)
+	if err != nil {
+		return err
+	}
+	replayer, err := parts.NewFileExportReplayer(replayNozzleNamePrefix, reader, vbNozzleMap, crMode, targetManifest, namespaceFilter, logger)
+	if err != nil {
+		reader.Close()
+		return err
+	}
+
+	defer func() {
+		for _, xmem := range xmemNozzles {
+			if xmem.State() == common.Part_Running || xmem.State() == common.Part_Error {
+				xmem.Stop()
+			}
+		}
+	}()
+	for _, xmem := range xmemNozzles {
+		settings, err := constructReplayXmemSettings(xmem, ref, ssl_port_map)
+		if err != nil {
+			reader.Close()
+			return err
+		}
+		err = xmem.Start(settings)
+		if err != nil {
+			reader.Close()
+			return err
+		}
+	}
+
+	logger.Infof("Replaying %v from %v into bucket %v of %v with conflict resolution %v, namespaces=%v", options.replayArchiveDir, from, bucketName, ref.Name(), conflictResolutionType, namespaceFilter)
+	pos, err := replayer.Run(func(pos parts.FileExportArchivePosition) {
+		fmt.Printf("Replayed up to %v\n", pos)
+	})
+	if err != nil {
+		fmt.Printf("Replay stopped. Use -replayResumeFrom=%v to resume\n", pos)
+		return err
+	}
+	fmt.Printf("Replay of %v is done\n", options.replayArchiveDir)
+	return nil
+}
+
+// The remote cluster agents retrieve the capability of the remote clusters in the background after they are loaded
+func waitForRemoteClusterCapability(remote_cluster_svc service_def.RemoteClusterSvc, ref *metadata.RemoteClusterReference, utils utilities.UtilsIface) (metadata.Capability, error) {
+	var capability metadata.Capability
+	getCapabilityOp := func() error {
+		var err error
+		capability, err = remote_cluster_svc.GetCapability(ref)
+		if err == nil && !capability.HasInitialized() {
+			err = fmt.Errorf("Capability of remote cluster %v has not been retrieved yet", ref.Name())
+		}
+		return err
+	}
+	err := utils.ExponentialBackoffExecutor("GetRemoteClusterCapability", time.Second, base.MaxNumOfMetakvRetries, base.MetaKvBackoffFactor, getCapabilityOp)
+	return capability, err
+}
+
+// Same as what the factory constructs for the xmem nozzles of a replication with default settings
+func constructReplayXmemSettings(xmem *parts.XmemNozzle, ref *metadata.RemoteClusterReference, ssl_port_map base.SSLPortMap) (metadata.ReplicationSettingsMap, error) {
+	repSettings := metadata.DefaultReplicationSettings()
+	settings := make(metadata.ReplicationSettingsMap)
+
+	settings[parts.SETTING_BATCHCOUNT] = repSettings.BatchCount
+	settings[parts.SETTING_BATCHSIZE] = repSettings.BatchSize
+	settings[parts.SETTING_RESP_TIMEOUT] = base.XmemDefaultRespTimeout
+	settings[parts.SETTING_BATCH_EXPIRATION_TIME] = time.Duration(float64(repSettings.MaxExpectedReplicationLag)*0.7) * time.Millisecond
+	settings[parts.SETTING_OPTI_REP_THRESHOLD] = repSettings.OptimisticReplicationThreshold
+	settings[parts.SETTING_STATS_INTERVAL] = repSettings.StatsInterval
+	settings[parts.SETTING_COMPRESSION_TYPE] = base.CompressionTypeSnappy
+
+	settings[parts.XMEM_SETTING_DEMAND_ENCRYPTION] = ref.DemandEncryption()
+	settings[parts.XMEM_SETTING_CERTIFICATE] = ref.Certificates()
+	settings[parts.XMEM_SETTING_CLIENT_CERTIFICATE] = ref.ClientCertificate()
+	settings[parts.XMEM_SETTING_CLIENT_KEY] = ref.ClientKey()
+	settings[parts.XMEM_SETTING_ENCRYPTION_TYPE] = ref.EncryptionType()
+	if ref.IsFullEncryption() {
+		mem_ssl_port, ok := ssl_port_map[xmem.ConnStr()]
+		if !ok {
+			return nil, fmt.Errorf("Can't get remote memcached ssl port for %v", xmem.ConnStr())
+		}
+		settings[parts.XMEM_SETTING_REMOTE_MEM_SSL_PORT] = mem_ssl_port
+		settings[parts.XMEM_SETTING_SAN_IN_CERITICATE] = ref.SANInCertificate()
+	}
+	return settings, nil
+}
@@ -74,6 +74,12 @@ func (c *Capability) LoadFromDefaultPoolInfo(defaultPoolInfo map[string]interfac
 	return nil
 }
 
+// File export replications have no remote cluster. Every source collection is exported as is,
+// as if to a target that supports collections
+func FileExportCapability() Capability {
+	return Capability{1, 1}
+}
+
 // Unit Test Only
 func UnitTestGetDefaultCapability() Capability {
 	return Capability{1, 0}
@@ -10,6 +10,7 @@ package metadata
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	mcc "github.com/couchbase/gomemcached/client"
 	"github.com/couchbase/goxdcr/base"
@@ -748,3 +749,101 @@ func (c *CheckpointsDoc) Len() int {
 	}
 	return c.Checkpoint_records.Len()
 }
+
+// Returns the non nil records, from the newest to the oldest
+func (c *CheckpointsDoc) validRecords() CheckpointRecordsList {
+	var records CheckpointRecordsList
+	for _, record := range c.Checkpoint_records {
+		if record != nil {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// Drops the records that are newer than the given one, counting from the newest record at index 0,
+// so that the VB resumes from that record at best
+func (c *CheckpointsDoc) RewindToRecord(index int) error {
+	records := c.validRecords()
+	if index < 0 || index >= len(records) {
+		return fmt.Errorf("checkpoint index %v is out of range. There are %v checkpoints", index, len(records))
+	}
+	c.Checkpoint_records = records[index:]
+	return nil
+}
+
+// Replaces the records that are newer than the given source seqno with one that resumes the VB from that seqno.
+// The new record takes the source failover UUID, the target VB opaque and the manifests of the newest record,
+// which remain valid for an earlier seqno as long as the newest record does
+func (c *CheckpointsDoc) RewindToSeqno(seqno uint64, creationTime uint64) error {
+	records := c.validRecords()
+	if len(records) == 0 {
+		return errors.New("there are no checkpoints to rewind")
+	}
+	newest := records[0]
+	if seqno > newest.Seqno {
+		return fmt.Errorf("seqno %v is after the newest checkpoint at seqno %v", seqno, newest.Seqno)
+	}
+
+	record, err := NewCheckpointRecord(newest.Failover_uuid, seqno, seqno, seqno, newest.Target_Seqno,
+		newest.Filtered_Items_Cnt, newest.Filtered_Failed_Cnt, newest.SourceManifestForDCP, newest.SourceManifestForBackfillMgr,
+		newest.TargetManifest, *newest.BrokenMappings(), creationTime)
+	if err != nil {
+		return err
+	}
+	record.Target_vb_opaque = newest.Target_vb_opaque
+
+	rewound := CheckpointRecordsList{record}
+	for _, olderRecord := range records {
+		if olderRecord.Seqno <= seqno && len(rewound) < base.MaxCheckpointRecordsToKeep {
+			rewound = append(rewound, olderRecord)
+		}
+	}
+	c.Checkpoint_records = rewound
+	return nil
+}
+
+// The checkpoints of a replication in a portable form, so that they can be imported into the replication of the same
+// source and target data on another cluster. The broken mappings that the checkpoint records refer to by SHA are
+// carried along with them
+type CheckpointsExport struct {
+	SourceBucketName  string                     `json:"sourceBucketName"`
+	TargetBucketName  string                     `json:"targetBucketName"`
+	ExportTime        int64                      `json:"exportTime"`
+	CheckpointsDocs   map[uint16]*CheckpointsDoc `json:"checkpointsDocs"`
+	BrokenMappingsDoc *CollectionNsMappingsDoc   `json:"brokenMappingsDoc,omitempty"`
+}
+
+// ckptDocs need to have been loaded with their broken mappings
+func NewCheckpointsExport(spec *ReplicationSpecification, ckptDocs map[uint16]*CheckpointsDoc, exportTime int64) (*CheckpointsExport, error) {
+	ckptsExport := &CheckpointsExport{
+		SourceBucketName: spec.SourceBucketName,
+		TargetBucketName: spec.TargetBucketName,
+		ExportTime:       exportTime,
+		CheckpointsDocs:  make(map[uint16]*CheckpointsDoc),
+	}
+
+	shaMap := make(ShaToCollectionNamespaceMap)
+	for vbno, ckptDoc := range ckptDocs {
+		if ckptDoc == nil {
+			continue
+		}
+		records := ckptDoc.validRecords()
+		for _, record := range records {
+			if record.BrokenMappingSha256 != "" {
+				shaMap[record.BrokenMappingSha256] = record.BrokenMappings()
+			}
+		}
+		// revisions and internal IDs are those of the exporting cluster
+		ckptsExport.CheckpointsDocs[vbno] = &CheckpointsDoc{Checkpoint_records: records}
+	}
+
+	if len(shaMap) > 0 {
+		ckptsExport.BrokenMappingsDoc = &CollectionNsMappingsDoc{}
+		err := ckptsExport.BrokenMappingsDoc.LoadShaMap(shaMap)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ckptsExport, nil
+}
@@ -14,6 +14,7 @@ import (
 	"encoding/json"
 	"fmt"
 	mcc "github.com/couchbase/gomemcached/client"
+	"github.com/couchbase/goxdcr/base"
 	"github.com/stretchr/testify/assert"
 	"sort"
 	"testing"
@@ -128,3 +129,98 @@ func TestCheckpointSortByFailoverLog(t *testing.T) {
 	assert.Equal(validFailoverLog, outputList[0].Failover_uuid)
 	assert.Equal(validFailoverLog2, outputList[1].Failover_uuid)
 }
+
+func TestCheckpointDocRewind(t *testing.T) {
+	fmt.Println("============== Test case start: TestCheckpointDocRewind =================")
+	defer fmt.Println("============== Test case end: TestCheckpointDocRewind =================")
+	assert := assert.New(t)
+
+	newDoc := func() *CheckpointsDoc {
+		doc := NewCheckpointsDoc("testInternalId")
+		for _, seqno := range []uint64{100, 200, 300} {
+			record, err := NewCheckpointRecord(uint64(seqno/100), seqno, seqno, seqno, seqno+1, 0, 0, 1, 1, 2, nil, 0)
+			assert.Nil(err)
+			record.Target_vb_opaque = &TargetVBUuidAndTimestamp{Target_vb_uuid: fmt.Sprintf("%v", seqno), Startup_time: "012"}
+			doc.AddRecord(record)
+		}
+		return doc
+	}
+
+	doc := newDoc()
+	assert.NotNil(doc.RewindToRecord(3))
+	assert.Nil(doc.RewindToRecord(1))
+	assert.Equal(2, doc.Len())
+	assert.Equal(uint64(200), doc.GetCheckpointRecords()[0].Seqno)
+	assert.Equal(uint64(100), doc.GetCheckpointRecords()[1].Seqno)
+
+	doc = newDoc()
+	assert.NotNil(doc.RewindToSeqno(301, 0))
+	assert.Nil(doc.RewindToSeqno(150, 0))
+	assert.Equal(2, doc.Len())
+	rewound := doc.GetCheckpointRecords()[0]
+	assert.Equal(uint64(150), rewound.Seqno)
+	assert.Equal(uint64(150), rewound.Dcp_snapshot_seqno)
+	assert.Equal(uint64(150), rewound.Dcp_snapshot_end_seqno)
+	// the failover UUID and the target VB opaque are the ones of the newest record
+	assert.Equal(uint64(3), rewound.Failover_uuid)
+	assert.Equal(uint64(301), rewound.Target_Seqno)
+	assert.True(rewound.Target_vb_opaque.IsSame(&TargetVBUuidAndTimestamp{Target_vb_uuid: "300", Startup_time: "012"}))
+	assert.Equal(uint64(100), doc.GetCheckpointRecords()[1].Seqno)
+
+	assert.NotNil(NewCheckpointsDoc("testInternalId").RewindToSeqno(0, 0))
+}
+
+func TestCheckpointsExport(t *testing.T) {
+	fmt.Println("============== Test case start: TestCheckpointsExport =================")
+	defer fmt.Println("============== Test case end: TestCheckpointsExport =================")
+	assert := assert.New(t)
+
+	brokenMapping := make(CollectionNamespaceMapping)
+	brokenNamespace := &base.CollectionNamespace{ScopeName: "S1", CollectionName: "C1"}
+	brokenMapping.AddSingleMapping(brokenNamespace, brokenNamespace)
+
+	ckptDocs := make(map[uint16]*CheckpointsDoc)
+	for vbno := uint16(0); vbno < 2; vbno++ {
+		doc := NewCheckpointsDoc("testInternalId")
+		record, err := NewCheckpointRecord(1, 100, 100, 100, 101, 0, 0, 1, 1, 2, nil, 0)
+		assert.Nil(err)
+		record.Target_vb_opaque = &TargetVBUuid{Target_vb_uuid: 5}
+		doc.AddRecord(record)
+		if vbno == 1 {
+			record, err = NewCheckpointRecord(1, 200, 200, 200, 201, 0, 0, 1, 1, 2, brokenMapping, 0)
+			assert.Nil(err)
+			record.Target_vb_opaque = &TargetVBUuid{Target_vb_uuid: 5}
+			doc.AddRecord(record)
+		}
+		ckptDocs[vbno] = doc
+	}
+
+	spec, err := NewReplicationSpecification("srcBucket", "srcUuid", "tgtClusterUuid", "tgtBucket", "tgtUuid")
+	assert.Nil(err)
+	ckptsExport, err := NewCheckpointsExport(spec, ckptDocs, 1234)
+	assert.Nil(err)
+
+	marshalledData, err := json.Marshal(ckptsExport)
+	assert.Nil(err)
+	var checkExport CheckpointsExport
+	assert.Nil(json.Unmarshal(marshalledData, &checkExport))
+
+	assert.Equal("srcBucket", checkExport.SourceBucketName)
+	assert.Equal("tgtBucket", checkExport.TargetBucketName)
+	assert.Len(checkExport.CheckpointsDocs, 2)
+	assert.Equal(1, checkExport.CheckpointsDocs[0].Len())
+	assert.Equal("", checkExport.CheckpointsDocs[0].SpecInternalId)
+	assert.Equal(2, checkExport.CheckpointsDocs[1].Len())
+	newest := checkExport.CheckpointsDocs[1].GetCheckpointRecords()[0]
+	assert.Equal(uint64(200), newest.Seqno)
+	assert.True(newest.Target_vb_opaque.IsSame(&TargetVBUuid{Target_vb_uuid: 5}))
+
+	// only the broken mappings that the records refer to are exported
+	assert.NotNil(checkExport.BrokenMappingsDoc)
+	shaMap, err := checkExport.BrokenMappingsDoc.ToShaMap()
+	assert.Nil(err)
+	assert.Len(shaMap, 1)
+	exportedMapping, exists := shaMap[newest.BrokenMappingSha256]
+	assert.True(exists)
+	assert.True(exportedMapping.IsSame(brokenMapping))
+}
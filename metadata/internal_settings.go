@@ -261,6 +261,10 @@ const (
 	ThroughSeqnoBgScannerLogFreqKey = "ThroughSeqnoBgScannerLogFreqSec"
 
 	PipelineTimeoutP2PProtocolKey = "PipelineTimeoutP2PProtocolSec"
+
+	CollectionStatsMaxEntriesKey = "CollectionStatsMaxEntries"
+
+	FileExportRootDirKey = "FileExportRootDir"
 )
 
 var TopologyChangeCheckIntervalConfig = &SettingsConfig{10, &Range{1, 100}}
@@ -374,6 +378,8 @@ var P2PVBRelatedGCIntervalConfig = &SettingsConfig{int(base.P2PVBRelatedGCInterv
 var ThroughSeqnoBgScannerFreqConfig = &SettingsConfig{int(base.ThroughSeqnoBgScannerFreq / time.Second), &Range{1, 300}}
 var ThroughSeqnoBgScannerLogFreqConfig = &SettingsConfig{int(base.ThroughSeqnoBgScannerLogFreq / time.Second), &Range{1, 300}}
 var PipelineTimeoutP2PProtocolConfig = &SettingsConfig{int(base.TimeoutP2PProtocol / time.Second), &Range{10, 300}}
+var CollectionStatsMaxEntriesConfig = &SettingsConfig{base.CollectionStatsMaxEntries, &Range{0, 100000}}
+var FileExportRootDirConfig = &SettingsConfig{base.FileExportRootDir, nil}
 
 var XDCRInternalSettingsConfigMap = map[string]*SettingsConfig{
 	TopologyChangeCheckIntervalKey:                TopologyChangeCheckIntervalConfig,
@@ -487,6 +493,8 @@ var XDCRInternalSettingsConfigMap = map[string]*SettingsConfig{
 	ThroughSeqnoBgScannerFreqKey:                  ThroughSeqnoBgScannerFreqConfig,
 	ThroughSeqnoBgScannerLogFreqKey:               ThroughSeqnoBgScannerLogFreqConfig,
 	PipelineTimeoutP2PProtocolKey:                 PipelineTimeoutP2PProtocolConfig,
+	CollectionStatsMaxEntriesKey:                  CollectionStatsMaxEntriesConfig,
+	FileExportRootDirKey:                          FileExportRootDirConfig,
 }
 
 func InitConstants(xmemMaxIdleCountLowerBound int, xmemMaxIdleCountUpperBound int) {
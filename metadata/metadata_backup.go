@@ -0,0 +1,248 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software
+will be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package metadata
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// version of the metadata backup format. Backups of a newer version than this cannot be restored
+const MetadataBackupVersion = 1
+
+const (
+	backupKeyLen        = 32
+	backupSaltLen       = 16
+	backupKDFIterations = 100000
+	// the iterations come from the backup on restore, so they are capped to bound the cost of deriving the key
+	backupMaxKDFIterations = 10 * backupKDFIterations
+)
+
+var ErrorBackupPassphrase = errors.New("The passphrase does not match the one the backup was taken with")
+
+// A backup of the XDCR metadata of a cluster
+type MetadataBackup struct {
+	Version           int       `json:"version"`
+	CreatedTime       time.Time `json:"createdTime"`
+	SourceClusterUUID string    `json:"sourceClusterUUID"`
+
+	// Set when the backup includes the secrets of the remote cluster references, which are encrypted with a key
+	// derived from a passphrase. Without it, the references have no password or client key
+	SecretsEncryption *BackupSecretsEncryption `json:"secretsEncryption,omitempty"`
+
+	RemoteClusters []*RemoteClusterBackup      `json:"remoteClusters"`
+	Replications   []*ReplicationSpecification `json:"replications"`
+
+	// setting values keyed by the internal setting keys
+	DefaultSettings  map[string]interface{} `json:"defaultSettings"`
+	GlobalSettings   map[string]interface{} `json:"globalSettings"`
+	InternalSettings map[string]interface{} `json:"internalSettings"`
+	// settings of the source buckets of the replications
+	BucketSettings []*BucketSettings `json:"bucketSettings"`
+
+	// the collection namespace mappings that the checkpoints of each replication refer to, keyed by replication id.
+	// They are restored into the replication that the one with the id is restored as
+	CollectionMappings map[string]*CollectionNsMappingsDoc `json:"collectionMappings,omitempty"`
+}
+
+type BackupSecretsEncryption struct {
+	// parameters of the PBKDF2 key derivation with HMAC-SHA256
+	Salt       []byte `json:"salt"`
+	Iterations int    `json:"iterations"`
+}
+
+type RemoteClusterBackup struct {
+	// the reference without its passwords and client keys
+	Reference *RemoteClusterReference `json:"reference"`
+	// the password and client key, encrypted with AES-GCM
+	EncryptedSecrets []byte `json:"encryptedSecrets,omitempty"`
+}
+
+type remoteClusterSecrets struct {
+	Password  string `json:"password"`
+	ClientKey []byte `json:"clientKey"`
+	// set when secondary credentials are staged for a rotation
+	SecondaryPassword  string `json:"secondaryPassword,omitempty"`
+	SecondaryClientKey []byte `json:"secondaryClientKey,omitempty"`
+}
+
+func NewMetadataBackup(sourceClusterUUID string, createdTime time.Time) *MetadataBackup {
+	return &MetadataBackup{
+		Version:            MetadataBackupVersion,
+		CreatedTime:        createdTime,
+		SourceClusterUUID:  sourceClusterUUID,
+		RemoteClusters:     make([]*RemoteClusterBackup, 0),
+		Replications:       make([]*ReplicationSpecification, 0),
+		BucketSettings:     make([]*BucketSettings, 0),
+		CollectionMappings: make(map[string]*CollectionNsMappingsDoc),
+	}
+}
+
+// SetRemoteClusters adds the references to the backup. Their secrets are only kept when a passphrase is given,
+// encrypted with a key derived from it
+func (backup *MetadataBackup) SetRemoteClusters(refs []*RemoteClusterReference, passphrase string) error {
+	var aead cipher.AEAD
+	if len(passphrase) > 0 {
+		backup.SecretsEncryption = &BackupSecretsEncryption{
+			Salt:       make([]byte, backupSaltLen),
+			Iterations: backupKDFIterations,
+		}
+		if _, err := rand.Read(backup.SecretsEncryption.Salt); err != nil {
+			return err
+		}
+		var err error
+		aead, err = backup.SecretsEncryption.newAEAD(passphrase)
+		if err != nil {
+			return err
+		}
+	} else {
+		backup.SecretsEncryption = nil
+	}
+
+	backup.RemoteClusters = make([]*RemoteClusterBackup, 0, len(refs))
+	for _, ref := range refs {
+		refBackup := &RemoteClusterBackup{Reference: ref.CloneForMetakvUpdate()}
+		secrets := &remoteClusterSecrets{Password: refBackup.Reference.Password_, ClientKey: refBackup.Reference.ClientKey_,
+			SecondaryPassword: refBackup.Reference.SecondaryPassword_, SecondaryClientKey: refBackup.Reference.SecondaryClientKey_}
+		refBackup.Reference.Password_ = ""
+		refBackup.Reference.ClientKey_ = nil
+		refBackup.Reference.SecondaryPassword_ = ""
+		refBackup.Reference.SecondaryClientKey_ = nil
+		if aead != nil {
+			secretsBytes, err := json.Marshal(secrets)
+			if err != nil {
+				return err
+			}
+			nonce := make([]byte, aead.NonceSize())
+			if _, err = rand.Read(nonce); err != nil {
+				return err
+			}
+			refBackup.EncryptedSecrets = aead.Seal(nonce, nonce, secretsBytes, []byte(ref.Name()))
+		}
+		backup.RemoteClusters = append(backup.RemoteClusters, refBackup)
+	}
+	return nil
+}
+
+// RemoteClusterReferences returns the references with the secrets decrypted using the passphrase.
+// The references of a backup without secrets have no password or client key, whatever the passphrase
+func (backup *MetadataBackup) RemoteClusterReferences(passphrase string) ([]*RemoteClusterReference, error) {
+	var aead cipher.AEAD
+	if backup.SecretsEncryption != nil {
+		if len(passphrase) == 0 {
+			return nil, fmt.Errorf("The secrets of the backup are encrypted, and a passphrase is needed to restore them")
+		}
+		var err error
+		aead, err = backup.SecretsEncryption.newAEAD(passphrase)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	refs := make([]*RemoteClusterReference, 0, len(backup.RemoteClusters))
+	for _, refBackup := range backup.RemoteClusters {
+		if refBackup == nil || refBackup.Reference == nil {
+			continue
+		}
+		ref := refBackup.Reference.CloneForMetakvUpdate()
+		if aead != nil && len(refBackup.EncryptedSecrets) > 0 {
+			if len(refBackup.EncryptedSecrets) < aead.NonceSize() {
+				return nil, fmt.Errorf("Secrets of remote cluster %v are corrupted", ref.Name())
+			}
+			nonce, ciphertext := refBackup.EncryptedSecrets[:aead.NonceSize()], refBackup.EncryptedSecrets[aead.NonceSize():]
+			secretsBytes, err := aead.Open(nil, nonce, ciphertext, []byte(ref.Name()))
+			if err != nil {
+				return nil, ErrorBackupPassphrase
+			}
+			secrets := &remoteClusterSecrets{}
+			if err = json.Unmarshal(secretsBytes, secrets); err != nil {
+				return nil, err
+			}
+			ref.Password_ = secrets.Password
+			ref.ClientKey_ = secrets.ClientKey
+			ref.SecondaryPassword_ = secrets.SecondaryPassword
+			ref.SecondaryClientKey_ = secrets.SecondaryClientKey
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+func (encryption *BackupSecretsEncryption) newAEAD(passphrase string) (cipher.AEAD, error) {
+	if len(encryption.Salt) == 0 || encryption.Iterations <= 0 || encryption.Iterations > backupMaxKDFIterations {
+		return nil, fmt.Errorf("Invalid secrets encryption parameters")
+	}
+	block, err := aes.NewCipher(pbkdf2SHA256([]byte(passphrase), encryption.Salt, encryption.Iterations, backupKeyLen))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// PBKDF2 as defined in RFC 8018, with HMAC-SHA256 as the pseudorandom function
+func pbkdf2SHA256(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	numBlocks := (keyLen + prf.Size() - 1) / prf.Size()
+	key := make([]byte, 0, numBlocks*prf.Size())
+	blockIndex := make([]byte, 4)
+	for block := 1; block <= numBlocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(blockIndex, uint32(block))
+		prf.Write(blockIndex)
+		u := prf.Sum(nil)
+		t := make([]byte, len(u))
+		copy(t, u)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
+
+// after the backup is unmarshalled, the setting values need to be converted back to the types of the settings
+func (backup *MetadataBackup) PostProcessAfterUnmarshalling() error {
+	if backup.Version > MetadataBackupVersion {
+		return fmt.Errorf("Backup version %v is newer than the latest supported version %v", backup.Version, MetadataBackupVersion)
+	}
+	for _, spec := range backup.Replications {
+		if spec != nil && spec.Settings != nil {
+			spec.Settings.PostProcessAfterUnmarshalling()
+		}
+	}
+	if backup.DefaultSettings != nil {
+		settings := &ReplicationSettings{Settings: &Settings{Values: backup.DefaultSettings}}
+		settings.PostProcessAfterUnmarshalling()
+	}
+	if backup.GlobalSettings != nil {
+		settings := &GlobalSettings{Settings: &Settings{Values: backup.GlobalSettings}}
+		settings.PostProcessAfterUnmarshalling()
+	}
+	if backup.InternalSettings != nil {
+		settings := &InternalSettings{Settings: &Settings{Values: backup.InternalSettings}}
+		settings.PostProcessAfterUnmarshalling()
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software
+will be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package metadata
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/couchbase/goxdcr/base"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetadataBackupSecrets(t *testing.T) {
+	fmt.Println("============== Test case start: TestMetadataBackupSecrets =================")
+	defer fmt.Println("============== Test case end: TestMetadataBackupSecrets =================")
+	assert := assert.New(t)
+
+	ref, err := NewRemoteClusterReference("remoteUuid", "remote", "127.0.0.1:8091", "admin", "password",
+		"", true, EncryptionType_Full, []byte("certificate"), []byte("clientCertificate"), []byte("clientKey"), nil)
+	assert.Nil(err)
+	ref.SetSecondaryCredentials(&base.ConnCredentials{ClientCertificate: []byte("newClientCertificate"), ClientKey: []byte("newClientKey")})
+
+	backup := NewMetadataBackup("localUuid", time.Now())
+	assert.Nil(backup.SetRemoteClusters([]*RemoteClusterReference{ref}, "passphrase"))
+	data, err := json.Marshal(backup)
+	assert.Nil(err)
+	assert.NotContains(string(data), "password")
+	assert.Nil(backup.RemoteClusters[0].Reference.SecondaryClientKey_)
+
+	restoredBackup := &MetadataBackup{}
+	assert.Nil(json.Unmarshal(data, restoredBackup))
+	assert.Nil(restoredBackup.PostProcessAfterUnmarshalling())
+
+	_, err = restoredBackup.RemoteClusterReferences("")
+	assert.NotNil(err)
+	_, err = restoredBackup.RemoteClusterReferences("wrong passphrase")
+	assert.Equal(ErrorBackupPassphrase, err)
+	refs, err := restoredBackup.RemoteClusterReferences("passphrase")
+	assert.Nil(err)
+	assert.Len(refs, 1)
+	assert.Equal("remoteUuid", refs[0].Uuid())
+	assert.Equal("password", refs[0].Password())
+	assert.Equal([]byte("clientKey"), refs[0].ClientKey())
+	assert.Equal([]byte("certificate"), refs[0].Certificates())
+	_, secondary := refs[0].ConnCredentials()
+	assert.Equal([]byte("newClientKey"), secondary.ClientKey)
+
+	// a backup cannot make the restore derive the key for an unbounded number of iterations
+	restoredBackup.SecretsEncryption.Iterations = backupMaxKDFIterations + 1
+	_, err = restoredBackup.RemoteClusterReferences("passphrase")
+	assert.NotNil(err)
+
+	// without a passphrase, the secrets are left out
+	assert.Nil(backup.SetRemoteClusters([]*RemoteClusterReference{ref}, ""))
+	assert.Nil(backup.SecretsEncryption)
+	refs, err = backup.RemoteClusterReferences("passphrase")
+	assert.Nil(err)
+	assert.Equal("", refs[0].Password())
+	assert.Nil(refs[0].ClientKey())
+	assert.Equal("password", ref.Password())
+}
+
+func TestMetadataBackupVersion(t *testing.T) {
+	fmt.Println("============== Test case start: TestMetadataBackupVersion =================")
+	defer fmt.Println("============== Test case end: TestMetadataBackupVersion =================")
+	assert := assert.New(t)
+
+	backup := NewMetadataBackup("localUuid", time.Now())
+	backup.InternalSettings = DefaultInternalSettings().Values
+	backup.Version = MetadataBackupVersion + 1
+	data, err := json.Marshal(backup)
+	assert.Nil(err)
+
+	restoredBackup := &MetadataBackup{}
+	assert.Nil(json.Unmarshal(data, restoredBackup))
+	assert.NotNil(restoredBackup.PostProcessAfterUnmarshalling())
+
+	restoredBackup.Version = MetadataBackupVersion
+	assert.Nil(restoredBackup.PostProcessAfterUnmarshalling())
+	assert.Equal(backup.InternalSettings, restoredBackup.InternalSettings)
+}
+
+func TestPbkdf2SHA256(t *testing.T) {
+	fmt.Println("============== Test case start: TestPbkdf2SHA256 =================")
+	defer fmt.Println("============== Test case end: TestPbkdf2SHA256 =================")
+	assert := assert.New(t)
+
+	// test vector from RFC 7914
+	assert.Equal("55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783",
+		hex.EncodeToString(pbkdf2SHA256([]byte("passwd"), []byte("salt"), 1, 64)))
+	assert.Len(pbkdf2SHA256([]byte("passwd"), []byte("salt"), 2, backupKeyLen), backupKeyLen)
+}
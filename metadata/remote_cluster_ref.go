@@ -68,6 +68,13 @@ type RemoteClusterReference struct {
 	ClientCertificate_ []byte `json:"ClientCertificate"`
 	ClientKey_         []byte `json:"ClientKey"`
 
+	// credentials staged while the credentials on target are being rotated. New connections to target fall back on
+	// them when the credentials above are rejected, so that pipelines do not need to be restarted
+	SecondaryUserName_          string `json:"SecondaryUserName,omitempty"`
+	SecondaryPassword_          string `json:"SecondaryPassword,omitempty"`
+	SecondaryClientCertificate_ []byte `json:"SecondaryClientCertificate,omitempty"`
+	SecondaryClientKey_         []byte `json:"SecondaryClientKey,omitempty"`
+
 	// these are hostname actually used to connect to target
 	// they are rotated among nodes in target cluster to achieve load balancing on target
 	// they are used to update HostName/HttpsHostName when HostName has been removed from the target cluster
@@ -281,6 +288,15 @@ func (ref *RemoteClusterReference) redactNoLock() *RemoteClusterReference {
 		if len(ref.ClientCertificate_) > 0 && !base.IsByteSliceRedacted(ref.ClientCertificate_) {
 			ref.ClientCertificate_ = base.TagUDBytes(ref.ClientCertificate_)
 		}
+		if len(ref.SecondaryUserName_) > 0 && !base.IsStringRedacted(ref.SecondaryUserName_) {
+			ref.SecondaryUserName_ = base.TagUD(ref.SecondaryUserName_)
+		}
+		if len(ref.SecondaryPassword_) > 0 && !base.IsStringRedacted(ref.SecondaryPassword_) {
+			ref.SecondaryPassword_ = base.TagUD(ref.SecondaryPassword_)
+		}
+		if len(ref.SecondaryClientCertificate_) > 0 && !base.IsByteSliceRedacted(ref.SecondaryClientCertificate_) {
+			ref.SecondaryClientCertificate_ = base.TagUDBytes(ref.SecondaryClientCertificate_)
+		}
 		// no need to redact ClientKey since it is always nil in this ref for redact
 	}
 	return ref
@@ -328,6 +344,12 @@ func (ref *RemoteClusterReference) ToMap() map[string]interface{} {
 	if len(ref.ClientCertificate_) > 0 {
 		outputMap[base.RemoteClusterClientCertificate] = string(ref.ClientCertificate_)
 	}
+	if len(ref.SecondaryUserName_) > 0 {
+		outputMap[base.RemoteClusterSecondaryUserName] = ref.SecondaryUserName_
+	}
+	if len(ref.SecondaryClientCertificate_) > 0 {
+		outputMap[base.RemoteClusterSecondaryClientCertificate] = string(ref.SecondaryClientCertificate_)
+	}
 	if ref.connectivityStatus != "" {
 		outputMap[base.ConnectivityStatus] = ref.connectivityStatus
 	}
@@ -379,6 +401,88 @@ func (ref *RemoteClusterReference) areUserSecurityCredentialsTheSameNoLock(ref2
 		bytes.Equal(ref.ClientCertificate_, ref2.ClientCertificate_) && bytes.Equal(ref.ClientKey_, ref2.ClientKey_)
 }
 
+func (ref *RemoteClusterReference) AreSecondaryCredentialsTheSame(ref2 *RemoteClusterReference) bool {
+	ref.mutex.RLock()
+	defer ref.mutex.RUnlock()
+	return ref.areSecondaryCredentialsTheSameNoLock(ref2)
+}
+
+func (ref *RemoteClusterReference) areSecondaryCredentialsTheSameNoLock(ref2 *RemoteClusterReference) bool {
+	if ref == nil {
+		return ref2 == nil
+	}
+	if ref2 == nil {
+		return false
+	}
+	ref2.mutex.RLock()
+	defer ref2.mutex.RUnlock()
+	return sameCredentials(ref.secondaryCredentialsNoLock(), ref2.secondaryCredentialsNoLock())
+}
+
+// IsCredentialRotation returns true if the change from ref to newRef is a step of a credential rotation, i.e.,
+// staging, replacing or dropping the secondary credentials, or retiring the primary credentials in favor of the
+// secondary ones. Connections set up with either set of credentials stay valid across such a change
+func (ref *RemoteClusterReference) IsCredentialRotation(newRef *RemoteClusterReference) bool {
+	if ref == nil || newRef == nil {
+		return false
+	}
+	ref.mutex.RLock()
+	defer ref.mutex.RUnlock()
+	newRef.mutex.RLock()
+	defer newRef.mutex.RUnlock()
+
+	if ref.DemandEncryption_ != newRef.DemandEncryption_ || ref.EncryptionType_ != newRef.EncryptionType_ ||
+		!bytes.Equal(ref.Certificate_, newRef.Certificate_) || ref.SANInCertificate_ != newRef.SANInCertificate_ ||
+		ref.HttpAuthMech_ != newRef.HttpAuthMech_ {
+		return false
+	}
+
+	oldPrimary, oldSecondary := ref.primaryCredentialsNoLock(), ref.secondaryCredentialsNoLock()
+	newPrimary, newSecondary := newRef.primaryCredentialsNoLock(), newRef.secondaryCredentialsNoLock()
+	if sameCredentials(oldPrimary, newPrimary) {
+		return true
+	}
+	return oldSecondary != nil && sameCredentials(oldSecondary, newPrimary) &&
+		(newSecondary == nil || sameCredentials(oldPrimary, newSecondary))
+}
+
+// ConnCredentials returns the credentials for the connections to target. secondary is nil when no secondary
+// credentials are staged
+func (ref *RemoteClusterReference) ConnCredentials() (primary, secondary *base.ConnCredentials) {
+	ref.mutex.RLock()
+	defer ref.mutex.RUnlock()
+	return ref.primaryCredentialsNoLock(), ref.secondaryCredentialsNoLock()
+}
+
+func (ref *RemoteClusterReference) primaryCredentialsNoLock() *base.ConnCredentials {
+	return &base.ConnCredentials{
+		UserName:          ref.UserName_,
+		Password:          ref.Password_,
+		ClientCertificate: ref.ClientCertificate_,
+		ClientKey:         ref.ClientKey_,
+	}
+}
+
+func (ref *RemoteClusterReference) secondaryCredentialsNoLock() *base.ConnCredentials {
+	if !ref.hasSecondaryCredentialsNoLock() {
+		return nil
+	}
+	return &base.ConnCredentials{
+		UserName:          ref.SecondaryUserName_,
+		Password:          ref.SecondaryPassword_,
+		ClientCertificate: ref.SecondaryClientCertificate_,
+		ClientKey:         ref.SecondaryClientKey_,
+	}
+}
+
+func sameCredentials(creds1, creds2 *base.ConnCredentials) bool {
+	if creds1 == nil || creds2 == nil {
+		return creds1 == creds2
+	}
+	return creds1.UserName == creds2.UserName && creds1.Password == creds2.Password &&
+		bytes.Equal(creds1.ClientCertificate, creds2.ClientCertificate) && bytes.Equal(creds1.ClientKey, creds2.ClientKey)
+}
+
 func (ref *RemoteClusterReference) AreSecuritySettingsTheSame(ref2 *RemoteClusterReference) bool {
 	ref.mutex.RLock()
 	defer ref.mutex.RUnlock()
@@ -412,7 +516,8 @@ func (ref *RemoteClusterReference) isEssentiallySameNoLock(ref2 *RemoteClusterRe
 	if ref2 == nil {
 		return false
 	}
-	if !ref.areUserSecurityCredentialsTheSameNoLock(ref2) || !ref.areSecuritySettingsTheSameNoLock(ref2) {
+	if !ref.areUserSecurityCredentialsTheSameNoLock(ref2) || !ref.areSecuritySettingsTheSameNoLock(ref2) ||
+		!ref.areSecondaryCredentialsTheSameNoLock(ref2) {
 		return false
 	} else {
 		ref2.mutex.RLock()
@@ -441,9 +546,13 @@ func (ref *RemoteClusterReference) String() string {
 	if len(ref.ClientCertificate_) > 0 {
 		clientKey = "xxxx"
 	}
+	var secondaryPassword string
+	if len(ref.SecondaryPassword_) > 0 {
+		secondaryPassword = "xxxx"
+	}
 
-	return fmt.Sprintf("id:%v; uuid:%v; name:%v; hostName:%v; userName:%v; password:%v; secureType:%v; certificate:%v; clientCertificate:%v; clientKey:%v; SanInCertificate:%v; HttpAuthMech:%v, revision:%v",
-		ref.Id_, ref.Uuid_, ref.Name_, ref.HostName_, ref.UserName_, password, ref.SecureTypeString(), ref.Certificate_, ref.ClientCertificate_, clientKey, ref.SANInCertificate_, ref.HttpAuthMech_, ref.revision)
+	return fmt.Sprintf("id:%v; uuid:%v; name:%v; hostName:%v; userName:%v; password:%v; secureType:%v; certificate:%v; clientCertificate:%v; clientKey:%v; SanInCertificate:%v; HttpAuthMech:%v, secondaryUserName:%v; secondaryPassword:%v; secondaryClientCertificate:%v; revision:%v",
+		ref.Id_, ref.Uuid_, ref.Name_, ref.HostName_, ref.UserName_, password, ref.SecureTypeString(), ref.Certificate_, ref.ClientCertificate_, clientKey, ref.SANInCertificate_, ref.HttpAuthMech_, ref.SecondaryUserName_, secondaryPassword, ref.SecondaryClientCertificate_, ref.revision)
 }
 
 func (ref *RemoteClusterReference) LoadFrom(inRef *RemoteClusterReference) {
@@ -482,6 +591,10 @@ func (ref *RemoteClusterReference) loadNonActivesFromNoLock(inRef *RemoteCluster
 	ref.Certificate_ = base.DeepCopyByteArray(inRef.Certificate_)
 	ref.ClientCertificate_ = base.DeepCopyByteArray(inRef.ClientCertificate_)
 	ref.ClientKey_ = base.DeepCopyByteArray(inRef.ClientKey_)
+	ref.SecondaryUserName_ = inRef.SecondaryUserName_
+	ref.SecondaryPassword_ = inRef.SecondaryPassword_
+	ref.SecondaryClientCertificate_ = base.DeepCopyByteArray(inRef.SecondaryClientCertificate_)
+	ref.SecondaryClientKey_ = base.DeepCopyByteArray(inRef.SecondaryClientKey_)
 	ref.HttpsHostName_ = inRef.HttpsHostName_
 	ref.EncryptionType_ = inRef.EncryptionType_
 	ref.SANInCertificate_ = inRef.SANInCertificate_
@@ -502,6 +615,7 @@ func (ref *RemoteClusterReference) Clone() *RemoteClusterReference {
 
 	cloneRef := ref.cloneForRedactNoLock()
 	cloneRef.ClientKey_ = base.DeepCopyByteArray(ref.ClientKey_)
+	cloneRef.SecondaryClientKey_ = base.DeepCopyByteArray(ref.SecondaryClientKey_)
 	return cloneRef
 }
 
@@ -523,6 +637,7 @@ func (ref *RemoteClusterReference) CloneForMetakvUpdate() *RemoteClusterReferenc
 
 	cloneRef := ref.cloneCommonFieldsNoLock()
 	cloneRef.ClientKey_ = base.DeepCopyByteArray(ref.ClientKey_)
+	cloneRef.SecondaryClientKey_ = base.DeepCopyByteArray(ref.SecondaryClientKey_)
 	// no need for Revision in metakv.
 	// cloneRef.Revision is a shallow copy, hence it is not a big waste to copy and then set it to nil
 	cloneRef.revision = nil
@@ -548,6 +663,10 @@ func (ref *RemoteClusterReference) cloneCommonFieldsNoLock() *RemoteClusterRefer
 		SANInCertificate_:  ref.SANInCertificate_,
 		HttpAuthMech_:      ref.HttpAuthMech_,
 		HostnameMode_:      ref.HostnameMode_,
+		// the secondary client key, like the client key, is only copied by the clones that need it
+		SecondaryUserName_:          ref.SecondaryUserName_,
+		SecondaryPassword_:          ref.SecondaryPassword_,
+		SecondaryClientCertificate_: base.DeepCopyByteArray(ref.SecondaryClientCertificate_),
 		// !!! shallow copy of revision.
 		// ref.Revision should only be passed along and should never be modified
 		revision:        ref.revision,
@@ -715,6 +834,47 @@ func (ref *RemoteClusterReference) Password() string {
 	return ref.Password_
 }
 
+func (ref *RemoteClusterReference) HasSecondaryCredentials() bool {
+	ref.mutex.RLock()
+	defer ref.mutex.RUnlock()
+	return ref.hasSecondaryCredentialsNoLock()
+}
+
+func (ref *RemoteClusterReference) hasSecondaryCredentialsNoLock() bool {
+	return len(ref.SecondaryUserName_) > 0 || len(ref.SecondaryClientCertificate_) > 0
+}
+
+// SetSecondaryCredentials stages the credentials to rotate to. A nil creds drops the staged credentials
+func (ref *RemoteClusterReference) SetSecondaryCredentials(creds *base.ConnCredentials) {
+	ref.mutex.Lock()
+	defer ref.mutex.Unlock()
+	if creds == nil {
+		creds = &base.ConnCredentials{}
+	}
+	ref.SecondaryUserName_ = creds.UserName
+	ref.SecondaryPassword_ = creds.Password
+	ref.SecondaryClientCertificate_ = base.DeepCopyByteArray(creds.ClientCertificate)
+	ref.SecondaryClientKey_ = base.DeepCopyByteArray(creds.ClientKey)
+}
+
+// PromoteSecondaryCredentials retires the primary credentials, and makes the secondary credentials the primary ones
+func (ref *RemoteClusterReference) PromoteSecondaryCredentials() error {
+	ref.mutex.Lock()
+	defer ref.mutex.Unlock()
+	if !ref.hasSecondaryCredentialsNoLock() {
+		return fmt.Errorf("Remote cluster reference %v has no secondary credentials", ref.Name_)
+	}
+	ref.UserName_ = ref.SecondaryUserName_
+	ref.Password_ = ref.SecondaryPassword_
+	ref.ClientCertificate_ = ref.SecondaryClientCertificate_
+	ref.ClientKey_ = ref.SecondaryClientKey_
+	ref.SecondaryUserName_ = ""
+	ref.SecondaryPassword_ = ""
+	ref.SecondaryClientCertificate_ = nil
+	ref.SecondaryClientKey_ = nil
+	return nil
+}
+
 func (ref *RemoteClusterReference) DemandEncryption() bool {
 	ref.mutex.RLock()
 	defer ref.mutex.RUnlock()
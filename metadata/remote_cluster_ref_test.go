@@ -296,3 +296,59 @@ func TestDNSSRVBoth(t *testing.T) {
 		assert.Equal(base.DefaultAdminPortSSL, portNo)
 	}
 }
+
+func TestRefCredentialRotation(t *testing.T) {
+	fmt.Println("============== Test case start: TestRefCredentialRotation =================")
+	defer fmt.Println("============== Test case end: TestRefCredentialRotation =================")
+	assert := assert.New(t)
+
+	ref, _ := NewRemoteClusterReference("testsUuid", "testName", "127.0.0.1:8091", "oldUserName", "oldPassword",
+		"", false, "", nil, nil, nil, nil)
+	assert.False(ref.HasSecondaryCredentials())
+	assert.NotNil(ref.Clone().PromoteSecondaryCredentials())
+
+	// stage the new credentials
+	staged := ref.Clone()
+	staged.SetSecondaryCredentials(&base.ConnCredentials{UserName: "newUserName", Password: "newPassword"})
+	assert.True(staged.HasSecondaryCredentials())
+	assert.True(ref.AreUserSecurityCredentialsTheSame(staged))
+	assert.False(ref.AreSecondaryCredentialsTheSame(staged))
+	assert.False(ref.IsEssentiallySame(staged))
+	assert.True(ref.IsCredentialRotation(staged))
+	primary, secondary := staged.ConnCredentials()
+	assert.Equal("oldPassword", primary.Password)
+	assert.Equal("newPassword", secondary.Password)
+
+	// the secondary credentials are kept in metakv, but not shown
+	assert.Equal("newPassword", staged.CloneForMetakvUpdate().SecondaryPassword_)
+	redacted := staged.CloneAndRedact()
+	assert.True(base.IsStringRedacted(redacted.SecondaryUserName_))
+	assert.True(base.IsStringRedacted(redacted.SecondaryPassword_))
+	assert.NotContains(staged.String(), "newPassword")
+	assert.Equal("newUserName", staged.ToMap()[base.RemoteClusterSecondaryUserName])
+
+	loaded := ref.Clone()
+	loaded.LoadFrom(staged)
+	assert.True(loaded.AreSecondaryCredentialsTheSame(staged))
+
+	// retire the old credentials
+	retired := staged.Clone()
+	assert.Nil(retired.PromoteSecondaryCredentials())
+	assert.False(retired.HasSecondaryCredentials())
+	assert.Equal("newUserName", retired.UserName())
+	assert.Equal("newPassword", retired.Password())
+	assert.True(staged.IsCredentialRotation(retired))
+	primary, secondary = retired.ConnCredentials()
+	assert.Equal("newPassword", primary.Password)
+	assert.Nil(secondary)
+
+	// replacing the credentials outright, or changing the security settings along with them, is not a rotation
+	replaced := ref.Clone()
+	replaced.Password_ = "newPassword"
+	assert.False(ref.IsCredentialRotation(replaced))
+	assert.False(ref.IsCredentialRotation(retired))
+	encrypted := staged.Clone()
+	encrypted.SetEncryptionType(EncryptionType_Half)
+	encrypted.DemandEncryption_ = true
+	assert.False(ref.IsCredentialRotation(encrypted))
+}
@@ -247,3 +247,60 @@ func TestMultiValueHelperCheckAndConvert(t *testing.T) {
 
 	fmt.Println("============== Test case end: TestMultiValueHelperCheckAndConvert =================")
 }
+
+func TestValidateConflictLoggingSettings(t *testing.T) {
+	assert := assert.New(t)
+	fmt.Println("============== Test case start: TestValidateConflictLoggingSettings =================")
+
+	settings := setupBoilerPlate()
+	assert.False(settings.GetConflictLoggingEnabled())
+	assert.Equal(base.ConflictLoggingDestFile, settings.GetConflictLoggingDest())
+
+	converted, err := ValidateAndConvertReplicationSettingsValue(ConflictLoggingKey, "true", "", true, false)
+	assert.Nil(err)
+	assert.Equal(true, converted)
+
+	// empty means local file
+	converted, err = ValidateAndConvertReplicationSettingsValue(ConflictLoggingDestKey, "", "", true, false)
+	assert.Nil(err)
+	assert.Equal("", converted)
+
+	converted, err = ValidateAndConvertReplicationSettingsValue(ConflictLoggingDestKey, "audit.conflicts", "", true, false)
+	assert.Nil(err)
+	assert.Equal("audit.conflicts", converted)
+
+	_, err = ValidateAndConvertReplicationSettingsValue(ConflictLoggingDestKey, "conflicts", "", true, false)
+	assert.NotNil(err)
+	_, err = ValidateAndConvertReplicationSettingsValue(ConflictLoggingDestKey, "a.b.c", "", true, false)
+	assert.NotNil(err)
+	fmt.Println("============== Test case end: TestValidateConflictLoggingSettings =================")
+}
+
+func TestValidateFileExportDir(t *testing.T) {
+	assert := assert.New(t)
+	fmt.Println("============== Test case start: TestValidateFileExportDir =================")
+	defer fmt.Println("============== Test case end: TestValidateFileExportDir =================")
+
+	oldRootDir := base.FileExportRootDir
+	defer func() { base.FileExportRootDir = oldRootDir }()
+
+	// unavailable until an administrator configures the root
+	base.FileExportRootDir = ""
+	_, err := ValidateAndConvertReplicationSettingsValue(FileExportDirKey, "/data/export", "", true, false)
+	assert.NotNil(err)
+
+	base.FileExportRootDir = "/data/export"
+	converted, err := ValidateAndConvertReplicationSettingsValue(FileExportDirKey, "/data/export/repl1/", "", true, false)
+	assert.Nil(err)
+	assert.Equal("/data/export/repl1", converted)
+	converted, err = ValidateAndConvertReplicationSettingsValue(FileExportDirKey, "/data/export", "", true, false)
+	assert.Nil(err)
+	assert.Equal("/data/export", converted)
+
+	_, err = ValidateAndConvertReplicationSettingsValue(FileExportDirKey, "repl1", "", true, false)
+	assert.NotNil(err)
+	_, err = ValidateAndConvertReplicationSettingsValue(FileExportDirKey, "/data/export/../../etc", "", true, false)
+	assert.NotNil(err)
+	_, err = ValidateAndConvertReplicationSettingsValue(FileExportDirKey, "/data/exports", "", true, false)
+	assert.NotNil(err)
+}
@@ -11,11 +11,14 @@ package metadata
 import (
 	"fmt"
 	"math"
+	"path/filepath"
 	"reflect"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/couchbase/goxdcr/base"
+	"github.com/couchbase/goxdcr/base/transform"
 	"github.com/couchbase/goxdcr/log"
 )
 
@@ -85,6 +88,38 @@ const (
 
 	PreReplicateVBMasterCheckKey = base.PreReplicateVBMasterCheckKey
 	ReplicateCkptIntervalKey     = base.ReplicateCkptIntervalKey
+
+	ConflictLoggingKey     = base.ConflictLoggingKey
+	ConflictLoggingDestKey = base.ConflictLoggingDestKey
+
+	FileExportDirKey = base.FileExportDirKey
+
+	ConflictResolutionStrategyKey = base.ConflictResolutionStrategyKey
+
+	StopWhenCaughtUpKey = base.StopWhenCaughtUpKey
+
+	SharedSourceStreamKey = base.SharedSourceStreamKey
+
+	TransformationRulesKey = base.TransformationRulesKey
+
+	TargetKeyRemovePrefixKey     = base.TargetKeyRemovePrefixKey
+	TargetKeyRegexKey            = base.TargetKeyRegexKey
+	TargetKeyRegexReplacementKey = base.TargetKeyRegexReplacementKey
+	TargetKeyAddPrefixKey        = base.TargetKeyAddPrefixKey
+
+	DeadLetterDestKey        = base.DeadLetterDestKey
+	DeadLetterIncludeBodyKey = base.DeadLetterIncludeBodyKey
+
+	ApplyDelayKey = base.ApplyDelayKey
+
+	BulkDeleteMaxRateKey    = base.BulkDeleteMaxRateKey
+	BulkDeleteMaxPercentKey = base.BulkDeleteMaxPercentKey
+	BulkDeleteWindowKey     = base.BulkDeleteWindowKey
+	BulkDeleteTrippedKey    = base.BulkDeleteTrippedKey
+	BulkDeleteAckTimeKey    = base.BulkDeleteAckTimeKey
+
+	StartFromTimeKey   = base.StartFromTimeKey
+	StartFromSeqnosKey = base.StartFromSeqnosKey
 )
 
 // keys to facilitate redaction of replication settings map
@@ -98,10 +133,11 @@ const (
 var ImmutableDefaultSettings = []string{ReplicationTypeKey, FilterExpressionKey, ActiveKey, FilterVersionKey,
 	CollectionsMgtMultiKey, CollectionsSkipSourceCheckKey, CollectionsMappingRulesKey, CollectionsMgtMirrorKey,
 	CollectionsMgtMappingKey, CollectionsMgtMigrateKey, CollectionsMgtOsoKey, CollectionsManualBackfillKey, CollectionsDelAllBackfillKey,
-	CollectionsDelVbBackfillKey, DismissEventKey}
+	CollectionsDelVbBackfillKey, DismissEventKey, FileExportDirKey, TransformationRulesKey, TargetKeyRemovePrefixKey, TargetKeyRegexKey,
+	TargetKeyRegexReplacementKey, TargetKeyAddPrefixKey, StartFromTimeKey, StartFromSeqnosKey}
 
 // settings whose values cannot be changed after replication is created
-var ImmutableSettings = []string{}
+var ImmutableSettings = []string{FileExportDirKey, StartFromTimeKey, StartFromSeqnosKey}
 
 // settings that are internal and should be hidden from outside
 var HiddenSettings = []string{FilterVersionKey, FilterSkipRestreamKey, FilterExpDelKey, CollectionsMgtMultiKey,
@@ -129,6 +165,8 @@ var MaxBatchCount = 10000
 const (
 	ReplicationTypeXmem = "xmem"
 	ReplicationTypeCapi = "capi"
+	// Writes the replicated mutations to local files under FileExportDirKey instead of the target bucket
+	ReplicationTypeFile = "file"
 )
 
 var DefaultPipelineStatsIntervalMs = 1000
@@ -185,6 +223,48 @@ var PreReplicateVBMasterCheckConfig = &SettingsConfig{true, nil}
 
 var ReplicateCkptIntervalConfig = &SettingsConfig{int(base.ReplicateCkptInterval.Minutes()), &Range{1, 4320 /* 3 days */}}
 
+var ConflictLoggingConfig = &SettingsConfig{false, nil}
+
+// Either base.ConflictLoggingDestFile or a "scope.collection" in the source bucket
+var ConflictLoggingDestConfig = &SettingsConfig{base.ConflictLoggingDestFile, nil}
+
+// Absolute path of the directory that a file export replication writes to
+var FileExportDirConfig = &SettingsConfig{"", nil}
+
+var ConflictResolutionStrategyConfig = &SettingsConfig{base.ConflictResolutionStrategyDefault, nil}
+
+var StopWhenCaughtUpConfig = &SettingsConfig{false, nil}
+
+var SharedSourceStreamConfig = &SettingsConfig{false, nil}
+
+var TransformationRulesConfig = &SettingsConfig{"", nil}
+
+var TargetKeyRemovePrefixConfig = &SettingsConfig{"", nil}
+var TargetKeyRegexConfig = &SettingsConfig{"", nil}
+var TargetKeyRegexReplacementConfig = &SettingsConfig{"", nil}
+var TargetKeyAddPrefixConfig = &SettingsConfig{"", nil}
+
+// One of base.DeadLetterDestDisabled, base.DeadLetterDestFile, or a collection in the source or target bucket
+var DeadLetterDestConfig = &SettingsConfig{base.DeadLetterDestDisabled, nil}
+var DeadLetterIncludeBodyConfig = &SettingsConfig{false, nil}
+
+var ApplyDelayConfig = &SettingsConfig{0, &Range{0, base.ApplyDelayMax}}
+
+// deletions per second, averaged over the window
+var BulkDeleteMaxRateConfig = &SettingsConfig{0, &Range{0, math.MaxInt32}}
+var BulkDeleteMaxPercentConfig = &SettingsConfig{0, &Range{0, 100}}
+var BulkDeleteWindowConfig = &SettingsConfig{base.BulkDeleteWindowDefault, &Range{1, base.BulkDeleteWindowMax}}
+var BulkDeleteTrippedConfig = &SettingsConfig{false, nil}
+
+// Set by XDCR when the operator acknowledges, in seconds since the epoch
+var BulkDeleteAckTimeConfig = &SettingsConfig{0, nil}
+
+// seconds since the epoch
+var StartFromTimeConfig = &SettingsConfig{0, &Range{0, math.MaxInt32}}
+
+// JSON object of VB number to seqno
+var StartFromSeqnosConfig = &SettingsConfig{"", nil}
+
 var ReplicationSettingsConfigMap = map[string]*SettingsConfig{
 	DevMainPipelineSendDelay:          XDCRDevMainPipelineSendDelayConfig,
 	DevBackfillPipelineSendDelay:      XDCRDevBackfillPipelineSendDelayConfig,
@@ -221,6 +301,27 @@ var ReplicationSettingsConfigMap = map[string]*SettingsConfig{
 	DismissEventKey:                   DismissEventConfig,
 	PreReplicateVBMasterCheckKey:      PreReplicateVBMasterCheckConfig,
 	ReplicateCkptIntervalKey:          ReplicateCkptIntervalConfig,
+	ConflictLoggingKey:                ConflictLoggingConfig,
+	ConflictLoggingDestKey:            ConflictLoggingDestConfig,
+	FileExportDirKey:                  FileExportDirConfig,
+	ConflictResolutionStrategyKey:     ConflictResolutionStrategyConfig,
+	StopWhenCaughtUpKey:               StopWhenCaughtUpConfig,
+	SharedSourceStreamKey:             SharedSourceStreamConfig,
+	TransformationRulesKey:            TransformationRulesConfig,
+	TargetKeyRemovePrefixKey:          TargetKeyRemovePrefixConfig,
+	TargetKeyRegexKey:                 TargetKeyRegexConfig,
+	TargetKeyRegexReplacementKey:      TargetKeyRegexReplacementConfig,
+	TargetKeyAddPrefixKey:             TargetKeyAddPrefixConfig,
+	DeadLetterDestKey:                 DeadLetterDestConfig,
+	DeadLetterIncludeBodyKey:          DeadLetterIncludeBodyConfig,
+	ApplyDelayKey:                     ApplyDelayConfig,
+	BulkDeleteMaxRateKey:              BulkDeleteMaxRateConfig,
+	BulkDeleteMaxPercentKey:           BulkDeleteMaxPercentConfig,
+	BulkDeleteWindowKey:               BulkDeleteWindowConfig,
+	BulkDeleteTrippedKey:              BulkDeleteTrippedConfig,
+	BulkDeleteAckTimeKey:              BulkDeleteAckTimeConfig,
+	StartFromTimeKey:                  StartFromTimeConfig,
+	StartFromSeqnosKey:                StartFromSeqnosConfig,
 }
 
 // Adding values in this struct is deprecated - use ReplicationSettings.Settings.Values instead
@@ -556,6 +657,13 @@ func (s *ReplicationSettings) Redact() *ReplicationSettings {
 		s.FilterExpression = base.TagUD(s.FilterExpression)
 	}
 
+	// Transformation rules name the fields that hold user data
+	if transformationRules, ok := s.Values[TransformationRulesKey].(string); ok {
+		if len(transformationRules) > 0 && !base.IsStringRedacted(transformationRules) {
+			s.Values[TransformationRulesKey] = base.TagUD(transformationRules)
+		}
+	}
+
 	collectionModes := s.GetCollectionModes()
 	if collectionModes.IsMigrationOn() {
 		// Migration rules have filter expressions and need to be redacted
@@ -854,6 +962,10 @@ func (s *ReplicationSettings) IsCapi() bool {
 	return s.RepType == ReplicationTypeCapi
 }
 
+func (s *ReplicationSettings) IsFileExport() bool {
+	return s.RepType == ReplicationTypeFile
+}
+
 func (s *ReplicationSettings) GetPriority() base.PriorityType {
 	priority, _ := s.GetSettingValueOrDefaultValue(PriorityKey)
 	return priority.(base.PriorityType)
@@ -938,6 +1050,122 @@ func (s *ReplicationSettings) GetReplicateCkptInterval() time.Duration {
 	return time.Duration(minInt) * time.Minute
 }
 
+func (s *ReplicationSettings) GetConflictLoggingEnabled() bool {
+	val, _ := s.GetSettingValueOrDefaultValue(ConflictLoggingKey)
+	return val.(bool)
+}
+
+func (s *ReplicationSettings) GetConflictLoggingDest() string {
+	val, _ := s.GetSettingValueOrDefaultValue(ConflictLoggingDestKey)
+	return val.(string)
+}
+
+func (s *ReplicationSettings) GetFileExportDir() string {
+	val, _ := s.GetSettingValueOrDefaultValue(FileExportDirKey)
+	return val.(string)
+}
+
+func (s *ReplicationSettings) GetConflictResolutionStrategy() string {
+	val, _ := s.GetSettingValueOrDefaultValue(ConflictResolutionStrategyKey)
+	return val.(string)
+}
+
+func (s *ReplicationSettings) GetStopWhenCaughtUp() bool {
+	val, _ := s.GetSettingValueOrDefaultValue(StopWhenCaughtUpKey)
+	return val.(bool)
+}
+
+func (s *ReplicationSettings) GetSharedSourceStream() bool {
+	val, _ := s.GetSettingValueOrDefaultValue(SharedSourceStreamKey)
+	return val.(bool)
+}
+
+func (s *ReplicationSettings) GetTransformationRules() string {
+	val, _ := s.GetSettingValueOrDefaultValue(TransformationRulesKey)
+	return val.(string)
+}
+
+func (s *ReplicationSettings) GetTargetKeyRewriteRules() transform.KeyRewriteRules {
+	removePrefix, _ := s.GetSettingValueOrDefaultValue(TargetKeyRemovePrefixKey)
+	regex, _ := s.GetSettingValueOrDefaultValue(TargetKeyRegexKey)
+	regexReplacement, _ := s.GetSettingValueOrDefaultValue(TargetKeyRegexReplacementKey)
+	addPrefix, _ := s.GetSettingValueOrDefaultValue(TargetKeyAddPrefixKey)
+	return transform.KeyRewriteRules{
+		RemovePrefix:     removePrefix.(string),
+		Regex:            regex.(string),
+		RegexReplacement: regexReplacement.(string),
+		AddPrefix:        addPrefix.(string),
+	}
+}
+
+func (s *ReplicationSettings) GetDeadLetterDest() string {
+	val, _ := s.GetSettingValueOrDefaultValue(DeadLetterDestKey)
+	return val.(string)
+}
+
+func (s *ReplicationSettings) GetDeadLetterIncludeBody() bool {
+	val, _ := s.GetSettingValueOrDefaultValue(DeadLetterIncludeBodyKey)
+	return val.(bool)
+}
+
+func (s *ReplicationSettings) GetApplyDelay() time.Duration {
+	val, _ := s.GetSettingValueOrDefaultValue(ApplyDelayKey)
+	return time.Duration(val.(int)) * time.Minute
+}
+
+func (s *ReplicationSettings) GetBulkDeleteMaxRate() int {
+	val, _ := s.GetSettingValueOrDefaultValue(BulkDeleteMaxRateKey)
+	return val.(int)
+}
+
+func (s *ReplicationSettings) GetBulkDeleteMaxPercent() int {
+	val, _ := s.GetSettingValueOrDefaultValue(BulkDeleteMaxPercentKey)
+	return val.(int)
+}
+
+func (s *ReplicationSettings) GetBulkDeleteWindow() time.Duration {
+	val, _ := s.GetSettingValueOrDefaultValue(BulkDeleteWindowKey)
+	return time.Duration(val.(int)) * time.Second
+}
+
+// The bulk delete breaker is disabled unless at least one of its limits is set
+func (s *ReplicationSettings) IsBulkDeleteBreakerEnabled() bool {
+	return s.GetBulkDeleteMaxRate() > 0 || s.GetBulkDeleteMaxPercent() > 0
+}
+
+func (s *ReplicationSettings) GetBulkDeleteTripped() bool {
+	val, _ := s.GetSettingValueOrDefaultValue(BulkDeleteTrippedKey)
+	return val.(bool)
+}
+
+// Zero time if the operator has never acknowledged
+func (s *ReplicationSettings) GetBulkDeleteAckTime() time.Time {
+	val, _ := s.GetSettingValueOrDefaultValue(BulkDeleteAckTimeKey)
+	if val.(int) == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(val.(int)), 0)
+}
+
+// Zero time if the replication does not start from a point in time
+func (s *ReplicationSettings) GetStartFromTime() time.Time {
+	val, _ := s.GetSettingValueOrDefaultValue(StartFromTimeKey)
+	if val.(int) == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(val.(int)), 0)
+}
+
+// Nil if the replication does not start from given seqnos. The value has been validated when it was set
+func (s *ReplicationSettings) GetStartFromSeqnos() map[uint16]uint64 {
+	val, _ := s.GetSettingValueOrDefaultValue(StartFromSeqnosKey)
+	if val.(string) == "" {
+		return nil
+	}
+	seqnos, _ := base.ParseStartFromSeqnos(val.(string))
+	return seqnos
+}
+
 type ReplicationSettingsMap map[string]interface{}
 
 type redactDictType int
@@ -951,9 +1179,10 @@ const (
 
 // The dictionary map is a kv pair of KeyNeedsRedacting -> RedactTypeAndOperation
 var replicationSettingsMapRedactDict = map[string]redactDictType{FilterExpressionKey: redactDictString,
-	XmemCertificate:       redactDictBytes,
-	XmemClientKey:         redactDictBytesClear, // Clear the value instead of redaction
-	XmemClientCertificate: redactDictBytes}
+	TransformationRulesKey: redactDictString,
+	XmemCertificate:        redactDictBytes,
+	XmemClientKey:          redactDictBytesClear, // Clear the value instead of redaction
+	XmemClientCertificate:  redactDictBytes}
 
 // Input - the key that is being redacted. Value - the value to be redacted
 // The function will redact the value automatically if the key needs to be redacted, otherwise, it will do shallow clone
@@ -1020,7 +1249,7 @@ func ValidateAndConvertReplicationSettingsValue(key, value, errorKey string, isE
 	case ReplicationTypeKey:
 		if value == ReplicationTypeCapi {
 			err = base.ErrorCAPIDeprecated
-		} else if value != ReplicationTypeXmem {
+		} else if value != ReplicationTypeXmem && value != ReplicationTypeFile {
 			err = base.GenericInvalidValueError(errorKey)
 		} else {
 			convertedValue = value
@@ -1132,6 +1361,73 @@ func ValidateAndConvertReplicationSettingsValue(key, value, errorKey string, isE
 		if err != nil {
 			return
 		}
+	case ConflictLoggingDestKey:
+		if value != base.ConflictLoggingDestFile {
+			if _, err = base.NewCollectionNamespaceFromString(value); err != nil {
+				err = fmt.Errorf("%v must be empty for a local file or in the form of scope%vcollection", errorKey, base.ScopeCollectionDelimiter)
+				return
+			}
+		}
+		convertedValue = value
+	case TransformationRulesKey:
+		if err = transform.ValidateRules(value); err != nil {
+			return
+		}
+		convertedValue = value
+	case TargetKeyRegexKey:
+		if len(value) > 0 {
+			if err = transform.ValidateKeyRegex(value); err != nil {
+				return
+			}
+		}
+		convertedValue = value
+	case TargetKeyRemovePrefixKey, TargetKeyAddPrefixKey:
+		if len(value) >= base.MaxDocKeyLength {
+			err = fmt.Errorf("%v must be shorter than %v bytes", errorKey, base.MaxDocKeyLength)
+			return
+		}
+		convertedValue = value
+	case ApplyDelayKey:
+		convertedValue, err = ValidateAndConvertSettingsValue(key, value, ReplicationSettingsConfigMap)
+		if err != nil {
+			return
+		}
+		if err = nonCAPIOnlyFeature(convertedValue.(int), 0, isCapi); err != nil {
+			return
+		}
+	case StartFromSeqnosKey:
+		if len(value) > 0 {
+			if _, err = base.ParseStartFromSeqnos(value); err != nil {
+				err = fmt.Errorf("%v must be a JSON object of VB number to seqno. err=%v", errorKey, err)
+				return
+			}
+		}
+		convertedValue = value
+	case DeadLetterDestKey:
+		if value != base.DeadLetterDestDisabled && value != base.DeadLetterDestFile {
+			if _, _, err = base.ParseDeadLetterCollectionDest(value); err != nil {
+				err = fmt.Errorf("%v must be empty, %q, or in the form of %vscope%vcollection or %vscope%vcollection", errorKey, base.DeadLetterDestFile,
+					base.DeadLetterDestSource, base.ScopeCollectionDelimiter, base.DeadLetterDestTarget, base.ScopeCollectionDelimiter)
+				return
+			}
+		}
+		convertedValue = value
+	case FileExportDirKey:
+		if !filepath.IsAbs(value) {
+			err = fmt.Errorf("%v must be an absolute path", errorKey)
+			return
+		}
+		exportDir := filepath.Clean(value)
+		if base.FileExportRootDir == "" {
+			err = fmt.Errorf("%v cannot be used until the file export root directory is configured by an administrator", errorKey)
+			return
+		}
+		relPath, relErr := filepath.Rel(filepath.Clean(base.FileExportRootDir), exportDir)
+		if relErr != nil || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+			err = fmt.Errorf("%v must be under the file export root directory %v", errorKey, base.FileExportRootDir)
+			return
+		}
+		convertedValue = exportDir
 	default:
 		// generic cases that can be handled by ValidateAndConvertSettingsValue
 		convertedValue, err = ValidateAndConvertSettingsValue(key, value, ReplicationSettingsConfigMap)
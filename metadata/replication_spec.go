@@ -40,6 +40,10 @@ type ReplicationSpecification struct {
 
 	TargetBucketUUID string `json:"targetBucketUUID"`
 
+	// Optional user supplied name, which is part of the replication id. Replications created before
+	// names were introduced do not have one, and their ids stay as they were
+	ReplicationName string `json:"replicationName,omitempty"`
+
 	Settings *ReplicationSettings `json:"replicationSettings"`
 
 	// revision number to be used by metadata service. not included in json
@@ -47,18 +51,24 @@ type ReplicationSpecification struct {
 }
 
 func NewReplicationSpecification(sourceBucketName string, sourceBucketUUID string, targetClusterUUID string, targetBucketName string, targetBucketUUID string) (*ReplicationSpecification, error) {
+	return NewNamedReplicationSpecification(sourceBucketName, sourceBucketUUID, targetClusterUUID, targetBucketName, targetBucketUUID, "")
+}
+
+func NewNamedReplicationSpecification(sourceBucketName string, sourceBucketUUID string, targetClusterUUID string, targetBucketName string, targetBucketUUID string, replicationName string) (*ReplicationSpecification, error) {
 	randId, err := base.GenerateRandomId(base.LengthOfRandomId, base.MaxRetryForRandomIdGeneration)
 	if err != nil {
 		return nil, err
 	}
-	return &ReplicationSpecification{Id: ReplicationId(sourceBucketName, targetClusterUUID, targetBucketName),
+	spec := &ReplicationSpecification{Id: NamedReplicationId(sourceBucketName, targetClusterUUID, targetBucketName, replicationName),
 		InternalId:        randId,
 		SourceBucketName:  sourceBucketName,
 		SourceBucketUUID:  sourceBucketUUID,
 		TargetClusterUUID: targetClusterUUID,
 		TargetBucketName:  targetBucketName,
 		TargetBucketUUID:  targetBucketUUID,
-		Settings:          DefaultReplicationSettings()}, nil
+		Settings:          DefaultReplicationSettings()}
+	spec.ReplicationName = replicationName
+	return spec, nil
 }
 
 func (spec *ReplicationSpecification) GetReplicationSpec() *ReplicationSpecification {
@@ -77,6 +87,11 @@ func (spec *ReplicationSpecification) GetFullId() string {
 	return spec.Id
 }
 
+// File export replications write to local files and have no remote cluster reference nor target bucket
+func (spec *ReplicationSpecification) IsFileExport() bool {
+	return spec.TargetClusterUUID == base.FileExportTargetClusterUUID
+}
+
 func (spec *ReplicationSpecification) String() string {
 	if spec == nil {
 		return ""
@@ -85,8 +100,8 @@ func (spec *ReplicationSpecification) String() string {
 	if spec.Settings != nil {
 		specSettingsMap = spec.Settings.CloneAndRedact().ToMap(false /*defaultSettings*/)
 	}
-	return fmt.Sprintf("Id: %v InternalId: %v SourceBucketName: %v SourceBucketUUID: %v TargetClusterUUID: %v TargetBucketName: %v TargetBucketUUID: %v Settings: %v",
-		spec.Id, spec.InternalId, spec.SourceBucketName, spec.SourceBucketUUID, spec.TargetClusterUUID, spec.TargetBucketName, spec.TargetBucketUUID, specSettingsMap)
+	return fmt.Sprintf("Id: %v InternalId: %v SourceBucketName: %v SourceBucketUUID: %v TargetClusterUUID: %v TargetBucketName: %v TargetBucketUUID: %v ReplicationName: %v Settings: %v",
+		spec.Id, spec.InternalId, spec.SourceBucketName, spec.SourceBucketUUID, spec.TargetClusterUUID, spec.TargetBucketName, spec.TargetBucketUUID, spec.ReplicationName, specSettingsMap)
 }
 
 // checks if the passed in spec is the same as the current spec
@@ -103,7 +118,8 @@ func (spec *ReplicationSpecification) SameSpec(spec2 *ReplicationSpecification)
 		spec.SourceBucketName == spec2.SourceBucketName &&
 		spec.SourceBucketUUID == spec2.SourceBucketUUID &&
 		spec.TargetClusterUUID == spec2.TargetClusterUUID && spec.TargetBucketName == spec2.TargetBucketName &&
-		spec.TargetBucketUUID == spec2.TargetBucketUUID && reflect.DeepEqual(spec.Revision, spec2.Revision)
+		spec.TargetBucketUUID == spec2.TargetBucketUUID && spec.ReplicationName == spec2.ReplicationName &&
+		reflect.DeepEqual(spec.Revision, spec2.Revision)
 }
 
 func (spec *ReplicationSpecification) Clone() *ReplicationSpecification {
@@ -117,6 +133,7 @@ func (spec *ReplicationSpecification) Clone() *ReplicationSpecification {
 		TargetClusterUUID: spec.TargetClusterUUID,
 		TargetBucketName:  spec.TargetBucketName,
 		TargetBucketUUID:  spec.TargetBucketUUID,
+		ReplicationName:   spec.ReplicationName,
 		Settings:          spec.Settings.Clone(),
 		// !!! shallow copy of revision.
 		// spec.Revision should only be passed along and should never be modified
@@ -154,6 +171,27 @@ func ReplicationId(sourceBucketName string, targetClusterUUID string, targetBuck
 	return strings.Join(parts, base.KeyPartsDelimiter)
 }
 
+// An empty replication name gives the same id as ReplicationId
+func NamedReplicationId(sourceBucketName string, targetClusterUUID string, targetBucketName string, replicationName string) string {
+	replicationId := ReplicationId(sourceBucketName, targetClusterUUID, targetBucketName)
+	if replicationName == "" {
+		return replicationId
+	}
+	return replicationId + base.ReplicationNameDelimiter + replicationName
+}
+
+var replicationNameRegexp = regexp.MustCompile("^[A-Za-z0-9._-]+$")
+
+func ValidateReplicationName(replicationName string) error {
+	if len(replicationName) > base.ReplicationNameMaxLength {
+		return fmt.Errorf("Replication name cannot be longer than %v characters", base.ReplicationNameMaxLength)
+	}
+	if !replicationNameRegexp.MatchString(replicationName) {
+		return fmt.Errorf("Replication name can only contain letters, digits, '.', '_' and '-'")
+	}
+	return nil
+}
+
 func IsReplicationIdForSourceBucket(replicationId string, sourceBucketName string) (bool, error) {
 	replBucketName, err := GetSourceBucketNameFromReplicationId(replicationId)
 	if err != nil {
@@ -176,9 +214,10 @@ func IsReplicationIdForTargetBucket(replicationId string, targetBucketName strin
 // 136082a7c89ccdc9aed81cb04a97720f/B1/B2
 // and
 // backfill_136082a7c89ccdc9aed81cb04a97720f/B1/B2
-const ReplicationIdRegexpStr = "([a-z]+_)*([[:alnum:]]+)/([A-Za-z0-9._%-]+)/([A-Za-z0-9._%-]+)$"
+// with an optional :<replicationName> at the end of either
+const ReplicationIdRegexpStr = "([a-z]+_)*([[:alnum:]]+)/([A-Za-z0-9._%-]+)/([A-Za-z0-9._%-]+)(:[A-Za-z0-9._-]+)?$"
 
-var replicationRegexpGroupCount = 5
+var replicationRegexpGroupCount = 6
 
 func IsAReplicationId(replicationId string) bool {
 	// The replication ID could have "backfill_" in front of it, or not... so don't enforce a ^ at the beginning
@@ -191,6 +230,8 @@ type ReplIdComposition struct {
 	SourceBucketName  string
 	TargetBucketName  string
 	PipelineType      string
+	// Empty if the replication has not been given a name
+	ReplicationName string
 }
 
 var decompositionRegexp = regexp.MustCompile(ReplicationIdRegexpStr)
@@ -219,6 +260,7 @@ func DecomposeReplicationId(replicationId string, reuseStruct *ReplIdComposition
 	compositionStruct.TargetClusterUUID = submatches[2]
 	compositionStruct.SourceBucketName = submatches[3]
 	compositionStruct.TargetBucketName = submatches[4]
+	compositionStruct.ReplicationName = strings.TrimPrefix(submatches[5], base.ReplicationNameDelimiter)
 	return compositionStruct
 }
 
@@ -234,8 +276,21 @@ func GetSourceBucketNameFromReplicationId(replicationId string) (string, error)
 func GetTargetBucketNameFromReplicationId(replicationId string) (string, error) {
 	parts := strings.Split(replicationId, base.KeyPartsDelimiter)
 	if len(parts) == 3 {
-		return parts[2], nil
+		return strings.SplitN(parts[2], base.ReplicationNameDelimiter, 2)[0], nil
 	} else {
 		return "", fmt.Errorf("Invalid replication id: %v", replicationId)
 	}
 }
+
+// Returns an empty name for replications that were not given one
+func GetReplicationNameFromReplicationId(replicationId string) (string, error) {
+	parts := strings.Split(replicationId, base.KeyPartsDelimiter)
+	if len(parts) != 3 {
+		return "", fmt.Errorf("Invalid replication id: %v", replicationId)
+	}
+	targetParts := strings.SplitN(parts[2], base.ReplicationNameDelimiter, 2)
+	if len(targetParts) == 2 {
+		return targetParts[1], nil
+	}
+	return "", nil
+}
@@ -34,3 +34,42 @@ func TestDecompositionReplId(t *testing.T) {
 	deconstructed = DecomposeReplicationId(replId, deconstructed)
 	assert.Equal("Backfill", deconstructed.PipelineType)
 }
+
+func TestNamedReplicationId(t *testing.T) {
+	assert := assert.New(t)
+	fmt.Println("============== Test case start: TestNamedReplicationId =================")
+	defer fmt.Println("============== Test case end: TestNamedReplicationId =================")
+
+	// Unnamed replications keep the ids they had before names were introduced
+	assert.Equal(ReplicationId("B1", "136082a7c89ccdc9aed81cb04a97720f", "B2"), NamedReplicationId("B1", "136082a7c89ccdc9aed81cb04a97720f", "B2", ""))
+
+	replId := NamedReplicationId("B1", "136082a7c89ccdc9aed81cb04a97720f", "B2", "orders")
+	assert.Equal("136082a7c89ccdc9aed81cb04a97720f/B1/B2:orders", replId)
+
+	deconstructed := DecomposeReplicationId(replId, nil)
+	assert.Equal("136082a7c89ccdc9aed81cb04a97720f", deconstructed.TargetClusterUUID)
+	assert.Equal("B1", deconstructed.SourceBucketName)
+	assert.Equal("B2", deconstructed.TargetBucketName)
+	assert.Equal("orders", deconstructed.ReplicationName)
+	assert.Equal("Main", deconstructed.PipelineType)
+
+	deconstructed = DecomposeReplicationId("backfill_"+replId, deconstructed)
+	assert.Equal("Backfill", deconstructed.PipelineType)
+	assert.Equal("orders", deconstructed.ReplicationName)
+
+	// a reused struct must not keep the name of the previous id
+	deconstructed = DecomposeReplicationId("136082a7c89ccdc9aed81cb04a97720f/B1/B2", deconstructed)
+	assert.Equal("", deconstructed.ReplicationName)
+
+	targetBucket, err := GetTargetBucketNameFromReplicationId(replId)
+	assert.Nil(err)
+	assert.Equal("B2", targetBucket)
+	name, err := GetReplicationNameFromReplicationId(replId)
+	assert.Nil(err)
+	assert.Equal("orders", name)
+
+	assert.Nil(ValidateReplicationName("bulk-load_2.0"))
+	assert.NotNil(ValidateReplicationName(""))
+	assert.NotNil(ValidateReplicationName("orders/high"))
+	assert.NotNil(ValidateReplicationName("a:b"))
+}
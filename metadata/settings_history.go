@@ -0,0 +1,159 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software
+will be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package metadata
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/couchbase/goxdcr/base"
+)
+
+// Subjects of the settings history other than replications
+const (
+	DefaultSettingsHistorySubject  = "defaultSettings"
+	GlobalSettingsHistorySubject   = "globalSettings"
+	InternalSettingsHistorySubject = "internalSettings"
+
+	replicationSettingsHistoryPrefix = "replication" + base.KeyPartsDelimiter
+)
+
+func ReplicationSettingsHistorySubject(replicationId string) string {
+	return replicationSettingsHistoryPrefix + replicationId
+}
+
+// Returns the replication id if the subject is the settings of a replication
+func ReplicationIdFromSettingsHistorySubject(subject string) (string, bool) {
+	if !strings.HasPrefix(subject, replicationSettingsHistoryPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(subject, replicationSettingsHistoryPrefix), true
+}
+
+// A change to the settings of a subject. Only the settings that have changed are kept
+type SettingsHistoryEntry struct {
+	Version uint64                 `json:"version"`
+	Time    time.Time              `json:"time"`
+	User    string                 `json:"user"`
+	Before  map[string]interface{} `json:"before"`
+	After   map[string]interface{} `json:"after"`
+}
+
+// Returns nil if no setting has changed. Both before and after are expected to have the values of all settings
+// populated, so that a setting that is left at its default value is not taken as changed
+func NewSettingsHistoryEntry(before, after map[string]interface{}, user string, changeTime time.Time) *SettingsHistoryEntry {
+	entry := &SettingsHistoryEntry{
+		Time:   changeTime,
+		User:   user,
+		Before: make(map[string]interface{}),
+		After:  make(map[string]interface{}),
+	}
+	for key, value := range after {
+		oldValue, exists := before[key]
+		if exists && settingValuesSame(key, oldValue, value) {
+			continue
+		}
+		if exists {
+			entry.Before[key] = oldValue
+		}
+		entry.After[key] = value
+	}
+	for key, oldValue := range before {
+		if _, exists := after[key]; !exists {
+			entry.Before[key] = oldValue
+		}
+	}
+	if len(entry.Before) == 0 && len(entry.After) == 0 {
+		return nil
+	}
+	return entry
+}
+
+func settingValuesSame(key string, value1, value2 interface{}) bool {
+	if CheckIfKeyIsSpecialSetting(key) && value1 != nil && value2 != nil {
+		return value1.(SpecialSettingValue).SameAs(value2)
+	}
+	return reflect.DeepEqual(value1, value2)
+}
+
+type SettingsHistory struct {
+	Subject string `json:"subject"`
+	// oldest first
+	Entries []*SettingsHistoryEntry `json:"entries"`
+
+	// revision number to be used by metadata service. not included in json
+	Revision interface{} `json:"-"`
+}
+
+func (history *SettingsHistory) LatestVersion() uint64 {
+	if len(history.Entries) == 0 {
+		return 0
+	}
+	return history.Entries[len(history.Entries)-1].Version
+}
+
+// AddEntry gives the entry the next version and drops the oldest entries beyond maxEntries
+func (history *SettingsHistory) AddEntry(entry *SettingsHistoryEntry, maxEntries int) {
+	entry.Version = history.LatestVersion() + 1
+	history.Entries = append(history.Entries, entry)
+	if maxEntries > 0 && len(history.Entries) > maxEntries {
+		history.Entries = history.Entries[len(history.Entries)-maxEntries:]
+	}
+}
+
+// ValuesAtVersion returns the values that the settings changed after the given version had at that version.
+// Settings that did not exist at that version are left out
+func (history *SettingsHistory) ValuesAtVersion(version uint64) (map[string]interface{}, error) {
+	if len(history.Entries) == 0 || version > history.LatestVersion() {
+		return nil, fmt.Errorf("Version %v of the settings of %v does not exist", version, history.Subject)
+	}
+	if version+1 < history.Entries[0].Version {
+		return nil, fmt.Errorf("Version %v of the settings of %v is older than the oldest version kept, which is %v",
+			version, history.Subject, history.Entries[0].Version-1)
+	}
+
+	values := make(map[string]interface{})
+	// going from the newest entry back, the value before the oldest change after the version is the one to keep
+	for i := len(history.Entries) - 1; i >= 0 && history.Entries[i].Version > version; i-- {
+		for key, value := range history.Entries[i].Before {
+			values[key] = value
+		}
+	}
+	return values, nil
+}
+
+// after the history is loaded from metakv and unmarshalled, the setting values need to be converted back to the types
+// of the settings of the subject
+func (history *SettingsHistory) PostProcessAfterUnmarshalling() {
+	for _, entry := range history.Entries {
+		history.convertValueTypes(entry.Before)
+		history.convertValueTypes(entry.After)
+	}
+}
+
+func (history *SettingsHistory) convertValueTypes(values map[string]interface{}) {
+	if values == nil {
+		return
+	}
+	switch history.Subject {
+	case GlobalSettingsHistorySubject:
+		settings := &GlobalSettings{Settings: &Settings{Values: values}}
+		settings.PostProcessAfterUnmarshalling()
+	case InternalSettingsHistorySubject:
+		settings := &InternalSettings{Settings: &Settings{Values: values}}
+		settings.PostProcessAfterUnmarshalling()
+	default:
+		settings := &ReplicationSettings{Settings: &Settings{Values: values}}
+		settings.PostProcessAfterUnmarshalling()
+	}
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software
+will be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSettingsHistoryRollback(t *testing.T) {
+	fmt.Println("============== Test case start: TestSettingsHistoryRollback =================")
+	defer fmt.Println("============== Test case end: TestSettingsHistoryRollback =================")
+	assert := assert.New(t)
+
+	history := &SettingsHistory{Subject: ReplicationSettingsHistorySubject("uuid/src/tgt")}
+	replicationId, ok := ReplicationIdFromSettingsHistorySubject(history.Subject)
+	assert.True(ok)
+	assert.Equal("uuid/src/tgt", replicationId)
+	_, ok = ReplicationIdFromSettingsHistorySubject(InternalSettingsHistorySubject)
+	assert.False(ok)
+
+	values := []map[string]interface{}{
+		{CheckpointIntervalKey: 600, BatchCountKey: 500},
+		{CheckpointIntervalKey: 300, BatchCountKey: 500},
+		{CheckpointIntervalKey: 300, BatchCountKey: 1000},
+		{CheckpointIntervalKey: 60, BatchCountKey: 2000},
+	}
+	assert.Nil(NewSettingsHistoryEntry(values[0], values[0], "admin", time.Now()))
+	for i := 1; i < len(values); i++ {
+		entry := NewSettingsHistoryEntry(values[i-1], values[i], "admin", time.Now())
+		assert.NotNil(entry)
+		history.AddEntry(entry, 3)
+	}
+	assert.Equal(uint64(3), history.LatestVersion())
+	assert.Equal(map[string]interface{}{BatchCountKey: 500}, history.Entries[1].Before)
+	assert.Equal(map[string]interface{}{BatchCountKey: 1000}, history.Entries[1].After)
+
+	// version 0 is the state before the first change
+	restored, err := history.ValuesAtVersion(0)
+	assert.Nil(err)
+	assert.Equal(values[0], restored)
+	restored, err = history.ValuesAtVersion(1)
+	assert.Nil(err)
+	assert.Equal(values[1], restored)
+	restored, err = history.ValuesAtVersion(3)
+	assert.Nil(err)
+	assert.Len(restored, 0)
+	_, err = history.ValuesAtVersion(4)
+	assert.NotNil(err)
+
+	// the oldest entry is dropped, so version 0 can no longer be restored
+	history.AddEntry(NewSettingsHistoryEntry(values[3], values[0], "admin", time.Now()), 3)
+	assert.Equal(uint64(2), history.Entries[0].Version)
+	_, err = history.ValuesAtVersion(0)
+	assert.NotNil(err)
+	restored, err = history.ValuesAtVersion(1)
+	assert.Nil(err)
+	assert.Equal(values[1], restored)
+}
+
+func TestSettingsHistoryMarshal(t *testing.T) {
+	fmt.Println("============== Test case start: TestSettingsHistoryMarshal =================")
+	defer fmt.Println("============== Test case end: TestSettingsHistoryMarshal =================")
+	assert := assert.New(t)
+
+	history := &SettingsHistory{Subject: ReplicationSettingsHistorySubject("uuid/src/tgt")}
+	entry := NewSettingsHistoryEntry(map[string]interface{}{CheckpointIntervalKey: 600, FilterExpressionKey: ""},
+		map[string]interface{}{CheckpointIntervalKey: 300, FilterExpressionKey: "REGEXP_CONTAINS(META().id, 'a')"}, "admin", time.Now())
+	history.AddEntry(entry, 10)
+
+	data, err := json.Marshal(history)
+	assert.Nil(err)
+	unmarshalled := &SettingsHistory{}
+	assert.Nil(json.Unmarshal(data, unmarshalled))
+	unmarshalled.PostProcessAfterUnmarshalling()
+
+	assert.Equal(history.Subject, unmarshalled.Subject)
+	assert.Len(unmarshalled.Entries, 1)
+	assert.Equal(entry.Before, unmarshalled.Entries[0].Before)
+	assert.Equal(entry.After, unmarshalled.Entries[0].After)
+	assert.Equal("admin", unmarshalled.Entries[0].User)
+}
@@ -29,6 +29,11 @@ const (
 	CheckpointsCatalogKeyPrefix = "ckpt"
 	CheckpointsKeyPrefix        = CheckpointsCatalogKeyPrefix
 	BrokenMappingKey            = "brokenMappings"
+
+	// the VBs that each node has caught up on for a stop when caught up replication are kept under
+	// caughtUp/<specInternalId>/<nodeId>. The spec internal id, unlike the replication id, is never a prefix
+	// of another one, and is not reused by a replication that is deleted and created again
+	CaughtUpCatalogKeyPrefix = "caughtUp"
 )
 
 // Used to keep track of brokenmapping SHA and the count of checkpoint records referring to it
@@ -139,8 +144,9 @@ func (ckpt_svc *CheckpointsService) decodeVbnoFromCkptDocKey(ckptDocKey string)
 	return uint16(vbno), nil
 }
 
+// The replication name in the key could contain BrokenMappingKey, so only the last part of the key is checked
 func (ckpt_svc *CheckpointsService) isBrokenMappingDoc(ckptDocKey string) bool {
-	return strings.Contains(ckptDocKey, BrokenMappingKey)
+	return strings.HasSuffix(ckptDocKey, base.KeyPartsDelimiter+BrokenMappingKey)
 }
 
 func (ckpt_svc *CheckpointsService) DelCheckpointsDocs(replicationId string) error {
@@ -187,6 +193,55 @@ func (ckpt_svc *CheckpointsService) DelCheckpointsDocs(replicationId string) err
 	return fmt.Errorf(base.FlattenErrorMap(errMap))
 }
 
+func getCaughtUpCatalogKey(specInternalId string) string {
+	return CaughtUpCatalogKeyPrefix + base.KeyPartsDelimiter + specInternalId
+}
+
+func getCaughtUpDocKey(specInternalId, nodeId string) string {
+	return getCaughtUpCatalogKey(specInternalId) + base.KeyPartsDelimiter + nodeId
+}
+
+// Records the VBs that a node has caught up on for a stop when caught up replication
+func (ckpt_svc *CheckpointsService) UpsertCaughtUpVBs(specInternalId, nodeId string, vbnos []uint16) error {
+	value, err := json.Marshal(vbnos)
+	if err != nil {
+		return err
+	}
+	return ckpt_svc.metadata_svc.Set(getCaughtUpDocKey(specInternalId, nodeId), value, nil /*rev*/)
+}
+
+// The VBs that all the nodes have caught up on. Nodes record what they have caught up on independently,
+// so the same VB could be returned more than once if it has moved between nodes
+func (ckpt_svc *CheckpointsService) CaughtUpVBs(specInternalId string) ([]uint16, error) {
+	entries, err := ckpt_svc.metadata_svc.GetAllMetadataFromCatalog(getCaughtUpCatalogKey(specInternalId))
+	if err != nil {
+		return nil, err
+	}
+
+	var vbnos []uint16
+	for _, entry := range entries {
+		if entry == nil {
+			continue
+		}
+		var nodeVbnos []uint16
+		err = json.Unmarshal(entry.Value, &nodeVbnos)
+		if err != nil {
+			ckpt_svc.logger.Warnf("Unable to unmarshal caught up vbs %v - %v", entry.Key, err)
+			continue
+		}
+		vbnos = append(vbnos, nodeVbnos...)
+	}
+	return vbnos, nil
+}
+
+func (ckpt_svc *CheckpointsService) DelCaughtUpVBs(specInternalId, nodeId string) error {
+	return ckpt_svc.metadata_svc.Del(getCaughtUpDocKey(specInternalId, nodeId), nil /*rev*/)
+}
+
+func (ckpt_svc *CheckpointsService) DelAllCaughtUpVBs(specInternalId string) error {
+	return ckpt_svc.metadata_svc.DelAllFromCatalog(getCaughtUpCatalogKey(specInternalId))
+}
+
 // Need to have correct accounting after deleting checkpoingsDocs
 func (ckpt_svc *CheckpointsService) PostDelCheckpointsDoc(replicationId string, doc *metadata.CheckpointsDoc) (modified bool, err error) {
 	if doc == nil {
@@ -605,6 +660,12 @@ func (ckpt_svc *CheckpointsService) ReplicationSpecChangeCallback(metadataId str
 		delete(ckpt_svc.cachedSpecs, oldSpec.Id)
 		delete(ckpt_svc.stopTheWorldMtx, oldSpec.Id)
 		ckpt_svc.specsMtx.Unlock()
+		if oldSpec.Settings.GetStopWhenCaughtUp() {
+			err := ckpt_svc.DelAllCaughtUpVBs(oldSpec.InternalId)
+			if err != nil {
+				ckpt_svc.logger.Warnf("Unable to delete the caught up vbs of %v - manual clean up may be required. err=%v", oldSpec.Id, err)
+			}
+		}
 	} else {
 		if oldSpec == nil && newSpec != nil {
 			waitGrp := sync.WaitGroup{}
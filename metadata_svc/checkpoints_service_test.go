@@ -22,6 +22,8 @@ import (
 	service_def "github.com/couchbase/goxdcr/service_def/mocks"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"io/ioutil"
+	"os"
 	"testing"
 )
 
@@ -213,3 +215,41 @@ func generateBrokenMap() metadata.CollectionNamespaceMapping {
 	brokenMap.AddSingleMapping(src2, tgt2)
 	return brokenMap
 }
+
+func TestCkptSvcCaughtUpVBs(t *testing.T) {
+	fmt.Println("============== Test case start: TestCkptSvcCaughtUpVBs =================")
+	defer fmt.Println("============== Test case end: TestCkptSvcCaughtUpVBs =================")
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "ckptSvcCaughtUpVBs")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	metadataSvc, err := NewFileMetadataSvc(dir, log.DefaultLoggerContext)
+	assert.Nil(err)
+	_, loggerCtx, replSpecSvc := setupCkptSvcBoilerPlate()
+	replSpecSvc.On("AllReplicationSpecs").Return(map[string]*metadata.ReplicationSpecification{}, nil)
+	ckptSvc, err := NewCheckpointsService(metadataSvc, loggerCtx, nil, replSpecSvc)
+	assert.Nil(err)
+
+	assert.Nil(ckptSvc.UpsertCaughtUpVBs(internalId, "10.0.0.1:8091", []uint16{0, 1}))
+	assert.Nil(ckptSvc.UpsertCaughtUpVBs(internalId, "10.0.0.2:8091", []uint16{2, 3}))
+	// left behind by an older instance of the replication
+	assert.Nil(ckptSvc.UpsertCaughtUpVBs("oldInternalId", "10.0.0.3:8091", []uint16{4}))
+
+	vbnos, err := ckptSvc.CaughtUpVBs(internalId)
+	assert.Nil(err)
+	assert.ElementsMatch([]uint16{0, 1, 2, 3}, vbnos)
+
+	assert.Nil(ckptSvc.DelCaughtUpVBs(internalId, "10.0.0.1:8091"))
+	vbnos, err = ckptSvc.CaughtUpVBs(internalId)
+	assert.Nil(err)
+	assert.ElementsMatch([]uint16{2, 3}, vbnos)
+
+	assert.Nil(ckptSvc.DelAllCaughtUpVBs(internalId))
+	vbnos, err = ckptSvc.CaughtUpVBs(internalId)
+	assert.Nil(err)
+	assert.Len(vbnos, 0)
+	vbnos, err = ckptSvc.CaughtUpVBs("oldInternalId")
+	assert.Nil(err)
+	assert.Equal([]uint16{4}, vbnos)
+}
@@ -706,6 +706,12 @@ func (a *CollectionsManifestAgent) loadManifestsFromMetakv() (srcErr, tgtErr err
 }
 
 func (a *CollectionsManifestAgent) populateRemoteClusterRefOnce() error {
+	if a.replicationSpec.IsFileExport() {
+		// there is no remote cluster to look up
+		atomic.StoreUint32(&a.remoteClusterRefPopulated, 1)
+		return nil
+	}
+
 	// For a spec to exist, the reference must exist also
 	retryOp := func() error {
 		var getErr error
@@ -1030,6 +1036,14 @@ func (a *CollectionsManifestAgent) refreshTargetCustom(force, lock bool, waitTim
 	var manifest *metadata.CollectionsManifest
 	var ok bool
 	getRetry := func() error {
+		if a.replicationSpec.IsFileExport() {
+			// A file export writes every source collection to its own namespace, so its target mirrors the source
+			manifest = a.srcManifestGetter()
+			if manifest == nil {
+				return fmt.Errorf("Unable to retrieve manifest from source bucket %v\n", a.replicationSpec.SourceBucketName)
+			}
+			return nil
+		}
 		clusterUuid := a.replicationSpec.TargetClusterUUID
 		bucketName := a.replicationSpec.TargetBucketName
 		manifest, err = a.remoteClusterSvc.GetManifestByUuid(clusterUuid, bucketName, force, a.tempAgent)
@@ -1346,6 +1360,10 @@ func (a *CollectionsManifestAgent) remoteClusterHasNoCollectionsCapability() (bo
 		return false, fmt.Errorf("%v - RemoteClusterReference has not finished populating", a.id)
 	}
 
+	if a.replicationSpec.IsFileExport() {
+		return metadata.FileExportCapability().HasCollectionSupport(), nil
+	}
+
 	capability, err := a.remoteClusterSvc.GetCapability(a.remoteClusterRef)
 	if err != nil {
 		return false, fmt.Errorf("%v getting capability: %v", a.id, err)
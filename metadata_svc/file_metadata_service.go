@@ -0,0 +1,485 @@
+// Copyright 2021-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included in
+// the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+// file, in accordance with the Business Source License, use of this software
+// will be governed by the Apache License, Version 2.0, included in the file
+// licenses/APL2.txt.
+
+// metadata service implementation backed by a local directory, for running goxdcr without ns_server's metakv
+package metadata_svc
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/couchbase/cbauth/metakv"
+	"github.com/couchbase/goxdcr/base"
+	"github.com/couchbase/goxdcr/log"
+	"github.com/couchbase/goxdcr/service_def"
+)
+
+const (
+	fileMetadataDataDir    = "data"
+	fileMetadataKeyFile    = "metadata.key"
+	fileMetadataSuffix     = ".json"
+	fileMetadataTmpSuffix  = ".tmp"
+	fileMetadataKeyLen     = 32
+	fileMetadataDirPerm    = 0700
+	fileMetadataFilePerm   = 0600
+	fileMetadataObserverCh = 1
+)
+
+// One metadata entry, as it is written to its own file. Sensitive values are encrypted
+type fileMetadataEntry struct {
+	Value     []byte `json:"value"`
+	Rev       uint64 `json:"rev"`
+	Sensitive bool   `json:"sensitive,omitempty"`
+}
+
+// FileMetadataSvc keeps every metadata entry in a JSON file of its own under dir/data, at the path of its key, and
+// a copy of all of them in memory. Each write goes to a temporary file that is renamed over the entry's file, so
+// that an entry is either the old or the new version after a crash.
+// Revisions are numbers that increase with every write across all the keys, so Set and Del can be made conditional
+// on the revision returned by Get as with metakv. Sensitive values are encrypted with AES-GCM, using a key kept in
+// dir/metadata.key that is generated on first use
+type FileMetadataSvc struct {
+	dir    string
+	aead   cipher.AEAD
+	logger *log.CommonLogger
+
+	entries   map[string]*fileMetadataEntry
+	lastRev   uint64
+	observers map[*fileMetadataObserver]bool
+	mtx       sync.RWMutex
+}
+
+func NewFileMetadataSvc(dir string, logger_ctx *log.LoggerContext) (*FileMetadataSvc, error) {
+	meta_svc := &FileMetadataSvc{
+		dir:       dir,
+		logger:    log.NewLogger("FileMetadataSvc", logger_ctx),
+		entries:   make(map[string]*fileMetadataEntry),
+		observers: make(map[*fileMetadataObserver]bool),
+	}
+
+	err := os.MkdirAll(meta_svc.dataDir(), fileMetadataDirPerm)
+	if err != nil {
+		return nil, err
+	}
+	err = meta_svc.initCipher()
+	if err != nil {
+		return nil, err
+	}
+	err = meta_svc.load()
+	if err != nil {
+		return nil, err
+	}
+	meta_svc.logger.Infof("Loaded %v metadata entries from %v. Last revision is %v", len(meta_svc.entries), dir, meta_svc.lastRev)
+	return meta_svc, nil
+}
+
+func (meta_svc *FileMetadataSvc) dataDir() string {
+	return filepath.Join(meta_svc.dir, fileMetadataDataDir)
+}
+
+func (meta_svc *FileMetadataSvc) initCipher() error {
+	keyPath := filepath.Join(meta_svc.dir, fileMetadataKeyFile)
+	key, err := ioutil.ReadFile(keyPath)
+	if os.IsNotExist(err) {
+		key = make([]byte, fileMetadataKeyLen)
+		_, err = io.ReadFull(rand.Reader, key)
+		if err != nil {
+			return err
+		}
+		err = writeFileAtomically(keyPath, key)
+	}
+	if err != nil {
+		return err
+	}
+	if len(key) != fileMetadataKeyLen {
+		return fmt.Errorf("%v has %v bytes instead of %v", keyPath, len(key), fileMetadataKeyLen)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	meta_svc.aead, err = cipher.NewGCM(block)
+	return err
+}
+
+// Loads all the entries, and removes the temporary files of writes that have not completed
+func (meta_svc *FileMetadataSvc) load() error {
+	return filepath.Walk(meta_svc.dataDir(), func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		if strings.HasSuffix(path, fileMetadataTmpSuffix) {
+			return os.Remove(path)
+		}
+		key, err := meta_svc.keyFromFilePath(path)
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		entry := &fileMetadataEntry{}
+		err = json.Unmarshal(data, entry)
+		if err != nil {
+			return fmt.Errorf("unable to parse %v. err=%v", path, err)
+		}
+		meta_svc.entries[key] = entry
+		if entry.Rev > meta_svc.lastRev {
+			meta_svc.lastRev = entry.Rev
+		}
+		return nil
+	})
+}
+
+// Each part of the key is escaped so that it is a valid file name that cannot refer to another directory
+func (meta_svc *FileMetadataSvc) filePathFromKey(key string) string {
+	parts := strings.Split(key, base.KeyPartsDelimiter)
+	for i, part := range parts {
+		parts[i] = strings.Replace(url.PathEscape(part), ".", "%2E", -1)
+	}
+	return filepath.Join(meta_svc.dataDir(), filepath.Join(parts...)) + fileMetadataSuffix
+}
+
+func (meta_svc *FileMetadataSvc) keyFromFilePath(path string) (string, error) {
+	relPath, err := filepath.Rel(meta_svc.dataDir(), strings.TrimSuffix(path, fileMetadataSuffix))
+	if err != nil {
+		return "", err
+	}
+	parts := strings.Split(filepath.ToSlash(relPath), "/")
+	for i, part := range parts {
+		parts[i], err = url.PathUnescape(part)
+		if err != nil {
+			return "", err
+		}
+	}
+	return strings.Join(parts, base.KeyPartsDelimiter), nil
+}
+
+func writeFileAtomically(path string, data []byte) error {
+	tmpPath := path + fileMetadataTmpSuffix
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, fileMetadataFilePerm)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+func (meta_svc *FileMetadataSvc) encrypt(value []byte) ([]byte, error) {
+	nonce := make([]byte, meta_svc.aead.NonceSize())
+	_, err := io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return nil, err
+	}
+	return meta_svc.aead.Seal(nonce, nonce, value, nil), nil
+}
+
+func (meta_svc *FileMetadataSvc) decrypt(entry *fileMetadataEntry) ([]byte, error) {
+	if !entry.Sensitive {
+		return entry.Value, nil
+	}
+	nonceSize := meta_svc.aead.NonceSize()
+	if len(entry.Value) < nonceSize {
+		return nil, errors.New("encrypted value is too short")
+	}
+	return meta_svc.aead.Open(nil, entry.Value[:nonceSize], entry.Value[nonceSize:], nil)
+}
+
+// Revisions are handed out as uint64, but may come back as other numeric types once they have been through JSON
+func revFromInterface(rev interface{}) (uint64, bool) {
+	switch typedRev := rev.(type) {
+	case uint64:
+		return typedRev, true
+	case int:
+		return uint64(typedRev), typedRev >= 0
+	case int64:
+		return uint64(typedRev), typedRev >= 0
+	case float64:
+		return uint64(typedRev), typedRev >= 0
+	case json.Number:
+		intRev, err := typedRev.Int64()
+		return uint64(intRev), err == nil && intRev >= 0
+	default:
+		return 0, false
+	}
+}
+
+func (meta_svc *FileMetadataSvc) Get(key string) ([]byte, interface{}, error) {
+	meta_svc.mtx.RLock()
+	defer meta_svc.mtx.RUnlock()
+	entry, exists := meta_svc.entries[key]
+	if !exists {
+		return nil, nil, service_def.MetadataNotFoundErr
+	}
+	value, err := meta_svc.decrypt(entry)
+	if err != nil {
+		return nil, nil, err
+	}
+	return base.DeepCopyByteArray(value), entry.Rev, nil
+}
+
+func (meta_svc *FileMetadataSvc) Add(key string, value []byte) error {
+	return meta_svc.write(key, value, nil, true /*addOnly*/, false)
+}
+
+func (meta_svc *FileMetadataSvc) AddSensitive(key string, value []byte) error {
+	return meta_svc.write(key, value, nil, true /*addOnly*/, true)
+}
+
+func (meta_svc *FileMetadataSvc) Set(key string, value []byte, rev interface{}) error {
+	return meta_svc.write(key, value, rev, false /*addOnly*/, false)
+}
+
+func (meta_svc *FileMetadataSvc) SetSensitive(key string, value []byte, rev interface{}) error {
+	return meta_svc.write(key, value, rev, false /*addOnly*/, true)
+}
+
+// As with metakv, a nil rev writes the entry unconditionally
+func (meta_svc *FileMetadataSvc) write(key string, value []byte, rev interface{}, addOnly, sensitive bool) error {
+	storedValue := base.DeepCopyByteArray(value)
+	var err error
+	if sensitive {
+		storedValue, err = meta_svc.encrypt(value)
+		if err != nil {
+			return err
+		}
+	}
+
+	meta_svc.mtx.Lock()
+	defer meta_svc.mtx.Unlock()
+
+	curEntry, exists := meta_svc.entries[key]
+	if addOnly && exists {
+		return service_def.ErrorKeyAlreadyExist
+	}
+	if rev != nil {
+		expectedRev, ok := revFromInterface(rev)
+		if !ok || !exists || curEntry.Rev != expectedRev {
+			return service_def.ErrorRevisionMismatch
+		}
+	}
+
+	entry := &fileMetadataEntry{Value: storedValue, Rev: meta_svc.lastRev + 1, Sensitive: sensitive}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	path := meta_svc.filePathFromKey(key)
+	err = os.MkdirAll(filepath.Dir(path), fileMetadataDirPerm)
+	if err == nil {
+		err = writeFileAtomically(path, data)
+	}
+	if err != nil {
+		meta_svc.logger.Warnf("Failed to write key=%v, err=%v\n", key, err)
+		return err
+	}
+
+	meta_svc.lastRev = entry.Rev
+	meta_svc.entries[key] = entry
+	meta_svc.notifyObservers(key, base.DeepCopyByteArray(value), entry.Rev)
+	return nil
+}
+
+// As with metakv, a nil rev deletes the entry unconditionally, and deleting an entry that does not exist succeeds
+func (meta_svc *FileMetadataSvc) Del(key string, rev interface{}) error {
+	meta_svc.mtx.Lock()
+	defer meta_svc.mtx.Unlock()
+	return meta_svc.delNoLock(key, rev)
+}
+
+func (meta_svc *FileMetadataSvc) delNoLock(key string, rev interface{}) error {
+	curEntry, exists := meta_svc.entries[key]
+	if rev != nil {
+		expectedRev, ok := revFromInterface(rev)
+		if !ok || !exists || curEntry.Rev != expectedRev {
+			return service_def.ErrorRevisionMismatch
+		}
+	}
+	if !exists {
+		return nil
+	}
+
+	err := os.Remove(meta_svc.filePathFromKey(key))
+	if err != nil && !os.IsNotExist(err) {
+		meta_svc.logger.Warnf("Failed to delete key=%v, err=%v\n", key, err)
+		return err
+	}
+	delete(meta_svc.entries, key)
+	meta_svc.notifyObservers(key, nil, nil)
+	return nil
+}
+
+func (meta_svc *FileMetadataSvc) AddWithCatalog(catalogKey, key string, value []byte) error {
+	// ignore catalogKey
+	return meta_svc.Add(key, value)
+}
+
+func (meta_svc *FileMetadataSvc) AddSensitiveWithCatalog(catalogKey, key string, value []byte) error {
+	// ignore catalogKey
+	return meta_svc.AddSensitive(key, value)
+}
+
+func (meta_svc *FileMetadataSvc) DelWithCatalog(catalogKey, key string, rev interface{}) error {
+	// ignore catalogKey
+	return meta_svc.Del(key, rev)
+}
+
+// The keys under a catalog, at any depth, as with metakv
+func (meta_svc *FileMetadataSvc) catalogKeysNoLock(catalogKey string) []string {
+	prefix := catalogKey + base.KeyPartsDelimiter
+	var keys []string
+	for key := range meta_svc.entries {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (meta_svc *FileMetadataSvc) DelAllFromCatalog(catalogKey string) error {
+	meta_svc.mtx.Lock()
+	defer meta_svc.mtx.Unlock()
+	for _, key := range meta_svc.catalogKeysNoLock(catalogKey) {
+		err := meta_svc.delNoLock(key, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (meta_svc *FileMetadataSvc) GetAllMetadataFromCatalog(catalogKey string) ([]*service_def.MetadataEntry, error) {
+	meta_svc.mtx.RLock()
+	defer meta_svc.mtx.RUnlock()
+	entries := make([]*service_def.MetadataEntry, 0)
+	for _, key := range meta_svc.catalogKeysNoLock(catalogKey) {
+		entry := meta_svc.entries[key]
+		value, err := meta_svc.decrypt(entry)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, &service_def.MetadataEntry{Key: key, Value: base.DeepCopyByteArray(value), Rev: entry.Rev})
+	}
+	return entries, nil
+}
+
+func (meta_svc *FileMetadataSvc) GetAllKeysFromCatalog(catalogKey string) ([]string, error) {
+	meta_svc.mtx.RLock()
+	defer meta_svc.mtx.RUnlock()
+	keys := meta_svc.catalogKeysNoLock(catalogKey)
+	if keys == nil {
+		keys = make([]string, 0)
+	}
+	return keys, nil
+}
+
+// A change feed of the entries under one directory
+type fileMetadataObserver struct {
+	dirpath  string
+	queue    []metakv.KVEntry
+	queueMtx sync.Mutex
+	notifyCh chan bool
+}
+
+func (observer *fileMetadataObserver) push(kve metakv.KVEntry) {
+	observer.queueMtx.Lock()
+	observer.queue = append(observer.queue, kve)
+	observer.queueMtx.Unlock()
+	select {
+	case observer.notifyCh <- true:
+	default:
+	}
+}
+
+func (observer *fileMetadataObserver) popAll() []metakv.KVEntry {
+	observer.queueMtx.Lock()
+	defer observer.queueMtx.Unlock()
+	kves := observer.queue
+	observer.queue = nil
+	return kves
+}
+
+// Called with the write lock held, so that the observers see the changes in the order they were made
+func (meta_svc *FileMetadataSvc) notifyObservers(key string, value []byte, rev interface{}) {
+	path := getPathFromKey(key)
+	for observer := range meta_svc.observers {
+		if strings.HasPrefix(path, observer.dirpath) {
+			observer.push(metakv.KVEntry{Path: path, Value: value, Rev: rev})
+		}
+	}
+}
+
+// RunObserveChildren follows metakv.RunObserveChildrenV2, so that it can be used by the metakv change listeners.
+// The callback is called with the entries under dirpath, which starts and ends with "/", and then with every change
+// to them, until cancel is closed or the callback returns an error. Deleted entries have a nil value and rev
+func (meta_svc *FileMetadataSvc) RunObserveChildren(dirpath string, callback func(kve metakv.KVEntry) error, cancel <-chan struct{}) error {
+	observer := &fileMetadataObserver{
+		dirpath:  dirpath,
+		notifyCh: make(chan bool, fileMetadataObserverCh),
+	}
+
+	meta_svc.mtx.Lock()
+	catalogKey := strings.TrimSuffix(GetKeyFromPath(dirpath), base.KeyPartsDelimiter)
+	for _, key := range meta_svc.catalogKeysNoLock(catalogKey) {
+		entry := meta_svc.entries[key]
+		value, err := meta_svc.decrypt(entry)
+		if err != nil {
+			meta_svc.mtx.Unlock()
+			return err
+		}
+		observer.push(metakv.KVEntry{Path: getPathFromKey(key), Value: base.DeepCopyByteArray(value), Rev: entry.Rev})
+	}
+	meta_svc.observers[observer] = true
+	meta_svc.mtx.Unlock()
+
+	defer func() {
+		meta_svc.mtx.Lock()
+		delete(meta_svc.observers, observer)
+		meta_svc.mtx.Unlock()
+	}()
+
+	for {
+		select {
+		case <-cancel:
+			return nil
+		case <-observer.notifyCh:
+			for _, kve := range observer.popAll() {
+				err := callback(kve)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,205 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software
+will be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package metadata_svc
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/couchbase/cbauth/metakv"
+	"github.com/couchbase/goxdcr/log"
+	"github.com/couchbase/goxdcr/service_def"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileMetadataSvcRevisions(t *testing.T) {
+	fmt.Println("============== Test case start: TestFileMetadataSvcRevisions =================")
+	defer fmt.Println("============== Test case end: TestFileMetadataSvcRevisions =================")
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "fileMetadataSvc")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	metaSvc, err := NewFileMetadataSvc(dir, log.DefaultLoggerContext)
+	assert.Nil(err)
+
+	_, _, err = metaSvc.Get("remoteCluster/ref1")
+	assert.Equal(service_def.MetadataNotFoundErr, err)
+
+	assert.Nil(metaSvc.Add("remoteCluster/ref1", []byte("v1")))
+	assert.Equal(service_def.ErrorKeyAlreadyExist, metaSvc.Add("remoteCluster/ref1", []byte("v2")))
+	value, rev, err := metaSvc.Get("remoteCluster/ref1")
+	assert.Nil(err)
+	assert.Equal("v1", string(value))
+
+	// writes are conditional on the revision, unless it is nil
+	assert.Nil(metaSvc.Set("remoteCluster/ref1", []byte("v2"), rev))
+	assert.Equal(service_def.ErrorRevisionMismatch, metaSvc.Set("remoteCluster/ref1", []byte("v3"), rev))
+	assert.Equal(service_def.ErrorRevisionMismatch, metaSvc.Del("remoteCluster/ref1", rev))
+	assert.Nil(metaSvc.Set("remoteCluster/ref1", []byte("v3"), nil))
+	value, rev, err = metaSvc.Get("remoteCluster/ref1")
+	assert.Nil(err)
+	assert.Equal("v3", string(value))
+
+	// revisions that have been through JSON still match
+	assert.Nil(metaSvc.Set("remoteCluster/ref1", []byte("v4"), float64(rev.(uint64))))
+
+	// the entries survive a restart, and later revisions keep increasing
+	metaSvc, err = NewFileMetadataSvc(dir, log.DefaultLoggerContext)
+	assert.Nil(err)
+	value, reloadedRev, err := metaSvc.Get("remoteCluster/ref1")
+	assert.Nil(err)
+	assert.Equal("v4", string(value))
+	assert.True(reloadedRev.(uint64) > rev.(uint64))
+	assert.Nil(metaSvc.Set("remoteCluster/ref2", []byte("v1"), nil))
+	_, newRev, _ := metaSvc.Get("remoteCluster/ref2")
+	assert.True(newRev.(uint64) > reloadedRev.(uint64))
+
+	assert.Nil(metaSvc.Del("remoteCluster/ref1", reloadedRev))
+	_, _, err = metaSvc.Get("remoteCluster/ref1")
+	assert.Equal(service_def.MetadataNotFoundErr, err)
+	assert.Nil(metaSvc.Del("remoteCluster/ref1", nil))
+}
+
+func TestFileMetadataSvcCatalogs(t *testing.T) {
+	fmt.Println("============== Test case start: TestFileMetadataSvcCatalogs =================")
+	defer fmt.Println("============== Test case end: TestFileMetadataSvcCatalogs =================")
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "fileMetadataSvc")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	metaSvc, err := NewFileMetadataSvc(dir, log.DefaultLoggerContext)
+	assert.Nil(err)
+
+	assert.Nil(metaSvc.AddWithCatalog("ckpt/repl1", "ckpt/repl1/0", []byte("0")))
+	assert.Nil(metaSvc.AddWithCatalog("ckpt/repl1", "ckpt/repl1/1", []byte("1")))
+	assert.Nil(metaSvc.AddWithCatalog("ckpt/repl10", "ckpt/repl10/0", []byte("0")))
+	// keys that are not valid file names
+	assert.Nil(metaSvc.AddWithCatalog("ckpt/repl1", "ckpt/repl1/../x y", []byte("2")))
+
+	keys, err := metaSvc.GetAllKeysFromCatalog("ckpt/repl1")
+	assert.Nil(err)
+	assert.Equal([]string{"ckpt/repl1/../x y", "ckpt/repl1/0", "ckpt/repl1/1"}, keys)
+	entries, err := metaSvc.GetAllMetadataFromCatalog("ckpt")
+	assert.Nil(err)
+	assert.Len(entries, 4)
+
+	// the escaped keys are read back as they were written
+	metaSvc, err = NewFileMetadataSvc(dir, log.DefaultLoggerContext)
+	assert.Nil(err)
+	value, _, err := metaSvc.Get("ckpt/repl1/../x y")
+	assert.Nil(err)
+	assert.Equal("2", string(value))
+
+	assert.Nil(metaSvc.DelAllFromCatalog("ckpt/repl1"))
+	keys, err = metaSvc.GetAllKeysFromCatalog("ckpt")
+	assert.Nil(err)
+	assert.Equal([]string{"ckpt/repl10/0"}, keys)
+}
+
+func TestFileMetadataSvcSensitive(t *testing.T) {
+	fmt.Println("============== Test case start: TestFileMetadataSvcSensitive =================")
+	defer fmt.Println("============== Test case end: TestFileMetadataSvcSensitive =================")
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "fileMetadataSvc")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	metaSvc, err := NewFileMetadataSvc(dir, log.DefaultLoggerContext)
+	assert.Nil(err)
+
+	secret := []byte("password=secret")
+	assert.Nil(metaSvc.AddSensitiveWithCatalog("remoteCluster", "remoteCluster/ref1", secret))
+	value, _, err := metaSvc.Get("remoteCluster/ref1")
+	assert.Nil(err)
+	assert.Equal(secret, value)
+
+	data, err := ioutil.ReadFile(metaSvc.filePathFromKey("remoteCluster/ref1"))
+	assert.Nil(err)
+	assert.False(bytes.Contains(data, secret))
+
+	// the value cannot be read back with another key
+	metaSvc, err = NewFileMetadataSvc(dir, log.DefaultLoggerContext)
+	assert.Nil(err)
+	value, _, err = metaSvc.Get("remoteCluster/ref1")
+	assert.Nil(err)
+	assert.Equal(secret, value)
+	assert.Nil(ioutil.WriteFile(filepath.Join(dir, fileMetadataKeyFile), bytes.Repeat([]byte{1}, fileMetadataKeyLen), 0600))
+	metaSvc, err = NewFileMetadataSvc(dir, log.DefaultLoggerContext)
+	assert.Nil(err)
+	_, _, err = metaSvc.Get("remoteCluster/ref1")
+	assert.NotNil(err)
+}
+
+func TestFileMetadataSvcObserve(t *testing.T) {
+	fmt.Println("============== Test case start: TestFileMetadataSvcObserve =================")
+	defer fmt.Println("============== Test case end: TestFileMetadataSvcObserve =================")
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "fileMetadataSvc")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	metaSvc, err := NewFileMetadataSvc(dir, log.DefaultLoggerContext)
+	assert.Nil(err)
+	assert.Nil(metaSvc.Add("replicationSpec/spec1", []byte("1")))
+	assert.Nil(metaSvc.Add("remoteCluster/ref1", []byte("1")))
+
+	kveCh := make(chan metakv.KVEntry, 10)
+	cancelCh := make(chan struct{})
+	doneCh := make(chan error)
+	go func() {
+		doneCh <- metaSvc.RunObserveChildren(GetCatalogPathFromCatalogKey("replicationSpec"), func(kve metakv.KVEntry) error {
+			kveCh <- kve
+			return nil
+		}, cancelCh)
+	}()
+
+	nextEntry := func() metakv.KVEntry {
+		select {
+		case kve := <-kveCh:
+			return kve
+		case <-time.After(5 * time.Second):
+			assert.FailNow("timed out waiting for metadata change")
+			return metakv.KVEntry{}
+		}
+	}
+
+	// existing entries come first
+	kve := nextEntry()
+	assert.Equal("/replicationSpec/spec1", kve.Path)
+	assert.Equal("1", string(kve.Value))
+
+	assert.Nil(metaSvc.Set("remoteCluster/ref1", []byte("2"), nil))
+	assert.Nil(metaSvc.Set("replicationSpec/spec1", []byte("2"), nil))
+	assert.Nil(metaSvc.Del("replicationSpec/spec1", nil))
+	kve = nextEntry()
+	assert.Equal("/replicationSpec/spec1", kve.Path)
+	assert.Equal("2", string(kve.Value))
+	assert.NotNil(kve.Rev)
+	kve = nextEntry()
+	assert.Equal("/replicationSpec/spec1", kve.Path)
+	assert.Nil(kve.Value)
+	assert.Nil(kve.Rev)
+
+	close(cancelCh)
+	assert.Nil(<-doneCh)
+	assert.Len(kveCh, 0)
+}
@@ -3699,6 +3699,10 @@ func (service *RemoteClusterService) RequestRemoteMonitoring(spec *metadata.Repl
 	if spec == nil {
 		return fmt.Errorf("RequestRemoteMonitoring() passed in nil spec")
 	}
+	if spec.IsFileExport() {
+		// file export replications have no remote cluster to monitor
+		return nil
+	}
 
 	agent, err := service.getAgentByReplSpec(spec)
 	if err != nil {
@@ -3711,6 +3715,10 @@ func (service *RemoteClusterService) UnRequestRemoteMonitoring(spec *metadata.Re
 	if spec == nil {
 		return fmt.Errorf("UnRequestRemoteMonitoring() passed in nil spec")
 	}
+	if spec.IsFileExport() {
+		// file export replications have no remote cluster to monitor
+		return nil
+	}
 
 	agent, err := service.getAgentByReplSpec(spec)
 	if err != nil {
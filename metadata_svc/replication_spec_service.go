@@ -297,10 +297,10 @@ func (service *ReplicationSpecService) getRemoteReference(errorMap base.ErrorMap
 	return targetClusterRef, remote_connStr, remote_userName, remote_password, httpAuthMech, certificate, sanInCertificate, clientCertificate, clientKey
 }
 
-func (service *ReplicationSpecService) validateReplicationSpecDoesNotAlreadyExist(errorMap base.ErrorMap, sourceBucket string, targetClusterRef *metadata.RemoteClusterReference, targetBucket string) {
-	stopFunc := service.utils.StartDiagStopwatch(fmt.Sprintf("validateReplicationSpecDoesNotAlreadyExist(%v, _, %v)", sourceBucket, targetBucket), base.DiagInternalThreshold)
+func (service *ReplicationSpecService) validateReplicationSpecDoesNotAlreadyExist(errorMap base.ErrorMap, sourceBucket string, targetClusterRef *metadata.RemoteClusterReference, targetBucket, replicationName string) {
+	stopFunc := service.utils.StartDiagStopwatch(fmt.Sprintf("validateReplicationSpecDoesNotAlreadyExist(%v, _, %v, %v)", sourceBucket, targetBucket, replicationName), base.DiagInternalThreshold)
 	defer stopFunc()
-	repId := metadata.ReplicationId(sourceBucket, targetClusterRef.Uuid(), targetBucket)
+	repId := metadata.NamedReplicationId(sourceBucket, targetClusterRef.Uuid(), targetBucket, replicationName)
 	_, err := service.replicationSpec(repId)
 	if err == nil {
 		errorMap[base.PlaceHolderFieldKey] = errors.New(ReplicationSpecAlreadyExistErrorMessage)
@@ -350,14 +350,22 @@ func (service *ReplicationSpecService) validateXmemSettings(errorMap base.ErrorM
  * Main Validation routine, supplemented by multiple helper sub-routines.
  * Each sub-routine may be daisy chained by variables that would be helpful for further subroutines.
  */
-func (service *ReplicationSpecService) ValidateNewReplicationSpec(sourceBucket, targetCluster, targetBucket string, settings metadata.ReplicationSettingsMap, performRemoteValidation bool) (string, string, *metadata.RemoteClusterReference, base.ErrorMap, error, service_def.UIWarnings) {
+func (service *ReplicationSpecService) ValidateNewReplicationSpec(sourceBucket, targetCluster, targetBucket, replicationName string, settings metadata.ReplicationSettingsMap, performRemoteValidation bool) (string, string, *metadata.RemoteClusterReference, base.ErrorMap, error, service_def.UIWarnings) {
 	errMap := make(base.ErrorMap)
-	service.logger.Infof("Start ValidateAddReplicationSpec, sourceBucket=%v, targetCluster=%v, targetBucket=%v, performRPC=%v settings=%v", sourceBucket, targetCluster, targetBucket, performRemoteValidation, settings.CloneAndRedact())
-	defer service.logger.Infof("Finished ValidateAddReplicationSpec, sourceBucket=%v, targetCluster=%v, targetBucket=%v, errorMap=%v, performRPC=%v settings=%v", sourceBucket, targetCluster, targetBucket, errMap, performRemoteValidation, settings.CloneAndRedact())
+	service.logger.Infof("Start ValidateAddReplicationSpec, sourceBucket=%v, targetCluster=%v, targetBucket=%v, replicationName=%v, performRPC=%v settings=%v", sourceBucket, targetCluster, targetBucket, replicationName, performRemoteValidation, settings.CloneAndRedact())
+	defer service.logger.Infof("Finished ValidateAddReplicationSpec, sourceBucket=%v, targetCluster=%v, targetBucket=%v, replicationName=%v, errorMap=%v, performRPC=%v settings=%v", sourceBucket, targetCluster, targetBucket, replicationName, errMap, performRemoteValidation, settings.CloneAndRedact())
 
 	stopFunc := service.utils.StartDiagStopwatch(fmt.Sprintf("ValidateNewReplicationSpec(%v, %v, %v)", sourceBucket, targetCluster, targetBucket), base.DiagNetworkThreshold)
 	defer stopFunc()
 
+	if replType, ok := settings[metadata.ReplicationTypeKey].(string); ok && replType == metadata.ReplicationTypeFile {
+		sourceBucketUUID, err, warnings := service.validateNewFileExportSpec(errMap, sourceBucket, targetBucket, replicationName, settings)
+		if len(errMap) > 0 || err != nil {
+			return "", "", nil, errMap, err, nil
+		}
+		return sourceBucketUUID, "", nil, errMap, nil, warnings
+	}
+
 	var errMapMtx sync.Mutex
 	var srcSideWaitGrpPhase1 sync.WaitGroup
 	var tgtSideWaitGrpPhase1 sync.WaitGroup
@@ -434,7 +442,7 @@ func (service *ReplicationSpecService) ValidateNewReplicationSpec(sourceBucket,
 		tgtSideWaitGrpPhase3.Add(1)
 		go func() {
 			defer tgtSideWaitGrpPhase3.Done()
-			service.validateReplicationSpecDoesNotAlreadyExist(validateReplDNEErrMap, sourceBucket, targetClusterRef, targetBucket)
+			service.validateReplicationSpecDoesNotAlreadyExist(validateReplDNEErrMap, sourceBucket, targetClusterRef, targetBucket, replicationName)
 			if len(validateReplDNEErrMap) > 0 {
 				errMapMtx.Lock()
 				base.ConcatenateErrors(errMap, validateReplDNEErrMap, math.MaxInt32, nil)
@@ -506,7 +514,7 @@ func (service *ReplicationSpecService) ValidateNewReplicationSpec(sourceBucket,
 		return "", "", nil, errMap, nil, nil
 	}
 
-	err, warnings := service.validateReplicationSettingsInternal(errMap, sourceBucket, targetCluster, targetBucket, settings, targetClusterRef, remoteConnstr, remoteUsername, remotePassword, httpAuthMech, certificate, sanInCertificate, clientCertificate, clientKey, targetKVVBMap, targetBucketInfo, true, performRemoteValidation)
+	err, warnings := service.validateReplicationSettingsInternal(errMap, sourceBucket, targetCluster, targetBucket, replicationName, settings, targetClusterRef, remoteConnstr, remoteUsername, remotePassword, httpAuthMech, certificate, sanInCertificate, clientCertificate, clientKey, targetKVVBMap, targetBucketInfo, true, performRemoteValidation)
 	if len(errMap) > 0 || err != nil {
 		return "", "", nil, errMap, err, nil
 	}
@@ -516,6 +524,11 @@ func (service *ReplicationSpecService) ValidateNewReplicationSpec(sourceBucket,
 		return "", "", nil, errMap, nil, nil
 	}
 
+	service.validateConflictResolutionStrategy(errMap, sourceConflictResolutionType, settings)
+	if len(errMap) > 0 {
+		return "", "", nil, errMap, nil, nil
+	}
+
 	if sourceConflictResolutionType == base.ConflictResolutionType_Custom {
 		if _, ok := settings[metadata.MergeFunctionMappingKey]; !ok {
 			errMsg := fmt.Sprintf("Replication setting %v is required for custom conflict resolution.", base.MergeFunctionMappingKey)
@@ -531,7 +544,28 @@ func (service *ReplicationSpecService) ValidateNewReplicationSpec(sourceBucket,
 	return sourceBucketUUID, targetBucketUUID, targetClusterRef, errMap, nil, warnings
 }
 
-func (service *ReplicationSpecService) ValidateReplicationSettings(sourceBucket, targetCluster, targetBucket string, settings metadata.ReplicationSettingsMap, performRemoteValidation bool) (base.ErrorMap, error, service_def.UIWarnings) {
+// File export replications have no remote cluster reference nor target bucket. Only the source bucket and the
+// target agnostic settings are validated
+func (service *ReplicationSpecService) validateNewFileExportSpec(errMap base.ErrorMap, sourceBucket, targetBucket, replicationName string, settings metadata.ReplicationSettingsMap) (string, error, service_def.UIWarnings) {
+	sourceBucketUUID, _, _, err := service.validateSourceBucket(errMap, sourceBucket, "", targetBucket)
+	if len(errMap) > 0 || err != nil {
+		return "", err, nil
+	}
+
+	repId := metadata.NamedReplicationId(sourceBucket, base.FileExportTargetClusterUUID, targetBucket, replicationName)
+	if _, err = service.replicationSpec(repId); err == nil {
+		errMap[base.PlaceHolderFieldKey] = errors.New(ReplicationSpecAlreadyExistErrorMessage)
+		return "", nil, nil
+	}
+
+	err, warnings := service.validateReplicationSettingsInternal(errMap, sourceBucket, "", targetBucket, replicationName, settings, nil /*targetClusterRef*/, "", "", "", base.HttpAuthMechPlain, nil, false, nil, nil, nil, nil, true /*newSettings*/, false /*performTargetValidation*/)
+	if len(errMap) > 0 || err != nil {
+		return "", err, nil
+	}
+	return sourceBucketUUID, nil, warnings
+}
+
+func (service *ReplicationSpecService) ValidateReplicationSettings(sourceBucket, targetCluster, targetBucket, replicationName string, settings metadata.ReplicationSettingsMap, performRemoteValidation bool) (base.ErrorMap, error, service_def.UIWarnings) {
 	var errorMap base.ErrorMap = make(base.ErrorMap)
 
 	targetClusterRef, remote_connStr, remote_userName, remote_password, httpAuthMech, certificate, sanInCertificate, clientCertificate, clientKey := service.getRemoteReference(errorMap, targetCluster)
@@ -544,6 +578,18 @@ func (service *ReplicationSpecService) ValidateReplicationSettings(sourceBucket,
 		return nil, err, nil
 	}
 
+	if strategy, ok := settings[metadata.ConflictResolutionStrategyKey].(string); ok && strategy != base.ConflictResolutionStrategyDefault {
+		// the source and target buckets of a replication have the same conflict resolution type
+		_, _, sourceConflictResolutionType, err := service.validateSourceBucket(errorMap, sourceBucket, targetCluster, targetBucket)
+		if len(errorMap) > 0 || err != nil {
+			return errorMap, err, nil
+		}
+		service.validateConflictResolutionStrategy(errorMap, sourceConflictResolutionType, settings)
+		if len(errorMap) > 0 {
+			return errorMap, nil, nil
+		}
+	}
+
 	var targetBucketInfo map[string]interface{}
 	var targetKVVBMap map[string][]uint16
 	if performRemoteValidation {
@@ -553,11 +599,22 @@ func (service *ReplicationSpecService) ValidateReplicationSettings(sourceBucket,
 		}
 	}
 
-	err, warnings := service.validateReplicationSettingsInternal(errorMap, sourceBucket, targetCluster, targetBucket, settings, targetClusterRef, remote_connStr, remote_userName, remote_password, httpAuthMech, certificate, sanInCertificate, clientCertificate, clientKey, targetKVVBMap, targetBucketInfo, false, performRemoteValidation)
+	err, warnings := service.validateReplicationSettingsInternal(errorMap, sourceBucket, targetCluster, targetBucket, replicationName, settings, targetClusterRef, remote_connStr, remote_userName, remote_password, httpAuthMech, certificate, sanInCertificate, clientCertificate, clientKey, targetKVVBMap, targetBucketInfo, false, performRemoteValidation)
 	return errorMap, err, warnings
 }
 
-func (service *ReplicationSpecService) validateReplicationSettingsInternal(errorMap base.ErrorMap, sourceBucket, targetCluster, targetBucket string, settings metadata.ReplicationSettingsMap, targetClusterRef *metadata.RemoteClusterReference, remote_connStr, remote_userName, remote_password string, httpAuthMech base.HttpAuthMech, certificate []byte, sanInCertificate bool, clientCertificate, clientKey []byte, targetKVVBMap map[string][]uint16, targetBucketInfo map[string]interface{}, newSettings, performTargetValidation bool) (error, service_def.UIWarnings) {
+// Conflict resolution strategies take over the source side conflict resolution that custom conflict resolution relies on
+func (service *ReplicationSpecService) validateConflictResolutionStrategy(errorMap base.ErrorMap, sourceConflictResolutionType string, settings metadata.ReplicationSettingsMap) {
+	strategy, ok := settings[metadata.ConflictResolutionStrategyKey].(string)
+	if ok && strategy != base.ConflictResolutionStrategyDefault && sourceConflictResolutionType == base.ConflictResolutionType_Custom {
+		errMsg := fmt.Sprintf("Replication setting %v cannot be used with custom conflict resolution.", base.ConflictResolutionStrategyKey)
+		service.logger.Errorf(errMsg)
+		errorMap[base.ConflictResolutionStrategyKey] = errors.New(errMsg)
+	}
+}
+
+// targetClusterRef is nil for file export replications, which are never validated against a target
+func (service *ReplicationSpecService) validateReplicationSettingsInternal(errorMap base.ErrorMap, sourceBucket, targetCluster, targetBucket, replicationName string, settings metadata.ReplicationSettingsMap, targetClusterRef *metadata.RemoteClusterReference, remote_connStr, remote_userName, remote_password string, httpAuthMech base.HttpAuthMech, certificate []byte, sanInCertificate bool, clientCertificate, clientKey []byte, targetKVVBMap map[string][]uint16, targetBucketInfo map[string]interface{}, newSettings, performTargetValidation bool) (error, service_def.UIWarnings) {
 	var populateErr error
 	var err error
 
@@ -632,7 +689,11 @@ func (service *ReplicationSpecService) validateReplicationSettingsInternal(error
 		}
 	}
 
-	service.appendGoMaxProcsWarnings(sourceBucket, targetClusterRef, targetBucket, warnings, settings)
+	targetClusterUUID := base.FileExportTargetClusterUUID
+	if targetClusterRef != nil {
+		targetClusterUUID = targetClusterRef.Uuid()
+	}
+	service.appendGoMaxProcsWarnings(sourceBucket, targetClusterUUID, targetBucket, replicationName, warnings, settings)
 	return nil, warnings
 }
 
@@ -1374,6 +1435,11 @@ func (service *ReplicationSpecService) gcTargetBucket(spec *metadata.Replication
 		return false, base.ErrorInvalidInput
 	}
 
+	if spec.IsFileExport() {
+		// there is no target bucket to lose
+		return false, nil
+	}
+
 	ref, err := service.remote_cluster_svc.RemoteClusterByUuid(spec.TargetClusterUUID, false /*refresh*/)
 	if err != nil {
 		service.logger.Warnf("Unable to retrieve reference from spec %v due to %v", spec.Id, err.Error())
@@ -1582,14 +1648,14 @@ func (service *ReplicationSpecService) GetDerivedObj(specId string) (interface{}
 	return cachedObj.derivedObj, nil
 }
 
-func (service *ReplicationSpecService) appendGoMaxProcsWarnings(sourceBucketName string, ref *metadata.RemoteClusterReference, targetBucket string, warnings service_def.UIWarnings, settings metadata.ReplicationSettingsMap) {
+func (service *ReplicationSpecService) appendGoMaxProcsWarnings(sourceBucketName string, targetClusterUUID string, targetBucket string, replicationName string, warnings service_def.UIWarnings, settings metadata.ReplicationSettingsMap) {
 	currentGoMaxProcs := runtime.GOMAXPROCS(0)
 	sourceNozzleCnt, srcExists := settings[metadata.SourceNozzlePerNodeKey].(int)
 	targetNozzleCnt, tgtExists := settings[metadata.TargetNozzlePerNodeKey].(int)
 
 	if !srcExists || !tgtExists {
 		// This can happen when replication is being updated - fetch existing spec
-		replId := metadata.ReplicationId(sourceBucketName, ref.Uuid(), targetBucket)
+		replId := metadata.NamedReplicationId(sourceBucketName, targetClusterUUID, targetBucket, replicationName)
 		currentSpec, specErr := service.replicationSpec(replId)
 		var currentSettings *metadata.ReplicationSettings
 		if specErr != nil || currentSpec == nil {
@@ -265,11 +265,37 @@ func TestValidateNewReplicationSpec(t *testing.T) {
 
 	// Assume XMEM replication type
 	settings[metadata.ReplicationTypeKey] = metadata.ReplicationTypeXmem
-	_, _, _, errMap, _, _ := replSpecSvc.ValidateNewReplicationSpec(sourceBucket, targetCluster, targetBucket, settings, false)
+	_, _, _, errMap, _, _ := replSpecSvc.ValidateNewReplicationSpec(sourceBucket, targetCluster, targetBucket, "", settings, false)
 	assert.Equal(len(errMap), 0)
 	fmt.Println("============== Test case end: TestValidateNewReplicationSpec =================")
 }
 
+/**
+ * File export replications have no target cluster nor target bucket to validate
+ */
+func TestValidateNewFileExportReplicationSpec(t *testing.T) {
+	assert := assert.New(t)
+
+	fmt.Println("============== Test case start: TestValidateNewFileExportReplicationSpec =================")
+	xdcrTopologyMock, metadataSvcMock, uiLogSvcMock, remoteClusterMock,
+		utilitiesMock, replSpecSvc,
+		sourceBucket, _, _, settings, clientMock, backfillReplSvc := setupBoilerPlate()
+
+	// Begin mocks
+	setupMocks(base.ConflictResolutionType_Seqno, base.ConflictResolutionType_Seqno, xdcrTopologyMock, metadataSvcMock, uiLogSvcMock, remoteClusterMock, utilitiesMock, replSpecSvc, clientMock, true, false, true, backfillReplSvc, false)
+
+	settings[metadata.ReplicationTypeKey] = metadata.ReplicationTypeFile
+	sourceBucketUUID, targetBucketUUID, ref, errMap, err, _ := replSpecSvc.ValidateNewReplicationSpec(sourceBucket, "", sourceBucket, "", settings, true)
+	assert.Nil(err)
+	assert.Equal(len(errMap), 0)
+	assert.NotEqual("", sourceBucketUUID)
+	assert.Equal("", targetBucketUUID)
+	assert.Nil(ref)
+	remoteClusterMock.AssertNotCalled(t, "RemoteClusterByRefName", mock.Anything, mock.Anything)
+	utilitiesMock.AssertNotCalled(t, "RemoteBucketValidationInfo", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	fmt.Println("============== Test case end: TestValidateNewFileExportReplicationSpec =================")
+}
+
 /**
  * Tests when the conflict resolution types are different - negative test
  */
@@ -287,12 +313,45 @@ func TestNegativeConflictResolutionType(t *testing.T) {
 	// Assume XMEM replication type
 	settings[metadata.ReplicationTypeKey] = metadata.ReplicationTypeXmem
 
-	_, _, _, errMap, _, _ := replSpecSvc.ValidateNewReplicationSpec(sourceBucket, targetCluster, targetBucket, settings, true)
+	_, _, _, errMap, _, _ := replSpecSvc.ValidateNewReplicationSpec(sourceBucket, targetCluster, targetBucket, "", settings, true)
 	// Should have only one error
 	assert.Equal(len(errMap), 1)
 	fmt.Println("============== Test case end: TestNegativeConflictResolutionType =================")
 }
 
+/**
+ * Conflict resolution strategies cannot be used with custom conflict resolution - negative test
+ */
+func TestConflictResolutionStrategyWithCustomCR(t *testing.T) {
+	assert := assert.New(t)
+
+	fmt.Println("============== Test case start: TestConflictResolutionStrategyWithCustomCR =================")
+	xdcrTopologyMock, metadataSvcMock, uiLogSvcMock, remoteClusterMock,
+		utilitiesMock, replSpecSvc,
+		sourceBucket, targetBucket, targetCluster, settings, clientMock, backfillReplSvc := setupBoilerPlate()
+
+	// Begin mocks
+	setupMocks(base.ConflictResolutionType_Custom, base.ConflictResolutionType_Custom, xdcrTopologyMock, metadataSvcMock, uiLogSvcMock, remoteClusterMock, utilitiesMock, replSpecSvc, clientMock, true, false, true, backfillReplSvc, false)
+
+	settings[metadata.ReplicationTypeKey] = metadata.ReplicationTypeXmem
+	settings[metadata.ConflictResolutionStrategyKey] = base.ConflictResolutionStrategySourceWins
+
+	_, _, _, errMap, err, _ := replSpecSvc.ValidateNewReplicationSpec(sourceBucket, targetCluster, targetBucket, "", settings, true)
+	assert.Nil(err)
+	assert.NotNil(errMap[base.ConflictResolutionStrategyKey])
+
+	// changing the strategy of an existing replication is rejected as well
+	errMap, err, _ = replSpecSvc.ValidateReplicationSettings(sourceBucket, targetCluster, targetBucket, "", metadata.ReplicationSettingsMap{metadata.ConflictResolutionStrategyKey: base.ConflictResolutionStrategySourceWins}, false)
+	assert.Nil(err)
+	assert.NotNil(errMap[base.ConflictResolutionStrategyKey])
+
+	// going back to the default is fine
+	errMap, err, _ = replSpecSvc.ValidateReplicationSettings(sourceBucket, targetCluster, targetBucket, "", metadata.ReplicationSettingsMap{metadata.ConflictResolutionStrategyKey: base.ConflictResolutionStrategyDefault}, false)
+	assert.Nil(err)
+	assert.Equal(0, len(errMap))
+	fmt.Println("============== Test case end: TestConflictResolutionStrategyWithCustomCR =================")
+}
+
 /**
  * MB-23968 - Tests when the conflict resolution types are different but allowed only on elastic search
  */
@@ -310,7 +369,7 @@ func TestDifferentConflictResolutionTypeOnCapi(t *testing.T) {
 	// Assume CAPI (elasticsearch) replication type
 	settings[metadata.ReplicationTypeKey] = metadata.ReplicationTypeCapi
 
-	_, _, _, errMap, _, _ := replSpecSvc.ValidateNewReplicationSpec(sourceBucket, targetCluster, targetBucket, settings, false)
+	_, _, _, errMap, _, _ := replSpecSvc.ValidateNewReplicationSpec(sourceBucket, targetCluster, targetBucket, "", settings, false)
 	// Should pass
 	assert.Equal(len(errMap), 0)
 	fmt.Println("============== Test case end: TestDifferentConflictResolutionTypeOnCapi =================")
@@ -360,12 +419,12 @@ func TestCompressionPositive(t *testing.T) {
 
 	// Turning off should be allowed
 	settings[metadata.CompressionTypeKey] = base.CompressionTypeNone
-	_, _, _, errMap, _, _ := replSpecSvc.ValidateNewReplicationSpec(sourceBucket, targetCluster, targetBucket, settings, true)
+	_, _, _, errMap, _, _ := replSpecSvc.ValidateNewReplicationSpec(sourceBucket, targetCluster, targetBucket, "", settings, true)
 	assert.Equal(len(errMap), 0)
 
 	// Turning on should be allowed
 	settings[metadata.CompressionTypeKey] = base.CompressionTypeSnappy
-	_, _, _, errMap, _, _ = replSpecSvc.ValidateNewReplicationSpec(sourceBucket, targetCluster, targetBucket, settings, true)
+	_, _, _, errMap, _, _ = replSpecSvc.ValidateNewReplicationSpec(sourceBucket, targetCluster, targetBucket, "", settings, true)
 	assert.Equal(len(errMap), 0)
 
 	fmt.Println("============== Test case end: TestCompressionPositive =================")
@@ -383,12 +442,12 @@ func TestCompressionNegNotEnterprise(t *testing.T) {
 
 	// Turning on should be disallowed
 	settings[metadata.CompressionTypeKey] = base.CompressionTypeSnappy
-	_, _, _, errMap, _, _ := replSpecSvc.ValidateNewReplicationSpec(sourceBucket, targetCluster, targetBucket, settings, true)
+	_, _, _, errMap, _, _ := replSpecSvc.ValidateNewReplicationSpec(sourceBucket, targetCluster, targetBucket, "", settings, true)
 	assert.NotEqual(len(errMap), 0)
 
 	// Setting to auto should be disallowed
 	settings[metadata.CompressionTypeKey] = base.CompressionTypeAuto
-	_, _, _, errMap, _, _ = replSpecSvc.ValidateNewReplicationSpec(sourceBucket, targetCluster, targetBucket, settings, true)
+	_, _, _, errMap, _, _ = replSpecSvc.ValidateNewReplicationSpec(sourceBucket, targetCluster, targetBucket, "", settings, true)
 	assert.NotEqual(len(errMap), 0)
 
 	fmt.Println("============== Test case end: TestCompressionNegNotEnterprise =================")
@@ -407,11 +466,11 @@ func TestOriginalRegexInvalidateFilter(t *testing.T) {
 	// Xmem using elas
 	settings[metadata.FilterExpressionKey] = "^abc"
 
-	_, _, _, _, err, _ := replSpecSvc.ValidateNewReplicationSpec(sourceBucket, targetCluster, targetBucket, settings, false)
+	_, _, _, _, err, _ := replSpecSvc.ValidateNewReplicationSpec(sourceBucket, targetCluster, targetBucket, "", settings, false)
 	assert.NotNil(err)
 
 	// If it's an existing replication with an old filter, it's ok
-	errMap, err, _ := replSpecSvc.ValidateReplicationSettings(sourceBucket, targetCluster, targetBucket, settings, false)
+	errMap, err, _ := replSpecSvc.ValidateReplicationSettings(sourceBucket, targetCluster, targetBucket, "", settings, false)
 	assert.Nil(err)
 	assert.Equal(0, len(errMap))
 
@@ -431,7 +490,7 @@ func TestOriginalRegexUpgradedFilter(t *testing.T) {
 	// Xmem using elas
 	settings[metadata.FilterExpressionKey] = base.UpgradeFilter("^abc")
 
-	_, _, _, _, err, _ := replSpecSvc.ValidateNewReplicationSpec(sourceBucket, targetCluster, targetBucket, settings, false)
+	_, _, _, _, err, _ := replSpecSvc.ValidateNewReplicationSpec(sourceBucket, targetCluster, targetBucket, "", settings, false)
 	assert.Nil(err)
 
 	fmt.Println("============== Test case end: TestOriginalRegexUpgradedFilter =================")
@@ -449,7 +508,7 @@ func TestStripExpiry(t *testing.T) {
 
 	settings[metadata.FilterExpDelKey] = base.FilterExpDelStripExpiration
 
-	_, _, _, errMap, _, _ := replSpecSvc.ValidateNewReplicationSpec(sourceBucket, targetCluster, targetBucket, settings, false)
+	_, _, _, errMap, _, _ := replSpecSvc.ValidateNewReplicationSpec(sourceBucket, targetCluster, targetBucket, "", settings, false)
 	assert.Equal(len(errMap), 0)
 
 	fmt.Println("============== Test case start: TestStripExpiry =================")
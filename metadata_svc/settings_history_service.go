@@ -0,0 +1,126 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software
+will be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package metadata_svc
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/couchbase/goxdcr/base"
+	"github.com/couchbase/goxdcr/log"
+	"github.com/couchbase/goxdcr/metadata"
+	"github.com/couchbase/goxdcr/service_def"
+)
+
+const (
+	// the catalog of the settings history docs, one per subject
+	SettingsHistoryCatalogKey = "SettingsHistory"
+)
+
+type SettingsHistorySvc struct {
+	metadata_svc service_def.MetadataSvc
+	logger       *log.CommonLogger
+	historyMtx   sync.Mutex
+}
+
+func NewSettingsHistorySvc(metadata_svc service_def.MetadataSvc, logger_ctx *log.LoggerContext) *SettingsHistorySvc {
+	return &SettingsHistorySvc{
+		metadata_svc: metadata_svc,
+		logger:       log.NewLogger("SettHistSvc", logger_ctx),
+	}
+}
+
+func getSettingsHistoryKey(subject string) string {
+	return SettingsHistoryCatalogKey + base.KeyPartsDelimiter + subject
+}
+
+// GetSettingsHistory returns the recorded changes of the subject, which is empty if nothing has been recorded
+func (service *SettingsHistorySvc) GetSettingsHistory(subject string) (*metadata.SettingsHistory, error) {
+	service.historyMtx.Lock()
+	defer service.historyMtx.Unlock()
+
+	return service.getSettingsHistory(subject)
+}
+
+func (service *SettingsHistorySvc) getSettingsHistory(subject string) (*metadata.SettingsHistory, error) {
+	bytes, rev, err := service.metadata_svc.Get(getSettingsHistoryKey(subject))
+	if err == service_def.MetadataNotFoundErr {
+		return &metadata.SettingsHistory{Subject: subject}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	history := &metadata.SettingsHistory{}
+	err = json.Unmarshal(bytes, history)
+	if err != nil {
+		return nil, err
+	}
+	history.Revision = rev
+	history.PostProcessAfterUnmarshalling()
+	return history, nil
+}
+
+// RecordSettingsChange adds an entry with the settings that differ between before and after to the history of the
+// subject. Nothing is recorded if no setting has changed
+func (service *SettingsHistorySvc) RecordSettingsChange(subject string, before, after map[string]interface{}, user string) error {
+	entry := metadata.NewSettingsHistoryEntry(before, after, user, time.Now())
+	if entry == nil {
+		return nil
+	}
+
+	service.historyMtx.Lock()
+	defer service.historyMtx.Unlock()
+
+	var err error
+	// the history could be updated by another node in the meantime, in which case it is read again
+	for i := 0; i < base.MaxNumOfMetakvRetries; i++ {
+		var history *metadata.SettingsHistory
+		history, err = service.getSettingsHistory(subject)
+		if err != nil {
+			return err
+		}
+		history.AddEntry(entry, base.MaxSettingsHistoryEntries)
+		err = service.setSettingsHistory(history)
+		if err != service_def.ErrorRevisionMismatch && err != service_def.ErrorKeyAlreadyExist {
+			break
+		}
+	}
+	if err == nil {
+		service.logger.Infof("Recorded version %v of the settings of %v", entry.Version, subject)
+	}
+	return err
+}
+
+func (service *SettingsHistorySvc) setSettingsHistory(history *metadata.SettingsHistory) error {
+	bytes, err := json.Marshal(history)
+	if err != nil {
+		return err
+	}
+	key := getSettingsHistoryKey(history.Subject)
+	if history.Revision != nil {
+		return service.metadata_svc.Set(key, bytes, history.Revision)
+	} else {
+		return service.metadata_svc.Add(key, bytes)
+	}
+}
+
+// DelSettingsHistory removes the history of the subject, e.g. when the replication has been deleted
+func (service *SettingsHistorySvc) DelSettingsHistory(subject string) error {
+	service.historyMtx.Lock()
+	defer service.historyMtx.Unlock()
+
+	err := service.metadata_svc.Del(getSettingsHistoryKey(subject), nil /*rev*/)
+	if err == service_def.MetadataNotFoundErr {
+		return nil
+	}
+	return err
+}
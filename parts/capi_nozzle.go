@@ -764,6 +764,7 @@ func (capi *CapiNozzle) batchSendWithRetry(batch *capiBatch) error {
 					Opcode:      encodeOpCode(item.Req, false /* isCustomCR */),
 					IsExpirySet: (binary.BigEndian.Uint32(item.Req.Extras[4:8]) != 0),
 					VBucket:     item.Req.VBucket,
+					SourceCas:   getSourceCas(item.Req),
 				}
 				capi.RaiseEvent(common.NewEvent(common.DataFailedCRSource, nil, capi, nil, additionalInfo))
 			}
@@ -786,6 +787,7 @@ func (capi *CapiNozzle) batchSendWithRetry(batch *capiBatch) error {
 				IsExpirySet: (binary.BigEndian.Uint32(req.Req.Extras[4:8]) != 0),
 				VBucket:     req.Req.VBucket,
 				Req_size:    req.Req.Size(),
+				SourceCas:   getSourceCas(req.Req),
 			}
 			capi.RaiseEvent(common.NewEvent(common.DataSent, nil, capi, nil, additionalInfo))
 
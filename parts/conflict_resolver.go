@@ -0,0 +1,421 @@
+// Copyright 2013-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included in
+// the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+// file, in accordance with the Business Source License, use of this software
+// will be governed by the Apache License, Version 2.0, included in the file
+// licenses/APL2.txt.
+
+// defines the conflict resolvers used by xmem for source side conflict resolution
+package parts
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	base "github.com/couchbase/goxdcr/base"
+)
+
+// What a conflict resolver needs to know of the target document before a source mutation is sent
+type ConflictResolverLookup int
+
+const (
+	// Mutations smaller than the optimistic replication threshold are sent without looking up the target.
+	// The target bucket resolves the conflict for them using its own conflict resolution mode
+	CRLookupOptimistic ConflictResolverLookup = iota
+	// The target is never looked up
+	CRLookupNone ConflictResolverLookup = iota
+	// The metadata of the target document is looked up for every mutation
+	CRLookupMeta ConflictResolverLookup = iota
+	// The metadata and the body of the target document are looked up for every mutation
+	CRLookupDoc ConflictResolverLookup = iota
+)
+
+// ConflictResolverDoc is what a conflict resolver gets to see of the source mutation or of the target document
+type ConflictResolverDoc struct {
+	Key []byte
+	base.ConflictDocMeta
+	// Body without xattrs. Only populated when the resolver looks up CRLookupDoc and the document is not deleted
+	Body []byte
+}
+
+// ConflictResolver decides at the source whether a source mutation should be sent to the target
+type ConflictResolver interface {
+	Name() string
+	TargetLookup() ConflictResolverLookup
+	// Whether the target should apply the mutations that the resolver let through without running its own
+	// conflict resolution
+	SkipTargetCR() bool
+	// Returns true if the source mutation wins over the existing target document.
+	// xattrEnabled is whether the connection to the target supports xattrs
+	Resolve(source *ConflictResolverDoc, target *ConflictResolverDoc, xattrEnabled bool) bool
+}
+
+// Constructs a resolver from the argument of a conflict resolution strategy.
+// crMode is the conflict resolution mode of the buckets, for resolvers that fall back to it
+type ConflictResolverConstructor func(arg string, crMode base.ConflictResolutionMode) (ConflictResolver, error)
+
+var conflictResolverRegistry = map[string]ConflictResolverConstructor{
+	base.ConflictResolutionStrategySourceWins:       newSourceWinsConflictResolver,
+	base.ConflictResolutionStrategyTargetWins:       newTargetWinsConflictResolver,
+	base.ConflictResolutionStrategyHighestJsonField: newHighestJsonFieldConflictResolver,
+}
+var conflictResolverRegistryLock sync.RWMutex
+
+// Makes a conflict resolver selectable with the conflictResolutionStrategy replication setting
+func RegisterConflictResolver(name string, constructor ConflictResolverConstructor) error {
+	if name == "" || strings.Contains(name, base.ConflictResolutionStrategyArgDelimiter) || constructor == nil {
+		return fmt.Errorf("Invalid conflict resolver registration for name %v", name)
+	}
+	conflictResolverRegistryLock.Lock()
+	defer conflictResolverRegistryLock.Unlock()
+	if _, exists := conflictResolverRegistry[name]; exists {
+		return fmt.Errorf("Conflict resolver %v has already been registered", name)
+	}
+	conflictResolverRegistry[name] = constructor
+	return nil
+}
+
+// Returns the resolver that implements the conflict resolution mode of the buckets
+func NewConflictResolverForCRMode(crMode base.ConflictResolutionMode) ConflictResolver {
+	switch crMode {
+	case base.CRMode_LWW, base.CRMode_Custom:
+		// custom conflict resolution falls back to LWW for documents that cannot be merged
+		return &lwwConflictResolver{}
+	default:
+		return &revIdConflictResolver{}
+	}
+}
+
+// Returns the resolver for a conflict resolution strategy, which is in the form of name[:argument]
+// An empty strategy means the conflict resolution mode of the buckets
+func NewConflictResolver(strategy string, crMode base.ConflictResolutionMode) (ConflictResolver, error) {
+	if strategy == base.ConflictResolutionStrategyDefault {
+		return NewConflictResolverForCRMode(crMode), nil
+	}
+	name := strategy
+	var arg string
+	if idx := strings.Index(strategy, base.ConflictResolutionStrategyArgDelimiter); idx >= 0 {
+		name = strategy[:idx]
+		arg = strategy[idx+len(base.ConflictResolutionStrategyArgDelimiter):]
+	}
+
+	conflictResolverRegistryLock.RLock()
+	constructor, ok := conflictResolverRegistry[name]
+	conflictResolverRegistryLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("Unknown conflict resolution strategy %v. Valid strategies are %v", name, registeredConflictResolverNames())
+	}
+	return constructor(arg, crMode)
+}
+
+func ValidateConflictResolutionStrategy(strategy string) error {
+	_, err := NewConflictResolver(strategy, base.CRMode_RevId)
+	return err
+}
+
+func registeredConflictResolverNames() []string {
+	conflictResolverRegistryLock.RLock()
+	defer conflictResolverRegistryLock.RUnlock()
+	names := make([]string, 0, len(conflictResolverRegistry))
+	for name, _ := range conflictResolverRegistry {
+		names = append(names, name)
+	}
+	return base.SortStringList(names)
+}
+
+func noConflictResolverArg(name, arg string) error {
+	if arg != "" {
+		return fmt.Errorf("Conflict resolution strategy %v does not take an argument", name)
+	}
+	return nil
+}
+
+/************************************
+/* revId and LWW, the conflict resolution modes of the buckets
+*************************************/
+type revIdConflictResolver struct{}
+
+func (r *revIdConflictResolver) Name() string {
+	return base.ConflictResolutionType_Seqno
+}
+
+func (r *revIdConflictResolver) TargetLookup() ConflictResolverLookup {
+	return CRLookupOptimistic
+}
+
+func (r *revIdConflictResolver) SkipTargetCR() bool {
+	return false
+}
+
+func (r *revIdConflictResolver) Resolve(source *ConflictResolverDoc, target *ConflictResolverDoc, xattrEnabled bool) bool {
+	return resolveConflictByRevSeq(source, target, xattrEnabled)
+}
+
+type lwwConflictResolver struct{}
+
+func (r *lwwConflictResolver) Name() string {
+	return base.ConflictResolutionType_Lww
+}
+
+func (r *lwwConflictResolver) TargetLookup() ConflictResolverLookup {
+	return CRLookupOptimistic
+}
+
+func (r *lwwConflictResolver) SkipTargetCR() bool {
+	return false
+}
+
+func (r *lwwConflictResolver) Resolve(source *ConflictResolverDoc, target *ConflictResolverDoc, xattrEnabled bool) bool {
+	return resolveConflictByCAS(source, target, xattrEnabled)
+}
+
+func resolveConflictByCAS(doc_meta_source *ConflictResolverDoc,
+	doc_meta_target *ConflictResolverDoc, xattrEnabled bool) bool {
+	ret := true
+	if doc_meta_target.Cas > doc_meta_source.Cas {
+		ret = false
+	} else if doc_meta_target.Cas == doc_meta_source.Cas {
+		if doc_meta_target.RevSeq > doc_meta_source.RevSeq {
+			ret = false
+		} else if doc_meta_target.RevSeq == doc_meta_source.RevSeq {
+			//if the outgoing mutation is deletion and its revSeq and cas are the
+			//same as the target side document, it would lose the conflict resolution
+			if doc_meta_source.Deletion || (doc_meta_target.Expiry > doc_meta_source.Expiry) {
+				ret = false
+			} else if doc_meta_target.Expiry == doc_meta_source.Expiry {
+				if doc_meta_target.Flags > doc_meta_source.Flags {
+					ret = false
+				} else if doc_meta_target.Flags == doc_meta_source.Flags {
+					ret = resolveConflictByXattr(doc_meta_source, doc_meta_target, xattrEnabled)
+				}
+			}
+		}
+	}
+	return ret
+}
+
+func resolveConflictByRevSeq(doc_meta_source *ConflictResolverDoc,
+	doc_meta_target *ConflictResolverDoc, xattrEnabled bool) bool {
+	ret := true
+	if doc_meta_target.RevSeq > doc_meta_source.RevSeq {
+		ret = false
+	} else if doc_meta_target.RevSeq == doc_meta_source.RevSeq {
+		if doc_meta_target.Cas > doc_meta_source.Cas {
+			ret = false
+		} else if doc_meta_target.Cas == doc_meta_source.Cas {
+			//if the outgoing mutation is deletion and its revSeq and cas are the
+			//same as the target side document, it would lose the conflict resolution
+			if doc_meta_source.Deletion || (doc_meta_target.Expiry > doc_meta_source.Expiry) {
+				ret = false
+			} else if doc_meta_target.Expiry == doc_meta_source.Expiry {
+				if doc_meta_target.Flags > doc_meta_source.Flags {
+					ret = false
+				} else if doc_meta_target.Flags == doc_meta_source.Flags {
+					ret = resolveConflictByXattr(doc_meta_source, doc_meta_target, xattrEnabled)
+				}
+			}
+		}
+	}
+	return ret
+}
+
+// if all other metadata fields are equal, use xattr field to decide whether source mutations should win
+func resolveConflictByXattr(doc_meta_source *ConflictResolverDoc,
+	doc_meta_target *ConflictResolverDoc, xattrEnabled bool) bool {
+	if xattrEnabled {
+		// if target is xattr enabled, source mutation has xattr, and target mutation does not have xattr
+		// let source mutation win
+		source_has_xattr := base.HasXattr(doc_meta_source.DataType)
+		target_has_xattr := base.HasXattr(doc_meta_target.DataType)
+		return source_has_xattr && !target_has_xattr
+	} else {
+		// if target is not xattr enabled, target mutation always does not have xattr
+		// do not have let source mutation win even if source mutation has xattr,
+		// otherwise source mutations need to be repeatly re-sent in backfill mode
+		return false
+	}
+}
+
+/************************************
+/* sourceAlwaysWins
+*************************************/
+// Every source mutation overwrites the target document, whatever the target has, unless the target already has the
+// same revision of the document. A replication sends the documents it writes with their source metadata, so the
+// reverse replication of a bidirectional setup finds identical metadata on its target and does not send them back.
+// Without the lookup, every mutation would bounce between the two buckets forever
+type sourceWinsConflictResolver struct{}
+
+func newSourceWinsConflictResolver(arg string, crMode base.ConflictResolutionMode) (ConflictResolver, error) {
+	if err := noConflictResolverArg(base.ConflictResolutionStrategySourceWins, arg); err != nil {
+		return nil, err
+	}
+	return &sourceWinsConflictResolver{}, nil
+}
+
+func (r *sourceWinsConflictResolver) Name() string {
+	return base.ConflictResolutionStrategySourceWins
+}
+
+func (r *sourceWinsConflictResolver) TargetLookup() ConflictResolverLookup {
+	return CRLookupMeta
+}
+
+func (r *sourceWinsConflictResolver) SkipTargetCR() bool {
+	return true
+}
+
+func (r *sourceWinsConflictResolver) Resolve(source *ConflictResolverDoc, target *ConflictResolverDoc, xattrEnabled bool) bool {
+	return source.Cas != target.Cas || source.RevSeq != target.RevSeq || source.Deletion != target.Deletion
+}
+
+/************************************
+/* targetAlwaysWins
+*************************************/
+// Source mutations only get to the target when the target does not have a live document with the same key
+type targetWinsConflictResolver struct{}
+
+func newTargetWinsConflictResolver(arg string, crMode base.ConflictResolutionMode) (ConflictResolver, error) {
+	if err := noConflictResolverArg(base.ConflictResolutionStrategyTargetWins, arg); err != nil {
+		return nil, err
+	}
+	return &targetWinsConflictResolver{}, nil
+}
+
+func (r *targetWinsConflictResolver) Name() string {
+	return base.ConflictResolutionStrategyTargetWins
+}
+
+func (r *targetWinsConflictResolver) TargetLookup() ConflictResolverLookup {
+	return CRLookupMeta
+}
+
+// a target tombstone may have a larger revision than the source mutation that replaces it
+func (r *targetWinsConflictResolver) SkipTargetCR() bool {
+	return true
+}
+
+func (r *targetWinsConflictResolver) Resolve(source *ConflictResolverDoc, target *ConflictResolverDoc, xattrEnabled bool) bool {
+	return target.Deletion
+}
+
+/************************************
+/* highestJsonFieldWins:<field path>
+*************************************/
+// The document with the highest value of a JSON field wins. The field path is dot separated, e.g. "meta.updatedAt".
+// Numbers are compared as numbers and strings are compared lexicographically, which suits ISO 8601 timestamps.
+// A document that has the field wins over one that does not. The conflict resolution mode of the buckets decides
+// when either side is deleted or is not JSON, when neither has the field, when the values cannot be compared or are equal
+type highestJsonFieldConflictResolver struct {
+	fieldPath []string
+	fallback  ConflictResolver
+}
+
+func newHighestJsonFieldConflictResolver(arg string, crMode base.ConflictResolutionMode) (ConflictResolver, error) {
+	if arg == "" {
+		return nil, fmt.Errorf("Conflict resolution strategy %v requires a field path, e.g. %v%vupdatedAt",
+			base.ConflictResolutionStrategyHighestJsonField, base.ConflictResolutionStrategyHighestJsonField, base.ConflictResolutionStrategyArgDelimiter)
+	}
+	fieldPath := strings.Split(arg, ".")
+	for _, field := range fieldPath {
+		if field == "" {
+			return nil, fmt.Errorf("Invalid field path %v for conflict resolution strategy %v", arg, base.ConflictResolutionStrategyHighestJsonField)
+		}
+	}
+	return &highestJsonFieldConflictResolver{
+		fieldPath: fieldPath,
+		fallback:  NewConflictResolverForCRMode(crMode),
+	}, nil
+}
+
+func (r *highestJsonFieldConflictResolver) Name() string {
+	return base.ConflictResolutionStrategyHighestJsonField
+}
+
+func (r *highestJsonFieldConflictResolver) TargetLookup() ConflictResolverLookup {
+	return CRLookupDoc
+}
+
+// the target would otherwise reject a source document with a lower revision even if its field is higher
+func (r *highestJsonFieldConflictResolver) SkipTargetCR() bool {
+	return true
+}
+
+func (r *highestJsonFieldConflictResolver) Resolve(source *ConflictResolverDoc, target *ConflictResolverDoc, xattrEnabled bool) bool {
+	if source.Deletion || target.Deletion || source.DataType&base.JSONDataType == 0 || target.DataType&base.JSONDataType == 0 {
+		return r.fallback.Resolve(source, target, xattrEnabled)
+	}
+	sourceVal, sourceHasField := r.fieldValue(source.Body)
+	targetVal, targetHasField := r.fieldValue(target.Body)
+	if sourceHasField != targetHasField {
+		return sourceHasField
+	}
+	if sourceHasField {
+		if result, comparable := compareJsonValues(sourceVal, targetVal); comparable && result != 0 {
+			return result > 0
+		}
+	}
+	return r.fallback.Resolve(source, target, xattrEnabled)
+}
+
+func (r *highestJsonFieldConflictResolver) fieldValue(body []byte) (interface{}, bool) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, false
+	}
+	for _, field := range r.fieldPath {
+		docMap, ok := doc.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if doc, ok = docMap[field]; !ok {
+			return nil, false
+		}
+	}
+	return doc, doc != nil
+}
+
+// Returns 1 if a is higher, -1 if b is higher and 0 if they are equal. comparable is false if they are of different types
+// or are neither numbers nor strings
+func compareJsonValues(a, b interface{}) (result int, comparable bool) {
+	switch aVal := a.(type) {
+	case json.Number:
+		bVal, ok := b.(json.Number)
+		if !ok {
+			return 0, false
+		}
+		aInt, aErr := aVal.Int64()
+		bInt, bErr := bVal.Int64()
+		if aErr == nil && bErr == nil {
+			return compareOrdered(aInt < bInt, aInt > bInt), true
+		}
+		aFloat, aErr := aVal.Float64()
+		bFloat, bErr := bVal.Float64()
+		if aErr != nil || bErr != nil {
+			return 0, false
+		}
+		return compareOrdered(aFloat < bFloat, aFloat > bFloat), true
+	case string:
+		bVal, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(aVal, bVal), true
+	default:
+		return 0, false
+	}
+}
+
+func compareOrdered(less, greater bool) int {
+	if less {
+		return -1
+	} else if greater {
+		return 1
+	}
+	return 0
+}
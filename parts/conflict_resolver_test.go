@@ -0,0 +1,183 @@
+// +build !pcre
+
+/*
+Copyright 2026-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software
+will be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package parts
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	mc "github.com/couchbase/gomemcached"
+	base "github.com/couchbase/goxdcr/base"
+	"github.com/stretchr/testify/assert"
+)
+
+func conflictResolverTestDoc(revSeq, cas uint64, deletion bool, body string) *ConflictResolverDoc {
+	doc := &ConflictResolverDoc{
+		Key: []byte("doc"),
+		ConflictDocMeta: base.ConflictDocMeta{
+			Cas:      cas,
+			RevSeq:   revSeq,
+			Deletion: deletion,
+		},
+	}
+	if body != "" {
+		doc.Body = []byte(body)
+		doc.DataType = base.JSONDataType
+	}
+	return doc
+}
+
+func TestConflictResolverForCRMode(t *testing.T) {
+	fmt.Println("============== Test case start: TestConflictResolverForCRMode =================")
+	defer fmt.Println("============== Test case end: TestConflictResolverForCRMode =================")
+	assert := assert.New(t)
+
+	// higher revision but lower CAS
+	source := conflictResolverTestDoc(5, 100, false, "")
+	target := conflictResolverTestDoc(4, 200, false, "")
+
+	revId, err := NewConflictResolver(base.ConflictResolutionStrategyDefault, base.CRMode_RevId)
+	assert.Nil(err)
+	assert.Equal(CRLookupOptimistic, revId.TargetLookup())
+	assert.False(revId.SkipTargetCR())
+	assert.True(revId.Resolve(source, target, true))
+
+	lww, err := NewConflictResolver(base.ConflictResolutionStrategyDefault, base.CRMode_LWW)
+	assert.Nil(err)
+	assert.False(lww.Resolve(source, target, true))
+
+	// a deletion with the same metadata as the target loses
+	source = conflictResolverTestDoc(4, 200, true, "")
+	assert.False(revId.Resolve(source, target, true))
+	assert.False(lww.Resolve(source, target, true))
+
+	// only xattrs differ
+	source = conflictResolverTestDoc(4, 200, false, "")
+	source.DataType = base.XattrDataType
+	assert.True(revId.Resolve(source, target, true))
+	assert.False(revId.Resolve(source, target, false))
+}
+
+func TestSourceAndTargetWinsConflictResolvers(t *testing.T) {
+	fmt.Println("============== Test case start: TestSourceAndTargetWinsConflictResolvers =================")
+	defer fmt.Println("============== Test case end: TestSourceAndTargetWinsConflictResolvers =================")
+	assert := assert.New(t)
+
+	source := conflictResolverTestDoc(1, 100, false, "")
+	target := conflictResolverTestDoc(9, 900, false, "")
+
+	sourceWins, err := NewConflictResolver(base.ConflictResolutionStrategySourceWins, base.CRMode_LWW)
+	assert.Nil(err)
+	assert.Equal(CRLookupMeta, sourceWins.TargetLookup())
+	assert.True(sourceWins.SkipTargetCR())
+	assert.True(sourceWins.Resolve(source, target, true))
+	// the same revision coming back from the reverse replication is not sent again
+	assert.False(sourceWins.Resolve(source, conflictResolverTestDoc(1, 100, false, ""), true))
+	assert.True(sourceWins.Resolve(source, conflictResolverTestDoc(1, 100, true, ""), true))
+
+	targetWins, err := NewConflictResolver(base.ConflictResolutionStrategyTargetWins, base.CRMode_RevId)
+	assert.Nil(err)
+	assert.Equal(CRLookupMeta, targetWins.TargetLookup())
+	assert.False(targetWins.Resolve(target, source, true))
+	// a tombstone on target does not hold on to the document
+	target.Deletion = true
+	assert.True(targetWins.Resolve(source, target, true))
+
+	_, err = NewConflictResolver(base.ConflictResolutionStrategySourceWins+base.ConflictResolutionStrategyArgDelimiter+"arg", base.CRMode_LWW)
+	assert.NotNil(err)
+	_, err = NewConflictResolver("unknownStrategy", base.CRMode_LWW)
+	assert.NotNil(err)
+	assert.NotNil(ValidateConflictResolutionStrategy("unknownStrategy"))
+	assert.Nil(ValidateConflictResolutionStrategy(base.ConflictResolutionStrategyDefault))
+}
+
+func TestHighestJsonFieldConflictResolver(t *testing.T) {
+	fmt.Println("============== Test case start: TestHighestJsonFieldConflictResolver =================")
+	defer fmt.Println("============== Test case end: TestHighestJsonFieldConflictResolver =================")
+	assert := assert.New(t)
+
+	_, err := NewConflictResolver(base.ConflictResolutionStrategyHighestJsonField, base.CRMode_RevId)
+	assert.NotNil(err)
+	_, err = NewConflictResolver(base.ConflictResolutionStrategyHighestJsonField+":meta..updatedAt", base.CRMode_RevId)
+	assert.NotNil(err)
+
+	resolver, err := NewConflictResolver(base.ConflictResolutionStrategyHighestJsonField+":meta.version", base.CRMode_RevId)
+	assert.Nil(err)
+	assert.Equal(CRLookupDoc, resolver.TargetLookup())
+	assert.True(resolver.SkipTargetCR())
+
+	// the field decides regardless of the revisions
+	source := conflictResolverTestDoc(1, 100, false, `{"meta":{"version":10}}`)
+	target := conflictResolverTestDoc(9, 900, false, `{"meta":{"version":9.5}}`)
+	assert.True(resolver.Resolve(source, target, true))
+	assert.False(resolver.Resolve(target, source, true))
+
+	// a doc with the field wins over one without
+	target.Body = []byte(`{"meta":{}}`)
+	assert.True(resolver.Resolve(source, target, true))
+	assert.False(resolver.Resolve(target, source, true))
+
+	// strings are compared lexicographically
+	source.Body = []byte(`{"meta":{"version":"2021-06-01T00:00:00Z"}}`)
+	target.Body = []byte(`{"meta":{"version":"2021-05-31T23:59:59Z"}}`)
+	assert.True(resolver.Resolve(source, target, true))
+
+	// values that cannot be compared, equal values, and deletions fall back to the bucket conflict resolution mode
+	target.Body = []byte(`{"meta":{"version":3}}`)
+	assert.False(resolver.Resolve(source, target, true))
+	target.Body = source.Body
+	assert.False(resolver.Resolve(source, target, true))
+	assert.True(resolver.Resolve(target, source, true))
+	source.Deletion = true
+	source.Body = nil
+	assert.False(resolver.Resolve(source, target, true))
+}
+
+func TestConflictResolverRegistry(t *testing.T) {
+	fmt.Println("============== Test case start: TestConflictResolverRegistry =================")
+	defer fmt.Println("============== Test case end: TestConflictResolverRegistry =================")
+	assert := assert.New(t)
+
+	var receivedArg string
+	constructor := func(arg string, crMode base.ConflictResolutionMode) (ConflictResolver, error) {
+		receivedArg = arg
+		return &sourceWinsConflictResolver{}, nil
+	}
+	assert.Nil(RegisterConflictResolver("unitTestResolver", constructor))
+	assert.NotNil(RegisterConflictResolver("unitTestResolver", constructor))
+	assert.NotNil(RegisterConflictResolver("unitTest:Resolver", constructor))
+	assert.Contains(registeredConflictResolverNames(), "unitTestResolver")
+
+	_, err := NewConflictResolver("unitTestResolver:a:b", base.CRMode_RevId)
+	assert.Nil(err)
+	assert.Equal("a:b", receivedArg)
+}
+
+func TestSetSkipConflictResolutionOption(t *testing.T) {
+	fmt.Println("============== Test case start: TestSetSkipConflictResolutionOption =================")
+	defer fmt.Println("============== Test case end: TestSetSkipConflictResolutionOption =================")
+	assert := assert.New(t)
+
+	req := &mc.MCRequest{Extras: make([]byte, 24)}
+	binary.BigEndian.PutUint64(req.Extras[8:16], 7)
+	setSkipConflictResolutionOption(req)
+	assert.Equal(28, len(req.Extras))
+	assert.Equal(uint64(7), binary.BigEndian.Uint64(req.Extras[8:16]))
+	assert.Equal(base.SKIP_CONFLICT_RESOLUTION_FLAG, binary.BigEndian.Uint32(req.Extras[24:28]))
+
+	// existing options are kept
+	binary.BigEndian.PutUint32(req.Extras[24:28], base.FORCE_ACCEPT_WITH_META_OPS)
+	setSkipConflictResolutionOption(req)
+	assert.Equal(base.FORCE_ACCEPT_WITH_META_OPS|base.SKIP_CONFLICT_RESOLUTION_FLAG, binary.BigEndian.Uint32(req.Extras[24:28]))
+}
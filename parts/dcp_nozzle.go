@@ -41,8 +41,14 @@ const (
 	DCP_Priority            = "dcpPriority"
 	DCP_VBTasksMap          = "VBTaskMap"
 	DCP_EnableOSO           = "enableOSO"
+	DCP_StopWhenCaughtUp    = "stopWhenCaughtUp"
+	DCP_SharedSourceStream  = "sharedSourceStream"
 )
 
+// The flags of a stream end when the producer has sent everything up to the requested end seqno
+// https://github.com/couchbaselabs/dcp-documentation/blob/master/documentation/commands/stream-end.md
+const dcpStreamEndOk uint32 = 0x00
+
 type DcpStreamState int
 
 const (
@@ -357,6 +363,19 @@ type DcpNozzle struct {
 	vbHighSeqnoMap         map[uint16]*base.SeqnoWithLock
 	savedMcReqFeatures     utilities.HELOFeatures
 	isCollectionsMigration bool
+
+	// When set, each vb stream ends at the source high seqno fetched when the streams are first started
+	// and the vb streams are not restarted once they have ended
+	stopWhenCaughtUp     bool
+	caughtUpSeqnosLoaded uint32
+
+	// When set, the vb streams are shared with the main pipelines of other replications from the same source bucket.
+	// The nozzle either owns the shared source stream or reads from one owned by another nozzle
+	sharedSourceStream bool
+	ownedSharedFeed    *sharedDcpFeed
+	sharedFeedReader   *sharedDcpFeedReader
+	sharedFeedLock     sync.RWMutex
+	sharedFeedReaderCh chan *sharedDcpFeedReader
 }
 
 func NewDcpNozzle(id string,
@@ -395,6 +414,7 @@ func NewDcpNozzle(id string,
 		vbHighSeqnoMap:           make(map[uint16]*base.SeqnoWithLock),
 		wrappedUprPool:           utilities.NewWrappedUprPool(base.NewDataPool()),
 		finch:                    make(chan bool),
+		sharedFeedReaderCh:       make(chan *sharedDcpFeedReader, 1),
 	}
 
 	// Allow one caller the ability to execute
@@ -700,6 +720,22 @@ func (dcp *DcpNozzle) initialize(settings metadata.ReplicationSettingsMap) (err
 		}
 	}
 
+	stopWhenCaughtUp, exists := settings[DCP_StopWhenCaughtUp]
+	if exists {
+		dcp.stopWhenCaughtUp = stopWhenCaughtUp.(bool)
+		if dcp.stopWhenCaughtUp {
+			dcp.Logger().Infof("%v will stop streaming once caught up with the source high seqnos", dcp.Id())
+		}
+	}
+
+	sharedSourceStream, exists := settings[DCP_SharedSourceStream]
+	if exists && !dcp.is_capi && !dcp.osoRequested && !dcp.stopWhenCaughtUp && (dcp.specificVBTasks.IsNil() || dcp.specificVBTasks.Len() == 0) {
+		dcp.sharedSourceStream = sharedSourceStream.(bool)
+		if dcp.sharedSourceStream {
+			dcp.Logger().Infof("%v will share its source stream with other replications", dcp.Id())
+		}
+	}
+
 	err = dcp.initializeUprFeed()
 	if err != nil {
 		return err
@@ -807,6 +843,7 @@ func (dcp *DcpNozzle) Stop() error {
 		close(dcp.finch)
 	})
 
+	dcp.releaseSharedDcpFeed()
 	dcp.closeUprStreamsWithTimeout()
 	dcp.closeUprFeedWithTimeout()
 
@@ -864,6 +901,11 @@ func (dcp *DcpNozzle) closeUprStreamsWithTimeout() {
 func (dcp *DcpNozzle) closeUprStreams() error {
 	defer dcp.childrenWaitGrp.Done()
 
+	if dcp.getSharedFeedReader() != nil {
+		dcp.Logger().Infof("%v skip closing of streams since they are owned by the shared source stream\n", dcp.Id())
+		return nil
+	}
+
 	vbsList := dcp.ResponsibleVBs()
 	dcp.Logger().Infof("%v Closing dcp streams for vb=%v\n", dcp.Id(), vbsList)
 	errMap := make(map[uint16]error)
@@ -955,10 +997,40 @@ func (dcp *DcpNozzle) processData() (err error) {
 	// GetUprEventCh() wraps the channel supplied that sends in uprEvents
 	// mutch is of type UprEvent, located in gomemcached/client/upr_feed.go
 	mutch := uprFeed.GetUprEventCh()
+	// set once the vb streams are read from a shared source stream owned by another nozzle
+	var sharedFeedReader *sharedDcpFeedReader
+	var sharedch chan *mcc.UprEvent
 	for {
 		select {
 		case <-dcp.finch:
 			goto done
+		case sharedFeedReader = <-dcp.sharedFeedReaderCh:
+			sharedch = sharedFeedReader.eventCh
+		case m, ok := <-sharedch:
+			if !ok {
+				if sharedFeedReader.fellBehind {
+					sharedDcpFeeds.recordDetached(dcp.Id())
+				}
+				dcp.handleGeneralError(sharedFeedReader.detachErr)
+				goto done
+			}
+			if sharedFeedReader.alreadyReplicated(m) {
+				continue
+			}
+			if m.IsSystemEvent() {
+				dcp.handleSystemEvent(m)
+				dcp.RaiseEvent(common.NewEvent(common.SystemEventReceived, m, dcp, nil /*derivedItems*/, nil /*otherInfos*/))
+			} else if dcp.IsOpen() {
+				switch m.Opcode {
+				case mc.UPR_MUTATION, mc.UPR_DELETION, mc.UPR_EXPIRATION:
+					err = dcp.forwardMutation(m)
+					if err != nil {
+						goto done
+					}
+				case mc.UPR_SNAPSHOT:
+					dcp.RaiseEvent(common.NewEvent(common.SnapshotMarkerReceived, m, dcp, nil /*derivedItems*/, nil /*otherInfos*/))
+				}
+			}
 		case m, ok := <-mutch: // mutation from upstream
 			if !ok {
 				dcp.Logger().Infof("%v DCP mutation channel has been closed.Stop dcp nozzle now.", dcp.Id())
@@ -983,6 +1055,7 @@ func (dcp *DcpNozzle) processData() (err error) {
 					vb_err := fmt.Errorf("Received error %v on vb %v\n", base.ErrorNotMyVbucket, m.VBucket)
 					dcp.Logger().Errorf("%v %v", dcp.Id(), vb_err)
 					dcp.handleVBError(m.VBucket, vb_err)
+					dcp.detachSharedFeedReaders(vb_err)
 				} else if m.Status == mc.ROLLBACK {
 					rollbackseq := binary.BigEndian.Uint64(m.Value[:8])
 					vbno := m.VBucket
@@ -996,6 +1069,8 @@ func (dcp *DcpNozzle) processData() (err error) {
 					if ignoreResponse {
 						dcp.Logger().Infof("%v ignored rollback message for vb %v with version %v and rollbackseqno %v since it has already been acknowledged\n", dcp.Id(), vbno, m.Opaque, rollbackseq)
 					} else {
+						// the readers of a shared source stream have to roll back on their own
+						dcp.detachSharedFeedReaders(fmt.Errorf("vb %v rolled back to seqno %v", vbno, rollbackseq))
 						//need to request the uprstream for the vbucket again
 						updated_ts, err := dcp.vbtimestamp_updater(vbno, rollbackseq)
 						if err != nil {
@@ -1023,10 +1098,15 @@ func (dcp *DcpNozzle) processData() (err error) {
 						}
 						dcp.RaiseEvent(common.NewEvent(common.StreamingStart, m, dcp, nil, nil))
 						dcp.vbHandshakeMap[vbno].processSuccessResponse(m.Opaque)
+						if feed := dcp.getOwnedSharedFeed(); feed != nil {
+							feed.recordStreamStart(m)
+						}
 						// Check for corner case - where streamReq seqno will be the same as seqend Seqno
+						// When stopping once caught up, endSeqnoForDcp is the last seen seqno instead, and vbs
+						// that have nothing to stream are never requested
 						endSeqnoCheck := dcp.endSeqnoForDcp[vbno].GetSeqno()
-						if endSeqnoCheck > 0 && endSeqnoCheck == m.Seqno {
-							err = dcp.handleStreamEnd(vbno)
+						if !dcp.stopWhenCaughtUp && endSeqnoCheck > 0 && endSeqnoCheck == m.Seqno {
+							err = dcp.handleStreamEnd(vbno, dcpStreamEndOk)
 							if err != nil {
 								return err
 							}
@@ -1042,11 +1122,18 @@ func (dcp *DcpNozzle) processData() (err error) {
 				// Sent to the consumer to indicate that the producer has no more messages to stream for the specified vbucket.
 				// https://github.com/couchbaselabs/dcp-documentation/blob/master/documentation/commands/stream-end.md
 				vbno := m.VBucket
-				err = dcp.handleStreamEnd(vbno)
+				dcp.detachSharedFeedReaders(fmt.Errorf("stream for vb %v has ended", vbno))
+				// the flags of a stream end are the reason that the stream has ended
+				err = dcp.handleStreamEnd(vbno, m.Flags)
 				if err != nil {
 					return err
 				}
 			} else if m.IsSystemEvent() {
+				dcp.fanOutToSharedFeed(m)
+				if dcp.stopWhenCaughtUp {
+					// the high seqno that the vb has to catch up with may be the one of a system event
+					dcp.endSeqnoForDcp[m.VBucket].SetSeqno(m.Seqno)
+				}
 				dcp.handleSystemEvent(m)
 				dcp.RaiseEvent(common.NewEvent(common.SystemEventReceived, m, dcp, nil /*derivedItems*/, nil /*otherInfos*/))
 			} else if m.IsOsoSnapshot() {
@@ -1068,30 +1155,13 @@ func (dcp *DcpNozzle) processData() (err error) {
 						// https://github.com/couchbaselabs/dcp-documentation/blob/master/documentation/commands/mutation.md
 						// https://github.com/couchbaselabs/dcp-documentation/blob/master/documentation/commands/deletion.md
 						// https://github.com/couchbaselabs/dcp-documentation/blob/master/documentation/commands/expiration.md
-						start_time := time.Now()
-						dcp.incCounterReceived()
-						if m.IsSnappyDataType() {
-							dcp.incCompressedCounterReceived()
-						}
-						dcp.RaiseEvent(common.NewEvent(common.DataReceived, m, dcp, nil /*derivedItems*/, nil /*otherInfos*/))
-						dcp.endSeqnoForDcp[m.VBucket].SetSeqno(m.Seqno)
-						wrappedUpr, err := dcp.composeWrappedUprEvent(m)
+						dcp.fanOutToSharedFeed(m)
+						err = dcp.forwardMutation(m)
 						if err != nil {
-							dcp.handleGeneralError(err)
-							dcp.Logger().Errorf("Composing wrappedUpr had error %v", err)
-							return err
-						}
-						// forward mutation downstream through connector
-						if err := dcp.Connector().Forward(wrappedUpr); err != nil {
-							dcp.handleGeneralError(err)
-							dcp.Logger().Errorf("Connector forward had error %v", err)
 							goto done
 						}
-						dcp.incCounterSent()
-						// raise event for statistics collection
-						dispatch_time := time.Since(start_time)
-						dcp.RaiseEvent(common.NewEvent(common.DataProcessed, m, dcp, nil /*derivedItems*/, dispatch_time.Seconds()*1000000 /*otherInfos*/))
 					case mc.UPR_SNAPSHOT:
+						dcp.fanOutToSharedFeed(m)
 						dcp.RaiseEvent(common.NewEvent(common.SnapshotMarkerReceived, m, dcp, nil /*derivedItems*/, nil /*otherInfos*/))
 					default:
 						dcp.Logger().Debugf("%v Uprevent OpCode=%v, is skipped\n", dcp.Id(), m.Opcode)
@@ -1104,13 +1174,56 @@ done:
 	return
 }
 
-func (dcp *DcpNozzle) handleStreamEnd(vbno uint16) error {
+func (dcp *DcpNozzle) forwardMutation(m *mcc.UprEvent) error {
+	start_time := time.Now()
+	dcp.incCounterReceived()
+	if m.IsSnappyDataType() {
+		dcp.incCompressedCounterReceived()
+	}
+	dcp.RaiseEvent(common.NewEvent(common.DataReceived, m, dcp, nil /*derivedItems*/, nil /*otherInfos*/))
+	dcp.endSeqnoForDcp[m.VBucket].SetSeqno(m.Seqno)
+	wrappedUpr, err := dcp.composeWrappedUprEvent(m)
+	if err != nil {
+		dcp.handleGeneralError(err)
+		dcp.Logger().Errorf("Composing wrappedUpr had error %v", err)
+		return err
+	}
+	// wrappedUpr is recycled downstream, so hold on to the namespace for per collection stats
+	sourceNamespace := wrappedUpr.ColNamespace
+	// forward mutation downstream through connector
+	// the owner of a shared source stream is watched so that a slow target does not hold up the other readers
+	feed := dcp.getOwnedSharedFeed()
+	if feed != nil {
+		feed.startForwarding()
+	}
+	err = dcp.Connector().Forward(wrappedUpr)
+	if feed != nil {
+		feed.doneForwarding()
+	}
+	if err != nil {
+		dcp.handleGeneralError(err)
+		dcp.Logger().Errorf("Connector forward had error %v", err)
+		return err
+	}
+	dcp.incCounterSent()
+	// raise event for statistics collection
+	dispatch_time := time.Since(start_time)
+	dcp.RaiseEvent(common.NewEvent(common.DataProcessed, m, dcp, []interface{}{sourceNamespace} /*derivedItems*/, dispatch_time.Seconds()*1000000 /*otherInfos*/))
+	return nil
+}
+
+func (dcp *DcpNozzle) handleStreamEnd(vbno uint16, flags uint32) error {
 	var err error
-	err_streamend := fmt.Errorf("%v stream for vb=%v is closed by producer", dcp.Id(), vbno)
+	err_streamend := fmt.Errorf("%v stream for vb=%v is closed by producer with flags %v", dcp.Id(), vbno, flags)
 	dcp.Logger().Infof("%v: seqno: %v %v", dcp.Id(), dcp.endSeqnoForDcp[vbno].GetSeqno(), err_streamend)
-	if dcp.vbStreamEndIsOk(vbno) {
+	if dcp.vbStreamEndIsOk(vbno, flags) {
 		err = dcp.setStreamState(vbno, Dcp_Stream_Closed)
-		go dcp.RaiseEvent(common.NewEvent(common.StreamingEnd, vbno, dcp, nil, nil))
+		// the last seqno received is passed along so that listeners can wait for it to be replicated
+		go dcp.RaiseEvent(common.NewEvent(common.StreamingEnd, vbno, dcp, nil, dcp.endSeqnoForDcp[vbno].GetSeqno()))
+	} else if dcp.stopWhenCaughtUp {
+		// the vb has not caught up, i.e. the stream ended because of a state change, or because it was slow
+		dcp.handleVBError(vbno, err_streamend)
+		return err
 	} else {
 		stream_status, err := dcp.GetStreamState(vbno)
 		if err != nil || stream_status != Dcp_Stream_Active {
@@ -1137,8 +1250,13 @@ func (dcp *DcpNozzle) handleSystemEvent(event *mcc.UprEvent) {
 	atomic.StoreUint64(&dcp.vbHighestManifestUidArray[vbno], manifestId)
 }
 
-// Only ok situation dcp should receive a streamEnd is if it's a backfill
-func (dcp *DcpNozzle) vbStreamEndIsOk(vbno uint16) bool {
+// Only ok situation dcp should receive a streamEnd is if it's a backfill, or if the streams are meant to
+// stop once caught up and the stream has ended normally at the high seqno it was requested up to
+func (dcp *DcpNozzle) vbStreamEndIsOk(vbno uint16, flags uint32) bool {
+	if dcp.stopWhenCaughtUp {
+		return flags == dcpStreamEndOk && dcp.endSeqnoForDcp[vbno].GetSeqno() >= dcp.vbHighSeqnoMap[vbno].GetSeqno()
+	}
+
 	if !dcp.CollectionEnabled() {
 		return false
 	}
@@ -1287,6 +1405,11 @@ func (dcp *DcpNozzle) startUprStreams() error {
 	vbsList := dcp.ResponsibleVBs()
 	dcp.Logger().Infof("%v: startUprStreams for %v...\n", dcp.Id(), vbsList)
 
+	if dcp.sharedSourceStream && dcp.joinSharedDcpFeed() {
+		dcp.Logger().Infof("%v: dcp streams are read from the shared source stream.\n", dcp.Id())
+		return nil
+	}
+
 	init_ch := make(chan bool, 1)
 	init_ch <- true
 
@@ -1321,6 +1444,145 @@ done:
 	return nil
 }
 
+// Decides whether this nozzle owns or reads from a shared source stream once every vb has its starting seqno.
+// Returns true when the vb streams are read from a shared source stream owned by another nozzle, and false when
+// this nozzle has to start its own streams
+func (dcp *DcpNozzle) joinSharedDcpFeed() bool {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for !dcp.allTSSet() {
+		select {
+		case <-dcp.finch:
+			return false
+		case <-ticker.C:
+		}
+	}
+
+	if sharedDcpFeeds.recentlyDetached(dcp.Id()) {
+		dcp.Logger().Infof("%v was recently detached from a shared source stream and will stream on its own", dcp.Id())
+		return false
+	}
+
+	startTs := make(map[uint16]*base.VBTimestamp)
+	for _, vbno := range dcp.ResponsibleVBs() {
+		vbts, err := dcp.getTS(vbno, true)
+		if err != nil || vbts == nil {
+			return false
+		}
+		startTs[vbno] = vbts
+	}
+
+	key := sharedDcpFeedKey(dcp.sourceBucketName, dcp.ResponsibleVBs(), dcp.uprFeedCompressionSetting, dcp.CollectionEnabled())
+	joinDeadline := time.Now().Add(base.SharedDcpFeedJoinTimeout)
+	for {
+		feed, owned := sharedDcpFeeds.getOrOwn(key, dcp.Id(), dcp.Logger())
+		if owned {
+			if !dcp.setOwnedSharedFeed(feed) {
+				sharedDcpFeeds.release(feed)
+				feed.close(fmt.Errorf("%v: owner %v has stopped", base.ErrorSharedDcpFeedDetached, dcp.Id()))
+				return false
+			}
+			dcp.Logger().Infof("%v owns the shared source stream for %v", dcp.Id(), key)
+			return false
+		}
+
+		reader, err := feed.addReader(dcp.Id(), startTs)
+		if err == nil {
+			return dcp.startReadingSharedFeed(reader)
+		}
+		if err != ErrorSharedDcpFeedNotReady || time.Now().After(joinDeadline) {
+			dcp.Logger().Infof("%v cannot read from the shared source stream owned by %v and will stream on its own. err=%v", dcp.Id(), feed.ownerId, err)
+			return false
+		}
+
+		select {
+		case <-dcp.finch:
+			return false
+		case <-ticker.C:
+		}
+	}
+}
+
+func (dcp *DcpNozzle) allTSSet() bool {
+	for _, vbno := range dcp.ResponsibleVBs() {
+		if !dcp.isTSSet(vbno, true) {
+			return false
+		}
+	}
+	return true
+}
+
+func (dcp *DcpNozzle) startReadingSharedFeed(reader *sharedDcpFeedReader) bool {
+	dcp.sharedFeedLock.Lock()
+	defer dcp.sharedFeedLock.Unlock()
+	select {
+	case <-dcp.finch:
+		reader.feed.removeReader(reader.id)
+		return false
+	default:
+	}
+	dcp.sharedFeedReader = reader
+
+	for vbno, streamStart := range reader.streamStarts {
+		dcp.setStreamState(vbno, Dcp_Stream_Active)
+		// checkpoint manager gets the failover log of the vb from the stream request response of the owner
+		dcp.RaiseEvent(common.NewEvent(common.StreamingStart, streamStart, dcp, nil, nil))
+	}
+	dcp.sharedFeedReaderCh <- reader
+	return true
+}
+
+// The nozzle may be stopping while it becomes the owner, in which case nobody would release the shared source stream
+func (dcp *DcpNozzle) setOwnedSharedFeed(feed *sharedDcpFeed) bool {
+	dcp.sharedFeedLock.Lock()
+	defer dcp.sharedFeedLock.Unlock()
+	select {
+	case <-dcp.finch:
+		return false
+	default:
+	}
+	dcp.ownedSharedFeed = feed
+	return true
+}
+
+func (dcp *DcpNozzle) getOwnedSharedFeed() *sharedDcpFeed {
+	dcp.sharedFeedLock.RLock()
+	defer dcp.sharedFeedLock.RUnlock()
+	return dcp.ownedSharedFeed
+}
+
+func (dcp *DcpNozzle) getSharedFeedReader() *sharedDcpFeedReader {
+	dcp.sharedFeedLock.RLock()
+	defer dcp.sharedFeedLock.RUnlock()
+	return dcp.sharedFeedReader
+}
+
+func (dcp *DcpNozzle) fanOutToSharedFeed(m *mcc.UprEvent) {
+	if feed := dcp.getOwnedSharedFeed(); feed != nil {
+		feed.fanOut(m)
+	}
+}
+
+func (dcp *DcpNozzle) detachSharedFeedReaders(reason error) {
+	if feed := dcp.getOwnedSharedFeed(); feed != nil {
+		feed.detachAll(fmt.Errorf("%v: %v", base.ErrorSharedDcpFeedDetached, reason))
+	}
+}
+
+// Called once finch is closed so that the nozzle can no longer become an owner or a reader
+func (dcp *DcpNozzle) releaseSharedDcpFeed() {
+	dcp.sharedFeedLock.Lock()
+	defer dcp.sharedFeedLock.Unlock()
+	if dcp.ownedSharedFeed != nil {
+		sharedDcpFeeds.release(dcp.ownedSharedFeed)
+		dcp.ownedSharedFeed.close(fmt.Errorf("%v: owner %v has stopped", base.ErrorSharedDcpFeedDetached, dcp.Id()))
+		dcp.ownedSharedFeed = nil
+	}
+	if dcp.sharedFeedReader != nil {
+		dcp.sharedFeedReader.feed.removeReader(dcp.sharedFeedReader.id)
+	}
+}
+
 /**
  * Once the stream is ready to be started (once seqno is populated from ckptmgr)
  * Do the actual stream start.
@@ -1429,6 +1691,20 @@ func (dcp *DcpNozzle) startUprStreamInner(vbno uint16, vbts *base.VBTimestamp, v
 		// In a corner case where startSeq == endSeqno, DCP will not send down a streamEnd and instead just
 		// close the connection. Mark the endSeqno here first to check if this is the case
 		dcp.endSeqnoForDcp[vbno].SetSeqno(seqEnd)
+	} else if dcp.stopWhenCaughtUp {
+		seqEnd = dcp.vbHighSeqnoMap[vbno].GetSeqno()
+		// Nothing has been received yet, so the last seen seqno is where the stream resumes from
+		dcp.endSeqnoForDcp[vbno].SetSeqno(vbts.Seqno)
+		if vbts.Seqno >= seqEnd {
+			// DCP does not accept a stream request that ends where it starts. The vb has already caught up
+			dcp.Logger().Infof("%v vb=%v has already caught up with seqno %v. Not starting its stream", dcp.Id(), vbno, vbts.Seqno)
+			err = dcp.setStreamState(vbno, Dcp_Stream_Closed)
+			if err != nil {
+				return
+			}
+			go dcp.RaiseEvent(common.NewEvent(common.StreamingEnd, vbno, dcp, nil, vbts.Seqno))
+			return
+		}
 	}
 
 	dcp.Logger().Debugf("%v starting vb stream for vb=%v, version=%v collectionEnabled=%v endSeqno=%v\n", dcp.Id(), vbno, version, dcp.CollectionEnabled(), seqEnd)
@@ -1964,6 +2240,9 @@ func (dcp *DcpNozzle) GetOSOSeqnoRaiser() func(vbno uint16, seqno uint64) {
 
 func (dcp *DcpNozzle) getHighSeqnosIfNecessary(vbnos []uint16) error {
 	if dcp.specificVBTasks.IsNil() || dcp.specificVBTasks.Len() == 0 {
+		if dcp.stopWhenCaughtUp {
+			return dcp.getCaughtUpHighSeqnos()
+		}
 		// Main pipeline, no need to get high Seqno
 		return nil
 	}
@@ -2050,3 +2329,47 @@ func (dcp *DcpNozzle) getHighSeqnosIfNecessary(vbnos []uint16) error {
 	}
 	return nil
 }
+
+// When stopping once caught up, the end seqno of every vb stream is the bucket wide high seqno when the streams
+// are first started. They are only retrieved once so that restarted streams do not chase newer mutations
+func (dcp *DcpNozzle) getCaughtUpHighSeqnos() error {
+	if atomic.LoadUint32(&dcp.caughtUpSeqnosLoaded) == 1 {
+		return nil
+	}
+
+	select {
+	case <-dcp.getHighSeqnoOneAtATime:
+		defer func() { dcp.getHighSeqnoOneAtATime <- true }()
+
+		if atomic.LoadUint32(&dcp.caughtUpSeqnosLoaded) == 1 {
+			return nil
+		}
+
+		addr, err := dcp.xdcr_topology_svc.MyMemcachedAddr()
+		if err != nil {
+			return err
+		}
+
+		client, _, err := dcp.utils.GetMemcachedConnectionWFeatures(addr, dcp.sourceBucketName, dcp.user_agent, base.KeepAlivePeriod, dcp.savedMcReqFeatures, dcp.Logger())
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		var statsMap map[string]string
+		highSeqnoMap, _, unableToParseVBs, err := dcp.utils.GetHighSeqNos(dcp.ResponsibleVBs(), client, &statsMap, nil /*collectionIds*/, nil)
+		if err != nil {
+			return fmt.Errorf("Unable to get high seqnos to stop at %v", err)
+		}
+		if len(unableToParseVBs) > 0 {
+			return fmt.Errorf("Unable to parse high seqnos to stop at for vbs %v", unableToParseVBs)
+		}
+
+		for _, vb := range dcp.ResponsibleVBs() {
+			dcp.vbHighSeqnoMap[vb].SetSeqno((*highSeqnoMap)[vb])
+		}
+		atomic.StoreUint32(&dcp.caughtUpSeqnosLoaded, 1)
+		dcp.Logger().Infof("%v vb streams will end at seqnos %v", dcp.Id(), *highSeqnoMap)
+	}
+	return nil
+}
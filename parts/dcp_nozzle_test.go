@@ -469,3 +469,22 @@ func TestUprFeedNil(t *testing.T) {
 	assert.Nil(nozzle.getUprFeed())
 	fmt.Println("============== Test case end: TestUprFeedNil =================")
 }
+
+func TestStreamEndIsOkWhenCaughtUp(t *testing.T) {
+	fmt.Println("============== Test case start: TestStreamEndIsOkWhenCaughtUp =================")
+	defer fmt.Println("============== Test case end: TestStreamEndIsOkWhenCaughtUp =================")
+	assert := assert.New(t)
+	_, _, nozzle, _, _, _, _ := setupBoilerPlate()
+	nozzle.stopWhenCaughtUp = true
+	nozzle.vbHighSeqnoMap[0].SetSeqno(100)
+
+	// not caught up yet
+	nozzle.endSeqnoForDcp[0].SetSeqno(50)
+	assert.False(nozzle.vbStreamEndIsOk(0, dcpStreamEndOk))
+
+	// caught up, but only an end that is not caused by a state change or a slow stream counts
+	nozzle.endSeqnoForDcp[0].SetSeqno(100)
+	assert.True(nozzle.vbStreamEndIsOk(0, dcpStreamEndOk))
+	assert.False(nozzle.vbStreamEndIsOk(0, 0x02 /*state changed*/))
+	assert.False(nozzle.vbStreamEndIsOk(0, 0x04 /*slow*/))
+}
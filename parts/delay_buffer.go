@@ -0,0 +1,508 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software
+will be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package parts
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	mc "github.com/couchbase/gomemcached"
+	base "github.com/couchbase/goxdcr/base"
+	"github.com/couchbase/goxdcr/log"
+)
+
+// delayBufferEntry is a source mutation held by the delay buffer, along with the sibling requests it has been
+// cloned into. The entry is released once the source CAS of the mutation is older than the apply delay
+type delayBufferEntry struct {
+	req         *base.WrappedMCRequest
+	size        int64
+	releaseTime time.Time
+}
+
+// delayBufferRecord is the form in which a held request is spilled to disk. Its siblings are spilled with it
+type delayBufferRecord struct {
+	Seqno           uint64                    `json:"seqno"`
+	Opcode          mc.CommandCode            `json:"opcode"`
+	VBucket         uint16                    `json:"vb"`
+	Cas             uint64                    `json:"cas"`
+	DataType        uint8                     `json:"datatype"`
+	Extras          []byte                    `json:"extras"`
+	Key             []byte                    `json:"key"`
+	Body            []byte                    `json:"body,omitempty"`
+	SourceNamespace *base.CollectionNamespace `json:"srcNamespace,omitempty"`
+	ColInfo         *delayBufferColInfo       `json:"colInfo,omitempty"`
+	KeyRewritten    bool                      `json:"keyRewritten,omitempty"`
+	SrcVBucket      uint16                    `json:"srcVb,omitempty"`
+	SrcKey          []byte                    `json:"srcKey,omitempty"`
+	Siblings        []*delayBufferRecord      `json:"siblings,omitempty"`
+}
+
+type delayBufferColInfo struct {
+	ManifestId          uint64                    `json:"manifestId"`
+	ColId               uint32                    `json:"colId"`
+	ColIDPrefixedKeyLen int                       `json:"prefixedKeyLen"`
+	TargetNamespace     *base.CollectionNamespace `json:"targetNamespace,omitempty"`
+}
+
+// delayBufferSegment is a spill file. Records are appended to the last segment and read from the first one
+type delayBufferSegment struct {
+	path string
+	// number of records written into the segment
+	count int
+}
+
+// delayBuffer holds the mutations that XMEM receives until they are old enough to be sent, so that a target with an
+// apply delay lags behind the source. Held mutations are not sent, so they are not covered by the through seqno and
+// therefore not by checkpoints either. A restart streams them again.
+// Entries are released in the order they are received. Once the in-memory entries reach the memory limit, new
+// entries are spilled to segment files and read back as the in-memory entries are released. Adding an entry blocks
+// once the spilled entries reach the disk limit
+type delayBuffer struct {
+	id         string
+	delay      time.Duration
+	memLimit   int64
+	diskLimit  int64
+	filePrefix string
+
+	// allocates the collection ID prefixed keys of entries read back from disk. The router releases them into its
+	// own data pool when the requests are recycled, so they need to come from a data pool as well
+	dataPool base.DataPool
+	recycler func(*base.WrappedMCRequest)
+
+	mtx      sync.Mutex
+	cond     *sync.Cond
+	closed   bool
+	memQueue []*delayBufferEntry
+	memBytes int64
+
+	segments    []*delayBufferSegment
+	segmentSeq  int
+	writeFile   *os.File
+	writer      *bufio.Writer
+	writeSize   int64
+	readFile    *os.File
+	reader      *bufio.Reader
+	readCount   int
+	spillCount  int
+	spillBytes  int64
+	spillTotal  uint64
+	segmentSize int64
+
+	logger *log.CommonLogger
+}
+
+// filePrefix is the path that spill segment file names start with. Requests that are spilled are given to recycler
+func newDelayBuffer(id string, delay time.Duration, filePrefix string, recycler func(*base.WrappedMCRequest), logger *log.CommonLogger) *delayBuffer {
+	buf := &delayBuffer{
+		id:          id,
+		delay:       delay,
+		memLimit:    base.DelayBufferMemLimit,
+		diskLimit:   base.DelayBufferDiskLimit,
+		segmentSize: base.DelayBufferSegmentSize,
+		filePrefix:  filePrefix,
+		dataPool:    base.NewDataPool(),
+		recycler:    recycler,
+		logger:      logger,
+	}
+	buf.cond = sync.NewCond(&buf.mtx)
+	return buf
+}
+
+func (buf *delayBuffer) segmentPattern() string {
+	return fmt.Sprintf("%v_*%v", buf.filePrefix, base.DelayBufferFileSuffix)
+}
+
+// Removes the segments left behind by a previous instance that did not exit cleanly. Their mutations are streamed again
+func (buf *delayBuffer) removeStaleSegments() {
+	paths, err := filepath.Glob(buf.segmentPattern())
+	if err != nil {
+		buf.logger.Warnf("%v unable to list stale delay buffer segments. err=%v", buf.id, err)
+		return
+	}
+	for _, path := range paths {
+		if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+			buf.logger.Warnf("%v unable to remove stale delay buffer segment %v. err=%v", buf.id, path, err)
+		}
+	}
+	if len(paths) > 0 {
+		buf.logger.Infof("%v removed %v stale delay buffer segments", buf.id, len(paths))
+	}
+}
+
+func (buf *delayBuffer) releaseTimeOf(req *base.WrappedMCRequest) time.Time {
+	return time.Unix(0, int64(getSourceCas(req.Req))).Add(buf.delay)
+}
+
+func requestSizeWithSiblings(req *base.WrappedMCRequest) int64 {
+	size := int64(req.Req.Size())
+	req.SiblingReqsMtx.RLock()
+	for _, sibling := range req.SiblingReqs {
+		size += int64(sibling.Req.Size())
+	}
+	req.SiblingReqsMtx.RUnlock()
+	return size
+}
+
+// Holds the request and its siblings until they are old enough to be sent
+func (buf *delayBuffer) add(req *base.WrappedMCRequest) error {
+	entry := &delayBufferEntry{
+		req:         req,
+		size:        requestSizeWithSiblings(req),
+		releaseTime: buf.releaseTimeOf(req),
+	}
+
+	buf.mtx.Lock()
+	defer buf.mtx.Unlock()
+
+	for !buf.closed && buf.spillBytes >= buf.diskLimit {
+		buf.cond.Wait()
+	}
+	if buf.closed {
+		return PartStoppedError
+	}
+
+	// once entries have been spilled, newer entries are spilled as well until the spilled ones have been read back
+	if buf.spillCount == 0 && (buf.memBytes+entry.size <= buf.memLimit || len(buf.memQueue) == 0) {
+		buf.memQueue = append(buf.memQueue, entry)
+		buf.memBytes += entry.size
+		return nil
+	}
+	return buf.spill(entry)
+}
+
+// Returns up to maxCount requests, in the order they were added, whose release time is not after now
+func (buf *delayBuffer) release(now time.Time, maxCount int) ([]*base.WrappedMCRequest, error) {
+	buf.mtx.Lock()
+	defer buf.mtx.Unlock()
+
+	if buf.closed {
+		return nil, PartStoppedError
+	}
+
+	var released []*base.WrappedMCRequest
+	for len(buf.memQueue) > 0 && len(released) < maxCount {
+		entry := buf.memQueue[0]
+		if entry.releaseTime.After(now) {
+			break
+		}
+		buf.memQueue[0] = nil
+		buf.memQueue = buf.memQueue[1:]
+		buf.memBytes -= entry.size
+		released = append(released, entry.req)
+	}
+
+	if len(released) > 0 || len(buf.memQueue) == 0 {
+		if err := buf.readBack(); err != nil {
+			return released, err
+		}
+	}
+	return released, nil
+}
+
+// Returns the number of held mutations, the number of bytes currently spilled to disk, and the number of mutations
+// spilled so far
+func (buf *delayBuffer) stats() (count int, spillBytes int64, spillTotal uint64) {
+	buf.mtx.Lock()
+	defer buf.mtx.Unlock()
+	return len(buf.memQueue) + buf.spillCount, buf.spillBytes, buf.spillTotal
+}
+
+// Drops the held mutations and removes the spill segments. The mutations are streamed again after a restart
+func (buf *delayBuffer) close() {
+	buf.mtx.Lock()
+	defer buf.mtx.Unlock()
+	if buf.closed {
+		return
+	}
+	buf.closed = true
+	buf.cond.Broadcast()
+
+	buf.memQueue = nil
+	buf.memBytes = 0
+	if buf.writeFile != nil {
+		buf.writeFile.Close()
+		buf.writeFile = nil
+		buf.writer = nil
+	}
+	if buf.readFile != nil {
+		buf.readFile.Close()
+		buf.readFile = nil
+		buf.reader = nil
+	}
+	for _, segment := range buf.segments {
+		if err := os.Remove(segment.path); err != nil && !os.IsNotExist(err) {
+			buf.logger.Warnf("%v unable to remove delay buffer segment %v. err=%v", buf.id, segment.path, err)
+		}
+	}
+	buf.segments = nil
+	buf.spillCount = 0
+	buf.spillBytes = 0
+}
+
+// Caller holds buf.mtx
+func (buf *delayBuffer) spill(entry *delayBufferEntry) error {
+	if buf.writer == nil || buf.writeSize >= buf.segmentSize {
+		if err := buf.openNextSegment(); err != nil {
+			return err
+		}
+	}
+
+	recordBytes, err := json.Marshal(composeDelayBufferRecord(entry.req))
+	if err != nil {
+		return err
+	}
+	recordBytes = append(recordBytes, '\n')
+	if _, err = buf.writer.Write(recordBytes); err != nil {
+		return fmt.Errorf("%v unable to spill held mutation. err=%v", buf.id, err)
+	}
+
+	segment := buf.segments[len(buf.segments)-1]
+	segment.count++
+	buf.writeSize += int64(len(recordBytes))
+	buf.spillCount++
+	buf.spillBytes += int64(len(recordBytes))
+	buf.spillTotal++
+	if buf.spillCount == 1 {
+		buf.logger.Infof("%v has started spilling held mutations to disk. %v mutations are held in memory", buf.id, len(buf.memQueue))
+	}
+
+	// the requests are re-created when they are read back
+	buf.recycleWithSiblings(entry.req)
+	return nil
+}
+
+func (buf *delayBuffer) recycleWithSiblings(req *base.WrappedMCRequest) {
+	if buf.recycler == nil {
+		return
+	}
+	req.SiblingReqsMtx.RLock()
+	siblings := make([]*base.WrappedMCRequest, len(req.SiblingReqs))
+	copy(siblings, req.SiblingReqs)
+	req.SiblingReqsMtx.RUnlock()
+
+	buf.recycler(req)
+	for _, sibling := range siblings {
+		buf.recycler(sibling)
+	}
+}
+
+// Caller holds buf.mtx
+func (buf *delayBuffer) openNextSegment() error {
+	if err := buf.sealWriteSegment(); err != nil {
+		return err
+	}
+	buf.segmentSeq++
+	path := fmt.Sprintf("%v_%06d%v", buf.filePrefix, buf.segmentSeq, base.DelayBufferFileSuffix)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("%v unable to create delay buffer segment %v. err=%v", buf.id, path, err)
+	}
+	buf.writeFile = file
+	buf.writer = bufio.NewWriter(file)
+	buf.writeSize = 0
+	buf.segments = append(buf.segments, &delayBufferSegment{path: path})
+	return nil
+}
+
+// Stops appending to the current segment so that it can be read. Caller holds buf.mtx
+func (buf *delayBuffer) sealWriteSegment() error {
+	if buf.writer == nil {
+		return nil
+	}
+	err := buf.writer.Flush()
+	closeErr := buf.writeFile.Close()
+	buf.writer = nil
+	buf.writeFile = nil
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("%v unable to write delay buffer segment. err=%v", buf.id, err)
+	}
+	return nil
+}
+
+// Moves spilled entries back into memory, oldest first, until the memory limit is reached. Caller holds buf.mtx
+func (buf *delayBuffer) readBack() error {
+	readAny := false
+	for buf.spillCount > 0 && (buf.memBytes < buf.memLimit || len(buf.memQueue) == 0) {
+		if buf.reader == nil {
+			if err := buf.openReadSegment(); err != nil {
+				return err
+			}
+		}
+
+		line, err := buf.reader.ReadBytes('\n')
+		if err != nil {
+			return fmt.Errorf("%v unable to read delay buffer segment %v. err=%v", buf.id, buf.segments[0].path, err)
+		}
+		var record delayBufferRecord
+		if err = json.Unmarshal(line, &record); err != nil {
+			return fmt.Errorf("%v unable to decode delay buffer record from %v. err=%v", buf.id, buf.segments[0].path, err)
+		}
+		req, err := buf.newRequestFromRecord(&record)
+		if err != nil {
+			return err
+		}
+
+		entry := &delayBufferEntry{
+			req:         req,
+			size:        requestSizeWithSiblings(req),
+			releaseTime: buf.releaseTimeOf(req),
+		}
+		buf.memQueue = append(buf.memQueue, entry)
+		buf.memBytes += entry.size
+		buf.spillCount--
+		buf.spillBytes -= int64(len(line))
+		buf.readCount++
+		readAny = true
+
+		if buf.readCount == buf.segments[0].count {
+			buf.removeReadSegment()
+		}
+	}
+
+	if readAny {
+		// adding may have been blocked on the disk limit
+		buf.cond.Broadcast()
+		if buf.spillCount == 0 {
+			buf.logger.Infof("%v has read back all spilled mutations", buf.id)
+		}
+	}
+	return nil
+}
+
+// Caller holds buf.mtx
+func (buf *delayBuffer) openReadSegment() error {
+	if len(buf.segments) == 1 {
+		// the only segment is still being written to
+		if err := buf.sealWriteSegment(); err != nil {
+			return err
+		}
+	}
+	file, err := os.Open(buf.segments[0].path)
+	if err != nil {
+		return fmt.Errorf("%v unable to open delay buffer segment %v. err=%v", buf.id, buf.segments[0].path, err)
+	}
+	buf.readFile = file
+	buf.reader = bufio.NewReader(file)
+	buf.readCount = 0
+	return nil
+}
+
+// Caller holds buf.mtx
+func (buf *delayBuffer) removeReadSegment() {
+	path := buf.segments[0].path
+	buf.readFile.Close()
+	buf.readFile = nil
+	buf.reader = nil
+	buf.readCount = 0
+	buf.segments = buf.segments[1:]
+	if err := os.Remove(path); err != nil {
+		buf.logger.Warnf("%v unable to remove delay buffer segment %v. err=%v", buf.id, path, err)
+	}
+}
+
+func composeDelayBufferRecord(req *base.WrappedMCRequest) *delayBufferRecord {
+	record := &delayBufferRecord{
+		Seqno:           req.Seqno,
+		Opcode:          req.Req.Opcode,
+		VBucket:         req.Req.VBucket,
+		Cas:             req.Req.Cas,
+		DataType:        req.Req.DataType,
+		Extras:          req.Req.Extras,
+		Key:             req.Req.Key,
+		Body:            req.Req.Body,
+		SourceNamespace: req.GetSourceCollectionNamespace(),
+		KeyRewritten:    req.KeyRewritten,
+		SrcVBucket:      req.SrcVBucket,
+		SrcKey:          req.SrcKey,
+	}
+
+	req.ColInfoMtx.RLock()
+	if req.ColInfo != nil {
+		record.ColInfo = &delayBufferColInfo{
+			ManifestId:          req.ColInfo.ManifestId,
+			ColId:               req.ColInfo.ColId,
+			ColIDPrefixedKeyLen: req.ColInfo.ColIDPrefixedKeyLen,
+			TargetNamespace:     req.ColInfo.TargetNamespace,
+		}
+	}
+	req.ColInfoMtx.RUnlock()
+
+	req.SiblingReqsMtx.RLock()
+	for _, sibling := range req.SiblingReqs {
+		record.Siblings = append(record.Siblings, composeDelayBufferRecord(sibling))
+	}
+	req.SiblingReqsMtx.RUnlock()
+	return record
+}
+
+func (buf *delayBuffer) newRequestFromRecord(record *delayBufferRecord) (*base.WrappedMCRequest, error) {
+	if len(record.Extras) < 24 {
+		return nil, fmt.Errorf("%v delay buffer record for seqno %v of vb %v has invalid extras", buf.id, record.Seqno, record.VBucket)
+	}
+	req := &mc.MCRequest{
+		Opcode:   record.Opcode,
+		VBucket:  record.VBucket,
+		Cas:      record.Cas,
+		DataType: record.DataType,
+		Extras:   record.Extras,
+		Key:      record.Key,
+		Body:     record.Body,
+	}
+
+	wrappedReq := &base.WrappedMCRequest{
+		Seqno:           record.Seqno,
+		Req:             req,
+		Start_time:      time.Now(),
+		SrcColNamespace: record.SourceNamespace,
+		KeyRewritten:    record.KeyRewritten,
+		SrcVBucket:      record.SrcVBucket,
+		SrcKey:          record.SrcKey,
+	}
+
+	if record.ColInfo != nil {
+		colInfo := &base.TargetCollectionInfo{
+			ManifestId:      record.ColInfo.ManifestId,
+			ColId:           record.ColInfo.ColId,
+			TargetNamespace: record.ColInfo.TargetNamespace,
+		}
+		if record.ColInfo.ColIDPrefixedKeyLen > 0 {
+			prefixedKey, err := buf.dataPool.GetByteSlice(uint64(len(record.Key)))
+			if err != nil {
+				return nil, err
+			}
+			copy(prefixedKey, record.Key)
+			colInfo.ColIDPrefixedKey = prefixedKey
+			colInfo.ColIDPrefixedKeyLen = len(record.Key)
+			req.Key = prefixedKey[:len(record.Key)]
+		}
+		wrappedReq.ColInfo = colInfo
+	}
+	req.Keylen = len(req.Key)
+
+	for _, siblingRecord := range record.Siblings {
+		sibling, err := buf.newRequestFromRecord(siblingRecord)
+		if err != nil {
+			return nil, err
+		}
+		wrappedReq.SiblingReqs = append(wrappedReq.SiblingReqs, sibling)
+	}
+
+	wrappedReq.ConstructUniqueKey()
+	return wrappedReq, nil
+}
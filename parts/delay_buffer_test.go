@@ -0,0 +1,153 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software
+will be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package parts
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	mc "github.com/couchbase/gomemcached"
+	"github.com/couchbase/goxdcr/base"
+	"github.com/couchbase/goxdcr/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func newDelayBufferTestReq(key string, seqno uint64, casTime time.Time) *base.WrappedMCRequest {
+	extras := make([]byte, 24)
+	binary.BigEndian.PutUint64(extras[8:16], seqno)
+	binary.BigEndian.PutUint64(extras[16:24], uint64(casTime.UnixNano()))
+	req := &base.WrappedMCRequest{
+		Seqno: seqno,
+		Req: &mc.MCRequest{
+			Opcode:  base.SET_WITH_META,
+			VBucket: 5,
+			Extras:  extras,
+			Key:     []byte(key),
+			Body:    []byte(`{"a":1}`),
+		},
+	}
+	req.ConstructUniqueKey()
+	return req
+}
+
+func TestDelayBufferRelease(t *testing.T) {
+	fmt.Println("============== Test case start: TestDelayBufferRelease =================")
+	defer fmt.Println("============== Test case end: TestDelayBufferRelease =================")
+	assert := assert.New(t)
+
+	now := time.Now()
+	buf := newDelayBuffer("testXmem", time.Hour, "", nil, log.NewLogger("testDelayBuffer", log.DefaultLoggerContext))
+	assert.Nil(buf.add(newDelayBufferTestReq("old", 1, now.Add(-2*time.Hour))))
+	assert.Nil(buf.add(newDelayBufferTestReq("recent", 2, now.Add(-30*time.Minute))))
+	assert.Nil(buf.add(newDelayBufferTestReq("olderButLater", 3, now.Add(-3*time.Hour))))
+
+	// the entries are released in order, so the third one waits for the second one
+	released, err := buf.release(now, 10)
+	assert.Nil(err)
+	assert.Len(released, 1)
+	assert.Equal(uint64(1), released[0].Seqno)
+
+	released, err = buf.release(now.Add(31*time.Minute), 10)
+	assert.Nil(err)
+	assert.Len(released, 2)
+	assert.Equal(uint64(2), released[0].Seqno)
+	assert.Equal(uint64(3), released[1].Seqno)
+
+	held, _, _ := buf.stats()
+	assert.Equal(0, held)
+
+	buf.close()
+	assert.Equal(PartStoppedError, buf.add(newDelayBufferTestReq("closed", 4, now)))
+}
+
+func TestDelayBufferSpill(t *testing.T) {
+	fmt.Println("============== Test case start: TestDelayBufferSpill =================")
+	defer fmt.Println("============== Test case end: TestDelayBufferSpill =================")
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "delayBuffer")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	var recycled []*base.WrappedMCRequest
+	recycler := func(req *base.WrappedMCRequest) {
+		recycled = append(recycled, req)
+	}
+	buf := newDelayBuffer("testXmem", time.Minute, filepath.Join(dir, "xdcr_delay_buffer_test"), recycler,
+		log.NewLogger("testDelayBuffer", log.DefaultLoggerContext))
+	// one entry fits in memory, and each spilled entry goes into a segment of its own
+	buf.memLimit = 1
+	buf.segmentSize = 1
+
+	casTime := time.Now().Add(-time.Hour)
+	targetNamespace := &base.CollectionNamespace{ScopeName: "S1", CollectionName: "C1"}
+	for i := uint64(1); i <= 5; i++ {
+		req := newDelayBufferTestReq(fmt.Sprintf("doc%v", i), i, casTime)
+		// key prefixed with leb128 encoded collection ID 8, as the router does
+		req.Req.Key = append([]byte{0x08}, req.Req.Key...)
+		req.ColInfo = &base.TargetCollectionInfo{ManifestId: 3, ColId: 8, ColIDPrefixedKeyLen: len(req.Req.Key), TargetNamespace: targetNamespace}
+		sibling := newDelayBufferTestReq(fmt.Sprintf("doc%v", i), i, casTime)
+		sibling.KeyRewritten = true
+		sibling.SrcVBucket = 7
+		sibling.SrcKey = []byte("srcKey")
+		req.SiblingReqs = append(req.SiblingReqs, sibling)
+		assert.Nil(buf.add(req))
+	}
+
+	held, spillBytes, spillTotal := buf.stats()
+	assert.Equal(5, held)
+	assert.True(spillBytes > 0)
+	assert.Equal(uint64(4), spillTotal)
+	// the spilled requests and their siblings are recycled
+	assert.Len(recycled, 8)
+	segments, _ := filepath.Glob(buf.segmentPattern())
+	assert.Len(segments, 4)
+
+	var releasedSeqnos []uint64
+	for len(releasedSeqnos) < 5 {
+		released, err := buf.release(time.Now(), 10)
+		assert.Nil(err)
+		if !assert.NotEmpty(released) {
+			break
+		}
+		for _, req := range released {
+			releasedSeqnos = append(releasedSeqnos, req.Seqno)
+			assert.Equal(fmt.Sprintf("doc%v", req.Seqno), string(req.GetPlainKey()))
+			assert.Equal(uint32(8), req.ColInfo.ColId)
+			assert.Equal(uint64(3), req.GetManifestId())
+			assert.True(req.ColInfo.TargetNamespace.IsSameAs(*targetNamespace))
+			assert.Len(req.SiblingReqs, 1)
+			assert.True(req.SiblingReqs[0].KeyRewritten)
+			assert.Equal(uint16(7), req.SiblingReqs[0].GetSourceVBucket())
+			assert.Equal("srcKey", string(req.SiblingReqs[0].GetSourceKey()))
+			assert.Equal(`{"a":1}`, string(req.Req.Body))
+		}
+	}
+	assert.Equal([]uint64{1, 2, 3, 4, 5}, releasedSeqnos)
+
+	held, spillBytes, _ = buf.stats()
+	assert.Equal(0, held)
+	assert.Equal(int64(0), spillBytes)
+	segments, _ = filepath.Glob(buf.segmentPattern())
+	assert.Len(segments, 0)
+
+	// stale segments of a previous instance are removed
+	assert.Nil(ioutil.WriteFile(filepath.Join(dir, "xdcr_delay_buffer_test_000009.jsonl"), []byte("{}\n"), 0600))
+	buf.removeStaleSegments()
+	segments, _ = filepath.Glob(buf.segmentPattern())
+	assert.Len(segments, 0)
+	buf.close()
+}
@@ -0,0 +1,625 @@
+// Copyright 2013-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included in
+// the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+// file, in accordance with the Business Source License, use of this software
+// will be governed by the Apache License, Version 2.0, included in the file
+// licenses/APL2.txt.
+
+package parts
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mc "github.com/couchbase/gomemcached"
+	base "github.com/couchbase/goxdcr/base"
+	common "github.com/couchbase/goxdcr/common"
+	"github.com/couchbase/goxdcr/log"
+	"github.com/couchbase/goxdcr/metadata"
+	utilities "github.com/couchbase/goxdcr/utils"
+	"github.com/golang/snappy"
+)
+
+const (
+	default_selfMonitorInterval_fileExport time.Duration = 1 * time.Second
+	default_batchCount_fileExport          int           = 500
+)
+
+var file_export_setting_defs base.SettingDefinitions = base.SettingDefinitions{SETTING_BATCHCOUNT: base.NewSettingDef(reflect.TypeOf((*int)(nil)), true),
+	SETTING_STATS_INTERVAL: base.NewSettingDef(reflect.TypeOf((*int)(nil)), false)}
+
+// Replication IDs and part IDs contain characters such as '/' and ':' that do not belong in a file name
+var fileExportNameSanitizer = regexp.MustCompile("[^A-Za-z0-9._-]")
+
+func sanitizeFileExportName(name string) string {
+	return fileExportNameSanitizer.ReplaceAllString(name, "_")
+}
+
+// All the nozzles of a replication, main and backfill pipelines alike, write into the same directory
+func FileExportDirForReplication(fileExportDir, replicationId string) string {
+	return filepath.Join(fileExportDir, sanitizeFileExportName(replicationId))
+}
+
+// TargetNamespaceGetter resolves the target collection ID that the router has routed a mutation to
+type TargetNamespaceGetter func(manifestId uint64, colId uint32) (*base.CollectionNamespace, error)
+
+/************************************
+/* struct fileExportConfig
+*************************************/
+type fileExportConfig struct {
+	baseConfig
+	// the directory that this nozzle's files are written to
+	exportDir     string
+	maxFileSize   int64
+	flushInterval time.Duration
+}
+
+func newFileExportConfig(logger *log.CommonLogger) fileExportConfig {
+	return fileExportConfig{
+		baseConfig: baseConfig{maxCount: default_batchCount_fileExport,
+			selfMonitorInterval: default_selfMonitorInterval_fileExport,
+			statsInterval:       time.Duration(metadata.DefaultPipelineStatsIntervalMs) * time.Millisecond,
+			logger:              logger,
+		},
+		maxFileSize:   base.FileExportMaxFileSize,
+		flushInterval: base.FileExportFlushInterval,
+	}
+}
+
+func (config *fileExportConfig) initializeConfig(settings metadata.ReplicationSettingsMap, utils utilities.UtilsIface) error {
+	err := utils.ValidateSettings(file_export_setting_defs, settings, config.logger)
+	if err == nil {
+		config.baseConfig.initializeConfig(settings)
+	}
+	return err
+}
+
+/************************************
+/* struct FileExportNozzle
+*************************************/
+// FileExportNozzle writes the routed mutations to rotating files on the local disk instead of a target bucket.
+// Each line of a file is a JSON encoded base.FileExportRecord. Files are never deleted by XDCR.
+// A mutation is reported as sent only once it has been synced to disk, so checkpoints never cover
+// data that is not in the archive. Data written after the last checkpoint is written again when
+// the pipeline restarts, i.e. the archive may contain duplicates but never has gaps.
+type FileExportNozzle struct {
+	AbstractPart
+
+	bOpen      bool
+	lock_bOpen sync.RWMutex
+
+	topic  string
+	vbList []uint16
+	config fileExportConfig
+
+	dataChan chan *base.WrappedMCRequest
+	//the total number of items queued in the data channel
+	items_in_dataChan int32
+	//the total size of data (in bytes) queued in the data channel
+	bytes_in_dataChan int64
+
+	// only accessed by the processData routine
+	file         *os.File
+	writer       *bufio.Writer
+	curFileSize  int64
+	fileSeq      int
+	startTime    time.Time
+	pendingReqs  []*base.WrappedMCRequest
+	namespaceMap map[uint64]map[uint32]string
+
+	targetNamespaceGetter TargetNamespaceGetter
+	upstreamObjRecycler   utilities.RecycleObjFunc
+
+	childrenWaitGrp sync.WaitGroup
+	finish_ch       chan bool
+
+	counter_received  uint64
+	counter_written   uint64
+	counter_files     uint64
+	handle_error      bool
+	lock_handle_error sync.RWMutex
+
+	utils utilities.UtilsIface
+}
+
+func NewFileExportNozzle(id string,
+	topic string,
+	exportDir string,
+	targetNamespaceGetter TargetNamespaceGetter,
+	logger_context *log.LoggerContext,
+	utilsIn utilities.UtilsIface,
+	vbList []uint16) *FileExportNozzle {
+
+	part := NewAbstractPartWithLogger(id, log.NewLogger("FileExportNozzle", logger_context))
+
+	nozzle := &FileExportNozzle{
+		AbstractPart:          part,
+		bOpen:                 true,
+		topic:                 topic,
+		vbList:                vbList,
+		config:                newFileExportConfig(part.Logger()),
+		finish_ch:             make(chan bool),
+		handle_error:          true,
+		targetNamespaceGetter: targetNamespaceGetter,
+		utils:                 utilsIn,
+	}
+	nozzle.config.exportDir = exportDir
+	return nozzle
+}
+
+func (nozzle *FileExportNozzle) IsOpen() bool {
+	nozzle.lock_bOpen.RLock()
+	defer nozzle.lock_bOpen.RUnlock()
+	return nozzle.bOpen
+}
+
+func (nozzle *FileExportNozzle) Open() error {
+	nozzle.lock_bOpen.Lock()
+	defer nozzle.lock_bOpen.Unlock()
+	nozzle.bOpen = true
+	return nil
+}
+
+func (nozzle *FileExportNozzle) Close() error {
+	nozzle.lock_bOpen.Lock()
+	defer nozzle.lock_bOpen.Unlock()
+	nozzle.bOpen = false
+	return nil
+}
+
+func (nozzle *FileExportNozzle) handleError() bool {
+	nozzle.lock_handle_error.RLock()
+	defer nozzle.lock_handle_error.RUnlock()
+	return nozzle.handle_error
+}
+
+func (nozzle *FileExportNozzle) disableHandleError() {
+	nozzle.lock_handle_error.Lock()
+	defer nozzle.lock_handle_error.Unlock()
+	nozzle.handle_error = false
+}
+
+func (nozzle *FileExportNozzle) Start(settings metadata.ReplicationSettingsMap) error {
+	nozzle.Logger().Infof("%v starting ....\n", nozzle.Id())
+
+	err := nozzle.SetState(common.Part_Starting)
+	if err != nil {
+		return err
+	}
+
+	err = nozzle.initialize(settings)
+	if err != nil {
+		return err
+	}
+
+	nozzle.childrenWaitGrp.Add(1)
+	go nozzle.selfMonitor(nozzle.finish_ch, &nozzle.childrenWaitGrp)
+
+	nozzle.childrenWaitGrp.Add(1)
+	go nozzle.processData(nozzle.finish_ch, &nozzle.childrenWaitGrp)
+
+	err = nozzle.SetState(common.Part_Running)
+	if err != nil {
+		nozzle.Logger().Errorf("%v failed to set state to running. err=%v\n", nozzle.Id(), err)
+		return err
+	}
+
+	nozzle.Logger().Infof("%v has been started successfully. Exporting to %v\n", nozzle.Id(), nozzle.config.exportDir)
+	return nil
+}
+
+func (nozzle *FileExportNozzle) initialize(settings metadata.ReplicationSettingsMap) error {
+	err := nozzle.config.initializeConfig(settings, nozzle.utils)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(nozzle.config.exportDir, 0700)
+	if err != nil {
+		return fmt.Errorf("%v unable to create export directory %v. err=%v", nozzle.Id(), nozzle.config.exportDir, err)
+	}
+
+	nozzle.dataChan = make(chan *base.WrappedMCRequest, nozzle.config.maxCount*base.CapiDataChanSizeMultiplier)
+	nozzle.pendingReqs = make([]*base.WrappedMCRequest, 0, nozzle.config.maxCount)
+	nozzle.namespaceMap = make(map[uint64]map[uint32]string)
+	// a new sequence of files is started every time the nozzle starts so that files from a previous run are never appended to
+	nozzle.startTime = time.Now()
+	nozzle.fileSeq = 0
+	return nozzle.openNextFile()
+}
+
+func (nozzle *FileExportNozzle) Stop() error {
+	nozzle.Logger().Infof("%v stopping \n", nozzle.Id())
+
+	err := nozzle.SetState(common.Part_Stopping)
+	if err != nil {
+		return err
+	}
+
+	nozzle.onExit()
+
+	err = nozzle.SetState(common.Part_Stopped)
+	if err == nil {
+		nozzle.Logger().Infof("%v has been stopped\n", nozzle.Id())
+	} else {
+		nozzle.Logger().Errorf("%v failed to stop. err=%v\n", nozzle.Id(), err)
+	}
+	return err
+}
+
+func (nozzle *FileExportNozzle) onExit() {
+	//in the process of stopping, no need to report any error to replication manager anymore
+	nozzle.disableHandleError()
+
+	//notify the data processing routine
+	close(nozzle.finish_ch)
+	nozzle.childrenWaitGrp.Wait()
+
+	nozzle.closeFile()
+}
+
+// Coming from Router's Forward
+func (nozzle *FileExportNozzle) Receive(data interface{}) error {
+	defer func() {
+		if r := recover(); r != nil {
+			nozzle.Logger().Errorf("%v recovered from %v", nozzle.Id(), r)
+			if nozzle.validateRunningState() == nil {
+				nozzle.handleGeneralError(errors.New(fmt.Sprintf("%v", r)))
+			}
+		}
+	}()
+
+	err := nozzle.validateRunningState()
+	if err != nil {
+		nozzle.Logger().Infof("%v is in %v state, Receive did no-op", nozzle.Id(), nozzle.State())
+		return err
+	}
+
+	request, ok := data.(*base.WrappedMCRequest)
+	if !ok {
+		err = fmt.Errorf("Got data of unexpected type")
+		nozzle.handleGeneralError(err)
+		return err
+	}
+
+	err = nozzle.writeToDataChan(request)
+	if err != nil {
+		return err
+	}
+
+	request.SiblingReqsMtx.RLock()
+	defer request.SiblingReqsMtx.RUnlock()
+	for _, siblingReq := range request.SiblingReqs {
+		err = nozzle.writeToDataChan(siblingReq)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (nozzle *FileExportNozzle) writeToDataChan(request *base.WrappedMCRequest) error {
+	atomic.AddUint64(&nozzle.counter_received, 1)
+	atomic.AddInt32(&nozzle.items_in_dataChan, 1)
+	atomic.AddInt64(&nozzle.bytes_in_dataChan, int64(request.Req.Size()))
+
+	select {
+	case nozzle.dataChan <- request:
+		return nil
+	// provides an alternative exit path when the nozzle stops
+	case <-nozzle.finish_ch:
+		return PartStoppedError
+	}
+}
+
+func (nozzle *FileExportNozzle) processData(finch chan bool, waitGrp *sync.WaitGroup) {
+	defer waitGrp.Done()
+	flushTicker := time.NewTicker(nozzle.config.flushInterval)
+	defer flushTicker.Stop()
+
+	for {
+		select {
+		case <-finch:
+			// write out what has been buffered. It is not reported as sent since the pipeline is going away
+			if nozzle.writer != nil {
+				nozzle.writer.Flush()
+			}
+			goto done
+		case req := <-nozzle.dataChan:
+			atomic.AddInt32(&nozzle.items_in_dataChan, -1)
+			atomic.AddInt64(&nozzle.bytes_in_dataChan, int64(0-req.Req.Size()))
+			err := nozzle.writeRecord(req)
+			if err != nil {
+				nozzle.handleGeneralError(err)
+				goto done
+			}
+			if len(nozzle.pendingReqs) >= nozzle.config.maxCount {
+				if err = nozzle.flush(); err != nil {
+					nozzle.handleGeneralError(err)
+					goto done
+				}
+			}
+		case <-flushTicker.C:
+			if len(nozzle.pendingReqs) > 0 {
+				if err := nozzle.flush(); err != nil {
+					nozzle.handleGeneralError(err)
+					goto done
+				}
+			}
+		}
+	}
+done:
+	nozzle.Logger().Infof("%v processData routine exits", nozzle.Id())
+}
+
+func (nozzle *FileExportNozzle) writeRecord(req *base.WrappedMCRequest) error {
+	record, err := nozzle.composeRecord(req)
+	if err != nil {
+		return err
+	}
+	recordBytes, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	recordBytes = append(recordBytes, '\n')
+	n, err := nozzle.writer.Write(recordBytes)
+	nozzle.curFileSize += int64(n)
+	if err != nil {
+		return fmt.Errorf("%v failed to write to %v. err=%v", nozzle.Id(), nozzle.file.Name(), err)
+	}
+	nozzle.pendingReqs = append(nozzle.pendingReqs, req)
+	return nil
+}
+
+// Everything written so far is synced to disk before being reported as sent, which is what allows checkpoints to move forward
+func (nozzle *FileExportNozzle) flush() error {
+	start_time := time.Now()
+	err := nozzle.writer.Flush()
+	if err == nil {
+		err = nozzle.file.Sync()
+	}
+	if err != nil {
+		return fmt.Errorf("%v failed to sync %v. err=%v", nozzle.Id(), nozzle.file.Name(), err)
+	}
+	commit_time := time.Since(start_time)
+
+	for _, req := range nozzle.pendingReqs {
+		additionalInfo := DataSentEventAdditional{Seqno: req.Seqno,
+			IsOptRepd:      true,
+			Opcode:         encodeOpCode(req.Req, false /*isCustomCR*/),
+			IsExpirySet:    (binary.BigEndian.Uint32(req.Req.Extras[4:8]) != 0),
+			VBucket:        req.GetSourceVBucket(),
+			Req_size:       req.Req.Size(),
+			Commit_time:    commit_time,
+			Resp_wait_time: commit_time,
+			ManifestId:     req.GetManifestId(),
+			SourceCas:      getSourceCas(req.Req),
+		}
+		additionalInfo.SourceNamespace, additionalInfo.TargetNamespace = getStatsNamespaces(req)
+		nozzle.RaiseEvent(common.NewEvent(common.DataSent, nil, nozzle, nil, additionalInfo))
+		if nozzle.upstreamObjRecycler != nil {
+			nozzle.upstreamObjRecycler(req)
+		}
+	}
+	atomic.AddUint64(&nozzle.counter_written, uint64(len(nozzle.pendingReqs)))
+	nozzle.pendingReqs = nozzle.pendingReqs[:0]
+
+	if nozzle.curFileSize >= nozzle.config.maxFileSize {
+		nozzle.closeFile()
+		return nozzle.openNextFile()
+	}
+	return nil
+}
+
+func (nozzle *FileExportNozzle) openNextFile() error {
+	nozzle.fileSeq++
+	fileName := fmt.Sprintf("%v_%v_%06d%v", sanitizeFileExportName(nozzle.Id()), nozzle.startTime.UnixNano(), nozzle.fileSeq, base.FileExportFileSuffix)
+	file, err := os.OpenFile(filepath.Join(nozzle.config.exportDir, fileName), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("%v unable to create export file %v. err=%v", nozzle.Id(), fileName, err)
+	}
+	nozzle.file = file
+	nozzle.writer = bufio.NewWriter(file)
+	nozzle.curFileSize = 0
+	atomic.AddUint64(&nozzle.counter_files, 1)
+	nozzle.Logger().Infof("%v started writing to %v", nozzle.Id(), file.Name())
+	return nil
+}
+
+func (nozzle *FileExportNozzle) closeFile() {
+	if nozzle.file == nil {
+		return
+	}
+	if err := nozzle.writer.Flush(); err != nil {
+		nozzle.Logger().Warnf("%v error flushing %v. err=%v", nozzle.Id(), nozzle.file.Name(), err)
+	}
+	if err := nozzle.file.Close(); err != nil {
+		nozzle.Logger().Warnf("%v error closing %v. err=%v", nozzle.Id(), nozzle.file.Name(), err)
+	}
+	nozzle.file = nil
+	nozzle.writer = nil
+}
+
+func (nozzle *FileExportNozzle) composeRecord(wrappedReq *base.WrappedMCRequest) (*base.FileExportRecord, error) {
+	req := wrappedReq.Req
+	record := &base.FileExportRecord{
+		Key:              string(wrappedReq.GetPlainKey()),
+		VBucket:          req.VBucket,
+		Seqno:            wrappedReq.Seqno,
+		Cas:              req.Cas,
+		Flags:            binary.BigEndian.Uint32(req.Extras[0:4]),
+		Expiry:           binary.BigEndian.Uint32(req.Extras[4:8]),
+		RevSeq:           binary.BigEndian.Uint64(req.Extras[8:16]),
+		DataType:         req.DataType &^ base.SnappyDataType,
+		SourceNamespace:  base.DefaultCollectionNamespace.ToIndexString(),
+		TargetManifestId: wrappedReq.GetManifestId(),
+	}
+
+	switch req.Opcode {
+	case mc.UPR_DELETION:
+		record.Op = base.FileExportOpDeletion
+	case mc.UPR_EXPIRATION:
+		record.Op = base.FileExportOpExpiration
+	default:
+		record.Op = base.FileExportOpMutation
+	}
+
+	if namespace := wrappedReq.GetSourceCollectionNamespace(); namespace != nil {
+		record.SourceNamespace = namespace.ToIndexString()
+	}
+
+	targetNamespace, err := nozzle.getTargetNamespace(wrappedReq)
+	if err != nil {
+		return nil, err
+	}
+	record.TargetNamespace = targetNamespace
+
+	body := req.Body
+	if req.DataType&base.SnappyDataType > 0 {
+		body, err = snappy.Decode(nil, body)
+		if err != nil {
+			return nil, fmt.Errorf("%v unable to decompress doc %v%s%v. err=%v", nozzle.Id(), base.UdTagBegin, record.Key, base.UdTagEnd, err)
+		}
+	}
+	if req.DataType&base.XattrDataType > 0 {
+		iterator, err := base.NewXattrIterator(body)
+		if err != nil {
+			return nil, err
+		}
+		record.Xattrs = make(map[string]string)
+		for iterator.HasNext() {
+			key, value, err := iterator.Next()
+			if err != nil {
+				return nil, err
+			}
+			record.Xattrs[string(key)] = string(value)
+		}
+		body, err = base.StripXattrAndGetBody(body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(body) > 0 {
+		if req.DataType&base.JSONDataType > 0 && json.Valid(body) {
+			record.Doc = json.RawMessage(body)
+		} else {
+			record.DocBinary = body
+		}
+	}
+	return record, nil
+}
+
+// The names are cached since the target collection IDs only change with the target manifest
+func (nozzle *FileExportNozzle) getTargetNamespace(wrappedReq *base.WrappedMCRequest) (string, error) {
+	var colId uint32
+	wrappedReq.ColInfoMtx.RLock()
+	if wrappedReq.ColInfo != nil {
+		if wrappedReq.ColInfo.TargetNamespace != nil {
+			namespace := wrappedReq.ColInfo.TargetNamespace.ToIndexString()
+			wrappedReq.ColInfoMtx.RUnlock()
+			return namespace, nil
+		}
+		colId = wrappedReq.ColInfo.ColId
+	}
+	wrappedReq.ColInfoMtx.RUnlock()
+
+	if colId == 0 {
+		return base.DefaultCollectionNamespace.ToIndexString(), nil
+	}
+
+	manifestId := wrappedReq.GetManifestId()
+	if namespace, ok := nozzle.namespaceMap[manifestId][colId]; ok {
+		return namespace, nil
+	}
+	if nozzle.targetNamespaceGetter == nil {
+		return "", fmt.Errorf("%v unable to resolve target collection ID %v", nozzle.Id(), colId)
+	}
+	namespace, err := nozzle.targetNamespaceGetter(manifestId, colId)
+	if err != nil {
+		return "", fmt.Errorf("%v unable to resolve target collection ID %v with manifest %v. err=%v", nozzle.Id(), colId, manifestId, err)
+	}
+	if _, ok := nozzle.namespaceMap[manifestId]; !ok {
+		nozzle.namespaceMap[manifestId] = make(map[uint32]string)
+	}
+	nozzle.namespaceMap[manifestId][colId] = namespace.ToIndexString()
+	return nozzle.namespaceMap[manifestId][colId], nil
+}
+
+func (nozzle *FileExportNozzle) selfMonitor(finch chan bool, waitGrp *sync.WaitGroup) {
+	defer waitGrp.Done()
+	statsTicker := time.NewTicker(nozzle.config.statsInterval)
+	defer statsTicker.Stop()
+	for {
+		select {
+		case <-finch:
+			goto done
+		case <-statsTicker.C:
+			nozzle.RaiseEvent(common.NewEvent(common.StatsUpdate, nil, nozzle, nil, []int{int(atomic.LoadInt32(&nozzle.items_in_dataChan)), int(atomic.LoadInt64(&nozzle.bytes_in_dataChan))}))
+		}
+	}
+done:
+	nozzle.Logger().Infof("%v selfMonitor routine exits", nozzle.Id())
+}
+
+func (nozzle *FileExportNozzle) validateRunningState() error {
+	state := nozzle.State()
+	if state == common.Part_Stopping || state == common.Part_Stopped || state == common.Part_Error {
+		return PartStoppedError
+	}
+	return nil
+}
+
+func (nozzle *FileExportNozzle) handleGeneralError(err error) {
+	if nozzle.handleError() {
+		err1 := nozzle.SetState(common.Part_Error)
+		if err1 == nil {
+			nozzle.Logger().Errorf("%v raise error condition %v\n", nozzle.Id(), err)
+			nozzle.RaiseEvent(common.NewEvent(common.ErrorEncountered, nil, nozzle, nil, err))
+		} else {
+			nozzle.Logger().Infof("%v is already in error state. err=%v is ignored\n", nozzle.Id(), err)
+		}
+	} else {
+		nozzle.Logger().Infof("%v is already in shutdown process, err=%v is ignored\n", nozzle.Id(), err)
+	}
+}
+
+func (nozzle *FileExportNozzle) PrintStatusSummary() {
+	nozzle.Logger().Infof("%v state=%v received %v items, written %v items, %v in queue, %v files in %v",
+		nozzle.Id(), nozzle.State(), atomic.LoadUint64(&nozzle.counter_received), atomic.LoadUint64(&nozzle.counter_written),
+		atomic.LoadInt32(&nozzle.items_in_dataChan), atomic.LoadUint64(&nozzle.counter_files), nozzle.config.exportDir)
+}
+
+func (nozzle *FileExportNozzle) UpdateSettings(settings metadata.ReplicationSettingsMap) error {
+	return nil
+}
+
+func (nozzle *FileExportNozzle) RecycleDataObj(incomingReq interface{}) {
+	req, ok := incomingReq.(*base.WrappedMCRequest)
+	if ok && nozzle.upstreamObjRecycler != nil {
+		nozzle.upstreamObjRecycler(req)
+	}
+}
+
+func (nozzle *FileExportNozzle) ResponsibleVBs() []uint16 {
+	return nozzle.vbList
+}
+
+// Should only be done during pipeline construction
+func (nozzle *FileExportNozzle) SetUpstreamObjRecycler(recycler func(interface{})) {
+	nozzle.upstreamObjRecycler = recycler
+}
+
+func (nozzle *FileExportNozzle) SetUpstreamErrReporter(func(interface{})) {
+	// no op
+}
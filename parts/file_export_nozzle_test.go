@@ -0,0 +1,176 @@
+/*
+Copyright 2026-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software
+will be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package parts
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	base "github.com/couchbase/goxdcr/base"
+	common "github.com/couchbase/goxdcr/common"
+	"github.com/couchbase/goxdcr/log"
+	"github.com/couchbase/goxdcr/metadata"
+	utilsReal "github.com/couchbase/goxdcr/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// Records the DataSent events, together with what was on disk at the time each of them was raised
+type fileExportTestListener struct {
+	nozzle       *FileExportNozzle
+	sentSeqnos   []uint64
+	linesAtEvent []int
+}
+
+func (l *fileExportTestListener) OnEvent(event *common.Event) {
+	l.sentSeqnos = append(l.sentSeqnos, event.OtherInfos.(DataSentEventAdditional).Seqno)
+	lines, _ := readFileExportTestLines(l.nozzle.file.Name())
+	l.linesAtEvent = append(l.linesAtEvent, len(lines))
+}
+
+func readFileExportTestLines(fileName string) ([]string, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
+
+func setupFileExportNozzle(assert *assert.Assertions) (*FileExportNozzle, *fileExportTestListener, string) {
+	dir, err := ioutil.TempDir("", "fileExportNozzle")
+	assert.Nil(err)
+
+	nozzle := NewFileExportNozzle("fileExportUnitTest", "topic", dir, nil, log.DefaultLoggerContext, utilsReal.NewUtilities(), []uint16{12})
+	listener := &fileExportTestListener{nozzle: nozzle}
+	assert.Nil(nozzle.RegisterComponentEventListener(common.DataSent, listener))
+	assert.Nil(nozzle.initialize(metadata.ReplicationSettingsMap{SETTING_BATCHCOUNT: 10}))
+	return nozzle, listener, dir
+}
+
+func TestFileExportNozzleWritesJsonLines(t *testing.T) {
+	fmt.Println("============== Test case start: TestFileExportNozzleWritesJsonLines =================")
+	defer fmt.Println("============== Test case end: TestFileExportNozzleWritesJsonLines =================")
+	assert := assert.New(t)
+
+	nozzle, _, dir := setupFileExportNozzle(assert)
+	defer os.RemoveAll(dir)
+
+	keys := []string{"doc1", "doc2", "doc3"}
+	for i, key := range keys {
+		req := composeFileExportTestReq(key, nil, []byte(fmt.Sprintf(`{"idx":%v}`, i)))
+		req.Seqno = uint64(i + 1)
+		assert.Nil(nozzle.writeRecord(req))
+	}
+	assert.Nil(nozzle.flush())
+	fileName := nozzle.file.Name()
+	nozzle.closeFile()
+
+	lines, err := readFileExportTestLines(fileName)
+	assert.Nil(err)
+	assert.Len(lines, len(keys))
+	for i, line := range lines {
+		record := &base.FileExportRecord{}
+		assert.Nil(json.Unmarshal([]byte(line), record))
+		assert.Equal(keys[i], record.Key)
+		assert.Equal(uint64(i+1), record.Seqno)
+		assert.Equal(base.FileExportOpMutation, record.Op)
+		assert.Equal(json.RawMessage(fmt.Sprintf(`{"idx":%v}`, i)), record.Doc)
+	}
+}
+
+func TestFileExportNozzleSyncsBeforeDataSent(t *testing.T) {
+	fmt.Println("============== Test case start: TestFileExportNozzleSyncsBeforeDataSent =================")
+	defer fmt.Println("============== Test case end: TestFileExportNozzleSyncsBeforeDataSent =================")
+	assert := assert.New(t)
+
+	nozzle, listener, dir := setupFileExportNozzle(assert)
+	defer os.RemoveAll(dir)
+	defer nozzle.closeFile()
+
+	for i := 1; i <= 3; i++ {
+		req := composeFileExportTestReq(fmt.Sprintf("doc%v", i), nil, []byte(`{"a":1}`))
+		req.Seqno = uint64(i)
+		assert.Nil(nozzle.writeRecord(req))
+	}
+
+	// written records are buffered and not reported as sent
+	assert.Len(listener.sentSeqnos, 0)
+	lines, err := readFileExportTestLines(nozzle.file.Name())
+	assert.Nil(err)
+	assert.Len(lines, 0)
+	assert.Len(nozzle.pendingReqs, 3)
+
+	// every record is on disk by the time any of them is reported as sent
+	assert.Nil(nozzle.flush())
+	assert.Equal([]uint64{1, 2, 3}, listener.sentSeqnos)
+	assert.Equal([]int{3, 3, 3}, listener.linesAtEvent)
+	assert.Len(nozzle.pendingReqs, 0)
+	assert.Equal(uint64(3), nozzle.counter_written)
+
+	// nothing is reported again on the next flush
+	req := composeFileExportTestReq("doc4", nil, []byte(`{"a":1}`))
+	req.Seqno = 4
+	assert.Nil(nozzle.writeRecord(req))
+	assert.Nil(nozzle.flush())
+	assert.Equal([]uint64{1, 2, 3, 4}, listener.sentSeqnos)
+	assert.Equal(4, listener.linesAtEvent[3])
+}
+
+func TestFileExportNozzleRotatesFiles(t *testing.T) {
+	fmt.Println("============== Test case start: TestFileExportNozzleRotatesFiles =================")
+	defer fmt.Println("============== Test case end: TestFileExportNozzleRotatesFiles =================")
+	assert := assert.New(t)
+
+	nozzle, listener, dir := setupFileExportNozzle(assert)
+	defer os.RemoveAll(dir)
+	// any flushed write fills up a file
+	nozzle.config.maxFileSize = 1
+
+	for i := 1; i <= 2; i++ {
+		req := composeFileExportTestReq(fmt.Sprintf("doc%v", i), nil, []byte(`{"a":1}`))
+		req.Seqno = uint64(i)
+		assert.Nil(nozzle.writeRecord(req))
+		assert.Nil(nozzle.flush())
+	}
+	nozzle.closeFile()
+
+	assert.Equal([]uint64{1, 2}, listener.sentSeqnos)
+	// a new file is opened after each flush that fills up the current one
+	assert.Equal(uint64(3), nozzle.counter_files)
+	fileNames, err := filepath.Glob(filepath.Join(dir, "*"+base.FileExportFileSuffix))
+	assert.Nil(err)
+	assert.Len(fileNames, 3)
+
+	// the file names sort in the order they were written to
+	sort.Strings(fileNames)
+	for i, expectedKeys := range [][]string{{"doc1"}, {"doc2"}, nil} {
+		lines, err := readFileExportTestLines(fileNames[i])
+		assert.Nil(err)
+		assert.Len(lines, len(expectedKeys))
+		for j, line := range lines {
+			record := &base.FileExportRecord{}
+			assert.Nil(json.Unmarshal([]byte(line), record))
+			assert.Equal(expectedKeys[j], record.Key)
+		}
+	}
+}
@@ -0,0 +1,593 @@
+// Copyright 2013-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included in
+// the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+// file, in accordance with the Business Source License, use of this software
+// will be governed by the Apache License, Version 2.0, included in the file
+// licenses/APL2.txt.
+
+package parts
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mc "github.com/couchbase/gomemcached"
+	base "github.com/couchbase/goxdcr/base"
+	common "github.com/couchbase/goxdcr/common"
+	"github.com/couchbase/goxdcr/log"
+	"github.com/couchbase/goxdcr/metadata"
+)
+
+var ErrorFileExportReplayCustomCR = errors.New("Replaying an archive into a bucket with custom conflict resolution is not supported")
+
+/************************************
+/* struct FileExportArchivePosition
+*************************************/
+// FileExportArchivePosition identifies a record in an archive written by file export nozzles
+// Line is the number of records in File that come before the record, i.e., {File, 0} is the first record of File
+// The zero value is the beginning of the archive
+type FileExportArchivePosition struct {
+	File string
+	Line int
+}
+
+func (pos FileExportArchivePosition) String() string {
+	if pos.File == "" {
+		return ""
+	}
+	return fmt.Sprintf("%v%v%v", pos.File, base.FileExportPositionDelimiter, pos.Line)
+}
+
+// Parses a position printed by FileExportArchivePosition.String(). An empty string is the beginning of the archive
+func ParseFileExportArchivePosition(posStr string) (FileExportArchivePosition, error) {
+	var pos FileExportArchivePosition
+	if posStr == "" {
+		return pos, nil
+	}
+	idx := strings.LastIndex(posStr, base.FileExportPositionDelimiter)
+	if idx <= 0 {
+		return pos, fmt.Errorf("Invalid archive position %v. It should be in the form of <file>%v<line>", posStr, base.FileExportPositionDelimiter)
+	}
+	line, err := strconv.Atoi(posStr[idx+1:])
+	if err != nil || line < 0 {
+		return pos, fmt.Errorf("Invalid line in archive position %v", posStr)
+	}
+	pos.File = posStr[:idx]
+	pos.Line = line
+	return pos, nil
+}
+
+/************************************
+/* struct FileExportArchiveReader
+*************************************/
+// FileExportArchiveReader reads the records in an archive directory one file at a time, in the order of the nozzle
+// start time in the file names, then the file name. A VB is only written to by one nozzle of a pipeline at a time,
+// and a pipeline starts its nozzles after the previous ones have stopped, so the records of each VB are read in
+// seqno order, give or take the records that are sent again after a pipeline restart.
+// Records are not merged across files by (vb, seqno). The order of an archive that is put together from
+// the directories of several nodes therefore also depends on the clocks of the nodes being in sync
+type FileExportArchiveReader struct {
+	dir     string
+	files   []string
+	fileIdx int
+	file    *os.File
+	reader  *bufio.Reader
+	// position of the next record to be returned
+	pos FileExportArchivePosition
+
+	logger *log.CommonLogger
+}
+
+func NewFileExportArchiveReader(dir string, from FileExportArchivePosition, logger *log.CommonLogger) (*FileExportArchiveReader, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+base.FileExportFileSuffix))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("No archive files found in %v", dir)
+	}
+	files := make([]string, 0, len(paths))
+	startTimes := make(map[string]int64)
+	for _, path := range paths {
+		file := filepath.Base(path)
+		startTime, err := parseFileExportFileStartTime(file)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+		startTimes[file] = startTime
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if startTimes[files[i]] != startTimes[files[j]] {
+			return startTimes[files[i]] < startTimes[files[j]]
+		}
+		return files[i] < files[j]
+	})
+
+	reader := &FileExportArchiveReader{
+		dir:     dir,
+		files:   files,
+		fileIdx: -1,
+		logger:  logger,
+	}
+
+	if from.File == "" {
+		return reader, reader.openNextFile()
+	}
+
+	if _, exists := startTimes[from.File]; !exists {
+		return nil, fmt.Errorf("Archive file %v from position %v does not exist in %v", from.File, from, dir)
+	}
+	var idx int
+	for idx = range files {
+		if files[idx] == from.File {
+			break
+		}
+	}
+	reader.fileIdx = idx - 1
+	err = reader.openNextFile()
+	if err != nil {
+		return nil, err
+	}
+	for reader.pos.Line < from.Line {
+		_, err = reader.readLine()
+		if err == io.EOF {
+			return nil, fmt.Errorf("Archive file %v has fewer than %v records", from.File, from.Line)
+		} else if err != nil {
+			return nil, err
+		}
+		reader.pos.Line++
+	}
+	return reader, nil
+}
+
+// File names are in the form of <nozzle ID>_<nozzle start time>_<sequence number><suffix>, as composed by FileExportNozzle.openNextFile()
+func parseFileExportFileStartTime(file string) (int64, error) {
+	fields := strings.Split(strings.TrimSuffix(file, base.FileExportFileSuffix), "_")
+	if len(fields) < 3 {
+		return 0, fmt.Errorf("%v is not an archive file written by file export", file)
+	}
+	startTime, err := strconv.ParseInt(fields[len(fields)-2], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%v is not an archive file written by file export. err=%v", file, err)
+	}
+	return startTime, nil
+}
+
+// Returns the next record and its position, or io.EOF once all the files have been read
+func (reader *FileExportArchiveReader) Next() (*base.FileExportRecord, FileExportArchivePosition, error) {
+	for {
+		if reader.file == nil {
+			return nil, reader.pos, io.EOF
+		}
+		line, err := reader.readLine()
+		if err == io.EOF {
+			err = reader.openNextFile()
+			if err != nil {
+				return nil, reader.pos, err
+			}
+			continue
+		} else if err != nil {
+			return nil, reader.pos, err
+		}
+
+		recordPos := reader.pos
+		reader.pos.Line++
+		record := &base.FileExportRecord{}
+		err = json.Unmarshal(line, record)
+		if err != nil {
+			return nil, recordPos, fmt.Errorf("Unable to parse record at %v. err=%v", recordPos, err)
+		}
+		return record, recordPos, nil
+	}
+}
+
+// The position of the next record to be returned by Next()
+func (reader *FileExportArchiveReader) Position() FileExportArchivePosition {
+	return reader.pos
+}
+
+func (reader *FileExportArchiveReader) Close() {
+	if reader.file != nil {
+		reader.file.Close()
+		reader.file = nil
+	}
+}
+
+// A file export nozzle that was killed in the middle of a write leaves behind a partial last line.
+// The records in it were never reported as sent, so they are in a later file, and the partial line is skipped
+func (reader *FileExportArchiveReader) readLine() ([]byte, error) {
+	line, err := reader.reader.ReadBytes('\n')
+	if err == io.EOF {
+		if len(line) > 0 {
+			reader.logger.Warnf("Skipping incomplete last record of archive file %v", reader.pos.File)
+		}
+		return nil, io.EOF
+	}
+	return line, err
+}
+
+func (reader *FileExportArchiveReader) openNextFile() error {
+	reader.Close()
+	reader.fileIdx++
+	if reader.fileIdx >= len(reader.files) {
+		return nil
+	}
+	file, err := os.Open(filepath.Join(reader.dir, reader.files[reader.fileIdx]))
+	if err != nil {
+		return err
+	}
+	reader.file = file
+	reader.reader = bufio.NewReader(file)
+	reader.pos = FileExportArchivePosition{File: reader.files[reader.fileIdx]}
+	return nil
+}
+
+// Reverses FileExportNozzle.composeRecord. The request is composed the same way as the router composes it
+// from a DCP mutation, so that xmem does the same conflict resolution for it as for a replicated mutation
+// colInfo is nil when the target does not support collections
+// The record carries the source VB, which is only valid on the target when both buckets have the same number of VBs,
+// so the request is sent to the VB that KV clients hash the key to on the target instead
+func ComposeMCRequestFromFileExportRecord(record *base.FileExportRecord, crMode base.ConflictResolutionMode, numOfTargetVbs int, colInfo *base.TargetCollectionInfo) (*base.WrappedMCRequest, error) {
+	if len(record.Key) == 0 {
+		return nil, fmt.Errorf("Record with seqno %v of vb %v has an empty key", record.Seqno, record.VBucket)
+	}
+
+	req := &mc.MCRequest{
+		Cas:      record.Cas,
+		VBucket:  base.GetVBucketForKey([]byte(record.Key), numOfTargetVbs),
+		Key:      []byte(record.Key),
+		DataType: record.DataType &^ (base.SnappyDataType | base.XattrDataType),
+	}
+	switch record.Op {
+	case base.FileExportOpMutation:
+		req.Opcode = mc.UPR_MUTATION
+	case base.FileExportOpDeletion:
+		req.Opcode = mc.UPR_DELETION
+	case base.FileExportOpExpiration:
+		req.Opcode = mc.UPR_EXPIRATION
+	default:
+		return nil, fmt.Errorf("Record with seqno %v of vb %v has unknown op %v", record.Seqno, record.VBucket, record.Op)
+	}
+
+	var doc []byte = record.Doc
+	if len(doc) == 0 {
+		doc = record.DocBinary
+	}
+	if len(record.Xattrs) > 0 {
+		// compose the xattr section in the order of the xattr names so that replaying a record always produces the same body
+		xattrKeys := make([]string, 0, len(record.Xattrs))
+		size := 4 + len(doc)
+		for key, value := range record.Xattrs {
+			xattrKeys = append(xattrKeys, key)
+			size += 4 + len(key) + len(value) + 2
+		}
+		sort.Strings(xattrKeys)
+		composer := base.NewXattrComposer(make([]byte, size))
+		for _, key := range xattrKeys {
+			err := composer.WriteKV([]byte(key), []byte(record.Xattrs[key]))
+			if err != nil {
+				return nil, err
+			}
+		}
+		req.Body = composer.FinishAndAppendDocValue(doc)
+		req.DataType |= base.XattrDataType
+	} else {
+		req.Body = doc
+	}
+
+	extrasSize := 24
+	if crMode == base.CRMode_LWW || req.Opcode == mc.UPR_EXPIRATION {
+		extrasSize = 28
+	}
+	req.Extras = make([]byte, extrasSize)
+	binary.BigEndian.PutUint32(req.Extras[0:4], record.Flags)
+	binary.BigEndian.PutUint32(req.Extras[4:8], record.Expiry)
+	binary.BigEndian.PutUint64(req.Extras[8:16], record.RevSeq)
+	binary.BigEndian.PutUint64(req.Extras[16:24], record.Cas)
+	var options uint32
+	if crMode == base.CRMode_LWW {
+		options |= base.FORCE_ACCEPT_WITH_META_OPS
+	}
+	if req.Opcode == mc.UPR_EXPIRATION {
+		options |= base.IS_EXPIRATION
+	}
+	if options > 0 {
+		binary.BigEndian.PutUint32(req.Extras[24:28], options)
+	}
+
+	wrappedReq := &base.WrappedMCRequest{
+		Seqno:      record.Seqno,
+		Req:        req,
+		Start_time: time.Now(),
+	}
+	if record.SourceNamespace != "" {
+		srcNamespace, err := base.NewCollectionNamespaceFromString(record.SourceNamespace)
+		if err != nil {
+			return nil, err
+		}
+		wrappedReq.SrcColNamespace = &srcNamespace
+	}
+
+	if colInfo != nil {
+		// Embed collection ID in the beginning of the key, as the router does
+		leb128Cid, leb128CidLen, err := base.NewUleb128(colInfo.ColId, nil /*dataSliceGetter*/, true /*truncate*/)
+		if err != nil {
+			return nil, fmt.Errorf("LEB encoding for collection ID %v error: %v", colInfo.ColId, err)
+		}
+		colInfo.ColIDPrefixedKeyLen = leb128CidLen + len(req.Key)
+		colInfo.ColIDPrefixedKey = make([]byte, 0, colInfo.ColIDPrefixedKeyLen)
+		colInfo.ColIDPrefixedKey = append(colInfo.ColIDPrefixedKey, leb128Cid...)
+		colInfo.ColIDPrefixedKey = append(colInfo.ColIDPrefixedKey, req.Key...)
+		req.Key = colInfo.ColIDPrefixedKey
+		wrappedReq.ColInfo = colInfo
+	}
+	req.Keylen = len(req.Key)
+
+	wrappedReq.ConstructUniqueKey()
+	return wrappedReq, nil
+}
+
+/************************************
+/* struct FileExportReplayer
+*************************************/
+// FileExportReplayer pushes the records of an archive to a target bucket through xmem nozzles, so that
+// the records go through the same setMeta and conflict resolution path as replicated mutations.
+// Records are sent in chunks. The position after a chunk is only reported once the target has processed
+// every record of the chunk, so replaying from a reported position never skips a record
+type FileExportReplayer struct {
+	id     string
+	reader *FileExportArchiveReader
+	// vbno -> the out nozzle responsible for the vb on the target
+	vbNozzleMap    map[uint16]common.Nozzle
+	numOfTargetVbs int
+	crMode         base.ConflictResolutionMode
+	// nil when the target does not support collections, in which case only records of the default collection can be replayed
+	targetManifest *metadata.CollectionsManifest
+	// target namespaces to replay, either scope.collection or a whole scope. Empty means all of them
+	namespaceFilter map[string]bool
+	// target namespaces not found in the target manifest, only accessed by the Run routine
+	missingNamespaces map[string]bool
+
+	inflight sync.WaitGroup
+	errCh    chan error
+
+	counter_read     uint64
+	counter_filtered uint64
+	counter_replayed uint64
+	counter_failed   uint64
+
+	logger *log.CommonLogger
+}
+
+// The out nozzles are wired up to the replayer here and are to be started by the caller before calling Run()
+func NewFileExportReplayer(id string, reader *FileExportArchiveReader, vbNozzleMap map[uint16]common.Nozzle, crMode base.ConflictResolutionMode,
+	targetManifest *metadata.CollectionsManifest, namespaceFilter []string, logger *log.CommonLogger) (*FileExportReplayer, error) {
+	if crMode == base.CRMode_Custom {
+		return nil, ErrorFileExportReplayCustomCR
+	}
+	numOfTargetVbs := len(vbNozzleMap)
+	if numOfTargetVbs == 0 || numOfTargetVbs&(numOfTargetVbs-1) != 0 {
+		return nil, fmt.Errorf("%v out nozzles cover %v vbs, which is not all the vbs of the target bucket", id, numOfTargetVbs)
+	}
+
+	replayer := &FileExportReplayer{
+		id:                id,
+		reader:            reader,
+		vbNozzleMap:       vbNozzleMap,
+		numOfTargetVbs:    numOfTargetVbs,
+		crMode:            crMode,
+		targetManifest:    targetManifest,
+		namespaceFilter:   make(map[string]bool),
+		missingNamespaces: make(map[string]bool),
+		errCh:             make(chan error, 1),
+		logger:            logger,
+	}
+	for _, namespace := range namespaceFilter {
+		replayer.namespaceFilter[namespace] = true
+	}
+
+	wiredNozzles := make(map[string]bool)
+	for _, nozzle := range vbNozzleMap {
+		if wiredNozzles[nozzle.Id()] {
+			continue
+		}
+		wiredNozzles[nozzle.Id()] = true
+		outNozzle, ok := nozzle.(common.OutNozzle)
+		if !ok {
+			return nil, fmt.Errorf("%v is not an out nozzle", nozzle.Id())
+		}
+		outNozzle.SetUpstreamObjRecycler(replayer.recordReplayed)
+		outNozzle.SetUpstreamErrReporter(replayer.recordFailed)
+		nozzle.RegisterComponentEventListener(common.ErrorEncountered, replayer)
+		nozzle.RegisterComponentEventListener(common.VBErrorEncountered, replayer)
+	}
+	return replayer, nil
+}
+
+func (replayer *FileExportReplayer) Id() string {
+	return replayer.id
+}
+
+// Any error raised by an out nozzle aborts the replay
+func (replayer *FileExportReplayer) OnEvent(event *common.Event) {
+	var err error
+	switch event.EventType {
+	case common.ErrorEncountered:
+		err, _ = event.OtherInfos.(error)
+	case common.VBErrorEncountered:
+		if vbErr, ok := event.OtherInfos.(*base.VBErrorEventAdditional); ok {
+			err = fmt.Errorf("vb %v: %v", vbErr.Vbno, vbErr.Error)
+		}
+	}
+	if err == nil {
+		err = fmt.Errorf("%v received event %v from %v", replayer.id, event.EventType, event.Component.Id())
+	}
+	select {
+	case replayer.errCh <- err:
+	default:
+		// an error is already pending
+	}
+}
+
+// Called by the out nozzles once they are done with a request, whether it was sent or lost conflict resolution
+func (replayer *FileExportReplayer) recordReplayed(obj interface{}) {
+	atomic.AddUint64(&replayer.counter_replayed, 1)
+	replayer.inflight.Done()
+}
+
+// Called by the out nozzles when the target does not know the collection of a request
+func (replayer *FileExportReplayer) recordFailed(obj interface{}) {
+	atomic.AddUint64(&replayer.counter_failed, 1)
+	replayer.inflight.Done()
+}
+
+// Replays the archive until the end, calling progress with the resume position after each chunk.
+// Returns the position to resume from, which is the end of the archive when there is no error
+func (replayer *FileExportReplayer) Run(progress func(pos FileExportArchivePosition)) (FileExportArchivePosition, error) {
+	defer replayer.reader.Close()
+
+	for {
+		pos := replayer.reader.Position()
+		done, err := replayer.sendChunk()
+		if err == nil {
+			err = replayer.waitForChunk()
+		}
+		if err != nil {
+			replayer.logger.Errorf("%v aborted. Resume position is %v. err=%v", replayer.id, pos, err)
+			return pos, err
+		}
+
+		pos = replayer.reader.Position()
+		if progress != nil {
+			progress(pos)
+		}
+		if done {
+			replayer.PrintStatusSummary()
+			return pos, nil
+		}
+	}
+}
+
+// Returns true if the end of the archive has been reached
+func (replayer *FileExportReplayer) sendChunk() (bool, error) {
+	for count := 0; count < base.FileExportReplayChunkSize; {
+		select {
+		case err := <-replayer.errCh:
+			return false, err
+		default:
+		}
+
+		record, recordPos, err := replayer.reader.Next()
+		if err == io.EOF {
+			return true, nil
+		} else if err != nil {
+			return false, err
+		}
+		atomic.AddUint64(&replayer.counter_read, 1)
+
+		if !replayer.namespaceMatches(record.TargetNamespace) {
+			atomic.AddUint64(&replayer.counter_filtered, 1)
+			continue
+		}
+
+		colInfo, skip, err := replayer.getTargetColInfo(record.TargetNamespace)
+		if err != nil {
+			return false, fmt.Errorf("Invalid target namespace in record at %v. err=%v", recordPos, err)
+		}
+		if skip {
+			atomic.AddUint64(&replayer.counter_failed, 1)
+			continue
+		}
+
+		req, err := ComposeMCRequestFromFileExportRecord(record, replayer.crMode, replayer.numOfTargetVbs, colInfo)
+		if err != nil {
+			return false, fmt.Errorf("Unable to compose request for record at %v. err=%v", recordPos, err)
+		}
+
+		nozzle, ok := replayer.vbNozzleMap[req.Req.VBucket]
+		if !ok {
+			return false, fmt.Errorf("No out nozzle for vb %v of record at %v", req.Req.VBucket, recordPos)
+		}
+		replayer.inflight.Add(1)
+		err = nozzle.Receive(req)
+		if err != nil {
+			return false, err
+		}
+		count++
+	}
+	return false, nil
+}
+
+func (replayer *FileExportReplayer) waitForChunk() error {
+	chunkDone := make(chan bool)
+	go func() {
+		replayer.inflight.Wait()
+		close(chunkDone)
+	}()
+
+	select {
+	case <-chunkDone:
+		return nil
+	case err := <-replayer.errCh:
+		return err
+	}
+}
+
+func (replayer *FileExportReplayer) namespaceMatches(namespace string) bool {
+	if len(replayer.namespaceFilter) == 0 || replayer.namespaceFilter[namespace] {
+		return true
+	}
+	scopeName := strings.SplitN(namespace, base.ScopeCollectionDelimiter, 2)[0]
+	return replayer.namespaceFilter[scopeName]
+}
+
+// Returns skip as true, and logs once per namespace, for records whose namespace does not exist on the target
+// colInfo is nil for records of the default collection when the target does not support collections
+func (replayer *FileExportReplayer) getTargetColInfo(namespaceStr string) (colInfo *base.TargetCollectionInfo, skip bool, err error) {
+	namespace, err := base.NewCollectionNamespaceFromString(namespaceStr)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if replayer.targetManifest == nil {
+		if namespace.IsDefault() {
+			return nil, false, nil
+		}
+		replayer.recordMissingNamespace(namespaceStr, "target does not support collections")
+		return nil, true, nil
+	}
+
+	colId, err := replayer.targetManifest.GetCollectionId(namespace.ScopeName, namespace.CollectionName)
+	if err != nil {
+		replayer.recordMissingNamespace(namespaceStr, err.Error())
+		return nil, true, nil
+	}
+	return &base.TargetCollectionInfo{ManifestId: replayer.targetManifest.Uid(), ColId: colId}, false, nil
+}
+
+func (replayer *FileExportReplayer) recordMissingNamespace(namespace, reason string) {
+	if !replayer.missingNamespaces[namespace] {
+		replayer.missingNamespaces[namespace] = true
+		replayer.logger.Warnf("%v skipping records of %v since it cannot be replayed to the target: %v", replayer.id, namespace, reason)
+	}
+}
+
+func (replayer *FileExportReplayer) PrintStatusSummary() {
+	replayer.logger.Infof("%v read %v records, filtered %v, replayed %v, failed %v. position=%v",
+		replayer.id, atomic.LoadUint64(&replayer.counter_read), atomic.LoadUint64(&replayer.counter_filtered),
+		atomic.LoadUint64(&replayer.counter_replayed), atomic.LoadUint64(&replayer.counter_failed), replayer.reader.Position())
+}
@@ -0,0 +1,223 @@
+/*
+Copyright 2026-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software
+will be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package parts
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	mc "github.com/couchbase/gomemcached"
+	base "github.com/couchbase/goxdcr/base"
+	"github.com/couchbase/goxdcr/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func composeFileExportTestReq(key string, xattrs map[string]string, doc []byte) *base.WrappedMCRequest {
+	body := doc
+	dataType := uint8(base.JSONDataType)
+	if len(xattrs) > 0 {
+		size := 4 + len(doc)
+		for k, v := range xattrs {
+			size += 4 + len(k) + len(v) + 2
+		}
+		composer := base.NewXattrComposer(make([]byte, size))
+		for k, v := range xattrs {
+			composer.WriteKV([]byte(k), []byte(v))
+		}
+		body = composer.FinishAndAppendDocValue(doc)
+		dataType |= base.XattrDataType
+	}
+
+	req := &mc.MCRequest{
+		Opcode:   mc.UPR_MUTATION,
+		Cas:      1234,
+		VBucket:  12,
+		Key:      []byte(key),
+		Body:     body,
+		DataType: dataType,
+		Extras:   make([]byte, 24),
+	}
+	binary.BigEndian.PutUint32(req.Extras[0:4], 5)
+	binary.BigEndian.PutUint32(req.Extras[4:8], 0)
+	binary.BigEndian.PutUint64(req.Extras[8:16], 7)
+	binary.BigEndian.PutUint64(req.Extras[16:24], 1234)
+	return &base.WrappedMCRequest{Seqno: 99, Req: req}
+}
+
+func TestFileExportRecordRoundTrip(t *testing.T) {
+	fmt.Println("============== Test case start: TestFileExportRecordRoundTrip =================")
+	defer fmt.Println("============== Test case end: TestFileExportRecordRoundTrip =================")
+	assert := assert.New(t)
+
+	nozzle := NewFileExportNozzle("fileExportUnitTest", "topic", "", nil, log.DefaultLoggerContext, nil, nil)
+	xattrs := map[string]string{"_sync": `{"rev":"1-abc"}`, "app": `{"a":1}`}
+	doc := []byte(`{"name":"doc1"}`)
+	origReq := composeFileExportTestReq("doc1", xattrs, doc)
+
+	record, err := nozzle.composeRecord(origReq)
+	assert.Nil(err)
+	assert.Equal("doc1", record.Key)
+	assert.Equal(base.FileExportOpMutation, record.Op)
+	assert.Equal(uint64(7), record.RevSeq)
+	assert.Equal(uint32(5), record.Flags)
+	assert.Equal(xattrs, record.Xattrs)
+	assert.Equal(base.DefaultCollectionNamespace.ToIndexString(), record.TargetNamespace)
+
+	// go through the JSON encoding, as the archive does
+	recordBytes, err := json.Marshal(record)
+	assert.Nil(err)
+	readRecord := &base.FileExportRecord{}
+	assert.Nil(json.Unmarshal(recordBytes, readRecord))
+
+	replayReq, err := ComposeMCRequestFromFileExportRecord(readRecord, base.CRMode_RevId, 1024, nil)
+	assert.Nil(err)
+	assert.Equal(mc.UPR_MUTATION, replayReq.Req.Opcode)
+	assert.Equal(origReq.Req.Key, replayReq.Req.Key)
+	assert.Equal(origReq.Req.Cas, replayReq.Req.Cas)
+	assert.Equal(origReq.Req.Extras, replayReq.Req.Extras)
+	assert.Equal(origReq.Seqno, replayReq.Seqno)
+	assert.True(replayReq.Req.DataType&base.XattrDataType > 0)
+	body, err := base.StripXattrAndGetBody(replayReq.Req.Body)
+	assert.Nil(err)
+	assert.Equal(doc, body)
+	assert.Equal(base.GetVBucketForKey([]byte("doc1"), 1024), replayReq.Req.VBucket)
+
+	// the target vb is derived from the key, whatever the number of source vbs was
+	replayReq, err = ComposeMCRequestFromFileExportRecord(readRecord, base.CRMode_RevId, 64, nil)
+	assert.Nil(err)
+	assert.Equal(base.GetVBucketForKey([]byte("doc1"), 64), replayReq.Req.VBucket)
+
+	// an expiration replayed into a LWW bucket carries the options
+	readRecord.Op = base.FileExportOpExpiration
+	replayReq, err = ComposeMCRequestFromFileExportRecord(readRecord, base.CRMode_LWW, 1024, nil)
+	assert.Nil(err)
+	assert.Equal(28, len(replayReq.Req.Extras))
+	options := binary.BigEndian.Uint32(replayReq.Req.Extras[24:28])
+	assert.True(options&base.IS_EXPIRATION > 0)
+	assert.True(options&base.FORCE_ACCEPT_WITH_META_OPS > 0)
+
+	// collection ID is prefixed to the key, and the plain key can be retrieved back
+	replayReq, err = ComposeMCRequestFromFileExportRecord(readRecord, base.CRMode_RevId, 1024, &base.TargetCollectionInfo{ManifestId: 3, ColId: 8})
+	assert.Nil(err)
+	assert.Equal(byte(8), replayReq.Req.Key[0])
+	assert.Equal([]byte("doc1"), replayReq.GetPlainKey())
+	assert.Equal(uint64(3), replayReq.GetManifestId())
+
+	readRecord.Op = "unknown"
+	_, err = ComposeMCRequestFromFileExportRecord(readRecord, base.CRMode_RevId, 1024, nil)
+	assert.NotNil(err)
+}
+
+func writeFileExportTestFile(assert *assert.Assertions, dir, name string, keys []string, partialLine bool) {
+	file, err := os.Create(filepath.Join(dir, name))
+	assert.Nil(err)
+	defer file.Close()
+	for _, key := range keys {
+		recordBytes, err := json.Marshal(&base.FileExportRecord{Key: key, Op: base.FileExportOpMutation})
+		assert.Nil(err)
+		_, err = file.Write(append(recordBytes, '\n'))
+		assert.Nil(err)
+	}
+	if partialLine {
+		_, err = file.Write([]byte(`{"key":"partial`))
+		assert.Nil(err)
+	}
+}
+
+func readAllFileExportKeys(assert *assert.Assertions, reader *FileExportArchiveReader) []string {
+	var keys []string
+	for {
+		record, _, err := reader.Next()
+		if err == io.EOF {
+			return keys
+		}
+		assert.Nil(err)
+		keys = append(keys, record.Key)
+	}
+}
+
+func TestFileExportArchiveReader(t *testing.T) {
+	fmt.Println("============== Test case start: TestFileExportArchiveReader =================")
+	defer fmt.Println("============== Test case end: TestFileExportArchiveReader =================")
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "fileExportArchive")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	logger := log.NewLogger("fileExportUnitTest", log.DefaultLoggerContext)
+
+	_, err = NewFileExportArchiveReader(dir, FileExportArchivePosition{}, logger)
+	assert.NotNil(err)
+
+	writeFileExportTestFile(assert, dir, "file_a_1_000001"+base.FileExportFileSuffix, []string{"k1", "k2", "k3"}, false)
+	writeFileExportTestFile(assert, dir, "file_a_1_000002"+base.FileExportFileSuffix, []string{"k4", "k5"}, true)
+
+	reader, err := NewFileExportArchiveReader(dir, FileExportArchivePosition{}, logger)
+	assert.Nil(err)
+	assert.Equal([]string{"k1", "k2", "k3", "k4", "k5"}, readAllFileExportKeys(assert, reader))
+	reader.Close()
+
+	pos, err := ParseFileExportArchivePosition("file_a_1_000001" + base.FileExportFileSuffix + base.FileExportPositionDelimiter + "2")
+	assert.Nil(err)
+	reader, err = NewFileExportArchiveReader(dir, pos, logger)
+	assert.Nil(err)
+	assert.Equal(pos, reader.Position())
+	record, recordPos, err := reader.Next()
+	assert.Nil(err)
+	assert.Equal("k3", record.Key)
+	assert.Equal(pos, recordPos)
+	assert.Equal("file_a_1_000001"+base.FileExportFileSuffix+base.FileExportPositionDelimiter+"3", reader.Position().String())
+	assert.Equal([]string{"k4", "k5"}, readAllFileExportKeys(assert, reader))
+	reader.Close()
+
+	_, err = NewFileExportArchiveReader(dir, FileExportArchivePosition{File: "file_a_1_000001" + base.FileExportFileSuffix, Line: 4}, logger)
+	assert.NotNil(err)
+	_, err = NewFileExportArchiveReader(dir, FileExportArchivePosition{File: "nonexistent" + base.FileExportFileSuffix}, logger)
+	assert.NotNil(err)
+	_, err = ParseFileExportArchivePosition("noLine")
+	assert.NotNil(err)
+}
+
+func TestFileExportArchiveReaderOrdersByStartTime(t *testing.T) {
+	fmt.Println("============== Test case start: TestFileExportArchiveReaderOrdersByStartTime =================")
+	defer fmt.Println("============== Test case end: TestFileExportArchiveReaderOrdersByStartTime =================")
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "fileExportArchive")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	logger := log.NewLogger("fileExportUnitTest", log.DefaultLoggerContext)
+
+	// a vb that moved from nozzle b to nozzle a when the pipeline restarted is read in the order it was written
+	writeFileExportTestFile(assert, dir, "xmem_b_100_000001"+base.FileExportFileSuffix, []string{"k1", "k2"}, false)
+	writeFileExportTestFile(assert, dir, "xmem_b_100_000002"+base.FileExportFileSuffix, []string{"k3"}, false)
+	writeFileExportTestFile(assert, dir, "xmem_a_200_000001"+base.FileExportFileSuffix, []string{"k4"}, false)
+
+	reader, err := NewFileExportArchiveReader(dir, FileExportArchivePosition{}, logger)
+	assert.Nil(err)
+	assert.Equal([]string{"k1", "k2", "k3", "k4"}, readAllFileExportKeys(assert, reader))
+	reader.Close()
+
+	reader, err = NewFileExportArchiveReader(dir, FileExportArchivePosition{File: "xmem_b_100_000002" + base.FileExportFileSuffix}, logger)
+	assert.Nil(err)
+	assert.Equal([]string{"k3", "k4"}, readAllFileExportKeys(assert, reader))
+	reader.Close()
+
+	writeFileExportTestFile(assert, dir, "notAnArchive"+base.FileExportFileSuffix, []string{"k5"}, false)
+	_, err = NewFileExportArchiveReader(dir, FileExportArchivePosition{}, logger)
+	assert.NotNil(err)
+}
@@ -3,9 +3,7 @@
 package mocks
 
 import (
-	base "github.com/couchbase/goxdcr/base"
-	log "github.com/couchbase/goxdcr/log"
-
+	parts "github.com/couchbase/goxdcr/parts"
 	mock "github.com/stretchr/testify/mock"
 )
 
@@ -14,16 +12,58 @@ type ConflictResolver struct {
 	mock.Mock
 }
 
-// Execute provides a mock function with given fields: doc_metadata_source, doc_metadata_target, source_cr_mode, xattrEnabled, logger
-func (_m *ConflictResolver) Execute(doc_metadata_source parts.documentMetadata, doc_metadata_target parts.documentMetadata, source_cr_mode base.ConflictResolutionMode, xattrEnabled bool, logger *log.CommonLogger) bool {
-	ret := _m.Called(doc_metadata_source, doc_metadata_target, source_cr_mode, xattrEnabled, logger)
+// Name provides a mock function with given fields:
+func (_m *ConflictResolver) Name() string {
+	ret := _m.Called()
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func() string); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	return r0
+}
+
+// Resolve provides a mock function with given fields: source, target, xattrEnabled
+func (_m *ConflictResolver) Resolve(source *parts.ConflictResolverDoc, target *parts.ConflictResolverDoc, xattrEnabled bool) bool {
+	ret := _m.Called(source, target, xattrEnabled)
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func(*parts.ConflictResolverDoc, *parts.ConflictResolverDoc, bool) bool); ok {
+		r0 = rf(source, target, xattrEnabled)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	return r0
+}
+
+// SkipTargetCR provides a mock function with given fields:
+func (_m *ConflictResolver) SkipTargetCR() bool {
+	ret := _m.Called()
 
 	var r0 bool
-	if rf, ok := ret.Get(0).(func(parts.documentMetadata, parts.documentMetadata, base.ConflictResolutionMode, bool, *log.CommonLogger) bool); ok {
-		r0 = rf(doc_metadata_source, doc_metadata_target, source_cr_mode, xattrEnabled, logger)
+	if rf, ok := ret.Get(0).(func() bool); ok {
+		r0 = rf()
 	} else {
 		r0 = ret.Get(0).(bool)
 	}
 
 	return r0
 }
+
+// TargetLookup provides a mock function with given fields:
+func (_m *ConflictResolver) TargetLookup() parts.ConflictResolverLookup {
+	ret := _m.Called()
+
+	var r0 parts.ConflictResolverLookup
+	if rf, ok := ret.Get(0).(func() parts.ConflictResolverLookup); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(parts.ConflictResolverLookup)
+	}
+
+	return r0
+}
@@ -111,6 +111,25 @@ type documentMetadata struct {
 	dataType uint8 // item data type
 }
 
+func (doc_meta documentMetadata) toConflictDocMeta() base.ConflictDocMeta {
+	return base.ConflictDocMeta{
+		Cas:      doc_meta.cas,
+		RevSeq:   doc_meta.revSeq,
+		Flags:    doc_meta.flags,
+		Expiry:   doc_meta.expiry,
+		Deletion: doc_meta.deletion,
+		DataType: doc_meta.dataType,
+	}
+}
+
+func (doc_meta documentMetadata) toConflictResolverDoc(body []byte) *ConflictResolverDoc {
+	return &ConflictResolverDoc{
+		Key:             doc_meta.key,
+		ConflictDocMeta: doc_meta.toConflictDocMeta(),
+		Body:            body,
+	}
+}
+
 func (doc_meta documentMetadata) String() string {
 	return fmt.Sprintf("[key=%s; revSeq=%v;cas=%v;flags=%v;expiry=%v;deletion=%v:datatype=%v]", doc_meta.key, doc_meta.revSeq, doc_meta.cas, doc_meta.flags, doc_meta.expiry, doc_meta.deletion, doc_meta.dataType)
 }
@@ -156,6 +175,10 @@ type DataFailedCRSourceEventAdditional struct {
 	IsExpirySet bool
 	VBucket     uint16
 	ManifestId  uint64
+	SourceCas   uint64
+	// For per collection stats. Nil if unknown
+	SourceNamespace *base.CollectionNamespace
+	TargetNamespace *base.CollectionNamespace
 }
 
 type TargetDataSkippedEventAdditional DataFailedCRSourceEventAdditional
@@ -170,12 +193,38 @@ type DataSentEventAdditional struct {
 	VBucket        uint16
 	Req_size       int
 	ManifestId     uint64
+	SourceCas      uint64
+	// For per collection stats. Nil if unknown
+	SourceNamespace *base.CollectionNamespace
+	TargetNamespace *base.CollectionNamespace
 }
 
 type DataFilteredAdditional struct {
 	Key        string
 	Seqno      uint64
 	ManifestId uint64
+	// For per collection stats. Nil if unknown
+	SourceNamespace *base.CollectionNamespace
+}
+
+// Returns the source and target namespace of a routed request, for per collection stats
+func getStatsNamespaces(req *base.WrappedMCRequest) (source, target *base.CollectionNamespace) {
+	source = req.GetSourceCollectionNamespace()
+	req.ColInfoMtx.RLock()
+	if req.ColInfo != nil {
+		target = req.ColInfo.TargetNamespace
+	}
+	req.ColInfoMtx.RUnlock()
+	return
+}
+
+// The source mutation cas is carried in the extras of *_WITH_META requests
+// Req.Cas cannot be used since it may be overwritten before the request is sent
+func getSourceCas(req *mc.MCRequest) uint64 {
+	if len(req.Extras) >= 24 {
+		return binary.BigEndian.Uint64(req.Extras[16:24])
+	}
+	return req.Cas
 }
 
 type SentCasChangedEventAdditional struct {
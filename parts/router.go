@@ -24,6 +24,7 @@ import (
 	mcc "github.com/couchbase/gomemcached/client"
 	"github.com/couchbase/goxdcr/base"
 	baseFilter "github.com/couchbase/goxdcr/base/filter"
+	"github.com/couchbase/goxdcr/base/transform"
 	common "github.com/couchbase/goxdcr/common"
 	connector "github.com/couchbase/goxdcr/connector"
 	"github.com/couchbase/goxdcr/log"
@@ -124,6 +125,16 @@ type Router struct {
 	stopped         uint32
 	finCh           chan bool
 
+	// nil when the replication has no transformation rules
+	transformer *transform.Transformer
+	// nil when the replication has no target key rewriting rules. When the keys are rewritten, the routing map
+	// covers every target VB and the rewritten keys are routed by the target VB that they hash to
+	keyRewriter    *transform.KeyRewriter
+	numOfTargetVbs int
+	// nil unless conflicts are logged to a collection in the source bucket. The conflict log documents are
+	// never replicated, otherwise each logged conflict would be replicated as a new mutation
+	conflictLogNamespace *base.CollectionNamespace
+
 	throughputThrottlerSvc service_def.ThroughputThrottlerSvc
 	// whether the current replication is a high priority replication
 	// when Priority or Ongoing setting is changed, this field will be updated through UpdateSettings() call
@@ -470,7 +481,7 @@ func (c *CollectionsRouter) UpdateBrokenMappingsPair(brokenMappingsRO *metadata.
 }
 
 // No-Concurrent call
-func (c *CollectionsRouter) RouteReqToLatestTargetManifest(wrappedMCReq *base.WrappedMCRequest, eventForMigration *base.WrappedUprEvent) (colIds []uint32, manifestId uint64, backfillPersistHadErr bool, unmappedNamespaces metadata.CollectionNamespaceMapping, migrationColIdNsMap map[uint32]*base.CollectionNamespace, err error) {
+func (c *CollectionsRouter) RouteReqToLatestTargetManifest(wrappedMCReq *base.WrappedMCRequest, eventForMigration *base.WrappedUprEvent) (colIds []uint32, manifestId uint64, backfillPersistHadErr bool, unmappedNamespaces metadata.CollectionNamespaceMapping, colIdNsMap map[uint32]*base.CollectionNamespace, err error) {
 	if !c.IsRunning() {
 		err = PartStoppedError
 		return
@@ -537,7 +548,7 @@ func (c *CollectionsRouter) RouteReqToLatestTargetManifest(wrappedMCReq *base.Wr
 		manifestId, colId, err = c.implicitMap(namespace, latestTargetManifest)
 		colIds = append(colIds, colId)
 	} else {
-		manifestId, colIds, unmappedNamespaces, migrationColIdNsMap, err = c.explicitMap(wrappedMCReq, latestTargetManifest, eventForMigration)
+		manifestId, colIds, unmappedNamespaces, colIdNsMap, err = c.explicitMap(wrappedMCReq, latestTargetManifest, eventForMigration)
 	}
 	return
 }
@@ -629,7 +640,7 @@ func (c *CollectionsRouter) implicitMap(namespace *base.CollectionNamespace, lat
 	return manifestId, colId, err
 }
 
-func (c *CollectionsRouter) explicitMap(wrappedMCReq *base.WrappedMCRequest, latestTargetManifest *metadata.CollectionsManifest, eventForMigration *base.WrappedUprEvent) (manifestId uint64, colIds []uint32, unmappedNamespaces metadata.CollectionNamespaceMapping, migrationColIdNsMap map[uint32]*base.CollectionNamespace, err error) {
+func (c *CollectionsRouter) explicitMap(wrappedMCReq *base.WrappedMCRequest, latestTargetManifest *metadata.CollectionsManifest, eventForMigration *base.WrappedUprEvent) (manifestId uint64, colIds []uint32, unmappedNamespaces metadata.CollectionNamespaceMapping, colIdNsMap map[uint32]*base.CollectionNamespace, err error) {
 	srcNamespace := wrappedMCReq.GetSourceCollectionNamespace()
 	manifestId = latestTargetManifest.Uid()
 	var matchedNamespaces metadata.CollectionNamespaceMapping
@@ -639,9 +650,9 @@ func (c *CollectionsRouter) explicitMap(wrappedMCReq *base.WrappedMCRequest, lat
 		var tgtCollections metadata.CollectionNamespaceList
 		tgtCollections, err = c.regularExplicitMap(srcNamespace)
 		if err != nil {
-			return 0, []uint32{0}, unmappedNamespaces, migrationColIdNsMap, err
+			return 0, []uint32{0}, unmappedNamespaces, colIdNsMap, err
 		} else if len(tgtCollections) == 0 {
-			return 0, []uint32{0}, unmappedNamespaces, migrationColIdNsMap, base.ErrorIgnoreRequest
+			return 0, []uint32{0}, unmappedNamespaces, colIdNsMap, base.ErrorIgnoreRequest
 		}
 		matchedNamespaces = make(metadata.CollectionNamespaceMapping)
 		matchedNamespaces[metadata.NewSourceCollectionNamespace(srcNamespace)] = tgtCollections
@@ -656,7 +667,7 @@ func (c *CollectionsRouter) explicitMap(wrappedMCReq *base.WrappedMCRequest, lat
 				}
 			}
 			if len(errMap) == 1 && invalidInput {
-				return 0, []uint32{0}, unmappedNamespaces, migrationColIdNsMap, base.ErrorInvalidInput
+				return 0, []uint32{0}, unmappedNamespaces, colIdNsMap, base.ErrorInvalidInput
 			}
 			// At this point, means at least 1 match failure
 			var unableToFilterCnt int
@@ -694,9 +705,7 @@ func (c *CollectionsRouter) explicitMap(wrappedMCReq *base.WrappedMCRequest, lat
 		err = base.ErrorIgnoreRequest
 	}
 
-	if c.collectionMode.IsMigrationOn() {
-		migrationColIdNsMap = make(map[uint32]*base.CollectionNamespace)
-	}
+	colIdNsMap = make(map[uint32]*base.CollectionNamespace)
 	var colId uint32
 	for sourceNs, tgtCollections := range matchedNamespaces {
 		for _, targetNamespace := range tgtCollections {
@@ -708,9 +717,7 @@ func (c *CollectionsRouter) explicitMap(wrappedMCReq *base.WrappedMCRequest, lat
 				unmappedNamespaces.AddSingleSourceNsMapping(sourceNs, targetNamespace)
 			} else {
 				colIds = append(colIds, colId)
-				if c.collectionMode.IsMigrationOn() {
-					migrationColIdNsMap[colId] = targetNamespace
-				}
+				colIdNsMap[colId] = targetNamespace
 			}
 		}
 	}
@@ -1346,9 +1353,36 @@ func NewRouter(id string, spec *metadata.ReplicationSpecification, downStreamPar
 		filterPrintMsg = fmt.Sprintf("%v%v%v", base.UdTagBegin, filter.GetInternalExpr(), base.UdTagEnd)
 	}
 
+	transformer, err := transform.NewTransformer(spec.Settings.GetTransformationRules())
+	if err != nil {
+		return nil, err
+	}
+
+	keyRewriter, err := transform.NewKeyRewriter(spec.Settings.GetTargetKeyRewriteRules())
+	if err != nil {
+		return nil, err
+	}
+	numOfTargetVbs := len(routingMap)
+	if keyRewriter != nil && (numOfTargetVbs == 0 || numOfTargetVbs&(numOfTargetVbs-1) != 0) {
+		return nil, fmt.Errorf("%v routing map with %v vbs does not cover all the target vbs needed for target key rewriting", id, numOfTargetVbs)
+	}
+
+	var conflictLogNamespace *base.CollectionNamespace
+	if spec.Settings.GetConflictLoggingEnabled() && spec.Settings.GetConflictLoggingDest() != base.ConflictLoggingDestFile {
+		namespace, err := base.NewCollectionNamespaceFromString(spec.Settings.GetConflictLoggingDest())
+		if err != nil {
+			return nil, err
+		}
+		conflictLogNamespace = &namespace
+	}
+
 	router := &Router{
 		id:                       id,
 		filter:                   filter,
+		transformer:              transformer,
+		keyRewriter:              keyRewriter,
+		numOfTargetVbs:           numOfTargetVbs,
+		conflictLogNamespace:     conflictLogNamespace,
 		routingMap:               routingMap,
 		topic:                    topic,
 		sourceCRMode:             sourceCRMode,
@@ -1415,7 +1449,8 @@ func NewRouter(id string, spec *metadata.ReplicationSpecification, downStreamPar
 	}
 
 	ignoreRequestFunc := func(req *base.WrappedMCRequest) {
-		ignoreEvent := common.NewEvent(common.DataNotReplicated, req, router, nil, utilities.RecycleObjFunc(router.recycleDataObj))
+		// req may be recycled by the time the event is handled, so pass its namespace along for per collection stats
+		ignoreEvent := common.NewEvent(common.DataNotReplicated, req, router, []interface{}{nil, req.GetSourceCollectionNamespace()}, utilities.RecycleObjFunc(router.recycleDataObj))
 		router.RaiseEvent(ignoreEvent)
 	}
 
@@ -1450,6 +1485,19 @@ func (router *Router) ComposeMCRequest(wrappedEvent *base.WrappedUprEvent) (*bas
 	req.Opaque = 0
 	req.VBucket = event.VBucket
 	req.Key = event.Key
+	if router.keyRewriter != nil && (event.Opcode == mc.UPR_MUTATION || event.Opcode == mc.UPR_DELETION || event.Opcode == mc.UPR_EXPIRATION) {
+		req.Key, err = router.keyRewriter.Rewrite(event.Key)
+		if err != nil {
+			router.Logger().Debugf("%v unable to rewrite key %v%v%v", router.id, base.UdTagBegin, string(event.Key), base.UdTagEnd)
+			return nil, err
+		}
+		// the target VB is the one that KV clients hash the rewritten key to. The checkpoints and the through seqnos
+		// are still tracked against the source VB
+		wrapped_req.KeyRewritten = true
+		wrapped_req.SrcVBucket = event.VBucket
+		wrapped_req.SrcKey = event.Key
+		req.VBucket = base.GetVBucketForKey(req.Key, router.numOfTargetVbs)
+	}
 	if wrappedEvent.Flags.ShouldUseDecompressedValue() {
 		// The decompresedValue will get recycled before this mcRequest is passed down to Xmem
 		// Copy the data to another recycled slice
@@ -1475,6 +1523,12 @@ func (router *Router) ComposeMCRequest(wrappedEvent *base.WrappedUprEvent) (*bas
 	} else {
 		req.Body = event.Value
 	}
+	if router.transformer != nil && event.Opcode == mc.UPR_MUTATION && event.DataType&mcc.JSONDataType > 0 {
+		req.Body, err = router.transformBody(wrappedEvent)
+		if err != nil {
+			return nil, err
+		}
+	}
 	//opCode
 	req.Opcode = event.Opcode
 	req.DataType = event.DataType
@@ -1538,6 +1592,50 @@ func (router *Router) ComposeMCRequest(wrappedEvent *base.WrappedUprEvent) (*bas
 	return wrapped_req, nil
 }
 
+// Applies the transformation rules to the document body and leaves the xattrs as they are.
+// The transformed value is compressed again if DCP sent it compressed
+func (router *Router) transformBody(wrappedEvent *base.WrappedUprEvent) ([]byte, error) {
+	event := wrappedEvent.UprEvent
+	value := event.Value
+	var err error
+	if wrappedEvent.Flags.ShouldUseDecompressedValue() {
+		// filter has already decompressed the value and stripped the transaction xattrs
+		value = wrappedEvent.DecompressedValue
+	} else if event.IsSnappyDataType() {
+		value, err = snappy.Decode(nil, event.Value)
+		if err != nil {
+			router.Logger().Debugf("%v unable to decompress document %v%v%v for transformation. err=%v", router.id, base.UdTagBegin, string(event.Key), base.UdTagEnd, err)
+			return nil, base.ErrorTransformationFailed
+		}
+	}
+
+	var xattrs []byte
+	body := value
+	if event.DataType&mcc.XattrDataType > 0 {
+		xattrSize, err := base.GetXattrSize(value)
+		if err != nil || int(xattrSize)+4 > len(value) {
+			router.Logger().Debugf("%v unable to parse xattrs of document %v%v%v for transformation. err=%v", router.id, base.UdTagBegin, string(event.Key), base.UdTagEnd, err)
+			return nil, base.ErrorTransformationFailed
+		}
+		xattrs = value[:xattrSize+4]
+		body = value[xattrSize+4:]
+	}
+
+	transformedBody, err := router.transformer.Transform(body)
+	if err != nil {
+		router.Logger().Debugf("%v unable to transform document %v%v%v. err=%v", router.id, base.UdTagBegin, string(event.Key), base.UdTagEnd, err)
+		return nil, base.ErrorTransformationFailed
+	}
+	transformed := make([]byte, 0, len(xattrs)+len(transformedBody))
+	transformed = append(transformed, xattrs...)
+	transformed = append(transformed, transformedBody...)
+
+	if event.IsSnappyDataType() {
+		return snappy.Encode(nil, transformed), nil
+	}
+	return transformed, nil
+}
+
 // These Start() and Stop() operations are not subjected to wait timeout executors
 // So they should not block and the operations underneath should be innocuous if not
 // stopped in time
@@ -1575,12 +1673,13 @@ func (router *Router) Stop() error {
 	}
 }
 
-func (router *Router) getDataFilteredAdditional(uprEvent *mcc.UprEvent) interface{} {
-	return DataFilteredAdditional{Seqno: uprEvent.Seqno,
+func (router *Router) getDataFilteredAdditional(wrappedUpr *base.WrappedUprEvent) interface{} {
+	return DataFilteredAdditional{Seqno: wrappedUpr.UprEvent.Seqno,
 		// For filtered document, send the latestSuccessfulManifestId
 		// so that the throughSeqno service can get the most updated manifestId
 		// for checkpointing if there is an overwhelming number of filtered docs
-		ManifestId: atomic.LoadUint64(&router.lastSuccessfulManifestId),
+		ManifestId:      atomic.LoadUint64(&router.lastSuccessfulManifestId),
+		SourceNamespace: wrappedUpr.ColNamespace,
 	}
 }
 
@@ -1609,9 +1708,11 @@ func (router *Router) Route(data interface{}) (map[string]interface{}, error) {
 		return nil, ErrorInvalidRoutingMapForRouter
 	}
 
+	router.copyUprEventToStripExpiry(wrappedUpr)
+	uprEvent = wrappedUpr.UprEvent
 	shouldContinue := router.ProcessExpDelTTL(uprEvent)
-	if !shouldContinue {
-		router.RaiseEvent(common.NewEvent(common.DataFiltered, uprEvent, router, nil, router.getDataFilteredAdditional(uprEvent)))
+	if !shouldContinue || router.isConflictLogDoc(wrappedUpr) {
+		router.RaiseEvent(common.NewEvent(common.DataFiltered, uprEvent, router, nil, router.getDataFilteredAdditional(wrappedUpr)))
 		return result, nil
 	}
 
@@ -1626,10 +1727,10 @@ func (router *Router) Route(data interface{}) (map[string]interface{}, error) {
 	if !needToReplicate || err != nil {
 		if err != nil {
 			// Let pipeline supervisor do the logging
-			router.RaiseEvent(common.NewEvent(common.DataUnableToFilter, uprEvent, router, []interface{}{err, errDesc}, nil))
+			router.RaiseEvent(common.NewEvent(common.DataUnableToFilter, uprEvent, router, []interface{}{err, errDesc, wrappedUpr.ColNamespace}, nil))
 		} else {
 			// if data does not need to be replicated, drop it. return empty result
-			router.RaiseEvent(common.NewEvent(common.DataFiltered, uprEvent, router, nil, router.getDataFilteredAdditional(uprEvent)))
+			router.RaiseEvent(common.NewEvent(common.DataFiltered, uprEvent, router, nil, router.getDataFilteredAdditional(wrappedUpr)))
 		}
 		// Let supervisor set the err instead of the router, to minimize pipeline interruption
 		return result, nil
@@ -1642,16 +1743,34 @@ func (router *Router) Route(data interface{}) (map[string]interface{}, error) {
 	}
 
 	mcRequest, err := router.ComposeMCRequest(wrappedUpr)
+	if err == base.ErrorTransformationFailed {
+		// Documents are not replicated without the fields that the transformation rules remove or mask
+		errDesc := fmt.Sprintf("For document %v%v%v Unable to apply transformation rules", base.UdTagBegin, string(uprEvent.Key), base.UdTagEnd)
+		router.RaiseEvent(common.NewEvent(common.DataUnableToFilter, uprEvent, router, []interface{}{err, errDesc, wrappedUpr.ColNamespace}, nil))
+		return result, nil
+	}
+	if err == base.ErrorKeyRewriteFailed {
+		// Documents are not replicated under keys that may collide with the keys of other source buckets
+		errDesc := fmt.Sprintf("For document %v%v%v Unable to apply target key rewriting rules", base.UdTagBegin, string(uprEvent.Key), base.UdTagEnd)
+		router.RaiseEvent(common.NewEvent(common.DataUnableToFilter, uprEvent, router, []interface{}{err, errDesc, wrappedUpr.ColNamespace}, nil))
+		return result, nil
+	}
 	if err != nil {
 		return nil, router.utils.NewEnhancedError("Error creating new memcached request.", err)
 	}
 	if mcRequest == nil {
 		return nil, fmt.Errorf("Unable to create new mcRequest")
 	}
+	if mcRequest.KeyRewritten {
+		partId, ok = router.routingMap[mcRequest.Req.VBucket]
+		if !ok {
+			return nil, ErrorInvalidRoutingMapForRouter
+		}
+	}
 
 	if raiseDataNotReplicated {
 		err := fmt.Errorf("collection ID %v no longer exists, so the data is not to be replicated", wrappedUpr.UprEvent.CollectionId)
-		router.RaiseEvent(common.NewEvent(common.DataNotReplicated, mcRequest, router, []interface{}{err}, nil))
+		router.RaiseEvent(common.NewEvent(common.DataNotReplicated, mcRequest, router, []interface{}{err, wrappedUpr.ColNamespace}, nil))
 		return result, nil
 	}
 
@@ -1668,6 +1787,11 @@ func (router *Router) Route(data interface{}) (map[string]interface{}, error) {
 	return result, nil
 }
 
+func (router *Router) isConflictLogDoc(wrappedUpr *base.WrappedUprEvent) bool {
+	return router.conflictLogNamespace != nil && wrappedUpr.ColNamespace != nil &&
+		wrappedUpr.ColNamespace.IsSameAs(*router.conflictLogNamespace)
+}
+
 func (router *Router) RouteCollection(data interface{}, partId string, origUprEvent *base.WrappedUprEvent) error {
 	if !router.remoteClusterCapability.HasCollectionSupport() {
 		// collections is not being used
@@ -1684,7 +1808,7 @@ func (router *Router) RouteCollection(data interface{}, partId string, origUprEv
 	var manifestId uint64
 	var err error
 	var unmappedNamespaces metadata.CollectionNamespaceMapping
-	var migrationColIdNamespaceMap map[uint32]*base.CollectionNamespace
+	var colIdNamespaceMap map[uint32]*base.CollectionNamespace
 
 	collectionMode := router.collectionModes.Get()
 	if collectionMode.IsImplicitMapping() &&
@@ -1692,7 +1816,7 @@ func (router *Router) RouteCollection(data interface{}, partId string, origUprEv
 		colIds = append(colIds, 0)
 	} else {
 		var backfillPersistHadErr bool
-		colIds, manifestId, backfillPersistHadErr, unmappedNamespaces, migrationColIdNamespaceMap, err = router.collectionsRouting[partId].RouteReqToLatestTargetManifest(mcRequest, origUprEvent)
+		colIds, manifestId, backfillPersistHadErr, unmappedNamespaces, colIdNamespaceMap, err = router.collectionsRouting[partId].RouteReqToLatestTargetManifest(mcRequest, origUprEvent)
 		// If backfillPersistHadErr is set, then it is:
 		// 1. Safe to re-raise routingUpdate, as each routingUpdate will try to persist again
 		// 2. NOT safe to ignore data - as ignoring data means throughSeqno will move forward
@@ -1725,7 +1849,7 @@ func (router *Router) RouteCollection(data interface{}, partId string, origUprEv
 		}
 	}
 
-	err = router.prepareMcRequest(colIds, mcRequest, origUprEvent, migrationColIdNamespaceMap)
+	err = router.prepareMcRequest(colIds, mcRequest, origUprEvent, colIdNamespaceMap)
 	if err != nil {
 		return err
 	}
@@ -1737,7 +1861,8 @@ func (router *Router) RouteCollection(data interface{}, partId string, origUprEv
 // Sets up the unique Key
 // If a request is meant to replicate to >1 target collections, prepare the sibling requests as well
 // and notify throughSeqnoTracker svc that there are sibling requests coming for this given source seqno
-func (router *Router) prepareMcRequest(colIds []uint32, firstReq *base.WrappedMCRequest, origUprEvent *base.WrappedUprEvent, migrationNamespaceMap map[uint32]*base.CollectionNamespace) error {
+// colIdNsMap is nil for implicit mapping, where each request is replicated to the namespace of the same name
+func (router *Router) prepareMcRequest(colIds []uint32, firstReq *base.WrappedMCRequest, origUprEvent *base.WrappedUprEvent, colIdNsMap map[uint32]*base.CollectionNamespace) error {
 	var err error
 	for i, colId := range colIds {
 		var reqToProcess *base.WrappedMCRequest
@@ -1779,19 +1904,22 @@ func (router *Router) prepareMcRequest(colIds []uint32, firstReq *base.WrappedMC
 		reqToProcess.Req.Key = reqToProcess.ColInfo.ColIDPrefixedKey[0:totalLen]
 		reqToProcess.Req.Keylen = totalLen
 
-		if migrationNamespaceMap != nil {
-			reqToProcess.ColInfo.TargetNamespace = migrationNamespaceMap[colId]
+		if colIdNsMap != nil {
+			reqToProcess.ColInfo.TargetNamespace = colIdNsMap[colId]
+		} else {
+			reqToProcess.ColInfo.TargetNamespace = reqToProcess.GetSourceCollectionNamespace()
 		}
 		reqToProcess.ColInfoMtx.Unlock()
 	}
 	if len(colIds) > 1 {
-		vbno := firstReq.Req.VBucket
+		vbno := firstReq.GetSourceVBucket()
 		seqno := firstReq.Seqno
 		totalInstances := len(colIds)
 		var data []interface{}
 		data = append(data, vbno)
 		data = append(data, seqno)
 		data = append(data, totalInstances)
+		data = append(data, firstReq.GetSourceCollectionNamespace())
 
 		router.RaiseEvent(common.NewEvent(common.DataCloned, data, router, nil, nil))
 	}
@@ -1948,6 +2076,16 @@ func (router *Router) newWrappedMCRequest() (*base.WrappedMCRequest, error) {
 	return newReq, nil
 }
 
+// The source event may be shared with the other pipelines that read from the same source stream, so the expiry is
+// stripped from a copy that only this pipeline uses
+func (router *Router) copyUprEventToStripExpiry(wrappedUpr *base.WrappedUprEvent) {
+	if router.expDelMode.Get()&base.FilterExpDelStripExpiration == 0 || wrappedUpr.UprEvent.Expiry == 0 {
+		return
+	}
+	uprEventCopy := *wrappedUpr.UprEvent
+	wrappedUpr.UprEvent = &uprEventCopy
+}
+
 // Returns bool indicating if the data should continue to be sent
 func (router *Router) ProcessExpDelTTL(uprEvent *mcc.UprEvent) bool {
 	expDelMode := router.expDelMode.Get()
@@ -19,12 +19,14 @@ import (
 	"github.com/couchbase/gomemcached"
 	mcc "github.com/couchbase/gomemcached/client"
 	"github.com/couchbase/goxdcr/base"
+	"github.com/couchbase/goxdcr/base/transform"
 	"github.com/couchbase/goxdcr/common"
 	"github.com/couchbase/goxdcr/log"
 	"github.com/couchbase/goxdcr/metadata"
 	service_def_mocks "github.com/couchbase/goxdcr/service_def/mocks"
 	utilities "github.com/couchbase/goxdcr/utils"
 	UtilitiesMock "github.com/couchbase/goxdcr/utils/mocks"
+	"github.com/golang/snappy"
 	"github.com/stretchr/testify/assert"
 	mock "github.com/stretchr/testify/mock"
 	"io/ioutil"
@@ -319,9 +321,13 @@ func TestRouterExpDelAllMode(t *testing.T) {
 	assert.NotNil(expEvent)
 
 	assert.NotEqual(0, int(mutEvent.Expiry))
-	shouldContinue := router.ProcessExpDelTTL(mutEvent)
+	// the event that the source stream hands out keeps its expiry
+	wrappedEvent := &base.WrappedUprEvent{UprEvent: mutEvent}
+	router.copyUprEventToStripExpiry(wrappedEvent)
+	shouldContinue := router.ProcessExpDelTTL(wrappedEvent.UprEvent)
 	assert.True(shouldContinue)
-	assert.Equal(0, int(mutEvent.Expiry))
+	assert.Equal(0, int(wrappedEvent.UprEvent.Expiry))
+	assert.NotEqual(0, int(mutEvent.Expiry))
 
 	shouldContinue = router.ProcessExpDelTTL(expEvent)
 	assert.False(shouldContinue)
@@ -943,3 +949,109 @@ func TestRouterRaceyBrokenMapIdle(t *testing.T) {
 	assert.Equal(0, len(collectionsRouter.brokenMapping))
 	assert.Nil(collectionsRouter.brokenMapDblChkKicker)
 }
+
+func TestRouterTransformation(t *testing.T) {
+	fmt.Println("============== Test case start: TestRouterTransformation =================")
+	defer fmt.Println("============== Test case end: TestRouterTransformation =================")
+	assert := assert.New(t)
+
+	routerId, downStreamParts, routingMap, crMode, loggerCtx, utilsMock, throughputThrottlerSvc, needToThrottle, expDelMode, collectionsManifestSvc, spec, recycler, connectivityStatus := setupBoilerPlateRouter()
+	spec.Settings.Values[metadata.TransformationRulesKey] = `{"remove":["ssn"],"mask":["phone"]}`
+	router, err := NewRouter(routerId, spec, downStreamParts, routingMap, crMode, loggerCtx, utilsMock, throughputThrottlerSvc, needToThrottle, expDelMode, collectionsManifestSvc, recycler, nil, nonCollectionsCap, nil, connectivityStatus)
+	assert.Nil(err)
+	assert.NotNil(router.transformer)
+
+	// one xattr _sync with value {} followed by the document body
+	xattrs := []byte{0, 0, 0, 13, 0, 0, 0, 9}
+	xattrs = append(xattrs, []byte("_sync\x00{}\x00")...)
+	value := append(append([]byte{}, xattrs...), []byte(`{"name":"Ann","ssn":"123-45-6789","phone":"555-0100"}`)...)
+	uprEvent := &mcc.UprEvent{
+		Opcode:   gomemcached.UPR_MUTATION,
+		Key:      []byte("doc"),
+		Value:    snappy.Encode(nil, value),
+		DataType: mcc.JSONDataType | mcc.XattrDataType | mcc.SnappyDataType,
+	}
+
+	wrappedMCRequest, err := router.ComposeMCRequest(&base.WrappedUprEvent{UprEvent: uprEvent})
+	assert.Nil(err)
+	// the body is compressed again and the xattrs are left as they are
+	transformed, err := snappy.Decode(nil, wrappedMCRequest.Req.Body)
+	assert.Nil(err)
+	assert.Equal(xattrs, transformed[:len(xattrs)])
+	var doc map[string]interface{}
+	assert.Nil(json.Unmarshal(transformed[len(xattrs):], &doc))
+	assert.Equal(map[string]interface{}{"name": "Ann", "phone": transform.MaskedValue}, doc)
+
+	// documents that cannot be transformed are not replicated
+	uprEvent = &mcc.UprEvent{
+		Opcode:   gomemcached.UPR_MUTATION,
+		Key:      []byte("doc"),
+		Value:    []byte(`["not an object"]`),
+		DataType: mcc.JSONDataType,
+	}
+	_, err = router.ComposeMCRequest(&base.WrappedUprEvent{UprEvent: uprEvent})
+	assert.Equal(base.ErrorTransformationFailed, err)
+}
+
+func TestRouterKeyRewrite(t *testing.T) {
+	fmt.Println("============== Test case start: TestRouterKeyRewrite =================")
+	defer fmt.Println("============== Test case end: TestRouterKeyRewrite =================")
+	assert := assert.New(t)
+
+	routerId, downStreamParts, routingMap, crMode, loggerCtx, utilsMock, throughputThrottlerSvc, needToThrottle, expDelMode, collectionsManifestSvc, spec, recycler, connectivityStatus := setupBoilerPlateRouter()
+	spec.Settings.Values[metadata.TargetKeyAddPrefixKey] = "tenant1::"
+
+	// the routing map has to cover all the target vbs
+	_, err := NewRouter(routerId, spec, downStreamParts, routingMap, crMode, loggerCtx, utilsMock, throughputThrottlerSvc, needToThrottle, expDelMode, collectionsManifestSvc, recycler, nil, nonCollectionsCap, nil, connectivityStatus)
+	assert.NotNil(err)
+
+	for vbno := uint16(0); vbno < 64; vbno++ {
+		routingMap[vbno] = dummyDownStream
+	}
+	router, err := NewRouter(routerId, spec, downStreamParts, routingMap, crMode, loggerCtx, utilsMock, throughputThrottlerSvc, needToThrottle, expDelMode, collectionsManifestSvc, recycler, nil, nonCollectionsCap, nil, connectivityStatus)
+	assert.Nil(err)
+	assert.NotNil(router.keyRewriter)
+
+	uprEvent := &mcc.UprEvent{
+		Opcode:   gomemcached.UPR_MUTATION,
+		VBucket:  5,
+		Seqno:    10,
+		Key:      []byte("doc"),
+		Value:    []byte(`{}`),
+		DataType: mcc.JSONDataType,
+	}
+	wrappedMCRequest, err := router.ComposeMCRequest(&base.WrappedUprEvent{UprEvent: uprEvent})
+	assert.Nil(err)
+	assert.Equal("tenant1::doc", string(wrappedMCRequest.Req.Key))
+	// the request goes to the vb of the rewritten key, and is tracked against the source vb
+	assert.Equal(base.GetVBucketForKey([]byte("tenant1::doc"), 64), wrappedMCRequest.Req.VBucket)
+	assert.True(wrappedMCRequest.KeyRewritten)
+	assert.Equal(uint16(5), wrappedMCRequest.GetSourceVBucket())
+}
+
+func TestRouterSkipsConflictLogCollection(t *testing.T) {
+	fmt.Println("============== Test case start: TestRouterSkipsConflictLogCollection =================")
+	defer fmt.Println("============== Test case end: TestRouterSkipsConflictLogCollection =================")
+	assert := assert.New(t)
+
+	routerId, downStreamParts, routingMap, crMode, loggerCtx, utilsMock, throughputThrottlerSvc, needToThrottle, expDelMode, collectionsManifestSvc, spec, recycler, connectivityStatus := setupBoilerPlateRouter()
+	spec.Settings.Values[metadata.ConflictLoggingKey] = true
+	spec.Settings.Values[metadata.ConflictLoggingDestKey] = "logs.conflicts"
+	router, err := NewRouter(routerId, spec, downStreamParts, routingMap, crMode, loggerCtx, utilsMock, throughputThrottlerSvc, needToThrottle, expDelMode, collectionsManifestSvc, recycler, nil, nonCollectionsCap, nil, connectivityStatus)
+	assert.Nil(err)
+	assert.NotNil(router.conflictLogNamespace)
+
+	uprEvent, err := RetrieveUprFile("./testdata/uprEventDeletion.json")
+	assert.Nil(err)
+	router.routingMap[uprEvent.VBucket] = "dummyPartId"
+
+	logDoc := &base.WrappedUprEvent{UprEvent: uprEvent, ColNamespace: &base.CollectionNamespace{ScopeName: "logs", CollectionName: "conflicts"}}
+	assert.True(router.isConflictLogDoc(logDoc))
+	result, err := router.Route(logDoc)
+	assert.Nil(err)
+	assert.Equal(0, len(result))
+
+	otherDoc := &base.WrappedUprEvent{UprEvent: uprEvent, ColNamespace: &base.CollectionNamespace{ScopeName: "logs", CollectionName: "other"}}
+	assert.False(router.isConflictLogDoc(otherDoc))
+	assert.False(router.isConflictLogDoc(&base.WrappedUprEvent{UprEvent: uprEvent}))
+}
@@ -0,0 +1,293 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software
+will be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package parts
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mc "github.com/couchbase/gomemcached"
+	mcc "github.com/couchbase/gomemcached/client"
+	"github.com/couchbase/goxdcr/base"
+	"github.com/couchbase/goxdcr/log"
+)
+
+var ErrorSharedDcpFeedClosed = errors.New("Shared source stream no longer accepts new readers")
+var ErrorSharedDcpFeedNotReady = errors.New("Shared source stream has not opened all of its vb streams yet")
+
+// The shared source streams that are currently owned by a dcp nozzle
+var sharedDcpFeeds = newSharedDcpFeedRegistry()
+
+type sharedDcpFeedRegistry struct {
+	lock  sync.Mutex
+	feeds map[string]*sharedDcpFeed
+	// dcp nozzle id -> when the nozzle was last detached from a shared source stream
+	detachedTime map[string]time.Time
+}
+
+func newSharedDcpFeedRegistry() *sharedDcpFeedRegistry {
+	return &sharedDcpFeedRegistry{
+		feeds:        make(map[string]*sharedDcpFeed),
+		detachedTime: make(map[string]time.Time),
+	}
+}
+
+// Returns the shared source stream registered under the key, and whether it has just been created for the
+// caller to own
+func (r *sharedDcpFeedRegistry) getOrOwn(key, ownerId string, logger *log.CommonLogger) (*sharedDcpFeed, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if feed, exists := r.feeds[key]; exists {
+		return feed, false
+	}
+	feed := newSharedDcpFeed(key, ownerId, logger)
+	r.feeds[key] = feed
+	return feed, true
+}
+
+func (r *sharedDcpFeedRegistry) release(feed *sharedDcpFeed) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.feeds[feed.key] == feed {
+		delete(r.feeds, feed.key)
+	}
+}
+
+func (r *sharedDcpFeedRegistry) recordDetached(id string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.detachedTime[id] = time.Now()
+}
+
+func (r *sharedDcpFeedRegistry) recentlyDetached(id string) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	detachedTime, exists := r.detachedTime[id]
+	if !exists {
+		return false
+	}
+	if time.Since(detachedTime) > base.SharedDcpFeedRejoinCooldown {
+		delete(r.detachedTime, id)
+		return false
+	}
+	return true
+}
+
+// Only dcp nozzles that stream the same vbs of the same source bucket with the same stream features can share streams
+func sharedDcpFeedKey(sourceBucketName string, vbnos []uint16, compression base.CompressionType, collectionEnabled bool) string {
+	return fmt.Sprintf("%v_%v_%v_%v", sourceBucketName, base.SortUint16List(base.DeepCopyUint16Array(vbnos)), compression, collectionEnabled)
+}
+
+// sharedDcpFeed fans out the events of the vb streams owned by one dcp nozzle to the dcp nozzles of other pipelines.
+// Each reader has its own bounded buffer, and a reader whose buffer is full is detached instead of holding up the
+// owner and the other readers. The events are shared between the pipelines and must not be modified downstream
+type sharedDcpFeed struct {
+	key     string
+	ownerId string
+	logger  *log.CommonLogger
+
+	lock sync.Mutex
+	// vbno -> the response to the stream request of the owner, which carries the failover log of the vb
+	streamStarts map[uint16]*mcc.UprEvent
+	// vbno -> seqno of the last event fanned out, or the start seqno of the stream before any event
+	positions map[uint16]uint64
+	readers   map[string]*sharedDcpFeedReader
+	closed    bool
+
+	// When the owner started forwarding its current mutation in unix nano. 0 when the owner is not forwarding
+	forwardingSince int64
+
+	finch     chan bool
+	closeOnce sync.Once
+}
+
+type sharedDcpFeedReader struct {
+	id      string
+	feed    *sharedDcpFeed
+	eventCh chan *mcc.UprEvent
+	// vbno -> seqno the reader starts from. Events up to it have already been replicated by the reader's pipeline
+	startSeqnos  map[uint16]uint64
+	streamStarts map[uint16]*mcc.UprEvent
+	// set before eventCh is closed when the reader is detached
+	detachErr  error
+	fellBehind bool
+}
+
+func newSharedDcpFeed(key, ownerId string, logger *log.CommonLogger) *sharedDcpFeed {
+	feed := &sharedDcpFeed{
+		key:          key,
+		ownerId:      ownerId,
+		logger:       logger,
+		streamStarts: make(map[uint16]*mcc.UprEvent),
+		positions:    make(map[uint16]uint64),
+		readers:      make(map[string]*sharedDcpFeedReader),
+		finch:        make(chan bool),
+	}
+	go feed.monitorOwner()
+	return feed
+}
+
+func (feed *sharedDcpFeed) recordStreamStart(m *mcc.UprEvent) {
+	feed.lock.Lock()
+	defer feed.lock.Unlock()
+	feed.streamStarts[m.VBucket] = m
+	feed.positions[m.VBucket] = m.Seqno
+}
+
+func (feed *sharedDcpFeed) fanOut(m *mcc.UprEvent) {
+	feed.lock.Lock()
+	defer feed.lock.Unlock()
+	if m.Opcode != mc.UPR_SNAPSHOT {
+		feed.positions[m.VBucket] = m.Seqno
+	}
+	for id, reader := range feed.readers {
+		select {
+		case reader.eventCh <- m:
+		default:
+			reader.fellBehind = true
+			feed.detachNoLock(id, fmt.Errorf("%v: %v fell more than %v events behind", base.ErrorSharedDcpFeedDetached, id, base.SharedDcpFeedBufferSize))
+		}
+	}
+}
+
+// A reader can only be added if it does not need any event that has already been fanned out, and if its start
+// timestamps are still valid on the failover logs of the owner's streams
+func (feed *sharedDcpFeed) addReader(id string, startTs map[uint16]*base.VBTimestamp) (*sharedDcpFeedReader, error) {
+	feed.lock.Lock()
+	defer feed.lock.Unlock()
+	if feed.closed {
+		return nil, ErrorSharedDcpFeedClosed
+	}
+
+	reader := &sharedDcpFeedReader{
+		id:           id,
+		feed:         feed,
+		eventCh:      make(chan *mcc.UprEvent, base.SharedDcpFeedBufferSize),
+		startSeqnos:  make(map[uint16]uint64),
+		streamStarts: make(map[uint16]*mcc.UprEvent),
+	}
+	for vbno, ts := range startTs {
+		streamStart, exists := feed.streamStarts[vbno]
+		if !exists {
+			return nil, ErrorSharedDcpFeedNotReady
+		}
+		if ts.Seqno < feed.positions[vbno] {
+			return nil, fmt.Errorf("vb %v starts from seqno %v but the shared source stream is already at seqno %v", vbno, ts.Seqno, feed.positions[vbno])
+		}
+		if ts.Seqno > 0 && !vbuuidOnFailoverLog(streamStart.FailoverLog, ts.Vbuuid, ts.Seqno) {
+			return nil, fmt.Errorf("vb %v starts from vbuuid %v seqno %v which is not on the failover log of the shared source stream", vbno, ts.Vbuuid, ts.Seqno)
+		}
+		reader.startSeqnos[vbno] = ts.Seqno
+		reader.streamStarts[vbno] = streamStart
+	}
+	feed.readers[id] = reader
+	feed.logger.Infof("%v starts reading from the shared source stream owned by %v", id, feed.ownerId)
+	return reader, nil
+}
+
+// Used by a reader that stops on its own, which no longer reads its event channel
+func (feed *sharedDcpFeed) removeReader(id string) {
+	feed.lock.Lock()
+	defer feed.lock.Unlock()
+	delete(feed.readers, id)
+}
+
+func (feed *sharedDcpFeed) detachAll(err error) {
+	feed.lock.Lock()
+	defer feed.lock.Unlock()
+	for id := range feed.readers {
+		feed.detachNoLock(id, err)
+	}
+}
+
+func (feed *sharedDcpFeed) detachNoLock(id string, err error) {
+	reader, exists := feed.readers[id]
+	if !exists {
+		return
+	}
+	feed.logger.Warnf("Detaching %v from the shared source stream owned by %v. err=%v", id, feed.ownerId, err)
+	reader.detachErr = err
+	close(reader.eventCh)
+	delete(feed.readers, id)
+}
+
+// Detaches all the readers and stops accepting new ones
+func (feed *sharedDcpFeed) stopSharing(err error) {
+	feed.lock.Lock()
+	defer feed.lock.Unlock()
+	feed.closed = true
+	for id := range feed.readers {
+		feed.detachNoLock(id, err)
+	}
+}
+
+// Called when the owner stops
+func (feed *sharedDcpFeed) close(err error) {
+	feed.stopSharing(err)
+	feed.closeOnce.Do(func() {
+		close(feed.finch)
+	})
+}
+
+func (feed *sharedDcpFeed) startForwarding() {
+	atomic.StoreInt64(&feed.forwardingSince, time.Now().UnixNano())
+}
+
+func (feed *sharedDcpFeed) doneForwarding() {
+	atomic.StoreInt64(&feed.forwardingSince, 0)
+}
+
+// A slow target of the owner holds up the owner's streams, so the readers are detached from an owner that is
+// blocked for too long
+func (feed *sharedDcpFeed) monitorOwner() {
+	ticker := time.NewTicker(base.SharedDcpFeedOwnerStallTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-feed.finch:
+			return
+		case <-ticker.C:
+			forwardingSince := atomic.LoadInt64(&feed.forwardingSince)
+			if forwardingSince == 0 || time.Since(time.Unix(0, forwardingSince)) < base.SharedDcpFeedOwnerStallTimeout {
+				continue
+			}
+			feed.stopSharing(fmt.Errorf("%v: owner %v has been blocked for more than %v", base.ErrorSharedDcpFeedDetached, feed.ownerId, base.SharedDcpFeedOwnerStallTimeout))
+			return
+		}
+	}
+}
+
+// Events up to the start seqno of a vb have already been replicated by the reader's pipeline
+func (reader *sharedDcpFeedReader) alreadyReplicated(m *mcc.UprEvent) bool {
+	startSeqno := reader.startSeqnos[m.VBucket]
+	if m.Opcode == mc.UPR_SNAPSHOT {
+		return m.SnapendSeq <= startSeqno
+	}
+	return m.Seqno <= startSeqno
+}
+
+// The failover log is ordered from the latest entry. A vbuuid covers the seqnos from its entry up to the start of
+// the next newer entry
+func vbuuidOnFailoverLog(flog *mcc.FailoverLog, vbuuid, seqno uint64) bool {
+	if flog == nil {
+		return false
+	}
+	for i, entry := range *flog {
+		if entry[0] != vbuuid {
+			continue
+		}
+		return i == 0 || seqno <= (*flog)[i-1][1]
+	}
+	return false
+}
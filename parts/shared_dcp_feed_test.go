@@ -0,0 +1,87 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software
+will be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package parts
+
+import (
+	"fmt"
+	"testing"
+
+	mc "github.com/couchbase/gomemcached"
+	mcc "github.com/couchbase/gomemcached/client"
+	"github.com/couchbase/goxdcr/base"
+	"github.com/couchbase/goxdcr/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVbuuidOnFailoverLog(t *testing.T) {
+	fmt.Println("============== Test case start: TestVbuuidOnFailoverLog =================")
+	defer fmt.Println("============== Test case end: TestVbuuidOnFailoverLog =================")
+	assert := assert.New(t)
+
+	// latest entry first
+	flog := mcc.FailoverLog{{300, 500}, {200, 100}, {100, 0}}
+
+	assert.True(vbuuidOnFailoverLog(&flog, 300, 1000))
+	assert.True(vbuuidOnFailoverLog(&flog, 200, 500))
+	// seqno 501 of vbuuid 200 was never part of the current history
+	assert.False(vbuuidOnFailoverLog(&flog, 200, 501))
+	assert.False(vbuuidOnFailoverLog(&flog, 400, 10))
+	assert.False(vbuuidOnFailoverLog(nil, 300, 10))
+}
+
+func TestSharedDcpFeedReaders(t *testing.T) {
+	fmt.Println("============== Test case start: TestSharedDcpFeedReaders =================")
+	defer fmt.Println("============== Test case end: TestSharedDcpFeedReaders =================")
+	assert := assert.New(t)
+
+	bufferSize := base.SharedDcpFeedBufferSize
+	base.SharedDcpFeedBufferSize = 2
+	defer func() { base.SharedDcpFeedBufferSize = bufferSize }()
+
+	feed := newSharedDcpFeed("key", "owner", log.NewLogger("SharedDcpFeedTest", log.DefaultLoggerContext))
+	defer feed.close(base.ErrorSharedDcpFeedDetached)
+
+	startTs := map[uint16]*base.VBTimestamp{0: {Vbno: 0, Vbuuid: 100, Seqno: 10}}
+
+	_, err := feed.addReader("behind", startTs)
+	assert.Equal(ErrorSharedDcpFeedNotReady, err)
+
+	flog := mcc.FailoverLog{{100, 0}}
+	feed.recordStreamStart(&mcc.UprEvent{VBucket: 0, Seqno: 5, FailoverLog: &flog})
+	feed.fanOut(&mcc.UprEvent{Opcode: mc.UPR_MUTATION, VBucket: 0, Seqno: 11})
+
+	// the mutation at seqno 11 has already been fanned out
+	_, err = feed.addReader("behind", startTs)
+	assert.NotNil(err)
+
+	startTs[0].Seqno = 12
+	reader, err := feed.addReader("reader", startTs)
+	assert.Nil(err)
+	assert.Len(reader.streamStarts, 1)
+
+	feed.fanOut(&mcc.UprEvent{Opcode: mc.UPR_MUTATION, VBucket: 0, Seqno: 12})
+	feed.fanOut(&mcc.UprEvent{Opcode: mc.UPR_MUTATION, VBucket: 0, Seqno: 13})
+	assert.True(reader.alreadyReplicated(<-reader.eventCh))
+	assert.False(reader.alreadyReplicated(<-reader.eventCh))
+
+	// a reader that falls further behind than its buffer is detached without holding up the owner
+	for seqno := uint64(14); seqno < 17; seqno++ {
+		feed.fanOut(&mcc.UprEvent{Opcode: mc.UPR_MUTATION, VBucket: 0, Seqno: seqno})
+	}
+	assert.True(reader.fellBehind)
+	assert.NotNil(reader.detachErr)
+	assert.Len(feed.readers, 0)
+
+	feed.stopSharing(base.ErrorSharedDcpFeedDetached)
+	startTs[0].Seqno = 20
+	_, err = feed.addReader("late", startTs)
+	assert.Equal(ErrorSharedDcpFeedClosed, err)
+}
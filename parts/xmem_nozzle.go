@@ -48,6 +48,7 @@ const (
 	XMEM_SETTING_REMOTE_MEM_SSL_PORT = "remote_ssl_port"
 	XMEM_SETTING_CLIENT_CERTIFICATE  = metadata.XmemClientCertificate
 	XMEM_SETTING_CLIENT_KEY          = metadata.XmemClientKey
+	XMEM_SETTING_APPLY_DELAY         = base.ApplyDelayKey
 
 	default_demandEncryption bool = false
 )
@@ -86,6 +87,7 @@ var xmem_setting_defs base.SettingDefinitions = base.SettingDefinitions{
 	XMEM_SETTING_CERTIFICATE:        base.NewSettingDef(reflect.TypeOf((*[]byte)(nil)), false),
 	XMEM_SETTING_SAN_IN_CERITICATE:  base.NewSettingDef(reflect.TypeOf((*bool)(nil)), false),
 	XMEM_SETTING_INSECURESKIPVERIFY: base.NewSettingDef(reflect.TypeOf((*bool)(nil)), false),
+	XMEM_SETTING_APPLY_DELAY:        base.NewSettingDef(reflect.TypeOf((*time.Duration)(nil)), false),
 	XMEM_DEV_MAIN_SLEEP_DELAY:       base.NewSettingDef(reflect.TypeOf((*int)(nil)), false),
 	XMEM_DEV_BACKFILL_SLEEP_DELAY:   base.NewSettingDef(reflect.TypeOf((*int)(nil)), false),
 }
@@ -101,8 +103,6 @@ var ErrorXmemIsStuck = errors.New("Xmem is stuck")
 
 var ErrorBufferInvalidState = errors.New("xmem buffer is in invalid state")
 
-type ConflictResolver func(doc_metadata_source documentMetadata, doc_metadata_target documentMetadata, source_cr_mode base.ConflictResolutionMode, xattrEnabled bool, logger *log.CommonLogger) bool
-
 var GetMetaClientName = "client_getMeta"
 var SetMetaClientName = "client_setMeta"
 
@@ -429,6 +429,8 @@ type xmemConfig struct {
 	logger                         *log.CommonLogger
 	maxRetryMutationLocked         int
 	maxRetryIntervalMutationLocked time.Duration
+	// mutations are held until their source CAS is at least this old. 0 means no delay
+	applyDelay time.Duration
 }
 
 func newConfig(logger *log.CommonLogger) xmemConfig {
@@ -467,6 +469,9 @@ func (config *xmemConfig) initializeConfig(settings metadata.ReplicationSettings
 
 	if err == nil {
 		config.baseConfig.initializeConfig(settings)
+		if val, ok := settings[XMEM_SETTING_APPLY_DELAY]; ok {
+			config.applyDelay = val.(time.Duration)
+		}
 		if val, ok := settings[XMEM_SETTING_DEMAND_ENCRYPTION]; ok {
 			config.demandEncryption = val.(bool)
 		}
@@ -653,9 +658,14 @@ type XmemNozzle struct {
 
 	bandwidthThrottler service_def.BandwidthThrottlerSvc
 	conflictMgr        service_def.ConflictManagerIface
+	conflictLogger     service_def.ConflictLoggerIface
+	deadLetterQueue    service_def.DeadLetterQueueIface
 	compressionSetting base.CompressionType
 	utils              utilities.UtilsIface
 
+	// holds mutations when the replication has an apply delay. Nil otherwise
+	delayBuffer *delayBuffer
+
 	// Protect data memebers that may be accessed concurrently by Start() and Stop()
 	// i.e., buf, dataChan, client_for_setMeta, client_for_setMeta
 	// Access to these data members in other parts of xmem do not need to be protected,
@@ -728,7 +738,7 @@ func NewXmemNozzle(id string,
 	xmem.last_ten_batches_size = []uint32{0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
 
 	//set conflict resolver
-	xmem.conflict_resolver = resolveConflict
+	xmem.conflict_resolver = NewConflictResolverForCRMode(source_cr_mode)
 
 	xmem.config.connectStr = connectString
 	xmem.config.bucketName = targetBucketName
@@ -748,6 +758,16 @@ func (xmem *XmemNozzle) SetBandwidthThrottler(bandwidthThrottler service_def.Ban
 	xmem.bandwidthThrottler = bandwidthThrottler
 }
 
+// Replaces the resolver of the bucket conflict resolution mode. Needs to be called before the nozzle is started
+// Custom conflict resolution has its own source side conflict resolution, which cannot be replaced
+func (xmem *XmemNozzle) SetConflictResolver(resolver ConflictResolver) error {
+	if xmem.source_cr_mode == base.CRMode_Custom {
+		return fmt.Errorf("%v conflict resolver %v cannot be used with custom conflict resolution", xmem.Id(), resolver.Name())
+	}
+	xmem.conflict_resolver = resolver
+	return nil
+}
+
 func (xmem *XmemNozzle) IsOpen() bool {
 	xmem.lock_bOpen.RLock()
 	defer xmem.lock_bOpen.RUnlock()
@@ -806,6 +826,11 @@ func (xmem *XmemNozzle) Start(settings metadata.ReplicationSettingsMap) error {
 	xmem.childrenWaitGrp.Add(1)
 	go xmem.processData_sendbatch(xmem.finish_ch, &xmem.childrenWaitGrp)
 
+	if xmem.delayBuffer != nil {
+		xmem.childrenWaitGrp.Add(1)
+		go xmem.processDelayedData(xmem.finish_ch, &xmem.childrenWaitGrp)
+	}
+
 	xmem.start_time = time.Now()
 
 	err = xmem.SetState(common.Part_Running)
@@ -888,7 +913,20 @@ func (xmem *XmemNozzle) Receive(data interface{}) error {
 		return err
 	}
 
-	err = xmem.accumuBatch(request)
+	if xmem.delayBuffer != nil {
+		// blocks once the delay buffer is full
+		err = xmem.delayBuffer.add(request)
+		if err != nil && err != PartStoppedError {
+			xmem.handleGeneralError(err)
+		}
+		return err
+	}
+
+	return xmem.accumuBatchWithSiblings(request)
+}
+
+func (xmem *XmemNozzle) accumuBatchWithSiblings(request *base.WrappedMCRequest) error {
+	err := xmem.accumuBatch(request)
 	if err != nil {
 		xmem.handleGeneralError(err)
 	}
@@ -905,6 +943,48 @@ func (xmem *XmemNozzle) Receive(data interface{}) error {
 	return err
 }
 
+// Sends the mutations held in the delay buffer once their source CAS is older than the apply delay.
+// Until then they are not sent, so the through seqno and therefore the checkpoints stay behind them
+func (xmem *XmemNozzle) processDelayedData(finch chan bool, waitGrp *sync.WaitGroup) {
+	defer waitGrp.Done()
+
+	ticker := time.NewTicker(base.DelayBufferReleaseInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-finch:
+			return
+		case <-ticker.C:
+			for {
+				requests, err := xmem.delayBuffer.release(time.Now(), xmem.config.maxCount)
+				for _, request := range requests {
+					// latency is measured from when the mutation is released
+					request.Start_time = time.Now()
+					request.SiblingReqsMtx.RLock()
+					for _, siblingReq := range request.SiblingReqs {
+						siblingReq.Start_time = request.Start_time
+					}
+					request.SiblingReqsMtx.RUnlock()
+
+					if xmem.accumuBatchWithSiblings(request) != nil {
+						return
+					}
+				}
+				if err != nil {
+					if err != PartStoppedError {
+						xmem.handleGeneralError(err)
+					}
+					return
+				}
+				if len(requests) == 0 {
+					break
+				}
+			}
+		}
+	}
+}
+
 func (xmem *XmemNozzle) accumuBatch(request *base.WrappedMCRequest) error {
 	if string(request.Req.Key) == "" {
 		xmem.Logger().Errorf("%v accumuBatch received request with Empty key, req.UniqueKey=%v%s%v\n", xmem.Id(), base.UdTagBegin, request.UniqueKey, base.UdTagEnd)
@@ -925,9 +1005,11 @@ func (xmem *XmemNozzle) accumuBatch(request *base.WrappedMCRequest) error {
 		additionalInfo := TargetDataSkippedEventAdditional{Seqno: request.Seqno,
 			Opcode:      encodeOpCode(request.Req, xmem.source_cr_mode == base.CRMode_Custom),
 			IsExpirySet: (binary.BigEndian.Uint32(request.Req.Extras[4:8]) != 0),
-			VBucket:     request.Req.VBucket,
+			VBucket:     request.GetSourceVBucket(),
 			ManifestId:  request.GetManifestId(),
+			SourceCas:   getSourceCas(request.Req),
 		}
+		additionalInfo.SourceNamespace, additionalInfo.TargetNamespace = getStatsNamespaces(request)
 		xmem.RaiseEvent(common.NewEvent(common.TargetDataSkipped, nil, xmem, nil, additionalInfo))
 		xmem.recycleDataObj(request)
 		atomic.AddUint64(&xmem.counter_from_target, 1)
@@ -1027,7 +1109,15 @@ func (xmem *XmemNozzle) processData_sendbatch(finch chan bool, waitGrp *sync.Wai
 			}
 
 			var noRep_map map[string]NeedSendStatus
-			if xmem.source_cr_mode != base.CRMode_Custom {
+			if xmem.source_cr_mode != base.CRMode_Custom && xmem.conflict_resolver.TargetLookup() == CRLookupDoc {
+				noRep_map, err = xmem.batchGetDocForConflictResolver(batch.getMeta_map)
+				if err == PartStoppedError {
+					goto done
+				} else if err != nil {
+					xmem.handleGeneralError(err)
+				}
+				batch.bigDoc_noRep_map = noRep_map
+			} else if xmem.source_cr_mode != base.CRMode_Custom {
 				//batch get meta to find what needs to be not sent via the noRep map
 				noRep_map, err = xmem.batchGetMeta(batch.getMeta_map)
 				if err != nil {
@@ -1111,6 +1201,11 @@ func (xmem *XmemNozzle) onExit() {
 		buf.close()
 	}
 
+	if xmem.delayBuffer != nil {
+		// unblocks Receive if it is waiting for room in the delay buffer
+		xmem.delayBuffer.close()
+	}
+
 	//notify the data processing routine
 	close(xmem.finish_ch)
 
@@ -1139,6 +1234,8 @@ func (xmem *XmemNozzle) finalCleanup() {
 		}
 	}
 	xmem.conflictMgr = nil
+	xmem.conflictLogger = nil
+	xmem.deadLetterQueue = nil
 }
 
 func (xmem *XmemNozzle) cleanupBufferedMCRequest(req *bufferedMCRequest) {
@@ -1243,9 +1340,11 @@ func (xmem *XmemNozzle) batchSetMetaWithRetry(batch *dataBatch, numOfRetry int)
 					additionalInfo := DataFailedCRSourceEventAdditional{Seqno: item.Seqno,
 						Opcode:      encodeOpCode(item.Req, xmem.source_cr_mode == base.CRMode_Custom),
 						IsExpirySet: (binary.BigEndian.Uint32(item.Req.Extras[4:8]) != 0),
-						VBucket:     item.Req.VBucket,
+						VBucket:     item.GetSourceVBucket(),
 						ManifestId:  item.GetManifestId(),
+						SourceCas:   getSourceCas(item.Req),
 					}
+					additionalInfo.SourceNamespace, additionalInfo.TargetNamespace = getStatsNamespaces(item)
 					xmem.RaiseEvent(common.NewEvent(common.DataFailedCRSource, nil, xmem, nil, additionalInfo))
 				}
 				xmem.recycleDataObj(item)
@@ -1324,85 +1423,21 @@ func (xmem *XmemNozzle) preprocessMCRequest(req *base.WrappedMCRequest) error {
 				mc_req.DataType = mc_req.DataType | mcc.SnappyDataType
 			}
 		}
+	} else if xmem.conflict_resolver.SkipTargetCR() {
+		setSkipConflictResolutionOption(mc_req)
 	}
 	return nil
 }
 
-//return true if doc_meta_source win; false otherwise
-func resolveConflict(doc_meta_source documentMetadata, doc_meta_target documentMetadata,
-	source_cr_mode base.ConflictResolutionMode, xattrEnabled bool, logger *log.CommonLogger) bool {
-	if source_cr_mode == base.CRMode_LWW {
-		return resolveConflictByCAS(doc_meta_source, doc_meta_target, xattrEnabled, logger)
-	} else {
-		return resolveConflictByRevSeq(doc_meta_source, doc_meta_target, xattrEnabled, logger)
-	}
-}
-
-func resolveConflictByCAS(doc_meta_source documentMetadata,
-	doc_meta_target documentMetadata, xattrEnabled bool, logger *log.CommonLogger) bool {
-	ret := true
-	if doc_meta_target.cas > doc_meta_source.cas {
-		ret = false
-	} else if doc_meta_target.cas == doc_meta_source.cas {
-		if doc_meta_target.revSeq > doc_meta_source.revSeq {
-			ret = false
-		} else if doc_meta_target.revSeq == doc_meta_source.revSeq {
-			//if the outgoing mutation is deletion and its revSeq and cas are the
-			//same as the target side document, it would lose the conflict resolution
-			if doc_meta_source.deletion || (doc_meta_target.expiry > doc_meta_source.expiry) {
-				ret = false
-			} else if doc_meta_target.expiry == doc_meta_source.expiry {
-				if doc_meta_target.flags > doc_meta_source.flags {
-					ret = false
-				} else if doc_meta_target.flags == doc_meta_source.flags {
-					ret = resolveConflictByXattr(doc_meta_source, doc_meta_target, xattrEnabled)
-				}
-			}
-		}
-	}
-	return ret
-}
-
-func resolveConflictByRevSeq(doc_meta_source documentMetadata,
-	doc_meta_target documentMetadata, xattrEnabled bool, logger *log.CommonLogger) bool {
-	ret := true
-	if doc_meta_target.revSeq > doc_meta_source.revSeq {
-		ret = false
-	} else if doc_meta_target.revSeq == doc_meta_source.revSeq {
-		if doc_meta_target.cas > doc_meta_source.cas {
-			ret = false
-		} else if doc_meta_target.cas == doc_meta_source.cas {
-			//if the outgoing mutation is deletion and its revSeq and cas are the
-			//same as the target side document, it would lose the conflict resolution
-			if doc_meta_source.deletion || (doc_meta_target.expiry > doc_meta_source.expiry) {
-				ret = false
-			} else if doc_meta_target.expiry == doc_meta_source.expiry {
-				if doc_meta_target.flags > doc_meta_source.flags {
-					ret = false
-				} else if doc_meta_target.flags == doc_meta_source.flags {
-					ret = resolveConflictByXattr(doc_meta_source, doc_meta_target, xattrEnabled)
-				}
-			}
-		}
-	}
-	return ret
-}
-
-// if all other metadata fields are equal, use xattr field to decide whether source mutations should win
-func resolveConflictByXattr(doc_meta_source documentMetadata,
-	doc_meta_target documentMetadata, xattrEnabled bool) bool {
-	if xattrEnabled {
-		// if target is xattr enabled, source mutation has xattr, and target mutation does not have xattr
-		// let source mutation win
-		source_has_xattr := base.HasXattr(doc_meta_source.dataType)
-		target_has_xattr := base.HasXattr(doc_meta_target.dataType)
-		return source_has_xattr && !target_has_xattr
-	} else {
-		// if target is not xattr enabled, target mutation always does not have xattr
-		// do not have let source mutation win even if source mutation has xattr,
-		// otherwise source mutations need to be repeatly re-sent in backfill mode
-		return false
+// The decision of the source side conflict resolver is final. Tell the target not to run its own conflict resolution
+func setSkipConflictResolutionOption(mc_req *mc.MCRequest) {
+	if len(mc_req.Extras) < 28 {
+		extras := make([]byte, 28)
+		copy(extras, mc_req.Extras)
+		mc_req.Extras = extras
 	}
+	options := binary.BigEndian.Uint32(mc_req.Extras[24:28])
+	binary.BigEndian.PutUint32(mc_req.Extras[24:28], options|base.SKIP_CONFLICT_RESOLUTION_FLAG)
 }
 
 func (xmem *XmemNozzle) sendWithRetry(client *base.XmemClient, numOfRetry int, item_byte [][]byte) error {
@@ -1647,13 +1682,16 @@ func (xmem *XmemNozzle) batchGetMeta(bigDoc_map base.McRequestMap) (map[string]N
 				continue
 			}
 			doc_meta_source := decodeSetMetaReq(wrappedReq.Req)
-			if !xmem.conflict_resolver(doc_meta_source, doc_meta_target, xmem.source_cr_mode, xmem.xattrEnabled, xmem.Logger()) {
+			if !xmem.conflict_resolver.Resolve(doc_meta_source.toConflictResolverDoc(nil), doc_meta_target.toConflictResolverDoc(nil), xmem.xattrEnabled) {
 				if xmem.Logger().GetLogLevel() >= log.LogLevelDebug {
 					docMetaSrcRedacted := doc_meta_source.CloneAndRedact()
 					docMetaTgtRedacted := doc_meta_target.CloneAndRedact()
 					xmem.Logger().Debugf("%v doc %v%s%v failed source side conflict resolution. source meta=%v, target meta=%v. no need to send\n", xmem.Id(), base.UdTagBegin, key, base.UdTagEnd, docMetaSrcRedacted, docMetaTgtRedacted)
 				}
 				bigDoc_noRep_map[wrappedReq.UniqueKey] = Not_Send_Failed_CR
+				if xmem.conflictLogger != nil {
+					xmem.conflictLogger.Log(xmem.composeConflictRecord(wrappedReq, doc_meta_source, doc_meta_target))
+				}
 			} else if xmem.Logger().GetLogLevel() >= log.LogLevelDebug {
 				docMetaSrcRedacted := doc_meta_source.CloneAndRedact()
 				docMetaTgtRedacted := doc_meta_target.CloneAndRedact()
@@ -1687,6 +1725,123 @@ func (xmem *XmemNozzle) batchGetMeta(bigDoc_map base.McRequestMap) (map[string]N
 	return bigDoc_noRep_map, nil
 }
 
+/**
+ * batch call to memcached subdoc_get command for conflict resolvers that need the body of the target documents.
+ * Since these resolvers tell the target to skip its own conflict resolution, a mutation whose target document
+ * cannot be retrieved is not sent
+ */
+func (xmem *XmemNozzle) batchGetDocForConflictResolver(getDoc_map base.McRequestMap) (map[string]NeedSendStatus, error) {
+	noRep_map := make(BigDocNoRepMap)
+	var hasTmpErr bool
+	for i := 0; i < xmem.config.maxRetry || hasTmpErr; i++ {
+		if len(getDoc_map) == 0 {
+			return noRep_map, nil
+		}
+		err := xmem.validateRunningState()
+		if err != nil {
+			return nil, err
+		}
+		hasTmpErr = false
+		respMap, specs, err := xmem.sendBatchGetRequest(getDoc_map, xmem.config.maxRetry, true /* include_doc */)
+		if err != nil {
+			xmem.Logger().Errorf("%v sendBatchGetRequest returned error '%v'. Retry number %v", xmem.Id(), err, i)
+		}
+		// Process the response the handler received
+		keys_to_be_deleted := make(map[string]bool)
+		for uniqueKey, wrappedReq := range getDoc_map {
+			key := string(wrappedReq.Req.Key)
+			resp, ok := respMap[key]
+			if !ok || resp == nil {
+				continue
+			}
+			if resp.Status == mc.KEY_ENOENT || base.IsCollectionMappingError(resp.Status) {
+				// nothing to resolve against. Send the doc
+				keys_to_be_deleted[uniqueKey] = true
+			} else if base.IsSuccessSubdocLookupResponse(resp) {
+				sourceWins, err := xmem.resolveWithTargetDoc(wrappedReq, &base.SubdocLookupResponse{specs, resp})
+				if err != nil {
+					xmem.Logger().Errorf("%v conflict resolver %v: '%v'", xmem.Id(), xmem.conflict_resolver.Name(), err)
+					continue
+				}
+				if !sourceWins {
+					noRep_map[uniqueKey] = Not_Send_Failed_CR
+				}
+				keys_to_be_deleted[uniqueKey] = true
+			} else if base.IsTopologyChangeMCError(resp.Status) {
+				noRep_map[uniqueKey] = Not_Send_Other
+				keys_to_be_deleted[uniqueKey] = true
+			} else {
+				if base.IsTemporaryMCError(resp.Status) {
+					hasTmpErr = true
+				}
+				if xmem.Logger().GetLogLevel() >= log.LogLevelDebug {
+					xmem.Logger().Debugf("Received response status %v for key %v%s%v", resp.Status, base.UdTagBegin, key, base.UdTagEnd)
+				}
+			}
+		}
+		for uniqueKey, _ := range keys_to_be_deleted {
+			delete(getDoc_map, uniqueKey)
+		}
+	}
+	if len(getDoc_map) == 0 {
+		return noRep_map, nil
+	}
+	// the pipeline is going to restart, and these mutations will be replicated again from the last checkpoint
+	for uniqueKey, _ := range getDoc_map {
+		noRep_map[uniqueKey] = Not_Send_Other
+	}
+	return noRep_map, fmt.Errorf("Failed to get document body from target for %v documents after %v retries", len(getDoc_map), xmem.config.maxRetry)
+}
+
+func (xmem *XmemNozzle) resolveWithTargetDoc(wrappedReq *base.WrappedMCRequest, lookupResp *base.SubdocLookupResponse) (bool, error) {
+	doc_meta_source := decodeSetMetaReq(wrappedReq.Req)
+	doc_meta_target := xmem.decodeSubDocResp(wrappedReq.Req.Key, lookupResp)
+
+	var sourceBody, targetBody []byte
+	var err error
+	if !doc_meta_source.deletion {
+		sourceBody, err = getBodyWithoutXattr(wrappedReq.Req)
+		if err != nil {
+			return false, err
+		}
+	}
+	if !doc_meta_target.deletion {
+		targetBody, err = lookupResp.FindTargetBodyWithoutXattr()
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if xmem.conflict_resolver.Resolve(doc_meta_source.toConflictResolverDoc(sourceBody), doc_meta_target.toConflictResolverDoc(targetBody), xmem.xattrEnabled) {
+		return true, nil
+	}
+	if xmem.Logger().GetLogLevel() >= log.LogLevelDebug {
+		docMetaSrcRedacted := doc_meta_source.CloneAndRedact()
+		docMetaTgtRedacted := doc_meta_target.CloneAndRedact()
+		xmem.Logger().Debugf("%v doc %v%s%v failed source side conflict resolution. source meta=%v, target meta=%v. no need to send\n", xmem.Id(), base.UdTagBegin, wrappedReq.Req.Key, base.UdTagEnd, docMetaSrcRedacted, docMetaTgtRedacted)
+	}
+	if xmem.conflictLogger != nil {
+		xmem.conflictLogger.Log(xmem.composeConflictRecord(wrappedReq, doc_meta_source, doc_meta_target))
+	}
+	return false, nil
+}
+
+// Returns the document body of a source mutation, decompressed and without xattrs
+func getBodyWithoutXattr(req *mc.MCRequest) ([]byte, error) {
+	body := req.Body
+	if req.DataType&mcc.SnappyDataType > 0 {
+		var err error
+		body, err = snappy.Decode(nil, body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if req.DataType&mcc.XattrDataType > 0 {
+		return base.StripXattrAndGetBody(body)
+	}
+	return body, nil
+}
+
 func (xmem *XmemNozzle) decodeGetMetaResp(key []byte, resp *mc.MCResponse) (documentMetadata, error) {
 	ret := documentMetadata{}
 	ret.key = key
@@ -2120,7 +2275,7 @@ func (xmem *XmemNozzle) detectConflictWithXattr(source *mc.MCRequest, lookupResp
 		base.IsDeletedSubdocLookupResponse(target) || encodeOpCode(source, true) == base.DELETE_WITH_META {
 		doc_meta_source := decodeSetMetaReq(source)
 		doc_meta_target := xmem.decodeSubDocResp(source.Key, lookupResp)
-		if xmem.conflict_resolver(doc_meta_source, doc_meta_target, base.CRMode_LWW, xmem.xattrEnabled, xmem.Logger()) {
+		if xmem.conflict_resolver.Resolve(doc_meta_source.toConflictResolverDoc(nil), doc_meta_target.toConflictResolverDoc(nil), xmem.xattrEnabled) {
 			return SourceDominate, nil
 		} else {
 			return TargetDominate, nil
@@ -2518,6 +2673,16 @@ func (xmem *XmemNozzle) initialize(settings metadata.ReplicationSettingsMap) err
 		atomic.StoreUint32(&xmem.collectionEnabled, 0)
 	}
 
+	if xmem.config.applyDelay > 0 {
+		filePrefix, err := log.FilePathInLogDir(base.DelayBufferFilePrefix + sanitizeFileExportName(xmem.Id()))
+		if err != nil {
+			return err
+		}
+		xmem.delayBuffer = newDelayBuffer(xmem.Id(), xmem.config.applyDelay, filePrefix, xmem.recycleDataObj, xmem.Logger())
+		xmem.delayBuffer.removeStaleSegments()
+		xmem.Logger().Infof("%v holds mutations until their source CAS is %v old", xmem.Id(), xmem.config.applyDelay)
+	}
+
 	xmem.Logger().Infof("%v About to start initializing connection", xmem.Id())
 	err = xmem.initializeConnection()
 	if err == nil {
@@ -2682,11 +2847,22 @@ func (xmem *XmemNozzle) receiveResponse(finch chan bool, waitGrp *sync.WaitGroup
 								vb_err := fmt.Errorf("Received error %v on vb %v\n", base.ErrorNotMyVbucket, req.VBucket)
 								xmem.handleVBError(req.VBucket, vb_err)
 							} else if base.IsCollectionMappingError(response.Status) {
-								xmem.upstreamErrReporter(wrappedReq)
-								if xmem.buf.evictSlot(pos) != nil {
-									panic(fmt.Sprintf("Failed to evict slot %d\n", pos))
+								if xmem.deadLetterQueue != nil {
+									// the target nodes may not all know about a new collection yet. resendIfTimeout resends
+									// the document with backoff and dead letters it once the retries run out
+									_, err = xmem.buf.modSlot(pos, xmem.setCollectionMapErrFlag)
+								} else {
+									xmem.upstreamErrReporter(wrappedReq)
+									if xmem.buf.evictSlot(pos) != nil {
+										panic(fmt.Sprintf("Failed to evict slot %d\n", pos))
+									}
+									atomic.AddUint64(&xmem.counter_ignored, 1)
+								}
+							} else if xmem.deadLetterQueue != nil && base.IsDeadLetterMCError(response.Status) {
+								// the target will reject the document however many times it is resent
+								if !xmem.deadLetter(wrappedReq, pos, response.Status) {
+									goto done
 								}
-								atomic.AddUint64(&xmem.counter_ignored, 1)
 							} else {
 								// for other non-temporary errors, repair connections
 								if response.Status == mc.XATTR_EINVAL {
@@ -2734,12 +2910,16 @@ func (xmem *XmemNozzle) receiveResponse(finch chan bool, waitGrp *sync.WaitGroup
 				var committing_time time.Duration
 				var resp_wait_time time.Duration
 				var manifestId uint64
+				var sourceVBucket uint16
+				var sourceNamespace, targetNamespace *base.CollectionNamespace
 				if wrappedReq != nil {
 					req = wrappedReq.Req
 					seqno = wrappedReq.Seqno
+					sourceVBucket = wrappedReq.GetSourceVBucket()
 					committing_time = time.Since(wrappedReq.Start_time)
 					resp_wait_time = time.Since(*sent_time)
 					manifestId = wrappedReq.GetManifestId()
+					sourceNamespace, targetNamespace = getStatsNamespaces(wrappedReq)
 				}
 
 				if req != nil && req.Opaque == response.Opaque {
@@ -2747,12 +2927,14 @@ func (xmem *XmemNozzle) receiveResponse(finch chan bool, waitGrp *sync.WaitGroup
 						IsOptRepd:      xmem.optimisticRep(req),
 						Opcode:         req.Opcode,
 						IsExpirySet:    (binary.BigEndian.Uint32(req.Extras[4:8]) != 0),
-						VBucket:        req.VBucket,
+						VBucket:        sourceVBucket,
 						Req_size:       req.Size(),
 						Commit_time:    committing_time,
 						Resp_wait_time: resp_wait_time,
 						ManifestId:     manifestId,
+						SourceCas:      getSourceCas(req),
 					}
+					additionalInfo.SourceNamespace, additionalInfo.TargetNamespace = sourceNamespace, targetNamespace
 					xmem.RaiseEvent(common.NewEvent(common.DataSent, nil, xmem, nil, additionalInfo))
 
 					//feedback the most current commit_time to xmem.config.respTimeout
@@ -2991,7 +3173,12 @@ func (xmem *XmemNozzle) resendIfTimeout(req *bufferedMCRequest, pos uint16) (boo
 			xmem.Logger().Error(err.Error())
 
 			lastErrIsDueToCollectionMapping := req.collectionMapErr
-			if lastErrIsDueToCollectionMapping {
+			if lastErrIsDueToCollectionMapping && xmem.deadLetterQueue != nil {
+				wrappedReq := req.req
+				req.lock.Unlock()
+				xmem.deadLetter(wrappedReq, pos, mc.UNKNOWN_COLLECTION)
+				return true, nil
+			} else if lastErrIsDueToCollectionMapping {
 				xmem.handleGeneralError(GetErrorXmemTargetUnknownCollection(req.req))
 			}
 
@@ -3122,6 +3309,29 @@ func (xmem *XmemNozzle) resendWithReset(req *bufferedMCRequest, pos uint16) (boo
 	return modified, nil
 }
 
+// set collectionMapErr flag on the bufferedMCRequest so that it is dead lettered once it runs out of retries
+// returns true if modification is made on the bufferedMCRequest
+func (xmem *XmemNozzle) setCollectionMapErrFlag(req *bufferedMCRequest, pos uint16) (bool, error) {
+	req.lock.Lock()
+	defer req.lock.Unlock()
+
+	// check that there is a valid WrappedMCRequest associated with req
+	if req.req == nil {
+		return false, nil
+	}
+
+	if !req.collectionMapErr {
+		req.collectionMapErr = true
+		req.num_of_retry = 0
+		req.timedout = false
+		return true, nil
+	}
+
+	// no op if req has already been marked as collectionMapErr before
+
+	return false, nil
+}
+
 // set mutationLocked flag on the bufferedMCRequest
 // returns true if modification is made on the bufferedMCRequest
 func (xmem *XmemNozzle) setMutationLockedFlag(req *bufferedMCRequest, pos uint16) (bool, error) {
@@ -3187,6 +3397,11 @@ func (xmem *XmemNozzle) PrintStatusSummary() {
 			atomic.LoadUint64(&xmem.counter_locked),
 			xmem.client_for_getMeta.RepairCount(), xmem.client_for_setMeta.RepairCount(),
 			atomic.LoadUint64(&xmem.counter_retry_cr), atomic.LoadUint64(&xmem.counter_to_resolve), atomic.LoadUint64(&xmem.counter_to_setback))
+		if xmem.delayBuffer != nil {
+			held, spillBytes, spillTotal := xmem.delayBuffer.stats()
+			xmem.Logger().Infof("%v apply delay=%v held %v items, %v bytes spilled to disk, %v items spilled in total",
+				xmem.Id(), xmem.config.applyDelay, held, spillBytes, spillTotal)
+		}
 	} else {
 		xmem.Logger().Infof("%v state =%v ", xmem.Id(), xmem.State())
 	}
@@ -3206,6 +3421,12 @@ func (xmem *XmemNozzle) optimisticRep(req *mc.MCRequest) bool {
 	if xmem.source_cr_mode == base.CRMode_Custom {
 		return false
 	}
+	switch xmem.conflict_resolver.TargetLookup() {
+	case CRLookupNone:
+		return true
+	case CRLookupMeta, CRLookupDoc:
+		return false
+	}
 	if req != nil {
 		return uint32(req.Size()) < xmem.getOptiRepThreshold()
 	}
@@ -3713,6 +3934,11 @@ func (xmem *XmemNozzle) getClientWithRetry(xmem_id string, pool base.ConnPool, f
 			return nil, err
 		}
 
+		// the credentials of the remote cluster reference may have been rotated since the pipeline was started.
+		// Use the current ones, so that the new connection falls back on the secondary credentials if needed
+		primary, secondary := targetClusterRef.ConnCredentials()
+		pool.SetCredentials(primary, secondary)
+
 		sanInCertificate := xmem.config.san_in_certificate
 		if !initializing && xmem.config.encryptionType == metadata.EncryptionType_Full {
 			connStr, err := targetClusterRef.MyConnectionStr()
@@ -3721,8 +3947,13 @@ func (xmem *XmemNozzle) getClientWithRetry(xmem_id string, pool base.ConnPool, f
 			}
 			// hostAddr not used in full encryption mode
 			_, _, _, err = xmem.utils.GetSecuritySettingsAndDefaultPoolInfo("" /*hostAddr*/, connStr,
-				xmem.config.username, xmem.config.password, xmem.config.certificate, xmem.config.clientCertificate,
-				xmem.config.clientKey, false /*scramShaEnabled*/, logger)
+				primary.UserName, primary.Password, xmem.config.certificate, primary.ClientCertificate,
+				primary.ClientKey, false /*scramShaEnabled*/, logger)
+			if err != nil && secondary != nil {
+				_, _, _, err = xmem.utils.GetSecuritySettingsAndDefaultPoolInfo("" /*hostAddr*/, connStr,
+					secondary.UserName, secondary.Password, xmem.config.certificate, secondary.ClientCertificate,
+					secondary.ClientKey, false /*scramShaEnabled*/, logger)
+			}
 			if err != nil {
 				return nil, err
 			}
@@ -3764,6 +3995,74 @@ func (xmem *XmemNozzle) SetConflictManager(conflictMgr service_def.ConflictManag
 	xmem.conflictMgr = conflictMgr
 }
 
+// Should only be done during pipeline construction
+func (xmem *XmemNozzle) SetConflictLogger(conflictLogger service_def.ConflictLoggerIface) {
+	xmem.conflictLogger = conflictLogger
+}
+
+// The source request is recycled once the batch is done, so everything the conflict logger needs is copied here
+func (xmem *XmemNozzle) composeConflictRecord(wrappedReq *base.WrappedMCRequest, doc_meta_source, doc_meta_target documentMetadata) *base.ConflictRecord {
+	record := &base.ConflictRecord{
+		Key:       append([]byte{}, wrappedReq.GetPlainKey()...),
+		Namespace: base.DefaultCollectionNamespace,
+		VBucket:   wrappedReq.GetSourceVBucket(),
+		Seqno:     wrappedReq.Seqno,
+		CRMode:    xmem.source_cr_mode,
+		Timestamp: time.Now(),
+		Source:    doc_meta_source.toConflictDocMeta(),
+		Target:    doc_meta_target.toConflictDocMeta(),
+		Body:      append([]byte{}, wrappedReq.Req.Body...),
+	}
+	if namespace := wrappedReq.GetSourceCollectionNamespace(); namespace != nil {
+		record.Namespace = *namespace
+	}
+	return record
+}
+
+// Should only be done during pipeline construction
+func (xmem *XmemNozzle) SetDeadLetterQueue(deadLetterQueue service_def.DeadLetterQueueIface) {
+	xmem.deadLetterQueue = deadLetterQueue
+}
+
+// Like the conflict record, the dead letter record holds copies since the request is recycled once it is queued
+// deadLetter hands the request in the slot at pos to the dead letter queue and frees the slot
+// returns false if the queue is full, in which case the pipeline is restarted and the slot is left as is
+func (xmem *XmemNozzle) deadLetter(wrappedReq *base.WrappedMCRequest, pos uint16, status mc.Status) bool {
+	if !xmem.deadLetterQueue.Add(xmem.composeDeadLetterRecord(wrappedReq, status)) {
+		xmem.Logger().Errorf("%v unable to queue dead letter record for vb %v seqno %v. response status=%v", xmem.Id(), wrappedReq.GetSourceVBucket(), wrappedReq.Seqno, status)
+		xmem.handleGeneralError(base.ErrorDeadLetterQueueFull)
+		return false
+	}
+	if xmem.buf.evictSlot(pos) != nil {
+		panic(fmt.Sprintf("Failed to evict slot %d\n", pos))
+	}
+	atomic.AddUint64(&xmem.counter_ignored, 1)
+	xmem.recycleDataObj(wrappedReq)
+	return true
+}
+
+func (xmem *XmemNozzle) composeDeadLetterRecord(wrappedReq *base.WrappedMCRequest, status mc.Status) *base.DeadLetterRecord {
+	req := wrappedReq.Req
+	record := &base.DeadLetterRecord{
+		Key:        append([]byte{}, wrappedReq.GetSourceKey()...),
+		TargetKey:  append([]byte{}, wrappedReq.GetPlainKey()...),
+		Namespace:  base.DefaultCollectionNamespace,
+		VBucket:    wrappedReq.GetSourceVBucket(),
+		Seqno:      wrappedReq.Seqno,
+		ManifestId: wrappedReq.GetManifestId(),
+		Opcode:     req.Opcode,
+		Status:     status,
+		Cas:        getSourceCas(req),
+		DataType:   req.DataType,
+		Timestamp:  time.Now(),
+		Body:       append([]byte{}, req.Body...),
+	}
+	if namespace := wrappedReq.GetSourceCollectionNamespace(); namespace != nil {
+		record.Namespace = *namespace
+	}
+	return record
+}
+
 func (xmem *XmemNozzle) checkSendDelayInjection() {
 	if atomic.LoadUint32(&xmem.config.devBackfillSendDelay) > 0 {
 		if _, pipelineType := common.DecomposeFullTopic(xmem.topic); pipelineType == common.BackfillPipeline {
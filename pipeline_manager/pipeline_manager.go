@@ -528,6 +528,11 @@ func (pipelineMgr *PipelineManager) validatePipeline(topic string) error {
 		return fmt.Errorf(errString)
 	}
 
+	if spec.IsFileExport() {
+		// there is no remote cluster to validate
+		return nil
+	}
+
 	// refresh remote cluster reference when retrieving it, hence making sure that all fields,
 	// especially the security settings like sanInCertificate, are up to date
 	targetClusterRef, err := pipelineMgr.remote_cluster_svc.RemoteClusterByUuid(spec.TargetClusterUUID, true)
@@ -655,6 +660,13 @@ func (pipelineMgr *PipelineManager) StopPipeline(rep_status pipeline.Replication
 		}
 	}
 
+	// Events have been cleared above. Tell the user why the replication cannot simply be resumed
+	if spec != nil && spec.Settings.GetBulkDeleteTripped() {
+		msg := fmt.Sprintf("Replication was paused on bulk deletion on the source. Set %v to false to acknowledge the deletions before resuming it",
+			base.BulkDeleteTrippedKey)
+		rep_status.GetEventsManager().AddEvent(base.PersistentMsg, msg, base.NewEventsMap())
+	}
+
 	return errMap
 }
 
@@ -690,6 +702,9 @@ func (pipelineMgr *PipelineManager) CleanupPipeline(topic string) error {
 		pipelineMgr.logger.Warnf("Removing checkpoint resulting in error: %v\n")
 	}
 
+	// A cleaned up pipeline replicates everything again instead of starting from a point in time
+	pipelineMgr.clearStartPoint(replId)
+
 	// When pipeline is "cleaned up", we don't need anymore backfill specs
 	retryOp = func() error {
 		_, err := pipelineMgr.backfillReplSvc.DelBackfillReplSpec(replId)
@@ -706,6 +721,28 @@ func (pipelineMgr *PipelineManager) CleanupPipeline(topic string) error {
 	return err
 }
 
+func (pipelineMgr *PipelineManager) clearStartPoint(replId string) {
+	spec, err := pipelineMgr.repl_spec_svc.ReplicationSpec(replId)
+	if err != nil || spec == nil {
+		return
+	}
+	if spec.Settings.GetStartFromTime().IsZero() && spec.Settings.GetStartFromSeqnos() == nil {
+		return
+	}
+	_, errMap := spec.Settings.UpdateSettingsFromMap(metadata.ReplicationSettingsMap{
+		metadata.StartFromTimeKey:   0,
+		metadata.StartFromSeqnosKey: "",
+	})
+	if len(errMap) == 0 {
+		err = pipelineMgr.repl_spec_svc.SetReplicationSpec(spec)
+	} else {
+		err = fmt.Errorf("%v", errMap)
+	}
+	if err != nil {
+		pipelineMgr.logger.Warnf("%v unable to clear the start point. err=%v", replId, err)
+	}
+}
+
 // When a backfill pipeline is stopped, it can choose to cleanup the checkpoints for the backfill pipeline
 // so that when a new backfill task starts, it will start cleanly
 func (pipelineMgr *PipelineManager) CleanupBackfillPipeline(topic string) error {
@@ -1604,8 +1641,8 @@ func (r *PipelineUpdater) run() {
 			case <-r.updateRCStatusTicker.C:
 				// Check if connectivity has issues - and if so, raise an error if it's not there already
 				spec := r.rep_status.Spec()
-				// Spec may be nil when pipeline is stopping
-				if spec != nil {
+				// Spec may be nil when pipeline is stopping. File export replications have no remote cluster
+				if spec != nil && !spec.IsFileExport() {
 					ref, err := r.pipelineMgr.GetRemoteClusterSvc().RemoteClusterByUuid(spec.TargetClusterUUID, false)
 					if err != nil {
 						r.logger.Errorf("Pipeline updater received err %v when retrieving its remote cluster")
@@ -0,0 +1,228 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software
+will be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package pipeline_svc
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mc "github.com/couchbase/gomemcached"
+	mcc "github.com/couchbase/gomemcached/client"
+	"github.com/couchbase/goxdcr/base"
+	"github.com/couchbase/goxdcr/common"
+	component "github.com/couchbase/goxdcr/component"
+	"github.com/couchbase/goxdcr/log"
+	"github.com/couchbase/goxdcr/metadata"
+	"github.com/couchbase/goxdcr/pipeline_utils"
+)
+
+// Deletions and mutations whose source CAS falls within the same second
+type bulkDeleteBucket struct {
+	second    int64
+	deletions int64
+	total     int64
+}
+
+// BulkDeleteBreaker watches the deletions and expirations that the routers of the main pipeline let through.
+// Only those of recent source mutations are counted, i.e. the ones whose source CAS falls within the window, so that
+// streaming old tombstones, e.g. when a replication starts from scratch, does not count.
+// When the deletions in the window exceed the rate or percentage limit of the replication, the breaker raises
+// BulkDeletesDetected so that the pipeline supervisor can have the replication paused until the operator
+// acknowledges. Each node only watches the VBs it owns, so the replication is paused by whichever node trips first
+type BulkDeleteBreaker struct {
+	*component.AbstractComponent
+	pipeline common.Pipeline
+
+	maxRate    int
+	maxPercent int
+	window     time.Duration
+	// deletions from before the last acknowledgement have been looked at by the operator already
+	ackTime           time.Time
+	minDeletesPercent int64
+
+	// one bucket per second of the window, indexed by the second modulo the window size
+	buckets    []bulkDeleteBucket
+	bucketsMtx sync.Mutex
+
+	finish_ch chan bool
+	wait_grp  sync.WaitGroup
+	tripped   uint32
+}
+
+func NewBulkDeleteBreaker(replId string, settings *metadata.ReplicationSettings, logger_ctx *log.LoggerContext) *BulkDeleteBreaker {
+	window := settings.GetBulkDeleteWindow()
+	return &BulkDeleteBreaker{
+		AbstractComponent: component.NewAbstractComponentWithLogger(replId, log.NewLogger("BulkDeleteBreaker", logger_ctx)),
+		maxRate:           settings.GetBulkDeleteMaxRate(),
+		maxPercent:        settings.GetBulkDeleteMaxPercent(),
+		window:            window,
+		ackTime:           settings.GetBulkDeleteAckTime(),
+		minDeletesPercent: int64(base.BulkDeleteMinDeletesForPercent),
+		buckets:           make([]bulkDeleteBucket, int(window/time.Second)),
+		finish_ch:         make(chan bool, 1),
+	}
+}
+
+func (b *BulkDeleteBreaker) Attach(pipeline common.Pipeline) error {
+	b.Logger().Infof("Attach bulkDeleteBreaker with %v pipeline %v\n", pipeline.Type().String(), pipeline.FullTopic())
+	b.pipeline = pipeline
+
+	for _, dcp := range pipeline.Sources() {
+		err := dcp.RegisterComponentEventListener(common.DataReceived, b)
+		if err != nil {
+			return err
+		}
+		err = dcp.Connector().RegisterComponentEventListener(common.DataFiltered, b)
+		if err != nil {
+			return err
+		}
+	}
+
+	supervisor := b.pipeline.RuntimeContext().Service(base.PIPELINE_SUPERVISOR_SVC)
+	if supervisor == nil {
+		return errors.New("Pipeline supervisor not found")
+	}
+	return b.RegisterComponentEventListener(common.BulkDeletesDetected, supervisor.(*PipelineSupervisor))
+}
+
+func (b *BulkDeleteBreaker) Start(settingsMap metadata.ReplicationSettingsMap) error {
+	b.wait_grp.Add(1)
+	go b.run()
+	b.Logger().Infof("%v: BulkDeleteBreaker started with maxRate=%v/s maxPercent=%v window=%v", b.pipeline.FullTopic(),
+		b.maxRate, b.maxPercent, b.window)
+	return nil
+}
+
+func (b *BulkDeleteBreaker) Stop() error {
+	b.Logger().Infof("%v: BulkDeleteBreaker Stopping.", b.pipeline.FullTopic())
+	close(b.finish_ch)
+	b.wait_grp.Wait()
+	b.Logger().Infof("%v: BulkDeleteBreaker stopped.", b.pipeline.FullTopic())
+	return nil
+}
+
+func (b *BulkDeleteBreaker) Detach(pipeline common.Pipeline) error {
+	return base.ErrorNotSupported
+}
+
+// Backfill pipelines replicate what the main pipeline has not, and are paused along with it
+func (b *BulkDeleteBreaker) IsSharable() bool {
+	return false
+}
+
+// Changes to the bulk delete limits restart the pipeline
+func (b *BulkDeleteBreaker) UpdateSettings(settings metadata.ReplicationSettingsMap) error {
+	return nil
+}
+
+// Implements common.ComponentEventListener
+// DataReceived is raised by the DCP nozzles for every mutation, and DataFiltered by the routers for the ones that
+// are not replicated, including the deletions and expirations that the replication skips. Both are raised synchronously, before the event is recycled
+func (b *BulkDeleteBreaker) OnEvent(event *common.Event) {
+	var delta int64
+	switch event.EventType {
+	case common.DataReceived:
+		delta = 1
+	case common.DataFiltered:
+		delta = -1
+	default:
+		return
+	}
+	uprEvent, ok := event.Data.(*mcc.UprEvent)
+	if !ok || uprEvent.IsSystemEvent() {
+		return
+	}
+	b.record(uprEvent, delta, time.Now())
+}
+
+func (b *BulkDeleteBreaker) record(uprEvent *mcc.UprEvent, delta int64, now time.Time) {
+	casTime := time.Unix(0, int64(uprEvent.Cas))
+	if casTime.Before(b.ackTime) || now.Sub(casTime) >= b.window {
+		return
+	}
+	second := casTime.Unix()
+	if second > now.Unix() {
+		// source clock ahead of ours
+		second = now.Unix()
+	}
+
+	b.bucketsMtx.Lock()
+	defer b.bucketsMtx.Unlock()
+	bucket := &b.buckets[second%int64(len(b.buckets))]
+	if bucket.second != second {
+		if delta < 0 || bucket.second > second {
+			// the mutation that has been filtered out was not counted in the current bucket, or the bucket has moved on
+			return
+		}
+		*bucket = bulkDeleteBucket{second: second}
+	}
+	bucket.total += delta
+	if uprEvent.Opcode == mc.UPR_DELETION || uprEvent.Opcode == mc.UPR_EXPIRATION {
+		bucket.deletions += delta
+	}
+}
+
+// Returns the deletions and the mutations counted in the window that ends now
+func (b *BulkDeleteBreaker) sumWindow(now time.Time) (deletions, total int64) {
+	oldest := now.Add(-b.window).Unix()
+	b.bucketsMtx.Lock()
+	defer b.bucketsMtx.Unlock()
+	for _, bucket := range b.buckets {
+		if bucket.second > oldest {
+			deletions += bucket.deletions
+			total += bucket.total
+		}
+	}
+	return
+}
+
+// Returns a description of the limit that has been exceeded, or an empty string
+func (b *BulkDeleteBreaker) checkLimits(deletions, total int64) string {
+	windowSec := int64(b.window / time.Second)
+	if b.maxRate > 0 && deletions > int64(b.maxRate)*windowSec {
+		return fmt.Sprintf("%v deletions in the last %v exceed the limit of %v per second", deletions, b.window, b.maxRate)
+	}
+	if b.maxPercent > 0 && deletions >= b.minDeletesPercent && deletions*100 > int64(b.maxPercent)*total {
+		return fmt.Sprintf("%v deletions out of %v mutations in the last %v exceed the limit of %v%%", deletions, total, b.window, b.maxPercent)
+	}
+	return ""
+}
+
+func (b *BulkDeleteBreaker) run() {
+	defer b.wait_grp.Done()
+
+	ticker := time.NewTicker(base.BulkDeleteBreakerCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.finish_ch:
+			return
+		case <-ticker.C:
+			if !pipeline_utils.IsPipelineRunning(b.pipeline.State()) {
+				continue
+			}
+			deletions, total := b.sumWindow(time.Now())
+			desc := b.checkLimits(deletions, total)
+			if desc == "" {
+				continue
+			}
+			if atomic.CompareAndSwapUint32(&b.tripped, 0, 1) {
+				b.Logger().Warnf("%v: %v", b.pipeline.FullTopic(), desc)
+				b.RaiseEvent(common.NewEvent(common.BulkDeletesDetected, nil, b, nil, desc))
+			}
+			return
+		}
+	}
+}
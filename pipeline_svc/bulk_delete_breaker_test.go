@@ -0,0 +1,113 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software
+will be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package pipeline_svc
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	mc "github.com/couchbase/gomemcached"
+	mcc "github.com/couchbase/gomemcached/client"
+	"github.com/couchbase/goxdcr/log"
+	"github.com/couchbase/goxdcr/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func newBulkDeleteBreakerForTest(assert *assert.Assertions, settingsMap map[string]interface{}) *BulkDeleteBreaker {
+	settings := metadata.DefaultReplicationSettings()
+	_, errMap := settings.UpdateSettingsFromMap(settingsMap)
+	assert.Len(errMap, 0)
+	return NewBulkDeleteBreaker("testRepl", settings, log.DefaultLoggerContext)
+}
+
+func recordBulkDeleteTestEvents(breaker *BulkDeleteBreaker, opcode mc.CommandCode, count int, casTime, now time.Time, delta int64) {
+	for i := 0; i < count; i++ {
+		breaker.record(&mcc.UprEvent{Opcode: opcode, Cas: uint64(casTime.UnixNano())}, delta, now)
+	}
+}
+
+func TestBulkDeleteBreakerRate(t *testing.T) {
+	fmt.Println("============== Test case start: TestBulkDeleteBreakerRate =================")
+	defer fmt.Println("============== Test case end: TestBulkDeleteBreakerRate =================")
+	assert := assert.New(t)
+
+	breaker := newBulkDeleteBreakerForTest(assert, map[string]interface{}{
+		metadata.BulkDeleteMaxRateKey: 10,
+		metadata.BulkDeleteWindowKey:  5,
+	})
+	now := time.Now()
+
+	// 50 deletions in 5 seconds are within the limit, and old tombstones do not count
+	recordBulkDeleteTestEvents(breaker, mc.UPR_DELETION, 30, now.Add(-time.Second), now, 1)
+	recordBulkDeleteTestEvents(breaker, mc.UPR_EXPIRATION, 20, now, now, 1)
+	recordBulkDeleteTestEvents(breaker, mc.UPR_DELETION, 100, now.Add(-time.Hour), now, 1)
+	recordBulkDeleteTestEvents(breaker, mc.UPR_MUTATION, 100, now, now, 1)
+	deletions, total := breaker.sumWindow(now)
+	assert.Equal(int64(50), deletions)
+	assert.Equal(int64(150), total)
+	assert.Equal("", breaker.checkLimits(deletions, total))
+
+	// deletions filtered out by the router are not replicated
+	recordBulkDeleteTestEvents(breaker, mc.UPR_DELETION, 5, now, now, 1)
+	recordBulkDeleteTestEvents(breaker, mc.UPR_DELETION, 5, now, now, -1)
+	deletions, total = breaker.sumWindow(now)
+	assert.Equal(int64(50), deletions)
+	assert.Equal(int64(150), total)
+
+	recordBulkDeleteTestEvents(breaker, mc.UPR_DELETION, 1, now, now, 1)
+	deletions, total = breaker.sumWindow(now)
+	assert.NotEqual("", breaker.checkLimits(deletions, total))
+
+	// the deletions leave the window as time goes by
+	later := now.Add(3 * time.Second)
+	deletions, _ = breaker.sumWindow(later)
+	assert.Equal(int64(51), deletions)
+	later = now.Add(5 * time.Second)
+	deletions, _ = breaker.sumWindow(later)
+	assert.Equal(int64(0), deletions)
+}
+
+func TestBulkDeleteBreakerPercent(t *testing.T) {
+	fmt.Println("============== Test case start: TestBulkDeleteBreakerPercent =================")
+	defer fmt.Println("============== Test case end: TestBulkDeleteBreakerPercent =================")
+	assert := assert.New(t)
+
+	breaker := newBulkDeleteBreakerForTest(assert, map[string]interface{}{
+		metadata.BulkDeleteMaxPercentKey: 50,
+	})
+	breaker.minDeletesPercent = 10
+	now := time.Now()
+
+	// too few deletions to go by the percentage
+	recordBulkDeleteTestEvents(breaker, mc.UPR_DELETION, 9, now, now, 1)
+	deletions, total := breaker.sumWindow(now)
+	assert.Equal("", breaker.checkLimits(deletions, total))
+
+	recordBulkDeleteTestEvents(breaker, mc.UPR_MUTATION, 11, now, now, 1)
+	recordBulkDeleteTestEvents(breaker, mc.UPR_DELETION, 1, now, now, 1)
+	deletions, total = breaker.sumWindow(now)
+	assert.Equal("", breaker.checkLimits(deletions, total))
+
+	recordBulkDeleteTestEvents(breaker, mc.UPR_DELETION, 2, now, now, 1)
+	deletions, total = breaker.sumWindow(now)
+	assert.NotEqual("", breaker.checkLimits(deletions, total))
+
+	// deletions from before the acknowledgement do not count
+	breaker = newBulkDeleteBreakerForTest(assert, map[string]interface{}{
+		metadata.BulkDeleteMaxPercentKey: 50,
+		metadata.BulkDeleteAckTimeKey:    int(now.Unix()),
+	})
+	breaker.minDeletesPercent = 10
+	recordBulkDeleteTestEvents(breaker, mc.UPR_DELETION, 20, now.Add(-2*time.Second), now, 1)
+	deletions, _ = breaker.sumWindow(now)
+	assert.Equal(int64(0), deletions)
+}
@@ -0,0 +1,292 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software
+will be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package pipeline_svc
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/couchbase/goxdcr/base"
+	"github.com/couchbase/goxdcr/common"
+	component "github.com/couchbase/goxdcr/component"
+	"github.com/couchbase/goxdcr/log"
+	"github.com/couchbase/goxdcr/metadata"
+	"github.com/couchbase/goxdcr/pipeline_utils"
+	"github.com/couchbase/goxdcr/service_def"
+)
+
+var caughtUpMonitorIterationId uint32
+
+// CaughtUpMonitor is used by stop when caught up replications. The DCP nozzles end each VB stream at the source
+// high seqno of when the pipeline started, and raise StreamingEnd with the last seqno they received.
+// Once every VB of this node has ended and its through seqno has reached that last seqno, the monitor records
+// the VBs of this node as caught up in metakv.
+// The node that owns VB 0 then waits for every VB of the source bucket to be recorded as caught up by one node
+// or another, and raises ReplicationCaughtUp so that the pipeline supervisor can have the replication paused.
+// A node that restarts its pipeline clears what it has recorded, since its streams end at newer high seqnos.
+// VBs that a node recorded before it was rebalanced out stay recorded as caught up until the replication is paused
+type CaughtUpMonitor struct {
+	*component.AbstractComponent
+	pipeline            common.Pipeline
+	throughSeqnoTracker service_def.ThroughSeqnoTrackerSvc
+	checkpointsSvc      service_def.CheckpointsService
+	xdcrTopologySvc     service_def.XDCRCompTopologySvc
+	bucketTopologySvc   service_def.BucketTopologySvc
+	vbnos               []uint16
+
+	// vbno -> last seqno received by DCP before the stream ended
+	endSeqnos    map[uint16]uint64
+	endSeqnosMtx sync.RWMutex
+
+	// Only accessed by the run go-routine once started
+	nodeId                string
+	specInternalId        string
+	localCaughtUp         bool
+	numOfSourceVbs        int
+	bucketTopSubscriberId string
+
+	finish_ch chan bool
+	wait_grp  sync.WaitGroup
+}
+
+func NewCaughtUpMonitor(replId string, throughSeqnoTracker service_def.ThroughSeqnoTrackerSvc, checkpointsSvc service_def.CheckpointsService,
+	xdcrTopologySvc service_def.XDCRCompTopologySvc, bucketTopologySvc service_def.BucketTopologySvc, logger_ctx *log.LoggerContext) *CaughtUpMonitor {
+	return &CaughtUpMonitor{
+		AbstractComponent:   component.NewAbstractComponentWithLogger(replId, log.NewLogger("CaughtUpMonitor", logger_ctx)),
+		throughSeqnoTracker: throughSeqnoTracker,
+		checkpointsSvc:      checkpointsSvc,
+		xdcrTopologySvc:     xdcrTopologySvc,
+		bucketTopologySvc:   bucketTopologySvc,
+		endSeqnos:           make(map[uint16]uint64),
+		finish_ch:           make(chan bool, 1),
+	}
+}
+
+func (c *CaughtUpMonitor) Attach(pipeline common.Pipeline) error {
+	c.Logger().Infof("Attach caughtUpMonitor with %v pipeline %v\n", pipeline.Type().String(), pipeline.FullTopic())
+	c.pipeline = pipeline
+	c.vbnos = pipeline_utils.GetSourceVBListPerPipeline(pipeline)
+	c.bucketTopSubscriberId = fmt.Sprintf("%v_%v_%v", "caughtUpMonitor", pipeline.InstanceId(), base.GetIterationId(&caughtUpMonitorIterationId))
+
+	for _, dcp := range pipeline.Sources() {
+		err := dcp.RegisterComponentEventListener(common.StreamingEnd, c)
+		if err != nil {
+			return err
+		}
+	}
+
+	supervisor := c.pipeline.RuntimeContext().Service(base.PIPELINE_SUPERVISOR_SVC)
+	if supervisor == nil {
+		return errors.New("Pipeline supervisor not found")
+	}
+	err := c.RegisterComponentEventListener(common.ErrorEncountered, supervisor.(*PipelineSupervisor))
+	if err != nil {
+		return err
+	}
+	return c.RegisterComponentEventListener(common.ReplicationCaughtUp, supervisor.(*PipelineSupervisor))
+}
+
+func (c *CaughtUpMonitor) Start(settingsMap metadata.ReplicationSettingsMap) error {
+	nodeId, err := c.xdcrTopologySvc.MyHostAddr()
+	if err != nil {
+		return err
+	}
+	c.nodeId = nodeId
+	c.specInternalId = c.pipeline.Specification().GetReplicationSpec().InternalId
+
+	// What this node caught up on before the pipeline restarted no longer holds
+	err = c.checkpointsSvc.DelCaughtUpVBs(c.specInternalId, c.nodeId)
+	if err != nil {
+		c.Logger().Warnf("%v: unable to clear the vbs this node has caught up on. err=%v", c.pipeline.FullTopic(), err)
+	}
+
+	c.wait_grp.Add(1)
+	go c.run()
+	c.Logger().Infof("%v: CaughtUpMonitor started for %v vbs", c.pipeline.FullTopic(), len(c.vbnos))
+	return nil
+}
+
+func (c *CaughtUpMonitor) Stop() error {
+	c.Logger().Infof("%v: CaughtUpMonitor Stopping.", c.pipeline.FullTopic())
+	close(c.finish_ch)
+	c.wait_grp.Wait()
+	c.Logger().Infof("%v: CaughtUpMonitor stopped.", c.pipeline.FullTopic())
+	return nil
+}
+
+func (c *CaughtUpMonitor) Detach(pipeline common.Pipeline) error {
+	return base.ErrorNotSupported
+}
+
+// Only the main pipeline stops when caught up
+func (c *CaughtUpMonitor) IsSharable() bool {
+	return false
+}
+
+// Changes to the stop when caught up setting restart the pipeline
+func (c *CaughtUpMonitor) UpdateSettings(settings metadata.ReplicationSettingsMap) error {
+	return nil
+}
+
+// Implements common.ComponentEventListener
+func (c *CaughtUpMonitor) OnEvent(event *common.Event) {
+	if event.EventType != common.StreamingEnd {
+		return
+	}
+	vbno, ok := event.Data.(uint16)
+	if !ok {
+		c.RaiseEvent(common.NewEvent(common.ErrorEncountered, nil, c, nil,
+			fmt.Errorf("Invalid vbno data type raised for StreamingEnd. Type: %T", event.Data)))
+		return
+	}
+	lastSeenSeqno, _ := event.OtherInfos.(uint64)
+
+	c.endSeqnosMtx.Lock()
+	c.endSeqnos[vbno] = lastSeenSeqno
+	c.endSeqnosMtx.Unlock()
+}
+
+func (c *CaughtUpMonitor) run() {
+	defer c.wait_grp.Done()
+
+	ticker := time.NewTicker(base.CaughtUpMonitorInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.finish_ch:
+			return
+		case <-ticker.C:
+			if !pipeline_utils.IsPipelineRunning(c.pipeline.State()) {
+				continue
+			}
+			if !c.localCaughtUp {
+				if !c.checkLocalCaughtUp() {
+					continue
+				}
+				if !c.pausesReplication() {
+					return
+				}
+			}
+			if c.checkAllCaughtUp() {
+				return
+			}
+		}
+	}
+}
+
+// Records the vbs of this node as caught up once they all have
+func (c *CaughtUpMonitor) checkLocalCaughtUp() bool {
+	c.endSeqnosMtx.RLock()
+	caughtUp, waitingOn := vbsCaughtUp(c.vbnos, c.endSeqnos, c.throughSeqnoTracker.GetThroughSeqnos())
+	c.endSeqnosMtx.RUnlock()
+	if !caughtUp {
+		c.Logger().Debugf("%v: waiting on %v vbs to catch up", c.pipeline.FullTopic(), len(waitingOn))
+		return false
+	}
+
+	err := c.checkpointsSvc.UpsertCaughtUpVBs(c.specInternalId, c.nodeId, c.vbnos)
+	if err != nil {
+		c.Logger().Warnf("%v: unable to record that the vbs of this node have caught up. err=%v", c.pipeline.FullTopic(), err)
+		return false
+	}
+	c.localCaughtUp = true
+	c.Logger().Infof("%v: all %v vbs of this node have caught up", c.pipeline.FullTopic(), len(c.vbnos))
+	return true
+}
+
+// Only the node that owns VB 0 pauses the replication, so that it is paused once
+func (c *CaughtUpMonitor) pausesReplication() bool {
+	for _, vbno := range c.vbnos {
+		if vbno == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Raises ReplicationCaughtUp once every vb of the source bucket has caught up on one node or another
+func (c *CaughtUpMonitor) checkAllCaughtUp() bool {
+	if c.numOfSourceVbs == 0 {
+		numOfSourceVbs, err := c.getNumOfSourceVbs()
+		if err != nil {
+			c.Logger().Warnf("%v: unable to get the number of source vbs. err=%v", c.pipeline.FullTopic(), err)
+			return false
+		}
+		c.numOfSourceVbs = numOfSourceVbs
+	}
+
+	caughtUpVBs, err := c.checkpointsSvc.CaughtUpVBs(c.specInternalId)
+	if err != nil {
+		c.Logger().Warnf("%v: unable to get the vbs that have caught up. err=%v", c.pipeline.FullTopic(), err)
+		return false
+	}
+	waitingOn := vbsNotCaughtUpOnAnyNode(caughtUpVBs, c.numOfSourceVbs)
+	if len(waitingOn) > 0 {
+		c.Logger().Debugf("%v: waiting on %v vbs of other nodes to catch up", c.pipeline.FullTopic(), len(waitingOn))
+		return false
+	}
+
+	c.Logger().Infof("%v: all %v vbs of the source bucket have caught up", c.pipeline.FullTopic(), c.numOfSourceVbs)
+	err = c.checkpointsSvc.DelAllCaughtUpVBs(c.specInternalId)
+	if err != nil {
+		c.Logger().Warnf("%v: unable to clear the vbs that have caught up. err=%v", c.pipeline.FullTopic(), err)
+	}
+	c.RaiseEvent(common.NewEvent(common.ReplicationCaughtUp, nil, c, nil, nil))
+	return true
+}
+
+func (c *CaughtUpMonitor) getNumOfSourceVbs() (int, error) {
+	spec := c.pipeline.Specification().GetReplicationSpec()
+	notificationCh, err := c.bucketTopologySvc.SubscribeToLocalBucketFeed(spec, c.bucketTopSubscriberId)
+	if err != nil {
+		return 0, err
+	}
+	defer c.bucketTopologySvc.UnSubscribeLocalBucketFeed(spec, c.bucketTopSubscriberId)
+
+	select {
+	case notification := <-notificationCh:
+		defer notification.Recycle()
+		kvVbMap := notification.GetKvVbMapRO()
+		return len(kvVbMap.CompileLookupIndex()), nil
+	case <-c.finish_ch:
+		return 0, base.ErrorOpInterrupted
+	}
+}
+
+// A VB has caught up once its stream has ended and everything DCP sent before the stream end has been handled
+func vbsCaughtUp(vbnos []uint16, endSeqnos map[uint16]uint64, throughSeqnos map[uint16]uint64) (bool, []uint16) {
+	var waitingOn []uint16
+	for _, vbno := range vbnos {
+		endSeqno, ended := endSeqnos[vbno]
+		if !ended || throughSeqnos[vbno] < endSeqno {
+			waitingOn = append(waitingOn, vbno)
+		}
+	}
+	return len(waitingOn) == 0, waitingOn
+}
+
+// The vbs of the source bucket that have yet to catch up on any node
+func vbsNotCaughtUpOnAnyNode(caughtUpVBs []uint16, numOfSourceVbs int) []uint16 {
+	caughtUp := make(map[uint16]bool)
+	for _, vbno := range caughtUpVBs {
+		caughtUp[vbno] = true
+	}
+	var waitingOn []uint16
+	for vbno := uint16(0); int(vbno) < numOfSourceVbs; vbno++ {
+		if !caughtUp[vbno] {
+			waitingOn = append(waitingOn, vbno)
+		}
+	}
+	return waitingOn
+}
@@ -0,0 +1,50 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software
+will be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package pipeline_svc
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVBsCaughtUp(t *testing.T) {
+	fmt.Println("============== Test case start: TestVBsCaughtUp =================")
+	defer fmt.Println("============== Test case end: TestVBsCaughtUp =================")
+	assert := assert.New(t)
+
+	vbnos := []uint16{0, 1, 2}
+	endSeqnos := map[uint16]uint64{0: 10, 1: 0}
+	throughSeqnos := map[uint16]uint64{0: 5, 1: 0, 2: 20}
+
+	// vb 0 has not replicated what DCP sent, and vb 2 is still streaming
+	caughtUp, waitingOn := vbsCaughtUp(vbnos, endSeqnos, throughSeqnos)
+	assert.False(caughtUp)
+	assert.Equal([]uint16{0, 2}, waitingOn)
+
+	throughSeqnos[0] = 10
+	endSeqnos[2] = 15
+	caughtUp, waitingOn = vbsCaughtUp(vbnos, endSeqnos, throughSeqnos)
+	assert.True(caughtUp)
+	assert.Len(waitingOn, 0)
+}
+
+func TestVBsNotCaughtUpOnAnyNode(t *testing.T) {
+	fmt.Println("============== Test case start: TestVBsNotCaughtUpOnAnyNode =================")
+	defer fmt.Println("============== Test case end: TestVBsNotCaughtUpOnAnyNode =================")
+	assert := assert.New(t)
+
+	// vb 1 moved between nodes that both caught up on it
+	assert.Equal([]uint16{3}, vbsNotCaughtUpOnAnyNode([]uint16{0, 1, 1, 2}, 4))
+	assert.Len(vbsNotCaughtUpOnAnyNode([]uint16{3, 2, 1, 0}, 4), 0)
+	assert.Equal([]uint16{0, 1}, vbsNotCaughtUpOnAnyNode(nil, 2))
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software
+will be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package pipeline_svc
+
+import (
+	"fmt"
+
+	mcc "github.com/couchbase/gomemcached/client"
+	"github.com/couchbase/goxdcr/metadata"
+	"github.com/couchbase/goxdcr/service_def"
+)
+
+// Checkpoints exported from another cluster are validated the same way as the checkpoints pushed by peer nodes.
+// A record is only kept if its source failover UUID and its target VB UUID are still in the failover logs of the VB,
+// and the broken mappings it refers to have been exported along with it
+
+const importedCkptsKey = "import"
+
+// The imported checkpoints that have passed validation, along with what is needed to merge them into the stored ones
+type ImportedCheckpoints struct {
+	ckptDocs        map[uint16]*metadata.CheckpointsDoc
+	shaMap          metadata.ShaToCollectionNamespaceMap
+	srcFailoverLogs map[uint16]*mcc.FailoverLog
+	tgtFailoverLogs map[uint16]*mcc.FailoverLog
+}
+
+// Number of VBs that have at least one valid checkpoint
+func (imported *ImportedCheckpoints) Len() int {
+	var count int
+	for _, ckptDoc := range imported.ckptDocs {
+		if ckptDoc != nil && ckptDoc.Len() > 0 {
+			count++
+		}
+	}
+	return count
+}
+
+// ValidateImportedCheckpoints returns the imported checkpoints of the VBs that this node replicates
+func (ckmgr *CheckpointManager) ValidateImportedCheckpoints(ckptsExport *metadata.CheckpointsExport) (*ImportedCheckpoints, error) {
+	if ckptsExport == nil {
+		return nil, fmt.Errorf("nil checkpoints export")
+	}
+	spec := ckmgr.pipeline.Specification().GetReplicationSpec()
+	if ckptsExport.SourceBucketName != spec.SourceBucketName || ckptsExport.TargetBucketName != spec.TargetBucketName {
+		return nil, fmt.Errorf("Mismatch buckets - expecting %v to %v got %v to %v", spec.SourceBucketName,
+			spec.TargetBucketName, ckptsExport.SourceBucketName, ckptsExport.TargetBucketName)
+	}
+
+	// Records without a target VB opaque cannot be checked against the target failover logs
+	ckptDocs := make(map[uint16]*metadata.CheckpointsDoc)
+	for vbno, ckptDoc := range ckptsExport.CheckpointsDocs {
+		if ckptDoc == nil {
+			continue
+		}
+		ckptDocs[vbno] = ckptDoc.CloneWithoutRecords()
+		for _, record := range ckptDoc.Checkpoint_records {
+			if record != nil && record.Target_vb_opaque != nil {
+				ckptDocs[vbno].Checkpoint_records = append(ckptDocs[vbno].Checkpoint_records, record)
+			}
+		}
+	}
+
+	// Only the failover logs of the VBs that this node owns are returned
+	srcFailoverLogs, err := ckmgr.getOneTimeSrcFailoverLog()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get failoverlog from source %v", err)
+	}
+	filteredMap := filterInvalidCkptsBasedOnSourceFailover(map[string]map[uint16]*metadata.CheckpointsDoc{importedCkptsKey: ckptDocs}, srcFailoverLogs)
+
+	var vbnos []uint16
+	for vbno := range filteredMap {
+		vbnos = append(vbnos, vbno)
+	}
+	var tgtFailoverLogs map[uint16]*mcc.FailoverLog
+	if len(vbnos) > 0 {
+		tgtFailoverLogs, err = ckmgr.getOneTimeTgtFailoverLogs(vbnos)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get failoverlog from target(s) %v", err)
+		}
+		filteredMap = filterInvalidCkptsBasedOnTargetFailover(filteredMap, tgtFailoverLogs)
+	}
+
+	var brokenMappingsDocs []*metadata.CollectionNsMappingsDoc
+	if ckptsExport.BrokenMappingsDoc != nil {
+		brokenMappingsDocs = append(brokenMappingsDocs, ckptsExport.BrokenMappingsDoc)
+	}
+	filteredMap, shaMap, err := filterCkptsWithoutValidBrokenmaps(filteredMap, brokenMappingsDocs)
+	if err != nil {
+		return nil, err
+	}
+
+	imported := &ImportedCheckpoints{
+		ckptDocs:        filteredMap,
+		shaMap:          shaMap,
+		srcFailoverLogs: srcFailoverLogs,
+		tgtFailoverLogs: tgtFailoverLogs,
+	}
+	ckmgr.logger.Infof("%v imported checkpoints are valid for %v of %v VBs", ckmgr.pipeline.FullTopic(), imported.Len(),
+		len(ckptsExport.CheckpointsDocs))
+	return imported, nil
+}
+
+// Install merges the imported checkpoints into the stored ones, keeping the newest records of each VB, and persists
+// the broken mappings they refer to. It is meant to be called while the pipeline is stopped
+func (imported *ImportedCheckpoints) Install(checkpointsSvc service_def.CheckpointsService, spec *metadata.ReplicationSpecification) error {
+	currDocs, err := checkpointsSvc.CheckpointsDocs(spec.Id, true /*brokenMappingsNeeded*/)
+	if err == service_def.MetadataNotFoundErr {
+		currDocs = make(map[uint16]*metadata.CheckpointsDoc)
+	} else if err != nil {
+		return err
+	}
+
+	combinePeerCkptDocsWithLocalCkptDoc(imported.ckptDocs, imported.srcFailoverLogs, imported.tgtFailoverLogs, currDocs, spec)
+
+	shaMap, mappingDoc, _, _, err := checkpointsSvc.LoadBrokenMappings(spec.Id)
+	if err != nil {
+		return err
+	}
+	for sha, mapping := range imported.shaMap {
+		if _, exists := shaMap[sha]; !exists {
+			shaMap[sha] = mapping
+		}
+	}
+	if mappingDoc == nil {
+		mappingDoc = &metadata.CollectionNsMappingsDoc{SpecInternalId: spec.InternalId}
+	}
+	err = mappingDoc.LoadShaMap(shaMap)
+	if err != nil {
+		return err
+	}
+	err = checkpointsSvc.UpsertBrokenMappingsDoc(spec.Id, mappingDoc, currDocs, spec.InternalId)
+	if err != nil {
+		return err
+	}
+
+	_, err = checkpointsSvc.UpsertCheckpointsDoc(spec.Id, currDocs, spec.InternalId)
+	return err
+}
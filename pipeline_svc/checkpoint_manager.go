@@ -10,9 +10,11 @@ package pipeline_svc
 
 import (
 	"bytes"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"github.com/couchbase/goxdcr/peerToPeer"
+	"hash/fnv"
 	"math"
 	"math/rand"
 	"reflect"
@@ -54,6 +56,7 @@ type CheckpointMgrSvc interface {
 	CheckpointsExist(topic string) (bool, error)
 	DelSingleVBCheckpoint(topic string, vbno uint16) error
 	MergePeerNodesCkptInfo(genericResponse interface{}) error
+	ValidateImportedCheckpoints(ckptsExport *metadata.CheckpointsExport) (*ImportedCheckpoints, error)
 }
 
 type CheckpointManager struct {
@@ -139,6 +142,10 @@ type CheckpointManager struct {
 	backfillTsMtx      sync.RWMutex
 	backfillStartingTs map[uint16]*base.VBTimestamp
 	backfillEndingTs   map[uint16]*base.VBTimestamp
+	// When Checkpoint manager is attached to a main pipeline whose replication starts from a point in time,
+	// the VBs that have no checkpoint start from here instead of 0. A rollback erases the starting point of the VB
+	mainStartingTsMtx sync.RWMutex
+	mainStartingTs    map[uint16]*base.VBTimestamp
 
 	// Before a pipeline starts, prevent checkpoints from being created
 	// If a ckpt is created before old ones are loaded, it could lead to incorrect
@@ -169,6 +176,15 @@ type CheckpointManager struct {
 
 	bucketTopologySvc service_def.BucketTopologySvc
 
+	// File export replications do not write to the target bucket, so there is no target
+	// vbucket state to retrieve or to validate checkpoints against
+	isFileExport bool
+
+	// With target key rewriting, the docs of a source VB can land on any target VB. The checkpoints then carry a digest
+	// of the vbuuids of all of the target VBs as their target vb opaque, taken when the pipeline started
+	keyRewriteTargetVBOpaque    metadata.TargetVBOpaque
+	keyRewriteTargetVBOpaqueMtx sync.RWMutex
+
 	// Only used to bypass all the un-mockable RPC calls
 	unitTest bool
 }
@@ -348,6 +364,7 @@ func (ckmgr *CheckpointManager) Attach(pipeline common.Pipeline) error {
 		ckmgr.bpVbMtx.Unlock()
 	}
 	ckmgr.srcBucketName = ckmgr.pipeline.Specification().GetReplicationSpec().SourceBucketName
+	ckmgr.isFileExport = ckmgr.pipeline.Specification().GetReplicationSpec().Settings.IsFileExport()
 
 	//populate the remote bucket information at the time of attaching
 	err := ckmgr.populateRemoteBucketInfo(pipeline)
@@ -412,6 +429,20 @@ func (ckmgr *CheckpointManager) populateBackfillStartingTs(spec *metadata.Backfi
 	return nil
 }
 
+func (ckmgr *CheckpointManager) getMainStartingTs(vbno uint16) (*base.VBTimestamp, bool) {
+	ckmgr.mainStartingTsMtx.RLock()
+	defer ckmgr.mainStartingTsMtx.RUnlock()
+	startingTs, exists := ckmgr.mainStartingTs[vbno]
+	return startingTs, exists
+}
+
+// The source is unable to stream from the starting point of the VB. Fall back to the checkpoints, or to 0
+func (ckmgr *CheckpointManager) clearMainStartingTs(vbno uint16) {
+	ckmgr.mainStartingTsMtx.Lock()
+	defer ckmgr.mainStartingTsMtx.Unlock()
+	delete(ckmgr.mainStartingTs, vbno)
+}
+
 // When rolling back, if rollback is requesting a seqno that is earlier than the backfill task, then
 // allow that to happen by removing the startingTs
 func (ckmgr *CheckpointManager) clearBackfillStartingTsIfNeeded(vbno uint16, rollbackSeqno uint64) {
@@ -439,7 +470,7 @@ func (ckmgr *CheckpointManager) clearBackfillStartingTsIfNeeded(vbno uint16, rol
 }
 
 func (ckmgr *CheckpointManager) populateRemoteBucketInfo(pipeline common.Pipeline) error {
-	if ckmgr.unitTest {
+	if ckmgr.unitTest || ckmgr.isFileExport {
 		return nil
 	}
 
@@ -545,6 +576,10 @@ func (ckmgr *CheckpointManager) composeUserAgent() {
 func (ckmgr *CheckpointManager) initConnections() error {
 	ckmgr.initConnOnce.Do(func() {
 		defer close(ckmgr.initConnDone)
+		if ckmgr.isFileExport {
+			// target_kv_vb_map is empty and there is nothing to connect to
+			return
+		}
 		if ckmgr.target_cluster_ref.IsFullEncryption() {
 			err := ckmgr.initSSLConStrMap()
 			if err != nil {
@@ -668,6 +703,13 @@ func (ckmgr *CheckpointManager) getHighSeqnoAndVBUuidFromTarget(fin_ch chan bool
 
 	// A map of vbucketID -> slice of 2 elements of 1)HighSeqNo and 2)VbUuid in that order
 	high_seqno_and_vbuuid_map := make(map[uint16][]uint64)
+	if ckmgr.isFileExport {
+		// checkpoints of file export replications carry a target vbuuid of 0
+		for _, vbno := range ckmgr.getMyVBs() {
+			high_seqno_and_vbuuid_map[vbno] = []uint64{0, 0}
+		}
+		return high_seqno_and_vbuuid_map
+	}
 	for serverAddr, vbnos := range ckmgr.target_kv_vb_map {
 		ckmgr.getHighSeqnoAndVBUuidForServerWithRetry(serverAddr, vbnos, high_seqno_and_vbuuid_map, fin_ch)
 	}
@@ -717,6 +759,17 @@ func (ckmgr *CheckpointManager) getHighSeqnoAndVBUuidForServerWithRetry(serverAd
 	}
 }
 
+// For file export replications, a checkpoint is valid as long as it was created by a file export replication,
+// i.e., carries a target vbuuid of 0. Otherwise, the target has to agree on the checkpoint
+func (ckmgr *CheckpointManager) preReplicate(remote_vb_status *service_def.RemoteVBReplicationStatus) (bool, metadata.TargetVBOpaque, error) {
+	if ckmgr.isFileExport {
+		fileExportVBOpaque := &metadata.TargetVBUuid{0}
+		bMatch := remote_vb_status.VBOpaque != nil && fileExportVBOpaque.IsSame(remote_vb_status.VBOpaque)
+		return bMatch, fileExportVBOpaque, nil
+	}
+	return ckmgr.capi_svc.PreReplicate(ckmgr.remote_bucket, remote_vb_status, ckmgr.support_ckpt)
+}
+
 func (ckmgr *CheckpointManager) IsStopped() bool {
 	select {
 	case <-ckmgr.finish_ch:
@@ -1012,6 +1065,33 @@ func (ckmgr *CheckpointManager) SetVBTimestamps(topic string) error {
 		delete(ckptDocs, deleted_vbno)
 	}
 
+	if ckmgr.pipeline.Type() == common.MainPipeline {
+		var vbsWithoutCkpt []uint16
+		for _, vbno := range listOfVbs {
+			if ckptDocs[vbno] == nil {
+				vbsWithoutCkpt = append(vbsWithoutCkpt, vbno)
+			}
+		}
+		startingTs, err := ckmgr.getStartingTsFromSettings(vbsWithoutCkpt)
+		if err != nil {
+			ckmgr.logger.Errorf("Getting starting point for %v had error %v", ckmgr.pipeline.FullTopic(), err)
+			ckmgr.RaiseEvent(common.NewEvent(common.ErrorEncountered, nil, ckmgr, nil, err))
+			return err
+		}
+		ckmgr.mainStartingTsMtx.Lock()
+		ckmgr.mainStartingTs = startingTs
+		ckmgr.mainStartingTsMtx.Unlock()
+	}
+
+	if ckmgr.isKeyRewriting() {
+		err = ckmgr.initKeyRewriteTargetVBOpaque()
+		if err != nil {
+			ckmgr.logger.Errorf("Getting target vbuuids for %v had error %v", ckmgr.pipeline.FullTopic(), err)
+			ckmgr.RaiseEvent(common.NewEvent(common.ErrorEncountered, nil, ckmgr, nil, err))
+			return err
+		}
+	}
+
 	//divide the workload to several getter and run the getter parallelly
 	workload := 100
 	start_index := 0
@@ -1246,7 +1326,8 @@ func (ckmgr *CheckpointManager) startSeqnoGetter(getter_id int, listOfVbs []uint
 			err_ch <- err_info
 			return
 		}
-		if vbts.Seqno > 0 {
+		// Starting from a point in time is not resuming from a checkpoint, and needs no backfill
+		if _, startsFromPointInTime := ckmgr.getMainStartingTs(vbno); vbts.Seqno > 0 && !startsFromPointInTime {
 			atomic.CompareAndSwapUint32(vbtsStartedNon0, 0, 1)
 		}
 		if ckmgr.pipeline.Type() == common.MainPipeline {
@@ -1317,9 +1398,19 @@ func (ckmgr *CheckpointManager) getDataFromCkpts(vbno uint16, ckptDoc *metadata.
 			}
 		}
 
-		if remote_vb_status != nil {
+		if remote_vb_status != nil && ckmgr.isKeyRewriting() {
+			// a record without a target vb opaque is the internal one, which starts from scratch
+			current_remoteVBOpaque := ckmgr.getKeyRewriteTargetVBOpaque()
+			ckmgr.updateCurrentVBOpaque(vbno, current_remoteVBOpaque)
+			if remote_vb_status.VBOpaque == nil || current_remoteVBOpaque != nil && current_remoteVBOpaque.IsSame(remote_vb_status.VBOpaque) {
+				if ckptDoc != nil {
+					agreedIndex = index
+				}
+				goto POPULATE
+			}
+		} else if remote_vb_status != nil {
 			bMatch := false
-			bMatch, current_remoteVBOpaque, err := ckmgr.capi_svc.PreReplicate(ckmgr.remote_bucket, remote_vb_status, ckmgr.support_ckpt)
+			bMatch, current_remoteVBOpaque, err := ckmgr.preReplicate(remote_vb_status)
 
 			if err != nil {
 				if err == service_def.NoSupportForXDCRCheckpointingError {
@@ -1436,7 +1527,7 @@ func (ckmgr *CheckpointManager) populateDataFromCkptDoc(ckptDoc *metadata.Checkp
 	vbts := &base.VBTimestamp{Vbno: vbno}
 	var brokenMapping *metadata.CollectionNamespaceMapping
 	var targetManifestId uint64
-	var backfillBypassAgreedIndex bool
+	var bypassAgreedIndex bool
 	var lastSucccessfulBackfillMgrSrcManifestId uint64
 	if agreedIndex > -1 {
 		ckpt_records := ckptDoc.GetCheckpointRecords()
@@ -1472,13 +1563,19 @@ func (ckmgr *CheckpointManager) populateDataFromCkptDoc(ckptDoc *metadata.Checkp
 		startingTs, exists := ckmgr.backfillStartingTs[vbno]
 		ckmgr.backfillTsMtx.RUnlock()
 		if exists {
-			backfillBypassAgreedIndex = true
+			bypassAgreedIndex = true
 			vbts.Vbuuid = startingTs.Vbuuid
 			vbts.Seqno = startingTs.Seqno
 			vbts.SnapshotStart = startingTs.SnapshotStart
 			vbts.SnapshotEnd = startingTs.SnapshotEnd
 			vbts.ManifestIDs = startingTs.ManifestIDs
 		}
+	} else if startingTs, exists := ckmgr.getMainStartingTs(vbno); exists {
+		bypassAgreedIndex = true
+		vbts.Vbuuid = startingTs.Vbuuid
+		vbts.Seqno = startingTs.Seqno
+		vbts.SnapshotStart = startingTs.SnapshotStart
+		vbts.SnapshotEnd = startingTs.SnapshotEnd
 	}
 
 	//update current ckpt map
@@ -1488,7 +1585,7 @@ func (ckmgr *CheckpointManager) populateDataFromCkptDoc(ckptDoc *metadata.Checkp
 		defer obj.lock.Unlock()
 
 		//populate the next ckpt (in cur_ckpts)'s information based on the previous checkpoint information if it exists
-		if agreedIndex > -1 || backfillBypassAgreedIndex {
+		if agreedIndex > -1 || bypassAgreedIndex {
 			obj.ckpt.Failover_uuid = vbts.Vbuuid
 			obj.ckpt.Dcp_snapshot_seqno = vbts.SnapshotStart
 			obj.ckpt.Dcp_snapshot_end_seqno = vbts.SnapshotEnd
@@ -1961,6 +2058,59 @@ func (ckmgr *CheckpointManager) getThroughSeqno(vbno uint16, through_seqno_map m
 	return through_seqno, nil
 }
 
+func (ckmgr *CheckpointManager) isKeyRewriting() bool {
+	return !ckmgr.pipeline.Specification().GetReplicationSpec().Settings.GetTargetKeyRewriteRules().IsEmpty()
+}
+
+// With target key rewriting, the docs of a source VB are written to any number of target VBs, so the opaque of the
+// target VB with the same vbno cannot tell whether they are still on the target. A failover of any target VB
+// changes the digest instead, which invalidates the checkpoints taken before it
+func (ckmgr *CheckpointManager) initKeyRewriteTargetVBOpaque() error {
+	err := ckmgr.initConnections()
+	if err != nil {
+		return err
+	}
+	targetVBOpaque, err := targetVBUuidsDigest(ckmgr.target_kv_vb_map, ckmgr.getHighSeqnoAndVBUuidFromTarget(ckmgr.finish_ch))
+	if err != nil {
+		return err
+	}
+	ckmgr.keyRewriteTargetVBOpaqueMtx.Lock()
+	ckmgr.keyRewriteTargetVBOpaque = targetVBOpaque
+	ckmgr.keyRewriteTargetVBOpaqueMtx.Unlock()
+	return nil
+}
+
+func (ckmgr *CheckpointManager) getKeyRewriteTargetVBOpaque() metadata.TargetVBOpaque {
+	ckmgr.keyRewriteTargetVBOpaqueMtx.RLock()
+	defer ckmgr.keyRewriteTargetVBOpaqueMtx.RUnlock()
+	return ckmgr.keyRewriteTargetVBOpaque
+}
+
+// Returns a digest of the vbuuids of all of the target VBs, in vbno order
+func targetVBUuidsDigest(targetKvVbMap base.KvVBMapType, high_seqno_and_vbuuid_map map[uint16][]uint64) (metadata.TargetVBOpaque, error) {
+	var vbnos []uint16
+	for _, serverVBs := range targetKvVbMap {
+		vbnos = append(vbnos, serverVBs...)
+	}
+	if len(vbnos) == 0 {
+		return nil, errors.New("target has no vbuckets")
+	}
+	base.SortUint16List(vbnos)
+
+	digest := fnv.New64a()
+	buf := make([]byte, 10)
+	for _, vbno := range vbnos {
+		high_seqno_and_vbuuid, ok := high_seqno_and_vbuuid_map[vbno]
+		if !ok {
+			return nil, fmt.Errorf("cannot find vbuuid for target vb %v", vbno)
+		}
+		binary.BigEndian.PutUint16(buf[0:2], vbno)
+		binary.BigEndian.PutUint64(buf[2:10], high_seqno_and_vbuuid[1])
+		digest.Write(buf)
+	}
+	return &metadata.TargetVBUuid{digest.Sum64()}, nil
+}
+
 func (ckmgr *CheckpointManager) getRemoteSeqno(vbno uint16, high_seqno_and_vbuuid_map map[uint16][]uint64, curCkptTargetVBOpaque metadata.TargetVBOpaque) (uint64, error) {
 	// non-capi mode, high_seqno and vbuuid on target have been retrieved through vbucket-seqno stats
 	high_seqno_and_vbuuid, ok := high_seqno_and_vbuuid_map[vbno]
@@ -1970,7 +2120,14 @@ func (ckmgr *CheckpointManager) getRemoteSeqno(vbno uint16, high_seqno_and_vbuui
 
 	remote_seqno := high_seqno_and_vbuuid[0]
 	vbuuid := high_seqno_and_vbuuid[1]
-	targetVBOpaque := &metadata.TargetVBUuid{vbuuid}
+	var targetVBOpaque metadata.TargetVBOpaque = &metadata.TargetVBUuid{vbuuid}
+	if ckmgr.isKeyRewriting() {
+		var err error
+		targetVBOpaque, err = targetVBUuidsDigest(ckmgr.target_kv_vb_map, high_seqno_and_vbuuid_map)
+		if err != nil {
+			return 0, err
+		}
+	}
 	if !curCkptTargetVBOpaque.IsSame(targetVBOpaque) {
 		ckmgr.logger.Errorf("%v %v target vbuuid has changed for vb=%v. old=%v, new=%v", ckmgr.pipeline.Type().String(), ckmgr.pipeline.FullTopic(), vbno, curCkptTargetVBOpaque, targetVBOpaque)
 		return 0, targetVbuuidChangedError
@@ -2252,6 +2409,8 @@ func (ckmgr *CheckpointManager) UpdateVBTimestamps(vbno uint16, rollbackseqno ui
 
 	if ckmgr.pipeline.Type() == common.BackfillPipeline {
 		ckmgr.clearBackfillStartingTsIfNeeded(vbno, rollbackseqno)
+	} else {
+		ckmgr.clearMainStartingTs(vbno)
 	}
 
 	checkpointDoc, err := ckmgr.retrieveCkptDoc(vbno)
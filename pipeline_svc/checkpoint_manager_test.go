@@ -155,6 +155,33 @@ func TestCheckpointSyncHelper(t *testing.T) {
 	assert.Len(helper.ongoingOps, 0)
 }
 
+func TestTargetVBUuidsDigest(t *testing.T) {
+	fmt.Println("============== Test case start: TestTargetVBUuidsDigest =================")
+	defer fmt.Println("============== Test case end: TestTargetVBUuidsDigest =================")
+	assert := assert.New(t)
+
+	targetKvVbMap := base.KvVBMapType{"kv1": []uint16{0, 2}, "kv2": []uint16{1, 3}}
+	highSeqnoAndVbuuids := map[uint16][]uint64{0: {10, 100}, 1: {10, 101}, 2: {10, 102}, 3: {10, 103}}
+	digest, err := targetVBUuidsDigest(targetKvVbMap, highSeqnoAndVbuuids)
+	assert.Nil(err)
+
+	// high seqnos moving along do not change the digest
+	highSeqnoAndVbuuids[2] = []uint64{20, 102}
+	sameDigest, err := targetVBUuidsDigest(targetKvVbMap, highSeqnoAndVbuuids)
+	assert.Nil(err)
+	assert.True(digest.IsSame(sameDigest))
+
+	// a failover of any of the target VBs does
+	highSeqnoAndVbuuids[3] = []uint64{5, 203}
+	failedOverDigest, err := targetVBUuidsDigest(targetKvVbMap, highSeqnoAndVbuuids)
+	assert.Nil(err)
+	assert.False(digest.IsSame(failedOverDigest))
+
+	delete(highSeqnoAndVbuuids, 1)
+	_, err = targetVBUuidsDigest(targetKvVbMap, highSeqnoAndVbuuids)
+	assert.NotNil(err)
+}
+
 func TestMergeNoConsensusCkpt(t *testing.T) {
 	fmt.Println("============== Test case start: TestMergeNoConsensusCkpt =================")
 	defer fmt.Println("============== Test case end: TestMergeNoConsensusCkpt =================")
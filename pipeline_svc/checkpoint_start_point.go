@@ -0,0 +1,189 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software
+will be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package pipeline_svc
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	mc "github.com/couchbase/gomemcached"
+	mcc "github.com/couchbase/gomemcached/client"
+	"github.com/couchbase/goxdcr/base"
+)
+
+// A main pipeline whose replication starts from a point in time streams the VBs that have no checkpoints from the
+// start point instead of from seqno 0. The start point is either given as a seqno per VB, or found by scanning the
+// source VBs for the first document changed at or after the start time
+
+// Returns the timestamps that the given VBs start streaming from, or nil if the replication does not start from a
+// point in time. VBs that are missing from the returned map start from seqno 0
+func (ckmgr *CheckpointManager) getStartingTsFromSettings(vbnos []uint16) (map[uint16]*base.VBTimestamp, error) {
+	settings := ckmgr.pipeline.Specification().GetReplicationSpec().Settings
+	startTime := settings.GetStartFromTime()
+	startSeqnos := settings.GetStartFromSeqnos()
+	if len(vbnos) == 0 || startTime.IsZero() && startSeqnos == nil {
+		return nil, nil
+	}
+
+	memcachedAddr, err := ckmgr.xdcr_topology_svc.MyMemcachedAddr()
+	if err != nil {
+		return nil, err
+	}
+	client, err := ckmgr.utils.GetMemcachedConnection(memcachedAddr, ckmgr.srcBucketName, ckmgr.user_agent, 0, ckmgr.logger)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	// A VB cannot stream from beyond its high seqno
+	var statsMap map[string]string
+	highSeqnosPtr, _, _, err := ckmgr.utils.GetHighSeqNos(vbnos, client, &statsMap, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	highSeqnos := *highSeqnosPtr
+
+	feed, err := client.NewUprFeedWithConfigIface(true /*ackByClient*/)
+	if err != nil {
+		return nil, err
+	}
+	feedName, err := base.GenerateRandomId(base.LengthOfRandomId, base.MaxRetryForRandomIdGeneration)
+	if err != nil {
+		return nil, err
+	}
+	// gomemcached does not expose the DCP no-value open flag, so the scan streams the values along with the CAS
+	err = feed.UprOpen(ckmgr.user_agent+":"+feedName, uint32(0), base.UprFeedBufferSize)
+	if err != nil {
+		return nil, err
+	}
+	defer feed.Close()
+	failoverLogs, err := client.UprGetFailoverLog(vbnos)
+	if err != nil {
+		return nil, err
+	}
+
+	if !startTime.IsZero() {
+		startSeqnos, err = ckmgr.scanForStartSeqnos(feed, vbnos, startTime, highSeqnos, failoverLogs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	startingTs := make(map[uint16]*base.VBTimestamp)
+	for _, vbno := range vbnos {
+		seqno, exists := startSeqnos[vbno]
+		if !exists || seqno == 0 {
+			continue
+		}
+		if highSeqno, exists := highSeqnos[vbno]; exists && seqno > highSeqno {
+			seqno = highSeqno
+		}
+		failoverLog, exists := failoverLogs[vbno]
+		if !exists || failoverLog == nil {
+			return nil, fmt.Errorf("unable to find failover log for vb %v", vbno)
+		}
+		vbts, err := startingTsFromSeqno(vbno, seqno, *failoverLog)
+		if err != nil {
+			return nil, err
+		}
+		startingTs[vbno] = vbts
+	}
+	ckmgr.logger.Infof("%v %v starting %v of %v VBs from a point in time", ckmgr.pipeline.Type().String(),
+		ckmgr.pipeline.FullTopic(), len(startingTs), len(vbnos))
+	return startingTs, nil
+}
+
+// Streams the given VBs up to their high seqnos and returns the seqno that each VB starts streaming after, so that
+// the first document changed at or after startTime is streamed. DCP streams only the latest version of each document,
+// in seqno order, and the CAS of the documents written locally increases along with the seqnos of a VB. So every
+// document that has changed since startTime is at or after the first one found.
+// Documents that are replicated into the bucket, i.e. by XDCR, keep the CAS of their source. On such buckets the CAS
+// is not monotonic in seqno order, and a document that arrived after startTime with an older CAS is skipped if it is
+// before the first one found. Such buckets should start from seqnos instead
+func (ckmgr *CheckpointManager) scanForStartSeqnos(feed mcc.UprFeedIface, vbnos []uint16, startTime time.Time, highSeqnos map[uint16]uint64, failoverLogs map[uint16]*mcc.FailoverLog) (map[uint16]uint64, error) {
+	startCas := uint64(startTime.UnixNano())
+	startSeqnos := make(map[uint16]uint64)
+	scanning := make(map[uint16]bool)
+
+	feed.StartFeedWithConfig(base.UprFeedDataChanLength)
+	for _, vbno := range vbnos {
+		failoverLog, exists := failoverLogs[vbno]
+		if highSeqnos[vbno] == 0 || !exists || failoverLog == nil || len(*failoverLog) == 0 {
+			// nothing to skip
+			continue
+		}
+		err := feed.UprRequestStream(vbno, vbno, 0, (*failoverLog)[0][0], 0, highSeqnos[vbno], 0, 0)
+		if err != nil {
+			return nil, fmt.Errorf("stream request for vb %v failed with err %v", vbno, err)
+		}
+		scanning[vbno] = true
+	}
+
+	ckmgr.logger.Infof("%v %v scanning %v VBs for the first seqnos at or after %v", ckmgr.pipeline.Type().String(),
+		ckmgr.pipeline.FullTopic(), len(scanning), startTime)
+	timer := time.NewTimer(base.StartFromTimeScanTimeout)
+	defer timer.Stop()
+	eventCh := feed.GetUprEventCh()
+	for len(scanning) > 0 {
+		select {
+		case <-ckmgr.finish_ch:
+			return nil, ckptMgrStopped
+		case <-timer.C:
+			return nil, fmt.Errorf("timed out scanning %v VBs for the first seqnos at or after %v", len(scanning), startTime)
+		case m, ok := <-eventCh:
+			if !ok {
+				return nil, errors.New("DCP feed has been closed")
+			}
+			err := feed.ClientAck(m)
+			if err != nil {
+				return nil, err
+			}
+			if !scanning[m.VBucket] {
+				continue
+			}
+			switch m.Opcode {
+			case mc.UPR_STREAMREQ:
+				if m.Status != mc.SUCCESS {
+					return nil, fmt.Errorf("stream request for vb %v failed with status %v", m.VBucket, m.Status)
+				}
+			case mc.UPR_MUTATION, mc.UPR_DELETION, mc.UPR_EXPIRATION:
+				if m.Cas >= startCas {
+					startSeqnos[m.VBucket] = m.Seqno - 1
+					delete(scanning, m.VBucket)
+					feed.CloseStream(m.VBucket, m.VBucket)
+				}
+			case mc.UPR_STREAMEND:
+				// nothing has changed since startTime
+				startSeqnos[m.VBucket] = highSeqnos[m.VBucket]
+				delete(scanning, m.VBucket)
+			}
+		}
+	}
+	return startSeqnos, nil
+}
+
+// Returns the timestamp that streams a VB after the given seqno. The vbuuid is the one of the newest failover log
+// entry that the seqno falls in. The failover log is ordered from the newest entry to the oldest one
+func startingTsFromSeqno(vbno uint16, seqno uint64, failoverLog mcc.FailoverLog) (*base.VBTimestamp, error) {
+	for _, vbuuidSeqnoPair := range failoverLog {
+		if vbuuidSeqnoPair[1] <= seqno {
+			return &base.VBTimestamp{
+				Vbno:          vbno,
+				Vbuuid:        vbuuidSeqnoPair[0],
+				Seqno:         seqno,
+				SnapshotStart: seqno,
+				SnapshotEnd:   seqno,
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("seqno %v of vb %v is older than its failover log", seqno, vbno)
+}
@@ -0,0 +1,45 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software
+will be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package pipeline_svc
+
+import (
+	"fmt"
+	"testing"
+
+	mcc "github.com/couchbase/gomemcached/client"
+	"github.com/couchbase/goxdcr/base"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStartingTsFromSeqno(t *testing.T) {
+	fmt.Println("============== Test case start: TestStartingTsFromSeqno =================")
+	defer fmt.Println("============== Test case end: TestStartingTsFromSeqno =================")
+	assert := assert.New(t)
+
+	// newest entry first
+	failoverLog := mcc.FailoverLog{{300, 500}, {200, 100}, {100, 0}}
+
+	vbts, err := startingTsFromSeqno(3, 800, failoverLog)
+	assert.Nil(err)
+	assert.Equal(base.VBTimestamp{Vbno: 3, Vbuuid: 300, Seqno: 800, SnapshotStart: 800, SnapshotEnd: 800}, *vbts)
+
+	vbts, err = startingTsFromSeqno(3, 250, failoverLog)
+	assert.Nil(err)
+	assert.Equal(uint64(200), vbts.Vbuuid)
+
+	vbts, err = startingTsFromSeqno(3, 50, failoverLog)
+	assert.Nil(err)
+	assert.Equal(uint64(100), vbts.Vbuuid)
+
+	// the oldest entries of the failover log have been dropped
+	_, err = startingTsFromSeqno(3, 50, failoverLog[:2])
+	assert.NotNil(err)
+}
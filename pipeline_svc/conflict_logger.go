@@ -0,0 +1,429 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software
+will be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package pipeline_svc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/cbauth"
+	mc "github.com/couchbase/gomemcached"
+	mcc "github.com/couchbase/gomemcached/client"
+	"github.com/couchbase/goxdcr/base"
+	"github.com/couchbase/goxdcr/common"
+	component "github.com/couchbase/goxdcr/component"
+	"github.com/couchbase/goxdcr/log"
+	"github.com/couchbase/goxdcr/metadata"
+	"github.com/couchbase/goxdcr/service_def"
+	utilities "github.com/couchbase/goxdcr/utils"
+	"github.com/golang/snappy"
+)
+
+var conflictLoggerIterationId uint32
+
+// One line in the conflict log file, or one document in the conflict log collection
+type conflictLogEntry struct {
+	Timestamp     string              `json:"timestamp"`
+	ReplicationId string              `json:"replicationId"`
+	Key           string              `json:"key"`
+	Scope         string              `json:"scope"`
+	Collection    string              `json:"collection"`
+	VBucket       uint16              `json:"vb"`
+	Seqno         uint64              `json:"seqno"`
+	CRMode        string              `json:"crMode"`
+	Source        conflictLogDocEntry `json:"source"`
+	Target        conflictLogDocEntry `json:"target"`
+}
+
+type conflictLogDocEntry struct {
+	base.ConflictDocMeta
+	HasXattrs bool     `json:"hasXattrs"`
+	XattrKeys []string `json:"xattrKeys,omitempty"`
+	// Only one of the following is set for the source doc. Neither is set for the target
+	Doc       json.RawMessage `json:"doc,omitempty"`
+	DocBinary []byte          `json:"docBinary,omitempty"`
+}
+
+// ConflictLogger records source mutations that lose source side conflict resolution to the target,
+// which would otherwise only show up as docs_failed_cr_source.
+// XMEM hands the records over without blocking, and a single worker writes them to either a rotating
+// file in the XDCR log directory or to a collection in the source bucket
+type ConflictLogger struct {
+	*component.AbstractComponent
+	pipeline               common.Pipeline
+	top_svc                service_def.XDCRCompTopologySvc
+	collectionsManifestSvc service_def.CollectionsManifestSvc
+	bucketTopologySvc      service_def.BucketTopologySvc
+	utils                  utilities.UtilsIface
+	record_ch              chan *base.ConflictRecord
+	finish_ch              chan bool
+	wait_grp               sync.WaitGroup
+	started                uint32
+
+	replId string
+	dest   string
+
+	// file destination
+	fileWriter *log.RotatingLogFileWriter
+
+	// collection destination
+	sourceBucketName string
+	userAgent        string
+	destNamespace    base.CollectionNamespace
+	destColId        uint32
+	subscriberId     string
+	sourceNotifyCh   chan service_def.SourceNotification
+	numVbs           uint16
+	vbServerMap      map[uint16]string
+	clients          map[string]mcc.ClientIface
+	opaque           uint32
+
+	counter_logged  uint64
+	counter_dropped uint64
+}
+
+func NewConflictLogger(replId string, top_svc service_def.XDCRCompTopologySvc, collectionsManifestSvc service_def.CollectionsManifestSvc,
+	bucketTopologySvc service_def.BucketTopologySvc, utils utilities.UtilsIface) *ConflictLogger {
+	return &ConflictLogger{
+		AbstractComponent:      component.NewAbstractComponentWithLogger(replId, log.NewLogger("ConflictLogger", log.DefaultLoggerContext)),
+		top_svc:                top_svc,
+		collectionsManifestSvc: collectionsManifestSvc,
+		bucketTopologySvc:      bucketTopologySvc,
+		utils:                  utils,
+		replId:                 replId,
+		finish_ch:              make(chan bool, 1),
+		clients:                make(map[string]mcc.ClientIface),
+	}
+}
+
+func (c *ConflictLogger) Attach(pipeline common.Pipeline) error {
+	c.Logger().Infof("Attach conflictLogger with %v pipeline %v\n", pipeline.Type().String(), pipeline.FullTopic())
+	c.pipeline = pipeline
+	supervisor := c.pipeline.RuntimeContext().Service(base.PIPELINE_SUPERVISOR_SVC)
+	if supervisor == nil {
+		return errors.New("Pipeline supervisor not found")
+	}
+	return c.RegisterComponentEventListener(common.ErrorEncountered, supervisor.(*PipelineSupervisor))
+}
+
+func (c *ConflictLogger) Start(settingsMap metadata.ReplicationSettingsMap) (err error) {
+	spec := c.pipeline.Specification().GetReplicationSpec()
+	c.dest = spec.Settings.GetConflictLoggingDest()
+	if value, ok := settingsMap[base.ConflictLoggingDestKey]; ok {
+		c.dest = value.(string)
+	}
+	c.sourceBucketName = spec.SourceBucketName
+	c.userAgent = fmt.Sprintf("Goxdcr conflictLogger bucket: %s", c.sourceBucketName)
+
+	if c.dest == base.ConflictLoggingDestFile {
+		err = c.openFile()
+	} else {
+		err = c.initCollectionDest(spec)
+	}
+	if err != nil {
+		c.Logger().Errorf("%v: Failed to start conflictLogger with destination %q. err=%v", c.pipeline.FullTopic(), c.dest, err)
+		return err
+	}
+
+	c.record_ch = make(chan *base.ConflictRecord, base.ConflictLoggerQueueSize)
+	c.wait_grp.Add(1)
+	go c.run()
+	atomic.StoreUint32(&c.started, 1)
+	c.Logger().Infof("%v: ConflictLogger started with destination %q", c.pipeline.FullTopic(), c.dest)
+	return nil
+}
+
+func (c *ConflictLogger) Stop() error {
+	c.Logger().Infof("%v: ConflictLogger Stopping.", c.pipeline.FullTopic())
+	// record_ch is not closed since XMEM could still be sending. Log() checks finish_ch instead
+	atomic.StoreUint32(&c.started, 0)
+	close(c.finish_ch)
+	c.wait_grp.Wait()
+
+	if c.fileWriter != nil {
+		c.fileWriter.Close()
+	}
+	for _, client := range c.clients {
+		client.Close()
+	}
+	if c.sourceNotifyCh != nil {
+		c.bucketTopologySvc.UnSubscribeLocalBucketFeed(c.pipeline.Specification().GetReplicationSpec(), c.subscriberId)
+	}
+	c.Logger().Infof("%v: ConflictLogger stopped. logged=%v dropped=%v", c.pipeline.FullTopic(),
+		atomic.LoadUint64(&c.counter_logged), atomic.LoadUint64(&c.counter_dropped))
+	return nil
+}
+
+func (c *ConflictLogger) Detach(pipeline common.Pipeline) error {
+	return base.ErrorNotSupported
+}
+
+// Each pipeline writes to its own file so the service is not shared with backfill pipelines
+func (c *ConflictLogger) IsSharable() bool {
+	return false
+}
+
+// Changes to the conflict logging settings restart the pipeline
+func (c *ConflictLogger) UpdateSettings(settings metadata.ReplicationSettingsMap) error {
+	return nil
+}
+
+// Implements service_def.ConflictLoggerIface
+func (c *ConflictLogger) Log(record *base.ConflictRecord) {
+	if atomic.LoadUint32(&c.started) == 0 {
+		c.recordDropped()
+		return
+	}
+	select {
+	case c.record_ch <- record:
+	case <-c.finish_ch:
+		c.recordDropped()
+	default:
+		// Never hold up XMEM because the conflict log cannot keep up
+		c.recordDropped()
+	}
+}
+
+func (c *ConflictLogger) recordDropped() {
+	atomic.AddUint64(&c.counter_dropped, 1)
+	c.RaiseEvent(common.NewEvent(common.ConflictLogDropped, nil, c, nil, nil))
+}
+
+func (c *ConflictLogger) run() {
+	defer c.wait_grp.Done()
+	for {
+		select {
+		case <-c.finish_ch:
+			return
+		case notification := <-c.sourceNotifyCh:
+			// sourceNotifyCh is nil, and never selected, when logging to a file
+			c.updateVbServerMap(notification)
+		case record := <-c.record_ch:
+			err := c.write(record)
+			if err != nil {
+				c.Logger().Warnf("%v: Failed to write conflict record for doc %v%s%v. err=%v", c.pipeline.FullTopic(),
+					base.UdTagBegin, record.Key, base.UdTagEnd, err)
+				c.recordDropped()
+			} else {
+				atomic.AddUint64(&c.counter_logged, 1)
+				c.RaiseEvent(common.NewEvent(common.ConflictLogged, nil, c, nil, nil))
+			}
+		}
+	}
+}
+
+func (c *ConflictLogger) write(record *base.ConflictRecord) error {
+	entryBytes, err := json.Marshal(c.composeEntry(record))
+	if err != nil {
+		return err
+	}
+	if c.fileWriter != nil {
+		_, err = c.fileWriter.Write(append(entryBytes, '\n'))
+		return err
+	}
+	return c.writeToCollection(record, entryBytes)
+}
+
+func (c *ConflictLogger) composeEntry(record *base.ConflictRecord) *conflictLogEntry {
+	entry := &conflictLogEntry{
+		Timestamp:     record.Timestamp.Format(time.RFC3339Nano),
+		ReplicationId: c.replId,
+		Key:           string(record.Key),
+		Scope:         record.Namespace.ScopeName,
+		Collection:    record.Namespace.CollectionName,
+		VBucket:       record.VBucket,
+		Seqno:         record.Seqno,
+		CRMode:        conflictResolutionModeString(record.CRMode),
+		Source: conflictLogDocEntry{
+			ConflictDocMeta: record.Source,
+			HasXattrs:       record.Source.DataType&base.XattrDataType > 0,
+		},
+		Target: conflictLogDocEntry{
+			ConflictDocMeta: record.Target,
+			HasXattrs:       record.Target.DataType&base.XattrDataType > 0,
+		},
+	}
+
+	var err error
+	entry.Source.Doc, entry.Source.DocBinary, entry.Source.XattrKeys, err = decodeDocValue(record.Body, record.Source.DataType)
+	if err != nil {
+		c.Logger().Warnf("%v: Unable to decompress doc %v%s%v for conflict log. err=%v", c.pipeline.FullTopic(), base.UdTagBegin, record.Key, base.UdTagEnd, err)
+	}
+	return entry
+}
+
+// Splits a document value as sent over the memcached protocol into its body, which is either JSON or binary, and the
+// keys of its xattrs. A value that cannot be decompressed is returned as is in docBinary
+func decodeDocValue(body []byte, dataType uint8) (doc json.RawMessage, docBinary []byte, xattrKeys []string, err error) {
+	if dataType&base.SnappyDataType > 0 {
+		var decoded []byte
+		decoded, err = snappy.Decode(nil, body)
+		if err != nil {
+			docBinary = body
+			return
+		}
+		body = decoded
+	}
+	if dataType&base.XattrDataType > 0 {
+		if iterator, iterErr := base.NewXattrIterator(body); iterErr == nil {
+			for iterator.HasNext() {
+				key, _, iterErr := iterator.Next()
+				if iterErr != nil {
+					break
+				}
+				xattrKeys = append(xattrKeys, string(key))
+			}
+		}
+		if stripped, stripErr := base.StripXattrAndGetBody(body); stripErr == nil {
+			body = stripped
+		}
+	}
+	if len(body) > 0 {
+		if dataType&base.JSONDataType > 0 && json.Valid(body) {
+			doc = json.RawMessage(body)
+		} else {
+			docBinary = body
+		}
+	}
+	return
+}
+
+func conflictResolutionModeString(mode base.ConflictResolutionMode) string {
+	switch mode {
+	case base.CRMode_LWW:
+		return base.ConflictResolutionType_Lww
+	case base.CRMode_Custom:
+		return base.ConflictResolutionType_Custom
+	default:
+		return base.ConflictResolutionType_Seqno
+	}
+}
+
+func (c *ConflictLogger) openFile() (err error) {
+	fileName := base.ConflictLogFilePrefix + strings.Replace(c.pipeline.FullTopic(), "/", "_", -1) + base.ConflictLogFileSuffix
+	c.fileWriter, err = log.NewRotatingLogFileWriterInLogDir(fileName)
+	return
+}
+
+func (c *ConflictLogger) initCollectionDest(spec *metadata.ReplicationSpecification) error {
+	namespace, err := base.NewCollectionNamespaceFromString(c.dest)
+	if err != nil {
+		return err
+	}
+	c.destNamespace = namespace
+
+	srcManifest, _, err := c.collectionsManifestSvc.GetLatestManifests(spec, false)
+	if err != nil {
+		return err
+	}
+	c.destColId, err = srcManifest.GetCollectionId(namespace.ScopeName, namespace.CollectionName)
+	if err != nil {
+		return fmt.Errorf("conflict log collection %v does not exist in source bucket %v", c.dest, c.sourceBucketName)
+	}
+
+	c.subscriberId = fmt.Sprintf("%v_%v_%v_%v", "conflictLogger", c.pipeline.Type().String(), c.pipeline.InstanceId(), base.GetIterationId(&conflictLoggerIterationId))
+	c.sourceNotifyCh, err = c.bucketTopologySvc.SubscribeToLocalBucketFeed(spec, c.subscriberId)
+	if err != nil {
+		return err
+	}
+	select {
+	case notification := <-c.sourceNotifyCh:
+		c.updateVbServerMap(notification)
+	case <-time.After(base.TimeoutRuntimeContextStart):
+		c.bucketTopologySvc.UnSubscribeLocalBucketFeed(spec, c.subscriberId)
+		c.sourceNotifyCh = nil
+		return fmt.Errorf("timed out waiting for topology of source bucket %v", c.sourceBucketName)
+	}
+	return nil
+}
+
+func (c *ConflictLogger) updateVbServerMap(notification service_def.SourceNotification) {
+	defer notification.Recycle()
+	kvVbMap := notification.GetKvVbMapRO()
+	c.vbServerMap = kvVbMap.CompileLookupIndex()
+	c.numVbs = uint16(len(c.vbServerMap))
+}
+
+// the conflict record is stored under its own key, which may live in a vbucket owned by another node
+func (c *ConflictLogger) writeToCollection(record *base.ConflictRecord, value []byte) error {
+	key := []byte(fmt.Sprintf("%v%v_%v_%v", base.ConflictLogFilePrefix, record.VBucket, record.Seqno, record.Source.Cas))
+	if c.numVbs == 0 {
+		return errors.New("source bucket topology is not available")
+	}
+	vbno := uint16((crc32.ChecksumIEEE(key)>>16)&0x7fff) % c.numVbs
+	server, ok := c.vbServerMap[vbno]
+	if !ok {
+		return fmt.Errorf("unable to find the server for vb %v", vbno)
+	}
+	client, err := c.getClient(server)
+	if err != nil {
+		return err
+	}
+
+	leb128Cid, _, err := base.NewUleb128(c.destColId, nil, true)
+	if err != nil {
+		return err
+	}
+	// JSON is not negotiated in the HELO, so the datatype is left raw and KV detects the JSON itself
+	req := &mc.MCRequest{
+		Opcode:   mc.SET,
+		VBucket:  vbno,
+		Key:      append(append([]byte{}, leb128Cid...), key...),
+		Extras:   make([]byte, 8), // flags and expiry are 0
+		Body:     value,
+		DataType: 0,
+		Opaque:   atomic.AddUint32(&c.opaque, 1),
+	}
+
+	err = client.Transmit(req)
+	if err == nil {
+		var resp *mc.MCResponse
+		resp, err = client.Receive()
+		if err == nil && resp.Status != mc.SUCCESS {
+			return fmt.Errorf("received status %v from %v", resp.Status, server)
+		}
+	}
+	if err != nil {
+		// The connection is in an unknown state. Get a new one next time
+		client.Close()
+		delete(c.clients, server)
+	}
+	return err
+}
+
+func (c *ConflictLogger) getClient(server string) (mcc.ClientIface, error) {
+	if client, ok := c.clients[server]; ok {
+		return client, nil
+	}
+	username, password, err := cbauth.GetMemcachedServiceAuth(server)
+	if err != nil {
+		return nil, err
+	}
+	client, err := c.utils.GetMemcachedRawConn(server, username, password, c.sourceBucketName, true /*plainAuth*/, 0 /*keepAlivePeriod*/, c.Logger())
+	if err != nil {
+		return nil, err
+	}
+	var features utilities.HELOFeatures
+	features.Collections = true
+	_, err = c.utils.SendHELOWithFeatures(client, c.userAgent, base.XmemReadTimeout, base.XmemWriteTimeout, features, c.Logger())
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	c.clients[server] = client
+	return client, nil
+}
@@ -306,7 +306,7 @@ func (c *ConflictManager) conflictManagerWorker(id int) {
 						DataMergeEventCommon: DataMergeEventCommon{
 							Seqno:       source.Seqno,
 							IsExpirySet: isExpirySet,
-							VBucket:     source.Req.VBucket,
+							VBucket:     source.GetSourceVBucket(),
 							ManifestId:  source.GetManifestId(),
 						},
 						Req_size: source.Req.Size(),
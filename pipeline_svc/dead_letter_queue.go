@@ -0,0 +1,726 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software
+will be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package pipeline_svc
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/cbauth"
+	mc "github.com/couchbase/gomemcached"
+	mcc "github.com/couchbase/gomemcached/client"
+	"github.com/couchbase/goxdcr/base"
+	"github.com/couchbase/goxdcr/common"
+	component "github.com/couchbase/goxdcr/component"
+	"github.com/couchbase/goxdcr/log"
+	"github.com/couchbase/goxdcr/metadata"
+	"github.com/couchbase/goxdcr/service_def"
+	utilities "github.com/couchbase/goxdcr/utils"
+)
+
+var deadLetterQueueIterationId uint32
+
+// One line in a dead letter file, or one document in a dead letter collection
+type deadLetterEntry struct {
+	Timestamp     string `json:"timestamp"`
+	ReplicationId string `json:"replicationId"`
+	Key           string `json:"key"`
+	TargetKey     string `json:"targetKey,omitempty"`
+	Scope         string `json:"scope"`
+	Collection    string `json:"collection"`
+	VBucket       uint16 `json:"vb"`
+	Seqno         uint64 `json:"seqno"`
+	Cas           uint64 `json:"cas"`
+	Opcode        string `json:"opcode"`
+	Status        string `json:"status"`
+	StatusCode    uint16 `json:"statusCode"`
+	HasXattrs     bool   `json:"hasXattrs"`
+	// Only set when deadLetterIncludeBody is on. At most one of Doc and DocBinary is set
+	XattrKeys []string        `json:"xattrKeys,omitempty"`
+	Doc       json.RawMessage `json:"doc,omitempty"`
+	DocBinary []byte          `json:"docBinary,omitempty"`
+}
+
+type DataDeadLetteredEventAdditional struct {
+	Seqno      uint64
+	VBucket    uint16
+	ManifestId uint64
+}
+
+// DeadLetterQueue takes the source mutations that the target rejected for good, i.e. because they are too big or
+// have invalid xattrs, or because their target collection is still unknown after XMEM's retries, so that XMEM does
+// not keep resending them. A single worker writes them to an append-only file
+// in the XDCR log directory or to a collection in the source or target bucket.
+// DataDeadLettered is only raised once a record is written, which is when the through seqno of its VB moves past it.
+// If a record cannot be written, the pipeline is restarted so that the mutation is sent again
+type DeadLetterQueue struct {
+	*component.AbstractComponent
+	pipeline               common.Pipeline
+	remoteClusterSvc       service_def.RemoteClusterSvc
+	collectionsManifestSvc service_def.CollectionsManifestSvc
+	bucketTopologySvc      service_def.BucketTopologySvc
+	uiLogSvc               service_def.UILogSvc
+	utils                  utilities.UtilsIface
+	record_ch              chan *base.DeadLetterRecord
+	finish_ch              chan bool
+	wait_grp               sync.WaitGroup
+	started                uint32
+
+	replId      string
+	dest        string
+	includeBody bool
+
+	// file destination
+	file *os.File
+
+	// collection destination
+	isTargetDest   bool
+	bucketName     string
+	userAgent      string
+	destNamespace  base.CollectionNamespace
+	destColId      uint32
+	subscriberId   string
+	sourceNotifyCh chan service_def.SourceNotification
+	targetNotifyCh chan service_def.TargetNotification
+	numVbs         uint16
+	vbServerMap    map[uint16]string
+	clients        map[string]mcc.ClientIface
+	opaque         uint32
+
+	// target collection destination
+	targetRef      *metadata.RemoteClusterReference
+	targetUsername string
+	targetPassword string
+	sslConStrMap   map[string]string
+
+	counter_dead_lettered uint64
+	counter_write_failed  uint64
+}
+
+func NewDeadLetterQueue(replId string, remoteClusterSvc service_def.RemoteClusterSvc, collectionsManifestSvc service_def.CollectionsManifestSvc,
+	bucketTopologySvc service_def.BucketTopologySvc, uiLogSvc service_def.UILogSvc, utils utilities.UtilsIface) *DeadLetterQueue {
+	return &DeadLetterQueue{
+		AbstractComponent:      component.NewAbstractComponentWithLogger(replId, log.NewLogger("DeadLetterQueue", log.DefaultLoggerContext)),
+		remoteClusterSvc:       remoteClusterSvc,
+		collectionsManifestSvc: collectionsManifestSvc,
+		bucketTopologySvc:      bucketTopologySvc,
+		uiLogSvc:               uiLogSvc,
+		utils:                  utils,
+		replId:                 replId,
+		finish_ch:              make(chan bool, 1),
+		clients:                make(map[string]mcc.ClientIface),
+	}
+}
+
+func (d *DeadLetterQueue) Attach(pipeline common.Pipeline) error {
+	d.Logger().Infof("Attach deadLetterQueue with %v pipeline %v\n", pipeline.Type().String(), pipeline.FullTopic())
+	d.pipeline = pipeline
+	supervisor := d.pipeline.RuntimeContext().Service(base.PIPELINE_SUPERVISOR_SVC)
+	if supervisor == nil {
+		return errors.New("Pipeline supervisor not found")
+	}
+	return d.RegisterComponentEventListener(common.ErrorEncountered, supervisor.(*PipelineSupervisor))
+}
+
+func (d *DeadLetterQueue) Start(settingsMap metadata.ReplicationSettingsMap) (err error) {
+	spec := d.pipeline.Specification().GetReplicationSpec()
+	d.dest = spec.Settings.GetDeadLetterDest()
+	d.includeBody = spec.Settings.GetDeadLetterIncludeBody()
+
+	if d.dest == base.DeadLetterDestFile {
+		err = d.openFile()
+	} else {
+		err = d.initCollectionDest(spec)
+	}
+	if err != nil {
+		d.Logger().Errorf("%v: Failed to start deadLetterQueue with destination %q. err=%v", d.pipeline.FullTopic(), d.dest, err)
+		return err
+	}
+
+	d.record_ch = make(chan *base.DeadLetterRecord, base.DeadLetterQueueSize)
+	d.wait_grp.Add(1)
+	go d.run()
+	atomic.StoreUint32(&d.started, 1)
+	d.Logger().Infof("%v: DeadLetterQueue started with destination %q", d.pipeline.FullTopic(), d.dest)
+	return nil
+}
+
+func (d *DeadLetterQueue) Stop() error {
+	d.Logger().Infof("%v: DeadLetterQueue Stopping.", d.pipeline.FullTopic())
+	// record_ch is not closed since XMEM could still be sending. Add() checks finish_ch instead.
+	// Records still in record_ch have not moved the through seqnos, so they are sent again when the pipeline restarts
+	atomic.StoreUint32(&d.started, 0)
+	close(d.finish_ch)
+	d.wait_grp.Wait()
+
+	if d.file != nil {
+		d.file.Close()
+	}
+	for _, client := range d.clients {
+		client.Close()
+	}
+	spec := d.pipeline.Specification().GetReplicationSpec()
+	if d.sourceNotifyCh != nil {
+		d.bucketTopologySvc.UnSubscribeLocalBucketFeed(spec, d.subscriberId)
+	}
+	if d.targetNotifyCh != nil {
+		d.bucketTopologySvc.UnSubscribeRemoteBucketFeed(spec, d.subscriberId)
+	}
+	d.Logger().Infof("%v: DeadLetterQueue stopped. deadLettered=%v writeFailed=%v", d.pipeline.FullTopic(),
+		atomic.LoadUint64(&d.counter_dead_lettered), atomic.LoadUint64(&d.counter_write_failed))
+	return nil
+}
+
+func (d *DeadLetterQueue) Detach(pipeline common.Pipeline) error {
+	return base.ErrorNotSupported
+}
+
+// Each pipeline writes to its own file so the service is not shared with backfill pipelines
+func (d *DeadLetterQueue) IsSharable() bool {
+	return false
+}
+
+// Changes to the dead letter settings restart the pipeline
+func (d *DeadLetterQueue) UpdateSettings(settings metadata.ReplicationSettingsMap) error {
+	return nil
+}
+
+// Implements service_def.DeadLetterQueueIface
+func (d *DeadLetterQueue) Add(record *base.DeadLetterRecord) bool {
+	if atomic.LoadUint32(&d.started) == 0 {
+		return false
+	}
+	select {
+	case d.record_ch <- record:
+		return true
+	case <-d.finish_ch:
+		return false
+	default:
+		return false
+	}
+}
+
+func (d *DeadLetterQueue) run() {
+	defer d.wait_grp.Done()
+	for {
+		select {
+		case <-d.finish_ch:
+			return
+		case notification := <-d.sourceNotifyCh:
+			// the notification channels are nil, and never selected, unless writing to a collection
+			d.updateVbServerMap(notification.GetKvVbMapRO())
+			notification.Recycle()
+		case notification := <-d.targetNotifyCh:
+			d.updateVbServerMap(notification.GetTargetServerVBMap())
+			notification.Recycle()
+		case record := <-d.record_ch:
+			err := d.write(record)
+			if err != nil {
+				atomic.AddUint64(&d.counter_write_failed, 1)
+				d.RaiseEvent(common.NewEvent(common.DeadLetterWriteFailed, nil, d, nil, nil))
+				d.handleGeneralError(fmt.Errorf("Failed to write dead letter record for vb %v seqno %v. err=%v", record.VBucket, record.Seqno, err))
+				continue
+			}
+			count := atomic.AddUint64(&d.counter_dead_lettered, 1)
+			additionalInfo := DataDeadLetteredEventAdditional{
+				Seqno:      record.Seqno,
+				VBucket:    record.VBucket,
+				ManifestId: record.ManifestId,
+			}
+			d.RaiseEvent(common.NewEvent(common.DataDeadLettered, nil, d, nil, additionalInfo))
+			if count == 1 || count%base.DeadLetterUILogInterval == 0 {
+				d.uiLogSvc.Write(fmt.Sprintf("Replication %v has written %v documents that the target rejected to dead letter destination %q since it started. The latest was rejected with status %v",
+					d.pipeline.FullTopic(), count, d.destDescription(), record.Status))
+			}
+		}
+	}
+}
+
+func (d *DeadLetterQueue) handleGeneralError(err error) {
+	d.Logger().Errorf("%v Raise error condition %v\n", d.pipeline.FullTopic(), err)
+	d.RaiseEvent(common.NewEvent(common.ErrorEncountered, nil, d, nil, err))
+}
+
+func (d *DeadLetterQueue) destDescription() string {
+	if d.file != nil {
+		return d.file.Name()
+	}
+	return d.dest
+}
+
+func (d *DeadLetterQueue) write(record *base.DeadLetterRecord) error {
+	entryBytes, err := json.Marshal(d.composeEntry(record))
+	if err != nil {
+		return err
+	}
+	if d.file != nil {
+		_, err = d.file.Write(append(entryBytes, '\n'))
+		return err
+	}
+	return d.writeToCollection(record, entryBytes)
+}
+
+func (d *DeadLetterQueue) composeEntry(record *base.DeadLetterRecord) *deadLetterEntry {
+	entry := &deadLetterEntry{
+		Timestamp:     record.Timestamp.Format(time.RFC3339Nano),
+		ReplicationId: d.replId,
+		Key:           string(record.Key),
+		Scope:         record.Namespace.ScopeName,
+		Collection:    record.Namespace.CollectionName,
+		VBucket:       record.VBucket,
+		Seqno:         record.Seqno,
+		Cas:           record.Cas,
+		Opcode:        record.Opcode.String(),
+		Status:        record.Status.String(),
+		StatusCode:    uint16(record.Status),
+		HasXattrs:     record.DataType&base.XattrDataType > 0,
+	}
+	if string(record.TargetKey) != entry.Key {
+		entry.TargetKey = string(record.TargetKey)
+	}
+	if d.includeBody {
+		var err error
+		entry.Doc, entry.DocBinary, entry.XattrKeys, err = decodeDocValue(record.Body, record.DataType)
+		if err != nil {
+			d.Logger().Warnf("%v: Unable to decompress doc %v%s%v for dead letter entry. err=%v", d.pipeline.FullTopic(), base.UdTagBegin, record.Key, base.UdTagEnd, err)
+		}
+	}
+	return entry
+}
+
+func deadLetterFileName(fullTopic string) string {
+	return base.DeadLetterFilePrefix + strings.Replace(fullTopic, "/", "_", -1) + base.DeadLetterFileSuffix
+}
+
+// Dead letter files are not rotated since they are the only record of the documents that were not replicated
+func (d *DeadLetterQueue) openFile() error {
+	path, err := log.FilePathInLogDir(deadLetterFileName(d.pipeline.FullTopic()))
+	if err != nil {
+		return err
+	}
+	d.file, err = os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	return err
+}
+
+func (d *DeadLetterQueue) initCollectionDest(spec *metadata.ReplicationSpecification) error {
+	isTarget, namespace, err := base.ParseDeadLetterCollectionDest(d.dest)
+	if err != nil {
+		return err
+	}
+	d.isTargetDest = isTarget
+	d.destNamespace = namespace
+
+	srcManifest, tgtManifest, err := d.collectionsManifestSvc.GetLatestManifests(spec, false)
+	if err != nil {
+		return err
+	}
+	manifest := srcManifest
+	d.bucketName = spec.SourceBucketName
+	if d.isTargetDest {
+		manifest = tgtManifest
+		d.bucketName = spec.TargetBucketName
+	}
+	d.destColId, err = manifest.GetCollectionId(namespace.ScopeName, namespace.CollectionName)
+	if err != nil {
+		return fmt.Errorf("dead letter collection %v does not exist in bucket %v", namespace.ToIndexString(), d.bucketName)
+	}
+	d.userAgent = fmt.Sprintf("Goxdcr deadLetterQueue bucket: %s", d.bucketName)
+
+	d.subscriberId = fmt.Sprintf("%v_%v_%v_%v", "deadLetterQueue", d.pipeline.Type().String(), d.pipeline.InstanceId(), base.GetIterationId(&deadLetterQueueIterationId))
+	if !d.isTargetDest {
+		d.sourceNotifyCh, err = d.bucketTopologySvc.SubscribeToLocalBucketFeed(spec, d.subscriberId)
+		if err != nil {
+			return err
+		}
+		select {
+		case notification := <-d.sourceNotifyCh:
+			d.updateVbServerMap(notification.GetKvVbMapRO())
+			notification.Recycle()
+		case <-time.After(base.TimeoutRuntimeContextStart):
+			d.bucketTopologySvc.UnSubscribeLocalBucketFeed(spec, d.subscriberId)
+			d.sourceNotifyCh = nil
+			return fmt.Errorf("timed out waiting for topology of source bucket %v", d.bucketName)
+		}
+		return nil
+	}
+
+	d.targetRef, err = d.remoteClusterSvc.RemoteClusterByUuid(spec.TargetClusterUUID, false /*refresh*/)
+	if err != nil {
+		return err
+	}
+	d.targetUsername, d.targetPassword, _, _, _, _, _, err = d.targetRef.MyCredentials()
+	if err != nil {
+		return err
+	}
+	d.targetNotifyCh, err = d.bucketTopologySvc.SubscribeToRemoteBucketFeed(spec, d.subscriberId)
+	if err != nil {
+		return err
+	}
+	select {
+	case notification := <-d.targetNotifyCh:
+		d.updateVbServerMap(notification.GetTargetServerVBMap())
+		notification.Recycle()
+	case <-time.After(base.TimeoutRuntimeContextStart):
+		d.bucketTopologySvc.UnSubscribeRemoteBucketFeed(spec, d.subscriberId)
+		d.targetNotifyCh = nil
+		return fmt.Errorf("timed out waiting for topology of target bucket %v", d.bucketName)
+	}
+	return nil
+}
+
+func (d *DeadLetterQueue) updateVbServerMap(kvVbMap base.KvVBMapType) {
+	d.vbServerMap = kvVbMap.CompileLookupIndex()
+	d.numVbs = uint16(len(d.vbServerMap))
+}
+
+// the dead letter entry is stored under its own key, which may live in a vbucket owned by another node
+func (d *DeadLetterQueue) writeToCollection(record *base.DeadLetterRecord, value []byte) error {
+	key := []byte(fmt.Sprintf("%v%v_%v_%v", base.DeadLetterFilePrefix, record.VBucket, record.Seqno, record.Cas))
+	if d.numVbs == 0 {
+		return errors.New("bucket topology is not available")
+	}
+	vbno := uint16((crc32.ChecksumIEEE(key)>>16)&0x7fff) % d.numVbs
+	server, ok := d.vbServerMap[vbno]
+	if !ok {
+		return fmt.Errorf("unable to find the server for vb %v", vbno)
+	}
+	client, err := d.getClient(server)
+	if err != nil {
+		return err
+	}
+
+	leb128Cid, _, err := base.NewUleb128(d.destColId, nil, true)
+	if err != nil {
+		return err
+	}
+	// JSON is not negotiated in the HELO, so the datatype is left raw and KV detects the JSON itself
+	req := &mc.MCRequest{
+		Opcode:  mc.SET,
+		VBucket: vbno,
+		Key:     append(append([]byte{}, leb128Cid...), key...),
+		Extras:  make([]byte, 8), // flags and expiry are 0
+		Body:    value,
+		Opaque:  atomic.AddUint32(&d.opaque, 1),
+	}
+
+	err = client.Transmit(req)
+	if err == nil {
+		var resp *mc.MCResponse
+		resp, err = client.Receive()
+		if err == nil && resp.Status != mc.SUCCESS {
+			return fmt.Errorf("received status %v from %v", resp.Status, server)
+		}
+	}
+	if err != nil {
+		// The connection is in an unknown state. Get a new one next time
+		client.Close()
+		delete(d.clients, server)
+	}
+	return err
+}
+
+func (d *DeadLetterQueue) getClient(server string) (mcc.ClientIface, error) {
+	if client, ok := d.clients[server]; ok {
+		return client, nil
+	}
+	var client mcc.ClientIface
+	var err error
+	if d.isTargetDest {
+		client, err = d.getTargetClient(server)
+	} else {
+		client, err = getLocalMemcachedClient(server, d.bucketName, d.utils, d.Logger())
+	}
+	if err != nil {
+		return nil, err
+	}
+	var features utilities.HELOFeatures
+	features.Collections = true
+	_, err = d.utils.SendHELOWithFeatures(client, d.userAgent, base.XmemReadTimeout, base.XmemWriteTimeout, features, d.Logger())
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	d.clients[server] = client
+	return client, nil
+}
+
+func (d *DeadLetterQueue) getTargetClient(server string) (mcc.ClientIface, error) {
+	if !d.targetRef.IsFullEncryption() {
+		return d.utils.GetMemcachedRawConn(server, d.targetUsername, d.targetPassword, d.bucketName,
+			!d.targetRef.IsEncryptionEnabled() /*plainAuth*/, base.KeepAlivePeriod, d.Logger())
+	}
+
+	_, _, _, certificate, sanInCertificate, clientCertificate, clientKey, err := d.targetRef.MyCredentials()
+	if err != nil {
+		return nil, err
+	}
+	sslConStr, ok := d.sslConStrMap[server]
+	if !ok {
+		// the target topology may have changed since the ports were last retrieved
+		if err = d.initSSLConStrMap(); err != nil {
+			return nil, err
+		}
+		if sslConStr, ok = d.sslConStrMap[server]; !ok {
+			return nil, fmt.Errorf("Can't get remote memcached ssl port for %v", server)
+		}
+	}
+	return base.NewTLSConn(sslConStr, d.targetUsername, d.targetPassword, certificate, sanInCertificate, clientCertificate, clientKey, d.bucketName, d.Logger())
+}
+
+func (d *DeadLetterQueue) initSSLConStrMap() error {
+	connStr, err := d.targetRef.MyConnectionStr()
+	if err != nil {
+		return err
+	}
+	username, password, httpAuthMech, certificate, sanInCertificate, clientCertificate, clientKey, err := d.targetRef.MyCredentials()
+	if err != nil {
+		return err
+	}
+	useExternal, err := d.remoteClusterSvc.ShouldUseAlternateAddress(d.targetRef)
+	if err != nil {
+		return err
+	}
+	sslPortMap, err := d.utils.GetMemcachedSSLPortMap(connStr, username, password, httpAuthMech, certificate, sanInCertificate, clientCertificate, clientKey,
+		d.bucketName, d.Logger(), useExternal)
+	if err != nil {
+		return err
+	}
+	d.sslConStrMap = make(map[string]string)
+	for server, sslPort := range sslPortMap {
+		d.sslConStrMap[server] = base.GetHostAddr(base.GetHostName(server), sslPort)
+	}
+	return nil
+}
+
+func getLocalMemcachedClient(server, bucketName string, utils utilities.UtilsIface, logger *log.CommonLogger) (mcc.ClientIface, error) {
+	username, password, err := cbauth.GetMemcachedServiceAuth(server)
+	if err != nil {
+		return nil, err
+	}
+	return utils.GetMemcachedRawConn(server, username, password, bucketName, true /*plainAuth*/, 0 /*keepAlivePeriod*/, logger)
+}
+
+// Result of re-driving the dead letter files of a replication on this node
+type DeadLetterRedriveResult struct {
+	// Source documents that have been touched so that they are replicated again
+	Redriven int `json:"redriven"`
+	// Entries of documents that have been deleted or changed since, and are therefore replicated or gone already
+	Superseded int `json:"superseded"`
+	Failed     int `json:"failed"`
+}
+
+// RedriveDeadLetters goes through the dead letter files of both pipelines of the replication on this node and touches
+// every source document that is still the version that the target rejected. Touching a document gives it a new seqno,
+// which has it replicated again with its existing body and expiry.
+// The files are left as they are. Re-driving them again only touches the documents that have been dead lettered
+// again since, because the documents that were touched have a new cas
+func RedriveDeadLetters(spec *metadata.ReplicationSpecification, collectionsManifestSvc service_def.CollectionsManifestSvc,
+	bucketTopologySvc service_def.BucketTopologySvc, utils utilities.UtilsIface, logger *log.CommonLogger) (*DeadLetterRedriveResult, error) {
+	if spec.Settings.GetDeadLetterDest() != base.DeadLetterDestFile {
+		return nil, fmt.Errorf("dead letter entries can only be re-driven when %v is %q", base.DeadLetterDestKey, base.DeadLetterDestFile)
+	}
+
+	srcManifest, _, err := collectionsManifestSvc.GetLatestManifests(spec, false)
+	if err != nil {
+		return nil, err
+	}
+
+	subscriberId := fmt.Sprintf("%v_%v_%v", "deadLetterRedrive", spec.Id, base.GetIterationId(&deadLetterQueueIterationId))
+	notifyCh, err := bucketTopologySvc.SubscribeToLocalBucketFeed(spec, subscriberId)
+	if err != nil {
+		return nil, err
+	}
+	var vbServerMap map[uint16]string
+	select {
+	case notification := <-notifyCh:
+		kvVbMap := notification.GetKvVbMapRO()
+		vbServerMap = kvVbMap.CompileLookupIndex()
+		notification.Recycle()
+	case <-time.After(base.TimeoutRuntimeContextStart):
+	}
+	bucketTopologySvc.UnSubscribeLocalBucketFeed(spec, subscriberId)
+	if vbServerMap == nil {
+		return nil, fmt.Errorf("timed out waiting for topology of source bucket %v", spec.SourceBucketName)
+	}
+
+	redriver := &deadLetterRedriver{
+		bucketName:  spec.SourceBucketName,
+		userAgent:   fmt.Sprintf("Goxdcr deadLetterRedrive bucket: %s", spec.SourceBucketName),
+		manifest:    srcManifest,
+		vbServerMap: vbServerMap,
+		clients:     make(map[string]mcc.ClientIface),
+		utils:       utils,
+		logger:      logger,
+	}
+	defer redriver.close()
+
+	result := &DeadLetterRedriveResult{}
+	for _, fullTopic := range []string{spec.Id, common.ComposeFullTopic(spec.Id, common.BackfillPipeline)} {
+		path, err := log.FilePathInLogDir(deadLetterFileName(fullTopic))
+		if err != nil {
+			return nil, err
+		}
+		err = redriver.redriveFile(path, result)
+		if err != nil {
+			return result, err
+		}
+	}
+	logger.Infof("Re-drove dead letter entries of %v. redriven=%v superseded=%v failed=%v", spec.Id, result.Redriven, result.Superseded, result.Failed)
+	return result, nil
+}
+
+type deadLetterRedriver struct {
+	bucketName  string
+	userAgent   string
+	manifest    *metadata.CollectionsManifest
+	vbServerMap map[uint16]string
+	clients     map[string]mcc.ClientIface
+	opaque      uint32
+	utils       utilities.UtilsIface
+	logger      *log.CommonLogger
+}
+
+func (r *deadLetterRedriver) redriveFile(path string, result *DeadLetterRedriveResult) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	// the entries may include document bodies
+	scanner.Buffer(make([]byte, 64*1024), base.DeadLetterEntryMaxSize)
+	for scanner.Scan() {
+		var entry deadLetterEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			r.logger.Warnf("Skipping malformed dead letter entry in %v. err=%v", path, err)
+			result.Failed++
+			continue
+		}
+		redriven, err := r.redrive(&entry)
+		if err != nil {
+			r.logger.Warnf("Unable to re-drive dead letter entry for doc %v%s%v. err=%v", base.UdTagBegin, entry.Key, base.UdTagEnd, err)
+			result.Failed++
+		} else if redriven {
+			result.Redriven++
+		} else {
+			result.Superseded++
+		}
+	}
+	return scanner.Err()
+}
+
+// Returns false if the source document is no longer the version that was dead lettered
+func (r *deadLetterRedriver) redrive(entry *deadLetterEntry) (bool, error) {
+	colId, err := r.manifest.GetCollectionId(entry.Scope, entry.Collection)
+	if err != nil {
+		// The collection has been dropped, along with the document
+		return false, nil
+	}
+	server, ok := r.vbServerMap[entry.VBucket]
+	if !ok {
+		return false, fmt.Errorf("unable to find the server for vb %v", entry.VBucket)
+	}
+	client, err := r.getClient(server)
+	if err != nil {
+		return false, err
+	}
+	leb128Cid, _, err := base.NewUleb128(colId, nil, true)
+	if err != nil {
+		return false, err
+	}
+	key := append(append([]byte{}, leb128Cid...), []byte(entry.Key)...)
+
+	resp, err := r.send(server, client, &mc.MCRequest{Opcode: base.GET_WITH_META, VBucket: entry.VBucket, Key: key})
+	if err != nil {
+		return false, err
+	}
+	if resp.Status == mc.KEY_ENOENT {
+		return false, nil
+	} else if resp.Status != mc.SUCCESS {
+		return false, fmt.Errorf("received status %v for getMeta from %v", resp.Status, server)
+	} else if len(resp.Extras) < 12 {
+		return false, fmt.Errorf("received unexpected getMeta response from %v. extras=%v", server, resp.Extras)
+	}
+	deleted := binary.BigEndian.Uint32(resp.Extras[0:4]) != 0
+	if deleted || resp.Cas != entry.Cas {
+		return false, nil
+	}
+
+	// touch the document with the expiry it already has
+	extras := make([]byte, 4)
+	copy(extras, resp.Extras[8:12])
+	resp, err = r.send(server, client, &mc.MCRequest{Opcode: mc.TOUCH, VBucket: entry.VBucket, Key: key, Extras: extras})
+	if err != nil {
+		return false, err
+	}
+	if resp.Status == mc.KEY_ENOENT {
+		return false, nil
+	} else if resp.Status != mc.SUCCESS {
+		return false, fmt.Errorf("received status %v for touch from %v", resp.Status, server)
+	}
+	return true, nil
+}
+
+func (r *deadLetterRedriver) send(server string, client mcc.ClientIface, req *mc.MCRequest) (*mc.MCResponse, error) {
+	r.opaque++
+	req.Opaque = r.opaque
+	err := client.Transmit(req)
+	var resp *mc.MCResponse
+	if err == nil {
+		resp, err = client.Receive()
+		if err != nil && resp != nil && resp.Status != mc.SUCCESS {
+			// error statuses are returned as both a response and an error
+			err = nil
+		}
+	}
+	if err != nil {
+		client.Close()
+		delete(r.clients, server)
+	}
+	return resp, err
+}
+
+func (r *deadLetterRedriver) getClient(server string) (mcc.ClientIface, error) {
+	if client, ok := r.clients[server]; ok {
+		return client, nil
+	}
+	client, err := getLocalMemcachedClient(server, r.bucketName, r.utils, r.logger)
+	if err != nil {
+		return nil, err
+	}
+	var features utilities.HELOFeatures
+	features.Collections = true
+	_, err = r.utils.SendHELOWithFeatures(client, r.userAgent, base.XmemReadTimeout, base.XmemWriteTimeout, features, r.logger)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	r.clients[server] = client
+	return client, nil
+}
+
+func (r *deadLetterRedriver) close() {
+	for _, client := range r.clients {
+		client.Close()
+	}
+}
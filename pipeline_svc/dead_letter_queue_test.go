@@ -0,0 +1,70 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software
+will be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package pipeline_svc
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	mc "github.com/couchbase/gomemcached"
+	"github.com/couchbase/goxdcr/base"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeadLetterEntry(t *testing.T) {
+	fmt.Println("============== Test case start: TestDeadLetterEntry =================")
+	defer fmt.Println("============== Test case end: TestDeadLetterEntry =================")
+	assert := assert.New(t)
+
+	dlq := NewDeadLetterQueue("testReplId", nil, nil, nil, nil, nil)
+	// nothing is queued before the dead letter queue is started
+	record := &base.DeadLetterRecord{
+		Key:       []byte("doc1"),
+		TargetKey: []byte("doc1"),
+		Namespace: base.CollectionNamespace{ScopeName: "S1", CollectionName: "C1"},
+		VBucket:   12,
+		Seqno:     100,
+		Opcode:    base.SET_WITH_META,
+		Status:    mc.E2BIG,
+		Cas:       1234,
+		DataType:  base.JSONDataType,
+		Timestamp: time.Now(),
+		Body:      []byte(`{"field":"value"}`),
+	}
+	assert.False(dlq.Add(record))
+
+	entry := dlq.composeEntry(record)
+	assert.Equal("doc1", entry.Key)
+	assert.Equal("", entry.TargetKey)
+	assert.Equal("S1", entry.Scope)
+	assert.Equal("C1", entry.Collection)
+	assert.Equal(uint16(12), entry.VBucket)
+	assert.Equal(uint64(100), entry.Seqno)
+	assert.Equal(uint64(1234), entry.Cas)
+	assert.Equal(uint16(mc.E2BIG), entry.StatusCode)
+	assert.Nil(entry.Doc)
+	assert.Nil(entry.DocBinary)
+
+	dlq.includeBody = true
+	record.TargetKey = []byte("tenant1::doc1")
+	entry = dlq.composeEntry(record)
+	assert.Equal("tenant1::doc1", entry.TargetKey)
+	assert.Equal(`{"field":"value"}`, string(entry.Doc))
+
+	entryBytes, err := json.Marshal(entry)
+	assert.Nil(err)
+	var decoded deadLetterEntry
+	assert.Nil(json.Unmarshal(entryBytes, &decoded))
+	assert.Equal(entry.Key, decoded.Key)
+	assert.Equal(entry.Cas, decoded.Cas)
+}
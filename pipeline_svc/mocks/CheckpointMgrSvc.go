@@ -6,6 +6,8 @@ import (
 	common "github.com/couchbase/goxdcr/common"
 	metadata "github.com/couchbase/goxdcr/metadata"
 
+	pipeline_svc "github.com/couchbase/goxdcr/pipeline_svc"
+
 	mock "github.com/stretchr/testify/mock"
 )
 
@@ -146,3 +148,26 @@ func (_m *CheckpointMgrSvc) UpdateSettings(settings metadata.ReplicationSettings
 
 	return r0
 }
+
+// ValidateImportedCheckpoints provides a mock function with given fields: ckptsExport
+func (_m *CheckpointMgrSvc) ValidateImportedCheckpoints(ckptsExport *metadata.CheckpointsExport) (*pipeline_svc.ImportedCheckpoints, error) {
+	ret := _m.Called(ckptsExport)
+
+	var r0 *pipeline_svc.ImportedCheckpoints
+	if rf, ok := ret.Get(0).(func(*metadata.CheckpointsExport) *pipeline_svc.ImportedCheckpoints); ok {
+		r0 = rf(ckptsExport)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*pipeline_svc.ImportedCheckpoints)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*metadata.CheckpointsExport) error); ok {
+		r1 = rf(ckptsExport)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
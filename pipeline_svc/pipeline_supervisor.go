@@ -172,13 +172,18 @@ func (pipelineSupervisor *PipelineSupervisor) monitorPipelineHealth() error {
 		return fmt.Errorf("Nil spec2")
 	}
 
-	ref, err := pipelineSupervisor.remoteClusterSvc.RemoteClusterByUuid(replSpec.TargetClusterUUID, false)
-	if err != nil {
-		return err
-	}
-	remoteClusterCapability, err := pipelineSupervisor.remoteClusterSvc.GetCapability(ref)
-	if err != nil {
-		return err
+	var err error
+	remoteClusterCapability := metadata.FileExportCapability()
+	if !replSpec.IsFileExport() {
+		var ref *metadata.RemoteClusterReference
+		ref, err = pipelineSupervisor.remoteClusterSvc.RemoteClusterByUuid(replSpec.TargetClusterUUID, false)
+		if err != nil {
+			return err
+		}
+		remoteClusterCapability, err = pipelineSupervisor.remoteClusterSvc.GetCapability(ref)
+		if err != nil {
+			return err
+		}
 	}
 
 	fin_ch := pipelineSupervisor.GenericSupervisor.FinishChannel()
@@ -309,6 +314,16 @@ func (pipelineSupervisor *PipelineSupervisor) OnEvent(event *common.Event) {
 			pipelineSupervisor.Logger().Warnf("%v Received nil error from part %v\n", pipelineSupervisor.pipeline.Topic(), partId)
 		}
 		pipelineSupervisor.setError(partId, err)
+	case common.ReplicationCaughtUp:
+		// Reported as a failure so that the replication manager can pause the replication
+		pipelineSupervisor.Logger().Infof("%v Replication has caught up\n", pipelineSupervisor.pipeline.Topic())
+		pipelineSupervisor.setError(event.Component.Id(), base.ErrorReplicationCaughtUp)
+	case common.BulkDeletesDetected:
+		// Reported as a failure so that the replication manager can pause the replication.
+		// The details are carried along for the messages that go with the pause
+		err = fmt.Errorf("%v: %v", base.ErrorBulkDeletesDetected, event.OtherInfos)
+		pipelineSupervisor.Logger().Warnf("%v %v\n", pipelineSupervisor.pipeline.Topic(), err)
+		pipelineSupervisor.setError(event.Component.Id(), err)
 	case common.VBErrorEncountered:
 		additionalInfo := event.OtherInfos.(*base.VBErrorEventAdditional)
 		vbno := additionalInfo.Vbno
@@ -62,7 +62,8 @@ var OverviewMetricKeys = []string{service_def.CHANGES_LEFT_METRIC, service_def.D
 	service_def.RESP_WAIT_METRIC, service_def.META_LATENCY_METRIC, service_def.DCP_DISPATCH_TIME_METRIC, service_def.DCP_DATACH_LEN, service_def.THROTTLE_LATENCY_METRIC, service_def.THROUGHPUT_THROTTLE_LATENCY_METRIC,
 	service_def.DP_GET_FAIL_METRIC, service_def.EXPIRY_STRIPPED_METRIC, service_def.ADD_DOCS_WRITTEN_METRIC, service_def.GET_DOC_LATENCY_METRIC,
 	service_def.DOCS_MERGED_METRIC, service_def.DATA_MERGED_METRIC, service_def.EXPIRY_DOCS_MERGED_METRIC, service_def.MERGE_LATENCY_METRIC, service_def.DOCS_CLONED_METRIC,
-	service_def.TARGET_DOCS_SKIPPED_METRIC}
+	service_def.TARGET_DOCS_SKIPPED_METRIC, service_def.DOCS_CONFLICT_LOGGED_METRIC, service_def.DOCS_CONFLICT_LOG_DROPPED_METRIC,
+	service_def.DOCS_DEAD_LETTERED_METRIC, service_def.DEAD_LETTER_WRITE_FAILED_METRIC}
 
 var VBMetricKeys = []string{service_def.DOCS_FILTERED_METRIC, service_def.DOCS_UNABLE_TO_FILTER_METRIC}
 
@@ -177,6 +178,16 @@ type StatisticsManager struct {
 	updateStatsOnceCachedVBList []uint16
 
 	replStatusGetter func(string) (pipeline_pkg.ReplicationStatusIface, error)
+
+	// For replication lag - the cas of the latest source mutation per vb that has been replicated or handled
+	latestHandledCas map[uint16]*uint64
+	// the last time a vb was seen caught up to its high seqno, which bounds the age of its unhandled mutations
+	// To be used by a single go-routine that calls processCalculatedStats
+	vbCaughtUpTime map[uint16]time.Time
+	// lag per vb in milliseconds as of the last stats update
+	vbLags    map[uint16]int64
+	vbLagsMtx sync.RWMutex
+	startTime time.Time
 }
 
 func NewStatisticsManager(through_seqno_tracker_svc service_def.ThroughSeqnoTrackerSvc, xdcr_topology_svc service_def.XDCRCompTopologySvc, logger_ctx *log.LoggerContext, active_vbs map[string][]uint16, bucket_name string, utilsIn utilities.UtilsIface, remoteClusterSvc service_def.RemoteClusterSvc, bucketTopologySvc service_def.BucketTopologySvc, replStatusGetter func(string) (pipeline_pkg.ReplicationStatusIface, error)) *StatisticsManager {
@@ -203,8 +214,11 @@ func NewStatisticsManager(through_seqno_tracker_svc service_def.ThroughSeqnoTrac
 		replStatusGetter:          replStatusGetter,
 		latestNotificationReqCh:   make(chan notificationReqOpt, 100),
 		initDone:                  make(chan bool),
+		latestHandledCas:          make(map[uint16]*uint64),
+		vbCaughtUpTime:            make(map[uint16]time.Time),
+		vbLags:                    make(map[uint16]int64),
 	}
-	stats_mgr.collectors = []MetricsCollector{&outNozzleCollector{}, &dcpCollector{}, &routerCollector{}, &checkpointMgrCollector{}, &conflictMgrCollector{}}
+	stats_mgr.collectors = []MetricsCollector{&outNozzleCollector{}, &dcpCollector{}, &routerCollector{}, &checkpointMgrCollector{}, &conflictMgrCollector{}, &conflictLoggerCollector{}, &collectionsCollector{}, &deadLetterQueueCollector{}}
 
 	stats_mgr.initialize()
 	return stats_mgr
@@ -229,10 +243,41 @@ func GetStatisticsForPipeline(topic string, repStatusGetter func(topic string) (
 	return allPipelinesStats
 }
 
+// Per vb stats of a pipeline which may or may not be running, with the same indexing as GetStatisticsForPipeline
+// An elem is nil if the pipeline has not published any per vb stats
+func GetVBStatisticsForPipeline(topic string, repStatusGetter func(topic string) (pipeline_pkg.ReplicationStatusIface, error)) []*expvar.Map {
+	var allPipelinesStats []*expvar.Map
+	repl_status, _ := repStatusGetter(topic)
+	if repl_status == nil {
+		return allPipelinesStats
+	}
+
+	for pipelineType := common.PipelineTypeBegin; pipelineType < common.PipelineTypeInvalidEnd; pipelineType++ {
+		allPipelinesStats = append(allPipelinesStats, repl_status.GetStats(service_def.VBUCKETS_METRICS_KEY, pipelineType))
+	}
+	return allPipelinesStats
+}
+
+// Per collection stats of a pipeline which may or may not be running, with the same indexing as GetStatisticsForPipeline
+// An elem is nil if the pipeline has not published any per collection stats
+func GetCollectionStatisticsForPipeline(topic string, repStatusGetter func(topic string) (pipeline_pkg.ReplicationStatusIface, error)) []*expvar.Map {
+	var allPipelinesStats []*expvar.Map
+	repl_status, _ := repStatusGetter(topic)
+	if repl_status == nil {
+		return allPipelinesStats
+	}
+
+	for pipelineType := common.PipelineTypeBegin; pipelineType < common.PipelineTypeInvalidEnd; pipelineType++ {
+		allPipelinesStats = append(allPipelinesStats, repl_status.GetStats(service_def.COLLECTIONS_METRICS_KEY, pipelineType))
+	}
+	return allPipelinesStats
+}
+
 func (stats_mgr *StatisticsManager) initialize() {
 	for _, vb_list := range stats_mgr.active_vbs {
 		for _, vb := range vb_list {
 			stats_mgr.checkpointed_seqnos[vb] = base.NewSeqnoWithLock()
+			stats_mgr.latestHandledCas[vb] = new(uint64)
 			stats_mgr.stats_map[fmt.Sprintf(base.VBUCKET_HIGH_SEQNO_STAT_KEY_FORMAT, vb)] = ""
 		}
 	}
@@ -246,6 +291,10 @@ func (statsMgr *StatisticsManager) getdcpCollector() *dcpCollector {
 	return (statsMgr.collectors[1]).(*dcpCollector)
 }
 
+func (statsMgr *StatisticsManager) getCollectionsCollector() *collectionsCollector {
+	return (statsMgr.collectors[6]).(*collectionsCollector)
+}
+
 func (stats_mgr *StatisticsManager) cleanupBeforeExit() error {
 	rs, err := stats_mgr.getReplicationStatus()
 	if err != nil {
@@ -370,9 +419,12 @@ func (stats_mgr *StatisticsManager) logStatsOnce() error {
 		//log parts summary
 		outNozzle_parts := stats_mgr.pipeline.Targets()
 		for _, part := range outNozzle_parts {
-			if stats_mgr.pipeline.Specification().GetReplicationSpec().Settings.RepType == metadata.ReplicationTypeXmem {
+			switch stats_mgr.pipeline.Specification().GetReplicationSpec().Settings.RepType {
+			case metadata.ReplicationTypeXmem:
 				part.(*parts.XmemNozzle).PrintStatusSummary()
-			} else {
+			case metadata.ReplicationTypeFile:
+				part.(*parts.FileExportNozzle).PrintStatusSummary()
+			default:
 				part.(*parts.CapiNozzle).PrintStatusSummary()
 			}
 		}
@@ -538,6 +590,12 @@ func (stats_mgr *StatisticsManager) processRawStats() error {
 	map_for_overview.Set(base.CurrentTime, current_time_var)
 
 	rs.SetOverviewStats(map_for_overview, stats_mgr.pipeline.Type())
+
+	collectionsMap := stats_mgr.getCollectionsCollector().publish()
+	if collectionsMap != nil {
+		rs.SetStats(service_def.COLLECTIONS_METRICS_KEY, collectionsMap, stats_mgr.pipeline.Type())
+	}
+	rs.SetStats(service_def.VBUCKETS_METRICS_KEY, stats_mgr.publishVBStats(), stats_mgr.pipeline.Type())
 	return nil
 }
 
@@ -617,9 +675,34 @@ func (stats_mgr *StatisticsManager) processCalculatedStats(overview_expvar_map *
 	rate_doc_checks_var := new(expvar.Float)
 	rate_doc_checks_var.Set(rate_doc_checks)
 	overview_expvar_map.Set(service_def.RATE_DOC_CHECKS_METRIC, rate_doc_checks_var)
+
+	//publish replication lag. The per vb lags are published separately by publishVBStats
+	var replication_lag int64
+	stats_mgr.vbLagsMtx.RLock()
+	for _, lag := range stats_mgr.vbLags {
+		if lag > replication_lag {
+			replication_lag = lag
+		}
+	}
+	stats_mgr.vbLagsMtx.RUnlock()
+	overview_expvar_map.Set(service_def.REPLICATION_LAG_METRIC, newExpvarInt(replication_lag))
 	return nil
 }
 
+// The per vb stats, keyed by stats name and then by vbno
+func (stats_mgr *StatisticsManager) publishVBStats() *expvar.Map {
+	vb_lags_map := new(expvar.Map).Init()
+	stats_mgr.vbLagsMtx.RLock()
+	for vbno, lag := range stats_mgr.vbLags {
+		vb_lags_map.Set(fmt.Sprintf("%v", vbno), newExpvarInt(lag))
+	}
+	stats_mgr.vbLagsMtx.RUnlock()
+
+	vbStatsMap := new(expvar.Map).Init()
+	vbStatsMap.Set(service_def.VB_REPLICATION_LAG_METRIC, vb_lags_map)
+	return vbStatsMap
+}
+
 func (stats_mgr *StatisticsManager) calculateDocsChecked(vbsList []uint16) uint64 {
 	var docs_checked uint64 = 0
 	vbts_map, vbts_map_lock := GetStartSeqnos(stats_mgr.pipeline, stats_mgr.logger)
@@ -681,9 +764,70 @@ func (stats_mgr *StatisticsManager) calculateChangesLeftAndDocsProcessedMainPipe
 
 	changes_left := total_changes - docs_processed
 	stats_mgr.logChangesLeft(total_changes, docs_processed, changes_left)
+
+	stats_mgr.updateVBLags(highSeqnosMap, curKvVbMap, throughSeqnoMap)
 	return changes_left, docs_processed, vbsList, nil
 }
 
+func (stats_mgr *StatisticsManager) updateVBLags(highSeqnoKvMap base.HighSeqnosMapType, kvVbMap base.KvVBMapType, throughSeqnoMap map[uint16]uint64) {
+	vbLags := calculateVBLags(highSeqnoKvMap, kvVbMap, throughSeqnoMap, stats_mgr.latestHandledCas, stats_mgr.vbCaughtUpTime, stats_mgr.startTime, time.Now())
+	stats_mgr.vbLagsMtx.Lock()
+	stats_mgr.vbLags = vbLags
+	stats_mgr.vbLagsMtx.Unlock()
+}
+
+// A vb that has caught up to its high seqno has no lag
+// Otherwise its lag is the age of the latest mutation that has been handled, bounded by the last time the vb
+// was caught up, since all the mutations that have yet to be handled came after that. If nothing has been
+// handled and the vb has not been caught up since the pipeline started, the lag is at least the pipeline uptime
+// Lags are in milliseconds. vbCaughtUpTime is updated in place
+func calculateVBLags(highSeqnoKvMap base.HighSeqnosMapType, kvVbMap base.KvVBMapType, throughSeqnoMap map[uint16]uint64,
+	latestHandledCas map[uint16]*uint64, vbCaughtUpTime map[uint16]time.Time, startTime, now time.Time) map[uint16]int64 {
+	vbLags := make(map[uint16]int64)
+	for serverAddr, vbnos := range kvVbMap {
+		highseqno_map, found := highSeqnoKvMap[serverAddr]
+		if !found || highseqno_map == nil {
+			continue
+		}
+		for _, vbno := range vbnos {
+			if throughSeqnoMap[vbno] >= (*highseqno_map)[vbno] {
+				vbCaughtUpTime[vbno] = now
+				vbLags[vbno] = 0
+				continue
+			}
+
+			behindSince := startTime
+			if casPtr, ok := latestHandledCas[vbno]; ok && atomic.LoadUint64(casPtr) > 0 {
+				behindSince = base.CasToTime(atomic.LoadUint64(casPtr))
+			}
+			if caughtUpTime, ok := vbCaughtUpTime[vbno]; ok && caughtUpTime.After(behindSince) {
+				behindSince = caughtUpTime
+			}
+
+			var lag int64
+			if now.After(behindSince) {
+				lag = now.Sub(behindSince).Nanoseconds() / 1000000
+			}
+			vbLags[vbno] = lag
+		}
+	}
+	return vbLags
+}
+
+// Keeps the max cas seen, since the cas of the mutations of a vb increases with their seqnos
+func (stats_mgr *StatisticsManager) updateLatestHandledCas(vbno uint16, cas uint64) {
+	casPtr, ok := stats_mgr.latestHandledCas[vbno]
+	if !ok {
+		return
+	}
+	for {
+		oldCas := atomic.LoadUint64(casPtr)
+		if cas <= oldCas || atomic.CompareAndSwapUint64(casPtr, oldCas, cas) {
+			return
+		}
+	}
+}
+
 func (stats_mgr *StatisticsManager) getDocsProcessed(vbsList []uint16, throughSeqnoMap map[uint16]uint64) int64 {
 	var docs_processed int64
 	for vb, seqno := range throughSeqnoMap {
@@ -824,6 +968,7 @@ func (stats_mgr *StatisticsManager) initOverviewRegistry() {
 
 func (stats_mgr *StatisticsManager) Start(settings metadata.ReplicationSettingsMap) error {
 	stats_mgr.logger.Infof("%v StatisticsManager Starting...", stats_mgr.pipeline.InstanceId())
+	stats_mgr.startTime = time.Now()
 
 	err := stats_mgr.initializeConfig(settings)
 	if err != nil {
@@ -911,13 +1056,16 @@ func (stats_mgr *StatisticsManager) Stop() error {
 
 func (stats_mgr *StatisticsManager) initDataFeeds() error {
 	spec := stats_mgr.pipeline.Specification().GetReplicationSpec()
-	ref, err := stats_mgr.remoteClusterSvc.RemoteClusterByUuid(spec.TargetClusterUUID, false /*refresh*/)
-	if err != nil {
-		return err
-	}
-	rcCapability, err := stats_mgr.remoteClusterSvc.GetCapability(ref)
-	if err != nil {
-		return err
+	rcCapability := metadata.FileExportCapability()
+	if !spec.IsFileExport() {
+		ref, err := stats_mgr.remoteClusterSvc.RemoteClusterByUuid(spec.TargetClusterUUID, false /*refresh*/)
+		if err != nil {
+			return err
+		}
+		rcCapability, err = stats_mgr.remoteClusterSvc.GetCapability(ref)
+		if err != nil {
+			return err
+		}
 	}
 	stats_mgr.cachedCapability = rcCapability
 
@@ -1203,6 +1351,128 @@ func (conflictMgr_collector *conflictMgrCollector) ProcessEvent(event *common.Ev
 	return nil
 }
 
+//metrics collector for conflict logger
+type conflictLoggerCollector struct {
+	id        string
+	stats_mgr *StatisticsManager
+	common.AsyncComponentEventHandler
+	// key of outer map: component id
+	// key of inner map: metric name
+	// value of inner map: metric value
+	component_map map[string]map[string]interface{}
+}
+
+func (conflictLogger_collector *conflictLoggerCollector) Mount(pipeline common.Pipeline, stats_mgr *StatisticsManager) error {
+	conflictLoggerSvc := pipeline.RuntimeContext().Service(base.CONFLICT_LOGGER_SVC)
+	if conflictLoggerSvc == nil {
+		return nil
+	}
+	conflictLogger := conflictLoggerSvc.(*ConflictLogger)
+	conflictLogger_collector.id = pipeline_utils.GetElementIdFromName(pipeline, base.ConflictLoggerCollector)
+	conflictLogger_collector.stats_mgr = stats_mgr
+	conflictLogger_collector.component_map = make(map[string]map[string]interface{})
+	registry := stats_mgr.getOrCreateRegistry(conflictLogger.Id())
+	docs_conflict_logged := metrics.NewCounter()
+	registry.Register(service_def.DOCS_CONFLICT_LOGGED_METRIC, docs_conflict_logged)
+	docs_conflict_log_dropped := metrics.NewCounter()
+	registry.Register(service_def.DOCS_CONFLICT_LOG_DROPPED_METRIC, docs_conflict_log_dropped)
+
+	metric_map := make(map[string]interface{})
+	metric_map[service_def.DOCS_CONFLICT_LOGGED_METRIC] = docs_conflict_logged
+	metric_map[service_def.DOCS_CONFLICT_LOG_DROPPED_METRIC] = docs_conflict_log_dropped
+	conflictLogger_collector.component_map[conflictLogger.Id()] = metric_map
+
+	conflictLogger.RegisterComponentEventListener(common.ConflictLogged, conflictLogger_collector)
+	conflictLogger.RegisterComponentEventListener(common.ConflictLogDropped, conflictLogger_collector)
+
+	return nil
+}
+
+func (conflictLogger_collector *conflictLoggerCollector) Id() string {
+	return conflictLogger_collector.id
+}
+
+func (conflictLogger_collector *conflictLoggerCollector) OnEvent(event *common.Event) {
+	conflictLogger_collector.ProcessEvent(event)
+}
+
+func (conflictLogger_collector *conflictLoggerCollector) HandleLatestThroughSeqnos(SeqnoMap map[uint16]uint64) {
+	// Nothing
+	return
+}
+
+func (conflictLogger_collector *conflictLoggerCollector) ProcessEvent(event *common.Event) error {
+	metric_map := conflictLogger_collector.component_map[event.Component.Id()]
+	if event.EventType == common.ConflictLogged {
+		metric_map[service_def.DOCS_CONFLICT_LOGGED_METRIC].(metrics.Counter).Inc(1)
+	}
+	if event.EventType == common.ConflictLogDropped {
+		metric_map[service_def.DOCS_CONFLICT_LOG_DROPPED_METRIC].(metrics.Counter).Inc(1)
+	}
+	return nil
+}
+
+//metrics collector for dead letter queue
+type deadLetterQueueCollector struct {
+	id        string
+	stats_mgr *StatisticsManager
+	common.AsyncComponentEventHandler
+	// key of outer map: component id
+	// key of inner map: metric name
+	// value of inner map: metric value
+	component_map map[string]map[string]interface{}
+}
+
+func (deadLetterQueue_collector *deadLetterQueueCollector) Mount(pipeline common.Pipeline, stats_mgr *StatisticsManager) error {
+	deadLetterQueueSvc := pipeline.RuntimeContext().Service(base.DEAD_LETTER_QUEUE_SVC)
+	if deadLetterQueueSvc == nil {
+		return nil
+	}
+	deadLetterQueue := deadLetterQueueSvc.(*DeadLetterQueue)
+	deadLetterQueue_collector.id = pipeline_utils.GetElementIdFromName(pipeline, base.DeadLetterQueueCollector)
+	deadLetterQueue_collector.stats_mgr = stats_mgr
+	deadLetterQueue_collector.component_map = make(map[string]map[string]interface{})
+	registry := stats_mgr.getOrCreateRegistry(deadLetterQueue.Id())
+	docs_dead_lettered := metrics.NewCounter()
+	registry.Register(service_def.DOCS_DEAD_LETTERED_METRIC, docs_dead_lettered)
+	dead_letter_write_failed := metrics.NewCounter()
+	registry.Register(service_def.DEAD_LETTER_WRITE_FAILED_METRIC, dead_letter_write_failed)
+
+	metric_map := make(map[string]interface{})
+	metric_map[service_def.DOCS_DEAD_LETTERED_METRIC] = docs_dead_lettered
+	metric_map[service_def.DEAD_LETTER_WRITE_FAILED_METRIC] = dead_letter_write_failed
+	deadLetterQueue_collector.component_map[deadLetterQueue.Id()] = metric_map
+
+	deadLetterQueue.RegisterComponentEventListener(common.DataDeadLettered, deadLetterQueue_collector)
+	deadLetterQueue.RegisterComponentEventListener(common.DeadLetterWriteFailed, deadLetterQueue_collector)
+
+	return nil
+}
+
+func (deadLetterQueue_collector *deadLetterQueueCollector) Id() string {
+	return deadLetterQueue_collector.id
+}
+
+func (deadLetterQueue_collector *deadLetterQueueCollector) OnEvent(event *common.Event) {
+	deadLetterQueue_collector.ProcessEvent(event)
+}
+
+func (deadLetterQueue_collector *deadLetterQueueCollector) HandleLatestThroughSeqnos(SeqnoMap map[uint16]uint64) {
+	// Nothing
+	return
+}
+
+func (deadLetterQueue_collector *deadLetterQueueCollector) ProcessEvent(event *common.Event) error {
+	metric_map := deadLetterQueue_collector.component_map[event.Component.Id()]
+	if event.EventType == common.DataDeadLettered {
+		metric_map[service_def.DOCS_DEAD_LETTERED_METRIC].(metrics.Counter).Inc(1)
+	}
+	if event.EventType == common.DeadLetterWriteFailed {
+		metric_map[service_def.DEAD_LETTER_WRITE_FAILED_METRIC].(metrics.Counter).Inc(1)
+	}
+	return nil
+}
+
 //metrics collector for XMem/CapiNozzle
 type outNozzleCollector struct {
 	id        string
@@ -1346,6 +1616,7 @@ func (outNozzle_collector *outNozzleCollector) ProcessEvent(event *common.Event)
 		resp_wait_time := event_otherInfo.Resp_wait_time
 		metric_map[service_def.DOCS_WRITTEN_METRIC].(metrics.Counter).Inc(1)
 		metric_map[service_def.DATA_REPLICATED_METRIC].(metrics.Counter).Inc(int64(req_size))
+		outNozzle_collector.stats_mgr.updateLatestHandledCas(event_otherInfo.VBucket, event_otherInfo.SourceCas)
 		if opti_replicated {
 			metric_map[service_def.DOCS_OPT_REPD_METRIC].(metrics.Counter).Inc(1)
 		}
@@ -1371,6 +1642,7 @@ func (outNozzle_collector *outNozzleCollector) ProcessEvent(event *common.Event)
 	} else if event.EventType == common.DataFailedCRSource {
 		metric_map[service_def.DOCS_FAILED_CR_SOURCE_METRIC].(metrics.Counter).Inc(1)
 		event_otherInfos := event.OtherInfos.(parts.DataFailedCRSourceEventAdditional)
+		outNozzle_collector.stats_mgr.updateLatestHandledCas(event_otherInfos.VBucket, event_otherInfos.SourceCas)
 		expiry_set := event_otherInfos.IsExpirySet
 		if expiry_set {
 			metric_map[service_def.EXPIRY_FAILED_CR_SOURCE_METRIC].(metrics.Counter).Inc(1)
@@ -1387,6 +1659,7 @@ func (outNozzle_collector *outNozzleCollector) ProcessEvent(event *common.Event)
 	} else if event.EventType == common.TargetDataSkipped {
 		metric_map[service_def.TARGET_DOCS_SKIPPED_METRIC].(metrics.Counter).Inc(1)
 		event_otherInfos := event.OtherInfos.(parts.TargetDataSkippedEventAdditional)
+		outNozzle_collector.stats_mgr.updateLatestHandledCas(event_otherInfos.VBucket, event_otherInfos.SourceCas)
 		expiry_set := event_otherInfos.IsExpirySet
 		if expiry_set {
 			metric_map[service_def.EXPIRY_TARGET_DOCS_SKIPPED_METRIC].(metrics.Counter).Inc(1)
@@ -1661,6 +1934,7 @@ func (r_collector *routerCollector) handleVBEvent(event *common.Event, metricKey
 		uprEvent := event.Data.(*mcc.UprEvent)
 		vbucket := uprEvent.VBucket
 		seqno := uprEvent.Seqno
+		r_collector.stats_mgr.updateLatestHandledCas(vbucket, uprEvent.Cas)
 		helper, ok := r_collector.vbBasedHelper[vbucket]
 		if !ok {
 			return base.ErrorNotMyVbucket
@@ -1745,6 +2019,231 @@ func (r_collector *routerCollector) ProcessEvent(event *common.Event) error {
 	return err
 }
 
+// Key of the per collection stats
+// Stats that are counted before a mutation is routed are kept under the source namespace with an empty target namespace
+type collectionStatsKey struct {
+	source base.CollectionNamespace
+	target base.CollectionNamespace
+}
+
+// Collections beyond the cardinality cap are all accounted for under this key
+var collectionStatsOverflowKey = collectionStatsKey{
+	source: base.CollectionNamespace{ScopeName: base.CollectionStatsOverflowKey},
+	target: base.CollectionNamespace{ScopeName: base.CollectionStatsOverflowKey},
+}
+
+// Nil namespaces, i.e. mutations that were not routed by collection, belong to the default collection
+func newCollectionStatsKey(source, target *base.CollectionNamespace, hasTarget bool) collectionStatsKey {
+	key := collectionStatsKey{source: base.DefaultCollectionNamespace}
+	if source != nil {
+		key.source = *source
+	}
+	if hasTarget {
+		key.target = base.DefaultCollectionNamespace
+		if target != nil {
+			key.target = *target
+		}
+	}
+	return key
+}
+
+// "<source scope>.<source collection>:<target scope>.<target collection>", where the target is empty for the
+// stats that are kept under the source namespace
+func (key collectionStatsKey) String() string {
+	if key == collectionStatsOverflowKey {
+		return base.CollectionStatsOverflowKey + base.CollectionStatsKeyDelimiter + base.CollectionStatsOverflowKey
+	}
+	var target string
+	if !key.target.IsEmpty() {
+		target = key.target.ToIndexString()
+	}
+	return key.source.ToIndexString() + base.CollectionStatsKeyDelimiter + target
+}
+
+type collectionStats struct {
+	docsWritten  int64
+	docsFiltered int64
+	docsFailedCR int64
+	// mutations of the source collection received from DCP, including the clones for additional target collections
+	docsReceived int64
+	// mutations of the source collection that have been written, filtered, skipped or ignored
+	docsHandled int64
+	// docs latency of the writes since the last publish
+	latencySum   int64
+	latencyCount int64
+	latency      int64
+}
+
+// metrics collector for per collection stats
+// Unlike the other collectors, the number of entries depends on the data, so the stats are kept outside of metrics
+// registries and are capped at base.CollectionStatsMaxEntries
+type collectionsCollector struct {
+	id        string
+	stats_mgr *StatisticsManager
+	common.AsyncComponentEventHandler
+
+	// nil if per collection stats are disabled
+	stats          map[collectionStatsKey]*collectionStats
+	statsMtx       sync.Mutex
+	maxEntries     int
+	overflowLogged bool
+}
+
+func (collections_collector *collectionsCollector) Mount(pipeline common.Pipeline, stats_mgr *StatisticsManager) error {
+	collections_collector.id = pipeline_utils.GetElementIdFromName(pipeline, base.CollectionsStatsCollector)
+	collections_collector.stats_mgr = stats_mgr
+	collections_collector.maxEntries = base.CollectionStatsMaxEntries
+	if collections_collector.maxEntries <= 0 {
+		return nil
+	}
+	collections_collector.stats = make(map[collectionStatsKey]*collectionStats)
+
+	async_listener_map := pipeline_pkg.GetAllAsyncComponentEventListeners(pipeline)
+	pipeline_utils.RegisterAsyncComponentEventHandler(async_listener_map, base.DataProcessedEventListener, collections_collector)
+	pipeline_utils.RegisterAsyncComponentEventHandler(async_listener_map, base.DataFilteredEventListener, collections_collector)
+	pipeline_utils.RegisterAsyncComponentEventHandler(async_listener_map, base.DataClonedEventListener, collections_collector)
+	pipeline_utils.RegisterAsyncComponentEventHandler(async_listener_map, base.DataSentEventListener, collections_collector)
+	pipeline_utils.RegisterAsyncComponentEventHandler(async_listener_map, base.DataFailedCREventListener, collections_collector)
+	pipeline_utils.RegisterAsyncComponentEventHandler(async_listener_map, base.TargetDataSkippedEventListener, collections_collector)
+	return nil
+}
+
+func (collections_collector *collectionsCollector) Id() string {
+	return collections_collector.id
+}
+
+func (collections_collector *collectionsCollector) OnEvent(event *common.Event) {
+	collections_collector.ProcessEvent(event)
+}
+
+func (collections_collector *collectionsCollector) HandleLatestThroughSeqnos(SeqnoMap map[uint16]uint64) {
+	// Nothing
+	return
+}
+
+// Returns the namespace at idx of the derived data of an event, if there is one
+func getDerivedNamespace(event *common.Event, idx int) *base.CollectionNamespace {
+	if len(event.DerivedData) <= idx {
+		return nil
+	}
+	namespace, _ := event.DerivedData[idx].(*base.CollectionNamespace)
+	return namespace
+}
+
+func (collections_collector *collectionsCollector) ProcessEvent(event *common.Event) error {
+	collections_collector.statsMtx.Lock()
+	defer collections_collector.statsMtx.Unlock()
+	if collections_collector.stats == nil {
+		return nil
+	}
+
+	switch event.EventType {
+	case common.DataProcessed:
+		sourceStats := collections_collector.getStats(newCollectionStatsKey(getDerivedNamespace(event, 0), nil, false))
+		sourceStats.docsReceived++
+	case common.DataCloned:
+		data := event.Data.([]interface{})
+		if len(data) < 4 {
+			return nil
+		}
+		sourceNamespace, _ := data[3].(*base.CollectionNamespace)
+		// TotalCount includes the original request + cloned count
+		sourceStats := collections_collector.getStats(newCollectionStatsKey(sourceNamespace, nil, false))
+		sourceStats.docsReceived += int64(data[2].(int) - 1)
+	case common.DataFiltered:
+		additional := event.OtherInfos.(parts.DataFilteredAdditional)
+		sourceStats := collections_collector.getStats(newCollectionStatsKey(additional.SourceNamespace, nil, false))
+		sourceStats.docsFiltered++
+		sourceStats.docsHandled++
+	case common.DataUnableToFilter:
+		sourceStats := collections_collector.getStats(newCollectionStatsKey(getDerivedNamespace(event, 2), nil, false))
+		sourceStats.docsHandled++
+	case common.DataNotReplicated:
+		sourceStats := collections_collector.getStats(newCollectionStatsKey(getDerivedNamespace(event, 1), nil, false))
+		sourceStats.docsHandled++
+	case common.DataSent:
+		additional := event.OtherInfos.(parts.DataSentEventAdditional)
+		stats := collections_collector.getStats(newCollectionStatsKey(additional.SourceNamespace, additional.TargetNamespace, true))
+		stats.docsWritten++
+		stats.latencySum += additional.Commit_time.Nanoseconds() / 1000000
+		stats.latencyCount++
+		collections_collector.getStats(newCollectionStatsKey(additional.SourceNamespace, nil, false)).docsHandled++
+	case common.DataFailedCRSource:
+		additional := event.OtherInfos.(parts.DataFailedCRSourceEventAdditional)
+		stats := collections_collector.getStats(newCollectionStatsKey(additional.SourceNamespace, additional.TargetNamespace, true))
+		stats.docsFailedCR++
+		collections_collector.getStats(newCollectionStatsKey(additional.SourceNamespace, nil, false)).docsHandled++
+	case common.TargetDataSkipped:
+		additional := event.OtherInfos.(parts.TargetDataSkippedEventAdditional)
+		collections_collector.getStats(newCollectionStatsKey(additional.SourceNamespace, nil, false)).docsHandled++
+	}
+	return nil
+}
+
+// Caller holds statsMtx. Once the cap is reached, new keys share the overflow entry
+func (collections_collector *collectionsCollector) getStats(key collectionStatsKey) *collectionStats {
+	stats, ok := collections_collector.stats[key]
+	if ok {
+		return stats
+	}
+	if len(collections_collector.stats) >= collections_collector.maxEntries {
+		if !collections_collector.overflowLogged {
+			collections_collector.stats_mgr.logger.Warnf("%v per collection stats reached the limit of %v entries. Stats of %v and any further collections are accounted for under %v",
+				collections_collector.Id(), collections_collector.maxEntries, key, collectionStatsOverflowKey)
+			collections_collector.overflowLogged = true
+		}
+		key = collectionStatsOverflowKey
+		if stats, ok = collections_collector.stats[key]; ok {
+			return stats
+		}
+	}
+	stats = &collectionStats{}
+	collections_collector.stats[key] = stats
+	return stats
+}
+
+// Returns the per collection stats as a map of collection stats key to the map of metric name to value
+func (collections_collector *collectionsCollector) publish() *expvar.Map {
+	collections_collector.statsMtx.Lock()
+	defer collections_collector.statsMtx.Unlock()
+	if collections_collector.stats == nil {
+		return nil
+	}
+
+	collectionsMap := new(expvar.Map).Init()
+	for key, stats := range collections_collector.stats {
+		if stats.latencyCount > 0 {
+			stats.latency = stats.latencySum / stats.latencyCount
+			stats.latencySum = 0
+			stats.latencyCount = 0
+		}
+
+		statsMap := new(expvar.Map).Init()
+		if key.target.IsEmpty() || key == collectionStatsOverflowKey {
+			statsMap.Set(service_def.DOCS_FILTERED_METRIC, newExpvarInt(stats.docsFiltered))
+			changesLeft := stats.docsReceived - stats.docsHandled
+			if changesLeft < 0 {
+				// events of different types are handled by different listeners and can arrive out of order
+				changesLeft = 0
+			}
+			statsMap.Set(service_def.CHANGES_LEFT_METRIC, newExpvarInt(changesLeft))
+		}
+		if !key.target.IsEmpty() {
+			statsMap.Set(service_def.DOCS_WRITTEN_METRIC, newExpvarInt(stats.docsWritten))
+			statsMap.Set(service_def.DOCS_FAILED_CR_SOURCE_METRIC, newExpvarInt(stats.docsFailedCR))
+			statsMap.Set(service_def.DOCS_LATENCY_METRIC, newExpvarInt(stats.latency))
+		}
+		collectionsMap.Set(key.String(), statsMap)
+	}
+	return collectionsMap
+}
+
+func newExpvarInt(value int64) *expvar.Int {
+	expvarInt := new(expvar.Int)
+	expvarInt.Set(value)
+	return expvarInt
+}
+
 //metrics collector for checkpointmanager
 type checkpointMgrCollector struct {
 	stats_mgr *StatisticsManager
@@ -1837,12 +2336,6 @@ func UpdateStats(checkpoints_svc service_def.CheckpointsService, logger *log.Com
 		if spec == nil {
 			continue
 		}
-		ref, err := remoteClusterSvc.RemoteClusterByUuid(spec.TargetClusterUUID, false /*refresh*/)
-		if err != nil {
-			logger.Errorf("Error retrieving target cluster: err %v", err)
-			continue
-		}
-
 		localBucketNotificationCh, err := bucketTopologySvc.SubscribeToLocalBucketFeed(spec, subscriberId)
 		if err != nil {
 			logger.Errorf("Error subscribing to local bucket feed for paused replication %v. err=%v", repl_id, err)
@@ -1852,15 +2345,24 @@ func UpdateStats(checkpoints_svc service_def.CheckpointsService, logger *log.Com
 		cur_kv_vb_map := notification.GetKvVbMapRO()
 		err = bucketTopologySvc.UnSubscribeLocalBucketFeed(spec, subscriberId)
 		if err != nil {
-			logger.Errorf("Error unsubscribing to local bucket feed for paused replication %v - err: %v", ref.Id(), err)
+			logger.Errorf("Error unsubscribing to local bucket feed for paused replication %v - err: %v", repl_id, err)
 		}
 
 		// Check to ensure remote cluster collection capability is aligned with the current memcached connections
-		remoteClusterCapability, err := remoteClusterSvc.GetCapability(ref)
-		if err != nil {
-			logger.Errorf("Error retrieving capability for remote cluster %v - err: %v", ref.Id(), err)
-			notification.Recycle()
-			continue
+		remoteClusterCapability := metadata.FileExportCapability()
+		if !spec.IsFileExport() {
+			ref, err := remoteClusterSvc.RemoteClusterByUuid(spec.TargetClusterUUID, false /*refresh*/)
+			if err != nil {
+				logger.Errorf("Error retrieving target cluster: err %v", err)
+				notification.Recycle()
+				continue
+			}
+			remoteClusterCapability, err = remoteClusterSvc.GetCapability(ref)
+			if err != nil {
+				logger.Errorf("Error retrieving capability for remote cluster %v - err: %v", ref.Id(), err)
+				notification.Recycle()
+				continue
+			}
 		}
 
 		var highSeqnosMaps base.HighSeqnosMapType
@@ -1869,7 +2371,7 @@ func UpdateStats(checkpoints_svc service_def.CheckpointsService, logger *log.Com
 		if remoteClusterCapability.HasCollectionSupport() {
 			highSeqnoFeed, _, err := bucketTopologySvc.SubscribeToLocalBucketHighSeqnosFeed(spec, subscriberId, base.ReplSpecCheckInterval)
 			if err != nil {
-				logger.Errorf("Error subscribing to highSeqnosFeed %v - err: %v", ref.Id(), err)
+				logger.Errorf("Error subscribing to highSeqnosFeed %v - err: %v", repl_id, err)
 				notification.Recycle()
 				continue
 			}
@@ -1881,12 +2383,12 @@ func UpdateStats(checkpoints_svc service_def.CheckpointsService, logger *log.Com
 
 			err = bucketTopologySvc.UnSubscribeToLocalBucketHighSeqnosFeed(spec, subscriberId)
 			if err != nil {
-				logger.Errorf("Error unsubscribing to highSeqnosFeed %v - err: %v", ref.Id(), err)
+				logger.Errorf("Error unsubscribing to highSeqnosFeed %v - err: %v", repl_id, err)
 			}
 		} else {
 			highSeqnoFeed, _, err := bucketTopologySvc.SubscribeToLocalBucketHighSeqnosLegacyFeed(spec, subscriberId, base.ReplSpecCheckInterval)
 			if err != nil {
-				logger.Errorf("Error subscribing to highSeqnosLegacyFeed %v - err: %v", ref.Id(), err)
+				logger.Errorf("Error subscribing to highSeqnosLegacyFeed %v - err: %v", repl_id, err)
 				notification.Recycle()
 				continue
 			}
@@ -1898,7 +2400,7 @@ func UpdateStats(checkpoints_svc service_def.CheckpointsService, logger *log.Com
 
 			err = bucketTopologySvc.UnSubscribeToLocalBucketHighSeqnosFeed(spec, subscriberId)
 			if err != nil {
-				logger.Errorf("Error unsubscribing to highSeqnosLegacyFeed %v - err: %v", ref.Id(), err)
+				logger.Errorf("Error unsubscribing to highSeqnosLegacyFeed %v - err: %v", repl_id, err)
 			}
 		}
 		highSeqnoAndSourceVBGetter := func() (base.HighSeqnosMapType, base.KvVBMapType, func()) {
@@ -2225,6 +2727,28 @@ func (stats_mgr *StatisticsManager) GetVBCountMetrics(vb uint16) (service_def.VB
 	return metricsMap, nil
 }
 
+func (stats_mgr *StatisticsManager) GetReplicationLag() (time.Duration, error) {
+	var replicationLag int64
+	stats_mgr.vbLagsMtx.RLock()
+	defer stats_mgr.vbLagsMtx.RUnlock()
+	for _, lag := range stats_mgr.vbLags {
+		if lag > replicationLag {
+			replicationLag = lag
+		}
+	}
+	return time.Duration(replicationLag) * time.Millisecond, nil
+}
+
+func (stats_mgr *StatisticsManager) GetVBLag(vb uint16) (time.Duration, error) {
+	stats_mgr.vbLagsMtx.RLock()
+	defer stats_mgr.vbLagsMtx.RUnlock()
+	lag, ok := stats_mgr.vbLags[vb]
+	if !ok {
+		return 0, base.ErrorNotMyVbucket
+	}
+	return time.Duration(lag) * time.Millisecond, nil
+}
+
 func (stats_mgr *StatisticsManager) SetVBCountMetrics(vb uint16, metricKVs service_def.VBCountMetricMap) error {
 	// Currently only DCP has vb specific stats
 	vbBasedMetric, ok := stats_mgr.getRouterCollector().vbBasedMetric[vb]
@@ -12,12 +12,15 @@ package pipeline_svc
 
 import (
 	"encoding/json"
+	"expvar"
 	"fmt"
 	"github.com/couchbase/goxdcr/utils"
 	"io/ioutil"
 	"testing"
+	"time"
 
 	mcc "github.com/couchbase/gomemcached/client"
+	"github.com/couchbase/goxdcr/base"
 	commonReal "github.com/couchbase/goxdcr/common"
 	common "github.com/couchbase/goxdcr/common/mocks"
 	"github.com/couchbase/goxdcr/log"
@@ -391,3 +394,114 @@ func TestFilterVBSeqnoMap(t *testing.T) {
 
 	fmt.Println("============== Test case end: TestFilterVBSeqnoMap =================")
 }
+
+func TestCollectionsCollector(t *testing.T) {
+	fmt.Println("============== Test case start: TestCollectionsCollector =================")
+	defer fmt.Println("============== Test case end: TestCollectionsCollector =================")
+	assert := assert.New(t)
+
+	collector := &collectionsCollector{
+		id:         "testCollectionsCollector",
+		stats_mgr:  &StatisticsManager{logger: log.NewLogger("testStatsMgr", log.DefaultLoggerContext)},
+		stats:      make(map[collectionStatsKey]*collectionStats),
+		maxEntries: 3,
+	}
+	source := &base.CollectionNamespace{ScopeName: "S1", CollectionName: "col1"}
+	target := &base.CollectionNamespace{ScopeName: "S2", CollectionName: "col2"}
+
+	for i := 0; i < 3; i++ {
+		collector.ProcessEvent(commonReal.NewEvent(commonReal.DataProcessed, nil, nil, []interface{}{source}, float64(0)))
+	}
+	collector.ProcessEvent(commonReal.NewEvent(commonReal.DataFiltered, nil, nil, nil, parts.DataFilteredAdditional{SourceNamespace: source}))
+	collector.ProcessEvent(commonReal.NewEvent(commonReal.DataSent, nil, nil, nil,
+		parts.DataSentEventAdditional{Commit_time: 10 * time.Millisecond, SourceNamespace: source, TargetNamespace: target}))
+	collector.ProcessEvent(commonReal.NewEvent(commonReal.DataSent, nil, nil, nil,
+		parts.DataSentEventAdditional{Commit_time: 20 * time.Millisecond, SourceNamespace: source, TargetNamespace: target}))
+
+	collectionsMap := collector.publish()
+	sourceStats := collectionsMap.Get("S1.col1:").(*expvar.Map)
+	assert.Equal(int64(1), sourceStats.Get(service_def2.DOCS_FILTERED_METRIC).(*expvar.Int).Value())
+	assert.Equal(int64(0), sourceStats.Get(service_def2.CHANGES_LEFT_METRIC).(*expvar.Int).Value())
+	assert.Nil(sourceStats.Get(service_def2.DOCS_WRITTEN_METRIC))
+	pairStats := collectionsMap.Get("S1.col1:S2.col2").(*expvar.Map)
+	assert.Equal(int64(2), pairStats.Get(service_def2.DOCS_WRITTEN_METRIC).(*expvar.Int).Value())
+	assert.Equal(int64(15), pairStats.Get(service_def2.DOCS_LATENCY_METRIC).(*expvar.Int).Value())
+
+	// mutations without a namespace belong to the default collection
+	collector.ProcessEvent(commonReal.NewEvent(commonReal.DataProcessed, nil, nil, []interface{}{nil}, float64(0)))
+	collectionsMap = collector.publish()
+	assert.Equal(int64(1), collectionsMap.Get("_default._default:").(*expvar.Map).Get(service_def2.CHANGES_LEFT_METRIC).(*expvar.Int).Value())
+
+	// beyond the cap, new collections share the overflow entry
+	other := &base.CollectionNamespace{ScopeName: "S3", CollectionName: "col3"}
+	collector.ProcessEvent(commonReal.NewEvent(commonReal.DataProcessed, nil, nil, []interface{}{other}, float64(0)))
+	collector.ProcessEvent(commonReal.NewEvent(commonReal.DataProcessed, nil, nil, []interface{}{other}, float64(0)))
+	collectionsMap = collector.publish()
+	assert.Nil(collectionsMap.Get("S3.col3:"))
+	assert.Equal(int64(2), collectionsMap.Get("_overflow:_overflow").(*expvar.Map).Get(service_def2.CHANGES_LEFT_METRIC).(*expvar.Int).Value())
+	assert.Equal(4, len(collector.stats))
+}
+
+func TestStatsMgrCollectorsLookup(t *testing.T) {
+	fmt.Println("============== Test case start: TestStatsMgrCollectorsLookup =================")
+	defer fmt.Println("============== Test case end: TestStatsMgrCollectorsLookup =================")
+	assert := assert.New(t)
+	_, throughSeqSvc, xdcrTopologySvc, utils, activeVBs,
+		_, _, _, _, _, remoteClusterSvc, _,
+		_, _, _, _, _, _, _ := setupBoilerPlate()
+
+	statsMgr := NewStatisticsManager(throughSeqSvc, xdcrTopologySvc, log.DefaultLoggerContext, activeVBs, "TestBucket", utils, remoteClusterSvc, nil, nil)
+	assert.NotNil(statsMgr)
+
+	assert.NotPanics(func() {
+		assert.NotNil(statsMgr.getdcpCollector())
+		assert.NotNil(statsMgr.getRouterCollector())
+		assert.NotNil(statsMgr.getCollectionsCollector())
+	})
+}
+
+func TestCalculateVBLags(t *testing.T) {
+	fmt.Println("============== Test case start: TestCalculateVBLags =================")
+	defer fmt.Println("============== Test case end: TestCalculateVBLags =================")
+	assert := assert.New(t)
+
+	startTime := time.Unix(1000, 0)
+	now := startTime.Add(time.Minute)
+	highSeqnos := map[uint16]uint64{0: 10, 1: 10, 2: 10, 3: 10}
+	highSeqnoKvMap := base.HighSeqnosMapType{"kv1": &highSeqnos}
+	kvVbMap := base.KvVBMapType{"kv1": []uint16{0, 1, 2, 3}}
+	throughSeqnoMap := map[uint16]uint64{0: 10, 1: 5, 2: 5, 3: 0}
+
+	statsMgr := &StatisticsManager{latestHandledCas: make(map[uint16]*uint64)}
+	for vb := uint16(0); vb < 4; vb++ {
+		statsMgr.latestHandledCas[vb] = new(uint64)
+	}
+	statsMgr.updateLatestHandledCas(1, uint64(now.Add(-10*time.Second).UnixNano()))
+	// an older cas does not move the latest handled cas backward
+	statsMgr.updateLatestHandledCas(1, uint64(now.Add(-30*time.Second).UnixNano()))
+	statsMgr.updateLatestHandledCas(2, uint64(now.Add(-30*time.Second).UnixNano()))
+	vbCaughtUpTime := map[uint16]time.Time{2: now.Add(-20 * time.Second)}
+
+	vbLags := calculateVBLags(highSeqnoKvMap, kvVbMap, throughSeqnoMap, statsMgr.latestHandledCas, vbCaughtUpTime, startTime, now)
+	// caught up
+	assert.Equal(int64(0), vbLags[0])
+	assert.Equal(now, vbCaughtUpTime[0])
+	// age of the latest handled mutation
+	assert.Equal(int64(10000), vbLags[1])
+	// bounded by the last time the vb was caught up
+	assert.Equal(int64(20000), vbLags[2])
+	// nothing handled since the pipeline started
+	assert.Equal(int64(60000), vbLags[3])
+
+	statsMgr.vbLags = vbLags
+	replicationLag, err := statsMgr.GetReplicationLag()
+	assert.Nil(err)
+	assert.Equal(time.Minute, replicationLag)
+	_, err = statsMgr.GetVBLag(4)
+	assert.Equal(base.ErrorNotMyVbucket, err)
+
+	vbStatsMap := statsMgr.publishVBStats()
+	vbLagsMap := vbStatsMap.Get(service_def2.VB_REPLICATION_LAG_METRIC).(*expvar.Map)
+	assert.Equal(int64(20000), vbLagsMap.Get("2").(*expvar.Int).Value())
+	assert.Nil(vbLagsMap.Get("4"))
+}
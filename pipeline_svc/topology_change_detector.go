@@ -543,6 +543,11 @@ func (top_detect_svc *TopologyChangeDetectorSvc) monitorTarget(initWg *sync.Wait
 	} else {
 		spec = genSpec.GetReplicationSpec()
 	}
+	if spec.IsFileExport() {
+		// there is no target topology to monitor
+		initWg.Done()
+		return
+	}
 	targetVbUpdateCh, subscribeErr := top_detect_svc.bucketTopologySvc.SubscribeToRemoteBucketFeed(spec, top_detect_svc.bucketTopSubscriberId)
 	if subscribeErr != nil {
 		*initErr = subscribeErr
@@ -11,6 +11,7 @@ package pipeline_utils
 import (
 	"expvar"
 	"fmt"
+	"github.com/couchbase/goxdcr/base"
 	"github.com/couchbase/goxdcr/metadata"
 	"github.com/couchbase/goxdcr/service_def"
 	utilities "github.com/couchbase/goxdcr/utils"
@@ -28,12 +29,27 @@ const (
 	PrometheusSourceBucketLabel      = "sourceBucketName"
 	PrometheusTargetBucketLabel      = "targetBucketName"
 	PrometheusPipelineTypeLabel      = "pipelineType"
+	PrometheusSourceCollectionLabel  = "sourceCollection"
+	PrometheusTargetCollectionLabel  = "targetCollection"
+	PrometheusReplicationNameLabel   = "replicationName"
+	PrometheusSourceVBucketLabel     = "sourceVBucket"
 )
 
+// Delimits the replication ID from the per collection stats key in the IDs of the collections exporter
+// Neither collection names nor the stats key can contain it
+const collectionsExporterIdDelimiter = "/"
+
+// Delimits the replication ID from the vbno in the IDs of per vb stats
+const vbExporterIdDelimiter = "/"
+
 var PrometheusTargetClusterUuidBytes = []byte(PrometheusTargetClusterUuidLabel)
 var PrometheusSourceBucketBytes = []byte(PrometheusSourceBucketLabel)
 var PrometheusTargetBucketBytes = []byte(PrometheusTargetBucketLabel)
 var PrometheusPipelineTypeBytes = []byte(PrometheusPipelineTypeLabel)
+var PrometheusSourceCollectionBytes = []byte(PrometheusSourceCollectionLabel)
+var PrometheusTargetCollectionBytes = []byte(PrometheusTargetCollectionLabel)
+var PrometheusReplicationNameBytes = []byte(PrometheusReplicationNameLabel)
+var PrometheusSourceVBucketBytes = []byte(PrometheusSourceVBucketLabel)
 
 type PrometheusExporter struct {
 	// Read only
@@ -62,6 +78,16 @@ type PrometheusExporter struct {
 	outputBufferMtx sync.Mutex
 
 	utils utilities.UtilsIface
+
+	// The expvar map is keyed by replication ID and then by per collection stats key, instead of by replication ID only
+	collectionsMode bool
+}
+
+// Exports the per collection stats of service_def.COLLECTIONS_METRICS_KEY, with source and target collection labels
+func NewPrometheusCollectionsExporter(translationMap service_def.StatisticsPropertyMap) *PrometheusExporter {
+	prom := NewPrometheusExporter(translationMap)
+	prom.collectionsMode = true
+	return prom
 }
 
 func NewPrometheusExporter(translationMap service_def.StatisticsPropertyMap) *PrometheusExporter {
@@ -112,6 +138,24 @@ func (m *MetricsMapType) RecordStat(replicationId, statsConst string, value inte
 	replicationStatsMap[replicationId].Value = value
 }
 
+// Records the value of a per vb stat, which is exported with the vbno as a label
+func (m *MetricsMapType) RecordVBStat(replicationId, vbno, statsConst string, value interface{}, lookupMap service_def.StatisticsPropertyMap) {
+	replicationStatsMap, constExists := (*m)[statsConst]
+	if !constExists {
+		return
+	}
+
+	vbStatsId := replicationId + vbExporterIdDelimiter + vbno
+	_, vbExists := replicationStatsMap[vbStatsId]
+	if !vbExists {
+		statsProperty := lookupMap[statsConst]
+		replicationStatsMap[vbStatsId] = NewPerReplicationStatType(statsProperty)
+		replicationStatsMap[vbStatsId].VBucket = vbno
+	}
+
+	replicationStatsMap[vbStatsId].Value = value
+}
+
 type ExpVarParseMapType map[string]interface{}
 
 // Returns true if all the keys match, and the types all match
@@ -158,19 +202,26 @@ type PerReplicationStatType struct {
 	OutputBuffer              []byte
 	outputValueBuffer         []byte
 	ReplIdDecompositionStruct *metadata.ReplIdComposition
+	// The vbno of a per vb stat, or empty if the stat is not broken down by vb
+	VBucket string
 }
 
 func (t *PerReplicationStatType) UpdateOutputBuffer(metricName []byte, replId string) {
+	t.UpdateCollectionsOutputBuffer(metricName, replId, "")
+}
+
+// collectionsKey is the key of per collection stats, or empty if the stat is not broken down by collection
+func (t *PerReplicationStatType) UpdateCollectionsOutputBuffer(metricName []byte, replId, collectionsKey string) {
 	// Output looks like:
 	// metric_name {label_name=\"<labelVal>\", ...} <value>
 	// Ends with a newline, but won't output it here
 	t.OutputBuffer = t.OutputBuffer[:0]
 	t.OutputBuffer = append(t.OutputBuffer, metricName...)
-	t.appendLabels(replId)
+	t.appendLabels(replId, collectionsKey)
 	t.appendOutputBufferWithValue()
 }
 
-func (t *PerReplicationStatType) appendLabels(replId string) {
+func (t *PerReplicationStatType) appendLabels(replId, collectionsKey string) {
 	// {
 	t.OutputBuffer = append(t.OutputBuffer, []byte(" {")...)
 
@@ -200,6 +251,45 @@ func (t *PerReplicationStatType) appendLabels(replId string) {
 	t.OutputBuffer = append(t.OutputBuffer, []byte(t.ReplIdDecompositionStruct.PipelineType)...)
 	t.OutputBuffer = append(t.OutputBuffer, []byte("\"")...)
 
+	if t.ReplIdDecompositionStruct.ReplicationName != "" {
+		// ..., pipelineType="Main", replicationName="name"
+		t.OutputBuffer = append(t.OutputBuffer, []byte(", ")...)
+		t.OutputBuffer = append(t.OutputBuffer, PrometheusReplicationNameBytes...)
+		t.OutputBuffer = append(t.OutputBuffer, []byte("=\"")...)
+		t.OutputBuffer = append(t.OutputBuffer, []byte(t.ReplIdDecompositionStruct.ReplicationName)...)
+		t.OutputBuffer = append(t.OutputBuffer, []byte("\"")...)
+	}
+
+	if t.VBucket != "" {
+		// ..., pipelineType="Main", sourceVBucket="12"
+		t.OutputBuffer = append(t.OutputBuffer, []byte(", ")...)
+		t.OutputBuffer = append(t.OutputBuffer, PrometheusSourceVBucketBytes...)
+		t.OutputBuffer = append(t.OutputBuffer, []byte("=\"")...)
+		t.OutputBuffer = append(t.OutputBuffer, []byte(t.VBucket)...)
+		t.OutputBuffer = append(t.OutputBuffer, []byte("\"")...)
+	}
+
+	if collectionsKey != "" {
+		// ..., pipelineType="Main", sourceCollection="S1.col1", targetCollection="S2.col2"
+		var sourceCollection, targetCollection string
+		delimIdx := strings.Index(collectionsKey, base.CollectionStatsKeyDelimiter)
+		if delimIdx < 0 {
+			sourceCollection = collectionsKey
+		} else {
+			sourceCollection = collectionsKey[:delimIdx]
+			targetCollection = collectionsKey[delimIdx+len(base.CollectionStatsKeyDelimiter):]
+		}
+		t.OutputBuffer = append(t.OutputBuffer, []byte(", ")...)
+		t.OutputBuffer = append(t.OutputBuffer, PrometheusSourceCollectionBytes...)
+		t.OutputBuffer = append(t.OutputBuffer, []byte("=\"")...)
+		t.OutputBuffer = append(t.OutputBuffer, []byte(sourceCollection)...)
+		t.OutputBuffer = append(t.OutputBuffer, []byte("\", ")...)
+		t.OutputBuffer = append(t.OutputBuffer, PrometheusTargetCollectionBytes...)
+		t.OutputBuffer = append(t.OutputBuffer, []byte("=\"")...)
+		t.OutputBuffer = append(t.OutputBuffer, []byte(targetCollection)...)
+		t.OutputBuffer = append(t.OutputBuffer, []byte("\"")...)
+	}
+
 	// { targetClusterUUID="abcdef", sourceBucketName="b1", targetBucketName="b2", pipelineType="Main"}
 	t.OutputBuffer = append(t.OutputBuffer, []byte("} ")...)
 }
@@ -346,8 +436,23 @@ func (p *PrometheusExporter) LoadMetricsMap(needToReallocate bool) error {
 		if !metadata.IsAReplicationId(replId) {
 			return fmt.Errorf("Invalid expVarParseMap - expecting replication ID, got %v", replId)
 		}
+		if p.collectionsMode {
+			for collectionsKey, collectionStats := range statsMap.(ExpVarParseMapType) {
+				collectionsId := replId + collectionsExporterIdDelimiter + collectionsKey
+				for statConst, value := range collectionStats.(ExpVarParseMapType) {
+					p.metricsMap.RecordStat(collectionsId, statConst, value, p.globalLookupMap)
+				}
+			}
+			continue
+		}
 		// Now everything else is in the context of this replication
 		for statConst, value := range statsMap.(ExpVarParseMapType) {
+			if vbStats, isPerVB := value.(ExpVarParseMapType); isPerVB {
+				for vbno, vbValue := range vbStats {
+					p.metricsMap.RecordVBStat(replId, vbno, statConst, vbValue, p.globalLookupMap)
+				}
+				continue
+			}
 			p.metricsMap.RecordStat(replId, statConst, value, p.globalLookupMap)
 		}
 	}
@@ -452,7 +557,14 @@ func (p *PrometheusExporter) prepareStatsBuffer() (atLeastOneStatsActive bool) {
 				continue
 			}
 			atLeastOneStatsActive = true
-			perReplStats.UpdateOutputBuffer(metricKey, replId)
+			if p.collectionsMode {
+				delimIdx := strings.LastIndex(replId, collectionsExporterIdDelimiter)
+				perReplStats.UpdateCollectionsOutputBuffer(metricKey, replId[:delimIdx], replId[delimIdx+1:])
+			} else if perReplStats.VBucket != "" {
+				perReplStats.UpdateOutputBuffer(metricKey, replId[:strings.LastIndex(replId, vbExporterIdDelimiter)])
+			} else {
+				perReplStats.UpdateOutputBuffer(metricKey, replId)
+			}
 		}
 	}
 
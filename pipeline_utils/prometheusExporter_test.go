@@ -118,3 +118,69 @@ func TestPrometheusParseMapToMetricMap(t *testing.T) {
 	assert.Nil(err)
 
 }
+
+func TestPrometheusCollectionsExporter(t *testing.T) {
+	assert := assert.New(t)
+	fmt.Println("============== Test case start: TestPrometheusCollectionsExporter =================")
+	defer fmt.Println("============== Test case end: TestPrometheusCollectionsExporter =================")
+
+	newInt := func(value int64) *expvar.Int {
+		expvarInt := new(expvar.Int)
+		expvarInt.Set(value)
+		return expvarInt
+	}
+	pairStats := new(expvar.Map).Init()
+	pairStats.Set(service_def.DOCS_WRITTEN_METRIC, newInt(5))
+	pairStats.Set(service_def.DOCS_LATENCY_METRIC, newInt(20))
+	sourceStats := new(expvar.Map).Init()
+	sourceStats.Set(service_def.CHANGES_LEFT_METRIC, newInt(2))
+	collectionsStats := new(expvar.Map).Init()
+	collectionsStats.Set("S1.col1:S2.col2", pairStats)
+	collectionsStats.Set("S1.col1:", sourceStats)
+	expVarMap := new(expvar.Map).Init()
+	expVarMap.Set("0746d42b7e44e5840dc02a9249efaef0/B1/B2", collectionsStats)
+
+	exporter := NewPrometheusCollectionsExporter(service_def.GlobalCollectionStatsTable)
+	exporter.LoadExpVarMap(expVarMap)
+	output, err := exporter.Export()
+	assert.Nil(err)
+	outputStr := string(output)
+
+	replLabels := `targetClusterUUID="0746d42b7e44e5840dc02a9249efaef0", sourceBucketName="B1", targetBucketName="B2", pipelineType="Main"`
+	assert.Contains(outputStr, `xdcr_collection_docs_written_total {`+replLabels+`, sourceCollection="S1.col1", targetCollection="S2.col2"} 5`)
+	assert.Contains(outputStr, `xdcr_collection_wtavg_docs_latency_seconds {`+replLabels+`, sourceCollection="S1.col1", targetCollection="S2.col2"} 0.02`)
+	assert.Contains(outputStr, `xdcr_collection_changes_left_total {`+replLabels+`, sourceCollection="S1.col1", targetCollection=""} 2`)
+	// per replication totals are not repeated under the collection names
+	assert.NotContains(outputStr, "xdcr_docs_written_total")
+}
+
+func TestPrometheusExporterPerVBStats(t *testing.T) {
+	assert := assert.New(t)
+	fmt.Println("============== Test case start: TestPrometheusExporterPerVBStats =================")
+	defer fmt.Println("============== Test case end: TestPrometheusExporterPerVBStats =================")
+
+	newInt := func(value int64) *expvar.Int {
+		expvarInt := new(expvar.Int)
+		expvarInt.Set(value)
+		return expvarInt
+	}
+	vbLags := new(expvar.Map).Init()
+	vbLags.Set("0", newInt(0))
+	vbLags.Set("12", newInt(1500))
+	replStats := new(expvar.Map).Init()
+	replStats.Set(service_def.REPLICATION_LAG_METRIC, newInt(1500))
+	replStats.Set(service_def.VB_REPLICATION_LAG_METRIC, vbLags)
+	expVarMap := new(expvar.Map).Init()
+	expVarMap.Set("0746d42b7e44e5840dc02a9249efaef0/B1/B2", replStats)
+
+	exporter := NewPrometheusExporter(service_def.GlobalStatsTable)
+	exporter.LoadExpVarMap(expVarMap)
+	output, err := exporter.Export()
+	assert.Nil(err)
+	outputStr := string(output)
+
+	replLabels := `targetClusterUUID="0746d42b7e44e5840dc02a9249efaef0", sourceBucketName="B1", targetBucketName="B2", pipelineType="Main"`
+	assert.Contains(outputStr, `xdcr_replication_lag_seconds {`+replLabels+`} 1.5`)
+	assert.Contains(outputStr, `xdcr_replication_lag_vbs_seconds {`+replLabels+`, sourceVBucket="0"} 0`)
+	assert.Contains(outputStr, `xdcr_replication_lag_vbs_seconds {`+replLabels+`, sourceVBucket="12"} 1.5`)
+}
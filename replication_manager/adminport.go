@@ -37,8 +37,8 @@ import (
 	_ "net/http/pprof"
 )
 
-var StaticPaths = []string{base.RemoteClustersPath, CreateReplicationPath, SettingsReplicationsPath, AllReplicationsPath, AllReplicationInfosPath, RegexpValidationPrefix, MemStatsPath, BlockProfileStartPath, BlockProfileStopPath, XDCRInternalSettingsPath, XDCRPrometheusStatsPath, XDCRPrometheusStatsHighPath, base.XDCRPeerToPeerPath}
-var DynamicPathPrefixes = []string{base.RemoteClustersPath, DeleteReplicationPrefix, SettingsReplicationsPath, StatisticsPrefix, AllReplicationsPath, BucketSettingsPrefix}
+var StaticPaths = []string{base.RemoteClustersPath, CreateReplicationPath, CreateReplicationDryRunPath, SettingsReplicationsPath, AllReplicationsPath, AllReplicationInfosPath, RegexpValidationPrefix, FilterSamplePath, MemStatsPath, BlockProfileStartPath, BlockProfileStopPath, XDCRInternalSettingsPath, XDCRPrometheusStatsPath, XDCRPrometheusStatsHighPath, base.XDCRPeerToPeerPath, TopologyConfigPath, MetadataBackupPath, MetadataRestorePath, MergeFunctionsPath}
+var DynamicPathPrefixes = []string{base.RemoteClustersPath, DeleteReplicationPrefix, SettingsReplicationsPath, StatisticsPrefix, AllReplicationsPath, BucketSettingsPrefix, RedriveDeadLettersPrefix, CheckpointsPrefix, RewindCheckpointsPrefix, ExportCheckpointsPrefix, ImportCheckpointsPrefix, SettingsHistoryPrefix, RollbackSettingsPrefix, RotateCredentialsPrefix, MergeFunctionsPath}
 
 var logger_ap *log.CommonLogger = log.NewLogger("AdminPort", log.DefaultLoggerContext)
 
@@ -53,6 +53,8 @@ type Adminport struct {
 	finch              chan bool
 	utils              utilities.UtilsIface
 	prometheusExporter pipeline_utils.ExpVarExporter
+	// per collection stats, which are high cardinality
+	prometheusCollectionsExporter pipeline_utils.ExpVarExporter
 
 	p2pMgr      peerToPeer.P2PManager
 	p2pAPI      peerToPeer.PeerToPeerCommAPI
@@ -69,15 +71,16 @@ func NewAdminport(laddr string, xdcrRestPort, kvAdminPort uint16, finch chan boo
 		&exit_callback_func, &error_handler_func, log.DefaultLoggerContext, "Adminport", utilsIn)
 
 	adminport := &Adminport{
-		sourceKVHost:       laddr,
-		xdcrRestPort:       xdcrRestPort,
-		kvAdminPort:        kvAdminPort,
-		GenServer:          server, /*gen_server.GenServer*/
-		finch:              finch,
-		utils:              utilsIn,
-		prometheusExporter: pipeline_utils.NewPrometheusExporter(service_def.GlobalStatsTable),
-		p2pMgr:             p2pMgr,
-		securitySvc:        securitySvc,
+		sourceKVHost:                  laddr,
+		xdcrRestPort:                  xdcrRestPort,
+		kvAdminPort:                   kvAdminPort,
+		GenServer:                     server, /*gen_server.GenServer*/
+		finch:                         finch,
+		utils:                         utilsIn,
+		prometheusExporter:            pipeline_utils.NewPrometheusExporter(service_def.GlobalStatsTable),
+		prometheusCollectionsExporter: pipeline_utils.NewPrometheusCollectionsExporter(service_def.GlobalCollectionStatsTable),
+		p2pMgr:                        p2pMgr,
+		securitySvc:                   securitySvc,
 	}
 
 	msg_callback_func = adminport.processRequest
@@ -194,17 +197,49 @@ func (adminport *Adminport) handleRequest(
 		response, err = adminport.doChangeRemoteClusterRequest(request)
 	case base.RemoteClustersPath + DynamicSuffix + base.UrlDelimiter + base.MethodDelete:
 		response, err = adminport.doDeleteRemoteClusterRequest(request)
+	case RotateCredentialsPrefix + DynamicSuffix + base.UrlDelimiter + base.MethodPost:
+		response, err = adminport.doRotateCredentialsRequest(request)
 	case AllReplicationsPath + base.UrlDelimiter + base.MethodGet:
 		response, err = adminport.doGetAllReplicationsRequest(request)
 	case AllReplicationInfosPath + base.UrlDelimiter + base.MethodGet:
 		response, err = adminport.doGetAllReplicationInfosRequest(request)
 	case CreateReplicationPath + base.UrlDelimiter + base.MethodPost:
 		response, err = adminport.doCreateReplicationRequest(request)
+	case CreateReplicationDryRunPath + base.UrlDelimiter + base.MethodPost:
+		response, err = adminport.doCreateReplicationDryRunRequest(request)
 	case DeleteReplicationPrefix + DynamicSuffix + base.UrlDelimiter + base.MethodDelete:
 		fallthrough
 	// historically, deleteReplication could use Post method
 	case DeleteReplicationPrefix + DynamicSuffix + base.UrlDelimiter + base.MethodPost:
 		response, err = adminport.doDeleteReplicationRequest(request)
+	case RedriveDeadLettersPrefix + DynamicSuffix + base.UrlDelimiter + base.MethodPost:
+		response, err = adminport.doRedriveDeadLettersRequest(request)
+	case CheckpointsPrefix + DynamicSuffix + base.UrlDelimiter + base.MethodGet:
+		response, err = adminport.doGetCheckpointsRequest(request)
+	case RewindCheckpointsPrefix + DynamicSuffix + base.UrlDelimiter + base.MethodPost:
+		response, err = adminport.doRewindCheckpointsRequest(request)
+	case ExportCheckpointsPrefix + DynamicSuffix + base.UrlDelimiter + base.MethodGet:
+		response, err = adminport.doExportCheckpointsRequest(request)
+	case ImportCheckpointsPrefix + DynamicSuffix + base.UrlDelimiter + base.MethodPost:
+		response, err = adminport.doImportCheckpointsRequest(request)
+	case SettingsHistoryPrefix + DynamicSuffix + base.UrlDelimiter + base.MethodGet:
+		response, err = adminport.doGetSettingsHistoryRequest(request)
+	case RollbackSettingsPrefix + DynamicSuffix + base.UrlDelimiter + base.MethodPost:
+		response, err = adminport.doRollbackSettingsRequest(request)
+	case MetadataBackupPath + base.UrlDelimiter + base.MethodPost:
+		response, err = adminport.doMetadataBackupRequest(request)
+	case MetadataRestorePath + base.UrlDelimiter + base.MethodPost:
+		response, err = adminport.doMetadataRestoreRequest(request)
+	case TopologyConfigPath + base.UrlDelimiter + base.MethodPost:
+		response, err = adminport.doTopologyConfigRequest(request)
+	case MergeFunctionsPath + base.UrlDelimiter + base.MethodGet:
+		response, err = adminport.doGetMergeFunctionsRequest(request)
+	case MergeFunctionsPath + DynamicSuffix + base.UrlDelimiter + base.MethodGet:
+		response, err = adminport.doGetMergeFunctionRequest(request)
+	case MergeFunctionsPath + DynamicSuffix + base.UrlDelimiter + base.MethodPost:
+		response, err = adminport.doSetMergeFunctionRequest(request)
+	case MergeFunctionsPath + DynamicSuffix + base.UrlDelimiter + base.MethodDelete:
+		response, err = adminport.doDeleteMergeFunctionRequest(request)
 	case SettingsReplicationsPath + base.UrlDelimiter + base.MethodGet:
 		response, err = adminport.doViewDefaultReplicationSettingsRequest(request)
 	case SettingsReplicationsPath + base.UrlDelimiter + base.MethodPost:
@@ -217,6 +252,8 @@ func (adminport *Adminport) handleRequest(
 		response, err = adminport.doGetStatisticsRequest(request)
 	case RegexpValidationPrefix + base.UrlDelimiter + base.MethodPost:
 		response, err = adminport.doRegexpValidationRequest(request)
+	case FilterSamplePath + base.UrlDelimiter + base.MethodPost:
+		response, err = adminport.doFilterSampleRequest(request)
 	case MemStatsPath + base.UrlDelimiter + base.MethodGet:
 		response, err = adminport.doMemStatsRequest(request)
 	case BlockProfileStartPath + base.UrlDelimiter + base.MethodPost:
@@ -453,6 +490,46 @@ func (adminport *Adminport) doChangeRemoteClusterRequest(request *http.Request)
 	}
 }
 
+// Performs one step of the credential rotation of a remote cluster reference, which does not restart the replications
+func (adminport *Adminport) doRotateCredentialsRequest(request *http.Request) (*ap.Response, error) {
+	logger_ap.Infof("doRotateCredentialsRequest\n")
+	defer logger_ap.Infof("Finished doRotateCredentialsRequest\n")
+
+	response, err := authWebCreds(request, base.PermissionRemoteClusterWrite)
+	if response != nil || err != nil {
+		return response, err
+	}
+
+	remoteClusterName, err := DecodeDynamicParamInURL(request, RotateCredentialsPrefix, "Remote Cluster Name")
+	if err != nil {
+		return EncodeRemoteClusterValidationErrorIntoResponse(err)
+	}
+
+	action, secondary, errorsMap := DecodeRotateCredentialsRequest(request)
+	if len(errorsMap) > 0 {
+		logger_ap.Errorf("Validation error in inputs. errorsMap=%v\n", errorsMap)
+		return EncodeRemoteClusterErrorsMapIntoResponse(errorsMap)
+	}
+
+	logger_ap.Infof("Request params: remoteClusterName=%v, action=%v\n", remoteClusterName, action)
+
+	status, errorsMap, err := RotateRemoteClusterCredentials(remoteClusterName, action, secondary)
+	if len(errorsMap) > 0 {
+		logger_ap.Errorf("Validation error in inputs. errorsMap=%v\n", errorsMap)
+		return EncodeRemoteClusterErrorsMapIntoResponse(errorsMap)
+	} else if err != nil {
+		return EncodeRemoteClusterErrorIntoResponse(err)
+	}
+
+	if action != CredentialRotationVerify {
+		if remoteClusterRef, err := RemoteClusterService().RemoteClusterByRefName(remoteClusterName, false /*refresh*/); err == nil {
+			go writeRemoteClusterAuditEvent(service_def.UpdateRemoteClusterRefEventId, remoteClusterRef, getRealUserIdFromRequest(request), getLocalAndRemoteIps(request))
+			go writeRemoteClusterSystemEvent(service_def.UpdateRemoteClusterRefSystemEventId, remoteClusterRef)
+		}
+	}
+	return EncodeObjectIntoResponse(status)
+}
+
 func (adminport *Adminport) doDeleteRemoteClusterRequest(request *http.Request) (*ap.Response, error) {
 	logger_ap.Infof("doDeleteRemoteClusterRequest\n")
 	defer logger_ap.Infof("Finished doDeleteRemoteClusterRequest\n")
@@ -547,7 +624,7 @@ func (adminport *Adminport) doCreateReplicationRequest(request *http.Request) (*
 	logger_ap.Info("doCreateReplicationRequest")
 	defer logger_ap.Info("Finished doCreateReplicationRequest call")
 
-	justValidate, fromBucket, toCluster, toBucket, settings, errorsMap, err := DecodeCreateReplicationRequest(request)
+	justValidate, fromBucket, toCluster, toBucket, replicationName, settings, errorsMap, err := DecodeCreateReplicationRequest(request)
 	if err != nil {
 		return nil, err
 	} else if len(errorsMap) > 0 {
@@ -560,10 +637,10 @@ func (adminport *Adminport) doCreateReplicationRequest(request *http.Request) (*
 		return response, err
 	}
 
-	logger_ap.Infof("Request parameters: justValidate=%v, fromBucket=%v, toCluster=%v, toBucket=%v, settings=%v\n",
-		justValidate, fromBucket, toCluster, toBucket, settings.CloneAndRedact())
+	logger_ap.Infof("Request parameters: justValidate=%v, fromBucket=%v, toCluster=%v, toBucket=%v, replicationName=%v, settings=%v\n",
+		justValidate, fromBucket, toCluster, toBucket, replicationName, settings.CloneAndRedact())
 
-	replicationId, errorsMap, err, warnings := CreateReplication(justValidate, fromBucket, toCluster, toBucket, settings,
+	replicationId, errorsMap, err, warnings := CreateReplication(justValidate, fromBucket, toCluster, toBucket, replicationName, settings,
 		getRealUserIdFromRequest(request), getLocalAndRemoteIps(request))
 
 	if err != nil {
@@ -576,6 +653,40 @@ func (adminport *Adminport) doCreateReplicationRequest(request *http.Request) (*
 	}
 }
 
+// Validates a create replication request the same way doCreateReplicationRequest does, including the checks against the
+// target cluster that justValidate skips, and returns the effective settings and resolved collections mapping.
+// The replication is not created
+func (adminport *Adminport) doCreateReplicationDryRunRequest(request *http.Request) (*ap.Response, error) {
+	logger_ap.Info("doCreateReplicationDryRunRequest")
+	defer logger_ap.Info("Finished doCreateReplicationDryRunRequest call")
+
+	_, fromBucket, toCluster, toBucket, replicationName, settings, errorsMap, err := DecodeCreateReplicationRequest(request)
+	if err != nil {
+		return nil, err
+	} else if len(errorsMap) > 0 {
+		logger_ap.Errorf("Validation error in inputs. errorsMap=%v\n", errorsMap)
+		return EncodeErrorsMapIntoResponse(errorsMap, true)
+	}
+
+	response, err := authWebCreds(request, constructBucketPermission(fromBucket, base.PermissionBucketXDCRWriteSuffix))
+	if response != nil || err != nil {
+		return response, err
+	}
+
+	logger_ap.Infof("Request parameters: fromBucket=%v, toCluster=%v, toBucket=%v, replicationName=%v, settings=%v\n",
+		fromBucket, toCluster, toBucket, replicationName, settings.CloneAndRedact())
+
+	spec, mapping, errorsMap, err, warnings := DryRunCreateReplication(fromBucket, toCluster, toBucket, replicationName, settings)
+	if err != nil {
+		return EncodeReplicationSpecErrorIntoResponse(err)
+	} else if len(errorsMap) > 0 {
+		logger_ap.Errorf("Error validating replication. errorsMap=%v\n", errorsMap)
+		return EncodeErrorsMapIntoResponse(errorsMap, true)
+	} else {
+		return NewCreateReplicationDryRunResponse(spec, mapping, warnings)
+	}
+}
+
 func (adminport *Adminport) doDeleteReplicationRequest(request *http.Request) (*ap.Response, error) {
 	logger_ap.Infof("doDeleteReplicationRequest\n")
 	defer logger_ap.Infof("Finished doDeleteReplicationRequest\n")
@@ -601,6 +712,425 @@ func (adminport *Adminport) doDeleteReplicationRequest(request *http.Request) (*
 	}
 }
 
+// Only the dead letter files of this node are re-driven
+func (adminport *Adminport) doRedriveDeadLettersRequest(request *http.Request) (*ap.Response, error) {
+	logger_ap.Infof("doRedriveDeadLettersRequest\n")
+	defer logger_ap.Infof("Finished doRedriveDeadLettersRequest\n")
+
+	replicationId, err := DecodeDynamicParamInURL(request, RedriveDeadLettersPrefix, "Replication Id")
+	if err != nil {
+		return EncodeReplicationValidationErrorIntoResponse(err)
+	}
+
+	logger_ap.Infof("Request params: replicationId=%v\n", replicationId)
+
+	response, err := authWebCredsForReplication(request, replicationId, []string{base.PermissionBucketXDCRWriteSuffix})
+	if response != nil || err != nil {
+		return response, err
+	}
+
+	result, err := RedriveDeadLetters(replicationId)
+	if err != nil {
+		return EncodeErrorMessageIntoResponse(err, http.StatusBadRequest)
+	}
+	return EncodeObjectIntoResponse(result)
+}
+
+func (adminport *Adminport) doGetCheckpointsRequest(request *http.Request) (*ap.Response, error) {
+	logger_ap.Infof("doGetCheckpointsRequest\n")
+	defer logger_ap.Infof("Finished doGetCheckpointsRequest\n")
+
+	replicationId, err := DecodeDynamicParamInURL(request, CheckpointsPrefix, "Replication Id")
+	if err != nil {
+		return EncodeReplicationValidationErrorIntoResponse(err)
+	}
+
+	logger_ap.Infof("Request params: replicationId=%v\n", replicationId)
+
+	response, err := authWebCredsForReplication(request, replicationId, []string{base.PermissionBucketXDCRReadSuffix})
+	if response != nil || err != nil {
+		return response, err
+	}
+
+	checkpoints, err := GetCheckpoints(replicationId)
+	if err != nil {
+		return EncodeErrorMessageIntoResponse(err, http.StatusBadRequest)
+	}
+	return EncodeObjectIntoResponse(checkpoints)
+}
+
+// While the replication is running, only the VBs replicated on this node can be rewound
+func (adminport *Adminport) doRewindCheckpointsRequest(request *http.Request) (*ap.Response, error) {
+	logger_ap.Infof("doRewindCheckpointsRequest\n")
+	defer logger_ap.Infof("Finished doRewindCheckpointsRequest\n")
+
+	replicationId, err := DecodeDynamicParamInURL(request, RewindCheckpointsPrefix, "Replication Id")
+	if err != nil {
+		return EncodeReplicationValidationErrorIntoResponse(err)
+	}
+
+	vbnos, ckptIndex, seqno, toSeqno, err := DecodeRewindCheckpointsRequest(request)
+	if err != nil {
+		return EncodeErrorMessageIntoResponse(err, http.StatusBadRequest)
+	}
+
+	logger_ap.Infof("Request params: replicationId=%v, vbnos=%v, ckptIndex=%v, seqno=%v, toSeqno=%v\n", replicationId, vbnos, ckptIndex, seqno, toSeqno)
+
+	response, err := authWebCredsForReplication(request, replicationId, []string{base.PermissionBucketXDCRWriteSuffix})
+	if response != nil || err != nil {
+		return response, err
+	}
+
+	err = RewindCheckpoints(replicationId, vbnos, ckptIndex, seqno, toSeqno)
+	if err != nil {
+		return EncodeErrorMessageIntoResponse(err, http.StatusBadRequest)
+	}
+	return NewEmptyArrayResponse()
+}
+
+func (adminport *Adminport) doExportCheckpointsRequest(request *http.Request) (*ap.Response, error) {
+	logger_ap.Infof("doExportCheckpointsRequest\n")
+	defer logger_ap.Infof("Finished doExportCheckpointsRequest\n")
+
+	replicationId, err := DecodeDynamicParamInURL(request, ExportCheckpointsPrefix, "Replication Id")
+	if err != nil {
+		return EncodeReplicationValidationErrorIntoResponse(err)
+	}
+
+	logger_ap.Infof("Request params: replicationId=%v\n", replicationId)
+
+	response, err := authWebCredsForReplication(request, replicationId, []string{base.PermissionBucketXDCRReadSuffix})
+	if response != nil || err != nil {
+		return response, err
+	}
+
+	ckptsExport, err := ExportCheckpoints(replicationId)
+	if err != nil {
+		return EncodeErrorMessageIntoResponse(err, http.StatusBadRequest)
+	}
+	return EncodeObjectIntoResponse(ckptsExport)
+}
+
+// Only the checkpoints of the VBs replicated on this node are imported, so the same export is to be imported on
+// every node
+func (adminport *Adminport) doImportCheckpointsRequest(request *http.Request) (*ap.Response, error) {
+	logger_ap.Infof("doImportCheckpointsRequest\n")
+	defer logger_ap.Infof("Finished doImportCheckpointsRequest\n")
+
+	replicationId, err := DecodeDynamicParamInURL(request, ImportCheckpointsPrefix, "Replication Id")
+	if err != nil {
+		return EncodeReplicationValidationErrorIntoResponse(err)
+	}
+
+	logger_ap.Infof("Request params: replicationId=%v\n", replicationId)
+
+	response, err := authWebCredsForReplication(request, replicationId, []string{base.PermissionBucketXDCRWriteSuffix})
+	if response != nil || err != nil {
+		return response, err
+	}
+
+	ckptsExport, err := DecodeImportCheckpointsRequest(request)
+	if err != nil {
+		return EncodeErrorMessageIntoResponse(err, http.StatusBadRequest)
+	}
+
+	numOfVBs, err := ImportCheckpoints(replicationId, ckptsExport)
+	if err != nil {
+		return EncodeErrorMessageIntoResponse(err, http.StatusBadRequest)
+	}
+	return EncodeObjectIntoResponse(map[string]interface{}{ImportedVBs: numOfVBs})
+}
+
+// The subject in the URL is a replication id, or one of defaultSettings, globalSettings and internalSettings
+func (adminport *Adminport) doGetSettingsHistoryRequest(request *http.Request) (*ap.Response, error) {
+	logger_ap.Infof("doGetSettingsHistoryRequest\n")
+	defer logger_ap.Infof("Finished doGetSettingsHistoryRequest\n")
+
+	subject, err := DecodeDynamicParamInURL(request, SettingsHistoryPrefix, "Settings History Subject")
+	if err != nil {
+		return EncodeErrorMessageIntoResponse(err, http.StatusBadRequest)
+	}
+
+	logger_ap.Infof("Request params: subject=%v\n", subject)
+
+	subject, response, err := authWebCredsForSettingsHistory(request, subject, false /*write*/)
+	if response != nil || err != nil {
+		return response, err
+	}
+
+	history, err := SettingsHistoryService().GetSettingsHistory(subject)
+	if err != nil {
+		return nil, err
+	}
+	return NewSettingsHistoryResponse(history)
+}
+
+func (adminport *Adminport) doRollbackSettingsRequest(request *http.Request) (*ap.Response, error) {
+	logger_ap.Infof("doRollbackSettingsRequest\n")
+	defer logger_ap.Infof("Finished doRollbackSettingsRequest\n")
+
+	subject, err := DecodeDynamicParamInURL(request, RollbackSettingsPrefix, "Settings History Subject")
+	if err != nil {
+		return EncodeErrorMessageIntoResponse(err, http.StatusBadRequest)
+	}
+
+	subject, response, err := authWebCredsForSettingsHistory(request, subject, true /*write*/)
+	if response != nil || err != nil {
+		return response, err
+	}
+
+	version, err := DecodeRollbackSettingsRequest(request)
+	if err != nil {
+		return EncodeErrorMessageIntoResponse(err, http.StatusBadRequest)
+	}
+
+	logger_ap.Infof("Request params: subject=%v, version=%v\n", subject, version)
+
+	errorsMap, err := RollbackSettings(subject, version, getRealUserIdFromRequest(request), getLocalAndRemoteIps(request))
+	if len(errorsMap) > 0 {
+		logger_ap.Errorf("Validation error in rolled back settings. errorsMap=%v\n", errorsMap)
+		return EncodeErrorsMapIntoResponse(errorsMap, false)
+	} else if err != nil {
+		return EncodeErrorMessageIntoResponse(err, http.StatusBadRequest)
+	}
+
+	history, err := SettingsHistoryService().GetSettingsHistory(subject)
+	if err != nil {
+		return nil, err
+	}
+	return NewSettingsHistoryResponse(history)
+}
+
+// Converts the subject in the URL into the subject of the settings history, and checks that the credentials have the
+// permission that viewing or changing the settings of the subject requires
+func authWebCredsForSettingsHistory(request *http.Request, subject string, write bool) (string, *ap.Response, error) {
+	var permission string
+	switch subject {
+	case metadata.DefaultSettingsHistorySubject, metadata.GlobalSettingsHistorySubject:
+		permission = base.PermissionXDCRSettingsRead
+		if write {
+			permission = base.PermissionXDCRSettingsWrite
+		}
+	case metadata.InternalSettingsHistorySubject:
+		permission = base.PermissionXDCRInternalRead
+		if write {
+			permission = base.PermissionXDCRInternalWrite
+		}
+	default:
+		permissionSuffix := base.PermissionBucketXDCRReadSuffix
+		if write {
+			permissionSuffix = base.PermissionBucketXDCRWriteSuffix
+		}
+		response, err := authWebCredsForReplication(request, subject, []string{permissionSuffix})
+		return metadata.ReplicationSettingsHistorySubject(subject), response, err
+	}
+	response, err := authWebCreds(request, permission)
+	return subject, response, err
+}
+
+func (adminport *Adminport) doGetMergeFunctionsRequest(request *http.Request) (*ap.Response, error) {
+	logger_ap.Infof("doGetMergeFunctionsRequest\n")
+	defer logger_ap.Infof("Finished doGetMergeFunctionsRequest\n")
+
+	response, err := authWebCreds(request, base.PermissionXDCRSettingsRead)
+	if response != nil || err != nil {
+		return response, err
+	}
+
+	mergeFunctionsSvc, err := MergeFunctionsService()
+	if err != nil {
+		return EncodeErrorMessageIntoResponse(err, http.StatusBadRequest)
+	}
+	return EncodeObjectIntoResponse(mergeFunctionsSvc.AllMergeFunctions())
+}
+
+func (adminport *Adminport) doGetMergeFunctionRequest(request *http.Request) (*ap.Response, error) {
+	logger_ap.Infof("doGetMergeFunctionRequest\n")
+	defer logger_ap.Infof("Finished doGetMergeFunctionRequest\n")
+
+	fname, err := DecodeDynamicParamInURL(request, MergeFunctionsPath, "Merge Function Name")
+	if err != nil {
+		return EncodeErrorMessageIntoResponse(err, http.StatusBadRequest)
+	}
+
+	response, err := authWebCreds(request, base.PermissionXDCRSettingsRead)
+	if response != nil || err != nil {
+		return response, err
+	}
+
+	mergeFunctionsSvc, err := MergeFunctionsService()
+	if err != nil {
+		return EncodeErrorMessageIntoResponse(err, http.StatusBadRequest)
+	}
+	spec, err := mergeFunctionsSvc.GetMergeFunction(fname)
+	if err != nil {
+		return EncodeErrorMessageIntoResponse(err, http.StatusNotFound)
+	}
+	return EncodeObjectIntoResponse(spec)
+}
+
+func (adminport *Adminport) doSetMergeFunctionRequest(request *http.Request) (*ap.Response, error) {
+	logger_ap.Infof("doSetMergeFunctionRequest\n")
+	defer logger_ap.Infof("Finished doSetMergeFunctionRequest\n")
+
+	fname, err := DecodeDynamicParamInURL(request, MergeFunctionsPath, "Merge Function Name")
+	if err != nil {
+		return EncodeErrorMessageIntoResponse(err, http.StatusBadRequest)
+	}
+
+	logger_ap.Infof("Request params: mergeFunction=%v\n", fname)
+
+	response, err := authWebCreds(request, base.PermissionXDCRSettingsWrite)
+	if response != nil || err != nil {
+		return response, err
+	}
+
+	specBytes, err := DecodeSetMergeFunctionRequest(request)
+	if err != nil {
+		return EncodeErrorMessageIntoResponse(err, http.StatusBadRequest)
+	}
+
+	mergeFunctionsSvc, err := MergeFunctionsService()
+	if err != nil {
+		return EncodeErrorMessageIntoResponse(err, http.StatusBadRequest)
+	}
+	err = mergeFunctionsSvc.SetMergeFunction(fname, specBytes)
+	if err != nil {
+		return EncodeErrorMessageIntoResponse(err, http.StatusBadRequest)
+	}
+	return NewEmptyArrayResponse()
+}
+
+func (adminport *Adminport) doDeleteMergeFunctionRequest(request *http.Request) (*ap.Response, error) {
+	logger_ap.Infof("doDeleteMergeFunctionRequest\n")
+	defer logger_ap.Infof("Finished doDeleteMergeFunctionRequest\n")
+
+	fname, err := DecodeDynamicParamInURL(request, MergeFunctionsPath, "Merge Function Name")
+	if err != nil {
+		return EncodeErrorMessageIntoResponse(err, http.StatusBadRequest)
+	}
+
+	logger_ap.Infof("Request params: mergeFunction=%v\n", fname)
+
+	response, err := authWebCreds(request, base.PermissionXDCRSettingsWrite)
+	if response != nil || err != nil {
+		return response, err
+	}
+
+	mergeFunctionsSvc, err := MergeFunctionsService()
+	if err != nil {
+		return EncodeErrorMessageIntoResponse(err, http.StatusBadRequest)
+	}
+	err = mergeFunctionsSvc.DelMergeFunction(fname)
+	if err != nil {
+		return EncodeErrorMessageIntoResponse(err, http.StatusNotFound)
+	}
+	return NewEmptyArrayResponse()
+}
+
+// The backup covers all of the XDCR metadata, hence it needs the internal XDCR admin permission. Including the secrets
+// of the remote cluster references also needs the write permission
+func (adminport *Adminport) doMetadataBackupRequest(request *http.Request) (*ap.Response, error) {
+	logger_ap.Infof("doMetadataBackupRequest\n")
+	defer logger_ap.Infof("Finished doMetadataBackupRequest\n")
+
+	passphrase, err := DecodeMetadataBackupRequest(request)
+	if err != nil {
+		return EncodeErrorMessageIntoResponse(err, http.StatusBadRequest)
+	}
+
+	// the secrets are only included when a passphrase is given
+	permission := base.PermissionXDCRAdminInternalRead
+	if passphrase != "" {
+		permission = base.PermissionXDCRAdminInternalWrite
+	}
+	response, err := authWebCreds(request, permission)
+	if response != nil || err != nil {
+		return response, err
+	}
+
+	backup, err := BackupMetadata(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return EncodeObjectIntoResponse(backup)
+}
+
+func (adminport *Adminport) doMetadataRestoreRequest(request *http.Request) (*ap.Response, error) {
+	logger_ap.Infof("doMetadataRestoreRequest\n")
+	defer logger_ap.Infof("Finished doMetadataRestoreRequest\n")
+
+	response, err := authWebCreds(request, base.PermissionXDCRAdminInternalWrite)
+	if response != nil || err != nil {
+		return response, err
+	}
+
+	restoreRequest, err := DecodeMetadataRestoreRequest(request)
+	if err != nil {
+		return EncodeErrorMessageIntoResponse(err, http.StatusBadRequest)
+	}
+
+	logger_ap.Infof("Request params: sections=%v, remoteClusters=%v, replications=%v, targetClusterUUIDs=%v, conflictPolicy=%v\n",
+		restoreRequest.Sections, restoreRequest.RemoteClusters, restoreRequest.Replications, restoreRequest.TargetClusterUUIDs,
+		restoreRequest.ConflictPolicy)
+
+	result, errorsMap, err := RestoreMetadata(restoreRequest, getRealUserIdFromRequest(request), getLocalAndRemoteIps(request))
+	if len(errorsMap) > 0 {
+		logger_ap.Errorf("Validation error in metadata restore request. errorsMap=%v\n", errorsMap)
+		return EncodeErrorsMapIntoResponse(errorsMap, false)
+	} else if err != nil {
+		return EncodeErrorMessageIntoResponse(err, http.StatusBadRequest)
+	}
+	return EncodeObjectIntoResponse(result)
+}
+
+// Reconciles the remote cluster references and replications of this cluster with the topology config in the body.
+// With justValidate, only the plan is returned
+func (adminport *Adminport) doTopologyConfigRequest(request *http.Request) (*ap.Response, error) {
+	logger_ap.Infof("doTopologyConfigRequest\n")
+	defer logger_ap.Infof("Finished doTopologyConfigRequest\n")
+
+	justValidate, config, err := DecodeTopologyConfigRequest(request)
+	if err != nil {
+		return EncodeErrorMessageIntoResponse(err, http.StatusBadRequest)
+	}
+
+	remoteClusterPermission, bucketPermissionSuffix := base.PermissionRemoteClusterWrite, base.PermissionBucketXDCRWriteSuffix
+	if justValidate {
+		remoteClusterPermission, bucketPermissionSuffix = base.PermissionRemoteClusterRead, base.PermissionBucketXDCRReadSuffix
+	}
+	response, err := authWebCreds(request, remoteClusterPermission)
+	if response != nil || err != nil {
+		return response, err
+	}
+
+	logger_ap.Infof("Request params: justValidate=%v, remoteClusters=%v, replications=%v, prune=%v\n",
+		justValidate, len(config.RemoteClusters), len(config.Replications), config.Prune)
+
+	plan, errorsMap, err := PlanTopologyConfig(config)
+	if err != nil {
+		return nil, err
+	} else if len(errorsMap) > 0 {
+		logger_ap.Errorf("Validation error in topology config. errorsMap=%v\n", errorsMap)
+		return EncodeErrorsMapIntoResponse(errorsMap, true)
+	}
+
+	for _, sourceBucket := range plan.SourceBuckets() {
+		response, err = authWebCreds(request, constructBucketPermission(sourceBucket, bucketPermissionSuffix))
+		if response != nil || err != nil {
+			return response, err
+		}
+	}
+
+	if !justValidate {
+		err = ApplyTopologyPlan(plan, getRealUserIdFromRequest(request), getLocalAndRemoteIps(request))
+		if err != nil {
+			return EncodeErrorMessageIntoResponse(err, http.StatusBadRequest)
+		}
+	}
+	return EncodeObjectIntoResponse(plan)
+}
+
 func (adminport *Adminport) doViewDefaultReplicationSettingsRequest(request *http.Request) (*ap.Response, error) {
 	logger_ap.Infof("doViewDefaultReplicationSettingsRequest\n")
 
@@ -1205,6 +1735,32 @@ func (adminport *Adminport) doRegexpValidationRequest(request *http.Request) (*a
 	}
 }
 
+// Runs a filter expression against a sample of documents in the source collection so that the user can
+// see how many documents would be replicated before the replication is created
+func (adminport *Adminport) doFilterSampleRequest(request *http.Request) (*ap.Response, error) {
+	logger_ap.Infof("doFilterSampleRequest\n")
+
+	expression, bucket, collectionNs, sampleSize, err := DecodeFilterSampleRequest(request)
+	if err != nil {
+		return EncodeErrorMessageIntoResponse(err, http.StatusBadRequest)
+	}
+
+	response, err := authWebCreds(request, constructBucketPermission(bucket, base.PermissionBucketDataReadSuffix))
+	if response != nil || err != nil {
+		return response, err
+	}
+
+	logger_ap.Infof("Request params: expression=%v%v%v bucket=%v scope=%v collection=%v sampleSize=%v",
+		base.UdTagBegin, expression, base.UdTagEnd,
+		bucket, collectionNs.ScopeName, collectionNs.CollectionName, sampleSize)
+
+	result, err := adminport.utils.FilterExpressionSampleDocs(expression, bucket, collectionNs, sampleSize, adminport.sourceKVHost, adminport.kvAdminPort)
+	if err != nil {
+		return EncodeErrorMessageIntoResponse(err, http.StatusBadRequest)
+	}
+	return NewFilterSampleResponse(result)
+}
+
 func (adminport *Adminport) doStartBlockProfile(request *http.Request) (*ap.Response, error) {
 	response, err := authWebCreds(request, base.PermissionXDCRAdminInternalWrite)
 	if response != nil || err != nil {
@@ -1328,7 +1884,7 @@ func (adminport *Adminport) doChangeXDCRInternalSettingsRequest(request *http.Re
 
 	logger_ap.Infof("Request params: xdcrInternalSettings=%v\n", settingsMap.CloneAndRedact())
 
-	internalSettings, errorsMap, err := InternalSettingsService().UpdateInternalSettings(settingsMap)
+	internalSettings, errorsMap, err := UpdateInternalSettings(settingsMap, getRealUserIdFromRequest(request))
 	if len(errorsMap) > 0 {
 		logger_ap.Errorf("Validation error in inputs. errorsMap=%v\n", errorsMap)
 		return EncodeErrorsMapIntoResponse(errorsMap, false)
@@ -1354,18 +1910,21 @@ func (adminport *Adminport) doGetPrometheusStatsRequest(request *http.Request, h
 		return response, err
 	}
 
+	exporter := adminport.prometheusExporter
+	getStats := GetAllStatistics
 	if highCardinality {
-		// XDCR currently has no high-cardinality stats
-		return EncodeByteArrayIntoPrometheusResponseWithStatusCode([]byte{}, http.StatusOK)
+		// per collection stats are the only high-cardinality stats
+		exporter = adminport.prometheusCollectionsExporter
+		getStats = GetAllCollectionStatistics
 	}
 
-	expVarMap, err := GetAllStatistics()
+	expVarMap, err := getStats()
 	if err != nil {
 		return nil, err
 	}
 
-	adminport.prometheusExporter.LoadExpVarMap(expVarMap)
-	outputBytes, err := adminport.prometheusExporter.Export()
+	exporter.LoadExpVarMap(expVarMap)
+	outputBytes, err := exporter.Export()
 	if err != nil {
 		return EncodeErrorMessageIntoResponse(err, http.StatusInternalServerError)
 	}
@@ -0,0 +1,142 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software
+will be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package replication_manager
+
+import (
+	"fmt"
+
+	"github.com/couchbase/goxdcr/base"
+	"github.com/couchbase/goxdcr/metadata"
+)
+
+// A credential rotation switches a remote cluster reference over to new credentials without restarting the
+// replications to it:
+// 1. stage - the new credentials are added to the reference as secondary credentials, while target still accepts
+//    the current ones. The connection pools to target fall back on them from then on
+// 2. the credentials are changed on target. Broken connections are repaired with the secondary credentials
+// 3. verify - checks which of the credentials target accepts, along with the connectivity status of the reference
+// 4. retire - the secondary credentials replace the current ones, which is only allowed once target accepts them
+// abort drops the secondary credentials instead
+
+const (
+	CredentialRotationStage  = "stage"
+	CredentialRotationVerify = "verify"
+	CredentialRotationRetire = "retire"
+	CredentialRotationAbort  = "abort"
+
+	// result of verifying credentials that target accepts. Otherwise the result is the error
+	CredentialsAccepted = "accepted"
+)
+
+var CredentialRotationActions = []string{CredentialRotationStage, CredentialRotationVerify, CredentialRotationRetire,
+	CredentialRotationAbort}
+
+type CredentialRotationStatus struct {
+	RemoteClusterName string `json:"remoteClusterName"`
+	// whether secondary credentials are staged
+	Staged bool `json:"staged"`
+	// as reported by the connectivity helper of the reference, which checks the primary credentials
+	ConnectivityStatus string `json:"connectivityStatus"`
+	// only set by verify
+	PrimaryCredentials   string `json:"primaryCredentials,omitempty"`
+	SecondaryCredentials string `json:"secondaryCredentials,omitempty"`
+}
+
+// RotateRemoteClusterCredentials performs one step of the credential rotation of a remote cluster reference. The
+// secondary credentials are only used by the stage action. Invalid inputs are returned in the errors map
+func RotateRemoteClusterCredentials(refName, action string, secondary *base.ConnCredentials) (*CredentialRotationStatus, map[string]error, error) {
+	errorsMap := make(map[string]error)
+	ref, err := RemoteClusterService().RemoteClusterByRefName(refName, false /*refresh*/)
+	if err != nil {
+		errorsMap[base.PlaceHolderFieldKey] = err
+		return nil, errorsMap, nil
+	}
+
+	switch action {
+	case CredentialRotationStage:
+		if secondary == nil {
+			secondary = &base.ConnCredentials{}
+		}
+		validateRemoteClusterParameters(ref.Name(), ref.HostName(), ref.SecureTypeString(), secondary.UserName, secondary.Password,
+			ref.HostnameMode(), ref.Certificates(), secondary.ClientCertificate, secondary.ClientKey, errorsMap)
+		if len(errorsMap) > 0 {
+			return nil, errorsMap, nil
+		}
+		ref.SetSecondaryCredentials(secondary)
+	case CredentialRotationVerify:
+		status, err := verifyCredentialRotation(ref)
+		return status, nil, err
+	case CredentialRotationRetire:
+		// the new credentials are validated against target when the reference is set
+		if err = ref.PromoteSecondaryCredentials(); err != nil {
+			errorsMap[base.PlaceHolderFieldKey] = err
+			return nil, errorsMap, nil
+		}
+	case CredentialRotationAbort:
+		if !ref.HasSecondaryCredentials() {
+			errorsMap[base.PlaceHolderFieldKey] = fmt.Errorf("Remote cluster reference %v has no secondary credentials", refName)
+			return nil, errorsMap, nil
+		}
+		ref.SetSecondaryCredentials(nil)
+	default:
+		errorsMap[CredentialRotationAction] = fmt.Errorf("Invalid action %v. Valid actions are %v", action, CredentialRotationActions)
+		return nil, errorsMap, nil
+	}
+
+	err = RemoteClusterService().SetRemoteCluster(refName, ref)
+	if err != nil {
+		return nil, nil, err
+	}
+	logger_rm.Infof("Performed %v of the credential rotation of remote cluster reference %v", action, refName)
+	status, err := getCredentialRotationStatus(ref)
+	return status, nil, err
+}
+
+func getCredentialRotationStatus(ref *metadata.RemoteClusterReference) (*CredentialRotationStatus, error) {
+	connectivityStatus, err := RemoteClusterService().GetConnectivityStatus(ref)
+	if err != nil {
+		return nil, err
+	}
+	return &CredentialRotationStatus{
+		RemoteClusterName:  ref.Name(),
+		Staged:             ref.HasSecondaryCredentials(),
+		ConnectivityStatus: connectivityStatus.String(),
+	}, nil
+}
+
+// Each set of credentials is checked against target on its own, by validating a copy of the reference that only has it
+func verifyCredentialRotation(ref *metadata.RemoteClusterReference) (*CredentialRotationStatus, error) {
+	status, err := getCredentialRotationStatus(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	primaryRef := ref.Clone()
+	primaryRef.SetSecondaryCredentials(nil)
+	status.PrimaryCredentials = getCredentialsVerificationResult(RemoteClusterService().ValidateRemoteCluster(primaryRef))
+
+	if status.Staged {
+		secondaryRef := ref.Clone()
+		if err = secondaryRef.PromoteSecondaryCredentials(); err != nil {
+			return nil, err
+		}
+		status.SecondaryCredentials = getCredentialsVerificationResult(RemoteClusterService().ValidateRemoteCluster(secondaryRef))
+	}
+	return status, nil
+}
+
+func getCredentialsVerificationResult(err error) string {
+	if err == nil {
+		return CredentialsAccepted
+	}
+	_, err = RemoteClusterService().CheckAndUnwrapRemoteClusterError(err)
+	return err.Error()
+}
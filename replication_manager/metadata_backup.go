@@ -0,0 +1,514 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software
+will be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package replication_manager
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/couchbase/goxdcr/base"
+	"github.com/couchbase/goxdcr/metadata"
+	"github.com/couchbase/goxdcr/service_def"
+)
+
+// A metadata backup holds the remote cluster references, replications and settings of this cluster. Restoring it goes
+// through the same paths as the REST requests that create and change them, so everything is validated against the
+// clusters as they are now. A restored remote cluster reference takes the UUID of the cluster it connects to, and the
+// replications to the cluster it pointed to in the backup follow it, so that a rebuilt target cluster is picked up
+// without a UUID mapping. The collection mappings of a replication are merged into the ones of the replication it is
+// restored as, so that checkpoints imported into it later find the mappings they refer to. The conflict policy only
+// applies to remote cluster references and replications, and the collection mappings of a replication are only restored
+// along with it. The sections of settings that are selected are always applied
+
+const (
+	BackupSectionRemoteClusters     = "remoteClusters"
+	BackupSectionReplications       = "replications"
+	BackupSectionDefaultSettings    = "defaultSettings"
+	BackupSectionGlobalSettings     = "globalSettings"
+	BackupSectionInternalSettings   = "internalSettings"
+	BackupSectionBucketSettings     = "bucketSettings"
+	BackupSectionCollectionMappings = "collectionMappings"
+
+	// leave the existing one as it is
+	RestoreConflictSkip = "skip"
+	// replace the existing one with the one in the backup
+	RestoreConflictOverwrite = "overwrite"
+	// restore nothing if any of the selected ones exists
+	RestoreConflictFail = "fail"
+
+	RestoreActionCreated     = "created"
+	RestoreActionOverwritten = "overwritten"
+	RestoreActionApplied     = "applied"
+	RestoreActionSkipped     = "skipped"
+	RestoreActionFailed      = "failed"
+)
+
+var BackupSections = []string{BackupSectionRemoteClusters, BackupSectionReplications, BackupSectionDefaultSettings,
+	BackupSectionGlobalSettings, BackupSectionInternalSettings, BackupSectionBucketSettings, BackupSectionCollectionMappings}
+
+type MetadataRestoreRequest struct {
+	Backup *metadata.MetadataBackup `json:"backup"`
+	// needed when the backup has secrets
+	Passphrase string `json:"passphrase"`
+	// all sections when empty
+	Sections []string `json:"sections"`
+	// names of the remote cluster references and ids of the replications in the backup to restore. All when empty
+	RemoteClusters []string `json:"remoteClusters"`
+	Replications   []string `json:"replications"`
+	// UUIDs of the target clusters in the backup, mapped to the UUIDs of the clusters that have replaced them
+	TargetClusterUUIDs map[string]string `json:"targetClusterUUIDs"`
+	ConflictPolicy     string            `json:"conflictPolicy"`
+}
+
+type MetadataRestoreOutcome struct {
+	Section string `json:"section"`
+	// remote cluster name or replication id in the backup
+	Name   string `json:"name,omitempty"`
+	Action string `json:"action"`
+	// id of the restored replication, which differs from the one in the backup when the target cluster has changed
+	Id    string `json:"id,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+type MetadataRestoreResult struct {
+	Outcomes []*MetadataRestoreOutcome `json:"outcomes"`
+}
+
+func (result *MetadataRestoreResult) add(section, name, action string, err error) *MetadataRestoreOutcome {
+	outcome := &MetadataRestoreOutcome{Section: section, Name: name, Action: action}
+	if err != nil {
+		outcome.Action = RestoreActionFailed
+		outcome.Error = err.Error()
+	}
+	result.Outcomes = append(result.Outcomes, outcome)
+	return outcome
+}
+
+// Validates the options of the request, and fills in the defaults
+func (request *MetadataRestoreRequest) validate() map[string]error {
+	errorsMap := make(map[string]error)
+	if request.Backup == nil {
+		errorsMap["backup"] = base.MissingParameterError("backup")
+	}
+	if len(request.ConflictPolicy) == 0 {
+		request.ConflictPolicy = RestoreConflictSkip
+	}
+	switch request.ConflictPolicy {
+	case RestoreConflictSkip, RestoreConflictOverwrite, RestoreConflictFail:
+	default:
+		errorsMap["conflictPolicy"] = fmt.Errorf("Invalid conflict policy %v. It needs to be one of %v, %v and %v",
+			request.ConflictPolicy, RestoreConflictSkip, RestoreConflictOverwrite, RestoreConflictFail)
+	}
+	if len(request.Sections) == 0 {
+		request.Sections = BackupSections
+	}
+	for _, section := range request.Sections {
+		if !base.StringListContains(BackupSections, section) {
+			errorsMap["sections"] = fmt.Errorf("Invalid section %v. It needs to be one of %v", section, BackupSections)
+		}
+	}
+	return errorsMap
+}
+
+func (request *MetadataRestoreRequest) hasSection(section string) bool {
+	return base.StringListContains(request.Sections, section)
+}
+
+// BackupMetadata takes a backup of the XDCR metadata of this cluster. The secrets of the remote cluster references
+// are only included when a passphrase is given, encrypted with a key derived from it
+func BackupMetadata(passphrase string) (*metadata.MetadataBackup, error) {
+	clusterUuid, err := XDCRCompTopologyService().MyClusterUuid()
+	if err != nil {
+		return nil, err
+	}
+	backup := metadata.NewMetadataBackup(clusterUuid, time.Now())
+
+	refsMap, err := RemoteClusterService().RemoteClusters()
+	if err != nil {
+		return nil, err
+	}
+	refNames := make([]string, 0, len(refsMap))
+	refsByName := make(map[string]*metadata.RemoteClusterReference)
+	for _, ref := range refsMap {
+		refNames = append(refNames, ref.Name())
+		refsByName[ref.Name()] = ref
+	}
+	sort.Strings(refNames)
+	refs := make([]*metadata.RemoteClusterReference, 0, len(refNames))
+	for _, refName := range refNames {
+		refs = append(refs, refsByName[refName])
+	}
+	err = backup.SetRemoteClusters(refs, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	specs, err := ReplicationSpecService().AllReplicationSpecs()
+	if err != nil {
+		return nil, err
+	}
+	specIds := make([]string, 0, len(specs))
+	for specId := range specs {
+		specIds = append(specIds, specId)
+	}
+	sort.Strings(specIds)
+	sourceBuckets := make(map[string]bool)
+	for _, specId := range specIds {
+		spec := specs[specId].Clone()
+		spec.Revision = nil
+		backup.Replications = append(backup.Replications, spec)
+		sourceBuckets[spec.SourceBucketName] = true
+
+		ckptDocs, err := CheckpointService().CheckpointsDocs(specId, true /*brokenMappingsNeeded*/)
+		if err == service_def.MetadataNotFoundErr {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		ckptsExport, err := metadata.NewCheckpointsExport(spec, ckptDocs, backup.CreatedTime.Unix())
+		if err != nil {
+			return nil, err
+		}
+		if ckptsExport.BrokenMappingsDoc != nil {
+			backup.CollectionMappings[specId] = ckptsExport.BrokenMappingsDoc
+		}
+	}
+
+	defaultSettings, err := ReplicationSettingsService().GetDefaultReplicationSettings()
+	if err != nil {
+		return nil, err
+	}
+	backup.DefaultSettings = defaultSettings.Clone().Values
+	globalSettings, err := GlobalSettingsService().GetGlobalSettings()
+	if err != nil {
+		return nil, err
+	}
+	backup.GlobalSettings = globalSettings.Settings.Clone().Values
+	backup.InternalSettings = InternalSettingsService().GetInternalSettings().Clone().Values
+
+	bucketNames := make([]string, 0, len(sourceBuckets))
+	for bucketName := range sourceBuckets {
+		bucketNames = append(bucketNames, bucketName)
+	}
+	sort.Strings(bucketNames)
+	for _, bucketName := range bucketNames {
+		bucketSettings, err := BucketSettingsService().BucketSettings(bucketName)
+		if err != nil {
+			// the bucket could have been deleted along with its replications being removed
+			logger_rm.Warnf("Skipped the settings of bucket %v in the metadata backup. err=%v", bucketName, err)
+			continue
+		}
+		backup.BucketSettings = append(backup.BucketSettings, &metadata.BucketSettings{
+			BucketName: bucketSettings.BucketName,
+			LWWEnabled: bucketSettings.LWWEnabled,
+		})
+	}
+
+	logger_rm.Infof("Took metadata backup with %v remote clusters and %v replications, secrets included=%v",
+		len(backup.RemoteClusters), len(backup.Replications), backup.SecretsEncryption != nil)
+	return backup, nil
+}
+
+// RestoreMetadata restores the selected sections of the backup. Settings are restored first, then remote cluster
+// references and replications, and internal settings last since changing them restarts the process.
+// A remote cluster reference or replication that fails to be restored does not stop the others, and is reported
+// in the result. The errors map holds the validation errors of the request
+func RestoreMetadata(request *MetadataRestoreRequest, realUserId *service_def.RealUserId, ips *service_def.LocalRemoteIPs) (*MetadataRestoreResult, map[string]error, error) {
+	errorsMap := request.validate()
+	if len(errorsMap) > 0 {
+		return nil, errorsMap, nil
+	}
+	backup := request.Backup
+	logger_rm.Infof("Restoring metadata backup taken at %v on cluster %v, sections=%v, conflictPolicy=%v",
+		backup.CreatedTime, backup.SourceClusterUUID, request.Sections, request.ConflictPolicy)
+
+	var refs []*metadata.RemoteClusterReference
+	if request.hasSection(BackupSectionRemoteClusters) {
+		var err error
+		refs, err = backup.RemoteClusterReferences(request.Passphrase)
+		if err != nil {
+			return nil, nil, err
+		}
+		refs = filterBackupRemoteClusters(refs, request.RemoteClusters)
+	}
+	var specs []*metadata.ReplicationSpecification
+	if request.hasSection(BackupSectionReplications) {
+		specs = filterBackupReplications(backup.Replications, request.Replications)
+	}
+
+	if request.ConflictPolicy == RestoreConflictFail {
+		errorsMap, err := findRestoreConflicts(request, refs, specs)
+		if err != nil || len(errorsMap) > 0 {
+			return nil, errorsMap, err
+		}
+	}
+
+	result := &MetadataRestoreResult{Outcomes: make([]*MetadataRestoreOutcome, 0)}
+	if request.hasSection(BackupSectionGlobalSettings) && len(backup.GlobalSettings) > 0 {
+		errorsMap, err := UpdateDefaultSettings(backup.GlobalSettings, realUserId, ips)
+		result.add(BackupSectionGlobalSettings, "", RestoreActionApplied, errorsMapToError(errorsMap, err))
+	}
+	if request.hasSection(BackupSectionDefaultSettings) && len(backup.DefaultSettings) > 0 {
+		errorsMap, err := UpdateDefaultSettings(backup.DefaultSettings, realUserId, ips)
+		result.add(BackupSectionDefaultSettings, "", RestoreActionApplied, errorsMapToError(errorsMap, err))
+	}
+	if request.hasSection(BackupSectionBucketSettings) {
+		for _, bucketSettings := range backup.BucketSettings {
+			result.add(BackupSectionBucketSettings, bucketSettings.BucketName, RestoreActionApplied, restoreBucketSettings(bucketSettings))
+		}
+	}
+
+	for _, ref := range refs {
+		if len(ref.Password()) == 0 && len(ref.ClientKey()) == 0 {
+			result.add(BackupSectionRemoteClusters, ref.Name(), RestoreActionFailed,
+				fmt.Errorf("The backup has no credentials for remote cluster %v, since it was taken without a passphrase", ref.Name()))
+			continue
+		}
+		action, err := restoreRemoteCluster(ref, request.ConflictPolicy, realUserId, ips)
+		result.add(BackupSectionRemoteClusters, ref.Name(), action, err)
+	}
+
+	if len(specs) > 0 {
+		currentRefs, err := RemoteClusterService().RemoteClusters()
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, spec := range specs {
+			targetRef, err := restoreTargetRemoteCluster(spec, backup, request.TargetClusterUUIDs, currentRefs)
+			if err != nil {
+				result.add(BackupSectionReplications, spec.Id, RestoreActionFailed, err)
+				continue
+			}
+			replicationId, action, err := restoreReplication(spec, targetRef, request.ConflictPolicy, realUserId, ips)
+			result.add(BackupSectionReplications, spec.Id, action, err).Id = replicationId
+			if err != nil || action == RestoreActionSkipped || !request.hasSection(BackupSectionCollectionMappings) {
+				continue
+			}
+			if mappingsDoc, ok := backup.CollectionMappings[spec.Id]; ok && mappingsDoc != nil {
+				err = restoreCollectionMappings(replicationId, mappingsDoc)
+				result.add(BackupSectionCollectionMappings, spec.Id, RestoreActionApplied, err).Id = replicationId
+			}
+		}
+	}
+
+	if request.hasSection(BackupSectionInternalSettings) && len(backup.InternalSettings) > 0 {
+		_, errorsMap, err := UpdateInternalSettings(backup.InternalSettings, realUserId)
+		result.add(BackupSectionInternalSettings, "", RestoreActionApplied, errorsMapToError(errorsMap, err))
+	}
+	return result, nil, nil
+}
+
+func errorsMapToError(errorsMap map[string]error, err error) error {
+	if err != nil {
+		return err
+	} else if len(errorsMap) > 0 {
+		return fmt.Errorf("%v", errorsMap)
+	}
+	return nil
+}
+
+func filterBackupRemoteClusters(refs []*metadata.RemoteClusterReference, names []string) []*metadata.RemoteClusterReference {
+	if len(names) == 0 {
+		return refs
+	}
+	var filtered []*metadata.RemoteClusterReference
+	for _, ref := range refs {
+		if base.StringListContains(names, ref.Name()) {
+			filtered = append(filtered, ref)
+		}
+	}
+	return filtered
+}
+
+func filterBackupReplications(specs []*metadata.ReplicationSpecification, ids []string) []*metadata.ReplicationSpecification {
+	var filtered []*metadata.ReplicationSpecification
+	for _, spec := range specs {
+		if spec != nil && spec.Settings != nil && (len(ids) == 0 || base.StringListContains(ids, spec.Id)) {
+			filtered = append(filtered, spec)
+		}
+	}
+	return filtered
+}
+
+// Returns the remote cluster references and replications of the backup that exist already
+func findRestoreConflicts(request *MetadataRestoreRequest, refs []*metadata.RemoteClusterReference, specs []*metadata.ReplicationSpecification) (map[string]error, error) {
+	errorsMap := make(map[string]error)
+	currentRefs, err := RemoteClusterService().RemoteClusters()
+	if err != nil {
+		return nil, err
+	}
+	currentRefNames := make(map[string]bool)
+	for _, currentRef := range currentRefs {
+		currentRefNames[currentRef.Name()] = true
+	}
+	for _, ref := range refs {
+		if currentRefNames[ref.Name()] {
+			errorsMap[BackupSectionRemoteClusters+"."+ref.Name()] = fmt.Errorf("Remote cluster %v already exists", ref.Name())
+		}
+	}
+	for _, spec := range specs {
+		// a replication to a target cluster that has yet to be restored cannot exist
+		targetRef, err := restoreTargetRemoteCluster(spec, request.Backup, request.TargetClusterUUIDs, currentRefs)
+		if err != nil {
+			continue
+		}
+		replicationId := metadata.NamedReplicationId(spec.SourceBucketName, targetRef.Uuid(), spec.TargetBucketName, spec.ReplicationName)
+		if _, err = ReplicationSpecService().ReplicationSpecReadOnly(replicationId); err == nil {
+			errorsMap[BackupSectionReplications+"."+spec.Id] = fmt.Errorf("Replication %v already exists", replicationId)
+		}
+	}
+	return errorsMap, nil
+}
+
+func restoreBucketSettings(backupSettings *metadata.BucketSettings) error {
+	bucketSettings, err := BucketSettingsService().BucketSettings(backupSettings.BucketName)
+	if err != nil {
+		return err
+	}
+	bucketSettings.LWWEnabled = backupSettings.LWWEnabled
+	return BucketSettingsService().SetBucketSettings(backupSettings.BucketName, bucketSettings)
+}
+
+// The reference goes through the same decoder as the REST request, so that it is set up the same way
+func restoreRemoteCluster(backupRef *metadata.RemoteClusterReference, conflictPolicy string, realUserId *service_def.RealUserId, ips *service_def.LocalRemoteIPs) (string, error) {
+	values := make(url.Values)
+	values.Set(base.RemoteClusterName, backupRef.Name())
+	values.Set(base.RemoteClusterHostName, backupRef.HostName())
+	values.Set(base.RemoteClusterUserName, backupRef.UserName())
+	values.Set(base.RemoteClusterPassword, backupRef.Password())
+	values.Set(base.RemoteClusterSecureType, backupRef.SecureTypeString())
+	values.Set(base.RemoteClusterCertificate, string(backupRef.Certificates()))
+	values.Set(base.RemoteClusterClientCertificate, string(backupRef.ClientCertificate()))
+	values.Set(base.RemoteClusterClientKey, string(backupRef.ClientKey()))
+	values.Set(base.RemoteClusterHostnameMode, backupRef.HostnameMode())
+	for key, valArr := range values {
+		if len(getStringFromValArr(valArr)) == 0 {
+			delete(values, key)
+		}
+	}
+	_, ref, errorsMap, err := DecodeRemoteClusterRequest(newTopologyRequest(values))
+	if err = errorsMapToError(errorsMap, err); err != nil {
+		return RestoreActionFailed, err
+	}
+
+	_, err = RemoteClusterService().RemoteClusterByRefName(ref.Name(), false /*refresh*/)
+	exists := err == nil
+	if exists && conflictPolicy != RestoreConflictOverwrite {
+		return RestoreActionSkipped, nil
+	}
+
+	change := &TopologyChange{Kind: TopologyKindRemoteCluster, Name: ref.Name(), ref: ref, Action: TopologyActionCreate}
+	action := RestoreActionCreated
+	if exists {
+		change.Action = TopologyActionUpdate
+		action = RestoreActionOverwritten
+	}
+	return action, change.applyToRemoteCluster(realUserId, ips)
+}
+
+// Returns the remote cluster reference that the restored replication points to. The target cluster UUID of the
+// replication is mapped first, then matched to a reference by UUID, or by the name of its reference in the backup
+func restoreTargetRemoteCluster(spec *metadata.ReplicationSpecification, backup *metadata.MetadataBackup, targetClusterUUIDs map[string]string,
+	currentRefs map[string]*metadata.RemoteClusterReference) (*metadata.RemoteClusterReference, error) {
+	targetClusterUUID := spec.TargetClusterUUID
+	if mappedUUID, ok := targetClusterUUIDs[targetClusterUUID]; ok {
+		targetClusterUUID = mappedUUID
+	}
+	for _, ref := range currentRefs {
+		if ref.Uuid() == targetClusterUUID {
+			return ref, nil
+		}
+	}
+	for _, refBackup := range backup.RemoteClusters {
+		if refBackup == nil || refBackup.Reference == nil || refBackup.Reference.Uuid() != spec.TargetClusterUUID {
+			continue
+		}
+		for _, ref := range currentRefs {
+			if ref.Name() == refBackup.Reference.Name() {
+				return ref, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("No remote cluster reference points to target cluster %v", targetClusterUUID)
+}
+
+// A replication is restored with the settings it had, except the point to start from, since where the original
+// replication started says nothing about what the target has now
+func restoreReplication(spec *metadata.ReplicationSpecification, targetRef *metadata.RemoteClusterReference, conflictPolicy string,
+	realUserId *service_def.RealUserId, ips *service_def.LocalRemoteIPs) (string, string, error) {
+	replicationId := metadata.NamedReplicationId(spec.SourceBucketName, targetRef.Uuid(), spec.TargetBucketName, spec.ReplicationName)
+	currentSpec, err := ReplicationSpecService().ReplicationSpecReadOnly(replicationId)
+	exists := err == nil
+	if exists && conflictPolicy != RestoreConflictOverwrite {
+		return replicationId, RestoreActionSkipped, nil
+	}
+
+	settings := make(metadata.ReplicationSettingsMap)
+	for key, value := range spec.Settings.Clone().Values {
+		if metadata.IsSettingValueTemporary(key) || !metadata.IsSettingValueMutable(key) {
+			continue
+		}
+		settings[key] = value
+	}
+
+	if !exists {
+		replicationId, errorsMap, err, _ := CreateReplication(false /*justValidate*/, spec.SourceBucketName, targetRef.Name(),
+			spec.TargetBucketName, spec.ReplicationName, settings, realUserId, ips)
+		return replicationId, RestoreActionCreated, errorsMapToError(errorsMap, err)
+	}
+
+	// the type of a replication cannot be changed
+	delete(settings, metadata.ReplicationTypeKey)
+	if settings[metadata.FilterExpressionKey] != currentSpec.Settings.Values[metadata.FilterExpressionKey] {
+		settings[metadata.FilterSkipRestreamKey] = false
+	}
+	errorsMap, err, _ := UpdateReplicationSettings(replicationId, settings, realUserId, ips, false /*justValidate*/)
+	return replicationId, RestoreActionOverwritten, errorsMapToError(errorsMap, err)
+}
+
+// The mappings in the backup are added to the ones that the restored replication has. The ones it has win, since
+// its checkpoints refer to them
+func restoreCollectionMappings(replicationId string, backupDoc *metadata.CollectionNsMappingsDoc) error {
+	spec, err := ReplicationSpecService().ReplicationSpecReadOnly(replicationId)
+	if err != nil {
+		return err
+	}
+	backupShaMap, err := backupDoc.ToShaMap()
+	if err != nil {
+		return err
+	}
+
+	ckptDocs, err := CheckpointService().CheckpointsDocs(replicationId, true /*brokenMappingsNeeded*/)
+	if err == service_def.MetadataNotFoundErr {
+		ckptDocs = make(map[uint16]*metadata.CheckpointsDoc)
+	} else if err != nil {
+		return err
+	}
+	shaMap, mappingsDoc, _, _, err := CheckpointService().LoadBrokenMappings(replicationId)
+	if err != nil {
+		return err
+	}
+	for sha, mapping := range backupShaMap {
+		if _, exists := shaMap[sha]; !exists {
+			shaMap[sha] = mapping
+		}
+	}
+	if mappingsDoc == nil {
+		mappingsDoc = &metadata.CollectionNsMappingsDoc{SpecInternalId: spec.InternalId}
+	}
+	err = mappingsDoc.LoadShaMap(shaMap)
+	if err != nil {
+		return err
+	}
+	return CheckpointService().UpsertBrokenMappingsDoc(replicationId, mappingsDoc, ckptDocs, spec.InternalId)
+}
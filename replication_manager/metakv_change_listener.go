@@ -32,6 +32,15 @@ import (
 var SetTimeSyncRetryInterval = 10 * time.Second
 var BucketSettingsChanSize = 100
 
+// Observes the metadata under a directory. Replaced when the metadata is not kept in metakv
+var observeMetadataChildren = metakv.RunObserveChildrenV2
+
+// SetMetadataObserver has the metadata change listeners observe the metadata through the given function, which
+// follows metakv.RunObserveChildrenV2. It needs to be called before the replication manager is started
+func SetMetadataObserver(observer func(dirpath string, callback func(kve metakv.KVEntry) error, cancel <-chan struct{}) error) {
+	observeMetadataChildren = observer
+}
+
 // generic listener for metadata stored in metakv
 type MetakvChangeListener struct {
 	id                         string
@@ -76,7 +85,7 @@ func (mcl *MetakvChangeListener) Start() error {
 
 func (mcl *MetakvChangeListener) observeChildren() {
 	defer mcl.children_waitgrp.Done()
-	err := metakv.RunObserveChildrenV2(mcl.dirpath, mcl.metakvCallback, mcl.cancel_chan)
+	err := observeMetadataChildren(mcl.dirpath, mcl.metakvCallback, mcl.cancel_chan)
 	// call failure call back only when there are real errors
 	// err may be nil when observeChildren is canceled, in which case there is no need to call failure call back
 	mcl.failureCallback(err)
@@ -322,6 +331,30 @@ func needToReconstructPipeline(oldSettings, newSettings *metadata.ReplicationSet
 	filterChanged := !(oldSettings.FilterExpression == newSettings.FilterExpression)
 	modesChanged := oldSettings.NeedToRestartPipelineDueToCollectionModeChanges(newSettings)
 	rulesChanged := !oldSettings.GetCollectionsRoutingRules().SameAs(newSettings.GetCollectionsRoutingRules())
+	// the conflict logger is only constructed when conflict logging is on, and it opens its destination at start
+	conflictLoggingChanged := oldSettings.GetConflictLoggingEnabled() != newSettings.GetConflictLoggingEnabled() ||
+		oldSettings.GetConflictLoggingDest() != newSettings.GetConflictLoggingDest()
+	// xmem nozzles are given their conflict resolver when they are constructed
+	crStrategyChanged := oldSettings.GetConflictResolutionStrategy() != newSettings.GetConflictResolutionStrategy()
+	// the dcp nozzles are given their end seqnos and the caught up monitor is only constructed in this mode
+	stopWhenCaughtUpChanged := oldSettings.GetStopWhenCaughtUp() != newSettings.GetStopWhenCaughtUp()
+	// the dcp nozzles decide whether to share their streams when they start them
+	sharedSourceStreamChanged := oldSettings.GetSharedSourceStream() != newSettings.GetSharedSourceStream()
+	// routers are given their transformer when they are constructed
+	transformationChanged := oldSettings.GetTransformationRules() != newSettings.GetTransformationRules()
+	// key rewriting decides which target nozzles the pipeline constructs
+	keyRewriteChanged := oldSettings.GetTargetKeyRewriteRules() != newSettings.GetTargetKeyRewriteRules()
+	// the dead letter queue is only constructed when there is a destination, and it opens the destination at start
+	deadLetterChanged := oldSettings.GetDeadLetterDest() != newSettings.GetDeadLetterDest() ||
+		oldSettings.GetDeadLetterIncludeBody() != newSettings.GetDeadLetterIncludeBody()
+	// xmem sets up its delay buffer at start. Mutations held at the time of the restart are not covered by
+	// checkpoints, so they are streamed again and held according to the new delay
+	applyDelayChanged := oldSettings.GetApplyDelay() != newSettings.GetApplyDelay()
+	// the bulk delete breaker is only constructed when it has a limit, and it reads the limits when it is constructed
+	bulkDeleteBreakerChanged := oldSettings.GetBulkDeleteMaxRate() != newSettings.GetBulkDeleteMaxRate() ||
+		oldSettings.GetBulkDeleteMaxPercent() != newSettings.GetBulkDeleteMaxPercent() ||
+		oldSettings.GetBulkDeleteWindow() != newSettings.GetBulkDeleteWindow() ||
+		!oldSettings.GetBulkDeleteAckTime().Equal(newSettings.GetBulkDeleteAckTime())
 
 	// the following may qualify for live update in the future.
 	// batchCount is tricky since the sizes of xmem data channels depend on it.
@@ -330,7 +363,10 @@ func needToReconstructPipeline(oldSettings, newSettings *metadata.ReplicationSet
 	batchSizeChanged := (oldSettings.BatchSize != newSettings.BatchSize)
 
 	return repTypeChanged || sourceNozzlePerNodeChanged || targetNozzlePerNodeChanged ||
-		batchCountChanged || batchSizeChanged || compressionTypeChanged || filterChanged || modesChanged || rulesChanged
+		batchCountChanged || batchSizeChanged || compressionTypeChanged || filterChanged || modesChanged || rulesChanged ||
+		conflictLoggingChanged || crStrategyChanged || stopWhenCaughtUpChanged || sharedSourceStreamChanged ||
+		transformationChanged || keyRewriteChanged || deadLetterChanged || applyDelayChanged ||
+		bulkDeleteBreakerChanged
 }
 
 func needToRestreamPipeline(oldSettings *metadata.ReplicationSettings, newSettings *metadata.ReplicationSettings) bool {
@@ -514,7 +550,20 @@ func (rccl *RemoteClusterChangeListener) remoteClusterChangeHandlerCallback(remo
 		return nil
 	}
 
-	if !oldRemoteClusterRef.AreUserSecurityCredentialsTheSame(newRemoteClusterRef) ||
+	if oldRemoteClusterRef.IsCredentialRotation(newRemoteClusterRef) {
+		if !oldRemoteClusterRef.AreUserSecurityCredentialsTheSame(newRemoteClusterRef) ||
+			!oldRemoteClusterRef.AreSecondaryCredentialsTheSame(newRemoteClusterRef) {
+			// the connections set up with either the old or the new credentials remain valid, so the pipelines are
+			// not restarted. The connection pools pick up the new credentials, which are used by the connections
+			// set up from now on, e.g. when xmem repairs a broken connection
+			primary, secondary := newRemoteClusterRef.ConnCredentials()
+			specs := replication_mgr.pipelineMgr.AllReplicationSpecsForTargetCluster(oldRemoteClusterRef.Uuid())
+			for _, spec := range specs {
+				rccl.logger.Infof("Updating credentials of connection pools of %v since the credentials of the referenced remote cluster %v are being rotated\n", spec.Id, oldRemoteClusterRef.Name())
+				base.ConnPoolMgr().SetCredentialsForPoolsWithNamePrefix(spec.Id, primary, secondary)
+			}
+		}
+	} else if !oldRemoteClusterRef.AreUserSecurityCredentialsTheSame(newRemoteClusterRef) ||
 		!oldRemoteClusterRef.AreSecuritySettingsTheSame(newRemoteClusterRef) {
 		// TODO there may be less disruptive ways to handle the following updates without restarting the pipelines
 		// restarting the pipelines seems to be acceptable considering the low frequency of such updates.
@@ -705,3 +754,26 @@ func (iscl *InternalSettingsChangeListener) internalSettingsChangeHandlerCallbac
 	}
 	return nil
 }
+
+// listener for the merge functions of the in-process merge engine
+type MergeFunctionsChangeListener struct {
+	*MetakvChangeListener
+}
+
+func NewMergeFunctionsChangeListener(merge_functions_svc service_def.MergeFunctionsSvc,
+	cancel_chan chan struct{},
+	children_waitgrp *sync.WaitGroup,
+	logger_ctx *log.LoggerContext,
+	utilsIn utilities.UtilsIface) *MergeFunctionsChangeListener {
+	mfcl := &MergeFunctionsChangeListener{
+		NewMetakvChangeListener(base.MergeFunctionsChangeListener,
+			metadata_svc.GetCatalogPathFromCatalogKey(base.MergeFunctionsCatalogKey),
+			cancel_chan,
+			children_waitgrp,
+			merge_functions_svc.MergeFunctionsServiceCallback,
+			logger_ctx,
+			"MergeFunctionsChangeListener",
+			utilsIn),
+	}
+	return mfcl
+}
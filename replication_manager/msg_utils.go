@@ -19,12 +19,14 @@ import (
 	"reflect"
 	"sort"
 	"strconv"
+	"strings"
 
 	ap "github.com/couchbase/goxdcr/adminport"
 	"github.com/couchbase/goxdcr/base"
 	base2 "github.com/couchbase/goxdcr/base/helpers"
 	"github.com/couchbase/goxdcr/log"
 	"github.com/couchbase/goxdcr/metadata"
+	"github.com/couchbase/goxdcr/parts"
 )
 
 // xdcr prefix for internal settings keys
@@ -33,8 +35,10 @@ var ErrorsKey = "errors"
 // constants used for parsing url path
 const (
 	CreateReplicationPath       = "controller/createReplication"
+	CreateReplicationDryRunPath = CreateReplicationPath + "/dryRun"
 	StatisticsPrefix            = "stats/buckets"
 	RegexpValidationPrefix      = "controller/regexpValidation"
+	FilterSamplePath            = "controller/filterSample"
 	AllReplicationsPath         = "pools/default/replications"
 	AllReplicationInfosPath     = "pools/default/replicationInfos"
 	DeleteReplicationPrefix     = "controller/cancelXDCR"
@@ -43,6 +47,18 @@ const (
 	BlockProfileStartPath       = "profile/block/start"
 	BlockProfileStopPath        = "profile/block/stop"
 	BucketSettingsPrefix        = "controller/bucketSettings"
+	RedriveDeadLettersPrefix    = "controller/redriveDeadLetters"
+	CheckpointsPrefix           = "controller/checkpoints"
+	RewindCheckpointsPrefix     = "controller/rewindCheckpoints"
+	ExportCheckpointsPrefix     = "controller/exportCheckpoints"
+	ImportCheckpointsPrefix     = "controller/importCheckpoints"
+	TopologyConfigPath          = "controller/topologyConfig"
+	SettingsHistoryPrefix       = "controller/settingsHistory"
+	RollbackSettingsPrefix      = "controller/rollbackSettings"
+	MetadataBackupPath          = "controller/metadataBackup"
+	MetadataRestorePath         = "controller/metadataRestore"
+	RotateCredentialsPrefix     = "controller/rotateRemoteClusterCredentials"
+	MergeFunctionsPath          = "controller/mergeFunctions"
 	XDCRInternalSettingsPath    = base.XDCRPrefix + "/internalSettings"
 	XDCRPrometheusStatsPath     = "_prometheusMetrics"
 	XDCRPrometheusStatsHighPath = "_prometheusMetricsHigh"
@@ -97,13 +113,35 @@ const (
 	DismissEventKey                = metadata.DismissEventKey
 	PreReplicateVBMasterCheckKey   = base.PreReplicateVBMasterCheckKey
 	ReplicateCkptIntervalKey       = base.ReplicateCkptIntervalKey
+	ConflictLoggingKey             = base.ConflictLoggingKey
+	ConflictLoggingDestKey         = base.ConflictLoggingDestKey
+	FileExportDirKey               = base.FileExportDirKey
+	ConflictResolutionStrategyKey  = base.ConflictResolutionStrategyKey
+	StopWhenCaughtUpKey            = base.StopWhenCaughtUpKey
+	SharedSourceStreamKey          = base.SharedSourceStreamKey
+	TransformationRulesKey         = base.TransformationRulesKey
+	TargetKeyRemovePrefixKey       = base.TargetKeyRemovePrefixKey
+	TargetKeyRegexKey              = base.TargetKeyRegexKey
+	TargetKeyRegexReplacementKey   = base.TargetKeyRegexReplacementKey
+	TargetKeyAddPrefixKey          = base.TargetKeyAddPrefixKey
+	DeadLetterDestKey              = base.DeadLetterDestKey
+	DeadLetterIncludeBodyKey       = base.DeadLetterIncludeBodyKey
+	ApplyDelayKey                  = base.ApplyDelayKey
+	BulkDeleteMaxRateKey           = base.BulkDeleteMaxRateKey
+	BulkDeleteMaxPercentKey        = base.BulkDeleteMaxPercentKey
+	BulkDeleteWindowKey            = base.BulkDeleteWindowKey
+	BulkDeleteTrippedKey           = base.BulkDeleteTrippedKey
+	StartFromTimeKey               = base.StartFromTimeKey
+	StartFromSeqnosKey             = base.StartFromSeqnosKey
 )
 
 // constants for parsing create/change/view replication response
 const (
-	ReplicationId = "id"
-	Warnings      = "warnings"
-	Result        = "result"
+	ReplicationId      = "id"
+	Warnings           = "warnings"
+	Result             = "result"
+	EffectiveSettings  = "settings"
+	CollectionsMapping = "collectionsMapping"
 )
 
 // constants for RegexpValidation request
@@ -120,6 +158,32 @@ const (
 	MatchError  = "error"
 )
 
+// constants for FilterSample request, which also uses Expression, Bucket, Scope and Collection above
+const (
+	SampleSize = "sampleSize"
+)
+
+// constants for RewindCheckpoints request
+const (
+	// comma separated list of VBs. All VBs are rewound when it is not specified
+	Vbs = "vbs"
+	// index of the checkpoint record to rewind to, with the newest record at 0
+	CheckpointIndex = "checkpointIndex"
+	// source seqno to rewind to
+	RewindSeqno = "seqno"
+	// version of the settings history to roll back to
+	SettingsVersion = "version"
+	// passphrase that the secrets in a metadata backup are encrypted with
+	BackupPassphrase = "passphrase"
+	// step of a remote cluster credential rotation
+	CredentialRotationAction = "action"
+)
+
+// constants for ImportCheckpoints response
+const (
+	ImportedVBs = "importedVBs"
+)
+
 // constants used for parsing bucket setting changes
 const (
 	BucketName = "bucketName"
@@ -199,6 +263,26 @@ var RestKeyToSettingsKeyMap = map[string]string{
 	DismissEventKey:                   metadata.DismissEventKey,
 	PreReplicateVBMasterCheckKey:      metadata.PreReplicateVBMasterCheckKey,
 	ReplicateCkptIntervalKey:          metadata.ReplicateCkptIntervalKey,
+	ConflictLoggingKey:                metadata.ConflictLoggingKey,
+	ConflictLoggingDestKey:            metadata.ConflictLoggingDestKey,
+	FileExportDirKey:                  metadata.FileExportDirKey,
+	ConflictResolutionStrategyKey:     metadata.ConflictResolutionStrategyKey,
+	StopWhenCaughtUpKey:               metadata.StopWhenCaughtUpKey,
+	SharedSourceStreamKey:             metadata.SharedSourceStreamKey,
+	TransformationRulesKey:            metadata.TransformationRulesKey,
+	TargetKeyRemovePrefixKey:          metadata.TargetKeyRemovePrefixKey,
+	TargetKeyRegexKey:                 metadata.TargetKeyRegexKey,
+	TargetKeyRegexReplacementKey:      metadata.TargetKeyRegexReplacementKey,
+	TargetKeyAddPrefixKey:             metadata.TargetKeyAddPrefixKey,
+	DeadLetterDestKey:                 metadata.DeadLetterDestKey,
+	DeadLetterIncludeBodyKey:          metadata.DeadLetterIncludeBodyKey,
+	ApplyDelayKey:                     metadata.ApplyDelayKey,
+	BulkDeleteMaxRateKey:              metadata.BulkDeleteMaxRateKey,
+	BulkDeleteMaxPercentKey:           metadata.BulkDeleteMaxPercentKey,
+	BulkDeleteWindowKey:               metadata.BulkDeleteWindowKey,
+	BulkDeleteTrippedKey:              metadata.BulkDeleteTrippedKey,
+	StartFromTimeKey:                  metadata.StartFromTimeKey,
+	StartFromSeqnosKey:                metadata.StartFromSeqnosKey,
 }
 
 // internal replication settings key -> replication settings key in rest api
@@ -245,6 +329,26 @@ var SettingsKeyToRestKeyMap = map[string]string{
 	metadata.DismissEventKey:                   DismissEventKey,
 	metadata.PreReplicateVBMasterCheckKey:      PreReplicateVBMasterCheckKey,
 	metadata.ReplicateCkptIntervalKey:          ReplicateCkptIntervalKey,
+	metadata.ConflictLoggingKey:                ConflictLoggingKey,
+	metadata.ConflictLoggingDestKey:            ConflictLoggingDestKey,
+	metadata.FileExportDirKey:                  FileExportDirKey,
+	metadata.ConflictResolutionStrategyKey:     ConflictResolutionStrategyKey,
+	metadata.StopWhenCaughtUpKey:               StopWhenCaughtUpKey,
+	metadata.SharedSourceStreamKey:             SharedSourceStreamKey,
+	metadata.TransformationRulesKey:            TransformationRulesKey,
+	metadata.TargetKeyRemovePrefixKey:          TargetKeyRemovePrefixKey,
+	metadata.TargetKeyRegexKey:                 TargetKeyRegexKey,
+	metadata.TargetKeyRegexReplacementKey:      TargetKeyRegexReplacementKey,
+	metadata.TargetKeyAddPrefixKey:             TargetKeyAddPrefixKey,
+	metadata.DeadLetterDestKey:                 DeadLetterDestKey,
+	metadata.DeadLetterIncludeBodyKey:          DeadLetterIncludeBodyKey,
+	metadata.ApplyDelayKey:                     ApplyDelayKey,
+	metadata.BulkDeleteMaxRateKey:              BulkDeleteMaxRateKey,
+	metadata.BulkDeleteMaxPercentKey:           BulkDeleteMaxPercentKey,
+	metadata.BulkDeleteWindowKey:               BulkDeleteWindowKey,
+	metadata.BulkDeleteTrippedKey:              BulkDeleteTrippedKey,
+	metadata.StartFromTimeKey:                  StartFromTimeKey,
+	metadata.StartFromSeqnosKey:                StartFromSeqnosKey,
 }
 
 // Conversion to REST for user -> pauseRequested - Pretty much a NOT operation
@@ -311,9 +415,12 @@ func getReplicationDocMap(replSpec *metadata.ReplicationSpecification) map[strin
 
 		// special transformation for replication type and active flag
 		replDocMap[base.ReplicationDocPauseRequestedOutput] = !replSpec.Settings.Active
-		if replSpec.Settings.RepType == metadata.ReplicationTypeXmem {
+		switch replSpec.Settings.RepType {
+		case metadata.ReplicationTypeXmem:
 			replDocMap[base.ReplicationDocType] = base.ReplicationDocTypeXmem
-		} else {
+		case metadata.ReplicationTypeFile:
+			replDocMap[base.ReplicationDocType] = base.ReplicationDocTypeFile
+		default:
 			replDocMap[base.ReplicationDocType] = base.ReplicationDocTypeCapi
 		}
 
@@ -574,7 +681,7 @@ func NewEmptyArrayResponse() (*ap.Response, error) {
 }
 
 // decode parameters from create replication request
-func DecodeCreateReplicationRequest(request *http.Request) (justValidate bool, fromBucket, toCluster, toBucket string, settings metadata.ReplicationSettingsMap, errorsMap map[string]error, err error) {
+func DecodeCreateReplicationRequest(request *http.Request) (justValidate bool, fromBucket, toCluster, toBucket, replicationName string, settings metadata.ReplicationSettingsMap, errorsMap map[string]error, err error) {
 	errorsMap = make(map[string]error)
 	var replicationType string
 
@@ -586,6 +693,7 @@ func DecodeCreateReplicationRequest(request *http.Request) (justValidate bool, f
 
 	// default isCapi to false if replication type is not explicitly specified in request
 	isCapi := false
+	isFileExport := false
 
 	for key, valArr := range request.Form {
 		switch key {
@@ -600,6 +708,12 @@ func DecodeCreateReplicationRequest(request *http.Request) (justValidate bool, f
 			toCluster = getStringFromValArr(valArr)
 		case base.ToBucket:
 			toBucket = getStringFromValArr(valArr)
+		case base.ReplicationName:
+			replicationName = getStringFromValArr(valArr)
+			if err = metadata.ValidateReplicationName(replicationName); err != nil {
+				errorsMap[base.ReplicationName] = err
+				err = nil
+			}
 		case base.JustValidate:
 			justValidate, err = getBoolFromValArr(valArr, false)
 			if err != nil {
@@ -608,6 +722,7 @@ func DecodeCreateReplicationRequest(request *http.Request) (justValidate bool, f
 		case base.Type:
 			replType := getStringFromValArr(valArr)
 			isCapi = (replType == metadata.ReplicationTypeCapi)
+			isFileExport = (replType == metadata.ReplicationTypeFile)
 		default:
 			// ignore other parameters
 		}
@@ -620,11 +735,21 @@ func DecodeCreateReplicationRequest(request *http.Request) (justValidate bool, f
 	if len(fromBucket) == 0 {
 		errorsMap[base.FromBucket] = base.MissingValueError("source bucket")
 	}
-	if len(toCluster) == 0 {
-		errorsMap[base.ToCluster] = base.MissingValueError("target cluster")
-	}
-	if len(toBucket) == 0 {
-		errorsMap[base.ToBucket] = base.MissingValueError("target bucket")
+	if isFileExport {
+		// file export replications have no target cluster. The target bucket, if given, only names the archive
+		if len(toCluster) > 0 {
+			errorsMap[base.ToCluster] = fmt.Errorf("%v cannot be specified for replication type %v", base.ToCluster, metadata.ReplicationTypeFile)
+		}
+		if len(toBucket) == 0 {
+			toBucket = fromBucket
+		}
+	} else {
+		if len(toCluster) == 0 {
+			errorsMap[base.ToCluster] = base.MissingValueError("target cluster")
+		}
+		if len(toBucket) == 0 {
+			errorsMap[base.ToBucket] = base.MissingValueError("target bucket")
+		}
 	}
 
 	settings, settingsErrorsMap := DecodeSettingsFromRequest(request, false, false, isCapi)
@@ -637,7 +762,11 @@ func DecodeCreateReplicationRequest(request *http.Request) (justValidate bool, f
 		errorsMap[FilterExpression] = err
 	}
 
-	err = validateCollectionsMappingRule(settings, base.CollectionsMgtDefault, fromBucket, toBucket, toCluster)
+	if isFileExport {
+		err = validateFileExportCollectionsSettings(settings)
+	} else {
+		err = validateCollectionsMappingRule(settings, base.CollectionsMgtDefault, fromBucket, toBucket, toCluster)
+	}
 	if err != nil {
 		errorsMap[CollectionsMappingRulesKey] = err
 	}
@@ -646,6 +775,11 @@ func DecodeCreateReplicationRequest(request *http.Request) (justValidate bool, f
 	if err != nil {
 		errorsMap[MergeFunctionMappingKey] = err
 	}
+
+	err = validateConflictResolutionStrategy(settings)
+	if err != nil {
+		errorsMap[ConflictResolutionStrategyKey] = err
+	}
 	cleanupTempReplicationSettingKeys(settings)
 	return
 }
@@ -684,6 +818,16 @@ func filterExpressionVariousChecks(settings metadata.ReplicationSettingsMap, cre
 	return nil
 }
 
+// File export replications have no target manifest to map to. Every source collection is exported to its own namespace
+func validateFileExportCollectionsSettings(settings metadata.ReplicationSettingsMap) error {
+	_, modeExists := settings[metadata.CollectionsMgtMultiKey]
+	_, rulesExist := settings[metadata.CollectionsMappingRulesKey]
+	if modeExists || rulesExist {
+		return fmt.Errorf("collections mapping cannot be specified for replication type %v", metadata.ReplicationTypeFile)
+	}
+	return nil
+}
+
 func validateCollectionsMappingRule(settings metadata.ReplicationSettingsMap, currentMode base.CollectionsMgtType, srcBucket, targetBucketName, targetClusterName string) error {
 	mappingRulesObj, exists := settings[metadata.CollectionsMappingRulesKey]
 	if !exists {
@@ -762,6 +906,19 @@ func validateSrcNamespacesExist(targetClusterName string, srcBucket string, targ
 	return nil
 }
 
+// The strategies are registered by the parts package, which is why this cannot be done with the other setting values
+func validateConflictResolutionStrategy(settings metadata.ReplicationSettingsMap) error {
+	strategy, exists := settings[metadata.ConflictResolutionStrategyKey]
+	if !exists {
+		return nil
+	}
+	strategyStr, ok := strategy.(string)
+	if !ok {
+		return fmt.Errorf("Invalid conflict resolution strategy %v", strategy)
+	}
+	return parts.ValidateConflictResolutionStrategy(strategyStr)
+}
+
 func validateMergeFunctionMapping(settings metadata.ReplicationSettingsMap) error {
 	mergeFunctionMapping, exists := settings[metadata.MergeFunctionMappingKey]
 	if !exists {
@@ -839,22 +996,34 @@ func DecodeChangeReplicationSettings(request *http.Request, replicationId string
 		}
 		srcBucket = spec.SourceBucketName
 		tgtBucket = spec.TargetBucketName
-		ref, err := RemoteClusterService().RemoteClusterByUuid(spec.TargetClusterUUID, false)
-		if err != nil {
-			errorsMap[base.PlaceHolderFieldKey] = err
-			return
-		}
-		tgtClusterName = ref.Name()
+		if spec.IsFileExport() {
+			err = validateFileExportCollectionsSettings(settings)
+			if err != nil {
+				errorsMap[CollectionsMappingRulesKey] = err
+			}
+		} else {
+			ref, err := RemoteClusterService().RemoteClusterByUuid(spec.TargetClusterUUID, false)
+			if err != nil {
+				errorsMap[base.PlaceHolderFieldKey] = err
+				return
+			}
+			tgtClusterName = ref.Name()
 
-		err = validateCollectionsMappingRule(settings, currentCollectionMode, srcBucket, tgtBucket, tgtClusterName)
-		if err != nil {
-			errorsMap[CollectionsMappingRulesKey] = err
+			err = validateCollectionsMappingRule(settings, currentCollectionMode, srcBucket, tgtBucket, tgtClusterName)
+			if err != nil {
+				errorsMap[CollectionsMappingRulesKey] = err
+			}
 		}
 	}
 	err = validateMergeFunctionMapping(settings)
 	if err != nil {
 		errorsMap[MergeFunctionMappingKey] = err
 	}
+
+	err = validateConflictResolutionStrategy(settings)
+	if err != nil {
+		errorsMap[ConflictResolutionStrategyKey] = err
+	}
 	return
 }
 
@@ -1018,6 +1187,229 @@ func DecodeRegexpValidationRequest(request *http.Request) (expression, docId, bu
 	return
 }
 
+func DecodeFilterSampleRequest(request *http.Request) (expression, bucket string, collectionNamespace *base.CollectionNamespace, sampleSize int, err error) {
+	if err = request.ParseForm(); err != nil {
+		return
+	}
+
+	collectionNamespace = base.NewDefaultCollectionNamespace()
+	sampleSize = base.FilterSampleSizeDefault
+	var scopeSpecified bool
+	var collectionSpecified bool
+
+	for key, valArr := range request.Form {
+		switch key {
+		case Expression:
+			expression = getStringFromValArr(valArr)
+		case Bucket:
+			bucket = getStringFromValArr(valArr)
+		case Scope:
+			scopeSpecified = true
+			collectionNamespace.ScopeName = getStringFromValArr(valArr)
+		case Collection:
+			collectionSpecified = true
+			collectionNamespace.CollectionName = getStringFromValArr(valArr)
+		case SampleSize:
+			sampleSize, err = strconv.Atoi(getStringFromValArr(valArr))
+			if err != nil || sampleSize <= 0 || sampleSize > base.FilterSampleSizeMax {
+				err = base.InvalidValueError("an integer", 1, base.FilterSampleSizeMax)
+			}
+		default:
+			// ignore other parameters
+		}
+	}
+
+	if err != nil {
+		return
+	}
+
+	if len(expression) == 0 {
+		err = base.MissingParameterError(Expression)
+	} else if len(bucket) == 0 {
+		err = base.MissingParameterError(Bucket)
+	} else if scopeSpecified && !collectionSpecified {
+		err = base.MissingParameterError(Collection)
+	} else if collectionSpecified && !scopeSpecified {
+		err = base.MissingParameterError(Scope)
+	}
+	return
+}
+
+// Exactly one of checkpointIndex and seqno is to be specified
+func DecodeRewindCheckpointsRequest(request *http.Request) (vbnos []uint16, ckptIndex int, seqno uint64, toSeqno bool, err error) {
+	if err = request.ParseForm(); err != nil {
+		return
+	}
+
+	var ckptIndexSpecified bool
+	for key, valArr := range request.Form {
+		switch key {
+		case Vbs:
+			for _, vbStr := range strings.Split(getStringFromValArr(valArr), ",") {
+				vbStr = strings.TrimSpace(vbStr)
+				if len(vbStr) == 0 {
+					continue
+				}
+				vbno, parseErr := strconv.ParseUint(vbStr, 10, 16)
+				if parseErr != nil || vbno >= base.NumberOfVbs {
+					err = base.InvalidValueError("a comma separated list of integers", 0, base.NumberOfVbs-1)
+					return
+				}
+				vbnos = append(vbnos, uint16(vbno))
+			}
+		case CheckpointIndex:
+			ckptIndexSpecified = true
+			ckptIndex, err = strconv.Atoi(getStringFromValArr(valArr))
+			if err != nil || ckptIndex < 0 || ckptIndex >= base.MaxCheckpointRecordsToKeep {
+				err = base.InvalidValueError("an integer", 0, base.MaxCheckpointRecordsToKeep-1)
+				return
+			}
+		case RewindSeqno:
+			toSeqno = true
+			seqno, err = strconv.ParseUint(getStringFromValArr(valArr), 10, 64)
+			if err != nil {
+				err = base.IncorrectValueTypeError("an unsigned integer")
+				return
+			}
+		default:
+			// ignore other parameters
+		}
+	}
+
+	if ckptIndexSpecified == toSeqno {
+		err = fmt.Errorf("Exactly one of %v and %v is required", CheckpointIndex, RewindSeqno)
+	}
+	return
+}
+
+// The body of the request is the output of the export
+func DecodeImportCheckpointsRequest(request *http.Request) (*metadata.CheckpointsExport, error) {
+	bodyBytes, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		return nil, err
+	}
+	ckptsExport := &metadata.CheckpointsExport{}
+	err = json.Unmarshal(bodyBytes, ckptsExport)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse checkpoints export. err=%v", err)
+	}
+	if len(ckptsExport.CheckpointsDocs) == 0 {
+		return nil, base.MissingParameterError("checkpointsDocs")
+	}
+	return ckptsExport, nil
+}
+
+// The body of the request is the json encoded merge function
+func DecodeSetMergeFunctionRequest(request *http.Request) ([]byte, error) {
+	specBytes, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		return nil, err
+	}
+	if len(specBytes) == 0 {
+		return nil, base.MissingParameterError("merge function")
+	}
+	return specBytes, nil
+}
+
+func DecodeRollbackSettingsRequest(request *http.Request) (uint64, error) {
+	if err := request.ParseForm(); err != nil {
+		return 0, ErrorParsingForm
+	}
+	versionStr := request.Form.Get(SettingsVersion)
+	if len(versionStr) == 0 {
+		return 0, base.MissingParameterError(SettingsVersion)
+	}
+	version, err := strconv.ParseUint(versionStr, 10, 64)
+	if err != nil {
+		return 0, base.IncorrectValueTypeError("an integer")
+	}
+	return version, nil
+}
+
+func DecodeMetadataBackupRequest(request *http.Request) (string, error) {
+	if err := request.ParseForm(); err != nil {
+		return "", ErrorParsingForm
+	}
+	return request.Form.Get(BackupPassphrase), nil
+}
+
+// decode the action of a remote cluster credential rotation request, and the secondary credentials to stage, which
+// are given with the same parameters as the credentials of a remote cluster reference
+func DecodeRotateCredentialsRequest(request *http.Request) (action string, secondary *base.ConnCredentials, errorsMap map[string]error) {
+	errorsMap = make(map[string]error)
+	if err := request.ParseForm(); err != nil {
+		errorsMap[base.PlaceHolderFieldKey] = ErrorParsingForm
+		return
+	}
+
+	secondary = &base.ConnCredentials{}
+	for key, valArr := range request.Form {
+		switch key {
+		case CredentialRotationAction:
+			action = getStringFromValArr(valArr)
+		case base.RemoteClusterUserName:
+			secondary.UserName = getStringFromValArr(valArr)
+		case base.RemoteClusterPassword:
+			secondary.Password = getStringFromValArr(valArr)
+		case base.RemoteClusterClientCertificate:
+			secondary.ClientCertificate = []byte(getStringFromValArr(valArr))
+		case base.RemoteClusterClientKey:
+			secondary.ClientKey = []byte(getStringFromValArr(valArr))
+		default:
+			// ignore other parameters
+		}
+	}
+
+	if len(action) == 0 {
+		errorsMap[CredentialRotationAction] = base.MissingParameterError(CredentialRotationAction)
+	}
+	return
+}
+
+// The body of the request is the backup along with the restore options in JSON
+func DecodeMetadataRestoreRequest(request *http.Request) (*MetadataRestoreRequest, error) {
+	bodyBytes, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		return nil, err
+	}
+	restoreRequest := &MetadataRestoreRequest{}
+	err = json.Unmarshal(bodyBytes, restoreRequest)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse metadata restore request. err=%v", err)
+	}
+	if restoreRequest.Backup != nil {
+		err = restoreRequest.Backup.PostProcessAfterUnmarshalling()
+	}
+	return restoreRequest, err
+}
+
+// The body of the request is the topology config in JSON. Numbers are kept as they are written, so that they are
+// validated the same way as the parameters of the REST requests
+func DecodeTopologyConfigRequest(request *http.Request) (justValidate bool, config *TopologyConfig, err error) {
+	bodyBytes, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		return
+	}
+	if err = request.ParseForm(); err != nil {
+		err = ErrorParsingForm
+		return
+	}
+	justValidate, err = DecodeJustValidateFromRequest(request)
+	if err != nil {
+		return
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(bodyBytes))
+	decoder.UseNumber()
+	decoder.DisallowUnknownFields()
+	config = &TopologyConfig{}
+	err = decoder.Decode(config)
+	if err != nil {
+		err = fmt.Errorf("Unable to parse topology config. err=%v", err)
+	}
+	return
+}
+
 func NewCreateReplicationResponse(replicationId string, warnings service_def.UIWarnings, justValidate bool) (*ap.Response, error) {
 	params := make(map[string]interface{})
 	params[ReplicationId] = replicationId
@@ -1031,6 +1423,58 @@ func NewCreateReplicationResponse(replicationId string, warnings service_def.UIW
 	return EncodeObjectIntoResponse(params)
 }
 
+// Setting keys and values are shown the way the REST API takes them, so that they can be compared with the settings
+func NewSettingsHistoryResponse(history *metadata.SettingsHistory) (*ap.Response, error) {
+	entries := make([]map[string]interface{}, 0, len(history.Entries))
+	for _, entry := range history.Entries {
+		entries = append(entries, map[string]interface{}{
+			SettingsVersion: entry.Version,
+			"time":          entry.Time,
+			"user":          entry.User,
+			"before":        convertSettingsHistoryValuesToRESTValues(history.Subject, entry.Before),
+			"after":         convertSettingsHistoryValuesToRESTValues(history.Subject, entry.After),
+		})
+	}
+	return EncodeObjectIntoResponse(map[string]interface{}{"entries": entries})
+}
+
+func convertSettingsHistoryValuesToRESTValues(subject string, values map[string]interface{}) map[string]interface{} {
+	if subject == metadata.InternalSettingsHistorySubject {
+		// internal settings use the same keys in the REST API
+		return values
+	}
+	restValues := make(map[string]interface{})
+	for key, value := range values {
+		restKey, ok := SettingsKeyToRestKeyMap[key]
+		if !ok {
+			restKey = key
+		}
+		restValues[restKey] = convertSettingsInternalValuesToRESTValues(restKey, value)
+	}
+	return restValues
+}
+
+// The mapping is keyed by the source namespace, or the filter expression for migration, to the list of target "scope.collection"
+func NewCreateReplicationDryRunResponse(spec *metadata.ReplicationSpecification, mapping metadata.CollectionNamespaceMapping, warnings service_def.UIWarnings) (*ap.Response, error) {
+	mappingOutput := make(map[string][]string)
+	for source, targets := range mapping {
+		for _, target := range targets {
+			mappingOutput[source.String()] = append(mappingOutput[source.String()], target.ToIndexString())
+		}
+	}
+
+	params := make(map[string]interface{})
+	params[ReplicationId] = spec.Id
+	params[EffectiveSettings] = convertSettingsToRestSettingsMap(spec.Settings, false)
+	params[CollectionsMapping] = mappingOutput
+	if warnings != nil && warnings.Len() > 0 {
+		params[Warnings] = warnings.GetSuccessfulWarningStrings()
+	} else {
+		params[Warnings] = []string{}
+	}
+	return EncodeObjectIntoResponse(params)
+}
+
 func NewReplicationSettingsResponse(settings *metadata.ReplicationSettings, warnings service_def.UIWarnings, includeWarnings bool) (*ap.Response, error) {
 	if settings == nil {
 		if warnings == nil || warnings.Len() == 0 {
@@ -1086,6 +1530,10 @@ func NewRegexpValidationResponse(result bool, err error) (*ap.Response, error) {
 	return EncodeObjectIntoResponseSensitive(returnMap)
 }
 
+func NewFilterSampleResponse(result *base.FilterSampleResult) (*ap.Response, error) {
+	return EncodeObjectIntoResponseSensitive(result)
+}
+
 // decode dynamic paramater from the path of http request
 func DecodeDynamicParamInURL(request *http.Request, pathPrefix string, paramName string) (string, error) {
 	// length of prefix preceding replicationId in request url path
@@ -97,9 +97,11 @@ type replicationManager struct {
 	bucket_settings_svc service_def.BucketSettingsSvc
 	//internal settings service
 	internal_settings_svc service_def.InternalSettingsSvc
+	//settings history service
+	settings_history_svc service_def.SettingsHistorySvc
 	// Mockable utils object
 	utils        utilities.UtilsIface
-	resolver_svc *service_def.ResolverSvcIface
+	resolver_svc service_def.ResolverSvcIface
 	// Collections Manifests service
 	collectionsManifestSvc service_def.CollectionsManifestSvc
 	// Backfill replication service
@@ -147,6 +149,7 @@ func StartReplicationManager(sourceKVHost string,
 	global_setting_svc service_def.GlobalSettingsSvc,
 	bucket_settings_svc service_def.BucketSettingsSvc,
 	internal_settings_svc service_def.InternalSettingsSvc,
+	settings_history_svc service_def.SettingsHistorySvc,
 	throughput_throttler_svc service_def.ThroughputThrottlerSvc,
 	resolver_svc service_def.ResolverSvcIface,
 	utilitiesIn utilities.UtilsIface,
@@ -173,7 +176,7 @@ func StartReplicationManager(sourceKVHost string,
 		replication_mgr.init(repl_spec_svc, remote_cluster_svc,
 			xdcr_topology_svc, replication_settings_svc, checkpoint_svc, capi_svc,
 			audit_svc, uilog_svc, eventlog_svc, global_setting_svc, bucket_settings_svc, internal_settings_svc,
-			throughput_throttler_svc, resolver_svc, collectionsManifestSvc, backfillReplSvc, bucketTopologySvc,
+			settings_history_svc, throughput_throttler_svc, resolver_svc, collectionsManifestSvc, backfillReplSvc, bucketTopologySvc,
 			securitySvc, p2pMgr)
 
 		// start replication manager supervisor
@@ -339,6 +342,8 @@ func InitConstants(xdcr_topology_svc service_def.XDCRCompTopologySvc, internal_s
 		time.Duration(internal_settings.Values[metadata.ThroughSeqnoBgScannerFreqKey].(int))*time.Second,
 		time.Duration(internal_settings.Values[metadata.ThroughSeqnoBgScannerLogFreqKey].(int))*time.Second,
 		time.Duration(internal_settings.Values[metadata.PipelineTimeoutP2PProtocolKey].(int))*time.Second,
+		internal_settings.Values[metadata.CollectionStatsMaxEntriesKey].(int),
+		internal_settings.Values[metadata.FileExportRootDirKey].(string),
 	)
 }
 
@@ -379,6 +384,17 @@ func (rm *replicationManager) initMetadataChangeMonitor() {
 	mcm.RegisterListener(remoteClusterChangeListener)
 	rm.remote_cluster_svc.SetMetadataChangeHandlerCallback(remoteClusterChangeListener.remoteClusterChangeHandlerCallback)
 
+	if mergeFunctionsSvc, ok := rm.resolver_svc.(service_def.MergeFunctionsSvc); ok {
+		mergeFunctionsChangeListener := NewMergeFunctionsChangeListener(
+			mergeFunctionsSvc,
+			rm.metadata_change_callback_cancel_ch,
+			rm.children_waitgrp,
+			log.DefaultLoggerContext,
+			rm.utils)
+
+		mcm.RegisterListener(mergeFunctionsChangeListener)
+	}
+
 	replicationSpecChangeListener := NewReplicationSpecChangeListener(
 		rm.repl_spec_svc,
 		rm.metadata_change_callback_cancel_ch,
@@ -457,6 +473,7 @@ func (rm *replicationManager) init(
 	global_setting_svc service_def.GlobalSettingsSvc,
 	bucket_settings_svc service_def.BucketSettingsSvc,
 	internal_settings_svc service_def.InternalSettingsSvc,
+	settings_history_svc service_def.SettingsHistorySvc,
 	throughput_throttler_svc service_def.ThroughputThrottlerSvc,
 	resolverSvc service_def.ResolverSvcIface,
 	collectionsManifestSvc service_def.CollectionsManifestSvc,
@@ -479,10 +496,12 @@ func (rm *replicationManager) init(
 	rm.global_setting_svc = global_setting_svc
 	rm.bucket_settings_svc = bucket_settings_svc
 	rm.internal_settings_svc = internal_settings_svc
+	rm.settings_history_svc = settings_history_svc
 	rm.collectionsManifestSvc = collectionsManifestSvc
 	rm.backfillReplSvc = backfillReplSvc
 	rm.bucketTopologySvc = bucketTopologySvc
 	rm.p2pMgr = p2pMgr
+	rm.resolver_svc = resolverSvc
 
 	fac := factory.NewXDCRFactory(repl_spec_svc, remote_cluster_svc,
 		xdcr_topology_svc, checkpoint_svc, capi_svc, uilog_svc, bucket_settings_svc,
@@ -545,22 +564,35 @@ func InternalSettingsService() service_def.InternalSettingsSvc {
 	return replication_mgr.internal_settings_svc
 }
 
+func SettingsHistoryService() service_def.SettingsHistorySvc {
+	return replication_mgr.settings_history_svc
+}
+
 func CollectionsManifestService() service_def.CollectionsManifestSvc {
 	return replication_mgr.collectionsManifestSvc
 }
 
+// Only the in-process merge engine has its merge functions managed by XDCR. The javascript evaluator has its own REST API
+func MergeFunctionsService() (service_def.MergeFunctionsSvc, error) {
+	mergeFunctionsSvc, ok := replication_mgr.resolver_svc.(service_def.MergeFunctionsSvc)
+	if !ok {
+		return nil, errors.New("Merge functions can only be managed by XDCR when it is started with -localMergeEngine")
+	}
+	return mergeFunctionsSvc, nil
+}
+
 func BackfillManager() service_def.BackfillMgrIface {
 	return replication_mgr.backfillMgr
 }
 
 //CreateReplication create the replication specification in metadata store
 //and start the replication pipeline
-func CreateReplication(justValidate bool, sourceBucket, targetCluster, targetBucket string, settings metadata.ReplicationSettingsMap, realUserId *service_def.RealUserId, ips *service_def.LocalRemoteIPs) (string, map[string]error, error, service_def.UIWarnings) {
-	logger_rm.Infof("Creating replication - justValidate=%v, sourceBucket=%s, targetCluster=%s, targetBucket=%s, settings=%v\n",
-		justValidate, sourceBucket, targetCluster, targetBucket, settings.CloneAndRedact())
+func CreateReplication(justValidate bool, sourceBucket, targetCluster, targetBucket, replicationName string, settings metadata.ReplicationSettingsMap, realUserId *service_def.RealUserId, ips *service_def.LocalRemoteIPs) (string, map[string]error, error, service_def.UIWarnings) {
+	logger_rm.Infof("Creating replication - justValidate=%v, sourceBucket=%s, targetCluster=%s, targetBucket=%s, replicationName=%s, settings=%v\n",
+		justValidate, sourceBucket, targetCluster, targetBucket, replicationName, settings.CloneAndRedact())
 
 	var spec *metadata.ReplicationSpecification
-	spec, errorsMap, err, warnings := replication_mgr.createAndPersistReplicationSpec(justValidate, sourceBucket, targetCluster, targetBucket, settings)
+	spec, errorsMap, err, warnings := replication_mgr.createAndPersistReplicationSpec(justValidate, sourceBucket, targetCluster, targetBucket, replicationName, settings)
 	if err != nil {
 		logger_rm.Errorf("%v\n", err)
 		return "", nil, err, nil
@@ -580,6 +612,38 @@ func CreateReplication(justValidate bool, sourceBucket, targetCluster, targetBuc
 	return spec.Id, nil, nil, warnings
 }
 
+// DryRunCreateReplication runs all the validations that CreateReplication does, including the ones against the target cluster,
+// and returns the spec that would have been created together with the collection namespace mapping that its
+// settings resolve to against the current source and target manifests. Nothing is persisted
+func DryRunCreateReplication(sourceBucket, targetCluster, targetBucket, replicationName string, settings metadata.ReplicationSettingsMap) (*metadata.ReplicationSpecification, metadata.CollectionNamespaceMapping, map[string]error, error, service_def.UIWarnings) {
+	logger_rm.Infof("Dry run of replication creation - sourceBucket=%s, targetCluster=%s, targetBucket=%s, replicationName=%s, settings=%v\n",
+		sourceBucket, targetCluster, targetBucket, replicationName, settings.CloneAndRedact())
+
+	spec, errorsMap, err, warnings := replication_mgr.constructReplicationSpec(true /*performRemoteValidation*/, sourceBucket, targetCluster, targetBucket, replicationName, settings)
+	if err != nil {
+		logger_rm.Errorf("%v\n", err)
+		return nil, nil, nil, err, nil
+	} else if len(errorsMap) != 0 {
+		return nil, nil, errorsMap, nil, nil
+	}
+
+	srcManifest, tgtManifest, err := CollectionsManifestService().GetLatestManifests(spec, true /*specMayNotExist*/)
+	if err != nil {
+		return nil, nil, nil, err, nil
+	}
+	manifestsPair := metadata.CollectionsManifestPair{
+		Source: srcManifest,
+		Target: tgtManifest,
+	}
+	mapping, err := metadata.NewCollectionNamespaceMappingFromRules(manifestsPair, spec.Settings.GetCollectionModes(), spec.Settings.GetCollectionsRoutingRules(), true /*ensureSourceExists*/, false)
+	if err != nil {
+		errorsMap = make(map[string]error)
+		errorsMap[CollectionsMappingRulesKey] = err
+		return nil, nil, errorsMap, nil, nil
+	}
+	return spec, mapping, nil, nil, warnings
+}
+
 //DeleteReplication stops the running replication of given replicationId and
 //delete the replication specification from the metadata store
 func DeleteReplication(topic string, realUserId *service_def.RealUserId, ips *service_def.LocalRemoteIPs) error {
@@ -597,11 +661,245 @@ func DeleteReplication(topic string, realUserId *service_def.RealUserId, ips *se
 	go writeGenericReplicationEvent(service_def.CancelReplicationEventId, spec, realUserId, ips)
 	go writeReplicationSystemEvent(service_def.DeleteReplicationSystemEventId, spec, "")
 
+	// a replication created later with the same id does not inherit the history
+	err = SettingsHistoryService().DelSettingsHistory(metadata.ReplicationSettingsHistorySubject(topic))
+	if err != nil {
+		logger_rm.Warnf("Failed to delete settings history of replication %v. err=%v", topic, err)
+	}
+
 	logger_rm.Infof("Pipeline %s is deleted\n", topic)
 
 	return nil
 }
 
+// RedriveDeadLetters has the source documents listed in the dead letter files that the replication wrote on this node
+// replicated again. Each node only has the dead letter files of the VBs that it replicated
+func RedriveDeadLetters(topic string) (*pipeline_svc.DeadLetterRedriveResult, error) {
+	logger_rm.Infof("Re-driving dead letter entries of replication %s\n", topic)
+
+	spec, err := ReplicationSpecService().ReplicationSpec(topic)
+	if err != nil {
+		return nil, err
+	}
+	return pipeline_svc.RedriveDeadLetters(spec, CollectionsManifestService(), replication_mgr.bucketTopologySvc, replication_mgr.utils, logger_rm)
+}
+
+// A stored checkpoint record as returned by the checkpoints REST API, along with the broken mappings that the record
+// refers to by SHA
+type CheckpointRecordInfo struct {
+	*metadata.CheckpointRecord
+	BrokenMappingsInfo *metadata.CollectionNamespaceMapping `json:"brokenMappings,omitempty"`
+}
+
+// GetCheckpoints returns the stored checkpoint records of the main pipeline of the replication for every VB,
+// from the newest to the oldest
+func GetCheckpoints(topic string) (map[uint16][]*CheckpointRecordInfo, error) {
+	_, err := ReplicationSpecService().ReplicationSpec(topic)
+	if err != nil {
+		return nil, err
+	}
+
+	ckptDocs, err := CheckpointService().CheckpointsDocs(topic, true /*brokenMappingsNeeded*/)
+	if err != nil {
+		return nil, err
+	}
+
+	checkpoints := make(map[uint16][]*CheckpointRecordInfo)
+	for vbno, ckptDoc := range ckptDocs {
+		if ckptDoc == nil {
+			continue
+		}
+		records := []*CheckpointRecordInfo{}
+		for _, record := range ckptDoc.Checkpoint_records {
+			if record == nil {
+				continue
+			}
+			recordInfo := &CheckpointRecordInfo{CheckpointRecord: record}
+			if record.BrokenMappingSha256 != "" {
+				recordInfo.BrokenMappingsInfo = record.BrokenMappings()
+			}
+			records = append(records, recordInfo)
+		}
+		checkpoints[vbno] = records
+	}
+	return checkpoints, nil
+}
+
+// RewindCheckpoints rewinds the checkpoints of the given VBs of the replication, or of all of its VBs if none are
+// given, either to the stored record at ckptIndex, counting from the newest record at 0, or to the given source seqno.
+// Pipelines write the checkpoints of the VBs they replicate when they stop. So while the replication is running, only
+// the VBs replicated on this node can be rewound, and the pipeline on this node is restarted after the rewind
+func RewindCheckpoints(topic string, vbnos []uint16, ckptIndex int, seqno uint64, toSeqno bool) error {
+	logger_rm.Infof("Rewinding checkpoints of replication %v. vbnos=%v, ckptIndex=%v, seqno=%v, toSeqno=%v\n", topic, vbnos, ckptIndex, seqno, toSeqno)
+
+	spec, err := ReplicationSpecService().ReplicationSpec(topic)
+	if err != nil {
+		return err
+	}
+
+	rewind := func(ckptDoc *metadata.CheckpointsDoc) error {
+		if toSeqno {
+			return ckptDoc.RewindToSeqno(seqno, uint64(time.Now().Unix()))
+		}
+		return ckptDoc.RewindToRecord(ckptIndex)
+	}
+
+	if spec.Settings.Active {
+		localVBs, err := replication_mgr.getLocalVBs(topic)
+		if err != nil {
+			return err
+		}
+		if len(vbnos) == 0 {
+			vbnos = localVBs
+		}
+		for _, vbno := range vbnos {
+			if !base.IsVbInList(vbno, localVBs) {
+				return fmt.Errorf("VB %v is not replicated on this node. Rewind it on the node that replicates it, or pause the replication first", vbno)
+			}
+		}
+	}
+
+	// Validated against the checkpoints as they are now, so that the errors are returned to the caller
+	ckptDocs, err := rewindCheckpointsDocs(topic, vbnos, rewind)
+	if err != nil {
+		return err
+	}
+
+	if !spec.Settings.Active {
+		_, err = CheckpointService().UpsertCheckpointsDoc(topic, ckptDocs, spec.InternalId)
+		return err
+	}
+
+	// The pipeline checkpoints as it stops, so the checkpoints are rewound again once it has stopped
+	callback := func() error {
+		ckptDocs, err := rewindCheckpointsDocs(topic, vbnos, rewind)
+		if err != nil {
+			return err
+		}
+		_, err = CheckpointService().UpsertCheckpointsDoc(topic, ckptDocs, spec.InternalId)
+		return err
+	}
+	errCb := func(err error) {
+		logger_rm.Errorf("Unable to rewind checkpoints of replication %v. err=%v", topic, err)
+	}
+	return replication_mgr.pipelineMgr.UpdatePipelineWithStoppedCb(topic, callback, errCb)
+}
+
+// Returns the rewound checkpoint docs of the given VBs, or of all VBs that have checkpoints if none are given
+func rewindCheckpointsDocs(topic string, vbnos []uint16, rewind func(*metadata.CheckpointsDoc) error) (map[uint16]*metadata.CheckpointsDoc, error) {
+	ckptDocs, err := CheckpointService().CheckpointsDocs(topic, true /*brokenMappingsNeeded*/)
+	if err != nil {
+		return nil, err
+	}
+	if len(vbnos) == 0 {
+		for vbno := range ckptDocs {
+			vbnos = append(vbnos, vbno)
+		}
+	}
+
+	rewoundDocs := make(map[uint16]*metadata.CheckpointsDoc)
+	errMap := make(base.ErrorMap)
+	for _, vbno := range vbnos {
+		ckptDoc, exists := ckptDocs[vbno]
+		if !exists || ckptDoc == nil {
+			errMap[fmt.Sprintf("vb %v", vbno)] = errors.New("there are no checkpoints to rewind")
+			continue
+		}
+		err = rewind(ckptDoc)
+		if err != nil {
+			errMap[fmt.Sprintf("vb %v", vbno)] = err
+			continue
+		}
+		rewoundDocs[vbno] = ckptDoc
+	}
+	if len(errMap) > 0 {
+		return nil, fmt.Errorf(base.FlattenErrorMap(errMap))
+	}
+	return rewoundDocs, nil
+}
+
+// ExportCheckpoints returns the checkpoints of the main pipeline of the replication in a portable form
+func ExportCheckpoints(topic string) (*metadata.CheckpointsExport, error) {
+	spec, err := ReplicationSpecService().ReplicationSpec(topic)
+	if err != nil {
+		return nil, err
+	}
+
+	ckptDocs, err := CheckpointService().CheckpointsDocs(topic, true /*brokenMappingsNeeded*/)
+	if err != nil {
+		return nil, err
+	}
+	return metadata.NewCheckpointsExport(spec, ckptDocs, time.Now().Unix())
+}
+
+// ImportCheckpoints installs the checkpoints exported from the replication of the same source and target data on
+// another cluster. The checkpoints are validated against the source and target failover logs by the checkpoint
+// manager of the pipeline running on this node, which only knows about the VBs this node replicates. The valid ones
+// are merged into the stored checkpoints while the pipeline is stopped, so that the pipeline resumes from them.
+// Returns the number of VBs with valid checkpoints
+func ImportCheckpoints(topic string, ckptsExport *metadata.CheckpointsExport) (int, error) {
+	logger_rm.Infof("Importing checkpoints of replication %v exported at %v\n", topic, ckptsExport.ExportTime)
+
+	spec, err := ReplicationSpecService().ReplicationSpec(topic)
+	if err != nil {
+		return 0, err
+	}
+	if !spec.Settings.Active {
+		return 0, fmt.Errorf("Replication %v needs to be running so that the checkpoints can be validated", topic)
+	}
+
+	pipeline, err := replication_mgr.getMainPipeline(topic)
+	if err != nil {
+		return 0, err
+	}
+	ckptMgr, ok := pipeline.RuntimeContext().Service(base.CHECKPOINT_MGR_SVC).(pipeline_svc.CheckpointMgrSvc)
+	if !ok {
+		return 0, fmt.Errorf("CheckpointingManager has not been attached to pipeline %v", topic)
+	}
+
+	imported, err := ckptMgr.ValidateImportedCheckpoints(ckptsExport)
+	if err != nil {
+		return 0, err
+	}
+	if imported.Len() == 0 {
+		return 0, nil
+	}
+
+	callback := func() error {
+		return imported.Install(CheckpointService(), spec)
+	}
+	errCb := func(err error) {
+		logger_rm.Errorf("Unable to import checkpoints of replication %v. err=%v", topic, err)
+	}
+	return imported.Len(), replication_mgr.pipelineMgr.UpdatePipelineWithStoppedCb(topic, callback, errCb)
+}
+
+// Returns the main pipeline of the replication running on this node
+func (rm *replicationManager) getMainPipeline(topic string) (common.Pipeline, error) {
+	rep_status, err := rm.pipelineMgr.ReplicationStatus(topic)
+	if err != nil {
+		return nil, err
+	}
+	pipeline := rep_status.Pipeline()
+	if pipeline == nil || pipeline.RuntimeContext() == nil {
+		return nil, fmt.Errorf("Replication %v is not running on this node. Try again later", topic)
+	}
+	return pipeline, nil
+}
+
+// Returns the source VBs that the main pipeline of the replication replicates on this node
+func (rm *replicationManager) getLocalVBs(topic string) ([]uint16, error) {
+	pipeline, err := rm.getMainPipeline(topic)
+	if err != nil {
+		return nil, err
+	}
+	var vbnos []uint16
+	for _, source := range pipeline.Sources() {
+		vbnos = append(vbnos, source.ResponsibleVBs()...)
+	}
+	return vbnos, nil
+}
+
 //update the  replication settings and XDCR process setting
 func UpdateDefaultSettings(settings metadata.ReplicationSettingsMap, realUserId *service_def.RealUserId, ips *service_def.LocalRemoteIPs) (map[string]error, error) {
 	logger_rm.Infof("UpdateDefaultSettings called with settings=%v\n", settings)
@@ -609,12 +907,19 @@ func UpdateDefaultSettings(settings metadata.ReplicationSettingsMap, realUserId
 	// Validate process setting keys
 	globalSettingsMap := metadata.ValidateGlobalSettingsKey(settings)
 	if len(globalSettingsMap) > 0 {
+		oldGlobalSettings, err := GlobalSettingsService().GetGlobalSettings()
+		if err != nil {
+			return nil, err
+		}
 		//First update XDCR Process specific setting
 		errorMap, err := GlobalSettingsService().UpdateGlobalSettings(globalSettingsMap)
 		if len(errorMap) > 0 || err != nil {
 			return errorMap, err
 		}
 		logger_rm.Infof("Updated global settings\n")
+		if newGlobalSettings, err := GlobalSettingsService().GetGlobalSettings(); err == nil {
+			recordSettingsChange(metadata.GlobalSettingsHistorySubject, oldGlobalSettings.Values, newGlobalSettings.Values, realUserId)
+		}
 	} else {
 		logger_rm.Infof("Did not update global settings since there are no real changes\n")
 	}
@@ -622,6 +927,10 @@ func UpdateDefaultSettings(settings metadata.ReplicationSettingsMap, realUserId
 	//validate replication settings
 	replicationSettingMap := metadata.ValidateReplicationSettingsKey(settings)
 	if len(replicationSettingMap) > 0 {
+		oldDefaultSettings, err := ReplicationSettingsService().GetDefaultReplicationSettings()
+		if err != nil {
+			return nil, err
+		}
 		//Now update default replication setting
 		changedSettingsMap, errorMap, err := ReplicationSettingsService().UpdateDefaultReplicationSettings(replicationSettingMap)
 		if len(errorMap) > 0 || err != nil {
@@ -630,6 +939,9 @@ func UpdateDefaultSettings(settings metadata.ReplicationSettingsMap, realUserId
 		if len(changedSettingsMap) != 0 {
 			go writeUpdateDefaultReplicationSettingsEvent(&changedSettingsMap, realUserId, ips)
 			go writeUpdateDefaultReplicationSettingsSystemEvent(&changedSettingsMap)
+			if newDefaultSettings, err := ReplicationSettingsService().GetDefaultReplicationSettings(); err == nil {
+				recordSettingsChange(metadata.DefaultSettingsHistorySubject, oldDefaultSettings.Values, newDefaultSettings.Values, realUserId)
+			}
 		}
 		logger_rm.Infof("Updated default replication settings\n")
 	} else {
@@ -639,6 +951,55 @@ func UpdateDefaultSettings(settings metadata.ReplicationSettingsMap, realUserId
 	return nil, nil
 }
 
+// update the internal settings, which take effect after goxdcr restarts on the change
+func UpdateInternalSettings(settingsMap metadata.ReplicationSettingsMap, realUserId *service_def.RealUserId) (*metadata.InternalSettings, map[string]error, error) {
+	oldSettings := InternalSettingsService().GetInternalSettings()
+	internalSettings, errorsMap, err := InternalSettingsService().UpdateInternalSettings(settingsMap)
+	if len(errorsMap) > 0 || err != nil {
+		return nil, errorsMap, err
+	}
+	recordSettingsChange(metadata.InternalSettingsHistorySubject, oldSettings.Values, internalSettings.Values, realUserId)
+	return internalSettings, nil, nil
+}
+
+// Settings history is best effort, so a change that fails to be recorded is not failed
+func recordSettingsChange(subject string, before, after map[string]interface{}, realUserId *service_def.RealUserId) {
+	err := SettingsHistoryService().RecordSettingsChange(subject, before, after, realUserId.Username)
+	if err != nil {
+		logger_rm.Warnf("Failed to record the change to the settings of %v. err=%v", subject, err)
+	}
+}
+
+// RollbackSettings changes the settings of the subject back to the values they had at the given version of its
+// history. The rollback goes through the same update path as any other change, and is recorded as a new version
+func RollbackSettings(subject string, version uint64, realUserId *service_def.RealUserId, ips *service_def.LocalRemoteIPs) (map[string]error, error) {
+	history, err := SettingsHistoryService().GetSettingsHistory(subject)
+	if err != nil {
+		return nil, err
+	}
+	settings, err := history.ValuesAtVersion(version)
+	if err != nil {
+		return nil, err
+	} else if len(settings) == 0 {
+		return nil, fmt.Errorf("Settings of %v are already at version %v", subject, version)
+	}
+	logger_rm.Infof("Rolling back settings of %v to version %v, settings=%v", subject, version, metadata.ReplicationSettingsMap(settings).CloneAndRedact())
+
+	switch subject {
+	case metadata.DefaultSettingsHistorySubject, metadata.GlobalSettingsHistorySubject:
+		return UpdateDefaultSettings(settings, realUserId, ips)
+	case metadata.InternalSettingsHistorySubject:
+		_, errorsMap, err := UpdateInternalSettings(settings, realUserId)
+		return errorsMap, err
+	}
+	replicationId, ok := metadata.ReplicationIdFromSettingsHistorySubject(subject)
+	if !ok {
+		return nil, fmt.Errorf("Unknown settings history subject %v", subject)
+	}
+	errorsMap, err, _ := UpdateReplicationSettings(replicationId, settings, realUserId, ips, false /*justValidate*/)
+	return errorsMap, err
+}
+
 func compressionSettingsChanged(changedSettingsMap metadata.ReplicationSettingsMap, oldCompressionType int) bool {
 	if compressionType, ok := changedSettingsMap[metadata.CompressionTypeKey]; ok && (base.GetCompressionType(compressionType.(int)) != base.CompressionTypeNone) &&
 		base.GetCompressionType(oldCompressionType) != base.GetCompressionType(compressionType.(int)) {
@@ -676,6 +1037,7 @@ func UpdateReplicationSettings(topic string, settings metadata.ReplicationSettin
 	}
 
 	// Save some old values that we may need
+	oldSettings := replSpec.Settings.Clone()
 	filterExpression := replSpec.Settings.Values[metadata.FilterExpressionKey].(string)
 	oldCompressionType := replSpec.Settings.Values[metadata.CompressionTypeKey].(int)
 	filterVersion := replSpec.Settings.Values[metadata.FilterVersionKey].(base.FilterVersionType)
@@ -691,7 +1053,13 @@ func UpdateReplicationSettings(topic string, settings metadata.ReplicationSettin
 		// justValidate means UI is in need of immediate update - do not perform RPC call
 		performRemoteValidation = true
 	}
-	validateRoutineErrorMap, validateErr, warnings := ReplicationSpecService().ValidateReplicationSettings(replSpecificFields.SourceBucketName, replSpecificFields.RemoteClusterName, replSpecificFields.TargetBucketName, settings, performRemoteValidation)
+	var validateRoutineErrorMap base.ErrorMap
+	var validateErr error
+	var warnings service_def.UIWarnings
+	if !replSpec.IsFileExport() {
+		// file export replications have no target cluster to validate the settings against
+		validateRoutineErrorMap, validateErr, warnings = ReplicationSpecService().ValidateReplicationSettings(replSpecificFields.SourceBucketName, replSpecificFields.RemoteClusterName, replSpecificFields.TargetBucketName, replSpec.ReplicationName, settings, performRemoteValidation)
+	}
 	if len(validateRoutineErrorMap) > 0 {
 		return validateRoutineErrorMap, nil, nil
 	} else if validateErr != nil {
@@ -706,6 +1074,21 @@ func UpdateReplicationSettings(topic string, settings metadata.ReplicationSettin
 		return errorMap, nil, nil
 	}
 
+	// A replication paused on bulk deletion cannot be resumed until the operator acknowledges the deletions
+	if replSpec.Settings.Active && replSpec.Settings.GetBulkDeleteTripped() {
+		return nil, fmt.Errorf("Replication %v was paused on bulk deletion. Set %v to false to acknowledge the deletions before resuming it",
+			topic, BulkDeleteTrippedKey), nil
+	}
+	// Deletions from before the acknowledgement do not count towards tripping the breaker again
+	if tripped, ok := changedSettingsMap[metadata.BulkDeleteTrippedKey]; ok && !tripped.(bool) {
+		ackSettings := metadata.ReplicationSettingsMap{metadata.BulkDeleteAckTimeKey: int(time.Now().Unix())}
+		_, errorMap = replSpec.Settings.UpdateSettingsFromMap(ackSettings)
+		if len(errorMap) != 0 {
+			return errorMap, fmt.Errorf("Internal XDCR Error related to bulk delete acknowledgement: %v", errorMap), nil
+		}
+		changedSettingsMap[metadata.BulkDeleteAckTimeKey] = ackSettings[metadata.BulkDeleteAckTimeKey]
+	}
+
 	// If nonfilter-settings invoked this change, take this opportunity to fix stale infos if there is an existing expression present
 	if !filterSettingsChanged(changedSettingsMap, filterExpression) && len(filterExpression) > 0 && filterVersion < base.FilterVersionAdvanced {
 		settings[metadata.FilterVersionKey] = base.FilterVersionAdvanced
@@ -728,6 +1111,11 @@ func UpdateReplicationSettings(topic string, settings metadata.ReplicationSettin
 		}
 		logger_rm.Infof("Updated replication settings for replication %v\n", topic)
 
+		newSettings := replSpec.Settings.Clone()
+		oldSettings.PopulateDefault()
+		newSettings.PopulateDefault()
+		recordSettingsChange(metadata.ReplicationSettingsHistorySubject(topic), oldSettings.Values, newSettings.Values, realUserId)
+
 		go writeUpdateReplicationSettingsEvent(replSpec, &changedSettingsMap, realUserId, ips)
 		go writeUpdateReplicationSettingsSystemEvent(replSpec, &changedSettingsMap)
 
@@ -789,12 +1177,49 @@ func GetStatistics(bucket string) (*expvar.Map, error) {
 	return stats, nil
 }
 
+// Stats of all replications, keyed by full replication id
+// The per vb stats of a replication are included under their stats names, keyed by vbno
 func GetAllStatistics() (*expvar.Map, error) {
 	repIds := replication_mgr.pipelineMgr.AllReplications()
 
 	stats := new(expvar.Map).Init()
 	for _, repId := range repIds {
 		statsForPipeline := pipeline_svc.GetStatisticsForPipeline(repId, replication_mgr.pipelineMgr.ReplicationStatus)
+		vbStatsForPipeline := pipeline_svc.GetVBStatisticsForPipeline(repId, replication_mgr.pipelineMgr.ReplicationStatus)
+		for i, oneStats := range statsForPipeline {
+			if oneStats == nil {
+				continue
+			}
+			if i < len(vbStatsForPipeline) && vbStatsForPipeline[i] != nil {
+				oneStats = combineOverviewAndVBStats(oneStats, vbStatsForPipeline[i])
+			}
+			fullReplicationId := common.ComposeFullTopic(repId, common.PipelineType(i))
+			stats.Set(fullReplicationId, oneStats)
+		}
+	}
+	return stats, nil
+}
+
+// The overview stats map is shared with the REST stats, which only handle numerical values,
+// and so the per vb stats are added to a copy of it
+func combineOverviewAndVBStats(overviewStats, vbStats *expvar.Map) *expvar.Map {
+	combinedStats := new(expvar.Map).Init()
+	overviewStats.Do(func(kv expvar.KeyValue) {
+		combinedStats.Set(kv.Key, kv.Value)
+	})
+	vbStats.Do(func(kv expvar.KeyValue) {
+		combinedStats.Set(kv.Key, kv.Value)
+	})
+	return combinedStats
+}
+
+// Per collection stats of all replications, keyed by full replication id and then by source and target namespace
+func GetAllCollectionStatistics() (*expvar.Map, error) {
+	repIds := replication_mgr.pipelineMgr.AllReplications()
+
+	stats := new(expvar.Map).Init()
+	for _, repId := range repIds {
+		statsForPipeline := pipeline_svc.GetCollectionStatisticsForPipeline(repId, replication_mgr.pipelineMgr.ReplicationStatus)
 		for i, oneStats := range statsForPipeline {
 			if oneStats == nil {
 				continue
@@ -807,16 +1232,43 @@ func GetAllStatistics() (*expvar.Map, error) {
 }
 
 //create and persist the replication specification
-func (rm *replicationManager) createAndPersistReplicationSpec(justValidate bool, sourceBucket, targetCluster, targetBucket string, settings metadata.ReplicationSettingsMap) (*metadata.ReplicationSpecification, map[string]error, error, service_def.UIWarnings) {
-	logger_rm.Infof("Creating replication spec - justValidate=%v, sourceBucket=%s, targetCluster=%s, targetBucket=%s, settings=%v\n",
-		justValidate, sourceBucket, targetCluster, targetBucket, settings.CloneAndRedact())
+func (rm *replicationManager) createAndPersistReplicationSpec(justValidate bool, sourceBucket, targetCluster, targetBucket, replicationName string, settings metadata.ReplicationSettingsMap) (*metadata.ReplicationSpecification, map[string]error, error, service_def.UIWarnings) {
+	logger_rm.Infof("Creating replication spec - justValidate=%v, sourceBucket=%s, targetCluster=%s, targetBucket=%s, replicationName=%s, settings=%v\n",
+		justValidate, sourceBucket, targetCluster, targetBucket, replicationName, settings.CloneAndRedact())
+	spec, errorMap, err, warnings := rm.constructReplicationSpec(!justValidate, sourceBucket, targetCluster, targetBucket, replicationName, settings)
+	if err != nil || len(errorMap) > 0 {
+		return nil, errorMap, err, nil
+	}
+
+	if justValidate {
+		return spec, nil, nil, warnings
+	}
+
+	//persist it
+	err = replication_mgr.repl_spec_svc.AddReplicationSpec(spec, warnings.String())
+	if err == nil {
+		logger_rm.Infof("Success adding replication specification %s\n", spec.Id)
+		return spec, nil, nil, warnings
+	} else {
+		logger_rm.Errorf("Error adding replication specification %s. err=%v\n", spec.Id, err)
+		return nil, nil, err, nil
+	}
+}
+
+// validates the replication configuration and constructs the spec with its effective settings, without persisting it
+func (rm *replicationManager) constructReplicationSpec(performRemoteValidation bool, sourceBucket, targetCluster, targetBucket, replicationName string, settings metadata.ReplicationSettingsMap) (*metadata.ReplicationSpecification, map[string]error, error, service_def.UIWarnings) {
 	// validate that everything is alright with the replication configuration before actually creating it
-	sourceBucketUUID, targetBucketUUID, targetClusterRef, errorMap, err, warnings := replication_mgr.repl_spec_svc.ValidateNewReplicationSpec(sourceBucket, targetCluster, targetBucket, settings, !justValidate)
+	sourceBucketUUID, targetBucketUUID, targetClusterRef, errorMap, err, warnings := replication_mgr.repl_spec_svc.ValidateNewReplicationSpec(sourceBucket, targetCluster, targetBucket, replicationName, settings, performRemoteValidation)
 	if err != nil || len(errorMap) > 0 {
 		return nil, errorMap, err, nil
 	}
 
-	spec, err := metadata.NewReplicationSpecification(sourceBucket, sourceBucketUUID, targetClusterRef.Uuid(), targetBucket, targetBucketUUID)
+	// file export replications have no target cluster reference
+	targetClusterUUID := base.FileExportTargetClusterUUID
+	if targetClusterRef != nil {
+		targetClusterUUID = targetClusterRef.Uuid()
+	}
+	spec, err := metadata.NewNamedReplicationSpecification(sourceBucket, sourceBucketUUID, targetClusterUUID, targetBucket, targetBucketUUID, replicationName)
 	if err != nil {
 		return nil, nil, err, nil
 	}
@@ -844,21 +1296,20 @@ func (rm *replicationManager) createAndPersistReplicationSpec(justValidate bool,
 	if len(errorMap) != 0 {
 		return nil, errorMap, nil, nil
 	}
-	spec.Settings = replSettings
 
-	if justValidate {
-		return spec, nil, nil, warnings
+	// file export replications need somewhere to write to, and the export dir means nothing to the other types
+	if replSettings.IsFileExport() && replSettings.GetFileExportDir() == "" {
+		return nil, map[string]error{FileExportDirKey: base.MissingValueError("file export directory")}, nil, nil
+	} else if !replSettings.IsFileExport() && replSettings.GetFileExportDir() != "" {
+		return nil, map[string]error{FileExportDirKey: fmt.Errorf("%v can only be specified for replication type %v", FileExportDirKey, metadata.ReplicationTypeFile)}, nil, nil
 	}
 
-	//persist it
-	err = replication_mgr.repl_spec_svc.AddReplicationSpec(spec, warnings.String())
-	if err == nil {
-		logger_rm.Infof("Success adding replication specification %s\n", spec.Id)
-		return spec, nil, nil, warnings
-	} else {
-		logger_rm.Errorf("Error adding replication specification %s. err=%v\n", spec.Id, err)
-		return nil, nil, err, nil
+	if !replSettings.GetStartFromTime().IsZero() && replSettings.GetStartFromSeqnos() != nil {
+		return nil, map[string]error{StartFromSeqnosKey: fmt.Errorf("%v and %v cannot both be specified", StartFromTimeKey, StartFromSeqnosKey)}, nil, nil
 	}
+
+	spec.Settings = replSettings
+	return spec, nil, nil, warnings
 }
 
 /**
@@ -977,12 +1428,66 @@ func (rm *replicationManager) OnError(s common.Supervisor, errMap map[string]err
 			} else {
 				errMsg = base.FlattenErrorMap(errMap)
 			}
+			if base.CheckErrorMapForError(errMap, base.ErrorReplicationCaughtUp, false /*exactMatch*/) {
+				rm.pauseCaughtUpReplication(pipeline.Topic())
+				return
+			}
+			if base.CheckErrorMapForError(errMap, base.ErrorBulkDeletesDetected, false /*exactMatch*/) {
+				rm.pauseBulkDeleteReplication(pipeline.Topic(), errMsg)
+				return
+			}
 			// NOTE because we flatten the error map here, any error checked should not be exact match
 			rm.pipelineMgr.UpdatePipeline(pipeline.Topic(), errors.New(errMsg))
 		}
 	}
 }
 
+// A stop when caught up replication is paused instead of being repaired. Pausing stops the pipeline,
+// which checkpoints before it stops
+func (rm *replicationManager) pauseCaughtUpReplication(topic string) {
+	msg := fmt.Sprintf("Replication %v has caught up with the source and will automatically be paused.", topic)
+	logger_rm.Info(msg)
+	rm.pipelineMgr.GetLogSvc().Write(msg)
+
+	spec, err := ReplicationSpecService().ReplicationSpec(topic)
+	if err == nil && spec != nil {
+		go writeReplicationSystemEvent(service_def.ReplicationCaughtUpSystemEventId, spec, "")
+	}
+
+	err = rm.pipelineMgr.AutoPauseReplication(topic)
+	if err != nil {
+		logger_rm.Errorf("Failed to pause caught up replication %v. err=%v", topic, err)
+	}
+}
+
+// A replication that trips the bulk delete breaker is paused and marked as tripped, so that it cannot be resumed
+// until the operator has looked into the deletions and acknowledged them
+func (rm *replicationManager) pauseBulkDeleteReplication(topic string, errMsg string) {
+	msg := fmt.Sprintf("Replication %v has detected bulk deletion on the source and will automatically be paused. Set %v to false "+
+		"to acknowledge the deletions before resuming it. %v", topic, BulkDeleteTrippedKey, errMsg)
+	logger_rm.Warn(msg)
+	rm.pipelineMgr.GetLogSvc().Write(msg)
+
+	spec, err := ReplicationSpecService().ReplicationSpec(topic)
+	if err == nil && spec != nil {
+		_, errorMap := spec.Settings.UpdateSettingsFromMap(metadata.ReplicationSettingsMap{metadata.BulkDeleteTrippedKey: true})
+		if len(errorMap) == 0 {
+			err = ReplicationSpecService().SetReplicationSpec(spec)
+		} else {
+			err = fmt.Errorf("%v", errorMap)
+		}
+		if err != nil {
+			logger_rm.Errorf("Failed to mark replication %v as tripped on bulk deletion. err=%v", topic, err)
+		}
+		go writeReplicationSystemEvent(service_def.BulkDeletesDetectedSystemEventId, spec, "")
+	}
+
+	err = rm.pipelineMgr.AutoPauseReplication(topic)
+	if err != nil {
+		logger_rm.Errorf("Failed to pause replication %v on bulk deletion. err=%v", topic, err)
+	}
+}
+
 //lauch the repairer for a pipeline
 //in asynchronous fashion
 
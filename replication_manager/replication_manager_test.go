@@ -0,0 +1,99 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software
+will be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package replication_manager
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/couchbase/goxdcr/base"
+	"github.com/couchbase/goxdcr/metadata"
+	service_def "github.com/couchbase/goxdcr/service_def/mocks"
+	"github.com/stretchr/testify/assert"
+	mock "github.com/stretchr/testify/mock"
+)
+
+const (
+	dryRunSourceBucket  = "sourceBucket"
+	dryRunTargetCluster = "remoteCluster"
+	dryRunTargetBucket  = "targetBucket"
+)
+
+func setupDryRunBoilerPlate(validationErrMap base.ErrorMap, validationErr error) (*service_def.ReplicationSpecSvc, *service_def.ReplicationSettingsSvc, *service_def.CollectionsManifestSvc) {
+	replSpecSvc := &service_def.ReplicationSpecSvc{}
+	replSettingsSvc := &service_def.ReplicationSettingsSvc{}
+	collectionsManifestSvc := &service_def.CollectionsManifestSvc{}
+
+	ref, _ := metadata.NewRemoteClusterReference("targetClusterUuid", dryRunTargetCluster, "127.0.0.1:9000", "Administrator", "password", "",
+		false, "", nil, nil, nil, nil)
+	replSpecSvc.On("ValidateNewReplicationSpec", dryRunSourceBucket, dryRunTargetCluster, dryRunTargetBucket, mock.Anything, mock.Anything, true).
+		Return("sourceBucketUuid", "targetBucketUuid", ref, validationErrMap, validationErr, nil)
+	replSettingsSvc.On("GetDefaultReplicationSettings").Return(metadata.DefaultReplicationSettings(), nil)
+	defaultManifest := metadata.NewDefaultCollectionsManifest()
+	collectionsManifestSvc.On("GetLatestManifests", mock.Anything, true).Return(&defaultManifest, &defaultManifest, nil)
+
+	replication_mgr.repl_spec_svc = replSpecSvc
+	replication_mgr.replication_settings_svc = replSettingsSvc
+	replication_mgr.collectionsManifestSvc = collectionsManifestSvc
+	return replSpecSvc, replSettingsSvc, collectionsManifestSvc
+}
+
+func TestDryRunCreateReplication(t *testing.T) {
+	fmt.Println("============== Test case start: TestDryRunCreateReplication =================")
+	defer fmt.Println("============== Test case end: TestDryRunCreateReplication =================")
+	assert := assert.New(t)
+
+	replSpecSvc, _, _ := setupDryRunBoilerPlate(nil, nil)
+
+	spec, _, errorsMap, err, _ := DryRunCreateReplication(dryRunSourceBucket, dryRunTargetCluster, dryRunTargetBucket, "", metadata.ReplicationSettingsMap{})
+	assert.Nil(err)
+	assert.Len(errorsMap, 0)
+	assert.NotNil(spec)
+	assert.Equal(dryRunSourceBucket, spec.SourceBucketName)
+	assert.Equal(dryRunTargetBucket, spec.TargetBucketName)
+
+	// a dry run never persists the replication
+	replSpecSvc.AssertNotCalled(t, "AddReplicationSpec", mock.Anything, mock.Anything)
+	replSpecSvc.AssertNotCalled(t, "SetReplicationSpec", mock.Anything)
+}
+
+func TestDryRunCreateReplicationValidationErrors(t *testing.T) {
+	fmt.Println("============== Test case start: TestDryRunCreateReplicationValidationErrors =================")
+	defer fmt.Println("============== Test case end: TestDryRunCreateReplicationValidationErrors =================")
+	assert := assert.New(t)
+
+	// errors from validating the source and target
+	validationErrMap := base.ErrorMap{base.ToBucket: errors.New("target bucket does not exist")}
+	replSpecSvc, _, _ := setupDryRunBoilerPlate(validationErrMap, nil)
+	spec, _, errorsMap, err, _ := DryRunCreateReplication(dryRunSourceBucket, dryRunTargetCluster, dryRunTargetBucket, "", metadata.ReplicationSettingsMap{})
+	assert.Nil(err)
+	assert.Nil(spec)
+	assert.Equal(validationErrMap[base.ToBucket], errorsMap[base.ToBucket])
+	replSpecSvc.AssertNotCalled(t, "AddReplicationSpec", mock.Anything, mock.Anything)
+
+	// errors from the settings
+	replSpecSvc, _, _ = setupDryRunBoilerPlate(nil, nil)
+	settings := metadata.ReplicationSettingsMap{metadata.FileExportDirKey: "/tmp/export"}
+	spec, _, errorsMap, err, _ = DryRunCreateReplication(dryRunSourceBucket, dryRunTargetCluster, dryRunTargetBucket, "", settings)
+	assert.Nil(err)
+	assert.Nil(spec)
+	assert.NotNil(errorsMap[FileExportDirKey])
+	replSpecSvc.AssertNotCalled(t, "AddReplicationSpec", mock.Anything, mock.Anything)
+
+	// errors that fail the whole validation
+	replSpecSvc, _, _ = setupDryRunBoilerPlate(nil, errors.New("remote cluster is unreachable"))
+	spec, _, errorsMap, err, _ = DryRunCreateReplication(dryRunSourceBucket, dryRunTargetCluster, dryRunTargetBucket, "", metadata.ReplicationSettingsMap{})
+	assert.NotNil(err)
+	assert.Nil(spec)
+	assert.Len(errorsMap, 0)
+	replSpecSvc.AssertNotCalled(t, "AddReplicationSpec", mock.Anything, mock.Anything)
+}
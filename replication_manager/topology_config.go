@@ -0,0 +1,514 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software
+will be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package replication_manager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/couchbase/goxdcr/base"
+	"github.com/couchbase/goxdcr/metadata"
+	"github.com/couchbase/goxdcr/service_def"
+)
+
+// A topology config declares the remote cluster references and replications of this cluster in a single document.
+// Each entry takes the same parameters as the REST request that creates it, so it goes through the same decoders and
+// validation. Reconciling a config against the current state produces a plan, which is applied in an order that never
+// leaves a replication without its remote cluster reference: remote cluster references are created and updated first,
+// then replications are deleted, created and updated, and remote cluster references are deleted last.
+// Applying the same config again is a no-op
+
+const (
+	TopologyActionCreate = "create"
+	TopologyActionUpdate = "update"
+	TopologyActionDelete = "delete"
+	// exists but is not in the config, and the config does not prune
+	TopologyActionUnmanaged = "unmanaged"
+
+	TopologyKindRemoteCluster = "remoteCluster"
+	TopologyKindReplication   = "replication"
+)
+
+type TopologyConfig struct {
+	// Parameters of the create remote cluster request, e.g. name, hostname, username, password and secureType
+	RemoteClusters []map[string]interface{} `json:"remoteClusters"`
+	// Parameters of the create replication request, i.e. fromBucket, toCluster, toBucket and the replication settings.
+	// Objects such as colMappingRules are given as JSON objects instead of strings
+	Replications []map[string]interface{} `json:"replications"`
+	// Remote cluster references and replications that are not in the config are deleted
+	Prune bool `json:"prune"`
+}
+
+type TopologyDrift struct {
+	// Values of credentials and certificates are left out
+	Current interface{} `json:"current,omitempty"`
+	Desired interface{} `json:"desired,omitempty"`
+}
+
+type TopologyChange struct {
+	Action string `json:"action"`
+	Kind   string `json:"kind"`
+	// remote cluster name, or toCluster/fromBucket/toBucket of a replication, followed by its replication name if
+	// it has one
+	Name string `json:"name"`
+	// id of an existing replication
+	Id string `json:"id,omitempty"`
+	// the settings of an existing remote cluster reference or replication that differ from the config
+	Drift map[string]*TopologyDrift `json:"drift,omitempty"`
+
+	ref          *metadata.RemoteClusterReference
+	values       url.Values
+	sourceBucket string
+}
+
+type TopologyPlan struct {
+	Changes []*TopologyChange `json:"changes"`
+	Applied bool              `json:"applied"`
+}
+
+// Source buckets of the replications that the plan touches or reports on
+func (plan *TopologyPlan) SourceBuckets() []string {
+	bucketsMap := make(map[string]bool)
+	for _, change := range plan.Changes {
+		if change.Kind == TopologyKindReplication {
+			bucketsMap[change.sourceBucket] = true
+		}
+	}
+	buckets := make([]string, 0, len(bucketsMap))
+	for bucket := range bucketsMap {
+		buckets = append(buckets, bucket)
+	}
+	sort.Strings(buckets)
+	return buckets
+}
+
+// Converts an entry of the config into the parameters of the corresponding REST request
+func topologyEntryToValues(entry map[string]interface{}) (url.Values, error) {
+	values := make(url.Values)
+	for key, value := range entry {
+		switch typedValue := value.(type) {
+		case nil:
+			continue
+		case string:
+			values.Set(key, typedValue)
+		case json.Number:
+			values.Set(key, typedValue.String())
+		case bool:
+			values.Set(key, strconv.FormatBool(typedValue))
+		default:
+			// objects and arrays are passed in as JSON, like in the REST API
+			valueBytes, err := json.Marshal(typedValue)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid value for %v. err=%v", key, err)
+			}
+			values.Set(key, string(valueBytes))
+		}
+	}
+	return values, nil
+}
+
+// The REST decoders only look at the form of the request
+func newTopologyRequest(values url.Values) *http.Request {
+	return &http.Request{Method: http.MethodGet, URL: &url.URL{}, Form: values}
+}
+
+// Replications of the same bucket pair are told apart by their replication names, like their ids are
+func topologyReplicationName(fromBucket, toCluster, toBucket, replicationName string) string {
+	name := toCluster + base.KeyPartsDelimiter + fromBucket + base.KeyPartsDelimiter + toBucket
+	if replicationName == "" {
+		return name
+	}
+	return name + base.ReplicationNameDelimiter + replicationName
+}
+
+// PlanTopologyConfig returns the changes that bring the current state in line with the config.
+// The errors map holds the validation errors of the config, keyed by the entry and parameter they are about
+func PlanTopologyConfig(config *TopologyConfig) (*TopologyPlan, map[string]error, error) {
+	errorsMap := make(map[string]error)
+
+	refs, err := RemoteClusterService().RemoteClusters()
+	if err != nil {
+		return nil, nil, err
+	}
+	refsByName := make(map[string]*metadata.RemoteClusterReference)
+	refsByUuid := make(map[string]*metadata.RemoteClusterReference)
+	for _, ref := range refs {
+		refsByName[ref.Name()] = ref
+		refsByUuid[ref.Uuid()] = ref
+	}
+	specs, err := ReplicationSpecService().AllReplicationSpecs()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var refChanges, refDeletes, replChanges, replDeletes, unmanaged []*TopologyChange
+
+	declaredRefs := make(map[string]bool)
+	for i, entry := range config.RemoteClusters {
+		field := fmt.Sprintf("remoteClusters[%v]", i)
+		values, err := topologyEntryToValues(entry)
+		if err != nil {
+			errorsMap[field] = err
+			continue
+		}
+		_, ref, refErrorsMap, err := DecodeRemoteClusterRequest(newTopologyRequest(values))
+		for key, refErr := range refErrorsMap {
+			errorsMap[field+"."+key] = refErr
+		}
+		if err != nil {
+			errorsMap[field] = err
+		}
+		if ref == nil {
+			continue
+		}
+		if declaredRefs[ref.Name()] {
+			errorsMap[field] = fmt.Errorf("Remote cluster %v is declared more than once", ref.Name())
+			continue
+		}
+		declaredRefs[ref.Name()] = true
+
+		change := &TopologyChange{Kind: TopologyKindRemoteCluster, Name: ref.Name(), ref: ref}
+		currentRef, exists := refsByName[ref.Name()]
+		if !exists {
+			change.Action = TopologyActionCreate
+		} else if change.Drift = remoteClusterDrift(currentRef, ref); len(change.Drift) > 0 {
+			change.Action = TopologyActionUpdate
+		} else {
+			continue
+		}
+		refChanges = append(refChanges, change)
+	}
+
+	declaredRepls := make(map[string]bool)
+	managedSpecs := make(map[string]bool)
+	for i, entry := range config.Replications {
+		field := fmt.Sprintf("replications[%v]", i)
+		values, err := topologyEntryToValues(entry)
+		if err != nil {
+			errorsMap[field] = err
+			continue
+		}
+		if len(values.Get(ReplicationType)) == 0 {
+			values.Set(ReplicationType, ReplicationTypeValue)
+		}
+		fromBucket, toCluster, toBucket := values.Get(base.FromBucket), values.Get(base.ToCluster), values.Get(base.ToBucket)
+		replicationName := values.Get(base.ReplicationName)
+		name := topologyReplicationName(fromBucket, toCluster, toBucket, replicationName)
+		if declaredRepls[name] {
+			errorsMap[field] = fmt.Errorf("Replication %v is declared more than once", name)
+			continue
+		}
+		declaredRepls[name] = true
+
+		ref, refExists := refsByName[toCluster]
+		if config.Prune && !declaredRefs[toCluster] {
+			errorsMap[field+"."+base.ToCluster] = fmt.Errorf("Remote cluster %v is not in the config and would be deleted", toCluster)
+			continue
+		} else if !refExists && !declaredRefs[toCluster] {
+			errorsMap[field+"."+base.ToCluster] = fmt.Errorf("Remote cluster %v does not exist", toCluster)
+			continue
+		}
+
+		var spec *metadata.ReplicationSpecification
+		if refExists {
+			spec = specs[metadata.NamedReplicationId(fromBucket, ref.Uuid(), toBucket, replicationName)]
+		}
+		change := &TopologyChange{Kind: TopologyKindReplication, Name: name, sourceBucket: fromBucket}
+		if spec == nil {
+			// A replication to a remote cluster that has yet to be created is validated when it is created
+			if refExists {
+				_, _, _, _, _, _, replErrorsMap, err := DecodeCreateReplicationRequest(newTopologyRequest(values))
+				for key, replErr := range replErrorsMap {
+					errorsMap[field+"."+key] = replErr
+				}
+				if err != nil {
+					errorsMap[field] = err
+				}
+			}
+			change.Action = TopologyActionCreate
+			change.values = values
+			replChanges = append(replChanges, change)
+			continue
+		}
+
+		managedSpecs[spec.Id] = true
+		change.Id = spec.Id
+		drift, updateValues, replErrorsMap := replicationSettingsDrift(spec, values)
+		for key, replErr := range replErrorsMap {
+			errorsMap[field+"."+key] = replErr
+		}
+		if len(drift) > 0 {
+			change.Action = TopologyActionUpdate
+			change.Drift = drift
+			change.values = updateValues
+			replChanges = append(replChanges, change)
+		}
+	}
+
+	specIds := make([]string, 0, len(specs))
+	for specId := range specs {
+		specIds = append(specIds, specId)
+	}
+	sort.Strings(specIds)
+	for _, specId := range specIds {
+		if managedSpecs[specId] {
+			continue
+		}
+		spec := specs[specId]
+		toCluster := spec.TargetClusterUUID
+		if ref, exists := refsByUuid[spec.TargetClusterUUID]; exists {
+			toCluster = ref.Name()
+		}
+		change := &TopologyChange{Kind: TopologyKindReplication, Id: specId, sourceBucket: spec.SourceBucketName,
+			Name: topologyReplicationName(spec.SourceBucketName, toCluster, spec.TargetBucketName, spec.ReplicationName)}
+		if config.Prune {
+			change.Action = TopologyActionDelete
+			replDeletes = append(replDeletes, change)
+		} else {
+			change.Action = TopologyActionUnmanaged
+			unmanaged = append(unmanaged, change)
+		}
+	}
+
+	refNames := make([]string, 0, len(refsByName))
+	for refName := range refsByName {
+		refNames = append(refNames, refName)
+	}
+	sort.Strings(refNames)
+	for _, refName := range refNames {
+		if declaredRefs[refName] {
+			continue
+		}
+		change := &TopologyChange{Kind: TopologyKindRemoteCluster, Name: refName}
+		if config.Prune {
+			change.Action = TopologyActionDelete
+			refDeletes = append(refDeletes, change)
+		} else {
+			change.Action = TopologyActionUnmanaged
+			unmanaged = append(unmanaged, change)
+		}
+	}
+
+	if len(errorsMap) > 0 {
+		return nil, errorsMap, nil
+	}
+
+	plan := &TopologyPlan{Changes: make([]*TopologyChange, 0)}
+	for _, changes := range [][]*TopologyChange{refChanges, replDeletes, replChanges, refDeletes, unmanaged} {
+		plan.Changes = append(plan.Changes, changes...)
+	}
+	return plan, nil, nil
+}
+
+func remoteClusterDrift(currentRef, ref *metadata.RemoteClusterReference) map[string]*TopologyDrift {
+	drift := make(map[string]*TopologyDrift)
+	if currentRef.HostName() != ref.HostName() {
+		drift[base.RemoteClusterHostName] = &TopologyDrift{Current: currentRef.HostName(), Desired: ref.HostName()}
+	}
+	if currentRef.UserName() != ref.UserName() {
+		drift[base.RemoteClusterUserName] = &TopologyDrift{Current: currentRef.UserName(), Desired: ref.UserName()}
+	}
+	if currentRef.Password() != ref.Password() {
+		drift[base.RemoteClusterPassword] = &TopologyDrift{}
+	}
+	if currentRef.SecureTypeString() != ref.SecureTypeString() {
+		drift[base.RemoteClusterSecureType] = &TopologyDrift{Current: currentRef.SecureTypeString(), Desired: ref.SecureTypeString()}
+	}
+	if !bytes.Equal(currentRef.Certificates(), ref.Certificates()) {
+		drift[base.RemoteClusterCertificate] = &TopologyDrift{}
+	}
+	if !bytes.Equal(currentRef.ClientCertificate(), ref.ClientCertificate()) {
+		drift[base.RemoteClusterClientCertificate] = &TopologyDrift{}
+	}
+	if !bytes.Equal(currentRef.ClientKey(), ref.ClientKey()) {
+		drift[base.RemoteClusterClientKey] = &TopologyDrift{}
+	}
+	if currentRef.HostnameMode() != ref.HostnameMode() {
+		drift[base.RemoteClusterHostnameMode] = &TopologyDrift{Current: currentRef.HostnameMode(), Desired: ref.HostnameMode()}
+	}
+	return drift
+}
+
+// Returns the settings in the values that differ from the ones of the replication, and the values to update the
+// replication with. Settings that cannot be changed after the replication is created are only checked
+func replicationSettingsDrift(spec *metadata.ReplicationSpecification, values url.Values) (map[string]*TopologyDrift, url.Values, map[string]error) {
+	errorsMap := make(map[string]error)
+	isEnterprise, err := XDCRCompTopologyService().IsMyClusterEnterprise()
+	if err != nil {
+		errorsMap[base.PlaceHolderFieldKey] = err
+		return nil, nil, errorsMap
+	}
+
+	updateValues := make(url.Values)
+	for restKey, valArr := range values {
+		switch restKey {
+		case ReplicationType, base.FromBucket, base.ToCluster, base.ToBucket, base.ReplicationName:
+			continue
+		}
+		settingsKey, ok := RestKeyToSettingsKeyMap[restKey]
+		if ok && !metadata.IsSettingValueMutable(settingsKey) {
+			value, err := validateAndConvertAllSettingValue(settingsKey, getStringFromValArr(valArr), restKey, isEnterprise, spec.Settings.IsCapi())
+			if err != nil {
+				errorsMap[restKey] = err
+			} else if !reflect.DeepEqual(value, spec.Settings.Values[settingsKey]) {
+				errorsMap[restKey] = fmt.Errorf("Setting value cannot be modified after replication is created.")
+			}
+			continue
+		}
+		updateValues[restKey] = valArr
+	}
+	// A config that changes the filter restreams unless it says otherwise
+	if _, ok := updateValues[FilterExpression]; ok {
+		if _, ok := updateValues[FilterSkipRestreamKey]; !ok {
+			updateValues.Set(FilterSkipRestreamKey, "false")
+		}
+	}
+
+	_, settings, settingsErrorsMap, _ := DecodeChangeReplicationSettings(newTopologyRequest(updateValues), spec.Id)
+	for key, settingsErr := range settingsErrorsMap {
+		errorsMap[key] = settingsErr
+	}
+	if len(errorsMap) > 0 {
+		return nil, nil, errorsMap
+	}
+
+	changedSettingsMap, changeErrorsMap := spec.Settings.Clone().UpdateSettingsFromMap(settings)
+	for key, changeErr := range changeErrorsMap {
+		errorsMap[key] = changeErr
+	}
+	if len(errorsMap) > 0 {
+		return nil, nil, errorsMap
+	}
+
+	drift := make(map[string]*TopologyDrift)
+	for key, value := range changedSettingsMap {
+		// settings that only qualify the others are not drift by themselves
+		if metadata.IsSettingValueTemporary(key) || key == metadata.FilterSkipRestreamKey || key == metadata.FilterVersionKey {
+			continue
+		}
+		restKey, ok := SettingsKeyToRestKeyMap[key]
+		if !ok {
+			restKey = key
+		}
+		drift[restKey] = &TopologyDrift{
+			Current: convertSettingsInternalValuesToRESTValues(restKey, spec.Settings.Values[key]),
+			Desired: convertSettingsInternalValuesToRESTValues(restKey, value),
+		}
+	}
+	return drift, updateValues, nil
+}
+
+// ApplyTopologyPlan applies the changes of the plan in order, and stops at the first one that fails.
+// The changes before it stay applied, and planning the same config again picks up from there
+func ApplyTopologyPlan(plan *TopologyPlan, realUserId *service_def.RealUserId, ips *service_def.LocalRemoteIPs) error {
+	for _, change := range plan.Changes {
+		if change.Action == TopologyActionUnmanaged {
+			continue
+		}
+		logger_rm.Infof("Applying topology config change: %v %v %v", change.Action, change.Kind, change.Name)
+		err := change.apply(realUserId, ips)
+		if err != nil {
+			return fmt.Errorf("Failed to %v %v %v. The changes before it have been applied. err=%v", change.Action, change.Kind, change.Name, err)
+		}
+	}
+	plan.Applied = true
+	return nil
+}
+
+func (change *TopologyChange) apply(realUserId *service_def.RealUserId, ips *service_def.LocalRemoteIPs) error {
+	switch change.Kind {
+	case TopologyKindRemoteCluster:
+		return change.applyToRemoteCluster(realUserId, ips)
+	case TopologyKindReplication:
+		return change.applyToReplication(realUserId, ips)
+	default:
+		return fmt.Errorf("Unknown kind %v", change.Kind)
+	}
+}
+
+func (change *TopologyChange) applyToRemoteCluster(realUserId *service_def.RealUserId, ips *service_def.LocalRemoteIPs) error {
+	remoteClusterService := RemoteClusterService()
+	switch change.Action {
+	case TopologyActionCreate:
+		err := remoteClusterService.AddRemoteCluster(change.ref, false /*skipConnectivityValidation*/)
+		if err != nil {
+			return err
+		}
+		go writeRemoteClusterAuditEvent(service_def.CreateRemoteClusterRefEventId, change.ref, realUserId, ips)
+		go writeRemoteClusterSystemEvent(service_def.CreateRemoteClusterRefSystemEventId, change.ref)
+	case TopologyActionUpdate:
+		err := remoteClusterService.SetRemoteCluster(change.Name, change.ref)
+		if err != nil {
+			return err
+		}
+		go writeRemoteClusterAuditEvent(service_def.UpdateRemoteClusterRefEventId, change.ref, realUserId, ips)
+		go writeRemoteClusterSystemEvent(service_def.UpdateRemoteClusterRefSystemEventId, change.ref)
+	case TopologyActionDelete:
+		ref, err := remoteClusterService.RemoteClusterByRefName(change.Name, false)
+		if err != nil {
+			return err
+		}
+		specs, err := ReplicationSpecService().AllReplicationSpecs()
+		if err != nil {
+			return err
+		}
+		for _, spec := range specs {
+			if spec.TargetClusterUUID == ref.Uuid() {
+				return fmt.Errorf("Cannot delete remote cluster `%v` since it is referenced by replication %v", ref.Name(), spec.Id)
+			}
+		}
+		ref, err = remoteClusterService.DelRemoteCluster(change.Name)
+		if err != nil {
+			return err
+		}
+		go writeRemoteClusterAuditEvent(service_def.DeleteRemoteClusterRefEventId, ref, realUserId, ips)
+		go writeRemoteClusterSystemEvent(service_def.DeleteRemoteClusterRefSystemEventId, ref)
+	}
+	return nil
+}
+
+func (change *TopologyChange) applyToReplication(realUserId *service_def.RealUserId, ips *service_def.LocalRemoteIPs) error {
+	switch change.Action {
+	case TopologyActionCreate:
+		_, fromBucket, toCluster, toBucket, replicationName, settings, errorsMap, err := DecodeCreateReplicationRequest(newTopologyRequest(change.values))
+		if err != nil {
+			return err
+		} else if len(errorsMap) > 0 {
+			return fmt.Errorf("%v", errorsMap)
+		}
+		_, errorsMap, err, _ = CreateReplication(false /*justValidate*/, fromBucket, toCluster, toBucket, replicationName, settings, realUserId, ips)
+		if err != nil {
+			return err
+		} else if len(errorsMap) > 0 {
+			return fmt.Errorf("%v", errorsMap)
+		}
+	case TopologyActionUpdate:
+		_, settings, errorsMap, _ := DecodeChangeReplicationSettings(newTopologyRequest(change.values), change.Id)
+		if len(errorsMap) > 0 {
+			return fmt.Errorf("%v", errorsMap)
+		}
+		updateErrorsMap, err, _ := UpdateReplicationSettings(change.Id, settings, realUserId, ips, false /*justValidate*/)
+		if err != nil {
+			return err
+		} else if len(updateErrorsMap) > 0 {
+			return fmt.Errorf("%v", updateErrorsMap)
+		}
+	case TopologyActionDelete:
+		return DeleteReplication(change.Id, realUserId, ips)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software
+will be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package replication_manager
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/couchbase/goxdcr/base"
+	"github.com/couchbase/goxdcr/metadata"
+	service_def "github.com/couchbase/goxdcr/service_def/mocks"
+	"github.com/stretchr/testify/assert"
+	mock "github.com/stretchr/testify/mock"
+)
+
+const (
+	topologyTargetClusterUuid = "targetClusterUuid"
+	topologyReplName          = "second"
+)
+
+func setupTopologyBoilerPlate(specs ...*metadata.ReplicationSpecification) (*service_def.RemoteClusterSvc, *service_def.ReplicationSpecSvc) {
+	remoteClusterSvc := &service_def.RemoteClusterSvc{}
+	replSpecSvc := &service_def.ReplicationSpecSvc{}
+	xdcrTopologySvc := &service_def.XDCRCompTopologySvc{}
+
+	ref, _ := metadata.NewRemoteClusterReference(topologyTargetClusterUuid, dryRunTargetCluster, "127.0.0.1:9000", "Administrator", "password", "",
+		false, "", nil, nil, nil, nil)
+	remoteClusterSvc.On("RemoteClusters").Return(map[string]*metadata.RemoteClusterReference{ref.Id(): ref}, nil)
+	remoteClusterSvc.On("RemoteClusterByUuid", topologyTargetClusterUuid, false).Return(ref, nil)
+
+	specsMap := make(map[string]*metadata.ReplicationSpecification)
+	for _, spec := range specs {
+		specsMap[spec.Id] = spec
+		replSpecSvc.On("ReplicationSpec", spec.Id).Return(spec, nil)
+	}
+	replSpecSvc.On("AllReplicationSpecs").Return(specsMap, nil)
+	xdcrTopologySvc.On("IsMyClusterEnterprise").Return(true, nil)
+
+	replication_mgr.remote_cluster_svc = remoteClusterSvc
+	replication_mgr.repl_spec_svc = replSpecSvc
+	replication_mgr.xdcr_topology_svc = xdcrTopologySvc
+	return remoteClusterSvc, replSpecSvc
+}
+
+func newTopologySpec(replicationName string) *metadata.ReplicationSpecification {
+	spec, _ := metadata.NewNamedReplicationSpecification(dryRunSourceBucket, "sourceBucketUuid", topologyTargetClusterUuid, dryRunTargetBucket, "targetBucketUuid", replicationName)
+	return spec
+}
+
+func newTopologyConfig(prune bool, replicationNames ...string) *TopologyConfig {
+	config := &TopologyConfig{
+		RemoteClusters: []map[string]interface{}{{
+			base.RemoteClusterName:     dryRunTargetCluster,
+			base.RemoteClusterHostName: "127.0.0.1:9000",
+			base.RemoteClusterUserName: "Administrator",
+			base.RemoteClusterPassword: "password",
+		}},
+		Prune: prune,
+	}
+	for _, replicationName := range replicationNames {
+		entry := map[string]interface{}{
+			base.FromBucket: dryRunSourceBucket,
+			base.ToCluster:  dryRunTargetCluster,
+			base.ToBucket:   dryRunTargetBucket,
+		}
+		if replicationName != "" {
+			entry[base.ReplicationName] = replicationName
+		}
+		config.Replications = append(config.Replications, entry)
+	}
+	return config
+}
+
+func assertNothingApplied(t *testing.T, remoteClusterSvc *service_def.RemoteClusterSvc, replSpecSvc *service_def.ReplicationSpecSvc) {
+	remoteClusterSvc.AssertNotCalled(t, "AddRemoteCluster", mock.Anything, mock.Anything)
+	remoteClusterSvc.AssertNotCalled(t, "SetRemoteCluster", mock.Anything, mock.Anything)
+	remoteClusterSvc.AssertNotCalled(t, "DelRemoteCluster", mock.Anything)
+	replSpecSvc.AssertNotCalled(t, "AddReplicationSpec", mock.Anything, mock.Anything)
+	replSpecSvc.AssertNotCalled(t, "SetReplicationSpec", mock.Anything)
+	replSpecSvc.AssertNotCalled(t, "DelReplicationSpec", mock.Anything)
+}
+
+func TestTopologyConfigIsIdempotent(t *testing.T) {
+	fmt.Println("============== Test case start: TestTopologyConfigIsIdempotent =================")
+	defer fmt.Println("============== Test case end: TestTopologyConfigIsIdempotent =================")
+	assert := assert.New(t)
+
+	// an unnamed and a named replication of the same bucket pair, both of which already exist
+	for _, prune := range []bool{false, true} {
+		remoteClusterSvc, replSpecSvc := setupTopologyBoilerPlate(newTopologySpec(""), newTopologySpec(topologyReplName))
+		plan, errorsMap, err := PlanTopologyConfig(newTopologyConfig(prune, "", topologyReplName))
+		assert.Nil(err)
+		assert.Len(errorsMap, 0)
+		assert.NotNil(plan)
+		assert.Len(plan.Changes, 0)
+
+		assert.Nil(ApplyTopologyPlan(plan, nil, nil))
+		assert.True(plan.Applied)
+		assertNothingApplied(t, remoteClusterSvc, replSpecSvc)
+	}
+}
+
+func TestTopologyConfigNamedReplications(t *testing.T) {
+	fmt.Println("============== Test case start: TestTopologyConfigNamedReplications =================")
+	defer fmt.Println("============== Test case end: TestTopologyConfigNamedReplications =================")
+	assert := assert.New(t)
+
+	unnamedSpec := newTopologySpec("")
+	namedSpec := newTopologySpec(topologyReplName)
+	unnamedName := topologyReplicationName(dryRunSourceBucket, dryRunTargetCluster, dryRunTargetBucket, "")
+	namedName := topologyReplicationName(dryRunSourceBucket, dryRunTargetCluster, dryRunTargetBucket, topologyReplName)
+	assert.NotEqual(unnamedName, namedName)
+
+	// a named replication that does not exist yet is created next to the existing one of the same bucket pair
+	setupTopologyBoilerPlate(unnamedSpec)
+	plan, errorsMap, err := PlanTopologyConfig(newTopologyConfig(true, "", topologyReplName))
+	assert.Nil(err)
+	assert.Len(errorsMap, 0)
+	assert.Len(plan.Changes, 1)
+	assert.Equal(TopologyActionCreate, plan.Changes[0].Action)
+	assert.Equal(TopologyKindReplication, plan.Changes[0].Kind)
+	assert.Equal(namedName, plan.Changes[0].Name)
+
+	// only the named replication that is left out of a pruning config is deleted
+	setupTopologyBoilerPlate(unnamedSpec, namedSpec)
+	plan, errorsMap, err = PlanTopologyConfig(newTopologyConfig(true, ""))
+	assert.Nil(err)
+	assert.Len(errorsMap, 0)
+	assert.Len(plan.Changes, 1)
+	assert.Equal(TopologyActionDelete, plan.Changes[0].Action)
+	assert.Equal(namedSpec.Id, plan.Changes[0].Id)
+	assert.Equal(namedName, plan.Changes[0].Name)
+
+	// without pruning, it is reported as unmanaged
+	setupTopologyBoilerPlate(unnamedSpec, namedSpec)
+	plan, errorsMap, err = PlanTopologyConfig(newTopologyConfig(false, ""))
+	assert.Nil(err)
+	assert.Len(errorsMap, 0)
+	assert.Len(plan.Changes, 1)
+	assert.Equal(TopologyActionUnmanaged, plan.Changes[0].Action)
+	assert.Equal(namedSpec.Id, plan.Changes[0].Id)
+
+	// the same named replication cannot be declared twice
+	setupTopologyBoilerPlate(unnamedSpec, namedSpec)
+	plan, errorsMap, err = PlanTopologyConfig(newTopologyConfig(false, topologyReplName, topologyReplName))
+	assert.Nil(err)
+	assert.Nil(plan)
+	assert.NotNil(errorsMap["replications[1]"])
+}
@@ -34,6 +34,12 @@ type CheckpointsService interface {
 
 	LoadBrokenMappings(replicationId string) (metadata.ShaToCollectionNamespaceMap, *metadata.CollectionNsMappingsDoc, IncrementerFunc, bool, error)
 	UpsertBrokenMappingsDoc(replicationId string, mappingDoc *metadata.CollectionNsMappingsDoc, ckptDoc map[uint16]*metadata.CheckpointsDoc, internalId string) error
+
+	// For stop when caught up replications, the VBs that each node has caught up on
+	UpsertCaughtUpVBs(specInternalId, nodeId string, vbnos []uint16) error
+	CaughtUpVBs(specInternalId string) ([]uint16, error)
+	DelCaughtUpVBs(specInternalId, nodeId string) error
+	DelAllCaughtUpVBs(specInternalId string) error
 }
 
 type IncrementerFunc func(shaString string, mapping *metadata.CollectionNamespaceMapping)
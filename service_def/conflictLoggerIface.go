@@ -0,0 +1,21 @@
+/*
+Copyright 2021-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software
+will be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package service_def
+
+import (
+	"github.com/couchbase/goxdcr/base"
+)
+
+type ConflictLoggerIface interface {
+	// Log queues the record to be written to the conflict log destination. It does not block.
+	// Records are dropped if the logger cannot keep up
+	Log(record *base.ConflictRecord)
+}
@@ -38,7 +38,9 @@ const (
 	DeleteReplicationSystemEventId               EventIdType = 7174
 	UpdateDefaultReplicationSettingSystemEventId EventIdType = 7175
 	UpdateReplicationSettingSystemEventId        EventIdType = 7176
-	MaxSystemEventId                             EventIdType = 7176
+	ReplicationCaughtUpSystemEventId             EventIdType = 7177
+	BulkDeletesDetectedSystemEventId             EventIdType = 7178
+	MaxSystemEventId                             EventIdType = 7178
 )
 
 const (
@@ -32,6 +32,29 @@ func (_m *CheckpointsService) BackfillReplicationSpecChangeCallback(metadataId s
 	return r0
 }
 
+// CaughtUpVBs provides a mock function with given fields: specInternalId
+func (_m *CheckpointsService) CaughtUpVBs(specInternalId string) ([]uint16, error) {
+	ret := _m.Called(specInternalId)
+
+	var r0 []uint16
+	if rf, ok := ret.Get(0).(func(string) []uint16); ok {
+		r0 = rf(specInternalId)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]uint16)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(specInternalId)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // CheckpointsDoc provides a mock function with given fields: replicationId, vbno
 func (_m *CheckpointsService) CheckpointsDoc(replicationId string, vbno uint16) (*metadata.CheckpointsDoc, error) {
 	ret := _m.Called(replicationId, vbno)
@@ -92,6 +115,34 @@ func (_m *CheckpointsService) CollectionsManifestChangeCb(metadataId string, old
 	return r0
 }
 
+// DelAllCaughtUpVBs provides a mock function with given fields: specInternalId
+func (_m *CheckpointsService) DelAllCaughtUpVBs(specInternalId string) error {
+	ret := _m.Called(specInternalId)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string) error); ok {
+		r0 = rf(specInternalId)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DelCaughtUpVBs provides a mock function with given fields: specInternalId, nodeId
+func (_m *CheckpointsService) DelCaughtUpVBs(specInternalId string, nodeId string) error {
+	ret := _m.Called(specInternalId, nodeId)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string) error); ok {
+		r0 = rf(specInternalId, nodeId)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // DelCheckpointsDoc provides a mock function with given fields: replicationId, vbno
 func (_m *CheckpointsService) DelCheckpointsDoc(replicationId string, vbno uint16) error {
 	ret := _m.Called(replicationId, vbno)
@@ -293,6 +344,20 @@ func (_m *CheckpointsService) UpsertBrokenMappingsDoc(replicationId string, mapp
 	return r0
 }
 
+// UpsertCaughtUpVBs provides a mock function with given fields: specInternalId, nodeId, vbnos
+func (_m *CheckpointsService) UpsertCaughtUpVBs(specInternalId string, nodeId string, vbnos []uint16) error {
+	ret := _m.Called(specInternalId, nodeId, vbnos)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string, []uint16) error); ok {
+		r0 = rf(specInternalId, nodeId, vbnos)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // UpsertCheckpoints provides a mock function with given fields: replicationId, specInternalId, vbno, ckpt_record
 func (_m *CheckpointsService) UpsertCheckpoints(replicationId string, specInternalId string, vbno uint16, ckpt_record *metadata.CheckpointRecord) (int, error) {
 	ret := _m.Called(replicationId, specInternalId, vbno, ckpt_record)
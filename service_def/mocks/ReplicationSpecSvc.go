@@ -349,27 +349,27 @@ func (_m *ReplicationSpecSvc) ValidateAndGC(spec *metadata.ReplicationSpecificat
 	_m.Called(spec)
 }
 
-// ValidateNewReplicationSpec provides a mock function with given fields: sourceBucket, targetCluster, targetBucket, settings, performRemoteValidation
-func (_m *ReplicationSpecSvc) ValidateNewReplicationSpec(sourceBucket string, targetCluster string, targetBucket string, settings metadata.ReplicationSettingsMap, performRemoteValidation bool) (string, string, *metadata.RemoteClusterReference, base.ErrorMap, error, service_def.UIWarnings) {
-	ret := _m.Called(sourceBucket, targetCluster, targetBucket, settings, performRemoteValidation)
+// ValidateNewReplicationSpec provides a mock function with given fields: sourceBucket, targetCluster, targetBucket, replicationName, settings, performRemoteValidation
+func (_m *ReplicationSpecSvc) ValidateNewReplicationSpec(sourceBucket string, targetCluster string, targetBucket string, replicationName string, settings metadata.ReplicationSettingsMap, performRemoteValidation bool) (string, string, *metadata.RemoteClusterReference, base.ErrorMap, error, service_def.UIWarnings) {
+	ret := _m.Called(sourceBucket, targetCluster, targetBucket, replicationName, settings, performRemoteValidation)
 
 	var r0 string
-	if rf, ok := ret.Get(0).(func(string, string, string, metadata.ReplicationSettingsMap, bool) string); ok {
-		r0 = rf(sourceBucket, targetCluster, targetBucket, settings, performRemoteValidation)
+	if rf, ok := ret.Get(0).(func(string, string, string, string, metadata.ReplicationSettingsMap, bool) string); ok {
+		r0 = rf(sourceBucket, targetCluster, targetBucket, replicationName, settings, performRemoteValidation)
 	} else {
 		r0 = ret.Get(0).(string)
 	}
 
 	var r1 string
-	if rf, ok := ret.Get(1).(func(string, string, string, metadata.ReplicationSettingsMap, bool) string); ok {
-		r1 = rf(sourceBucket, targetCluster, targetBucket, settings, performRemoteValidation)
+	if rf, ok := ret.Get(1).(func(string, string, string, string, metadata.ReplicationSettingsMap, bool) string); ok {
+		r1 = rf(sourceBucket, targetCluster, targetBucket, replicationName, settings, performRemoteValidation)
 	} else {
 		r1 = ret.Get(1).(string)
 	}
 
 	var r2 *metadata.RemoteClusterReference
-	if rf, ok := ret.Get(2).(func(string, string, string, metadata.ReplicationSettingsMap, bool) *metadata.RemoteClusterReference); ok {
-		r2 = rf(sourceBucket, targetCluster, targetBucket, settings, performRemoteValidation)
+	if rf, ok := ret.Get(2).(func(string, string, string, string, metadata.ReplicationSettingsMap, bool) *metadata.RemoteClusterReference); ok {
+		r2 = rf(sourceBucket, targetCluster, targetBucket, replicationName, settings, performRemoteValidation)
 	} else {
 		if ret.Get(2) != nil {
 			r2 = ret.Get(2).(*metadata.RemoteClusterReference)
@@ -377,8 +377,8 @@ func (_m *ReplicationSpecSvc) ValidateNewReplicationSpec(sourceBucket string, ta
 	}
 
 	var r3 base.ErrorMap
-	if rf, ok := ret.Get(3).(func(string, string, string, metadata.ReplicationSettingsMap, bool) base.ErrorMap); ok {
-		r3 = rf(sourceBucket, targetCluster, targetBucket, settings, performRemoteValidation)
+	if rf, ok := ret.Get(3).(func(string, string, string, string, metadata.ReplicationSettingsMap, bool) base.ErrorMap); ok {
+		r3 = rf(sourceBucket, targetCluster, targetBucket, replicationName, settings, performRemoteValidation)
 	} else {
 		if ret.Get(3) != nil {
 			r3 = ret.Get(3).(base.ErrorMap)
@@ -386,15 +386,15 @@ func (_m *ReplicationSpecSvc) ValidateNewReplicationSpec(sourceBucket string, ta
 	}
 
 	var r4 error
-	if rf, ok := ret.Get(4).(func(string, string, string, metadata.ReplicationSettingsMap, bool) error); ok {
-		r4 = rf(sourceBucket, targetCluster, targetBucket, settings, performRemoteValidation)
+	if rf, ok := ret.Get(4).(func(string, string, string, string, metadata.ReplicationSettingsMap, bool) error); ok {
+		r4 = rf(sourceBucket, targetCluster, targetBucket, replicationName, settings, performRemoteValidation)
 	} else {
 		r4 = ret.Error(4)
 	}
 
 	var r5 service_def.UIWarnings
-	if rf, ok := ret.Get(5).(func(string, string, string, metadata.ReplicationSettingsMap, bool) service_def.UIWarnings); ok {
-		r5 = rf(sourceBucket, targetCluster, targetBucket, settings, performRemoteValidation)
+	if rf, ok := ret.Get(5).(func(string, string, string, string, metadata.ReplicationSettingsMap, bool) service_def.UIWarnings); ok {
+		r5 = rf(sourceBucket, targetCluster, targetBucket, replicationName, settings, performRemoteValidation)
 	} else {
 		if ret.Get(5) != nil {
 			r5 = ret.Get(5).(service_def.UIWarnings)
@@ -404,13 +404,13 @@ func (_m *ReplicationSpecSvc) ValidateNewReplicationSpec(sourceBucket string, ta
 	return r0, r1, r2, r3, r4, r5
 }
 
-// ValidateReplicationSettings provides a mock function with given fields: sourceBucket, targetCluster, targetBucket, settings, performRemoteValidation
-func (_m *ReplicationSpecSvc) ValidateReplicationSettings(sourceBucket string, targetCluster string, targetBucket string, settings metadata.ReplicationSettingsMap, performRemoteValidation bool) (base.ErrorMap, error, service_def.UIWarnings) {
-	ret := _m.Called(sourceBucket, targetCluster, targetBucket, settings, performRemoteValidation)
+// ValidateReplicationSettings provides a mock function with given fields: sourceBucket, targetCluster, targetBucket, replicationName, settings, performRemoteValidation
+func (_m *ReplicationSpecSvc) ValidateReplicationSettings(sourceBucket string, targetCluster string, targetBucket string, replicationName string, settings metadata.ReplicationSettingsMap, performRemoteValidation bool) (base.ErrorMap, error, service_def.UIWarnings) {
+	ret := _m.Called(sourceBucket, targetCluster, targetBucket, replicationName, settings, performRemoteValidation)
 
 	var r0 base.ErrorMap
-	if rf, ok := ret.Get(0).(func(string, string, string, metadata.ReplicationSettingsMap, bool) base.ErrorMap); ok {
-		r0 = rf(sourceBucket, targetCluster, targetBucket, settings, performRemoteValidation)
+	if rf, ok := ret.Get(0).(func(string, string, string, string, metadata.ReplicationSettingsMap, bool) base.ErrorMap); ok {
+		r0 = rf(sourceBucket, targetCluster, targetBucket, replicationName, settings, performRemoteValidation)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(base.ErrorMap)
@@ -418,15 +418,15 @@ func (_m *ReplicationSpecSvc) ValidateReplicationSettings(sourceBucket string, t
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string, string, string, metadata.ReplicationSettingsMap, bool) error); ok {
-		r1 = rf(sourceBucket, targetCluster, targetBucket, settings, performRemoteValidation)
+	if rf, ok := ret.Get(1).(func(string, string, string, string, metadata.ReplicationSettingsMap, bool) error); ok {
+		r1 = rf(sourceBucket, targetCluster, targetBucket, replicationName, settings, performRemoteValidation)
 	} else {
 		r1 = ret.Error(1)
 	}
 
 	var r2 service_def.UIWarnings
-	if rf, ok := ret.Get(2).(func(string, string, string, metadata.ReplicationSettingsMap, bool) service_def.UIWarnings); ok {
-		r2 = rf(sourceBucket, targetCluster, targetBucket, settings, performRemoteValidation)
+	if rf, ok := ret.Get(2).(func(string, string, string, string, metadata.ReplicationSettingsMap, bool) service_def.UIWarnings); ok {
+		r2 = rf(sourceBucket, targetCluster, targetBucket, replicationName, settings, performRemoteValidation)
 	} else {
 		if ret.Get(2) != nil {
 			r2 = ret.Get(2).(service_def.UIWarnings)
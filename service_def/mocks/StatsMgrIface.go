@@ -7,6 +7,8 @@ import (
 	mock "github.com/stretchr/testify/mock"
 
 	service_def "github.com/couchbase/goxdcr/service_def"
+
+	time "time"
 )
 
 // StatsMgrIface is an autogenerated mock type for the StatsMgrIface type
@@ -35,6 +37,27 @@ func (_m *StatsMgrIface) GetCountMetrics(key string) (int64, error) {
 	return r0, r1
 }
 
+// GetReplicationLag provides a mock function with given fields:
+func (_m *StatsMgrIface) GetReplicationLag() (time.Duration, error) {
+	ret := _m.Called()
+
+	var r0 time.Duration
+	if rf, ok := ret.Get(0).(func() time.Duration); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(time.Duration)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetThroughSeqnosFromTsService provides a mock function with given fields:
 func (_m *StatsMgrIface) GetThroughSeqnosFromTsService() map[uint16]uint64 {
 	ret := _m.Called()
@@ -74,6 +97,27 @@ func (_m *StatsMgrIface) GetVBCountMetrics(vb uint16) (service_def.VBCountMetric
 	return r0, r1
 }
 
+// GetVBLag provides a mock function with given fields: vb
+func (_m *StatsMgrIface) GetVBLag(vb uint16) (time.Duration, error) {
+	ret := _m.Called(vb)
+
+	var r0 time.Duration
+	if rf, ok := ret.Get(0).(func(uint16) time.Duration); ok {
+		r0 = rf(vb)
+	} else {
+		r0 = ret.Get(0).(time.Duration)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(uint16) error); ok {
+		r1 = rf(vb)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // HandleLatestThroughSeqnos provides a mock function with given fields: SeqnoMap
 func (_m *StatsMgrIface) HandleLatestThroughSeqnos(SeqnoMap map[uint16]uint64) {
 	_m.Called(SeqnoMap)
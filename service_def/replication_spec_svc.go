@@ -29,8 +29,8 @@ type ReplicationSpecSvc interface {
 	ReplicationSpecReadOnly(replicationId string) (*metadata.ReplicationSpecification, error)
 	// additionalInfo is an optional parameter, which, if provided, will be written to replication creation ui log
 	AddReplicationSpec(spec *metadata.ReplicationSpecification, additionalInfo string) error
-	ValidateNewReplicationSpec(sourceBucket, targetCluster, targetBucket string, settings metadata.ReplicationSettingsMap, performRemoteValidation bool) (string, string, *metadata.RemoteClusterReference, base.ErrorMap, error, UIWarnings)
-	ValidateReplicationSettings(sourceBucket, targetCluster, targetBucket string, settings metadata.ReplicationSettingsMap, performRemoteValidation bool) (base.ErrorMap, error, UIWarnings)
+	ValidateNewReplicationSpec(sourceBucket, targetCluster, targetBucket, replicationName string, settings metadata.ReplicationSettingsMap, performRemoteValidation bool) (string, string, *metadata.RemoteClusterReference, base.ErrorMap, error, UIWarnings)
+	ValidateReplicationSettings(sourceBucket, targetCluster, targetBucket, replicationName string, settings metadata.ReplicationSettingsMap, performRemoteValidation bool) (base.ErrorMap, error, UIWarnings)
 	SetReplicationSpec(spec *metadata.ReplicationSpecification) error
 	DelReplicationSpec(replicationId string) (*metadata.ReplicationSpecification, error)
 	DelReplicationSpecWithReason(replicationId string, reason string) (*metadata.ReplicationSpecification, error)
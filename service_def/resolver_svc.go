@@ -10,7 +10,11 @@ licenses/APL2.txt.
 
 package service_def
 
-import "github.com/couchbase/goxdcr/base"
+import (
+	"encoding/json"
+
+	"github.com/couchbase/goxdcr/base"
+)
 
 type ResolverSvcIface interface {
 	ResolveAsync(params *base.ConflictParams, finish_ch chan bool)
@@ -19,3 +23,13 @@ type ResolverSvcIface interface {
 	Started() bool
 	CheckMergeFunction(fname string) error
 }
+
+// Implemented by resolver services whose merge functions are managed through the XDCR REST API.
+// The merge functions are stored in metakv so that every node resolves conflicts with the same functions
+type MergeFunctionsSvc interface {
+	AllMergeFunctions() map[string]json.RawMessage
+	GetMergeFunction(fname string) (json.RawMessage, error)
+	SetMergeFunction(fname string, spec []byte) error
+	DelMergeFunction(fname string) error
+	MergeFunctionsServiceCallback(path string, value []byte, rev interface{}) error
+}
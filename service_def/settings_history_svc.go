@@ -0,0 +1,21 @@
+// Copyright 2021-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included in
+// the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+// file, in accordance with the Business Source License, use of this software
+// will be governed by the Apache License, Version 2.0, included in the file
+// licenses/APL2.txt.
+
+package service_def
+
+import (
+	"github.com/couchbase/goxdcr/metadata"
+)
+
+// Keeps the recent changes to the settings of each replication, the default replication settings, the global settings
+// and the internal settings, so that the settings can be rolled back to an earlier version
+type SettingsHistorySvc interface {
+	GetSettingsHistory(subject string) (*metadata.SettingsHistory, error)
+	RecordSettingsChange(subject string, before, after map[string]interface{}, user string) error
+	DelSettingsHistory(subject string) error
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/couchbase/goxdcr/base"
 	"github.com/couchbase/goxdcr/metadata"
 	"strings"
+	"time"
 )
 
 // Stats per vbucket
@@ -26,6 +27,9 @@ type StatsMgrIface interface {
 	GetVBCountMetrics(vb uint16) (VBCountMetricMap, error)
 	SetVBCountMetrics(vb uint16, metricKVs VBCountMetricMap) error
 	HandleLatestThroughSeqnos(SeqnoMap map[uint16]uint64)
+	// How far behind the target is, in time, as of the last stats update
+	GetReplicationLag() (time.Duration, error)
+	GetVBLag(vb uint16) (time.Duration, error)
 }
 
 const (
@@ -51,6 +55,14 @@ const (
 	DATA_MERGE_FAILED_METRIC        = "data_merge_failed"
 	EXPIRY_DOCS_MERGE_FAILED_METRIC = "expiry_docs_merge_failed"
 
+	// the number of docs that failed source side CR and were written to or dropped by the conflict log
+	DOCS_CONFLICT_LOGGED_METRIC      = "docs_conflict_logged"
+	DOCS_CONFLICT_LOG_DROPPED_METRIC = "docs_conflict_log_dropped"
+
+	// the number of docs rejected by the target that were written to or could not be written to the dead letter destination
+	DOCS_DEAD_LETTERED_METRIC       = "docs_dead_lettered"
+	DEAD_LETTER_WRITE_FAILED_METRIC = "dead_letter_write_failed"
+
 	// the number of docs processed by pipeline
 	DOCS_PROCESSED_METRIC  = "docs_processed"
 	DATA_REPLICATED_METRIC = "data_replicated"
@@ -81,6 +93,11 @@ const (
 	GET_DOC_LATENCY_METRIC = "wtavg_get_doc_latency"
 	RESP_WAIT_METRIC       = "resp_wait_time"
 
+	// how far behind the target is, in time, across the VBs of this node
+	// and the same broken down by VB, published under VBUCKETS_METRICS_KEY
+	REPLICATION_LAG_METRIC    = "replication_lag"
+	VB_REPLICATION_LAG_METRIC = "replication_lag_vbs"
+
 	//checkpointing related statistics
 	DOCS_CHECKED_METRIC    = "docs_checked" //calculated
 	NUM_CHECKPOINTS_METRIC = "num_checkpoints"
@@ -114,6 +131,12 @@ const (
 
 	OVERVIEW_METRICS_KEY = "Overview"
 
+	// Per collection breakdown of a subset of the stats, keyed by source and target namespace
+	COLLECTIONS_METRICS_KEY = "Collections"
+
+	// Per VB breakdown of a subset of the stats, keyed by stats name and then by vbno
+	VBUCKETS_METRICS_KEY = "VBuckets"
+
 	//statistics_manager's setting
 	PUBLISH_INTERVAL = "publish_interval"
 
@@ -125,7 +148,9 @@ const (
 
 const (
 	PrometheusXDCRPrefix = "xdcr"
-	PrometheusDelimiter  = "_"
+	// High cardinality stats are broken down by collection, and are named apart from the per replication totals
+	PrometheusCollectionPrefix = "collection"
+	PrometheusDelimiter        = "_"
 
 	PrometheusBaseNoUnit       = "total"
 	PrometheusBaseUnitTime     = "seconds"
@@ -196,13 +221,16 @@ func (sm StatisticsPropertyMap) GetPrometheusMetricName(internalConst string) (s
 	var output []string
 	// Must be prefixed by our service name
 	output = append(output, PrometheusXDCRPrefix)
-	// Then followed by the internal name
-	output = append(output, internalConst)
-	// Output the base unit
 	statsProperty, ok := sm[internalConst]
 	if !ok {
 		return "", fmt.Errorf("%v is not a proper stats for prometheus", internalConst)
 	}
+	if statsProperty.Cardinality == HighCardinality {
+		output = append(output, PrometheusCollectionPrefix)
+	}
+	// Then followed by the internal name
+	output = append(output, internalConst)
+	// Output the base unit
 	output = append(output, GlobalBaseUnitTable[statsProperty.MetricType.Unit])
 
 	return strings.Join(output, PrometheusDelimiter), nil
@@ -249,6 +277,12 @@ var GlobalStatsTable = StatisticsPropertyMap{
 	EXPIRY_DOCS_MERGE_FAILED_METRIC: StatsProperty{StatsUnit{MetricTypeCounter, StatsMgrNoUnit}, LowCardinality, "Number of conflicting expiry docs failed to merge"},
 	DATA_MERGE_FAILED_METRIC:        StatsProperty{StatsUnit{MetricTypeCounter, StatsMgrBytes}, LowCardinality, "Amount of data failed to merge"},
 
+	DOCS_CONFLICT_LOGGED_METRIC:      StatsProperty{StatsUnit{MetricTypeCounter, StatsMgrNoUnit}, LowCardinality, "Number of documents that failed source side conflict resolution and were written to the conflict log"},
+	DOCS_CONFLICT_LOG_DROPPED_METRIC: StatsProperty{StatsUnit{MetricTypeCounter, StatsMgrNoUnit}, LowCardinality, "Number of documents that failed source side conflict resolution but could not be written to the conflict log"},
+
+	DOCS_DEAD_LETTERED_METRIC:       StatsProperty{StatsUnit{MetricTypeCounter, StatsMgrNoUnit}, LowCardinality, "Number of documents rejected by the target that were written to the dead letter destination"},
+	DEAD_LETTER_WRITE_FAILED_METRIC: StatsProperty{StatsUnit{MetricTypeCounter, StatsMgrNoUnit}, LowCardinality, "Number of documents rejected by the target that could not be written to the dead letter destination and are resent after the pipeline restarts"},
+
 	DOCS_PROCESSED_METRIC:  StatsProperty{StatsUnit{MetricTypeGauge, StatsMgrNoUnit}, LowCardinality, "Number of docs processed for a replication"},
 	DATA_REPLICATED_METRIC: StatsProperty{StatsUnit{MetricTypeCounter, StatsMgrBytes}, LowCardinality, "Amount of data replicated for a replication"},
 	SIZE_REP_QUEUE_METRIC:  StatsProperty{StatsUnit{MetricTypeGauge, StatsMgrBytes}, LowCardinality, "Amount of data being queued to be sent in an out nozzle"},
@@ -278,6 +312,9 @@ var GlobalStatsTable = StatisticsPropertyMap{
 	RESP_WAIT_METRIC:       StatsProperty{StatsUnit{MetricTypeGauge, StatsMgrMilliSecond}, LowCardinality, "The rolling average amount of time it takes from when a MemcachedRequest is created to be ready to route to an outnozzle to the time that the response has been heard back from the target node after a successful write"},
 	MERGE_LATENCY_METRIC:   StatsProperty{StatsUnit{MetricTypeGauge, StatsMgrMilliSecond}, LowCardinality, "The rolling average amount of time it takes from routing, conflict detection and resolution, to receive the acknowledgement of merge"},
 
+	REPLICATION_LAG_METRIC:    StatsProperty{StatsUnit{MetricTypeGauge, StatsMgrMilliSecond}, LowCardinality, "Given the VBs of this node, the largest amount of time the target is behind the source, i.e. the age of the latest mutation that has been replicated or handled for a VB that has yet to catch up to its high sequence number"},
+	VB_REPLICATION_LAG_METRIC: StatsProperty{StatsUnit{MetricTypeGauge, StatsMgrMilliSecond}, LowCardinality, "The amount of time the target is behind the source for the source VB given by the sourceVBucket label"},
+
 	DOCS_CHECKED_METRIC:    StatsProperty{StatsUnit{MetricTypeGauge, StatsMgrNoUnit}, LowCardinality, "Across VBs for this node, the sum of all seqnos that have been considered to be checkpointed"},
 	NUM_CHECKPOINTS_METRIC: StatsProperty{StatsUnit{MetricTypeCounter, StatsMgrNoUnit}, LowCardinality, "The number of times checkpoint operation has completed successfully since this XDCR process instance is made aware of this replication"},
 	TIME_COMMITING_METRIC:  StatsProperty{StatsUnit{MetricTypeGauge, StatsMgrMilliSecond}, LowCardinality, "The rolling average amount of time it takes for a checkpoint operation to complete"},
@@ -308,3 +345,13 @@ var GlobalStatsTable = StatisticsPropertyMap{
 
 	DOCS_CLONED_METRIC: StatsProperty{StatsUnit{MetricTypeCounter, StatsMgrNoUnit}, LowCardinality, "The number of times a source mutation is cloned to be written to different target namespace"},
 }
+
+// The stats under COLLECTIONS_METRICS_KEY that are exported to prometheus, labeled with the source and target collection
+// Stats that are counted before a mutation is routed, i.e. docs_filtered and changes_left, have no target collection
+var GlobalCollectionStatsTable = StatisticsPropertyMap{
+	DOCS_WRITTEN_METRIC:          StatsProperty{StatsUnit{MetricTypeCounter, StatsMgrNoUnit}, HighCardinality, "Number of docs written/sent from the source collection to the target collection"},
+	DOCS_FILTERED_METRIC:         StatsProperty{StatsUnit{MetricTypeCounter, StatsMgrNoUnit}, HighCardinality, "Number of documents of the source collection that were filtered out and not replicated to the target cluster"},
+	DOCS_FAILED_CR_SOURCE_METRIC: StatsProperty{StatsUnit{MetricTypeCounter, StatsMgrNoUnit}, HighCardinality, "Number of documents from the source collection that were not replicated to the target collection due to conflict resolution evaluated on the source cluster"},
+	CHANGES_LEFT_METRIC:          StatsProperty{StatsUnit{MetricTypeGauge, StatsMgrNoUnit}, HighCardinality, "The number of documents of the source collection that were received from DCP but have yet to be replicated or handled"},
+	DOCS_LATENCY_METRIC:          StatsProperty{StatsUnit{MetricTypeGauge, StatsMgrMilliSecond}, HighCardinality, "The average amount of time, over the last publish interval, it takes for the source cluster to receive the acknowledgement of a write from the source collection to the target collection"},
+}
@@ -117,6 +117,10 @@ func (b *BucketTopologyService) loadFromReplSpecSvc(replSpecSvc service_def.Repl
 		}()
 		go func() {
 			defer waitGrp.Done()
+			if specCpy.IsFileExport() {
+				// there is no target bucket to watch
+				return
+			}
 
 			retryOp := func() error {
 				watcher, startErr := b.getOrCreateRemoteWatcher(specCpy)
@@ -569,6 +573,11 @@ func (b *BucketTopologyService) handleSpecDeletion(spec *metadata.ReplicationSpe
 	}
 	b.srcBucketWatchersMtx.Unlock()
 
+	if spec.IsFileExport() {
+		// no target watcher was ever created
+		return err
+	}
+
 	b.tgtBucketWatchersMtx.Lock()
 	b.tgtBucketWatchersCnt[getTargetWatcherKey(spec)]--
 	if b.tgtBucketWatchersCnt[getTargetWatcherKey(spec)] == 0 {
@@ -640,6 +649,10 @@ func (b *BucketTopologyService) ReplicationSpecChangeCallback(id string, oldVal,
 
 		go func() {
 			defer waitGrp.Done()
+			if newSpec.IsFileExport() {
+				// there is no target bucket to watch
+				return
+			}
 			retryRemoteOp := func() error {
 				remoteWatcher, remoteErr := b.getOrCreateRemoteWatcher(newSpec)
 				if remoteErr != nil {
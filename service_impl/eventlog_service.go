@@ -31,6 +31,8 @@ const (
 	DeleteReplicationEventDesc          = "Delete replication"
 	UpdateReplicationSettingDesc        = "Update replication setting"
 	UpdateDefaultReplicationSettingDesc = "Update default replication setting"
+	ReplicationCaughtUpEventDesc        = "Replication caught up"
+	BulkDeletesDetectedEventDesc        = "Replication paused on bulk deletion"
 )
 
 type EventSeverityType string
@@ -80,9 +82,13 @@ func NewEventLog(topSvc service_def.XDCRCompTopologySvc, utils utils.UtilsIface,
 		service_def.DeleteReplicationSystemEventId:               DeleteReplicationEventDesc,
 		service_def.UpdateReplicationSettingSystemEventId:        UpdateReplicationSettingDesc,
 		service_def.UpdateDefaultReplicationSettingSystemEventId: UpdateDefaultReplicationSettingDesc,
+		service_def.ReplicationCaughtUpSystemEventId:             ReplicationCaughtUpEventDesc,
+		service_def.BulkDeletesDetectedSystemEventId:             BulkDeletesDetectedEventDesc,
 	}
 	// Default severity: info
-	eventLog.idSeverittyMap = map[service_def.EventIdType]EventSeverityType{}
+	eventLog.idSeverittyMap = map[service_def.EventIdType]EventSeverityType{
+		service_def.BulkDeletesDetectedSystemEventId: EventSeverityWarn,
+	}
 	return &eventLog
 }
 
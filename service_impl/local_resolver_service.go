@@ -0,0 +1,249 @@
+// Copyright 2026-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included in
+// the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+// file, in accordance with the Business Source License, use of this software
+// will be governed by the Apache License, Version 2.0, included in the file
+// licenses/APL2.txt.
+
+package service_impl
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/couchbase/goxdcr/base"
+	"github.com/couchbase/goxdcr/log"
+	"github.com/couchbase/goxdcr/service_def"
+)
+
+// LocalResolverSvc resolves custom CR conflicts in process, using the declarative merge functions of the merge engine
+// instead of the javascript evaluator. It takes the same ConflictParams and returns the merged document the same way.
+// The merge functions are managed through the XDCR REST API and stored in metakv. Every node keeps a copy of them,
+// which the metakv change listener keeps up to date
+type LocalResolverSvc struct {
+	logger      *log.CommonLogger
+	InputCh     chan *base.ConflictParams
+	metadataSvc service_def.MetadataSvc
+
+	functions     map[string]*MergeFunctionSpec
+	functionsLock sync.RWMutex
+
+	// bytes that the source, target and merged documents of one merge can take in total
+	memoryCap int
+	started   bool
+}
+
+func NewLocalResolverSvc(metadataSvc service_def.MetadataSvc) *LocalResolverSvc {
+	return &LocalResolverSvc{
+		logger:      log.NewLogger("LocalResolverSvc", nil),
+		metadataSvc: metadataSvc,
+		functions:   make(map[string]*MergeFunctionSpec),
+		memoryCap:   base.JSWorkerQuota,
+	}
+}
+
+func getMergeFunctionKey(fname string) string {
+	return base.MergeFunctionsCatalogKey + base.KeyPartsDelimiter + fname
+}
+
+func (rs *LocalResolverSvc) ResolveAsync(aConflict *base.ConflictParams, finish_ch chan bool) {
+	select {
+	case rs.InputCh <- aConflict:
+	case <-finish_ch:
+	}
+}
+
+// The default merge function is built in. It is not stored in metakv and cannot be changed or deleted
+func (rs *LocalResolverSvc) InitDefaultFunc() {
+	err := rs.cacheMergeFunction(base.DefaultMergeFunc, []byte(fmt.Sprintf(`{"default":"%v"}`, MergeRuleLww)))
+	if err != nil {
+		rs.logger.Errorf("Create %v received error %v", base.DefaultMergeFunc, err)
+		return
+	}
+	rs.logger.Infof("Created %v function", base.DefaultMergeFunc)
+}
+
+func (rs *LocalResolverSvc) CheckMergeFunction(fname string) error {
+	rs.functionsLock.RLock()
+	defer rs.functionsLock.RUnlock()
+	if _, ok := rs.functions[fname]; !ok {
+		return fmt.Errorf("Merge function %v does not exist", fname)
+	}
+	return nil
+}
+
+func (rs *LocalResolverSvc) AllMergeFunctions() map[string]json.RawMessage {
+	rs.functionsLock.RLock()
+	defer rs.functionsLock.RUnlock()
+	functions := make(map[string]json.RawMessage)
+	for fname, spec := range rs.functions {
+		specBytes, err := json.Marshal(spec)
+		if err != nil {
+			rs.logger.Warnf("Unable to marshal merge function %v. err=%v", fname, err)
+			continue
+		}
+		functions[fname] = specBytes
+	}
+	return functions
+}
+
+func (rs *LocalResolverSvc) GetMergeFunction(fname string) (json.RawMessage, error) {
+	spec, err := rs.getMergeFunction(fname)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(spec)
+}
+
+// Adds or replaces a merge function. specBytes is a json encoded MergeFunctionSpec
+func (rs *LocalResolverSvc) SetMergeFunction(fname string, specBytes []byte) error {
+	err := validateMergeFunctionName(fname)
+	if err != nil {
+		return err
+	}
+	_, err = NewMergeFunctionSpec(specBytes)
+	if err != nil {
+		return err
+	}
+	err = rs.metadataSvc.Set(getMergeFunctionKey(fname), specBytes, nil /*rev*/)
+	if err != nil {
+		return err
+	}
+	// the metakv change listener caches it as well, but the caller expects it to be usable right away
+	return rs.cacheMergeFunction(fname, specBytes)
+}
+
+func (rs *LocalResolverSvc) DelMergeFunction(fname string) error {
+	if fname == base.DefaultMergeFunc {
+		return fmt.Errorf("Merge function %v is built in and cannot be deleted", fname)
+	}
+	err := rs.CheckMergeFunction(fname)
+	if err != nil {
+		return err
+	}
+	err = rs.metadataSvc.Del(getMergeFunctionKey(fname), nil /*rev*/)
+	if err != nil && err != service_def.MetadataNotFoundErr {
+		return err
+	}
+	rs.uncacheMergeFunction(fname)
+	return nil
+}
+
+// Implement callback function for metakv
+func (rs *LocalResolverSvc) MergeFunctionsServiceCallback(path string, value []byte, rev interface{}) error {
+	rs.logger.Infof("MergeFunctionsServiceCallback called on path = %v", path)
+	fname := strings.TrimPrefix(path, base.KeyPartsDelimiter+base.MergeFunctionsCatalogKey+base.KeyPartsDelimiter)
+	if len(value) == 0 {
+		rs.uncacheMergeFunction(fname)
+		return nil
+	}
+	return rs.cacheMergeFunction(fname, value)
+}
+
+func validateMergeFunctionName(fname string) error {
+	if fname == "" || strings.Contains(fname, base.UrlDelimiter) {
+		return fmt.Errorf("Invalid merge function name %v", fname)
+	}
+	if fname == base.DefaultMergeFunc {
+		return fmt.Errorf("Merge function %v is built in and cannot be changed", fname)
+	}
+	return nil
+}
+
+func (rs *LocalResolverSvc) cacheMergeFunction(fname string, specBytes []byte) error {
+	spec, err := NewMergeFunctionSpec(specBytes)
+	if err != nil {
+		return err
+	}
+	rs.functionsLock.Lock()
+	defer rs.functionsLock.Unlock()
+	rs.functions[fname] = spec
+	return nil
+}
+
+func (rs *LocalResolverSvc) uncacheMergeFunction(fname string) {
+	rs.functionsLock.Lock()
+	defer rs.functionsLock.Unlock()
+	delete(rs.functions, fname)
+}
+
+// Loads the merge functions that were stored before this node started
+func (rs *LocalResolverSvc) loadMergeFunctions() error {
+	entries, err := rs.metadataSvc.GetAllMetadataFromCatalog(base.MergeFunctionsCatalogKey)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		fname := strings.TrimPrefix(entry.Key, base.MergeFunctionsCatalogKey+base.KeyPartsDelimiter)
+		err = rs.cacheMergeFunction(fname, entry.Value)
+		if err != nil {
+			rs.logger.Errorf("Skipping invalid merge function %v. err=%v", fname, err)
+		}
+	}
+	return nil
+}
+
+func (rs *LocalResolverSvc) getMergeFunction(fname string) (*MergeFunctionSpec, error) {
+	rs.functionsLock.RLock()
+	defer rs.functionsLock.RUnlock()
+	spec, ok := rs.functions[fname]
+	if !ok {
+		return nil, fmt.Errorf("Merge function %v does not exist", fname)
+	}
+	return spec, nil
+}
+
+func (rs *LocalResolverSvc) Start(sourceKVHost string, xdcrRestPort uint16) {
+	err := rs.loadMergeFunctions()
+	if err != nil {
+		// replications that use the merge functions fail conflict resolution until the functions are set again
+		rs.logger.Errorf("Unable to load merge functions. err=%v", err)
+	}
+	rs.startWorkers()
+	rs.logger.Infof("LocalResolverSvc for custom CR is started with %v workers at memory cap %v.", base.JSEngineWorkers, rs.memoryCap)
+}
+
+func (rs *LocalResolverSvc) startWorkers() {
+	rs.InputCh = make(chan *base.ConflictParams, base.JSEngineWorkers)
+	for i := 0; i < base.JSEngineWorkers; i++ {
+		go rs.resolverWorker()
+	}
+	rs.started = true
+}
+
+func (rs *LocalResolverSvc) Started() bool {
+	return rs.started
+}
+
+func (rs *LocalResolverSvc) resolverWorker() {
+	for {
+		input := <-rs.InputCh
+		res, err := rs.resolveOne(input)
+		if err != nil {
+			input.ResultNotifier.NotifyMergeResult(input, nil, err)
+		} else {
+			input.ResultNotifier.NotifyMergeResult(input, res, nil)
+		}
+	}
+}
+
+func (rs *LocalResolverSvc) resolveOne(input *base.ConflictParams) (string, error) {
+	spec, err := rs.getMergeFunction(input.MergeFunction)
+	if err != nil {
+		return "", err
+	}
+	sourceBody := base.FindSourceBodyWithoutXattr(input.Source.Req)
+	targetBody, err := input.Target.FindTargetBodyWithoutXattr()
+	if err != nil {
+		return "", err
+	}
+	timeout := input.Timeout
+	if timeout <= 0 {
+		timeout = base.JSFunctionTimeoutDefault
+	}
+	return spec.Merge(sourceBody, input.Source.Req.Cas, targetBody, input.Target.Resp.Cas, time.Duration(timeout)*time.Millisecond, rs.memoryCap)
+}
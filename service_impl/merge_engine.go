@@ -0,0 +1,315 @@
+// Copyright 2026-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included in
+// the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+// file, in accordance with the Business Source License, use of this software
+// will be governed by the Apache License, Version 2.0, included in the file
+// licenses/APL2.txt.
+
+package service_impl
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Merge rules of the in-process merge engine
+const (
+	// Take the value of the source document
+	MergeRuleSource = "source"
+	// Take the value of the target document
+	MergeRuleTarget = "target"
+	// Take the value of the document with the higher CAS. Source wins a tie
+	MergeRuleLww = "lww"
+	// Take the higher number or string. Values that cannot be compared fall back to lww
+	MergeRuleMax = "max"
+	// Take the lower number or string. Values that cannot be compared fall back to lww
+	MergeRuleMin = "min"
+	// Objects are merged key by key and arrays are concatenated without duplicates, source elements first.
+	// Anything else falls back to lww
+	MergeRuleUnion = "union"
+)
+
+var validMergeRules = map[string]bool{
+	MergeRuleSource: true,
+	MergeRuleTarget: true,
+	MergeRuleLww:    true,
+	MergeRuleMax:    true,
+	MergeRuleMin:    true,
+	MergeRuleUnion:  true,
+}
+
+const mergeFieldPathDelimiter = "."
+
+var ErrorMergeTimedOut = errors.New("merge function timed out")
+var ErrorMergeMemoryCapExceeded = errors.New("merge function exceeded its memory cap")
+
+// MergeFunctionSpec is a merge function of the in-process merge engine. It is a declarative description of how
+// the fields of two conflicting JSON documents are merged, e.g.
+// {"default": "lww", "fields": {"tags": "union", "stats.views": "max"}}
+// A field uses the rule of its closest ancestor that has one, and the document uses the default rule.
+// Objects are merged key by key whenever a field under them has a rule of its own
+type MergeFunctionSpec struct {
+	Default string            `json:"default"`
+	Fields  map[string]string `json:"fields,omitempty"`
+
+	// field path -> whether some field under it has a rule
+	hasRulesBelow map[string]bool
+}
+
+func NewMergeFunctionSpec(specBytes []byte) (*MergeFunctionSpec, error) {
+	spec := &MergeFunctionSpec{}
+	decoder := json.NewDecoder(bytes.NewReader(specBytes))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(spec)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid merge function. err=%v", err)
+	}
+	err = spec.init()
+	if err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+func (spec *MergeFunctionSpec) init() error {
+	if spec.Default == "" {
+		spec.Default = MergeRuleLww
+	}
+	if !validMergeRules[spec.Default] {
+		return fmt.Errorf("Invalid default merge rule %v", spec.Default)
+	}
+	spec.hasRulesBelow = make(map[string]bool)
+	for path, rule := range spec.Fields {
+		if !validMergeRules[rule] {
+			return fmt.Errorf("Invalid merge rule %v for field %v", rule, path)
+		}
+		fields := strings.Split(path, mergeFieldPathDelimiter)
+		for i, field := range fields {
+			if field == "" {
+				return fmt.Errorf("Invalid field path %v", path)
+			}
+			// the document itself is the empty path
+			spec.hasRulesBelow[strings.Join(fields[:i], mergeFieldPathDelimiter)] = true
+		}
+	}
+	return nil
+}
+
+// The limits of a single merge
+type mergeBudget struct {
+	deadline time.Time
+	// bytes that the input documents and the merged document can take in total
+	memoryCap int
+	// values visited since the deadline was last checked
+	visited int
+}
+
+func (budget *mergeBudget) visit() error {
+	budget.visited++
+	if budget.visited%64 == 0 && time.Now().After(budget.deadline) {
+		return ErrorMergeTimedOut
+	}
+	return nil
+}
+
+type mergeDoc struct {
+	value interface{}
+	cas   uint64
+}
+
+// Merges two JSON documents. The sizes of the documents and of the merged document count against memoryCap
+func (spec *MergeFunctionSpec) Merge(sourceDoc []byte, sourceCas uint64, targetDoc []byte, targetCas uint64, timeout time.Duration, memoryCap int) (string, error) {
+	budget := &mergeBudget{deadline: time.Now().Add(timeout), memoryCap: memoryCap - len(sourceDoc) - len(targetDoc)}
+	if budget.memoryCap < 0 {
+		return "", ErrorMergeMemoryCapExceeded
+	}
+
+	source, err := decodeMergeDoc(sourceDoc, sourceCas)
+	if err != nil {
+		return "", fmt.Errorf("Unable to parse source document. err=%v", err)
+	}
+	target, err := decodeMergeDoc(targetDoc, targetCas)
+	if err != nil {
+		return "", fmt.Errorf("Unable to parse target document. err=%v", err)
+	}
+
+	merged, exists, err := spec.mergeValue("", spec.Default, source, target, true, true, budget)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", fmt.Errorf("Merged document is empty")
+	}
+	mergedBytes, err := json.Marshal(merged)
+	if err != nil {
+		return "", err
+	}
+	if len(mergedBytes) > budget.memoryCap {
+		return "", ErrorMergeMemoryCapExceeded
+	}
+	return string(mergedBytes), nil
+}
+
+func decodeMergeDoc(doc []byte, cas uint64) (*mergeDoc, error) {
+	decoder := json.NewDecoder(bytes.NewReader(doc))
+	decoder.UseNumber()
+	var value interface{}
+	err := decoder.Decode(&value)
+	if err != nil {
+		return nil, err
+	}
+	return &mergeDoc{value: value, cas: cas}, nil
+}
+
+// Returns the merged value at path, and whether it exists
+func (spec *MergeFunctionSpec) mergeValue(path, rule string, source, target *mergeDoc, sourceExists, targetExists bool, budget *mergeBudget) (interface{}, bool, error) {
+	if err := budget.visit(); err != nil {
+		return nil, false, err
+	}
+	if fieldRule, ok := spec.Fields[path]; ok && path != "" {
+		rule = fieldRule
+	}
+
+	sourceObj, sourceIsObj := source.value.(map[string]interface{})
+	targetObj, targetIsObj := target.value.(map[string]interface{})
+	if sourceExists && targetExists && sourceIsObj && targetIsObj && (rule == MergeRuleUnion || spec.hasRulesBelow[path]) {
+		return spec.mergeObjects(path, rule, sourceObj, source.cas, targetObj, target.cas, budget)
+	}
+
+	switch rule {
+	case MergeRuleSource:
+		return source.value, sourceExists, nil
+	case MergeRuleTarget:
+		return target.value, targetExists, nil
+	}
+	// the remaining rules take whichever side exists
+	if !sourceExists || !targetExists {
+		if rule == MergeRuleLww {
+			return mergeLww(source, target, sourceExists, targetExists)
+		}
+		if sourceExists {
+			return source.value, true, nil
+		}
+		return target.value, targetExists, nil
+	}
+
+	switch rule {
+	case MergeRuleMax, MergeRuleMin:
+		if result, comparable := compareMergeValues(source.value, target.value); comparable {
+			if (rule == MergeRuleMax) == (result >= 0) {
+				return source.value, true, nil
+			}
+			return target.value, true, nil
+		}
+	case MergeRuleUnion:
+		sourceArr, sourceIsArr := source.value.([]interface{})
+		targetArr, targetIsArr := target.value.([]interface{})
+		if sourceIsArr && targetIsArr {
+			return mergeArrays(sourceArr, targetArr, budget)
+		}
+	}
+	return mergeLww(source, target, sourceExists, targetExists)
+}
+
+func (spec *MergeFunctionSpec) mergeObjects(path, rule string, sourceObj map[string]interface{}, sourceCas uint64, targetObj map[string]interface{}, targetCas uint64, budget *mergeBudget) (interface{}, bool, error) {
+	merged := make(map[string]interface{}, len(sourceObj))
+	mergeKey := func(key string) error {
+		if _, done := merged[key]; done {
+			return nil
+		}
+		sourceVal, sourceExists := sourceObj[key]
+		targetVal, targetExists := targetObj[key]
+		childPath := key
+		if path != "" {
+			childPath = path + mergeFieldPathDelimiter + key
+		}
+		value, exists, err := spec.mergeValue(childPath, rule, &mergeDoc{sourceVal, sourceCas}, &mergeDoc{targetVal, targetCas}, sourceExists, targetExists, budget)
+		if err != nil {
+			return err
+		}
+		if exists {
+			merged[key] = value
+		}
+		return nil
+	}
+	for key, _ := range sourceObj {
+		if err := mergeKey(key); err != nil {
+			return nil, false, err
+		}
+	}
+	for key, _ := range targetObj {
+		if err := mergeKey(key); err != nil {
+			return nil, false, err
+		}
+	}
+	return merged, true, nil
+}
+
+func mergeLww(source, target *mergeDoc, sourceExists, targetExists bool) (interface{}, bool, error) {
+	if source.cas >= target.cas {
+		return source.value, sourceExists, nil
+	}
+	return target.value, targetExists, nil
+}
+
+func mergeArrays(sourceArr, targetArr []interface{}, budget *mergeBudget) (interface{}, bool, error) {
+	merged := make([]interface{}, 0, len(sourceArr)+len(targetArr))
+	seen := make(map[string]bool, len(sourceArr)+len(targetArr))
+	for _, arr := range [][]interface{}{sourceArr, targetArr} {
+		for _, elem := range arr {
+			if err := budget.visit(); err != nil {
+				return nil, false, err
+			}
+			elemBytes, err := json.Marshal(elem)
+			if err != nil {
+				return nil, false, err
+			}
+			if seen[string(elemBytes)] {
+				continue
+			}
+			budget.memoryCap -= len(elemBytes)
+			if budget.memoryCap < 0 {
+				return nil, false, ErrorMergeMemoryCapExceeded
+			}
+			seen[string(elemBytes)] = true
+			merged = append(merged, elem)
+		}
+	}
+	return merged, true, nil
+}
+
+// Returns 1 if a is higher, -1 if b is higher and 0 if they are equal. comparable is false unless both are numbers
+// or both are strings
+func compareMergeValues(a, b interface{}) (result int, comparable bool) {
+	switch aVal := a.(type) {
+	case json.Number:
+		bVal, ok := b.(json.Number)
+		if !ok {
+			return 0, false
+		}
+		aFloat, aErr := aVal.Float64()
+		bFloat, bErr := bVal.Float64()
+		if aErr != nil || bErr != nil {
+			return 0, false
+		}
+		if aFloat < bFloat {
+			return -1, true
+		} else if aFloat > bFloat {
+			return 1, true
+		}
+		return 0, true
+	case string:
+		bVal, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(aVal, bVal), true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,179 @@
+/*
+Copyright 2026-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software
+will be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package service_impl
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/couchbase/gomemcached"
+	"github.com/couchbase/goxdcr/base"
+	service_def_real "github.com/couchbase/goxdcr/service_def"
+	service_def "github.com/couchbase/goxdcr/service_def/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func mergeForTest(assert *assert.Assertions, spec, source string, sourceCas uint64, target string, targetCas uint64) map[string]interface{} {
+	mergeSpec, err := NewMergeFunctionSpec([]byte(spec))
+	assert.Nil(err)
+	merged, err := mergeSpec.Merge([]byte(source), sourceCas, []byte(target), targetCas, time.Second, base.JSWorkerQuota)
+	assert.Nil(err)
+	result := make(map[string]interface{})
+	assert.Nil(json.Unmarshal([]byte(merged), &result))
+	return result
+}
+
+func TestMergeFunctionSpecRules(t *testing.T) {
+	fmt.Println("============== Test case start: TestMergeFunctionSpecRules =================")
+	defer fmt.Println("============== Test case end: TestMergeFunctionSpecRules =================")
+	assert := assert.New(t)
+
+	source := `{"name":"src","count":3,"tags":["a","b"],"stats":{"views":10,"likes":1},"onlySource":true}`
+	target := `{"name":"tgt","count":5,"tags":["b","c"],"stats":{"views":7,"likes":4},"onlyTarget":true}`
+
+	// the whole document follows lww by default
+	result := mergeForTest(assert, `{}`, source, 100, target, 200)
+	assert.Equal("tgt", result["name"])
+	assert.Nil(result["onlySource"])
+
+	spec := `{"default":"lww","fields":{"count":"max","tags":"union","stats.views":"max","stats.likes":"min","name":"source"}}`
+	result = mergeForTest(assert, spec, source, 100, target, 200)
+	assert.Equal("src", result["name"])
+	assert.Equal(float64(5), result["count"])
+	assert.Equal([]interface{}{"a", "b", "c"}, result["tags"])
+	assert.Equal(map[string]interface{}{"views": float64(10), "likes": float64(1)}, result["stats"])
+	// fields that only exist on one side follow lww once the objects are merged key by key
+	assert.Nil(result["onlySource"])
+	assert.Equal(true, result["onlyTarget"])
+
+	// union merges objects key by key and keeps fields from both sides
+	result = mergeForTest(assert, `{"default":"union"}`, source, 300, target, 200)
+	assert.Equal("src", result["name"])
+	assert.Equal(true, result["onlySource"])
+	assert.Equal(true, result["onlyTarget"])
+
+	// values that cannot be compared fall back to lww
+	result = mergeForTest(assert, `{"fields":{"name":"max"}}`, `{"name":1}`, 300, `{"name":"tgt"}`, 200)
+	assert.Equal(float64(1), result["name"])
+}
+
+func TestMergeFunctionSpecErrors(t *testing.T) {
+	fmt.Println("============== Test case start: TestMergeFunctionSpecErrors =================")
+	defer fmt.Println("============== Test case end: TestMergeFunctionSpecErrors =================")
+	assert := assert.New(t)
+
+	_, err := NewMergeFunctionSpec([]byte(`{"default":"newest"}`))
+	assert.NotNil(err)
+	_, err = NewMergeFunctionSpec([]byte(`{"fields":{"a..b":"max"}}`))
+	assert.NotNil(err)
+	_, err = NewMergeFunctionSpec([]byte(`{"rules":{}}`))
+	assert.NotNil(err)
+
+	spec, err := NewMergeFunctionSpec([]byte(`{"default":"union"}`))
+	assert.Nil(err)
+	_, err = spec.Merge([]byte(`{"a":`), 1, []byte(`{}`), 2, time.Second, base.JSWorkerQuota)
+	assert.NotNil(err)
+
+	// the merged document does not fit under the memory cap
+	_, err = spec.Merge([]byte(`{"a":["xxxxxxxx"]}`), 1, []byte(`{"a":["yyyyyyyy"]}`), 2, time.Second, 50)
+	assert.Equal(ErrorMergeMemoryCapExceeded, err)
+	_, err = spec.Merge([]byte(`{"a":1}`), 1, []byte(`{"a":2}`), 2, time.Second, 10)
+	assert.Equal(ErrorMergeMemoryCapExceeded, err)
+
+	large := make([]int, 1000)
+	largeBytes, _ := json.Marshal(map[string]interface{}{"a": large})
+	_, err = spec.Merge(largeBytes, 1, largeBytes, 2, 0, base.JSWorkerQuota)
+	assert.Equal(ErrorMergeTimedOut, err)
+}
+
+type mergeResultCollector struct {
+	results chan interface{}
+	errs    chan error
+}
+
+func (c *mergeResultCollector) NotifyMergeResult(input *base.ConflictParams, mergeResult interface{}, mergeError error) {
+	c.results <- mergeResult
+	c.errs <- mergeError
+}
+
+func conflictParamsForTest(source, target string, mergeFunction string, notifier base.MergeResultNotifier) *base.ConflictParams {
+	targetBody := make([]byte, 6+len(target))
+	binary.BigEndian.PutUint16(targetBody[0:2], uint16(gomemcached.SUCCESS))
+	binary.BigEndian.PutUint32(targetBody[2:6], uint32(len(target)))
+	copy(targetBody[6:], target)
+	return &base.ConflictParams{
+		Source: &base.WrappedMCRequest{Req: &gomemcached.MCRequest{Key: []byte("doc"), Body: []byte(source), Cas: 100}},
+		Target: &base.SubdocLookupResponse{
+			Specs: []base.SubdocLookupPathSpec{{Opcode: gomemcached.GET, Path: []byte("")}},
+			Resp:  &gomemcached.MCResponse{Body: targetBody, Cas: 200},
+		},
+		MergeFunction:  mergeFunction,
+		ResultNotifier: notifier,
+	}
+}
+
+func TestLocalResolverSvc(t *testing.T) {
+	fmt.Println("============== Test case start: TestLocalResolverSvc =================")
+	defer fmt.Println("============== Test case end: TestLocalResolverSvc =================")
+	assert := assert.New(t)
+
+	metadataSvc := &service_def.MetadataSvc{}
+	maxCountKey := base.MergeFunctionsCatalogKey + base.KeyPartsDelimiter + "maxCount"
+	maxCountSpec := []byte(`{"fields":{"count":"max"}}`)
+	metadataSvc.On("GetAllMetadataFromCatalog", base.MergeFunctionsCatalogKey).Return([]*service_def_real.MetadataEntry{
+		{Key: base.MergeFunctionsCatalogKey + base.KeyPartsDelimiter + "stored", Value: []byte(`{"default":"source"}`)},
+	}, nil)
+	metadataSvc.On("Set", maxCountKey, maxCountSpec, nil).Return(nil)
+	metadataSvc.On("Del", maxCountKey, nil).Return(nil)
+
+	resolverSvc := NewLocalResolverSvc(metadataSvc)
+	assert.NotNil(resolverSvc.CheckMergeFunction(base.DefaultMergeFunc))
+	resolverSvc.InitDefaultFunc()
+	assert.Nil(resolverSvc.CheckMergeFunction(base.DefaultMergeFunc))
+	assert.Nil(resolverSvc.SetMergeFunction("maxCount", maxCountSpec))
+	metadataSvc.AssertCalled(t, "Set", maxCountKey, maxCountSpec, nil)
+	assert.NotNil(resolverSvc.SetMergeFunction("bad/name", []byte(`{}`)))
+	assert.NotNil(resolverSvc.SetMergeFunction(base.DefaultMergeFunc, []byte(`{}`)))
+	assert.NotNil(resolverSvc.DelMergeFunction(base.DefaultMergeFunc))
+
+	// functions stored by other nodes are loaded on start and followed through metakv
+	assert.Nil(resolverSvc.loadMergeFunctions())
+	assert.Nil(resolverSvc.CheckMergeFunction("stored"))
+	assert.Nil(resolverSvc.MergeFunctionsServiceCallback("/"+base.MergeFunctionsCatalogKey+"/fromPeer", []byte(`{"default":"target"}`), nil))
+	assert.Nil(resolverSvc.CheckMergeFunction("fromPeer"))
+	assert.Nil(resolverSvc.MergeFunctionsServiceCallback("/"+base.MergeFunctionsCatalogKey+"/fromPeer", nil, nil))
+	assert.NotNil(resolverSvc.CheckMergeFunction("fromPeer"))
+	assert.Len(resolverSvc.AllMergeFunctions(), 3)
+
+	assert.False(resolverSvc.Started())
+	resolverSvc.startWorkers()
+	assert.True(resolverSvc.Started())
+
+	collector := &mergeResultCollector{results: make(chan interface{}, 1), errs: make(chan error, 1)}
+	finch := make(chan bool)
+
+	resolverSvc.ResolveAsync(conflictParamsForTest(`{"count":9}`, `{"count":1}`, base.DefaultMergeFunc, collector), finch)
+	assert.Equal(`{"count":1}`, <-collector.results)
+	assert.Nil(<-collector.errs)
+
+	resolverSvc.ResolveAsync(conflictParamsForTest(`{"count":9}`, `{"count":1}`, "maxCount", collector), finch)
+	assert.Equal(`{"count":9}`, <-collector.results)
+	assert.Nil(<-collector.errs)
+
+	assert.Nil(resolverSvc.DelMergeFunction("maxCount"))
+	metadataSvc.AssertCalled(t, "Del", maxCountKey, nil)
+	resolverSvc.ResolveAsync(conflictParamsForTest(`{"count":9}`, `{"count":1}`, "maxCount", collector), finch)
+	assert.Nil(<-collector.results)
+	assert.NotNil(<-collector.errs)
+}
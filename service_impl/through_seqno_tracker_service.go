@@ -793,6 +793,12 @@ func (tsTracker *ThroughSeqnoTrackerSvc) Attach(pipeline common.Pipeline) error
 		conflictMgr.(*pipeline_svc.ConflictManager).RegisterComponentEventListener(common.MergeFailed, tsTracker)
 	}
 
+	// dead lettered docs are not replicated, but the VBs should not be held up by them once they are recorded
+	deadLetterQueue := pipeline.RuntimeContext().Service(base.DEAD_LETTER_QUEUE_SVC)
+	if deadLetterQueue != nil {
+		deadLetterQueue.(*pipeline_svc.DeadLetterQueue).RegisterComponentEventListener(common.DataDeadLettered, tsTracker)
+	}
+
 	//register pipeline supervisor as through seqno service's error handler
 	supervisor := pipeline.RuntimeContext().Service(base.PIPELINE_SUPERVISOR_SVC)
 	if supervisor == nil {
@@ -861,7 +867,7 @@ func (tsTracker *ThroughSeqnoTrackerSvc) markMCRequestAsIgnored(req *base.Wrappe
 		return
 	}
 	seqno := req.Seqno
-	vbno := req.Req.VBucket
+	vbno := req.GetSourceVBucket()
 	tsTracker.addIgnoredSeqno(vbno, seqno)
 }
 
@@ -994,6 +1000,20 @@ func (tsTracker *ThroughSeqnoTrackerSvc) ProcessEvent(event *common.Event) error
 				tsTracker.HandleDoneSession(vbno, session)
 			}
 		}
+	case common.DataDeadLettered:
+		seqno := event.OtherInfos.(pipeline_svc.DataDeadLetteredEventAdditional).Seqno
+		vbno := event.OtherInfos.(pipeline_svc.DataDeadLetteredEventAdditional).VBucket
+		manifestId := event.OtherInfos.(pipeline_svc.DataDeadLetteredEventAdditional).ManifestId
+		shouldProcessAsOSO, session := tsTracker.shouldProcessAsOso(vbno, seqno)
+		if !shouldProcessAsOSO {
+			tsTracker.addFailedCRSeqno(vbno, seqno)
+			tsTracker.addManifestId(vbno, seqno, manifestId)
+		} else {
+			done := session.MarkSeqnoProcessed(vbno, seqno, manifestId, tsTracker)
+			if done {
+				tsTracker.HandleDoneSession(vbno, session)
+			}
+		}
 	case common.DataReceived:
 		upr_event := event.Data.(*mcc.UprEvent)
 		seqno := upr_event.Seqno
@@ -1022,7 +1042,7 @@ func (tsTracker *ThroughSeqnoTrackerSvc) ProcessEvent(event *common.Event) error
 		}
 	case common.DataNotReplicated:
 		wrappedMcr := event.Data.(*base.WrappedMCRequest)
-		vbno := wrappedMcr.Req.VBucket
+		vbno := wrappedMcr.GetSourceVBucket()
 		seqno := wrappedMcr.Seqno
 		recycler, ok := event.OtherInfos.(utilities.RecycleObjFunc)
 		processedAsOSO, session := tsTracker.shouldProcessAsOso(vbno, seqno)
@@ -1693,8 +1713,11 @@ func (tsTracker *ThroughSeqnoTrackerSvc) preProcessOutgoingClonedEvent(event *co
 		seqno = event.OtherInfos.(parts.DataSentEventAdditional).Seqno
 	case common.DataNotReplicated:
 		wrappedMcr := event.Data.(*base.WrappedMCRequest)
-		vbno = wrappedMcr.Req.VBucket
+		vbno = wrappedMcr.GetSourceVBucket()
 		seqno = wrappedMcr.Seqno
+	case common.DataDeadLettered:
+		vbno = event.OtherInfos.(pipeline_svc.DataDeadLetteredEventAdditional).VBucket
+		seqno = event.OtherInfos.(pipeline_svc.DataDeadLetteredEventAdditional).Seqno
 	default:
 		panic("Implement me")
 	}
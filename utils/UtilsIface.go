@@ -55,6 +55,7 @@ type UtilsIface interface {
 	 */
 	ComposeHELORequest(userAgent string, features HELOFeatures) *mc.MCRequest
 	FilterExpressionMatchesDoc(expression, docId, bucketName string, collectionNs *base.CollectionNamespace, addr string, port uint16) (result bool, err error)
+	FilterExpressionSampleDocs(expression, bucketName string, collectionNs *base.CollectionNamespace, sampleSize int, addr string, port uint16) (*base.FilterSampleResult, error)
 	GetMemcachedClient(serverAddr, bucketName string, kv_mem_clients map[string]mcc.ClientIface, userAgent string, keepAlivePeriod time.Duration, logger *log.CommonLogger, features HELOFeatures) (mcc.ClientIface, error)
 	GetMemcachedConnection(serverAddr, bucketName, userAgent string, keepAlivePeriod time.Duration, logger *log.CommonLogger) (mcc.ClientIface, error)
 	GetMemcachedConnectionWFeatures(serverAddr, bucketName, userAgent string, keepAlivePeriod time.Duration, features HELOFeatures, logger *log.CommonLogger) (mcc.ClientIface, HELOFeatures, error)
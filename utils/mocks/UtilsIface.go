@@ -448,6 +448,29 @@ func (_m *UtilsIface) FilterExpressionMatchesDoc(expression string, docId string
 	return r0, r1
 }
 
+// FilterExpressionSampleDocs provides a mock function with given fields: expression, bucketName, collectionNs, sampleSize, addr, port
+func (_m *UtilsIface) FilterExpressionSampleDocs(expression string, bucketName string, collectionNs *base.CollectionNamespace, sampleSize int, addr string, port uint16) (*base.FilterSampleResult, error) {
+	ret := _m.Called(expression, bucketName, collectionNs, sampleSize, addr, port)
+
+	var r0 *base.FilterSampleResult
+	if rf, ok := ret.Get(0).(func(string, string, *base.CollectionNamespace, int, string, uint16) *base.FilterSampleResult); ok {
+		r0 = rf(expression, bucketName, collectionNs, sampleSize, addr, port)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*base.FilterSampleResult)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, string, *base.CollectionNamespace, int, string, uint16) error); ok {
+		r1 = rf(expression, bucketName, collectionNs, sampleSize, addr, port)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetBucketInfo provides a mock function with given fields: hostAddr, bucketName, username, password, authMech, certificate, sanInCertificate, clientCertificate, clientKey, logger
 func (_m *UtilsIface) GetBucketInfo(hostAddr string, bucketName string, username string, password string, authMech base.HttpAuthMech, certificate []byte, sanInCertificate bool, clientCertificate []byte, clientKey []byte, logger *log.CommonLogger) (map[string]interface{}, error) {
 	ret := _m.Called(hostAddr, bucketName, username, password, authMech, certificate, sanInCertificate, clientCertificate, clientKey, logger)
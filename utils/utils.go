@@ -404,6 +404,141 @@ func (u *Utilities) processNsServerDocForFiltering(matcher gojsonsm.Matcher, nsS
 	return matched, err
 }
 
+// Called by UI to run a filter expression against a sample of documents of a source collection
+// The documents are listed via ns_server, re-assembled into the form in which DCP would deliver them,
+// and then run through the same FilterImpl that the DCP nozzle uses
+func (u *Utilities) FilterExpressionSampleDocs(expression, bucketName string, collectionNs *base.CollectionNamespace, sampleSize int, addr string, port uint16) (*base.FilterSampleResult, error) {
+	hostAddr := base.GetHostAddr(addr, port)
+	var statusCode int
+
+	err := base.ValidateAdvFilter(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	sampleFilter, err := filter.NewFilter("filterSampler", expression, u, true /*skipUncommittedTxn*/)
+	if err != nil {
+		return nil, err
+	}
+
+	if collectionNs == nil {
+		collectionNs = base.NewDefaultCollectionNamespace()
+	}
+
+	urlPath := fmt.Sprintf("%v?%v=%v&include_docs=true", u.composeNsServerDocListPath(bucketName, collectionNs), base.BucketDocListLimitKey, sampleSize)
+
+	docList := make(map[string]interface{})
+	retryOp := func() error {
+		err, statusCode = u.QueryRestApi(hostAddr, urlPath, false /*preservePathEncoding*/, base.MethodGet, "" /*contentType*/, nil, /*body*/
+			0 /*timeout*/, &docList, u.logger_utils)
+
+		if err != nil {
+			return err
+		} else if statusCode != http.StatusOK {
+			return fmt.Errorf("Err returned: %v along with http status code: %v", err, statusCode)
+		} else {
+			return nil
+		}
+	}
+
+	err = u.ExponentialBackoffExecutor("filterSamplerDocsRetriever", base.BucketInfoOpWaitTime, base.BucketInfoOpMaxRetry, base.BucketInfoOpRetryFactor, retryOp)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, ok := docList[base.BucketDocListRowsKey].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Unable to parse document list returned for bucket %v collection %v", bucketName, collectionNs.ToIndexString())
+	}
+
+	result := base.NewFilterSampleResult()
+	for _, rowRaw := range rows {
+		row, ok := rowRaw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		docId, _ := row[base.BucketDocListIdKey].(string)
+		nsServerDocContent, _ := row[base.BucketDocListDocKey].(map[string]interface{})
+
+		uprEvent, err := u.composeUprEventFromNsServerDoc(docId, nsServerDocContent)
+		if err != nil {
+			result.RecordUnableToFilter(docId, err.Error())
+			continue
+		}
+
+		wrappedUprEvent := &base.WrappedUprEvent{
+			UprEvent:     uprEvent,
+			ColNamespace: collectionNs,
+			ByteSliceGetter: func(size uint64) ([]byte, error) {
+				return make([]byte, int(size)), nil
+			},
+		}
+		matched, err, errDesc, _ := sampleFilter.FilterUprEvent(wrappedUprEvent)
+		if err != nil {
+			result.RecordUnableToFilter(docId, fmt.Sprintf("%v %v", err, errDesc))
+		} else if matched {
+			result.RecordMatched(docId)
+		} else {
+			result.RecordNotMatched()
+		}
+	}
+	return result, nil
+}
+
+// ns_server returns the document body as a string of pre-formatted json and the xattributes as a json object
+// Put them back together as a single value with an xattr section, like what DCP would have sent
+func (u *Utilities) composeUprEventFromNsServerDoc(docId string, nsServerDocContent map[string]interface{}) (*mcc.UprEvent, error) {
+	var body []byte
+	var dataType uint8
+	switch bodyVal := nsServerDocContent[base.BucketDocBodyKey].(type) {
+	case nil:
+	case string:
+		body = []byte(bodyVal)
+	default:
+		marshaledBody, err := json.Marshal(bodyVal)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to process document body: %v", err)
+		}
+		body = marshaledBody
+	}
+	if len(body) > 0 && json.Valid(body) {
+		dataType |= base.JSONDataType
+	}
+
+	xattrs, _ := nsServerDocContent[base.BucketDocXattrKey].(map[string]interface{})
+	xattrKeys := make([][]byte, 0, len(xattrs))
+	xattrValues := make([][]byte, 0, len(xattrs))
+	valueSize := 4 + len(body)
+	for key, val := range xattrs {
+		marshaledVal, err := json.Marshal(val)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to process document xattribute %v: %v", key, err)
+		}
+		xattrKeys = append(xattrKeys, []byte(key))
+		xattrValues = append(xattrValues, marshaledVal)
+		valueSize += 4 + len(key) + 1 + len(marshaledVal) + 1
+	}
+
+	value := body
+	if len(xattrKeys) > 0 {
+		composer := base.NewXattrComposer(make([]byte, valueSize))
+		for i := range xattrKeys {
+			if err := composer.WriteKV(xattrKeys[i], xattrValues[i]); err != nil {
+				return nil, err
+			}
+		}
+		value = composer.FinishAndAppendDocValue(body)
+		dataType |= base.XattrDataType
+	}
+
+	return &mcc.UprEvent{
+		Opcode:   mc.UPR_MUTATION,
+		Key:      []byte(docId),
+		Value:    value,
+		DataType: dataType,
+	}, nil
+}
+
 // given a matches map, convert the indices from byte index to rune index
 func (u *Utilities) convertByteIndexToRuneIndex(key string, matches [][]int) ([][]int, error) {
 	convertedMatches := make([][]int, 0)
@@ -2900,6 +3035,17 @@ func (u *Utilities) composeNsServerDocGetPath(bucketName string, ns *base.Collec
 	return path
 }
 
+func (u *Utilities) composeNsServerDocListPath(bucketName string, ns *base.CollectionNamespace) string {
+	var path = base.DefaultPoolBucketsPath + bucketName
+	var docsPath = strings.TrimSuffix(base.DocsPath, base.UrlDelimiter)
+	if ns.IsDefault() {
+		path += docsPath
+	} else {
+		path += base.ScopesPath + ns.ScopeName + base.CollectionsPath + ns.CollectionName + docsPath
+	}
+	return path
+}
+
 func (u *Utilities) StartDiagStopwatch(id string, threshold time.Duration) func() {
 	startTime := time.Now()
 	return func() {
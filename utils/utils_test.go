@@ -15,6 +15,7 @@ import (
 	"encoding/json"
 	"fmt"
 	base "github.com/couchbase/goxdcr/base"
+	"github.com/couchbase/goxdcr/base/filter"
 	"github.com/couchbase/goxdcr/log"
 	"github.com/stretchr/testify/assert"
 	"io/ioutil"
@@ -716,6 +717,48 @@ func TestProcessNsServerNilDoc(t *testing.T) {
 	fmt.Println("============== Test case end: TestProcessNsServerDocNeg =================")
 }
 
+// The same document as TestProcessNsServerDoc, but re-assembled into an UprEvent and run through FilterImpl
+func TestComposeUprEventFromNsServerDoc(t *testing.T) {
+	fmt.Println("============== Test case start: TestComposeUprEventFromNsServerDoc =================")
+	var docKey string = "TestDocKey"
+	var testExpression string = fmt.Sprintf("META().xattrs.AnotherXattr = \"TestValueString\" AND META().xattrs.TestXattr = 30 AND META().id = \"%v\" AND REGEXP_CONTAINS(Key, \"^AA\")", docKey)
+	assert := assert.New(t)
+	fileName := "./testFilteringData/UIFilteringSampleDoc.json"
+	data, err := ioutil.ReadFile(fileName)
+	assert.Nil(err)
+
+	docKVs := make(map[string]interface{})
+	err = json.Unmarshal(data, &docKVs)
+	assert.Nil(err)
+
+	uprEvent, err := testUtils.composeUprEventFromNsServerDoc(docKey, docKVs)
+	assert.Nil(err)
+	assert.NotNil(uprEvent)
+	assert.True(uprEvent.DataType&base.XattrDataType > 0)
+	assert.True(uprEvent.DataType&base.JSONDataType > 0)
+
+	wrappedUprEvent := &base.WrappedUprEvent{
+		UprEvent: uprEvent,
+		ByteSliceGetter: func(size uint64) ([]byte, error) {
+			return make([]byte, int(size)), nil
+		},
+	}
+
+	sampleFilter, err := filter.NewFilter("test", testExpression, testUtils, true)
+	assert.Nil(err)
+	matched, err, _, _ := sampleFilter.FilterUprEvent(wrappedUprEvent)
+	assert.Nil(err)
+	assert.True(matched)
+
+	negFilter, err := filter.NewFilter("testNeg", "META().xattrs.TestXattr = 31", testUtils, true)
+	assert.Nil(err)
+	matched, err, _, _ = negFilter.FilterUprEvent(wrappedUprEvent)
+	assert.Nil(err)
+	assert.False(matched)
+
+	fmt.Println("============== Test case end: TestComposeUprEventFromNsServerDoc =================")
+}
+
 func TestGetServerVBucketsMapAfterReplacingRefNode(t *testing.T) {
 	fmt.Println("============== Test case start: TestGetServerVBucketsMapAfterReplacingRefNode =================")
 	assert := assert.New(t)